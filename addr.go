@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// --- Address Handling ---
+//
+// Addresses are always split and joined with net.SplitHostPort / net.JoinHostPort so that
+// IPv6 literals work: they must be bracketed when a port follows, e.g. "[::1]:8443".
+
+// validateAddr checks that addr is a host:port listen address, with a hint for unbracketed IPv6 literals.
+func validateAddr(addr string) error {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if i := strings.LastIndex(addr, ":"); i > 0 && net.ParseIP(addr[:i]) != nil && strings.Contains(addr[:i], ":") {
+			return fmt.Errorf("invalid address %q: IPv6 addresses must be bracketed, e.g. %s", addr, net.JoinHostPort(addr[:i], addr[i+1:]))
+		}
+		return fmt.Errorf("invalid address %q: %w", addr, err)
+	}
+	return nil
+}
+
+// hostOf returns the host part of a host:port address (without IPv6 brackets), or the address itself if it has no port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.Trim(addr, "[]")
+	}
+	return host
+}
+
+// sameHost compares two hosts, treating different spellings of the same IP (e.g. "::1" and "0:0::1",
+// or an IPv4-mapped IPv6 address and its IPv4 form) as equal.
+func sameHost(a, b string) bool {
+	if ipA, ipB := net.ParseIP(a), net.ParseIP(b); ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return strings.EqualFold(a, b)
+}
+
+// dialAddr turns a listen address into one a local client can connect to:
+// an empty or unspecified host (":8443", "0.0.0.0:8443", "[::]:8443") becomes localhost.
+func dialAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateAddr(t *testing.T) {
+	for _, addr := range []string{"localhost:8443", ":8443", "127.0.0.1:8443", "[::1]:8443", "[::]:0"} {
+		if err := validateAddr(addr); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", addr, err)
+		}
+	}
+	err := validateAddr("::1:8443")
+	if err == nil || !strings.Contains(err.Error(), "[::1]:8443") {
+		t.Errorf("Expected a bracketing hint for an unbracketed IPv6 address, got %v", err)
+	}
+	if err := validateAddr("localhost"); err == nil {
+		t.Error("Expected an address without a port to be rejected")
+	}
+}
+
+func TestHostHelpers(t *testing.T) {
+	cases := map[string]string{"[::1]:8443": "::1", "127.0.0.1:1": "127.0.0.1", "[fe80::1]": "fe80::1", "example.com": "example.com"}
+	for addr, want := range cases {
+		if got := hostOf(addr); got != want {
+			t.Errorf("hostOf(%q) = %q, want %q", addr, got, want)
+		}
+	}
+	if !sameHost("::1", "0:0:0:0:0:0:0:1") || !sameHost("::ffff:127.0.0.1", "127.0.0.1") || sameHost("::1", "127.0.0.1") {
+		t.Error("sameHost compared IP spellings incorrectly")
+	}
+	dialCases := map[string]string{":8443": "localhost:8443", "[::]:8443": "localhost:8443", "0.0.0.0:1": "localhost:1", "[::1]:8443": "[::1]:8443"}
+	for addr, want := range dialCases {
+		if got := dialAddr(addr); got != want {
+			t.Errorf("dialAddr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
+
+func TestServerOverIPv6Loopback(t *testing.T) {
+	if l, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	} else {
+		l.Close()
+	}
+	pki := newTestPKI(t)
+	addr := freeAddrOn(t, "::1")
+	diagAddr := freeAddrOn(t, "::1")
+	server := NewServer(addr, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.DiagAddr = diagAddr
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	if !strings.HasPrefix(addr, "[::1]:") {
+		t.Fatalf("Expected a bracketed IPv6 address, got %s", addr)
+	}
+
+	client, err := NewClient("https://"+addr+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected a successful request over [::1], got %d (%v)", status, err)
+	}
+
+	// Rejections are attributed to the IPv6 caller.
+	unknownCert := filepath.Join(pki.Dir, "unknown.crt")
+	unknownKey := filepath.Join(pki.Dir, "unknown.key")
+	pki.newClientCert(t, "intruder", unknownCert, unknownKey)
+	intruder, err := NewClient("https://"+addr+"/hello", pki.ServerCertFile, unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := intruder.SendRequest(); err == nil {
+		t.Fatal("Expected unknown client to be rejected")
+	}
+	latest := latestRejection(t, server.rejections)
+	if latest.CN != "intruder" || !sameHost(hostOf(latest.RemoteAddr), "::1") {
+		t.Errorf("Expected the rejection to be recorded for ::1, got %+v", latest)
+	}
+	resp, err := http.Get("http://" + diagAddr + "/diag/rejections?token=" + latest.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected diagnostics over [::1], got %d", resp.StatusCode)
+	}
+}
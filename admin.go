@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Known Clients Admin API ---
+//
+// An optional plain-HTTP listener (AdminAddr) for managing known clients at runtime:
+//
+//	GET    /known-clients                                   list the entries
+//	POST   /known-clients {"cn": ..., "fingerprint": ...}   authorize a fingerprint (or spki: entry)
+//	DELETE /known-clients/<cn>[?fingerprint=...]            revoke one entry, or every entry of the CN
+//
+// With TOFUApproval it also lists, approves and dismisses the clients awaiting approval (see tofu.go).
+// GET /connections lists the open HTTPS connections (see connstats.go).
+// Changes go through the active mtls.KnownClientsStore, which persists them (the file backend rewrites the
+// file) and reloads, so they apply to the next handshake. The listener only binds to loopback addresses
+// unless AdminAllowRemote is set, which needs AdminTokenFile. A loopback address still can't keep out a
+// browser on the same host, so requests are also checked by guardAdmin: the Host header must name a
+// loopback host (against DNS rebinding), a POST must be sent as application/json (which a cross-site
+// form or simple fetch can't do without a CORS preflight, which is never granted), and with
+// AdminTokenFile every request must carry the token as Authorization: Bearer.
+
+const knownClientsAdminPath = "/known-clients"
+
+// adminClientRequest is the body of POST /known-clients.
+type adminClientRequest struct {
+	CN          string `json:"cn"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// validateAdminAddr refuses to expose the unauthenticated admin API beyond the local host, unless allowed.
+func validateAdminAddr(addr string, allowRemote bool) error {
+	if err := validateAddr(addr); err != nil {
+		return err
+	}
+	if allowRemote {
+		return nil
+	}
+	if isLoopbackHost(hostOf(addr)) {
+		return nil
+	}
+	return fmt.Errorf("admin address %s is not a loopback address; use --admin-allow-remote to expose it", addr)
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback IP.
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return strings.EqualFold(host, "localhost") || (ip != nil && ip.IsLoopback())
+}
+
+// loadAdminToken reads the bearer token of the admin API from the first line of AdminTokenFile.
+func (s *Server) loadAdminToken() error {
+	if s.AdminTokenFile == "" {
+		if s.AdminAllowRemote {
+			return errors.New("--admin-allow-remote needs --admin-token-file, the admin API would be open to the network")
+		}
+		return nil
+	}
+	content, err := ioutil.ReadFile(s.AdminTokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read admin token: %w", err)
+	}
+	line, _, _ := strings.Cut(string(content), "\n")
+	if s.adminToken = strings.TrimSpace(line); s.adminToken == "" {
+		return fmt.Errorf("admin token file %s is empty", s.AdminTokenFile)
+	}
+	return nil
+}
+
+// guardAdmin rejects admin API requests without the token, for a non-loopback Host or, for a POST,
+// not sent as JSON.
+func (s *Server) guardAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+				logAuth(levelError, "Admin API request without a valid token", slog.String("remote_addr", r.RemoteAddr), slog.String("path", r.URL.Path))
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "admin token required"})
+				return
+			}
+		}
+		if !s.AdminAllowRemote && !isLoopbackHost(hostOf(r.Host)) {
+			logAuth(levelError, "Admin API request for a non-loopback host", slog.String("host", r.Host), slog.String("remote_addr", r.RemoteAddr))
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the admin API only answers for loopback host names"})
+			return
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != contentTypeJSON {
+				writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "send admin requests as " + contentTypeJSON})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startAdminServer starts the known clients admin listener if AdminAddr is set.
+func (s *Server) startAdminServer() error {
+	if s.AdminAddr == "" {
+		return nil
+	}
+	if err := validateAdminAddr(s.AdminAddr, s.AdminAllowRemote); err != nil {
+		return err
+	}
+	if err := s.loadAdminToken(); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(knownClientsAdminPath, s.adminKnownClientsHandler)
+	mux.HandleFunc(knownClientsAdminPath+"/", s.adminKnownClientHandler)
+	mux.HandleFunc(pendingClientsAdminPath, s.adminPendingClientsHandler)
+	mux.HandleFunc(pendingClientsAdminPath+"/", s.adminPendingClientHandler)
+	mux.HandleFunc(connectionsAdminPath, s.adminConnectionsHandler)
+	listener, err := net.Listen("tcp", s.AdminAddr)
+	if err != nil {
+		return err
+	}
+	s.adminServer = &http.Server{Handler: s.guardAdmin(mux), ErrorLog: newLevelLogger(levelError)}
+	logInfof("Serving the known clients admin API on http://%s%s", listener.Addr(), knownClientsAdminPath)
+	go func() {
+		if err := s.adminServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErrorf("Admin server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopAdminServer shuts down the admin listener, if running.
+func (s *Server) stopAdminServer(ctx context.Context) error {
+	if s.adminServer == nil {
+		return nil
+	}
+	return s.adminServer.Shutdown(ctx)
+}
+
+// adminStore returns the active known clients store, or writes an error if there is none (ca mode).
+func (s *Server) adminStore(w http.ResponseWriter) (mtls.KnownClientsStore, bool) {
+	if s.knownClients == nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "known clients are not used in verify mode " + s.VerifyMode})
+		return nil, false
+	}
+	return s.knownClients, true
+}
+
+// adminKnownClientsHandler lists (GET) and adds (POST) known clients.
+func (s *Server) adminKnownClientsHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.adminStore(w)
+	if !ok {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		entries := store.List()
+		if entries == nil {
+			entries = []mtls.KnownClient{}
+		}
+		writeJSON(w, http.StatusOK, entries)
+	case http.MethodPost:
+		s.adminAddKnownClient(w, r, store)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+func (s *Server) adminAddKnownClient(w http.ResponseWriter, r *http.Request, store mtls.KnownClientsStore) {
+	var req adminClientRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body: " + err.Error()})
+		return
+	}
+	if err := mtls.ValidateEntryCN(req.CN); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.Fingerprint == "" || strings.IndexFunc(req.Fingerprint, unicode.IsSpace) >= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "fingerprint must be non-empty and without whitespace"})
+		return
+	}
+	fingerprint, err := mtls.NormalizeFingerprint(req.Fingerprint)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+	entries, _ := store.Lookup(req.CN)
+	for _, entry := range entries {
+		if entry.Fingerprint == fingerprint {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "entry already exists"})
+			return
+		}
+	}
+	if err := store.Add(req.CN, req.Fingerprint); err != nil {
+		s.writeAdminStoreError(w, err)
+		return
+	}
+	logAuth(levelInfo, "Admin API authorized a known client", slog.String("cn", req.CN), slog.String("fingerprint", fingerprint),
+		slog.String("remote_addr", r.RemoteAddr), slog.String("path", r.URL.Path))
+	writeJSON(w, http.StatusCreated, mtls.KnownClient{CN: req.CN, Fingerprint: fingerprint})
+}
+
+// adminKnownClientHandler removes the entries of one CN (DELETE /known-clients/<cn>), optionally only the one with ?fingerprint=.
+func (s *Server) adminKnownClientHandler(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.adminStore(w)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	cn := strings.TrimPrefix(r.URL.Path, knownClientsAdminPath+"/")
+	var only string
+	if raw := r.URL.Query().Get("fingerprint"); raw != "" {
+		var err error
+		if only, err = mtls.NormalizeFingerprint(raw); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	s.adminMu.Lock()
+	defer s.adminMu.Unlock()
+	entries, _ := store.Lookup(cn)
+	var removed []mtls.KnownClient
+	for _, entry := range entries {
+		if only != "" && entry.Fingerprint != only {
+			continue
+		}
+		if err := store.Remove(cn, entry.Fingerprint); err != nil {
+			s.writeAdminStoreError(w, err)
+			return
+		}
+		removed = append(removed, entry)
+	}
+	if len(removed) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no matching known clients entry"})
+		return
+	}
+	logAuth(levelInfo, "Admin API revoked known clients entries", slog.String("cn", cn), slog.Int("entries", len(removed)),
+		slog.String("remote_addr", r.RemoteAddr), slog.String("path", r.URL.Path))
+	writeJSON(w, http.StatusOK, removed)
+}
+
+// writeAdminStoreError reports a failed store change. Read-only files are a conflict and entries the
+// store doesn't have are not found, rather than server errors.
+func (s *Server) writeAdminStoreError(w http.ResponseWriter, err error) {
+	logErrorf("Admin API: failed to update known clients: %v", err)
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, mtls.ErrKnownClientsReadOnly):
+		status = http.StatusConflict
+	case errors.Is(err, mtls.ErrKnownClientNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, mtls.ErrInvalidEntry):
+		status = http.StatusBadRequest
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestValidateAdminAddr(t *testing.T) {
+	for _, tt := range []struct {
+		addr        string
+		allowRemote bool
+		ok          bool
+	}{
+		{"localhost:8082", false, true},
+		{"127.0.0.1:8082", false, true},
+		{"[::1]:8082", false, true},
+		{":8082", false, false},
+		{"0.0.0.0:8082", false, false},
+		{"10.1.2.3:8082", false, false},
+		{"0.0.0.0:8082", true, true},
+		{"::1:8082", true, false}, // Unbracketed
+	} {
+		if err := validateAdminAddr(tt.addr, tt.allowRemote); (err == nil) != tt.ok {
+			t.Errorf("validateAdminAddr(%q, %v) = %v, want ok=%v", tt.addr, tt.allowRemote, err, tt.ok)
+		}
+	}
+}
+
+// adminRequest sends a request to the admin API as JSON and decodes the JSON response into out, if not nil.
+func adminRequest(t *testing.T, method, url, body string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.Unmarshal(data, out); err != nil {
+			t.Fatalf("Invalid JSON response %q: %v", data, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminKnownClientsAPI(t *testing.T) {
+	pki := newTestPKI(t)
+	adminAddr := freeAddr(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.AdminAddr = adminAddr })
+	adminURL := "http://" + adminAddr + knownClientsAdminPath
+	client, fingerprint := addNewClient(t, pki, baseURL, "api_client")
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Fatal("Expected the client to be rejected before it is added")
+	}
+
+	body := `{"cn": "api_client", "fingerprint": "` + strings.ToLower(fingerprint) + `"}`
+	var added mtls.KnownClient
+	if status := adminRequest(t, http.MethodPost, adminURL, body, &added); status != http.StatusCreated {
+		t.Fatalf("Expected 201 for a new entry, got %d", status)
+	}
+	if added.Fingerprint != fingerprint {
+		t.Errorf("Expected the normalized fingerprint %s, got %s", fingerprint, added.Fingerprint)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the added client to be accepted, got %d (%v)", status, err)
+	}
+	if status := adminRequest(t, http.MethodPost, adminURL, body, nil); status != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate entry, got %d", status)
+	}
+	for _, bad := range []string{`{"cn": "has space", "fingerprint": "AA"}`, `{"cn": "x"}`, `{"cn": "x", "fingerprint": "spki:nope"}`, `{"cn": "x", "fp": "AA"}`} {
+		if status := adminRequest(t, http.MethodPost, adminURL, bad, nil); status != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", bad, status)
+		}
+	}
+
+	var listed []mtls.KnownClient
+	if status := adminRequest(t, http.MethodGet, adminURL, "", &listed); status != http.StatusOK {
+		t.Fatalf("Expected 200 listing known clients, got %d", status)
+	}
+	if len(listed) != 2 || listed[0].CN != "api_client" || listed[1].CN != pki.ClientCN {
+		t.Errorf("Expected api_client and %s, got %+v", pki.ClientCN, listed)
+	}
+	content, _ := ioutil.ReadFile(pki.KnownClientsFile)
+	if !strings.Contains(string(content), "api_client") {
+		t.Errorf("Expected the entry to be persisted, got:\n%s", content)
+	}
+
+	var removed []mtls.KnownClient
+	if status := adminRequest(t, http.MethodDelete, adminURL+"/api_client", "", &removed); status != http.StatusOK || len(removed) != 1 {
+		t.Fatalf("Expected one entry to be removed, got %d: %+v", status, removed)
+	}
+	if status := adminRequest(t, http.MethodDelete, adminURL+"/api_client", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for a CN without entries, got %d", status)
+	}
+	client.httpClient.CloseIdleConnections()
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Error("Expected the removed client to be rejected")
+	}
+}
+
+func TestAdminKnownClientsAPIDeleteOneFingerprint(t *testing.T) {
+	pki := newTestPKI(t)
+	adminAddr := freeAddr(t)
+	startTestServer(t, pki, func(s *Server) { s.AdminAddr = adminAddr })
+	adminURL := "http://" + adminAddr + knownClientsAdminPath
+	if status := adminRequest(t, http.MethodPost, adminURL, `{"cn": "`+pki.ClientCN+`", "fingerprint": "AA:BB"}`, nil); status != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", status)
+	}
+
+	if status := adminRequest(t, http.MethodDelete, adminURL+"/"+pki.ClientCN+"?fingerprint=cc:dd", "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown fingerprint, got %d", status)
+	}
+	if status := adminRequest(t, http.MethodDelete, adminURL+"/"+pki.ClientCN+"?fingerprint=aa:bb", "", nil); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	var listed []mtls.KnownClient
+	adminRequest(t, http.MethodGet, adminURL, "", &listed)
+	if len(listed) != 1 || listed[0].Fingerprint == "AA:BB" {
+		t.Errorf("Expected only the original entry to remain, got %+v", listed)
+	}
+}
+
+func TestAdminAPIRefusesCrossSiteRequests(t *testing.T) {
+	pki := newTestPKI(t)
+	adminAddr := freeAddr(t)
+	startTestServer(t, pki, func(s *Server) { s.AdminAddr = adminAddr })
+	adminURL := "http://" + adminAddr + knownClientsAdminPath
+	body := `{"cn": "attacker", "fingerprint": "AA:BB"}`
+
+	// What a cross-site form or fetch without a preflight can send
+	resp, err := http.Post(adminURL, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for a text/plain POST, got %d", resp.StatusCode)
+	}
+
+	// A DNS rebinding page reaches the listener with its own host name
+	req, _ := http.NewRequest(http.MethodGet, adminURL, nil)
+	req.Host = "attacker.example:80"
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for a non-loopback Host, got %d", resp.StatusCode)
+	}
+
+	var listed []mtls.KnownClient
+	adminRequest(t, http.MethodGet, adminURL, "", &listed)
+	if len(listed) != 1 {
+		t.Errorf("Expected no entry to be added, got %+v", listed)
+	}
+}
+
+func TestAdminAPIToken(t *testing.T) {
+	pki := newTestPKI(t)
+	tokenFile := filepath.Join(pki.Dir, "admin-token")
+	if err := ioutil.WriteFile(tokenFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.AdminAddr, server.AdminAllowRemote = freeAddr(t), true
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "--admin-token-file") {
+		server.Stop()
+		t.Errorf("Expected --admin-allow-remote without a token to be refused, got %v", err)
+	}
+
+	adminAddr := freeAddr(t)
+	startTestServer(t, pki, func(s *Server) { s.AdminAddr, s.AdminTokenFile = adminAddr, tokenFile })
+	adminURL := "http://" + adminAddr + knownClientsAdminPath
+	if status := adminRequest(t, http.MethodGet, adminURL, "", nil); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the token, got %d", status)
+	}
+	for token, want := range map[string]int{"wrong": http.StatusUnauthorized, "s3cret": http.StatusOK} {
+		req, _ := http.NewRequest(http.MethodGet, adminURL, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Expected %d with token %q, got %d", want, token, resp.StatusCode)
+		}
+	}
+}
+
+func TestAdminKnownClientsAPIDeleteCaseInsensitiveCN(t *testing.T) {
+	pki := newTestPKI(t)
+	adminAddr := freeAddr(t)
+	server, baseURL := startTestServer(t, pki, func(s *Server) { s.AdminAddr, s.CaseInsensitiveCN = adminAddr, true })
+	adminURL := "http://" + adminAddr + knownClientsAdminPath
+	client, fingerprint := addNewClient(t, pki, baseURL, "Alice")
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "Alice", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected Alice to be accepted, got %d (%v)", status, err)
+	}
+
+	var removed []mtls.KnownClient
+	if status := adminRequest(t, http.MethodDelete, adminURL+"/alice", "", &removed); status != http.StatusOK || len(removed) != 1 {
+		t.Fatalf("Expected the Alice entry to be removed as alice, got %d: %+v", status, removed)
+	}
+	if content, _ := ioutil.ReadFile(pki.KnownClientsFile); strings.Contains(string(content), "Alice") {
+		t.Errorf("Expected the Alice line to be removed from the file, got:\n%s", content)
+	}
+	client.httpClient.CloseIdleConnections()
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Error("Expected the removed client to be rejected")
+	}
+}
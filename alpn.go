@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// --- ALPN Protocol Negotiation ---
+//
+// Without explicit protocols the HTTPS server offers h2 and http/1.1 and the client's Transport
+// attempts HTTP/2 over its custom TLS config. Listing protocols (--alpn) offers exactly those, and
+// leaving out h2 disables HTTP/2 on that side. Custom protocols are meant for the TCP echo mode:
+// net/http closes connections that negotiate a protocol it has no handler for.
+
+const (
+	alpnH2     = "h2"
+	alpnHTTP11 = "http/1.1"
+)
+
+// defaultALPN is what the HTTPS server offers, and the client asks for, unless told otherwise.
+var defaultALPN = []string{alpnH2, alpnHTTP11}
+
+// parseALPN validates the --alpn protocols. An empty list keeps the default.
+func parseALPN(protos []string) ([]string, error) {
+	var parsed []string
+	for _, proto := range protos {
+		proto = strings.TrimSpace(proto)
+		if proto == "" || len(proto) > 255 {
+			return nil, fmt.Errorf("invalid ALPN protocol %q: must be 1 to 255 bytes", proto)
+		}
+		if slices.Contains(parsed, proto) {
+			return nil, fmt.Errorf("ALPN protocol %q listed twice", proto)
+		}
+		parsed = append(parsed, proto)
+	}
+	return parsed, nil
+}
+
+// nextProtos returns the ALPN protocols the server offers: ALPN, or defaultALPN in HTTPS mode.
+// h2 is left out when the TLS restrictions don't allow serving HTTP/2.
+func (s *Server) nextProtos() []string {
+	protos := s.ALPN
+	if len(protos) == 0 {
+		if s.Mode != serverModeHTTPS {
+			return nil
+		}
+		protos = defaultALPN
+	}
+	if !s.TLSVersions.allowsHTTP2() {
+		protos = slices.DeleteFunc(slices.Clone(protos), func(p string) bool { return p == alpnH2 })
+	}
+	return protos
+}
+
+// warnCustomALPN warns about protocols the HTTPS server will hang up on if a client picks them.
+func (s *Server) warnCustomALPN() {
+	for _, proto := range s.nextProtos() {
+		if proto != alpnH2 && proto != alpnHTTP11 {
+			logWarnf("ALPN protocol %q has no HTTP handler: connections negotiating it are closed (use it with --mode tcp)", proto)
+		}
+	}
+}
+
+// negotiatedProtocol describes the protocol of a request for the hello response, e.g. "HTTP/2.0 (ALPN h2)".
+func negotiatedProtocol(r *http.Request) string {
+	if r.TLS == nil || r.TLS.NegotiatedProtocol == "" {
+		return r.Proto + " (no ALPN)"
+	}
+	return fmt.Sprintf("%s (ALPN %s)", r.Proto, r.TLS.NegotiatedProtocol)
+}
+
+// SetALPN sets the ALPN protocols the client offers, in order of preference. Unless h2 is among them
+// the client only speaks HTTP/1.1. Call it before the first request.
+func (c *Client) SetALPN(protos []string) {
+	if len(protos) == 0 {
+		return
+	}
+	c.tlsConfig.NextProtos = protos
+	c.anonymousTLSConfig.NextProtos = protos
+	if slices.Contains(protos, alpnH2) {
+		return
+	}
+	routing := c.httpClient.Transport.(*certRoutingTransport)
+	for _, rt := range []http.RoundTripper{routing.withCert, routing.withoutCert} {
+		transport := rt.(*http.Transport)
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{} // Don't add h2 back
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestALPNNegotiation(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverALPN   []string
+		clientALPN   []string
+		wantProtocol string
+	}{
+		{"defaults negotiate HTTP/2", nil, nil, "HTTP/2.0 (ALPN h2)"},
+		{"client without h2", nil, []string{alpnHTTP11}, "HTTP/1.1 (ALPN http/1.1)"},
+		{"server without h2", []string{alpnHTTP11}, nil, "HTTP/1.1 (ALPN http/1.1)"},
+		{"server preference wins", []string{alpnHTTP11, alpnH2}, nil, "HTTP/1.1 (ALPN http/1.1)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pki := newTestPKI(t)
+			_, baseURL := startTestServer(t, pki, func(s *Server) { s.ALPN = tt.serverALPN })
+			client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.SetALPN(tt.clientALPN)
+
+			body, status, err := client.SendRequest()
+			if err != nil || status != 200 {
+				t.Fatalf("Request failed: status=%d err=%v", status, err)
+			}
+			if !strings.Contains(body, "Protocol: "+tt.wantProtocol) {
+				t.Errorf("Expected protocol %q in response, got %q", tt.wantProtocol, body)
+			}
+		})
+	}
+}
+
+func TestALPNNoCommonProtocol(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.ALPN = []string{alpnH2} })
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetALPN([]string{"playground/1"})
+
+	if _, _, err := client.SendRequest(); err == nil || !strings.Contains(err.Error(), "no application protocol") {
+		t.Errorf("Expected a no_application_protocol alert, got %v", err)
+	}
+}
+
+func TestCustomALPNOverRawConn(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer("", pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.Mode = serverModeTCP
+	server.ALPN = []string{"playground/2", "playground/1"}
+	client, err := NewClient("https://localhost", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetALPN([]string{"playground/1", "playground/2"})
+
+	serverConn, clientConn, serverErr, clientErr := pipeHandshake(t, server, client)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("Handshake failed: server=%v client=%v", serverErr, clientErr)
+	}
+	defer serverConn.Close()
+	defer clientConn.Close()
+	// The server's preference wins.
+	if proto := clientConn.ConnectionState().NegotiatedProtocol; proto != "playground/2" {
+		t.Errorf("Expected playground/2 to be negotiated, got %q", proto)
+	}
+}
+
+func TestParseALPN(t *testing.T) {
+	if protos, err := parseALPN([]string{"h2", " http/1.1"}); err != nil || len(protos) != 2 || protos[1] != alpnHTTP11 {
+		t.Errorf("Unexpected result: %v, %v", protos, err)
+	}
+	for _, invalid := range [][]string{{""}, {"h2", "h2"}, {strings.Repeat("x", 256)}} {
+		if _, err := parseALPN(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Handshake Failure Auditing ---
+//
+// Client certificate verification records an auth decision for every certificate it sees, but a handshake
+// can also fail before that: the client presents no certificate, its chain doesn't verify against the
+// client CAs, or it offers no acceptable TLS version or cipher suite. Those handshakes are recorded as
+// rejected decisions with the "handshake" check, so the decision log (and the events, metrics and
+// rejection diagnostics) account for every handshake attempt. A rejection the verifier already recorded
+// is not recorded again when its handshake then fails.
+//
+// HTTPS handshakes run inside net/http, which only reports failures to http.Server.ErrorLog, so the
+// failures are taken from there (see handshakeErrorWriter). ServerConn reports its own.
+
+// handshakeCheck is the Check of decisions recorded for failed handshakes.
+const handshakeCheck = "handshake"
+
+// httpHandshakeErrorPrefix starts the ErrorLog line net/http writes for a failed TLS handshake.
+const httpHandshakeErrorPrefix = "http: TLS handshake error from "
+
+// maxRecordedRejections bounds recordedRejections; entries older than recordedRejectionTTL are pruned beyond it.
+const (
+	maxRecordedRejections = 1024
+	recordedRejectionTTL  = time.Minute
+)
+
+// recordedRejections remembers the remote addresses of connections whose rejection the verifier recorded,
+// until their handshake failure is reported.
+type recordedRejections struct {
+	mu    sync.Mutex
+	addrs map[string]time.Time
+}
+
+func newRecordedRejections() *recordedRejections {
+	return &recordedRejections{addrs: make(map[string]time.Time)}
+}
+
+// add remembers a rejected connection.
+func (r *recordedRejections) add(remoteAddr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if len(r.addrs) >= maxRecordedRejections {
+		for addr, at := range r.addrs {
+			if now.Sub(at) > recordedRejectionTTL {
+				delete(r.addrs, addr)
+			}
+		}
+	}
+	r.addrs[remoteAddr] = now
+}
+
+// take reports whether the connection's rejection was recorded, and forgets it.
+func (r *recordedRejections) take(remoteAddr string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.addrs[remoteAddr]
+	delete(r.addrs, remoteAddr)
+	return ok
+}
+
+// recordHandshakeFailure records a failed handshake as a rejected auth decision, unless the verifier
+// already recorded the rejection.
+func (s *Server) recordHandshakeFailure(remoteAddr, reason string) {
+	if s.rejectedConns.take(remoteAddr) {
+		return
+	}
+	if e, ok := explainHandshakeFailure(reason); ok {
+		logAuth(levelWarn, "Handshake failed", append([]slog.Attr{slog.String("remote_addr", remoteAddr)}, e.attrs()...)...)
+	}
+	s.recordDecision(authDecision{
+		Time:       time.Now().UTC(),
+		RemoteAddr: remoteAddr,
+		Check:      handshakeCheck,
+		Reason:     reason,
+	})
+}
+
+// handshakeErrorWriter is the HTTPS server's ErrorLog output: it logs every line at error level, like
+// newLevelLogger, and records the TLS handshake failures among them.
+type handshakeErrorWriter struct {
+	server *Server
+}
+
+func (w handshakeErrorWriter) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	logf(levelError, "%s", line)
+	if rest, ok := strings.CutPrefix(line, httpHandshakeErrorPrefix); ok {
+		if remoteAddr, reason, ok := strings.Cut(rest, ": "); ok {
+			w.server.recordHandshakeFailure(remoteAddr, reason)
+		}
+	}
+	return len(p), nil
+}
+
+// newHandshakeErrorLogger returns the ErrorLog of the HTTPS server, see handshakeErrorWriter.
+func newHandshakeErrorLogger(s *Server) *log.Logger {
+	return log.New(handshakeErrorWriter{server: s}, "", 0)
+}
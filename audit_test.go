@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+// readDecisionLog returns the decisions in a decision log file.
+func readDecisionLog(t *testing.T, path string) []authDecision {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var decisions []authDecision
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var d authDecision
+		if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions
+}
+
+func TestDecisionLogRecordsFailedHandshakes(t *testing.T) {
+	pki := newTestPKI(t)
+	logFile := filepath.Join(pki.Dir, "decisions.jsonl")
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.DecisionLogFile = logFile
+		s.SinkPolicy = sinkPolicyBlock
+	})
+
+	// No client certificate: the handshake fails before verification.
+	rootCAs, err := mtls.LoadCertPool(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", strings.TrimPrefix(baseURL, "https://"), &tls.Config{RootCAs: rootCAs, ServerName: "localhost"})
+	if err == nil {
+		conn.Read(make([]byte, 1)) // Under TLS 1.3 the server's verdict arrives after the client's handshake
+		conn.Close()
+	}
+
+	// Unknown client: rejected by the verifier, and recorded once.
+	stranger, _ := addNewClient(t, pki, baseURL, "stranger")
+	if _, _, err := stranger.SendRequest(); err == nil {
+		t.Fatal("Expected the unknown client to be rejected")
+	}
+
+	if err := server.Stop(); err != nil { // Waits for the failed handshakes to be logged
+		t.Fatal(err)
+	}
+	var handshakeFailures, strangerRejections int
+	for _, d := range readDecisionLog(t, logFile) {
+		if d.Allowed {
+			t.Errorf("Unexpected allowed decision: %+v", d)
+		}
+		switch {
+		case d.Check == handshakeCheck && d.RemoteAddr != "" && d.CN == "":
+			handshakeFailures++
+		case d.CN == "stranger":
+			strangerRejections++
+		default:
+			t.Errorf("Unexpected decision: %+v", d)
+		}
+	}
+	if handshakeFailures != 1 {
+		t.Errorf("Expected the handshake without a certificate to be recorded once, got %d", handshakeFailures)
+	}
+	if strangerRejections < 1 {
+		t.Error("Expected the unknown client's rejection to be recorded")
+	}
+}
+
+func TestDecisionLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	d := authDecision{CN: "client", Allowed: true}
+	line, _ := json.Marshal(d)
+	sink, err := newDecisionLogSink(path, int64(2*(len(line)+1)), 2) // Two decisions per file
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 7; i++ {
+		sink.HandleDecision(d)
+	}
+	sink.Close()
+
+	for file, want := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		if got := len(readDecisionLog(t, file)); got != want {
+			t.Errorf("Expected %d decisions in %s, got %d", want, filepath.Base(file), got)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected only 2 backups to be kept, got %v", err)
+	}
+}
+
+func TestDecisionLogReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.jsonl")
+	sink, err := newDecisionLogSink(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	sink.HandleDecision(authDecision{CN: "before"})
+	if err := os.Rename(path, path+".old"); err != nil { // As logrotate would
+		t.Fatal(err)
+	}
+	if err := sink.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	sink.HandleDecision(authDecision{CN: "after"})
+	if got := readDecisionLog(t, path); len(got) != 1 || got[0].CN != "after" {
+		t.Errorf("Expected the reopened log to hold only the new decision, got %+v", got)
+	}
+}
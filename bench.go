@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// --- Client Load Test ---
+//
+// client bench (client bench requests) sends the configured request from --concurrency workers until --requests have been sent
+// or --duration has passed. By default every request opens a new connection, so each one pays for a
+// handshake: a full one, or a resumed one with --session-cache. Comparing the two handshake latency
+// lines of the report shows what resumption saves. --keep-alive reuses connections instead, measuring
+// request latency without handshakes.
+
+// ClientBenchCmd groups the benchmarks: requests (the default) and handshake (see handshakebench.go).
+type ClientBenchCmd struct {
+	Requests  ClientBenchRequestsCmd  `kong:"cmd,default='withargs',help='Load test the server with concurrent requests and report latency percentiles, throughput and errors (default).'"`
+	Handshake ClientBenchHandshakeCmd `kong:"cmd,help='Time raw TLS handshakes, without HTTP, and report latency percentiles per TLS version and key type.'"`
+}
+
+// ClientBenchRequestsCmd runs a load test against the server.
+type ClientBenchRequestsCmd struct {
+	Concurrency int           `kong:"name='concurrency',short='c',help='Number of requests in flight at a time.',default='10'"`
+	Requests    int           `kong:"name='requests',short='n',help='Total number of requests to send. 0 means no limit (use --duration).',default='100'"`
+	Duration    time.Duration `kong:"name='duration',help='Stop sending requests after this long, e.g. 30s. 0 means no limit.',default='0'"`
+	KeepAlive   bool          `kong:"name='keep-alive',help='Reuse connections between requests instead of opening a new one (and handshaking) for each.'"`
+	JSON        bool          `kong:"name='json',help='Print the report as JSON.'"`
+}
+
+// benchOptions control a load test, see ClientBenchCmd.
+type benchOptions struct {
+	Concurrency int
+	Requests    int
+	Duration    time.Duration
+	KeepAlive   bool
+}
+
+// benchResult is the outcome of one request.
+type benchResult struct {
+	Latency   time.Duration
+	Handshake time.Duration // 0 if the request reused a connection
+	Resumed   bool
+	Err       string // Error kind (see benchErrorKind), or "" on success
+}
+
+// benchReport summarizes a load test.
+type benchReport struct {
+	Requests          int            `json:"requests"`
+	Succeeded         int            `json:"succeeded"`
+	Failed            int            `json:"failed"`
+	ElapsedMillis     float64        `json:"elapsed_ms"`
+	Throughput        float64        `json:"requests_per_second"`
+	FullHandshakes    int            `json:"full_handshakes"`
+	ResumedHandshakes int            `json:"resumed_handshakes"`
+	Latency           latencySummary `json:"latency"`
+	FullHandshake     latencySummary `json:"full_handshake_latency"`
+	ResumedHandshake  latencySummary `json:"resumed_handshake_latency"`
+	Errors            map[string]int `json:"errors,omitempty"`
+	elapsed           time.Duration  // Wall clock time of the test
+}
+
+// latencySample collects durations for a latencySummary.
+type latencySample []time.Duration
+
+// latencySummary gives percentiles of a latency distribution, in milliseconds.
+type latencySummary struct {
+	P50Millis float64 `json:"p50_ms"`
+	P90Millis float64 `json:"p90_ms"`
+	P99Millis float64 `json:"p99_ms"`
+	MaxMillis float64 `json:"max_ms"`
+}
+
+// Run sends the requests and prints the report.
+func (b *ClientBenchRequestsCmd) Run(c *ClientCmd) error {
+	if b.Requests <= 0 && b.Duration <= 0 {
+		return errors.New("set --requests or --duration, or the benchmark never ends")
+	}
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	logInfof("Benchmarking %s with %d concurrent workers...", client.ServerURL, max(b.Concurrency, 1))
+	report := client.Bench(benchOptions{Concurrency: b.Concurrency, Requests: b.Requests, Duration: b.Duration, KeepAlive: b.KeepAlive})
+	if b.JSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		outputf("%s\n", out)
+	} else {
+		printBenchReport(report)
+	}
+	if report.Succeeded == 0 {
+		return fmt.Errorf("all %d requests failed", report.Requests)
+	}
+	return nil
+}
+
+// Bench sends the configured request concurrently and reports latencies, throughput and errors. It uses a
+// transport of its own, so connections of earlier requests are not reused, but shares the client's TLS
+// configuration, including its session cache, and transport options.
+func (c *Client) Bench(opts benchOptions) benchReport {
+	workers := max(opts.Concurrency, 1)
+	transport := &http.Transport{
+		TLSClientConfig:     c.tlsConfig,
+		ForceAttemptHTTP2:   true,
+		DisableKeepAlives:   !opts.KeepAlive,
+		MaxIdleConnsPerHost: workers,
+	}
+	c.transportOptions.apply(transport)
+	defer transport.CloseIdleConnections()
+	httpClient := &http.Client{Transport: transport, CheckRedirect: c.checkRedirect}
+
+	ctx := context.Background()
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+	var (
+		issued  atomic.Int64
+		mu      sync.Mutex
+		results []benchResult
+		wg      sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && (opts.Requests <= 0 || issued.Add(1) <= int64(opts.Requests)) {
+				result, ok := c.benchRequest(ctx, httpClient)
+				if !ok {
+					return // Cut short by the end of the test, not a failure
+				}
+				mu.Lock()
+				results = append(results, result)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return summarizeBench(results, time.Since(start))
+}
+
+// benchRequest sends one request. It returns false if the request was cut short by ctx.
+func (c *Client) benchRequest(ctx context.Context, httpClient *http.Client) (benchResult, bool) {
+	attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+	if c.RequestTimeout > 0 {
+		attemptCtx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+	}
+	defer cancel()
+	req, err := c.newRequest(attemptCtx)
+	if err != nil {
+		return benchResult{Err: err.Error()}, true
+	}
+	var (
+		mu             sync.Mutex // A dial may outlive a cancelled request
+		result         benchResult
+		handshakeStart time.Time
+	)
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() {
+			mu.Lock()
+			handshakeStart = time.Now()
+			mu.Unlock()
+		},
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			mu.Lock()
+			if err == nil {
+				result.Handshake, result.Resumed = time.Since(handshakeStart), cs.DidResume
+			}
+			mu.Unlock()
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	result.Latency = time.Since(start)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return result, false
+	case err != nil:
+		result.Err = benchErrorKind(err)
+	case resp.StatusCode >= 400:
+		result.Err = fmt.Sprintf("status %d", resp.StatusCode)
+	}
+	return result, true
+}
+
+// benchErrorKind groups request errors for the error breakdown of the report.
+func benchErrorKind(err error) string {
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		err = urlErr.Err // Drop the method and URL, which are the same for every request
+	}
+	const remoteAlert = "remote error: tls: " // The alert type the server sends is unexported
+	switch msg := err.Error(); {
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection refused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connection reset"
+	case errors.As(err, &certErr):
+		return "server certificate rejected"
+	case strings.Contains(msg, remoteAlert):
+		return "TLS alert from server: " + msg[strings.Index(msg, remoteAlert)+len(remoteAlert):]
+	default:
+		return msg
+	}
+}
+
+// summarizeBench computes the report from the results of a test that took elapsed.
+func summarizeBench(results []benchResult, elapsed time.Duration) benchReport {
+	report := benchReport{Requests: len(results), ElapsedMillis: millis(elapsed), elapsed: elapsed}
+	var latency, full, resumed latencySample
+	for _, r := range results {
+		if r.Err != "" {
+			report.Failed++
+			if report.Errors == nil {
+				report.Errors = make(map[string]int)
+			}
+			report.Errors[r.Err]++
+			continue
+		}
+		report.Succeeded++
+		latency = append(latency, r.Latency)
+		switch {
+		case r.Handshake == 0:
+		case r.Resumed:
+			report.ResumedHandshakes++
+			resumed = append(resumed, r.Handshake)
+		default:
+			report.FullHandshakes++
+			full = append(full, r.Handshake)
+		}
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Succeeded) / elapsed.Seconds()
+	}
+	report.Latency, report.FullHandshake, report.ResumedHandshake = latency.summary(), full.summary(), resumed.summary()
+	return report
+}
+
+// summary computes the percentiles of the sample. The sample is sorted in place.
+func (s latencySample) summary() latencySummary {
+	if len(s) == 0 {
+		return latencySummary{}
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	return latencySummary{
+		P50Millis: millis(s.percentile(50)),
+		P90Millis: millis(s.percentile(90)),
+		P99Millis: millis(s.percentile(99)),
+		MaxMillis: millis(s[len(s)-1]),
+	}
+}
+
+// percentile returns the nearest-rank percentile p of the sorted, non-empty sample.
+func (s latencySample) percentile(p float64) time.Duration {
+	rank := int(p/100*float64(len(s)) + 0.5)
+	return s[min(max(rank, 1), len(s))-1]
+}
+
+// millis converts a duration to fractional milliseconds.
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// printBenchReport prints the report in the aligned "Label: value" format of inspect.
+func printBenchReport(r benchReport) {
+	line := func(label, format string, args ...interface{}) {
+		outputf("%-20s%s\n", label+":", fmt.Sprintf(format, args...))
+	}
+	latency := func(label string, s latencySummary, count int) {
+		if count > 0 {
+			line(label, "p50 %.2fms  p90 %.2fms  p99 %.2fms  max %.2fms", s.P50Millis, s.P90Millis, s.P99Millis, s.MaxMillis)
+		}
+	}
+	line("Requests", "%d (%d succeeded, %d failed) in %s", r.Requests, r.Succeeded, r.Failed, r.elapsed.Round(time.Millisecond))
+	line("Throughput", "%.1f requests/s", r.Throughput)
+	line("Handshakes", "%d full, %d resumed", r.FullHandshakes, r.ResumedHandshakes)
+	latency("Request latency", r.Latency, r.Succeeded)
+	latency("Full handshake", r.FullHandshake, r.FullHandshakes)
+	latency("Resumed handshake", r.ResumedHandshake, r.ResumedHandshakes)
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return r.Errors[kinds[i]] > r.Errors[kinds[j]] })
+	for _, kind := range kinds {
+		line("Errors", "%d %s", r.Errors[kind], kind)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBench(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.EnableSessionCache(0)
+
+	report := client.Bench(benchOptions{Concurrency: 4, Requests: 20})
+	if report.Requests != 20 || report.Succeeded != 20 {
+		t.Fatalf("Expected 20 successful requests, got %d of %d (errors %v)", report.Succeeded, report.Requests, report.Errors)
+	}
+	if report.FullHandshakes+report.ResumedHandshakes != 20 || report.ResumedHandshakes == 0 {
+		t.Errorf("Expected a handshake per request, some resumed, got %d full and %d resumed", report.FullHandshakes, report.ResumedHandshakes)
+	}
+	if report.Latency.P50Millis <= 0 || report.Latency.P50Millis > report.Latency.MaxMillis {
+		t.Errorf("Unexpected latency summary %+v", report.Latency)
+	}
+
+	report = client.Bench(benchOptions{Concurrency: 2, Requests: 10, KeepAlive: true})
+	if report.Succeeded != 10 || report.FullHandshakes+report.ResumedHandshakes > 2 {
+		t.Errorf("Expected kept-alive connections to handshake at most once per worker, got %d full and %d resumed", report.FullHandshakes, report.ResumedHandshakes)
+	}
+
+	report = client.Bench(benchOptions{Concurrency: 2, Duration: 200 * time.Millisecond, KeepAlive: true})
+	if report.Succeeded == 0 || report.Failed != 0 {
+		t.Errorf("Expected requests until the duration ran out, got %d succeeded and %d failed", report.Succeeded, report.Failed)
+	}
+}
+
+func TestBenchErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, _ := addNewClient(t, pki, baseURL, "stranger")
+
+	report := client.Bench(benchOptions{Concurrency: 2, Requests: 4})
+	if report.Failed != 4 || len(report.Errors) != 1 {
+		t.Fatalf("Expected 4 failures of one kind, got %d: %v", report.Failed, report.Errors)
+	}
+	for kind := range report.Errors {
+		if !strings.HasPrefix(kind, "TLS alert from server: ") {
+			t.Errorf("Expected the server's TLS alert as the error kind, got %q", kind)
+		}
+	}
+}
+
+func TestLatencyPercentiles(t *testing.T) {
+	var sample latencySample
+	for i := 100; i >= 1; i-- {
+		sample = append(sample, time.Duration(i)*time.Millisecond)
+	}
+	got := sample.summary()
+	if want := (latencySummary{P50Millis: 50, P90Millis: 90, P99Millis: 99, MaxMillis: 100}); got != want {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+	if got := (latencySample{7 * time.Millisecond}).summary(); got.P50Millis != 7 || got.P99Millis != 7 {
+		t.Errorf("Expected a single sample to be every percentile, got %+v", got)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Playground CA ---
+//
+// gen-cert makes self-signed certificates that are trusted one by one (pinning: the server lists
+// client fingerprints, the client trusts server.crt itself). ca init and ca issue make the other
+// kind of trust: one CA whose certificate is trusted, and certificates it signs. The server accepts
+// them with --verify-mode ca --client-ca certs/ca.crt, and the client trusts a CA-issued server
+// certificate with --server-cert certs/ca.crt. ca revoke lists certificates on certs/ca.crl, which
+// the server rejects with --crl (see revocation.go). ca issue --kind intermediate makes an intermediate
+// CA signed by the root, which can issue certificates in turn (see chain.go).
+
+// CACmd groups the playground CA subcommands.
+type CACmd struct {
+	Init   CAInitCmd   `kong:"cmd,help='Create a playground CA key and certificate (ca.crt and ca.key).'"`
+	Issue  CAIssueCmd  `kong:"cmd,help='Issue a server or client certificate signed by the playground CA, or by a Vault PKI role.'"`
+	Revoke CARevokeCmd `kong:"cmd,help='Add certificates to the CRL of the playground CA (see server --crl).'"`
+}
+
+// CAInitCmd creates the CA.
+type CAInitCmd struct {
+	OutDir   string        `kong:"name='out-dir',help='Directory to write ca.crt and ca.key to.',default='certs',type='path'"`
+	CN       string        `kong:"name='cn',help='Common name of the CA certificate.',default='tls-playground CA'"`
+	KeyType  string        `kong:"name='key-type',help='Key type of the CA: rsa, ecdsa (P-256, also ecdsa-p256), ecdsa-p384 or ed25519.',enum='rsa,ecdsa,ecdsa-p256,ecdsa-p384,ed25519',default='ecdsa'"`
+	RSABits  int           `kong:"name='rsa-bits',help='RSA key size.',default='2048'"`
+	ValidFor time.Duration `kong:"name='valid-for',help='Validity period of the CA certificate. Issued certificates never outlive it.',default='87600h'"`
+	Force    bool          `kong:"name='force',help='Overwrite an existing CA. Certificates it issued are no longer trusted by the new one.'"`
+}
+
+// Run generates the CA key pair.
+func (c *CAInitCmd) Run() error {
+	if err := os.MkdirAll(c.OutDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", c.OutDir, err)
+	}
+	certFile, keyFile := filepath.Join(c.OutDir, "ca.crt"), filepath.Join(c.OutDir, "ca.key")
+	if !c.Force {
+		if err := refuseOverwrite(certFile, keyFile); err != nil {
+			return err
+		}
+	}
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{
+		CommonName: c.CN,
+		ValidFor:   c.ValidFor,
+		KeyType:    c.KeyType,
+		RSABits:    c.RSABits,
+		IsCA:       true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate CA certificate: %w", err)
+	}
+	if err := replaceCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+		return err
+	}
+	cert, err := loadCertificate(certFile)
+	if err != nil {
+		return err
+	}
+	outputf("Generated CA certificate %s (key %s)\n  CN: %s\n  SHA-256 fingerprint: %s\n", certFile, keyFile, c.CN, mtls.CertFingerprint(cert))
+	outputf("Trust it with: server --verify-mode ca --client-ca %s, or client --server-cert %s\n", certFile, certFile)
+	return nil
+}
+
+// CAIssueCmd signs a new certificate with the CA, or has Vault sign it (see vault.go).
+type CAIssueCmd struct {
+	Backend  string        `kong:"name='backend',help='Who signs the certificate: the playground CA (--ca-cert and --ca-key), or a Vault PKI role (--vault-*).',enum='local,vault',default='local'"`
+	Kind     string        `kong:"name='kind',help='Issue a client certificate (client auth EKU), a server certificate (server auth EKU), or an intermediate CA that can sign both.',enum='client,server,intermediate',default='client'"`
+	CN       string        `kong:"name='cn',help='Common name. Defaults to my_secure_client, localhost for --kind server, or tls-playground intermediate CA.'"`
+	SANs     []string      `kong:"name='san',help='Subject alternative name: DNS name, IP, email or URI such as a SPIFFE ID (see server --client-id-source). Defaults to localhost,127.0.0.1,::1 for --kind server.',sep=','"`
+	Name     string        `kong:"name='name',help='Base name of the written files, <out-dir>/<name>.crt and .key. Defaults to ca-client, ca-server or ca-intermediate, or vault-client or vault-server with --backend vault.'"`
+	OutDir   string        `kong:"name='out-dir',help='Directory to write the certificate and key to.',default='certs',type='path'"`
+	CACert   string        `kong:"name='ca-cert',help='CA certificate (see ca init).',default='certs/ca.crt',type='path'"`
+	CAKey    string        `kong:"name='ca-key',help='CA private key.',default='certs/ca.key',type='path'"`
+	KeyType  string        `kong:"name='key-type',help='Key type of the issued certificate: rsa, ecdsa (P-256, also ecdsa-p256), ecdsa-p384 or ed25519.',enum='rsa,ecdsa,ecdsa-p256,ecdsa-p384,ed25519',default='rsa'"`
+	RSABits  int           `kong:"name='rsa-bits',help='RSA key size.',default='2048'"`
+	ValidFor time.Duration `kong:"name='valid-for',help='Validity period of the issued certificate, capped at the CA expiry.',default='8760h'"`
+
+	VaultAddr   string        `kong:"name='vault-addr',help='Address of the Vault server, e.g. https://vault.example.com:8200. Defaults to VAULT_ADDR.'"`
+	VaultToken  string        `kong:"name='vault-token',help='Vault token allowed to use the role. Defaults to VAULT_TOKEN, which other local users cannot see.'"`
+	VaultCACert string        `kong:"name='vault-ca-cert',help='CA bundle to verify the Vault server with. Defaults to VAULT_CACERT, or the system roots.',type='path'"`
+	VaultMount  string        `kong:"name='vault-mount',help='Path the PKI secrets engine is mounted at.',default='pki'"`
+	VaultRole   string        `kong:"name='vault-role',help='PKI role to sign the certificate with; it decides the allowed names, key usages and maximum TTL.'"`
+	VaultTTL    time.Duration `kong:"name='vault-ttl',help='Validity period to request from Vault, capped by the role. Used instead of --valid-for.',default='1h'"`
+
+	AddKnownClient bool   `kong:"name='add-known-client',help='Append the client CN and fingerprint to the known clients file, to compare with pinning.'"`
+	KnownClients   string `kong:"name='known-clients',help='Known clients file for --add-known-client (default: <out-dir>/knownClients.txt).',type='path'"`
+	Force          bool   `kong:"name='force',help='Overwrite existing certificate and key files.'"`
+}
+
+// Run issues the certificate and prints its fingerprint.
+func (c *CAIssueCmd) Run() error {
+	opts := certOptions{CommonName: c.CN, KeyType: c.KeyType, RSABits: c.RSABits, ValidFor: c.ValidFor}
+	sans := c.SANs
+	switch c.Kind {
+	case "intermediate":
+		if c.Backend == caBackendVault {
+			return errors.New("the Vault backend only issues client and server certificates")
+		}
+		if opts.CommonName == "" {
+			opts.CommonName = "tls-playground intermediate CA"
+		}
+		opts.IsCA = true
+	case "server":
+		if opts.CommonName == "" {
+			opts.CommonName = "localhost"
+		}
+		if len(sans) == 0 {
+			sans = []string{"localhost", "127.0.0.1", "::1"}
+		}
+		opts.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	default:
+		if opts.CommonName == "" {
+			opts.CommonName = "my_secure_client"
+		}
+		opts.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	if err := opts.setSANs(sans); err != nil {
+		return err
+	}
+
+	name := c.Name
+	if name == "" {
+		name = "ca-" + c.Kind
+		if c.Backend == caBackendVault {
+			name = "vault-" + c.Kind
+		}
+	}
+	certFile, keyFile := filepath.Join(c.OutDir, name+".crt"), filepath.Join(c.OutDir, name+".key")
+	if !c.Force {
+		if err := refuseOverwrite(certFile, keyFile); err != nil {
+			return err
+		}
+	}
+
+	issue := c.issueLocally
+	if c.Backend == caBackendVault {
+		issue = c.issueFromVault
+	}
+	certPEM, keyPEM, ca, err := issue(opts)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.OutDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", c.OutDir, err)
+	}
+	if err := replaceCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+		return err
+	}
+	cert, err := loadCertificate(certFile)
+	if err != nil {
+		return err
+	}
+	fingerprint := mtls.CertFingerprint(cert)
+	outputf("Issued %s certificate %s (key %s) signed by %s\n  CN: %s\n  SHA-256 fingerprint: %s\n",
+		c.Kind, certFile, keyFile, ca.Subject.CommonName, opts.CommonName, fingerprint)
+	if cert.NotAfter.Equal(ca.NotAfter) {
+		logWarnf("Validity of %s capped at the CA expiry, %s", certFile, ca.NotAfter.Format(time.RFC3339))
+	}
+	if opts.IsCA {
+		outputf("Issue certificates from it with: ca issue --ca-cert %s --ca-key %s\n", certFile, keyFile)
+		outputf("Clients present it with: client --intermediates %s (the server keeps trusting %s)\n", certFile, c.CACert)
+	}
+
+	if c.AddKnownClient {
+		knownClients := c.KnownClients
+		if knownClients == "" {
+			knownClients = filepath.Join(c.OutDir, "knownClients.txt")
+		}
+		if err := mtls.AppendKnownClient(knownClients, opts.CommonName, fingerprint); err != nil {
+			return err
+		}
+		outputf("Added '%s %s' to %s\n", opts.CommonName, fingerprint, knownClients)
+	}
+	return nil
+}
+
+// issueLocally signs a certificate for a new key with the playground CA, and returns them and the CA.
+func (c *CAIssueCmd) issueLocally(opts certOptions) (certPEM, keyPEM []byte, ca *x509.Certificate, err error) {
+	ca, caKey, err := loadCA(c.CACert, c.CAKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if opts.IsCA && ca.MaxPathLenZero {
+		return nil, nil, nil, fmt.Errorf("%s may only sign leaf certificates; intermediates need a root from ca init (re-create older ones with --force)", c.CACert)
+	}
+	if certPEM, keyPEM, err = generateCert(opts, ca, caKey); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to issue %s certificate: %w", c.Kind, err)
+	}
+	return certPEM, keyPEM, ca, nil
+}
+
+// CARevokeCmd revokes certificates issued by the CA.
+type CARevokeCmd struct {
+	Certs    []string      `kong:"arg,optional,name='cert',help='Certificate file(s) to revoke. Without any, the CRL is only re-signed with a new next update time.',type='existingfile'"`
+	Serials  []string      `kong:"name='serial',help='Serial number to revoke, as hex (colons allowed) like inspect prints it (repeatable).'"`
+	CRL      string        `kong:"name='crl',help='CRL file to update; created if missing.',default='certs/ca.crl',type='path'"`
+	CACert   string        `kong:"name='ca-cert',help='CA certificate (see ca init).',default='certs/ca.crt',type='path'"`
+	CAKey    string        `kong:"name='ca-key',help='CA private key.',default='certs/ca.key',type='path'"`
+	ValidFor time.Duration `kong:"name='valid-for',help='Time until the next update of the CRL, after which the server warns that it is stale.',default='168h'"`
+}
+
+// Run writes the updated CRL.
+func (c *CARevokeCmd) Run() error {
+	ca, caKey, err := loadCA(c.CACert, c.CAKey)
+	if err != nil {
+		return err
+	}
+	var serials []*big.Int
+	for _, certFile := range c.Certs {
+		cert, err := loadCertificate(certFile)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(cert.RawIssuer, ca.RawSubject) || cert.CheckSignatureFrom(ca) != nil {
+			return fmt.Errorf("%s was not issued by the CA in %s", certFile, c.CACert)
+		}
+		serials = append(serials, cert.SerialNumber)
+	}
+	for _, s := range c.Serials {
+		serial, ok := new(big.Int).SetString(strings.ReplaceAll(s, ":", ""), 16)
+		if !ok {
+			return fmt.Errorf("invalid serial number %q: want hex, e.g. 4F:FF:36", s)
+		}
+		serials = append(serials, serial)
+	}
+	added, err := revokeCertificates(c.CRL, ca, caKey, serials, c.ValidFor)
+	if err != nil {
+		return err
+	}
+	crl, err := loadCRL(c.CRL)
+	if err != nil {
+		return err
+	}
+	outputf("Revoked %d certificate(s); %s now lists %d, next update %s\n",
+		added, c.CRL, len(crl.RevokedCertificateEntries), crl.NextUpdate.Format(time.RFC3339))
+	return nil
+}
+
+// loadCA loads the CA certificate and key, checking that the certificate may sign others.
+func loadCA(certFile, keyFile string) (*x509.Certificate, crypto.Signer, error) {
+	pair, err := loadKeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA key pair (%s, %s), run ca init first: %w", certFile, keyFile, err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate %s: %w", certFile, err)
+	}
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported CA key type %T", pair.PrivateKey)
+	}
+	return cert, signer, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCAIssuedCertificates(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	caCert, caKey := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	captureOutput(t, func() {
+		if err := (&CAInitCmd{OutDir: dir, CN: "test CA", KeyType: keyTypeECDSA, ValidFor: 24 * time.Hour}).Run(); err != nil {
+			t.Fatal(err)
+		}
+		for _, kind := range []string{"server", "client"} {
+			issue := &CAIssueCmd{Kind: kind, OutDir: dir, CACert: caCert, CAKey: caKey, KeyType: keyTypeECDSA, ValidFor: time.Hour}
+			if err := issue.Run(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if err := (&CAInitCmd{OutDir: dir, CN: "test CA", KeyType: keyTypeECDSA}).Run(); err == nil {
+		t.Error("Expected ca init to refuse to overwrite the CA without --force")
+	}
+
+	client, err := loadCertificate(filepath.Join(dir, "ca-client.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Subject.CommonName != "my_secure_client" || len(client.ExtKeyUsage) != 1 || client.ExtKeyUsage[0] != x509.ExtKeyUsageClientAuth {
+		t.Errorf("Expected a client auth certificate for my_secure_client, got CN %s with EKUs %v", client.Subject.CommonName, client.ExtKeyUsage)
+	}
+
+	// The server trusts the CA for clients, and the client trusts it for the server.
+	_, url := startTestServer(t, pki, func(s *Server) {
+		s.CertFile, s.KeyFile = filepath.Join(dir, "ca-server.crt"), filepath.Join(dir, "ca-server.key")
+		s.VerifyMode = verifyModeCA
+		s.ClientCAFile = caCert
+	})
+	c, err := NewClient(url+"/hello", caCert, filepath.Join(dir, "ca-client.crt"), filepath.Join(dir, "ca-client.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := c.SendRequest(); err != nil || status != http.StatusOK {
+		t.Errorf("Expected the CA-issued client to be accepted by the CA-issued server, got %d, %v", status, err)
+	}
+
+	// A self-signed leaf can't issue certificates.
+	issue := &CAIssueCmd{Kind: "client", OutDir: dir, Name: "bogus", CACert: pki.ClientCertFile, CAKey: pki.ClientKeyFile}
+	if err := issue.Run(); err == nil {
+		t.Error("Expected issuing with a non-CA certificate to fail")
+	}
+}
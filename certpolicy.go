@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// --- Client Certificate Purpose and Names ---
+//
+// Without a client CA any certificate can authenticate a known client, whatever it was issued for, and
+// even with one the chain says nothing about which names a CA may vouch for. These checks narrow that
+// down: RequiredEKUs rejects certificates that don't list each required extended key usage (a server
+// certificate or a code signing certificate reused as a client certificate), ClientNamePatterns works
+// like X.509 name constraints on the CN and DNS SANs, and ClientIssuerPatterns accepts only certificates
+// from matching issuers. Patterns are globs as in path.Match, e.g. *.internal.example.com or svc-*.
+
+// ekuNames are the extended key usages --require-client-eku accepts, by normalized name.
+var ekuNames = map[string]x509.ExtKeyUsage{}
+
+func init() {
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageAny, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+		x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageEmailProtection, x509.ExtKeyUsageTimeStamping, x509.ExtKeyUsageOCSPSigning} {
+		ekuNames[normalizeEKUName(extKeyUsageName(usage))] = usage
+	}
+}
+
+// normalizeEKUName lowercases an extended key usage name and drops spaces, dashes and underscores, so
+// "clientAuth", "client-auth" and "Client Auth" (as inspect prints it) are the same.
+func normalizeEKUName(name string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(name))
+}
+
+// parseExtKeyUsages maps names such as "clientAuth" or "Server Auth" to their x509 values.
+func parseExtKeyUsages(names []string) ([]x509.ExtKeyUsage, error) {
+	var usages []x509.ExtKeyUsage
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		usage, ok := ekuNames[normalizeEKUName(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown extended key usage %q (want clientAuth, serverAuth, codeSigning, emailProtection, timeStamping, ocspSigning or any)", name)
+		}
+		usages = append(usages, usage)
+	}
+	return usages, nil
+}
+
+// validateNamePatterns checks that every pattern is a valid glob.
+func validateNamePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether name matches one of the glob patterns, ignoring case.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ekuCheck rejects certificates that don't list every required extended key usage. The any usage
+// stands in for all of them; a certificate without the extension states no purpose and is rejected.
+func ekuCheck(required []x509.ExtKeyUsage) CertCheck {
+	return CertCheck{Name: "eku", Check: func(cert *x509.Certificate) error {
+		has := make(map[x509.ExtKeyUsage]bool)
+		for _, usage := range cert.ExtKeyUsage {
+			has[usage] = true
+		}
+		for _, usage := range required {
+			if !has[usage] && !has[x509.ExtKeyUsageAny] {
+				return fmt.Errorf("client certificate lacks the %s extended key usage for CN '%s'", extKeyUsageName(usage), cert.Subject.CommonName)
+			}
+		}
+		return nil
+	}}
+}
+
+// namePatternsCheck rejects certificates whose CN or any DNS SAN matches none of the patterns.
+func namePatternsCheck(patterns []string) CertCheck {
+	return CertCheck{Name: "name-patterns", Check: func(cert *x509.Certificate) error {
+		names := cert.DNSNames
+		if cert.Subject.CommonName != "" {
+			names = append([]string{cert.Subject.CommonName}, names...)
+		}
+		for _, name := range names {
+			if !matchesAny(patterns, name) {
+				return fmt.Errorf("client certificate name %q matches no --client-name-pattern for CN '%s'", name, cert.Subject.CommonName)
+			}
+		}
+		return nil
+	}}
+}
+
+// issuerPatternsCheck rejects certificates whose issuer matches none of the patterns, by CN or full DN.
+func issuerPatternsCheck(patterns []string) CertCheck {
+	return CertCheck{Name: "issuer", Check: func(cert *x509.Certificate) error {
+		if !matchesAny(patterns, cert.Issuer.CommonName) && !matchesAny(patterns, cert.Issuer.String()) {
+			return fmt.Errorf("client certificate issuer %q matches no --client-issuer-pattern for CN '%s'", cert.Issuer.String(), cert.Subject.CommonName)
+		}
+		return nil
+	}}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+// newPolicyTestCert generates a self-signed certificate for opts and parses it.
+func newPolicyTestCert(t *testing.T, opts certOptions) *x509.Certificate {
+	t.Helper()
+	certPEM, _, err := generateSelfSignedCert(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestParseExtKeyUsages(t *testing.T) {
+	usages, err := parseExtKeyUsages([]string{"clientAuth", "Server Auth", "code-signing", "ANY", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageAny}
+	if len(usages) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, usages)
+	}
+	for i := range want {
+		if usages[i] != want[i] {
+			t.Errorf("Expected usage %d to be %v, got %v", i, want[i], usages[i])
+		}
+	}
+	if _, err := parseExtKeyUsages([]string{"clientAuthh"}); err == nil {
+		t.Error("Expected an unknown usage to be rejected")
+	}
+}
+
+func TestCertPolicyChecks(t *testing.T) {
+	client := newPolicyTestCert(t, certOptions{CommonName: "svc-api", DNSNames: []string{"api.internal.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	server := newPolicyTestCert(t, certOptions{CommonName: "svc-web", ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	anyUsage := newPolicyTestCert(t, certOptions{CommonName: "svc-any", ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+
+	requireClientAuth := ekuCheck([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+	if err := requireClientAuth.Check(client); err != nil {
+		t.Errorf("Expected a client auth certificate to pass, got %v", err)
+	}
+	if err := requireClientAuth.Check(anyUsage); err != nil {
+		t.Errorf("Expected the any usage to stand in for client auth, got %v", err)
+	}
+	if err := requireClientAuth.Check(server); err == nil || !strings.Contains(err.Error(), "Client Auth") {
+		t.Errorf("Expected a server auth certificate to be rejected, got %v", err)
+	}
+
+	names := namePatternsCheck([]string{"svc-*", "*.internal.example.com"})
+	if err := names.Check(client); err != nil {
+		t.Errorf("Expected the CN and SAN to match, got %v", err)
+	}
+	if err := namePatternsCheck([]string{"svc-*"}).Check(client); err == nil || !strings.Contains(err.Error(), "api.internal.example.com") {
+		t.Errorf("Expected the unmatched SAN to be named, got %v", err)
+	}
+
+	if err := issuerPatternsCheck([]string{"SVC-A*"}).Check(client); err != nil {
+		t.Errorf("Expected the self-signed issuer to match by CN, ignoring case, got %v", err)
+	}
+	if err := issuerPatternsCheck([]string{"CN=svc-api"}).Check(client); err != nil {
+		t.Errorf("Expected the issuer to match by DN, got %v", err)
+	}
+	if err := issuerPatternsCheck([]string{"Example Issuing CA*"}).Check(client); err == nil {
+		t.Error("Expected an unmatched issuer to be rejected")
+	}
+	if err := validateNamePatterns([]string{"svc-["}); err == nil {
+		t.Error("Expected a malformed pattern to be rejected")
+	}
+}
+
+func TestRequireClientEKU(t *testing.T) {
+	pki := newTestPKI(t)
+	// A known client presenting a server certificate is still rejected as a client.
+	certFile, keyFile := filepath.Join(pki.Dir, "web.crt"), filepath.Join(pki.Dir, "web.key")
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: "web", ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := loadCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "web", mtls.CertFingerprint(cert)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.RequiredClientEKUs = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	})
+	if _, status, err := newTestClient(t, pki, baseURL).SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected a client auth certificate to be accepted, got %d (%v)", status, err)
+	}
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Error("Expected a certificate without the client auth EKU to be rejected")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"time"
+)
+
+// --- Certificate Helpers ---
+
+// Supported key types for generated certificates.
+const (
+	keyTypeRSA       = "rsa"
+	keyTypeECDSA     = "ecdsa" // P-256
+	keyTypeECDSAP256 = "ecdsa-p256"
+	keyTypeECDSAP384 = "ecdsa-p384"
+	keyTypeEd25519   = "ed25519"
+
+	defaultRSABits = 2048
+)
+
+// certOptions describes a certificate to generate.
+type certOptions struct {
+	CommonName     string
+	DNSNames       []string
+	IPAddresses    []net.IP
+	EmailAddresses []string
+	URIs           []*url.URL
+	ValidFor       time.Duration
+	KeyType        string // keyTypeRSA (default), keyTypeECDSA (P-256), keyTypeECDSAP384 or keyTypeEd25519
+	RSABits        int    // Defaults to 2048
+	// ExtKeyUsage defaults to both server and client authentication.
+	ExtKeyUsage []x509.ExtKeyUsage
+	// IsCA makes a CA certificate that can sign others (see ca.go) instead of a TLS leaf. A self-signed
+	// (root) CA may sign one level of intermediate CAs, an intermediate only leaves.
+	IsCA bool
+}
+
+// generateKey creates a private key of the given type.
+func generateKey(keyType string, rsaBits int) (crypto.Signer, error) {
+	switch keyType {
+	case "", keyTypeRSA:
+		if rsaBits == 0 {
+			rsaBits = defaultRSABits
+		}
+		return rsa.GenerateKey(rand.Reader, rsaBits)
+	case keyTypeECDSA, keyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case keyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case keyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, fmt.Errorf("unsupported key type %q (want %s, %s, %s, %s or %s)", keyType, keyTypeRSA, keyTypeECDSA, keyTypeECDSAP256, keyTypeECDSAP384, keyTypeEd25519)
+	}
+}
+
+// generateSelfSignedCert creates a new key and a self-signed certificate for it.
+// Both are returned PEM encoded, ready to be written to disk.
+func generateSelfSignedCert(opts certOptions) (certPEM, keyPEM []byte, err error) {
+	return generateCert(opts, nil, nil)
+}
+
+// generateCert creates a new key and a certificate for it signed by parent and parentKey,
+// or self-signed if parent is nil. Both are returned PEM encoded.
+func generateCert(opts certOptions, parent *x509.Certificate, parentKey crypto.Signer) (certPEM, keyPEM []byte, err error) {
+	if opts.CommonName == "" && len(opts.DNSNames)+len(opts.IPAddresses)+len(opts.EmailAddresses)+len(opts.URIs) == 0 {
+		return nil, nil, errors.New("a common name or subject alternative name is required")
+	}
+	if opts.ValidFor == 0 {
+		opts.ValidFor = 365 * 24 * time.Hour
+	}
+
+	key, err := generateKey(opts.KeyType, opts.RSABits)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	notBefore := time.Now().Add(-1 * time.Minute) // Tolerate small clock skew
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: opts.CommonName},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(opts.ValidFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           opts.ExtKeyUsage,
+		BasicConstraintsValid: true,
+		DNSNames:              opts.DNSNames,
+		IPAddresses:           opts.IPAddresses,
+		EmailAddresses:        opts.EmailAddresses,
+		URIs:                  opts.URIs,
+	}
+	if template.ExtKeyUsage == nil {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	}
+
+	if opts.IsCA {
+		template.IsCA = true
+		template.MaxPathLen = 1
+		if parent != nil {
+			template.MaxPathLen, template.MaxPathLenZero = 0, true // Signs leaves only
+		}
+		template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		template.ExtKeyUsage = nil
+	} else if _, isRSA := key.(*rsa.PrivateKey); isRSA {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment // RSA key exchange (TLS 1.2 without ECDHE)
+	}
+
+	if parent == nil {
+		parent, parentKey = template, key
+	} else if template.NotAfter.After(parent.NotAfter) {
+		template.NotAfter = parent.NotAfter // Clients reject certificates that outlive their issuer
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// writeCertFiles writes a PEM certificate and key to disk. The key is only readable by the owner.
+func writeCertFiles(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write certificate %s: %w", certFile, err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write key %s: %w", keyFile, err)
+	}
+	return nil
+}
+
+// loadCertificate reads the first certificate from a PEM file.
+func loadCertificate(certFile string) (*x509.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate %s: %w", certFile, err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no PEM certificate found in %s", certFile)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %w", certFile, err)
+	}
+	return cert, nil
+}
+
+// loadCertificates parses every CERTIFICATE block of a PEM file, skipping other blocks such as keys.
+func loadCertificates(certFile string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate %s: %w", certFile, err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %d in %s: %w", len(certs)+1, certFile, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found in %s", certFile)
+	}
+	return certs, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// --- Certificate Chains ---
+//
+// A client certificate issued by an intermediate CA only verifies if the client presents the
+// intermediates with it: the server trusts the root (--client-ca) and builds the chain from what it is
+// sent. The client presents every certificate in --cert, leaf first, so a full chain PEM works as is;
+// --intermediates adds the intermediates from a file of their own when --cert holds only the leaf. In the
+// ca and both verify modes the server logs the chain it verified, leaf to root. ca issue --kind
+// intermediate makes an intermediate CA to try it with.
+
+// AddIntermediates appends the certificates of a PEM file to the chain the client presents, after its
+// certificate and any intermediates already there. Call it before the first request.
+func (c *Client) AddIntermediates(file string) error {
+	if len(c.tlsConfig.Certificates) == 0 {
+		return errors.New("intermediates need a client certificate file to go with")
+	}
+	intermediates, err := loadCertificates(file)
+	if err != nil {
+		return err
+	}
+	cert := &c.tlsConfig.Certificates[0] // Shared with the transport's config
+	for _, intermediate := range intermediates {
+		if !intermediate.IsCA {
+			return fmt.Errorf("%s in %s is not a CA certificate", intermediate.Subject, file)
+		}
+		if !containsCertificate(cert.Certificate, intermediate.Raw) {
+			cert.Certificate = append(cert.Certificate, intermediate.Raw)
+			c.intermediates = append(c.intermediates, intermediate.Raw)
+		}
+	}
+	logDebugf("Presenting a chain of %d certificates", len(cert.Certificate))
+	return nil
+}
+
+// containsCertificate reports whether chain holds the DER certificate raw.
+func containsCertificate(chain [][]byte, raw []byte) bool {
+	for _, der := range chain {
+		if bytes.Equal(der, raw) {
+			return true
+		}
+	}
+	return false
+}
+
+// chainSummary describes a certificate chain by the subjects of its certificates, leaf first, e.g.
+// "CN=client <- CN=Intermediate CA <- CN=Root CA".
+func chainSummary(chain []*x509.Certificate) string {
+	names := make([]string, len(chain))
+	for i, cert := range chain {
+		names[i] = cert.Subject.String()
+	}
+	return strings.Join(names, " <- ")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIntermediateCAChain(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	caCert, caKey := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key")
+	interCert, interKey := filepath.Join(dir, "ca-intermediate.crt"), filepath.Join(dir, "ca-intermediate.key")
+	leafCert, leafKey := filepath.Join(dir, "ca-client.crt"), filepath.Join(dir, "ca-client.key")
+	captureOutput(t, func() {
+		if err := (&CAInitCmd{OutDir: dir, CN: "test root", KeyType: keyTypeECDSA, ValidFor: 24 * time.Hour}).Run(); err != nil {
+			t.Fatal(err)
+		}
+		issue := &CAIssueCmd{Kind: "intermediate", OutDir: dir, CACert: caCert, CAKey: caKey, KeyType: keyTypeECDSA, ValidFor: time.Hour}
+		if err := issue.Run(); err != nil {
+			t.Fatal(err)
+		}
+		issue = &CAIssueCmd{Kind: "client", OutDir: dir, CACert: interCert, CAKey: interKey, KeyType: keyTypeECDSA, ValidFor: time.Hour}
+		if err := issue.Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	issue := &CAIssueCmd{Kind: "intermediate", OutDir: dir, Name: "nested", CACert: interCert, CAKey: interKey, KeyType: keyTypeECDSA}
+	if err := issue.Run(); err == nil || !strings.Contains(err.Error(), "may only sign leaf certificates") {
+		t.Errorf("Expected an intermediate to refuse to sign another, got %v", err)
+	}
+
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.VerifyMode = verifyModeCA
+		s.ClientCAFile = caCert
+	})
+	newChainClient := func(certFile string) *Client {
+		client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, certFile, leafKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	if _, _, err := newChainClient(leafCert).SendRequest(); err == nil {
+		t.Error("Expected a leaf presented without its intermediate to be rejected")
+	}
+
+	withIntermediates := newChainClient(leafCert)
+	if err := withIntermediates.AddIntermediates(interCert); err != nil {
+		t.Fatal(err)
+	}
+	if err := withIntermediates.AddIntermediates(pki.ClientCertFile); err == nil {
+		t.Error("Expected a leaf certificate to be refused as an intermediate")
+	}
+	var status int
+	var err error
+	_, logs := captureOutput(t, func() { _, status, err = withIntermediates.SendRequest() })
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the client to be accepted with --intermediates, got %d (%v)", status, err)
+	}
+	if want := `chain="CN=my_secure_client <- CN=tls-playground intermediate CA <- CN=test root"`; !strings.Contains(logs, want) {
+		t.Errorf("Expected the verified chain to be logged as %s, got:\n%s", want, logs)
+	}
+
+	// A single PEM with the leaf followed by the intermediate works without --intermediates.
+	leafPEM, err := ioutil.ReadFile(leafCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interPEM, err := ioutil.ReadFile(interCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainFile := filepath.Join(dir, "chain.crt")
+	if err := ioutil.WriteFile(chainFile, append(leafPEM, interPEM...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := newChainClient(chainFile).SendRequest(); err != nil || status != http.StatusOK {
+		t.Errorf("Expected a full chain PEM to be accepted, got %d (%v)", status, err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Client Certificate Check Pipeline ---
+
+// CertCheck is one named step of client certificate verification.
+// Checks are run in order by runCertChecks; each returns an error describing why the certificate was rejected.
+type CertCheck struct {
+	Name  string
+	Check func(cert *x509.Certificate) error
+}
+
+// certCheckError records which check rejected a certificate.
+type certCheckError struct {
+	Check string
+	Err   error
+}
+
+func (e *certCheckError) Error() string { return e.Err.Error() }
+func (e *certCheckError) Unwrap() error { return e.Err }
+
+// certCheckErrors collects every failure when checks run in audit mode.
+type certCheckErrors []*certCheckError
+
+func (e certCheckErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = fmt.Sprintf("%s: %v", err.Check, err.Err)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap lets errors.Is and errors.As see each failure.
+func (e certCheckErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// failedCheckName returns the name of the (first) check that produced err, or "" if it didn't come from a check.
+func failedCheckName(err error) string {
+	var failure *certCheckError
+	if errors.As(err, &failure) {
+		return failure.Check
+	}
+	return ""
+}
+
+// runCertChecks runs the checks in order and stops at the first failure.
+// In audit mode every check runs and all failures are returned together as certCheckErrors.
+func runCertChecks(cert *x509.Certificate, checks []CertCheck, audit bool) error {
+	var failures certCheckErrors
+	for _, check := range checks {
+		if err := check.Check(cert); err != nil {
+			logAuth(levelDebug, "Check rejected client certificate", slog.String("cn", cert.Subject.CommonName),
+				slog.String("fingerprint", mtls.CertFingerprint(cert)), slog.String("check", check.Name), slog.String("reason", err.Error()))
+			failure := &certCheckError{Check: check.Name, Err: err}
+			if !audit {
+				return failure
+			}
+			failures = append(failures, failure)
+		}
+	}
+	if len(failures) > 0 {
+		return failures
+	}
+	return nil
+}
+
+// buildCertChecks assembles the verification pipeline for the given options.
+// Denied clients are turned away first, then the validity period is checked, then revocation and the policy checks;
+// the known clients lookup runs last, unless knownClients is nil (ca mode).
+func buildCertChecks(knownClients mtls.KnownClientsStore, opts verifyOptions) []CertCheck {
+	var checks []CertCheck
+	if opts.Denied != nil {
+		checks = append(checks, deniedCheck(opts.Denied))
+	}
+	checks = append(checks, validityCheck())
+	if opts.CRL != nil {
+		checks = append(checks, revocationCheck(opts.CRL))
+	}
+	if len(opts.AllowedSignatureAlgorithms) > 0 {
+		checks = append(checks, signatureAlgorithmCheck(opts.AllowedSignatureAlgorithms))
+	}
+	if opts.MaxCertLifetime > 0 {
+		checks = append(checks, maxLifetimeCheck(opts.MaxCertLifetime))
+	}
+	if len(opts.RequiredEKUs) > 0 {
+		checks = append(checks, ekuCheck(opts.RequiredEKUs))
+	}
+	if len(opts.NamePatterns) > 0 {
+		checks = append(checks, namePatternsCheck(opts.NamePatterns))
+	}
+	if len(opts.IssuerPatterns) > 0 {
+		checks = append(checks, issuerPatternsCheck(opts.IssuerPatterns))
+	}
+	if knownClients != nil {
+		checks = append(checks, knownClientCheck(knownClients, opts.ClientIDSource))
+	}
+	return checks
+}
+
+// validityCheck rejects certificates outside their validity period. Without client CAs the TLS stack
+// accepts any client certificate and leaves this to checkClientCertificate.
+func validityCheck() CertCheck {
+	return CertCheck{Name: "validity", Check: mtls.ValidityVerifier}
+}
+
+// signatureAlgorithmCheck rejects certificates signed with an algorithm outside the allowed list.
+func signatureAlgorithmCheck(allowed []x509.SignatureAlgorithm) CertCheck {
+	return CertCheck{Name: "signature-algorithm", Check: func(cert *x509.Certificate) error {
+		if !containsSignatureAlgorithm(allowed, cert.SignatureAlgorithm) {
+			return fmt.Errorf("client certificate signature algorithm %s not allowed for CN '%s'", cert.SignatureAlgorithm, cert.Subject.CommonName)
+		}
+		return nil
+	}}
+}
+
+// maxLifetimeCheck rejects certificates whose total validity period exceeds max.
+func maxLifetimeCheck(max time.Duration) CertCheck {
+	return CertCheck{Name: "max-lifetime", Check: func(cert *x509.Certificate) error {
+		if lifetime := cert.NotAfter.Sub(cert.NotBefore); lifetime > max {
+			return fmt.Errorf("client certificate lifetime %s exceeds maximum %s for CN '%s'", lifetime, max, cert.Subject.CommonName)
+		}
+		return nil
+	}}
+}
+
+// knownClientCheck requires the CN (or the field idSource selects) to be listed with the certificate's fingerprint, or with its public key's (spki: entries),
+// in an entry that hasn't expired (see mtls.FingerprintVerifier).
+// The store applies the same CN normalization (e.g. --case-insensitive-cn) as when the file was loaded.
+func knownClientCheck(knownClients mtls.KnownClientsStore, idSource mtls.IdentitySource) CertCheck {
+	verifier := mtls.FingerprintVerifier{Store: knownClients, Identity: idSource}
+	return CertCheck{Name: "known-client", Check: func(cert *x509.Certificate) error {
+		err := verifier.Verify(cert)
+		if errors.Is(err, mtls.ErrFingerprintMismatch) {
+			id, _ := idSource.ClientID(cert)
+			entries, _ := knownClients.Lookup(id)
+			var knownFingerprints []string
+			for _, e := range entries {
+				knownFingerprints = append(knownFingerprints, e.Fingerprint)
+			}
+			logAuth(levelWarn, "Fingerprint mismatch", slog.String("cn", cert.Subject.CommonName), slog.String("fingerprint", mtls.CertFingerprint(cert)),
+				slog.Any("known_fingerprints", knownFingerprints))
+		}
+		return err
+	}}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+// recordingCheck returns a check that records its name when run and fails with err (if not nil).
+func recordingCheck(name string, ran *[]string, err error) CertCheck {
+	return CertCheck{Name: name, Check: func(*x509.Certificate) error {
+		*ran = append(*ran, name)
+		return err
+	}}
+}
+
+func TestRunCertChecksShortCircuits(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "c"}}
+	var ran []string
+	failure := errors.New("boom")
+	checks := []CertCheck{
+		recordingCheck("first", &ran, nil),
+		recordingCheck("second", &ran, failure),
+		recordingCheck("third", &ran, errors.New("never reached")),
+	}
+
+	err := runCertChecks(cert, checks, false)
+
+	var checkErr *certCheckError
+	if !errors.As(err, &checkErr) || checkErr.Check != "second" || !errors.Is(err, failure) {
+		t.Fatalf("Expected failure from 'second', got %v", err)
+	}
+	if len(ran) != 2 || ran[0] != "first" || ran[1] != "second" {
+		t.Errorf("Expected checks to run in order and stop at the failure, ran %v", ran)
+	}
+}
+
+func TestRunCertChecksAuditModeCollectsAll(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "c"}}
+	var ran []string
+	checks := []CertCheck{
+		recordingCheck("first", &ran, errors.New("bad one")),
+		recordingCheck("second", &ran, nil),
+		recordingCheck("third", &ran, errors.New("bad three")),
+	}
+
+	err := runCertChecks(cert, checks, true)
+
+	failures, ok := err.(certCheckErrors)
+	if !ok || len(failures) != 2 || failures[0].Check != "first" || failures[1].Check != "third" {
+		t.Fatalf("Expected failures from 'first' and 'third', got %v", err)
+	}
+	if len(ran) != 3 {
+		t.Errorf("Expected all checks to run in audit mode, ran %v", ran)
+	}
+	if err.Error() != "first: bad one; third: bad three" {
+		t.Errorf("Unexpected audit error message: %q", err.Error())
+	}
+	var failure *certCheckError
+	if !errors.As(err, &failure) || failure.Check != "first" || failedCheckName(err) != "first" {
+		t.Errorf("Expected errors.As to find the first failure, got %v", failure)
+	}
+}
+
+func TestBuildCertChecksOrder(t *testing.T) {
+	pki := newTestPKI(t)
+	store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := buildCertChecks(store, verifyOptions{
+		AllowedSignatureAlgorithms: []x509.SignatureAlgorithm{x509.SHA256WithRSA},
+		MaxCertLifetime:            1,
+	})
+	var names []string
+	for _, c := range checks {
+		names = append(names, c.Name)
+	}
+	want := []string{"validity", "signature-algorithm", "max-lifetime", "known-client"}
+	if len(names) != len(want) {
+		t.Fatalf("Expected checks %v, got %v", want, names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("Expected check %d to be %s, got %s", i, want[i], names[i])
+		}
+	}
+
+	if checks := buildCertChecks(store, verifyOptions{}); len(checks) != 2 || checks[1].Name != "known-client" {
+		t.Errorf("Expected only the validity and known-client checks without options, got %d checks", len(checks))
+	}
+}
@@ -1,10 +1,25 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"math"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"tls-playground/pkg/mtls"
 )
 
 // --- Client Implementation ---
@@ -15,51 +30,410 @@ type Client struct {
 	KeyFile   string
 	// CaFile    string // No longer needed, trust server cert directly
 
-	httpClient *http.Client
+	// Method, Header and Body make up the request SendRequest sends. Method defaults to GET, or to POST
+	// when there is a Body.
+	Method string
+	Header http.Header
+	Body   []byte
+
+	// MaxRetries is how many times a 429 or 503 response, or a failure to reach the server, is retried
+	// (0 disables retries).
+	MaxRetries int
+	// MaxRetryAfter caps how long a single Retry-After wait may be (0 means no cap).
+	MaxRetryAfter time.Duration
+	// RetryBackoff is the wait before retrying a failure to reach the server; it doubles with each
+	// attempt up to MaxRetryBackoff (0 means no cap).
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	// RequestTimeout bounds each attempt, including reading the response (0 means no timeout).
+	RequestTimeout time.Duration
+	// Deadline bounds SendRequest as a whole, retries and waits included (0 means no deadline).
+	Deadline time.Duration
+	// TLSReportFile, if set, receives a JSON report of the TLS connection after a successful request.
+	TLSReportFile string
+	// PrintPins prints the SPKI pins (pin-sha256) of the server's certificate chain after a successful request.
+	PrintPins bool
+	// JSON asks for a JSON response, unless Header sets Accept, and pretty-prints it.
+	JSON bool
+
+	// NoFollowRedirects returns redirect responses to the caller instead of following them.
+	NoFollowRedirects bool
+	// MaxRedirects limits how many redirects are followed (0 uses Go's default of 10).
+	MaxRedirects int
+	// StripCertCrossHost only presents the client certificate to the server URL's host and CertHosts,
+	// so a redirect to another host can't obtain it.
+	StripCertCrossHost bool
+	// CertHosts lists additional hosts ("host" or "host:port") the client certificate may be presented to.
+	CertHosts []string
+
+	httpClient         *http.Client
+	transportOptions   TransportOptions // See SetTransportOptions
+	tlsConfig          *tls.Config
+	anonymousTLSConfig *tls.Config        // tlsConfig without the client certificate, see certRoutingTransport
+	unixSocket         string             // See SetUnixSocket
+	spiffe             *spiffeSource      // See NewSPIFFEClient
+	intermediates      [][]byte           // Added by AddIntermediates
+	certSource         *clientCertificate // See EnableCertReload
+	sessionCacheSize   int                // See EnableSessionCache
+	signRequests       bool               // Sign each request with the client key, see signRequest
+	tamperSignedBody   bool               // Change the body after signing it, see ClientSignCmd
 }
 
 // NewClient creates a new client instance.
 // It trusts the specific server certificate provided in serverCertFile.
 func NewClient(serverURL, serverCertFile, clientCertFile, clientKeyFile string) (*Client, error) {
-	tlsConfig, err := createClientTLSConfig(serverCertFile, clientCertFile, clientKeyFile)
+	cfg := clientIdentity(clientCertFile, clientKeyFile)
+	cfg.ServerCertFile = serverCertFile
+	tlsConfig, err := cfg.TLSConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client TLS config: %w", err)
 	}
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
+	client := newClientWithTLSConfig(serverURL, tlsConfig)
+	client.CertFile = clientCertFile
+	client.KeyFile = clientKeyFile
+	return client, nil
+}
+
+// NewPinnedClient creates a client that trusts the server by the SHA-256 fingerprint of its public key
+// (hex or base64 pin-sha256, see --print-pins) instead of a server certificate file.
+func NewPinnedClient(serverURL, serverFingerprint, clientCertFile, clientKeyFile string) (*Client, error) {
+	cfg := clientIdentity(clientCertFile, clientKeyFile)
+	cfg.ServerFingerprint = serverFingerprint
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client TLS config: %w", err)
+	}
+
+	client := newClientWithTLSConfig(serverURL, tlsConfig)
+	client.CertFile = clientCertFile
+	client.KeyFile = clientKeyFile
+	return client, nil
+}
+
+// clientIdentity returns the ClientConfig presenting the client certificate in certFile and keyFile, or in
+// the PKCS#12 bundle certFile.
+func clientIdentity(certFile, keyFile string) mtls.ClientConfig {
+	if mtls.IsPKCS12File(certFile) {
+		return mtls.ClientConfig{PKCS12File: certFile, KeyPassphrase: keyPassphrase(certFile)}
 	}
+	return mtls.ClientConfig{CertFile: certFile, KeyFile: keyFile, KeyPassphrase: keyPassphrase(keyFile)}
+}
 
-	return &Client{
+// newClientWithTLSConfig creates a client from an already built TLS configuration.
+func newClientWithTLSConfig(serverURL string, tlsConfig *tls.Config) *Client {
+	c := &Client{
 		ServerURL: serverURL,
-		CertFile:  clientCertFile,
-		KeyFile:   clientKeyFile,
 		// CaFile:     caFile, // Removed
-		httpClient: httpClient,
-	}, nil
+		RetryBackoff:    defaultRetryBackoff,
+		MaxRetryBackoff: defaultMaxRetryBackoff,
+		tlsConfig:       tlsConfig,
+	}
+
+	// Identical transport minus the client certificate, for hosts it must not be presented to.
+	anonymousTLSConfig := tlsConfig.Clone()
+	anonymousTLSConfig.Certificates = nil
+	anonymousTLSConfig.GetClientCertificate = nil
+	c.anonymousTLSConfig = anonymousTLSConfig
+
+	// A custom TLSClientConfig turns off HTTP/2 unless it is forced back on.
+	c.httpClient = &http.Client{
+		Transport: &certRoutingTransport{
+			client:      c,
+			withCert:    &http.Transport{TLSClientConfig: tlsConfig, ForceAttemptHTTP2: true},
+			withoutCert: &http.Transport{TLSClientConfig: anonymousTLSConfig, ForceAttemptHTTP2: true},
+		},
+		CheckRedirect: c.checkRedirect,
+	}
+	return c
 }
 
-// SendRequest sends a GET request to the configured server URL.
-func (c *Client) SendRequest() (string, int, error) {
-	log.Printf("Sending request to %s...", c.ServerURL)
-	resp, err := c.httpClient.Get(c.ServerURL)
+// SetTLSVersions restricts the TLS versions and cipher suites the client offers. Call it before the first request.
+func (c *Client) SetTLSVersions(v TLSVersions) {
+	v.apply(c.tlsConfig)
+	v.apply(c.anonymousTLSConfig)
+}
+
+// SetServerName sends name in SNI instead of the URL host and verifies the server certificate against
+// it, e.g. to reach one of several virtual hosts at an IP address. Call it before the first request.
+func (c *Client) SetServerName(name string) {
+	c.tlsConfig.ServerName = name
+	c.anonymousTLSConfig.ServerName = name
+}
+
+// EnableSessionCache lets the client resume TLS sessions on new connections to a server it has talked
+// to, keeping up to size sessions (0 means the default size). Call it before the first request.
+// Connections without the client certificate (see certRoutingTransport) get a cache of their own, so
+// they can't resume a session that authenticated with it.
+func (c *Client) EnableSessionCache(size int) {
+	c.sessionCacheSize = size
+	c.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+	c.anonymousTLSConfig.ClientSessionCache = tls.NewLRUClientSessionCache(size)
+}
+
+// method returns the HTTP method SendRequest uses.
+func (c *Client) method() string {
+	if c.Method != "" {
+		return strings.ToUpper(c.Method)
+	}
+	if c.Body != nil {
+		return http.MethodPost
+	}
+	return http.MethodGet
+}
+
+// newRequest builds one attempt of the configured request. The body is re-read from Body for every attempt.
+func (c *Client) newRequest(ctx context.Context) (*http.Request, error) {
+	var body io.Reader
+	if c.Body != nil {
+		body = bytes.NewReader(c.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, c.method(), c.ServerURL, body)
 	if err != nil {
-		// Don't log fatal here, return the error for the caller (e.g., test) to handle
-		return "", 0, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	if host := c.Header.Get("Host"); host != "" {
+		req.Host = host // Go ignores a Host entry in Header
 	}
+	if c.JSON && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", contentTypeJSON)
+	}
+	if c.signRequests {
+		if err := c.signRequest(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// SendRequest sends the configured request (a GET by default) to the server URL.
+// 429 and 503 responses are retried up to MaxRetries times, honoring the server's Retry-After header,
+// and so are failures to reach the server, with exponential backoff. The whole exchange is bounded by Deadline.
+func (c *Client) SendRequest() (string, int, error) {
+	logInfof("Sending %s request to %s...", c.method(), c.ServerURL)
+	ctx := context.Background()
+	if c.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Deadline)
+		defer cancel()
+	}
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancelAttempt := ctx, context.CancelFunc(func() {})
+		if c.RequestTimeout > 0 {
+			attemptCtx, cancelAttempt = context.WithTimeout(ctx, c.RequestTimeout)
+		}
+		req, err := c.newRequest(attemptCtx)
+		if err != nil {
+			cancelAttempt()
+			return "", 0, err
+		}
+		timings := &requestTimings{Start: time.Now()}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), timings.trace()))
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			cancelAttempt()
+			if attempt < c.MaxRetries && ctx.Err() == nil && isRetryableError(err, isIdempotent(req.Method)) {
+				delay := retryBackoff(attempt, c.RetryBackoff, c.MaxRetryBackoff)
+				logWarnf("Request failed: %v, retrying in %s (attempt %d/%d)", err, delay, attempt+1, c.MaxRetries)
+				if err := sleepContext(ctx, delay); err != nil {
+					return "", 0, fmt.Errorf("failed to send request: gave up after %s: %w", c.Deadline, err)
+				}
+				continue
+			}
+			explainPostHandshakeError(err)
+			c.explainHandshakeError(err)
+			// Don't log fatal here, return the error for the caller (e.g., test) to handle
+			return "", 0, fmt.Errorf("failed to send request: %w", err)
+		}
+
+		if attempt < c.MaxRetries && isRetryableStatus(resp.StatusCode) {
+			delay := retryAfterDelay(resp.Header.Get("Retry-After"), time.Now(), c.MaxRetryAfter)
+			io.Copy(ioutil.Discard, resp.Body) // Drain so the connection can be reused
+			resp.Body.Close()
+			cancelAttempt()
+			logWarnf("Server responded with status %d, retrying in %s (attempt %d/%d)", resp.StatusCode, delay, attempt+1, c.MaxRetries)
+			if err := sleepContext(ctx, delay); err != nil {
+				return "", resp.StatusCode, fmt.Errorf("gave up retrying status %d after %s: %w", resp.StatusCode, c.Deadline, err)
+			}
+			continue
+		}
+
+		defer cancelAttempt() // The body is read under the attempt's timeout
+		return c.readResponse(resp, timings)
+	}
+}
+
+// readResponse reads and prints the response body.
+func (c *Client) readResponse(resp *http.Response, timings *requestTimings) (string, int, error) {
 	defer resp.Body.Close()
 
-	log.Printf("Received response: Status Code %d", resp.StatusCode)
+	logInfof("Received response: Status Code %d", resp.StatusCode)
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
+		explainPostHandshakeError(err)
 		return "", resp.StatusCode, fmt.Errorf("failed to read response body: %w", err)
 	}
 
+	timings.Done = time.Now()
+
 	body := string(bodyBytes)
-	fmt.Printf("Server Response:\n%s", body) // Keep log for interactive use
+	if c.JSON {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, bytes.TrimSpace(bodyBytes), "", "  "); err == nil {
+			outputf("%s\n", pretty.String()) // Nothing else, so the output can be piped to jq
+		} else {
+			logWarnf("Response is not JSON (Content-Type %q), printing it as is", resp.Header.Get("Content-Type"))
+			outputf("%s", body)
+		}
+	} else {
+		outputf("Server Response:\n%s", body) // Interactive output, suppressed by --silent
+	}
+
+	// The pins and the report describe a successful request; an error status could come from anything
+	// answering on the way, e.g. a proxy
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return body, resp.StatusCode, nil
+	}
+	if c.PrintPins && resp.TLS != nil {
+		printPins(resp.TLS.PeerCertificates)
+	}
+
+	if c.TLSReportFile != "" {
+		if err := writeTLSReport(c.TLSReportFile, buildTLSReport(resp, timings)); err != nil {
+			return body, resp.StatusCode, err
+		}
+	}
 
 	return body, resp.StatusCode, nil
 }
+
+// printPins prints an HPKP-style pin for each certificate in the chain, leaf first.
+func printPins(chain []*x509.Certificate) {
+	outputf("Server certificate pins:\n")
+	for _, cert := range chain {
+		outputf("pin-sha256=\"%s\"  # %s\n", mtls.SPKIPin(cert), cert.Subject.CommonName)
+	}
+}
+
+// defaultRetryDelay is used when a retryable response carries no usable Retry-After header.
+const defaultRetryDelay = 1 * time.Second
+
+// Defaults for the backoff between attempts to reach the server.
+const (
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultMaxRetryBackoff = 5 * time.Second
+)
+
+// isRetryableStatus reports whether the server asked us to come back later.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// isRetryableError reports whether a failed request is worth retrying: the server could not be reached
+// (e.g. it isn't up yet, or was restarting) or, for idempotent requests, the attempt timed out or the
+// connection was reset. TLS and certificate errors are not, since they fail the same way every time.
+func isRetryableError(err error, idempotent bool) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true // Nothing was sent
+	}
+	if !idempotent {
+		return false // The server may have acted on the request already
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isIdempotent reports whether sending a request with method twice has the same effect as sending it once.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// parseHeaders parses "Name: value" header flags.
+func parseHeaders(lines []string) (http.Header, error) {
+	header := http.Header{}
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q (want \"Name: value\")", line)
+		}
+		header.Add(name, strings.TrimSpace(value))
+	}
+	return header, nil
+}
+
+// retryBackoff returns the wait before retry number attempt+1: base doubled per attempt, capped at max when max > 0.
+func retryBackoff(attempt int, base, max time.Duration) time.Duration {
+	delay := base
+	for i := 0; i < attempt && (max <= 0 || delay < max) && delay < math.MaxInt64/2; i++ {
+		delay *= 2
+	}
+	if max > 0 && delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// sleepContext waits for d, or returns ctx's error if it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryAfterDelay computes how long to wait before retrying, based on a Retry-After header value.
+// Both the delay-seconds and HTTP-date forms are supported. The result is capped at max when max > 0.
+func retryAfterDelay(header string, now time.Time, max time.Duration) time.Duration {
+	delay, ok := parseRetryAfter(header, now)
+	if !ok {
+		delay = defaultRetryDelay
+	}
+	if max > 0 && (delay > max || delay < 0) {
+		delay = max
+	}
+	return delay
+}
+
+// parseRetryAfter parses a Retry-After header value (RFC 9110 section 10.2.3). A number of seconds too
+// large for a time.Duration is clamped to the longest one rather than overflowing into a negative delay.
+func parseRetryAfter(header string, now time.Time) (time.Duration, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(header, 10, 64); err == nil || errors.Is(err, strconv.ErrRange) {
+		switch {
+		case seconds < 0:
+			return 0, false
+		case seconds > int64(math.MaxInt64/time.Second):
+			return time.Duration(math.MaxInt64), true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		delay := when.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
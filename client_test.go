@@ -0,0 +1,246 @@
+package main
+
+import (
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryAfterDelay(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header string
+		max    time.Duration
+		want   time.Duration
+	}{
+		{"seconds", "3", 0, 3 * time.Second},
+		{"http date", now.Add(5 * time.Second).Format(http.TimeFormat), 0, 5 * time.Second},
+		{"date in the past", now.Add(-time.Minute).Format(http.TimeFormat), 0, 0},
+		{"capped seconds", "120", 10 * time.Second, 10 * time.Second},
+		{"capped date", now.Add(time.Hour).Format(http.TimeFormat), 10 * time.Second, 10 * time.Second},
+		{"capped overflowing seconds", "10000000000", 10 * time.Second, 10 * time.Second},
+		{"capped seconds beyond int64", "100000000000000000000", 10 * time.Second, 10 * time.Second},
+		{"overflowing seconds without cap", "10000000000", 0, time.Duration(math.MaxInt64)},
+		{"missing header", "", 0, defaultRetryDelay},
+		{"garbage", "soon", 0, defaultRetryDelay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryAfterDelay(tt.header, now, tt.max); got != tt.want {
+				t.Errorf("retryAfterDelay(%q) = %s, want %s", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendRequestHonorsRetryAfter(t *testing.T) {
+	var calls int32
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.Header().Set("Retry-After", time.Now().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+
+	client := &Client{ServerURL: ts.URL, MaxRetries: 2, MaxRetryAfter: time.Second, httpClient: ts.Client()}
+	body, status, err := client.SendRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK || body != "ok" {
+		t.Errorf("Expected 200 ok after retries, got %d %q", status, body)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 requests, got %d", calls)
+	}
+
+	// Without retries the first 429 is returned as-is.
+	atomic.StoreInt32(&calls, 0)
+	client.MaxRetries = 0
+	_, status, err = client.SendRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 without retries, got %d", status)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	base, max := 100*time.Millisecond, time.Second
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second}
+	for attempt, w := range want {
+		if got := retryBackoff(attempt, base, max); got != w {
+			t.Errorf("retryBackoff(%d) = %s, want %s", attempt, got, w)
+		}
+	}
+	if got := retryBackoff(40, base, 0); got <= 0 {
+		t.Errorf("Expected an uncapped backoff to stay positive, got %s", got)
+	}
+}
+
+func TestSendRequestRetriesUntilServerIsUp(t *testing.T) {
+	pki := newTestPKI(t)
+	addr := freeAddr(t)
+	client, err := NewClient("https://"+addr+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.MaxRetries = 50
+	client.RetryBackoff = 10 * time.Millisecond
+	client.MaxRetryBackoff = 50 * time.Millisecond
+	client.Deadline = 10 * time.Second
+
+	type result struct {
+		status int
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		_, status, err := client.SendRequest()
+		done <- result{status, err}
+	}()
+
+	time.Sleep(200 * time.Millisecond) // Let a few attempts fail
+	server := NewServer(addr, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	if r := <-done; r.err != nil || r.status != http.StatusOK {
+		t.Fatalf("Expected the request to succeed once the server is up, got %d (%v)", r.status, r.err)
+	}
+}
+
+func TestSendRequestDeadline(t *testing.T) {
+	pki := newTestPKI(t)
+	client, err := NewClient("https://"+freeAddr(t)+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.MaxRetries = 1000
+	client.RetryBackoff = 50 * time.Millisecond
+	client.Deadline = 300 * time.Millisecond
+
+	start := time.Now()
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Fatal("Expected the request to fail without a server")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected to give up around the 300ms deadline, took %s", elapsed)
+	}
+}
+
+func TestSendRequestTimeoutIsRetried(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select { // Hang the first attempt past its timeout
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	client := &Client{ServerURL: ts.URL, MaxRetries: 1, RequestTimeout: 200 * time.Millisecond, httpClient: ts.Client()}
+	body, status, err := client.SendRequest()
+	if err != nil || status != http.StatusOK || body != "ok" {
+		t.Fatalf("Expected the retry after a timeout to succeed, got %d %q (%v)", status, body, err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 requests, got %d", calls)
+	}
+}
+
+func TestSendRequestDoesNotRetryTLSErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	// Trust the client's own certificate instead of the server's.
+	client, err := NewClient(baseURL, pki.ClientCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.MaxRetries = 3
+	client.RetryBackoff = time.Second
+
+	start := time.Now()
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Fatal("Expected an untrusted server to be rejected")
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("Expected a certificate error to fail without retries, took %s", elapsed)
+	}
+}
+
+func TestSendRequestMethodBodyAndHeaders(t *testing.T) {
+	type seen struct {
+		method, body, contentType, custom, host string
+	}
+	requests := make(chan seen, 1)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- seen{r.Method, string(body), r.Header.Get("Content-Type"), r.Header.Get("X-Custom"), r.Host}
+	}))
+	defer ts.Close()
+
+	header, err := parseHeaders([]string{"Content-Type: application/json", "X-Custom:  a value ", "Host: example.test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{ServerURL: ts.URL, Header: header, Body: []byte(`{"a":1}`), httpClient: ts.Client()}
+	if _, _, err := client.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+	want := seen{http.MethodPost, `{"a":1}`, "application/json", "a value", "example.test"}
+	if got := <-requests; got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	client.Method = "put"
+	if _, _, err := client.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-requests; got.method != http.MethodPut || got.body != `{"a":1}` {
+		t.Errorf("Expected a PUT with the body, got %+v", got)
+	}
+}
+
+func TestParseHeadersRejectsMalformed(t *testing.T) {
+	for _, line := range []string{"no colon", ": value", "Bad Name: value"} {
+		if _, err := parseHeaders([]string{line}); err == nil {
+			t.Errorf("Expected %q to be rejected", line)
+		}
+	}
+}
+
+func TestNonIdempotentRequestsOnlyRetryDialErrors(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}
+	readErr := &net.OpError{Op: "read", Err: syscall.ECONNRESET}
+	if !isRetryableError(dialErr, false) || !isRetryableError(readErr, true) {
+		t.Error("Expected dial errors, and resets of idempotent requests, to be retried")
+	}
+	if isRetryableError(readErr, false) {
+		t.Error("Expected a reset POST not to be retried")
+	}
+}
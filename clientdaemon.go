@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Client Daemon ---
+//
+// client daemon keeps sending requests at an interval while the client certificate is rotated under
+// it. Like the server (see servercert.go), the client then presents its key pair through
+// tls.Config.GetClientCertificate, so a reload only swaps a pointer. After a rotation the client drops
+// its idle connections and cached TLS sessions, which carry the old certificate, so the next request
+// handshakes with the new one while a request in flight finishes on its connection. The pair is reloaded
+// on SIGHUP, when polling notices that --cert or --key changed, and after --renew-command has run because
+// the certificate is about to expire.
+
+// clientCertificate holds the client key pair loaded from CertFile and KeyFile, followed by the
+// intermediates added with AddIntermediates.
+type clientCertificate struct {
+	certFile      string
+	keyFile       string
+	intermediates [][]byte
+	current       atomic.Pointer[tls.Certificate]
+}
+
+// reload replaces the current key pair with the one on disk, keeping the current pair on error.
+func (c *clientCertificate) reload() error {
+	cert, err := loadKeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load client key pair (%s, %s): %w", c.certFile, c.keyFile, err)
+	}
+	if cert.Leaf == nil { // Go 1.23+ fills it in
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse client certificate %s: %w", c.certFile, err)
+		}
+	}
+	for _, intermediate := range c.intermediates {
+		if !containsCertificate(cert.Certificate, intermediate) {
+			cert.Certificate = append(cert.Certificate, intermediate)
+		}
+	}
+	c.current.Store(&cert)
+	return nil
+}
+
+// leaf returns the current client certificate.
+func (c *clientCertificate) leaf() *x509.Certificate {
+	return c.current.Load().Leaf
+}
+
+// getClientCertificate is the tls.Config.GetClientCertificate callback.
+func (c *clientCertificate) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return c.current.Load(), nil
+}
+
+// certificate returns the key pair the client presents, or nil if it has none (SPIFFE clients included).
+func (c *Client) certificate() *tls.Certificate {
+	if c.certSource != nil {
+		return c.certSource.current.Load()
+	}
+	if len(c.tlsConfig.Certificates) == 0 {
+		return nil
+	}
+	return &c.tlsConfig.Certificates[0]
+}
+
+// EnableCertReload makes the client present its key pair through a callback, so ReloadCertificate can
+// replace it between requests. Call it before the first request, after AddIntermediates; calling it
+// again does nothing.
+func (c *Client) EnableCertReload() error {
+	if c.certSource != nil {
+		return nil
+	}
+	if c.spiffe != nil {
+		return errors.New("the SPIFFE Workload API rotates the client certificate itself")
+	}
+	if c.CertFile == "" {
+		return errors.New("reloading the client certificate needs a client certificate file")
+	}
+	source := &clientCertificate{certFile: c.CertFile, keyFile: c.KeyFile, intermediates: c.intermediates}
+	if err := source.reload(); err != nil {
+		return err
+	}
+	c.certSource = source
+	c.tlsConfig.GetClientCertificate = source.getClientCertificate
+	if c.tlsConfig.Renegotiation != tls.RenegotiateNever {
+		logCertificateRequests(c.tlsConfig) // Replaces the wrapper SetRenegotiation put around the fixed pair
+	}
+	return nil
+}
+
+// ReloadCertificate re-reads CertFile and KeyFile and reports whether they hold another certificate.
+// If so, idle connections and cached sessions are dropped so the next request presents it. On error the
+// current pair stays in use. Don't call it while requests are in flight.
+func (c *Client) ReloadCertificate() (bool, error) {
+	if c.certSource == nil {
+		return false, errors.New("client certificate reloading is not enabled")
+	}
+	old := c.certSource.leaf()
+	if err := c.certSource.reload(); err != nil {
+		return false, err
+	}
+	leaf := c.certSource.leaf()
+	if bytes.Equal(old.Raw, leaf.Raw) {
+		return false, nil
+	}
+	logInfof("Rotated client certificate %s: CN %s, fingerprint %s -> %s, expires %s", c.CertFile,
+		leaf.Subject.CommonName, mtls.CertFingerprint(old), mtls.CertFingerprint(leaf), leaf.NotAfter.Format(time.RFC3339))
+	checkCertExpiry("client certificate "+c.CertFile, leaf, defaultExpiryWarnDays, false, time.Now()) // Only warns without strict
+	c.httpClient.CloseIdleConnections()
+	if c.tlsConfig.ClientSessionCache != nil { // A resumed session would authenticate with the old certificate
+		c.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(c.sessionCacheSize)
+	}
+	return true, nil
+}
+
+// clientDaemonOptions configure Client.RunDaemon.
+type clientDaemonOptions struct {
+	Interval time.Duration // Between requests
+	Count    int           // Requests to send; 0 sends them until ctx is done
+	// WatchCert, if set, polls CertFile and KeyFile at this interval and reloads them when they change.
+	WatchCert time.Duration
+	// RenewCommand, if set, is run with sh -c before a request once the certificate expires within
+	// RenewBefore; the pair is reloaded after it succeeds.
+	RenewCommand string
+	RenewBefore  time.Duration
+	// Reload, if set, reloads the pair whenever it receives (e.g. SIGHUP).
+	Reload <-chan os.Signal
+}
+
+// clientDaemonStats counts what RunDaemon did.
+type clientDaemonStats struct {
+	Requests  int
+	Failures  int
+	Rotations int
+}
+
+// RunDaemon sends the configured request every opts.Interval until it has sent opts.Count or ctx is done,
+// rotating the client certificate as described above. Failed requests are logged and counted, not fatal.
+func (c *Client) RunDaemon(ctx context.Context, opts clientDaemonOptions) (clientDaemonStats, error) {
+	var stats clientDaemonStats
+	if opts.Interval <= 0 {
+		return stats, errors.New("the request interval must be positive")
+	}
+	if err := c.EnableCertReload(); err != nil {
+		return stats, err
+	}
+	leaf := c.certSource.leaf()
+	logInfof("Sending a request every %s as CN %s (fingerprint %s)", opts.Interval, leaf.Subject.CommonName, mtls.CertFingerprint(leaf))
+
+	reload := func() {
+		rotated, err := c.ReloadCertificate()
+		if err != nil {
+			logErrorf("%v", err) // A half-replaced pair loads once its other file changes too
+		} else if rotated {
+			stats.Rotations++
+		}
+	}
+
+	stamps := func() (fileStamp, fileStamp) {
+		cert, _ := statFileStamp(c.CertFile) // Zero while a file is missing, e.g. mid-replacement
+		key, _ := statFileStamp(c.KeyFile)
+		return cert, key
+	}
+	lastCert, lastKey := stamps()
+	var watch <-chan time.Time
+	if opts.WatchCert > 0 {
+		ticker := time.NewTicker(opts.WatchCert)
+		defer ticker.Stop()
+		watch = ticker.C
+	}
+	requests := time.NewTicker(opts.Interval)
+	defer requests.Stop()
+
+	for {
+		if opts.RenewCommand != "" {
+			if rotated, ran := c.renewIfDue(ctx, opts); ran {
+				lastCert, lastKey = stamps()
+				if rotated {
+					stats.Rotations++
+				}
+			}
+		}
+		stats.Requests++
+		if _, status, err := c.SendRequest(); err != nil {
+			stats.Failures++
+			logErrorf("Request %d failed: %v", stats.Requests, err)
+		} else if status >= http.StatusBadRequest {
+			stats.Failures++
+			logErrorf("Request %d failed with status %d", stats.Requests, status)
+		}
+		if opts.Count > 0 && stats.Requests >= opts.Count {
+			return stats, nil
+		}
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return stats, nil
+			case <-requests.C:
+				break wait
+			case <-opts.Reload:
+				logInfof("Received SIGHUP, reloading the client certificate")
+				reload()
+			case <-watch:
+				cert, key := stamps()
+				if cert.modTime.Equal(lastCert.modTime) && cert.size == lastCert.size &&
+					key.modTime.Equal(lastKey.modTime) && key.size == lastKey.size {
+					continue
+				}
+				lastCert, lastKey = cert, key
+				logInfof("Client certificate or key changed, reloading")
+				reload()
+			}
+		}
+	}
+}
+
+// renewIfDue runs opts.RenewCommand if the client certificate expires within opts.RenewBefore, then
+// reloads the pair. ran reports whether the command ran, rotated whether it left another certificate.
+func (c *Client) renewIfDue(ctx context.Context, opts clientDaemonOptions) (rotated, ran bool) {
+	leaf := c.certSource.leaf()
+	if time.Until(leaf.NotAfter) > opts.RenewBefore {
+		return false, false
+	}
+	logInfof("Client certificate expires at %s, running --renew-command", leaf.NotAfter.Format(time.RFC3339))
+	cmd := exec.CommandContext(ctx, "sh", "-c", opts.RenewCommand)
+	cmd.Env = append(os.Environ(), "TLS_PLAYGROUND_CERT="+c.CertFile, "TLS_PLAYGROUND_KEY="+c.KeyFile)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		logErrorf("--renew-command failed: %v", err)
+		return false, true
+	}
+	rotated, err := c.ReloadCertificate()
+	if err != nil {
+		logErrorf("%v", err)
+		return false, true
+	}
+	if !rotated {
+		logWarnf("--renew-command left the client certificate unchanged")
+	}
+	return rotated, true
+}
+
+// ClientDaemonCmd sends requests at an interval while rotating the client certificate.
+type ClientDaemonCmd struct {
+	Interval     time.Duration `kong:"name='interval',help='Send a request at this interval.',default='5s'"`
+	Count        int           `kong:"name='count',short='n',help='Stop after this many requests. 0 runs until SIGINT or SIGTERM.',default='0'"`
+	WatchCert    time.Duration `kong:"name='watch-cert',help='Poll --cert and --key at this interval and present the new key pair from the next request on when they change. 0 disables; SIGHUP always reloads.',default='2s'"`
+	RenewCommand string        `kong:"name='renew-command',help='Shell command that renews --cert and --key (named by TLS_PLAYGROUND_CERT and TLS_PLAYGROUND_KEY), run when the certificate expires within --renew-before.'"`
+	RenewBefore  time.Duration `kong:"name='renew-before',help='Run --renew-command once the client certificate expires within this long.',default='24h'"`
+}
+
+// Run sends requests until interrupted, or --count is reached, and prints what happened.
+func (d *ClientDaemonCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	stats, err := client.RunDaemon(ctx, clientDaemonOptions{
+		Interval:     d.Interval,
+		Count:        d.Count,
+		WatchCert:    d.WatchCert,
+		RenewCommand: d.RenewCommand,
+		RenewBefore:  d.RenewBefore,
+		Reload:       hup,
+	})
+	if err != nil {
+		return err
+	}
+	outputf("Sent %d requests, %d failed, rotated the client certificate %d times\n", stats.Requests, stats.Failures, stats.Rotations)
+	if stats.Failures > 0 {
+		return fmt.Errorf("%d of %d requests failed", stats.Failures, stats.Requests)
+	}
+	return nil
+}
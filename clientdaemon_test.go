@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// copyClientFiles copies the test client's key pair to files of its own, which the test can replace.
+func copyClientFiles(t *testing.T, pki *testPKI) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(t.TempDir(), "daemon.crt"), filepath.Join(t.TempDir(), "daemon.key")
+	for src, dst := range map[string]string{pki.ClientCertFile: certFile, pki.ClientKeyFile: keyFile} {
+		data, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return certFile, keyFile
+}
+
+func TestClientDaemonRotatesWithoutFailures(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, nil)
+	certFile, keyFile := copyClientFiles(t, pki)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.EnableSessionCache(0)
+	if err := client.EnableCertReload(); err != nil { // Before the daemon starts, so clientLeaf can be polled below
+		t.Fatal(err)
+	}
+	oldCert, err := loadCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		stats clientDaemonStats
+		err   error
+	}
+	done := make(chan result, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		stats, err := client.RunDaemon(ctx, clientDaemonOptions{Interval: 20 * time.Millisecond, WatchCert: 10 * time.Millisecond})
+		done <- result{stats, err}
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// Renew: authorize the new certificate, swap the files under the daemon, then revoke the old one.
+	newFingerprint := pki.newClientCert(t, pki.ClientCN, filepath.Join(pki.Dir, "renewed.crt"), filepath.Join(pki.Dir, "renewed.key"))
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, pki.ClientCN, newFingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	for src, dst := range map[string]string{"renewed.crt": certFile, "renewed.key": keyFile} {
+		data, err := ioutil.ReadFile(filepath.Join(pki.Dir, src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dst, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for mtls.CertFingerprint(client.clientLeaf()) != newFingerprint {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the daemon to pick up the renewed certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, pki.ClientCN, mtls.CertFingerprint(oldCert)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(150 * time.Millisecond) // Requests that would fail on the old certificate
+	cancel()
+
+	res := <-done
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.stats.Failures != 0 || res.stats.Rotations != 1 || res.stats.Requests < 5 {
+		t.Errorf("Expected requests throughout a single rotation without failures, got %+v", res.stats)
+	}
+}
+
+func TestClientDaemonRenewCommand(t *testing.T) {
+	pki := newTestPKI(t)
+	certFile, keyFile := copyClientFiles(t, pki)
+
+	// The test certificates are valid for a year; one valid for 10 years is not due for renewal again.
+	renewedCert, renewedKey := filepath.Join(pki.Dir, "renewed.crt"), filepath.Join(pki.Dir, "renewed.key")
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: pki.ClientCN, ValidFor: 10 * 365 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCertFiles(renewedCert, renewedKey, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := loadCertificate(renewedCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, pki.ClientCN, mtls.CertFingerprint(renewed)); err != nil {
+		t.Fatal(err)
+	}
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := client.RunDaemon(context.Background(), clientDaemonOptions{
+		Interval:     10 * time.Millisecond,
+		Count:        3,
+		RenewCommand: `cp "` + renewedCert + `" "$TLS_PLAYGROUND_CERT" && cp "` + renewedKey + `" "$TLS_PLAYGROUND_KEY"`,
+		RenewBefore:  2 * 365 * 24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Failures != 0 || stats.Rotations != 1 || stats.Requests != 3 {
+		t.Errorf("Expected one renewal before three requests, got %+v", stats)
+	}
+	if got := mtls.CertFingerprint(client.clientLeaf()); got != mtls.CertFingerprint(renewed) {
+		t.Errorf("Expected the renewed certificate to be presented, got %s", got)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Watching Clients ---
+//
+// /admin/watch streams what /admin/events does, the auth decision of every handshake, together with every
+// change to the known clients: entries added, removed or modified, whether by a reload (SIGHUP, file
+// watching, Kubernetes), the admin API or trust on first use. Each WebSocket message is a watchEvent.
+// `client clients watch` prints the stream, so an operator can follow trust changes as they happen.
+
+const adminWatchPath = "/admin/watch"
+
+// Types of watchEvent.
+const (
+	watchEventSubscribed   = "subscribed" // First message, once both streams are subscribed to
+	watchEventAuth         = "auth"
+	watchEventKnownClients = "known_clients"
+)
+
+// Actions of knownClientsChange.
+const (
+	knownClientAdded   = "added"
+	knownClientRemoved = "removed"
+	knownClientChanged = "changed"
+)
+
+// knownClientsChange describes one known clients entry that appeared, disappeared or was modified.
+type knownClientsChange struct {
+	Time   time.Time        `json:"time"`
+	Action string           `json:"action"`
+	Entry  mtls.KnownClient `json:"entry"` // The new entry, or the removed one
+}
+
+// watchEvent is a JSON message the server sends over /admin/watch.
+type watchEvent struct {
+	Type     string              `json:"type"`
+	Decision *authDecision       `json:"decision,omitempty"` // For auth events
+	Change   *knownClientsChange `json:"change,omitempty"`   // For known_clients events
+}
+
+// notifyingStore wraps the server's known clients store to publish what each Add, Remove or Reload
+// changed. Stores only change through these, so every change is seen.
+type notifyingStore struct {
+	mtls.KnownClientsStore
+	publish func(knownClientsChange)
+
+	mu      sync.Mutex
+	entries map[string]mtls.KnownClient // As of the last change, by CN and fingerprint
+}
+
+func newNotifyingStore(store mtls.KnownClientsStore, publish func(knownClientsChange)) *notifyingStore {
+	return &notifyingStore{KnownClientsStore: store, publish: publish, entries: knownClientsByKey(store.List())}
+}
+
+func (n *notifyingStore) Add(cn, fingerprint string) error {
+	defer n.publishChanges()
+	return n.KnownClientsStore.Add(cn, fingerprint)
+}
+
+func (n *notifyingStore) Remove(cn, fingerprint string) error {
+	defer n.publishChanges()
+	return n.KnownClientsStore.Remove(cn, fingerprint)
+}
+
+func (n *notifyingStore) Reload() error {
+	defer n.publishChanges()
+	return n.KnownClientsStore.Reload()
+}
+
+// publishChanges compares the entries with those of the last change and publishes the differences.
+func (n *notifyingStore) publishChanges() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	current := knownClientsByKey(n.List())
+	for _, change := range diffKnownClients(n.entries, current, time.Now().UTC()) {
+		n.publish(change)
+	}
+	n.entries = current
+}
+
+// knownClientsByKey indexes entries by CN and fingerprint.
+func knownClientsByKey(entries []mtls.KnownClient) map[string]mtls.KnownClient {
+	byKey := make(map[string]mtls.KnownClient, len(entries))
+	for _, entry := range entries {
+		byKey[entry.CN+" "+entry.Fingerprint] = entry
+	}
+	return byKey
+}
+
+// diffKnownClients lists the entries added, removed or changed from before to after, sorted by CN and
+// fingerprint.
+func diffKnownClients(before, after map[string]mtls.KnownClient, now time.Time) []knownClientsChange {
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, ok := before[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []knownClientsChange
+	for _, key := range keys {
+		old, existed := before[key]
+		entry, exists := after[key]
+		switch {
+		case !existed:
+			changes = append(changes, knownClientsChange{Time: now, Action: knownClientAdded, Entry: entry})
+		case !exists:
+			changes = append(changes, knownClientsChange{Time: now, Action: knownClientRemoved, Entry: old})
+		case !reflect.DeepEqual(old, entry):
+			changes = append(changes, knownClientsChange{Time: now, Action: knownClientChanged, Entry: entry})
+		}
+	}
+	return changes
+}
+
+// adminWatchHandler streams auth decisions and known clients changes as watchEvent messages over a
+// websocket, until the client goes away or the server stops.
+func (s *Server) adminWatchHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := eventsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logErrorf("Failed to upgrade admin watch connection: %v", err)
+		return // Upgrade already replied with an error
+	}
+	defer conn.Close()
+
+	decisions, cancelDecisions := s.decisions.Subscribe()
+	defer cancelDecisions()
+	changes, cancelChanges := s.clientChanges.Subscribe()
+	defer cancelChanges()
+	logAuth(levelInfo, "Admin subscribed to auth events and known clients changes", requestAttrs(r)...)
+
+	closeWith := func(code int, text string) {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(wsWriteTimeout))
+	}
+	closed := discardWSMessages(conn)
+	event := watchEvent{Type: watchEventSubscribed}
+	for {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := conn.WriteJSON(event); err != nil {
+			return
+		}
+		select {
+		case d, ok := <-decisions:
+			if !ok {
+				closeWith(websocket.ClosePolicyViolation, "slow consumer")
+				return
+			}
+			event = watchEvent{Type: watchEventAuth, Decision: &d}
+		case c, ok := <-changes:
+			if !ok {
+				closeWith(websocket.ClosePolicyViolation, "slow consumer")
+				return
+			}
+			event = watchEvent{Type: watchEventKnownClients, Change: &c}
+		case <-closed:
+			return
+		case <-s.stopped:
+			closeWith(websocket.CloseGoingAway, "server stopping")
+			return
+		}
+	}
+}
+
+// WatchClients prints the auth decisions and known clients changes the server streams on /admin/watch
+// to out, one line each (or one JSON object with jsonOutput), until ctx is done or the server closes
+// the stream.
+func (c *Client) WatchClients(ctx context.Context, out io.Writer, jsonOutput bool) error {
+	conn, err := c.dialWebSocket(adminWatchPath)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteTimeout))
+			conn.Close() // Ends the read below
+		case <-done:
+		}
+	}()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return fmt.Errorf("server closed the stream: %s", closeErr.Text)
+			}
+			return fmt.Errorf("failed to read event: %w", err)
+		}
+		var event watchEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("invalid event %q: %w", data, err)
+		}
+		switch {
+		case event.Type == watchEventSubscribed:
+			logInfof("Watching auth decisions and known clients changes")
+		case jsonOutput:
+			fmt.Fprintf(out, "%s\n", data)
+		default:
+			fmt.Fprintln(out, formatWatchEvent(event))
+		}
+	}
+}
+
+// formatWatchEvent renders an event as one line of text.
+func formatWatchEvent(event watchEvent) string {
+	switch {
+	case event.Decision != nil:
+		d := event.Decision
+		name := d.CN
+		if d.ClientID != "" {
+			name += " (" + d.ClientID + ")"
+		}
+		line := fmt.Sprintf("%s allowed %s %s from %s", d.Time.Format(time.RFC3339), name, d.Fingerprint, d.RemoteAddr)
+		if !d.Allowed {
+			line = strings.Replace(line, " allowed ", " DENIED ", 1)
+			if d.Check != "" {
+				line += " [" + d.Check + "]"
+			}
+			line += ": " + d.Reason
+		}
+		return line
+	case event.Change != nil:
+		entry := event.Change.Entry
+		line := fmt.Sprintf("%s known client %s: %s %s", event.Change.Time.Format(time.RFC3339), event.Change.Action, entry.CN, entry.Fingerprint)
+		if !entry.ValidFrom.IsZero() {
+			line += ", valid from " + entry.ValidFrom.Format(time.RFC3339)
+		}
+		if !entry.Expires.IsZero() {
+			line += ", expires " + entry.Expires.Format(time.RFC3339)
+		}
+		return line
+	}
+	return "unknown event " + event.Type
+}
+
+// ClientClientsCmd groups the admin commands about the server's clients.
+type ClientClientsCmd struct {
+	Watch ClientClientsWatchCmd `kong:"cmd,help='Follow the auth decisions and known clients changes of the server live (needs an --admin-cn client certificate).'"`
+}
+
+// ClientClientsWatchCmd prints the server's auth decisions and known clients changes as they happen.
+type ClientClientsWatchCmd struct {
+	JSON bool `kong:"name='json',help='Print each event as a JSON line.'"`
+}
+
+// Run watches until SIGINT or SIGTERM, or until the server closes the stream.
+func (w *ClientClientsWatchCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return client.WatchClients(ctx, os.Stdout, w.JSON)
+}
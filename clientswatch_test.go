@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestDiffKnownClients(t *testing.T) {
+	before := knownClientsByKey([]mtls.KnownClient{
+		{CN: "alice", Fingerprint: "AA"},
+		{CN: "bob", Fingerprint: "BB"},
+		{CN: "carol", Fingerprint: "CC"},
+	})
+	after := knownClientsByKey([]mtls.KnownClient{
+		{CN: "alice", Fingerprint: "AA"},
+		{CN: "bob", Fingerprint: "BB", RateLimit: 5},
+		{CN: "dave", Fingerprint: "DD"},
+	})
+	var got []string
+	for _, change := range diffKnownClients(before, after, time.Now()) {
+		got = append(got, change.Action+" "+change.Entry.CN)
+	}
+	if want := "changed bob,removed carol,added dave"; strings.Join(got, ",") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, ","))
+	}
+}
+
+func TestClientsWatchStreamsKnownClientsChanges(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.AdminCNs = []string{pki.ClientCN}
+	})
+	client := newTestClient(t, pki, baseURL)
+
+	reader, writer := io.Pipe()
+	defer reader.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.WatchClients(ctx, writer, false) }()
+	time.Sleep(100 * time.Millisecond) // Let the handler subscribe before generating events
+
+	fingerprint := pki.newClientCert(t, "newcomer", filepath.Join(pki.Dir, "newcomer.crt"), filepath.Join(pki.Dir, "newcomer.key"))
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "newcomer", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, "newcomer", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	for _, want := range []string{"known client added: newcomer " + fingerprint, "known client removed: newcomer " + fingerprint} {
+		select {
+		case line := <-lines:
+			if !strings.Contains(line, want) {
+				t.Errorf("Expected %q, got %q", want, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %q", want)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Expected the watch to end cleanly, got %v", err)
+	}
+}
+
+func TestClientsWatchRequiresAdminCN(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	err := newTestClient(t, pki, baseURL).WatchClients(context.Background(), io.Discard, false)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a non-admin client to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Effective Configuration ---
+
+// redacted replaces sensitive values in the configuration summary.
+const redacted = "[REDACTED]"
+
+// configSummary is the server's effective configuration as shown by --dump-config and /admin/config.
+// Private key paths are redacted and secrets such as the token signing key are never included.
+type configSummary struct {
+	Addr                       string                  `json:"addr"`
+	ExtraAddrs                 []string                `json:"extra_addrs,omitempty"`
+	Mode                       string                  `json:"mode"`
+	TunnelTarget               string                  `json:"tunnel_target,omitempty"`
+	TunnelAllowlistFile        string                  `json:"tunnel_allowlist_file,omitempty"`
+	CertFile                   string                  `json:"cert_file"`
+	KeyFile                    string                  `json:"key_file"`
+	SNICertificates            []sniCertificateSummary `json:"sni_certificates,omitempty"`
+	Tenants                    []tenantSummary         `json:"tenants,omitempty"`
+	SPIFFESocket               string                  `json:"spiffe_socket,omitempty"`
+	KubeTLSSecret              string                  `json:"k8s_tls_secret,omitempty"`
+	KubeKnownClientsSecret     string                  `json:"k8s_known_clients_secret,omitempty"`
+	KubeNamespace              string                  `json:"k8s_namespace,omitempty"`
+	KnownClientsFile           string                  `json:"known_clients_file"`
+	KnownClientsBackend        string                  `json:"known_clients_backend,omitempty"` // Set when KnownClients replaces the file
+	KnownClients               int                     `json:"known_clients,omitempty"`         // Entries currently loaded, once started
+	VerifyMode                 string                  `json:"verify_mode"`
+	ClientCAFile               string                  `json:"client_ca_file,omitempty"`
+	CRLFile                    string                  `json:"crl_file,omitempty"`
+	WatchCRL                   string                  `json:"watch_crl,omitempty"`
+	DeniedClientsFile          string                  `json:"denied_clients_file,omitempty"`
+	WatchDeniedClients         string                  `json:"watch_denied_clients,omitempty"`
+	AllowedSignatureAlgorithms []string                `json:"allowed_signature_algorithms,omitempty"`
+	MaxClientCertLifetime      string                  `json:"max_client_cert_lifetime,omitempty"`
+	RequiredClientEKUs         []string                `json:"required_client_ekus,omitempty"`
+	ClientNamePatterns         []string                `json:"client_name_patterns,omitempty"`
+	ClientIssuerPatterns       []string                `json:"client_issuer_patterns,omitempty"`
+	VerifyAudit                bool                    `json:"verify_audit"`
+	TOFU                       bool                    `json:"tofu"`
+	TOFUApproval               bool                    `json:"tofu_approval"`
+	AdminCNs                   []string                `json:"admin_cns,omitempty"`
+	PprofCNs                   []string                `json:"pprof_cns,omitempty"`
+	Strict                     bool                    `json:"strict"`
+	MaxKnownClientsAge         string                  `json:"max_known_clients_age,omitempty"`
+	MaxKnownClients            int                     `json:"max_known_clients,omitempty"`
+	CaseInsensitiveCN          bool                    `json:"case_insensitive_cn"`
+	ClientIDSource             string                  `json:"client_id_source"`
+	DegradeOnReloadFailure     bool                    `json:"degrade_on_reload_failure"`
+	Degraded                   bool                    `json:"degraded"`
+	WatchKnownClients          string                  `json:"watch_known_clients,omitempty"`
+	WatchServerCert            string                  `json:"watch_server_cert,omitempty"`
+	SessionTicketsDisabled     bool                    `json:"session_tickets_disabled"`
+	OCSPStapleFile             string                  `json:"ocsp_staple_file,omitempty"`
+	OCSPFetch                  bool                    `json:"ocsp_fetch"`
+	OCSPResponderURL           string                  `json:"ocsp_responder_url,omitempty"`
+	OCSPIssuerFile             string                  `json:"ocsp_issuer_file,omitempty"`
+	OCSPRefresh                string                  `json:"ocsp_refresh,omitempty"`
+	ExpiryWarnDays             int                     `json:"expiry_warn_days"`
+	StrictExpiry               bool                    `json:"strict_expiry"`
+	ShutdownTimeout            string                  `json:"shutdown_timeout"`
+	DiagAddr                   string                  `json:"diag_addr,omitempty"`
+	AdminAddr                  string                  `json:"admin_addr,omitempty"`
+	AdminAllowRemote           bool                    `json:"admin_allow_remote"`
+	AdminTokenFile             string                  `json:"admin_token_file,omitempty"`
+	SocketMode                 string                  `json:"socket_mode,omitempty"`
+	SocketGroup                string                  `json:"socket_group,omitempty"`
+	MetricsAddr                string                  `json:"metrics_addr,omitempty"`
+	HTTPAddr                   string                  `json:"http_addr,omitempty"`
+	HTTPNoRedirect             bool                    `json:"http_no_redirect"`
+	BackendURL                 string                  `json:"backend_url,omitempty"`
+	LogJA3                     bool                    `json:"log_ja3"`
+	MinTLS                     string                  `json:"min_tls"`
+	MaxTLS                     string                  `json:"max_tls,omitempty"`
+	CipherSuites               []string                `json:"cipher_suites,omitempty"`
+	ALPN                       []string                `json:"alpn,omitempty"`
+	TLSDebug                   bool                    `json:"tls_debug"`
+	TLSKeyLogFile              string                  `json:"tls_keylog_file,omitempty"`
+	TokenTTL                   string                  `json:"token_ttl"`
+	RateLimit                  float64                 `json:"rate_limit,omitempty"`
+	RateBurst                  int                     `json:"rate_burst,omitempty"`
+	MaxConns                   int                     `json:"max_conns,omitempty"`
+	MaxConnsPerIP              int                     `json:"max_conns_per_ip,omitempty"`
+	ConnLimitPolicy            string                  `json:"max_conns_policy"`
+	DecisionLogFile            string                  `json:"decision_log_file,omitempty"`
+	DecisionLogMaxSize         int64                   `json:"decision_log_max_size,omitempty"`
+	DecisionLogMaxBackups      int                     `json:"decision_log_max_backups,omitempty"`
+	SinkWorkers                int                     `json:"sink_workers"`
+	SinkQueue                  int                     `json:"sink_queue"`
+	SinkPolicy                 string                  `json:"sink_policy"`
+}
+
+// sniCertificateSummary is an SNICertificate in the configuration summary, without its key path.
+type sniCertificateSummary struct {
+	Hosts    []string `json:"hosts,omitempty"` // Empty means the names in the certificate
+	CertFile string   `json:"cert_file"`
+}
+
+// tenantSummary is a Tenant in the configuration summary, without its key path.
+type tenantSummary struct {
+	Hosts            []string `json:"hosts"`
+	CertFile         string   `json:"cert_file"`
+	KnownClientsFile string   `json:"known_clients_file"`
+}
+
+// configSummary returns the server's effective configuration with sensitive values redacted.
+func (s *Server) configSummary() configSummary {
+	summary := configSummary{
+		Addr:                   s.Addr,
+		ExtraAddrs:             s.ExtraAddrs,
+		Mode:                   s.Mode,
+		TunnelTarget:           s.TunnelTarget,
+		TunnelAllowlistFile:    s.TunnelAllowlistFile,
+		CertFile:               s.CertFile,
+		KeyFile:                redacted,
+		KnownClientsFile:       s.KnownClientsFile,
+		SPIFFESocket:           s.SPIFFESocket,
+		KubeTLSSecret:          s.KubeTLSSecret,
+		KubeKnownClientsSecret: s.KubeKnownClientsSecret,
+		KubeNamespace:          s.KubeNamespace,
+		VerifyMode:             s.VerifyMode,
+		ClientCAFile:           s.ClientCAFile,
+		CRLFile:                s.CRLFile,
+		DeniedClientsFile:      s.DeniedClientsFile,
+		ClientNamePatterns:     s.ClientNamePatterns,
+		ClientIssuerPatterns:   s.ClientIssuerPatterns,
+		VerifyAudit:            s.VerifyAudit,
+		TOFU:                   s.TOFU,
+		TOFUApproval:           s.TOFUApproval,
+		AdminCNs:               s.AdminCNs,
+		PprofCNs:               s.PprofCNs,
+		Strict:                 s.Strict,
+		MaxKnownClients:        s.MaxKnownClients,
+		CaseInsensitiveCN:      s.CaseInsensitiveCN,
+		ClientIDSource:         string(mtls.IdentityCN),
+		DegradeOnReloadFailure: s.DegradeOnReloadFailure,
+		SessionTicketsDisabled: s.SessionTicketsDisabled,
+		OCSPStapleFile:         s.OCSPStapleFile,
+		OCSPFetch:              s.OCSPFetch,
+		OCSPResponderURL:       redactURL(s.OCSPResponderURL),
+		OCSPIssuerFile:         s.OCSPIssuerFile,
+		ExpiryWarnDays:         s.ExpiryWarnDays,
+		StrictExpiry:           s.StrictExpiry,
+		DiagAddr:               s.DiagAddr,
+		AdminAddr:              s.AdminAddr,
+		AdminAllowRemote:       s.AdminAllowRemote,
+		AdminTokenFile:         s.AdminTokenFile,
+		MetricsAddr:            s.MetricsAddr,
+		HTTPAddr:               s.HTTPAddr,
+		HTTPNoRedirect:         s.HTTPNoRedirect,
+		BackendURL:             redactURL(s.BackendURL),
+		LogJA3:                 s.LogJA3,
+		TLSDebug:               s.TLSDebug,
+		TLSKeyLogFile:          s.TLSKeyLogFile,
+		TokenTTL:               s.TokenTTL.String(),
+		RateLimit:              s.RateLimit,
+		RateBurst:              s.RateBurst,
+		MaxConns:               s.MaxConns,
+		MaxConnsPerIP:          s.MaxConnsPerIP,
+		ConnLimitPolicy:        s.ConnLimitPolicy,
+		ShutdownTimeout:        s.ShutdownTimeout.String(),
+		DecisionLogFile:        s.DecisionLogFile,
+		DecisionLogMaxSize:     s.DecisionLogMaxSize,
+		DecisionLogMaxBackups:  s.DecisionLogMaxBackups,
+		SinkWorkers:            s.SinkWorkers,
+		SinkQueue:              s.SinkQueue,
+		SinkPolicy:             s.SinkPolicy,
+	}
+	if s.KeyFile == "" {
+		summary.KeyFile = ""
+	}
+	for _, addr := range s.listenAddrs() {
+		if _, ok := unixSocketPath(addr); ok {
+			mode := s.SocketMode
+			if mode == 0 {
+				mode = defaultSocketMode
+			}
+			summary.SocketMode = fmt.Sprintf("%#o", mode)
+			summary.SocketGroup = s.SocketGroup
+		}
+	}
+	for _, c := range s.SNICertificates {
+		summary.SNICertificates = append(summary.SNICertificates, sniCertificateSummary{Hosts: c.Hosts, CertFile: c.CertFile})
+	}
+	for _, t := range s.Tenants {
+		summary.Tenants = append(summary.Tenants, tenantSummary{Hosts: t.Hosts, CertFile: t.CertFile, KnownClientsFile: t.KnownClientsFile})
+	}
+	summary.MinTLS = tls.VersionName(tls.VersionTLS12)
+	if s.ClientIDSource != "" {
+		summary.ClientIDSource = string(s.ClientIDSource)
+	}
+	if s.TLSVersions.Min != 0 {
+		summary.MinTLS = tls.VersionName(s.TLSVersions.Min)
+	}
+	if s.TLSVersions.Max != 0 {
+		summary.MaxTLS = tls.VersionName(s.TLSVersions.Max)
+	}
+	summary.ALPN = s.nextProtos()
+	for _, id := range s.TLSVersions.CipherSuites {
+		summary.CipherSuites = append(summary.CipherSuites, tls.CipherSuiteName(id))
+	}
+	for _, alg := range s.AllowedSignatureAlgorithms {
+		summary.AllowedSignatureAlgorithms = append(summary.AllowedSignatureAlgorithms, alg.String())
+	}
+	if s.MaxClientCertLifetime > 0 {
+		summary.MaxClientCertLifetime = s.MaxClientCertLifetime.String()
+	}
+	for _, usage := range s.RequiredClientEKUs {
+		summary.RequiredClientEKUs = append(summary.RequiredClientEKUs, extKeyUsageName(usage))
+	}
+	if s.WatchKnownClients > 0 {
+		summary.WatchKnownClients = s.WatchKnownClients.String()
+	}
+	if s.WatchServerCert > 0 {
+		summary.WatchServerCert = s.WatchServerCert.String()
+	}
+	if s.ocspStapling() {
+		summary.OCSPRefresh = s.OCSPRefresh.String()
+	}
+	if s.WatchCRL > 0 && s.CRLFile != "" {
+		summary.WatchCRL = s.WatchCRL.String()
+	}
+	if s.WatchDeniedClients > 0 && s.DeniedClientsFile != "" {
+		summary.WatchDeniedClients = s.WatchDeniedClients.String()
+	}
+	if s.MaxKnownClientsAge > 0 {
+		summary.MaxKnownClientsAge = s.MaxKnownClientsAge.String()
+	}
+	if s.KnownClients != nil {
+		summary.KnownClientsBackend = s.knownClientsSource()
+	}
+	if s.knownClients != nil {
+		summary.KnownClients = len(s.knownClients.List())
+	}
+	summary.Degraded, _ = s.Degraded()
+	return summary
+}
+
+// dumpConfig prints the effective configuration as indented JSON.
+func (s *Server) dumpConfig() error {
+	out, err := json.MarshalIndent(s.configSummary(), "", "  ")
+	if err != nil {
+		return err
+	}
+	outputf("%s\n", out)
+	return nil
+}
+
+// adminConfigHandler serves the effective configuration to admins.
+func (s *Server) adminConfigHandler(w http.ResponseWriter, r *http.Request) {
+	logAuth(levelInfo, "Admin requested the effective configuration", requestAttrs(r)...)
+	writeJSON(w, http.StatusOK, s.configSummary())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminConfigEndpoint(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.AdminCNs = []string{pki.ClientCN}
+		s.MaxClientCertLifetime = 400 * 24 * time.Hour
+		s.Strict = true
+	})
+	client, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.httpClient.Get(baseURL + "/admin/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	var summary configSummary
+	if err := json.Unmarshal(body, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.KnownClientsFile != pki.KnownClientsFile || summary.KnownClients != 1 || !summary.Strict ||
+		summary.MaxClientCertLifetime != "9600h0m0s" || len(summary.AdminCNs) != 1 || summary.SinkPolicy != sinkPolicyDrop {
+		t.Errorf("Unexpected config summary: %+v", summary)
+	}
+	if summary.KeyFile != redacted || strings.Contains(string(body), pki.ServerKeyFile) {
+		t.Errorf("Expected the key file path to be redacted, got: %s", body)
+	}
+}
+
+func TestAdminConfigRequiresAdminCN(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.httpClient.Get(baseURL + "/admin/config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for a non-admin, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// --- Config File ---
+//
+// --config reads flag values from a YAML file (JSON works too, being YAML), so deployments don't need
+// long command lines. Keys are flag names, nested under the command they belong to:
+//
+//	log-level: debug
+//	server:
+//	  addr: ":8443"
+//	  verify-mode: both
+//	  admin-cn: [ops_client]
+//	client:
+//	  url: https://localhost:8443/hello
+//	  get:
+//	    repeat: 3
+//
+// Every flag can also be set with an environment variable: TLS_PLAYGROUND_, then the command path and
+// the flag name in upper case with '-' as '_', e.g. TLS_PLAYGROUND_SERVER_VERIFY_MODE or
+// TLS_PLAYGROUND_LOG_LEVEL. Environment variables override the file, and the command line overrides both.
+// Relative paths are relative to the working directory, not to the file.
+
+const settingsEnvPrefix = "TLS_PLAYGROUND"
+
+// configFlag is the --config flag. Its hook loads the file before kong resolves the other flags.
+type configFlag string
+
+// BeforeResolve loads the config file into the settings resolver.
+func (c configFlag) BeforeResolve(ctx *kong.Context, trace *kong.Path, settings *settingsResolver) error {
+	return settings.load(string(ctx.FlagValue(trace.Flag).(configFlag)), ctx.Model.Node)
+}
+
+// settingsResolver is the kong resolver for flags not given on the command line: from the environment,
+// else from the config file.
+type settingsResolver struct {
+	values map[string]interface{}
+}
+
+// load reads the config file, checking that every key names a command or flag of app.
+func (r *settingsResolver) load(path string, app *kong.Node) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(content, &values); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if err := checkSettings(values, app, ""); err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	r.values = values
+	return nil
+}
+
+// Validate implements kong.Resolver. The file is checked when it is loaded instead.
+func (r *settingsResolver) Validate(app *kong.Application) error {
+	return nil
+}
+
+// Resolve implements kong.Resolver.
+func (r *settingsResolver) Resolve(ctx *kong.Context, parent *kong.Path, flag *kong.Flag) (interface{}, error) {
+	if flag.Name == "help" {
+		return nil, nil
+	}
+	commands := commandNames(parent.Node())
+	if value, ok := os.LookupEnv(settingsEnvName(commands, flag.Name)); ok {
+		return value, nil
+	}
+	values := r.values
+	for _, name := range commands {
+		var ok bool
+		if values, ok = lookupSetting(values, name).(map[string]interface{}); !ok {
+			return nil, nil
+		}
+	}
+	return lookupSetting(values, flag.Name), nil
+}
+
+// settingsEnvName returns the environment variable setting the flag of the command, e.g.
+// TLS_PLAYGROUND_CA_ISSUE_VALID_FOR for `ca issue --valid-for`.
+func settingsEnvName(commands []string, flag string) string {
+	name := strings.Join(append(append([]string{settingsEnvPrefix}, commands...), flag), "_")
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// commandNames returns the names of the commands from the root down to node.
+func commandNames(node *kong.Node) []string {
+	var names []string
+	for ; node != nil; node = node.Parent {
+		if node.Type == kong.CommandNode {
+			names = append([]string{node.Name}, names...)
+		}
+	}
+	return names
+}
+
+// lookupSetting returns the value of the key, which may be written with '_' instead of '-'.
+func lookupSetting(values map[string]interface{}, key string) interface{} {
+	if value, ok := values[key]; ok {
+		return value
+	}
+	return values[strings.ReplaceAll(key, "-", "_")]
+}
+
+// checkSettings reports the first key that is neither a flag of node nor a command below it.
+func checkSettings(values map[string]interface{}, node *kong.Node, prefix string) error {
+	for key, value := range values {
+		name := strings.ReplaceAll(key, "_", "-")
+		if child := childCommand(node, name); child != nil {
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%s%s is a command and must hold its flags", prefix, key)
+			}
+			if err := checkSettings(nested, child, prefix+key+"."); err != nil {
+				return err
+			}
+			continue
+		}
+		known := false
+		for _, flag := range node.Flags {
+			known = known || flag.Name == name
+		}
+		if !known {
+			return fmt.Errorf("unknown setting %s%s", prefix, key)
+		}
+	}
+	return nil
+}
+
+// childCommand returns the subcommand of node with the name, or nil.
+func childCommand(node *kong.Node, name string) *kong.Node {
+	for _, child := range node.Children {
+		if child.Type == kong.CommandNode && child.Name == name {
+			return child
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+// parseWithConfig parses args into a CLI with the server and client commands, resolving settings the way main does.
+func parseWithConfig(t *testing.T, args ...string) (*ServerCmd, *ClientCmd, error) {
+	t.Helper()
+	var c struct {
+		Config   configFlag `kong:"name='config'"`
+		LogLevel string     `kong:"name='log-level',default='info'"`
+		Server   ServerCmd  `kong:"cmd"`
+		Client   ClientCmd  `kong:"cmd"`
+	}
+	settings := &settingsResolver{}
+	parser, err := kong.New(&c, kong.Resolvers(settings), kong.Bind(settings))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parser.Parse(args)
+	return &c.Server, &c.Client, err
+}
+
+func TestConfigFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "tls-playground.yaml")
+	config := `
+server:
+  addr: ":9443"
+  verify_mode: both
+  admin-cn: [ops, audit]
+  watch-crl: 1m
+  tofu: true
+client:
+  url: https://example.test/hello
+  get:
+    repeat: 3
+`
+	if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server, _, err := parseWithConfig(t, "--config", file, "server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(server.Addrs, ",") != ":9443" || server.VerifyMode != "both" || !server.TOFU || server.WatchCRL != time.Minute {
+		t.Errorf("Settings not applied: addr %q, verify mode %q, tofu %v, watch-crl %s", server.Addrs, server.VerifyMode, server.TOFU, server.WatchCRL)
+	}
+	if strings.Join(server.AdminCNs, ",") != "ops,audit" {
+		t.Errorf("Expected admin CNs ops,audit, got %v", server.AdminCNs)
+	}
+
+	// The environment overrides the file, and the command line overrides both
+	t.Setenv("TLS_PLAYGROUND_SERVER_ADDR", ":7443")
+	t.Setenv("TLS_PLAYGROUND_SERVER_VERIFY_MODE", "ca")
+	server, _, err = parseWithConfig(t, "--config", file, "server", "--verify-mode", "fingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(server.Addrs, ",") != ":7443" || server.VerifyMode != "fingerprint" {
+		t.Errorf("Expected addr from the environment and verify mode from the command line, got %q and %q", server.Addrs, server.VerifyMode)
+	}
+
+	_, client, err := parseWithConfig(t, "--config", file, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.ServerURL != "https://example.test/hello" || client.Get.Repeat != 3 {
+		t.Errorf("Client settings not applied: url %q, repeat %d", client.ServerURL, client.Get.Repeat)
+	}
+}
+
+func TestConfigFileRejectsUnknownSettings(t *testing.T) {
+	dir := t.TempDir()
+	for config, want := range map[string]string{
+		"server:\n  adress: x\n": "unknown setting server.adress",
+		"server: x\n":            "server is a command",
+		"nope: 1\n":              "unknown setting nope",
+	} {
+		file := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := parseWithConfig(t, "--config", file, "server"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Config %q: expected an error containing %q, got %v", config, want, err)
+		}
+	}
+}
+
+func TestSettingsEnvName(t *testing.T) {
+	if got := settingsEnvName([]string{"ca", "issue"}, "valid-for"); got != "TLS_PLAYGROUND_CA_ISSUE_VALID_FOR" {
+		t.Errorf("Got %s", got)
+	}
+	if got := settingsEnvName(nil, "log-level"); got != "TLS_PLAYGROUND_LOG_LEVEL" {
+		t.Errorf("Got %s", got)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// --- Mutual TLS over Raw Connections ---
+//
+// ServerConn and ClientConn run the same mTLS handshake and known clients verification as the
+// HTTPS server and client, but over any net.Conn, so mTLS can be tunnelled over non-HTTP transports.
+// Set a deadline on conn beforehand if the handshake must not block forever.
+
+// ServerConn performs the server side of an mTLS handshake on conn and returns the established connection.
+// The client certificate is verified against the known clients exactly as for HTTPS requests.
+// conn is closed if the handshake fails.
+func (s *Server) ServerConn(conn net.Conn) (*tls.Conn, error) {
+	tlsConfig, err := s.serverTLSConfig()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Server(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		tlsConn.Close()
+		s.recordHandshakeFailure(conn.RemoteAddr().String(), err.Error())
+		return nil, fmt.Errorf("server TLS handshake with %s failed: %w", conn.RemoteAddr(), err)
+	}
+	return tlsConn, nil
+}
+
+// ClientConn performs the client side of an mTLS handshake on conn and returns the established connection.
+// The server certificate is checked against the host of ServerURL. conn is closed if the handshake fails.
+func (c *Client) ClientConn(conn net.Conn) (*tls.Conn, error) {
+	tlsConfig := c.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		u, err := url.Parse(c.ServerURL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("invalid server URL %q: %w", c.ServerURL, err)
+		}
+		tlsConfig.ServerName = u.Hostname()
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		tlsConn.Close()
+		c.explainHandshakeError(err)
+		return nil, fmt.Errorf("client TLS handshake with %s failed: %w", tlsConfig.ServerName, err)
+	}
+	return tlsConn, nil
+}
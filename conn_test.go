@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// pipeHandshake runs ServerConn and ClientConn on the two ends of a net.Pipe and returns their results.
+func pipeHandshake(t *testing.T, server *Server, client *Client) (serverConn, clientConn *tls.Conn, serverErr, clientErr error) {
+	t.Helper()
+	serverSide, clientSide := net.Pipe()
+	deadline := time.Now().Add(5 * time.Second)
+	serverSide.SetDeadline(deadline)
+	clientSide.SetDeadline(deadline)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serverConn, serverErr = server.ServerConn(serverSide)
+	}()
+	clientConn, clientErr = client.ClientConn(clientSide)
+	if clientErr != nil {
+		clientSide.Close() // Unblock the server if the client gave up first
+	}
+	<-done
+	return serverConn, clientConn, serverErr, clientErr
+}
+
+func TestConnOverPipe(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer("", pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	client, err := NewClient("https://localhost", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, clientConn, serverErr, clientErr := pipeHandshake(t, server, client)
+	if serverErr != nil || clientErr != nil {
+		t.Fatalf("Handshake failed: server=%v client=%v", serverErr, clientErr)
+	}
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	if cn := serverConn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != pki.ClientCN {
+		t.Errorf("Expected server to see client CN %s, got %s", pki.ClientCN, cn)
+	}
+
+	go clientConn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(serverConn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected to read 'ping' over the TLS connection, got %q (%v)", buf, err)
+	}
+}
+
+func TestServerConnRejectsUnknownClient(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer("", pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	unknownCert := filepath.Join(pki.Dir, "unknown.crt")
+	unknownKey := filepath.Join(pki.Dir, "unknown.key")
+	pki.newClientCert(t, "intruder", unknownCert, unknownKey)
+	client, err := NewClient("https://localhost", pki.ServerCertFile, unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, clientConn, serverErr, _ := pipeHandshake(t, server, client)
+	if clientConn != nil {
+		clientConn.Close() // With TLS 1.3 the client may finish before the server's alert arrives
+	}
+	if serverErr == nil {
+		serverConn.Close()
+		t.Fatal("Expected the server handshake to reject an unknown client")
+	}
+}
+
+func TestClientConnRejectsUntrustedServer(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer("", pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	// Trust the client's own certificate instead of the server's.
+	client, err := NewClient("https://localhost", pki.ClientCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, serverErr, clientErr := pipeHandshake(t, server, client)
+	if clientErr == nil {
+		t.Fatal("Expected the client handshake to reject an untrusted server")
+	}
+	if serverErr == nil {
+		t.Error("Expected the server handshake to fail too")
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// --- Connection Limits ---
+//
+// MaxConns caps the connections open at once across all listeners, and MaxConnsPerIP those from one
+// client IP. A listener wrapper enforces both before the TLS handshake, so a flood of connections costs
+// the server a file descriptor each rather than a handshake, in every server mode. A connection over
+// the per-IP limit is closed right away; its client sees the connection reset or closed during the
+// handshake. Over MaxConns, ConnLimitPolicy decides: "refuse" closes new connections the same way, while
+// "queue" stops accepting until a connection closes, leaving new ones in the kernel's accept queue. That
+// queue is the listen backlog (net.core.somaxconn on Linux): once it is full, new clients time out
+// connecting instead. Refusals are counted in tls_playground_refused_connections_total by reason, and
+// reaching a limit is logged once until connections close again.
+
+const (
+	connLimitRefuse = "refuse"
+	connLimitQueue  = "queue"
+)
+
+// connLimiter counts the open connections of limited listeners.
+type connLimiter struct {
+	maxPerIP int
+	queue    bool
+	metrics  *serverMetrics
+	slots    chan struct{} // One per open connection; nil without a MaxConns limit
+
+	mu      sync.Mutex
+	perIP   map[string]*ipConns
+	atLimit bool // Whether reaching MaxConns was logged
+}
+
+// ipConns counts the open connections of one client IP.
+type ipConns struct {
+	open    int
+	atLimit bool // Whether reaching MaxConnsPerIP was logged
+}
+
+// newConnLimiter returns a limiter for MaxConns, MaxConnsPerIP and ConnLimitPolicy, or nil if there are
+// no limits.
+func (s *Server) newConnLimiter() (*connLimiter, error) {
+	if s.ConnLimitPolicy != connLimitRefuse && s.ConnLimitPolicy != connLimitQueue {
+		return nil, fmt.Errorf("unknown connection limit policy %q (want %s or %s)", s.ConnLimitPolicy, connLimitRefuse, connLimitQueue)
+	}
+	if s.MaxConns < 0 || s.MaxConnsPerIP < 0 {
+		return nil, errors.New("connection limits must not be negative")
+	}
+	if s.MaxConns == 0 && s.MaxConnsPerIP == 0 {
+		return nil, nil
+	}
+	l := &connLimiter{maxPerIP: s.MaxConnsPerIP, queue: s.ConnLimitPolicy == connLimitQueue, metrics: s.metrics, perIP: make(map[string]*ipConns)}
+	if s.MaxConns > 0 {
+		l.slots = make(chan struct{}, s.MaxConns)
+	}
+	return l, nil
+}
+
+// listeners wraps each listener so the connections it accepts count against the limits.
+func (l *connLimiter) listeners(listeners []net.Listener) []net.Listener {
+	limited := make([]net.Listener, len(listeners))
+	for i, listener := range listeners {
+		limited[i] = &limitListener{Listener: listener, limiter: l, closed: make(chan struct{})}
+	}
+	return limited
+}
+
+// limitListener only hands out connections within the limits of its limiter.
+type limitListener struct {
+	net.Listener
+	limiter   *connLimiter
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		haveSlot := false
+		if l.limiter.queue && l.limiter.slots != nil {
+			if !l.limiter.waitForSlot(l.closed) {
+				return nil, net.ErrClosed
+			}
+			haveSlot = true
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if haveSlot {
+				l.limiter.releaseSlot()
+			}
+			return nil, err
+		}
+		if limited := l.limiter.admit(conn, haveSlot); limited != nil {
+			return limited, nil
+		}
+		conn.Close()
+	}
+}
+
+func (l *limitListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// waitForSlot takes a slot for the next connection, waiting for one to free up if MaxConns are open.
+// It reports false if closed is closed first.
+func (l *connLimiter) waitForSlot(closed <-chan struct{}) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	l.mu.Lock()
+	if !l.atLimit {
+		l.atLimit = true
+		logWarnf("%d connections open (--max-conns), new connections wait in the accept queue", cap(l.slots))
+	}
+	l.mu.Unlock()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-closed:
+		return false
+	}
+}
+
+// releaseSlot frees the slot of a closed connection.
+func (l *connLimiter) releaseSlot() {
+	<-l.slots
+	l.mu.Lock()
+	if l.atLimit {
+		l.atLimit = false
+		logInfof("Below --max-conns again, accepting connections")
+	}
+	l.mu.Unlock()
+}
+
+// admit returns conn wrapped to release its share of the limits when closed, or nil if it is over a
+// limit. haveSlot tells whether the caller already took its slot, which is released on refusal.
+func (l *connLimiter) admit(conn net.Conn, haveSlot bool) net.Conn {
+	ip := connIP(conn)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !haveSlot && l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		default:
+			l.metrics.refusedConns.WithLabelValues("max-conns").Inc()
+			if !l.atLimit {
+				l.atLimit = true
+				logWarnf("%d connections open (--max-conns), refusing new connections, starting with %s", cap(l.slots), conn.RemoteAddr())
+			}
+			return nil
+		}
+	}
+	if ip != "" && l.maxPerIP > 0 {
+		counts := l.perIP[ip]
+		if counts == nil {
+			counts = &ipConns{}
+			l.perIP[ip] = counts
+		}
+		if counts.open >= l.maxPerIP {
+			if l.slots != nil {
+				<-l.slots
+			}
+			l.metrics.refusedConns.WithLabelValues("max-conns-per-ip").Inc()
+			if !counts.atLimit {
+				counts.atLimit = true
+				logWarnf("%d connections open from %s (--max-conns-per-ip), refusing more from it", counts.open, ip)
+			}
+			return nil
+		}
+		counts.open++
+	}
+	return &limitedConn{Conn: conn, limiter: l, ip: ip}
+}
+
+// release frees the share of the limits held by a connection from ip.
+func (l *connLimiter) release(ip string) {
+	if ip != "" && l.maxPerIP > 0 {
+		l.mu.Lock()
+		if counts := l.perIP[ip]; counts != nil {
+			if counts.open--; counts.open == 0 {
+				delete(l.perIP, ip)
+			} else {
+				counts.atLimit = false
+			}
+		}
+		l.mu.Unlock()
+	}
+	if l.slots != nil {
+		l.releaseSlot()
+	}
+}
+
+// limitedConn releases its share of the limits when closed.
+type limitedConn struct {
+	net.Conn
+	limiter   *connLimiter
+	ip        string
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() { c.limiter.release(c.ip) })
+	return err
+}
+
+// connIP returns the IP a connection comes from, or "" if it is not a TCP connection (e.g. a Unix socket).
+func connIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
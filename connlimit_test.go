@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newKeepAliveClient returns a client of the test PKI that has sent one request, keeping its connection open.
+func newKeepAliveClient(t *testing.T, pki *testPKI, baseURL string) *Client {
+	t.Helper()
+	client := newTestClient(t, pki, baseURL)
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the first connection to be accepted, got %d (%v)", status, err)
+	}
+	t.Cleanup(client.httpClient.CloseIdleConnections)
+	return client
+}
+
+func TestMaxConnsRefuse(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		configure func(*Server)
+		reason    string
+	}{
+		{"max-conns", func(s *Server) { s.MaxConns = 1 }, "max-conns"},
+		{"max-conns-per-ip", func(s *Server) { s.MaxConnsPerIP = 1 }, "max-conns-per-ip"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pki := newTestPKI(t)
+			metricsAddr := freeAddr(t)
+			_, baseURL := startTestServer(t, pki, func(s *Server) {
+				s.MetricsAddr = metricsAddr
+				tc.configure(s)
+			})
+			first := newKeepAliveClient(t, pki, baseURL)
+
+			second, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := second.SendRequest(); err == nil {
+				t.Fatal("Expected a second connection to be refused")
+			}
+			if metrics := scrapeMetrics(t, metricsAddr); !strings.Contains(metrics, `tls_playground_refused_connections_total{reason="`+tc.reason+`"} 1`) {
+				t.Errorf("Expected the refusal to be counted under %s:\n%s", tc.reason, metrics)
+			}
+
+			first.httpClient.CloseIdleConnections()
+			waitForStatus(t, second, http.StatusOK)
+		})
+	}
+}
+
+func TestMaxConnsQueue(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.MaxConns, s.ConnLimitPolicy = 1, connLimitQueue })
+	first := newKeepAliveClient(t, pki, baseURL)
+
+	second, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := second.SendRequest()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the second connection to wait in the accept queue, it finished with %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	first.httpClient.CloseIdleConnections()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the queued connection to be served once the first closed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the queued connection to be accepted")
+	}
+}
+
+func TestConnLimitPolicyValidation(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.MaxConns, server.ConnLimitPolicy = 1, "drop"
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "unknown connection limit policy") {
+		server.Stop()
+		t.Errorf("Expected an unknown policy to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Connection Statistics ---
+//
+// The HTTPS listener hands out trackedConns, which count the bytes read and written on the raw TCP
+// connection (TLS records included). The connection tracker follows each one through its life:
+// GetConfigForClient and VerifyConnection time the handshake and note the client, http.Server.ConnState
+// reports when it turns active, idle and closed. Open connections are listed on the admin API at
+// GET /connections; totals go to the metrics (bytes, connection lifetimes and idle periods). Only the
+// HTTPS mode is tracked, and a connection leaves the tracker when it is hijacked (e.g. by /ws).
+
+const connectionsAdminPath = "/connections"
+
+// connTracker keeps the open tracked connections.
+type connTracker struct {
+	metrics *serverMetrics
+	nextID  atomic.Uint64
+	mu      sync.Mutex
+	conns   map[*trackedConn]struct{}
+}
+
+func newConnTracker(metrics *serverMetrics) *connTracker {
+	return &connTracker{metrics: metrics, conns: make(map[*trackedConn]struct{})}
+}
+
+// trackedConn is a raw connection accepted by a tracking listener.
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+	id      uint64
+	opened  time.Time
+	read    atomic.Int64
+	written atomic.Int64
+
+	mu             sync.Mutex
+	state          http.ConnState
+	handshakeStart time.Time
+	handshake      time.Duration // Set once the client certificate has been verified
+	cn             string
+	tlsVersion     uint16
+	resumed        bool
+	idleSince      time.Time
+	idleTotal      time.Duration
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.read.Add(int64(n))
+	c.tracker.metrics.connectionBytes.WithLabelValues("received").Add(float64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(int64(n))
+	c.tracker.metrics.connectionBytes.WithLabelValues("sent").Add(float64(n))
+	return n, err
+}
+
+// trackingListener wraps the connections it accepts in trackedConns.
+type trackingListener struct {
+	net.Listener
+	tracker *connTracker
+}
+
+func (l trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{Conn: conn, tracker: l.tracker, id: l.tracker.nextID.Add(1), opened: time.Now()}, nil
+}
+
+// listener returns a listener that tracks the connections accepted by l.
+func (t *connTracker) listener(l net.Listener) net.Listener {
+	return trackingListener{Listener: l, tracker: t}
+}
+
+// trackedConnOf returns the trackedConn under conn (as passed to ConnState or in a ClientHelloInfo),
+// or nil if it was not accepted by a tracking listener.
+func trackedConnOf(conn net.Conn) *trackedConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tc, _ := conn.(*trackedConn)
+	return tc
+}
+
+// instrumentHandshakes records the handshake time, client CN and TLS version of tracked connections.
+// Like serverMetrics.instrumentHandshakes, it only sees handshakes that pass client certificate
+// verification.
+func (t *connTracker) instrumentHandshakes(cfg *tls.Config) {
+	mtls.WrapVerifyConnection(cfg, func(hello *tls.ClientHelloInfo) func(tls.ConnectionState) error {
+		tc := trackedConnOf(hello.Conn)
+		if tc == nil { // e.g. ServerConn
+			return nil
+		}
+		tc.mu.Lock()
+		tc.handshakeStart = time.Now()
+		tc.mu.Unlock()
+		return func(cs tls.ConnectionState) error {
+			tc.mu.Lock()
+			tc.handshake = time.Since(tc.handshakeStart)
+			if len(cs.PeerCertificates) > 0 {
+				tc.cn = cs.PeerCertificates[0].Subject.CommonName
+			}
+			tc.tlsVersion, tc.resumed = cs.Version, cs.DidResume
+			tc.mu.Unlock()
+			return nil
+		}
+	})
+}
+
+// track follows a connection through its http.ConnState changes.
+func (t *connTracker) track(conn net.Conn, state http.ConnState) {
+	tc := trackedConnOf(conn)
+	if tc == nil {
+		return
+	}
+	now := time.Now()
+	tc.mu.Lock()
+	if tc.state == http.StateIdle && state != http.StateIdle {
+		idle := now.Sub(tc.idleSince)
+		tc.idleTotal += idle
+		t.metrics.connectionIdle.Observe(idle.Seconds())
+	}
+	tc.state = state
+	if state == http.StateIdle {
+		tc.idleSince = now
+	}
+	tc.mu.Unlock()
+
+	switch state {
+	case http.StateNew:
+		t.mu.Lock()
+		t.conns[tc] = struct{}{}
+		t.mu.Unlock()
+	case http.StateClosed, http.StateHijacked:
+		t.mu.Lock()
+		delete(t.conns, tc)
+		t.mu.Unlock()
+		t.metrics.connectionDuration.Observe(now.Sub(tc.opened).Seconds())
+	}
+}
+
+// connStats is one open connection as listed on the admin API.
+type connStats struct {
+	ID              uint64    `json:"id"`
+	RemoteAddr      string    `json:"remote_addr"`
+	LocalAddr       string    `json:"local_addr"`
+	CN              string    `json:"cn,omitempty"`
+	TLSVersion      string    `json:"tls_version,omitempty"`
+	Resumed         bool      `json:"resumed"`
+	State           string    `json:"state"`
+	Opened          time.Time `json:"opened"`
+	HandshakeMillis float64   `json:"handshake_ms"`
+	BytesReceived   int64     `json:"bytes_received"`
+	BytesSent       int64     `json:"bytes_sent"`
+	IdleMillis      float64   `json:"idle_ms"` // Of the current idle period, 0 unless idle
+	IdleTotalMillis float64   `json:"idle_total_ms"`
+}
+
+// list returns the open connections, oldest first.
+func (t *connTracker) list() []connStats {
+	t.mu.Lock()
+	conns := make([]*trackedConn, 0, len(t.conns))
+	for tc := range t.conns {
+		conns = append(conns, tc)
+	}
+	t.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+
+	now := time.Now()
+	stats := make([]connStats, len(conns))
+	for i, tc := range conns {
+		tc.mu.Lock()
+		stats[i] = connStats{
+			ID:              tc.id,
+			RemoteAddr:      tc.RemoteAddr().String(),
+			LocalAddr:       tc.LocalAddr().String(),
+			CN:              tc.cn,
+			Resumed:         tc.resumed,
+			State:           tc.state.String(),
+			Opened:          tc.opened,
+			HandshakeMillis: millis(tc.handshake),
+			BytesReceived:   tc.read.Load(),
+			BytesSent:       tc.written.Load(),
+			IdleTotalMillis: millis(tc.idleTotal),
+		}
+		if tc.tlsVersion != 0 {
+			stats[i].TLSVersion = tlsVersionName(tc.tlsVersion)
+		}
+		if tc.state == http.StateIdle {
+			idle := now.Sub(tc.idleSince)
+			stats[i].IdleMillis = millis(idle)
+			stats[i].IdleTotalMillis += millis(idle)
+		}
+		tc.mu.Unlock()
+	}
+	return stats
+}
+
+// connState is the http.Server.ConnState callback of the HTTPS server.
+func (s *Server) connState(conn net.Conn, state http.ConnState) {
+	s.metrics.trackConnState(conn, state)
+	s.conns.track(conn, state)
+}
+
+// adminConnectionsHandler lists the open HTTPS connections.
+func (s *Server) adminConnectionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.conns.list())
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForConnections polls the admin API until the open connections satisfy ok.
+func waitForConnections(t *testing.T, adminAddr string, ok func([]connStats) bool) []connStats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var conns []connStats
+		if status := adminRequest(t, http.MethodGet, "http://"+adminAddr+connectionsAdminPath, "", &conns); status != http.StatusOK {
+			t.Fatalf("Expected 200 listing connections, got %d", status)
+		}
+		if ok(conns) {
+			return conns
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for the connections, last got %+v", conns)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConnectionStats(t *testing.T) {
+	pki := newTestPKI(t)
+	adminAddr, metricsAddr := freeAddr(t), freeAddr(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.AdminAddr, s.MetricsAddr = adminAddr, metricsAddr })
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := client.SendRequest(); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	}
+
+	conns := waitForConnections(t, adminAddr, func(conns []connStats) bool { return len(conns) == 1 && conns[0].State == "idle" })
+	conn := conns[0]
+	if conn.CN != pki.ClientCN || conn.TLSVersion == "" || conn.HandshakeMillis <= 0 {
+		t.Errorf("Expected the handshake of %s to be recorded, got %+v", pki.ClientCN, conn)
+	}
+	if conn.BytesReceived == 0 || conn.BytesSent == 0 || conn.IdleTotalMillis < conn.IdleMillis {
+		t.Errorf("Expected traffic and idle time to be counted, got %+v", conn)
+	}
+
+	client.httpClient.CloseIdleConnections()
+	waitForConnections(t, adminAddr, func(conns []connStats) bool { return len(conns) == 0 })
+	metrics := scrapeMetrics(t, metricsAddr)
+	for _, want := range []string{
+		"tls_playground_connection_duration_seconds_count 1",
+		"tls_playground_connection_idle_seconds_count ", // HTTP/2 also reports the connection idle between streams
+		`tls_playground_connection_bytes_total{direction="received"}`,
+		`tls_playground_connection_bytes_total{direction="sent"}`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, metrics)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- Degraded Mode ---
+//
+// With DegradeOnReloadFailure, a failed known clients reload puts the server into a degraded
+// state instead of silently carrying on with the previous entries: the listener stays up, but
+// every request is answered with 503 and a Retry-After header. The reload is retried in the
+// background every ReloadRetryInterval, and the server recovers as soon as one succeeds.
+
+const defaultReloadRetryInterval = 10 * time.Second
+
+// degradedState records why the server is degraded, if it is.
+type degradedState struct {
+	mu       sync.Mutex
+	reason   string
+	since    time.Time
+	retrying bool
+}
+
+// Degraded reports whether the server is degraded, and why.
+func (s *Server) Degraded() (bool, string) {
+	s.degraded.mu.Lock()
+	defer s.degraded.mu.Unlock()
+	return s.degraded.reason != "", s.degraded.reason
+}
+
+// enterDegraded marks the server degraded and starts retrying the reload, if not already doing so.
+func (s *Server) enterDegraded(err error) {
+	s.degraded.mu.Lock()
+	defer s.degraded.mu.Unlock()
+	if s.degraded.reason == "" {
+		s.degraded.since = time.Now()
+		logErrorf("Entering degraded mode, serving 503 until the known clients reload succeeds: %v", err)
+	}
+	s.degraded.reason = err.Error()
+	if !s.degraded.retrying {
+		s.degraded.retrying = true
+		go s.retryReload()
+	}
+}
+
+// leaveDegraded clears the degraded state after a successful reload.
+func (s *Server) leaveDegraded() {
+	s.degraded.mu.Lock()
+	defer s.degraded.mu.Unlock()
+	if s.degraded.reason != "" {
+		logInfof("Leaving degraded mode after %s", time.Since(s.degraded.since).Round(time.Second))
+		s.degraded.reason = ""
+	}
+}
+
+// retryReload reloads the known clients every ReloadRetryInterval until it succeeds or the server stops.
+func (s *Server) retryReload() {
+	ticker := time.NewTicker(s.ReloadRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+		case <-ticker.C:
+			if s.ReloadKnownClients() != nil {
+				continue
+			}
+		}
+		s.degraded.mu.Lock()
+		s.degraded.retrying = false
+		s.degraded.mu.Unlock()
+		return
+	}
+}
+
+// rejectWhenDegraded answers 503 with Retry-After while the server is degraded.
+func (s *Server) rejectWhenDegraded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if degraded, reason := s.Degraded(); degraded {
+			logWarnf("Degraded: rejecting request from %s for %s", peerCN(r), r.URL.Path)
+			retryAfter := int((s.ReloadRetryInterval + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(w, "service degraded: "+reason, http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
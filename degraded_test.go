@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestDegradedModeAfterFailedReload(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.Strict = true
+		s.DegradeOnReloadFailure = true
+		s.ReloadRetryInterval = 100 * time.Millisecond
+	})
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good, err := ioutil.ReadFile(pki.KnownClientsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pki.KnownClientsFile, append(good, []byte("not-a-valid-line\n")...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err == nil {
+		t.Fatal("Expected the strict reload of a malformed file to fail")
+	}
+
+	resp, err := client.httpClient.Get(baseURL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("Expected 503 with Retry-After while degraded, got %d (Retry-After %q)", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	// Fixing the file is picked up by the background retry.
+	if err := ioutil.WriteFile(pki.KnownClientsFile, good, 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if degraded, _ := server.Degraded(); !degraded {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not recover after the known clients file was fixed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected 200 after recovery, got %d (%v)", status, err)
+	}
+}
+
+func TestFailedReloadWithoutDegradeKeepsServing(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, func(s *Server) { s.Strict = true })
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte("not-a-valid-line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err == nil {
+		t.Fatal("Expected the strict reload of a malformed file to fail")
+	}
+
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the previous entries to keep serving, got %d (%v)", status, err)
+	}
+}
+
+func TestStrictKnownClients(t *testing.T) {
+	pki := newTestPKI(t)
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte("broken\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{}); err != nil {
+		t.Errorf("Expected a lenient load to skip the invalid line, got %v", err)
+	}
+	if _, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{Strict: true}); err == nil {
+		t.Error("Expected a strict load to fail on the invalid line")
+	}
+}
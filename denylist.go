@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Denied Clients ---
+//
+// DeniedClientsFile blocks clients before any other check, so a compromised client is locked out even
+// while its known clients entry, a CA or TOFU would still let it in. Each line denies one certificate
+// fingerprint (as in known clients files), one public key (spki:<fingerprint>, which also blocks
+// certificates re-issued for the key) or one CN (cn:<name>); # starts a comment. The file is reloaded on
+// SIGHUP and, with WatchDeniedClients, when polling notices that it changed. A file that fails to load
+// leaves the current list in place.
+
+// defaultWatchDeniedClients is how often the denied clients file is polled for changes by default.
+const defaultWatchDeniedClients = 5 * time.Second
+
+// deniedCNPrefix marks a denied clients entry that blocks a CN rather than a certificate.
+const deniedCNPrefix = "cn:"
+
+// deniedSet is one version of the denied clients file.
+type deniedSet struct {
+	certs map[string]bool // Certificate fingerprints, see mtls.CertFingerprint
+	keys  map[string]bool // Public key fingerprints, see mtls.KeyFingerprint
+	cns   map[string]bool
+}
+
+// size returns the number of entries.
+func (d *deniedSet) size() int {
+	return len(d.certs) + len(d.keys) + len(d.cns)
+}
+
+// deniedClients holds the current denied clients list.
+type deniedClients struct {
+	file    string
+	current atomic.Pointer[deniedSet]
+}
+
+// loadDeniedClients loads the denied clients file.
+func loadDeniedClients(file string) (*deniedClients, error) {
+	d := &deniedClients{file: file}
+	if err := d.reload(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// reload replaces the current list with the one on disk, keeping the current list on error.
+func (d *deniedClients) reload() error {
+	content, err := ioutil.ReadFile(d.file)
+	if err != nil {
+		return fmt.Errorf("failed to read denied clients %s: %w", d.file, err)
+	}
+	set, err := parseDeniedClients(content)
+	if err != nil {
+		return fmt.Errorf("denied clients %s: %w", d.file, err)
+	}
+	d.current.Store(set)
+	return nil
+}
+
+// parseDeniedClients parses the lines of a denied clients file.
+func parseDeniedClients(content []byte) (*deniedSet, error) {
+	set := &deniedSet{certs: make(map[string]bool), keys: make(map[string]bool), cns: make(map[string]bool)}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		entry := scanner.Text()
+		if i := strings.Index(entry, "#"); i >= 0 {
+			entry = entry[:i]
+		}
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case hasPrefixFold(entry, deniedCNPrefix):
+			cn := strings.TrimSpace(entry[len(deniedCNPrefix):])
+			if cn == "" {
+				return nil, fmt.Errorf("line %d: empty CN", line)
+			}
+			set.cns[cn] = true
+		case hasPrefixFold(entry, mtls.SPKIEntryPrefix):
+			hash, err := mtls.ParseKeyFingerprint(entry[len(mtls.SPKIEntryPrefix):])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			set.keys[mtls.ColonHex(hash)] = true
+		default:
+			hash, err := mtls.ParseKeyFingerprint(entry) // Certificate fingerprints have the same formats
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w (or spki:<fingerprint>, or cn:<name>)", line, err)
+			}
+			set.certs[mtls.ColonHex(hash)] = true
+		}
+	}
+	return set, scanner.Err()
+}
+
+// hasPrefixFold reports whether s starts with prefix, ignoring case.
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// deny returns why cert is denied, or "" if it isn't.
+func (d *deniedClients) deny(cert *x509.Certificate) string {
+	set := d.current.Load()
+	switch {
+	case set.certs[mtls.CertFingerprint(cert)]:
+		return "its certificate fingerprint"
+	case set.keys[mtls.KeyFingerprint(cert)]:
+		return "its public key"
+	case set.cns[cert.Subject.CommonName]:
+		return "its CN"
+	}
+	return ""
+}
+
+// deniedCheck rejects client certificates on the denied clients list.
+func deniedCheck(denied *deniedClients) CertCheck {
+	return CertCheck{Name: "denied", Check: func(cert *x509.Certificate) error {
+		if by := denied.deny(cert); by != "" {
+			return fmt.Errorf("client certificate for CN '%s' is denied by %s in %s", cert.Subject.CommonName, by, denied.file)
+		}
+		return nil
+	}}
+}
+
+// ReloadDeniedClients re-reads DeniedClientsFile without restarting the server. On error the current
+// list stays active.
+func (s *Server) ReloadDeniedClients() error {
+	if s.denied == nil {
+		return errors.New("no denied clients loaded")
+	}
+	if err := s.denied.reload(); err != nil {
+		return fmt.Errorf("failed to reload denied clients: %w", err)
+	}
+	logInfof("Reloaded denied clients %s (%d entries)", s.DeniedClientsFile, s.denied.current.Load().size())
+	return nil
+}
+
+// watchDeniedClients reloads the denied clients whenever the stamp of their file changes, until the
+// server stops.
+func (s *Server) watchDeniedClients() {
+	last, _ := statFileStamp(s.DeniedClientsFile)
+	ticker := time.NewTicker(s.WatchDeniedClients)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		stamp, err := statFileStamp(s.DeniedClientsFile)
+		if err != nil || (stamp.modTime.Equal(last.modTime) && stamp.size == last.size) {
+			continue
+		}
+		last = stamp
+		logInfof("Denied clients %s changed, reloading", s.DeniedClientsFile)
+		if err := s.ReloadDeniedClients(); err != nil {
+			logErrorf("%v", err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestParseDeniedClients(t *testing.T) {
+	fingerprint := strings.Repeat("AB:", 31) + "AB"
+	set, err := parseDeniedClients([]byte("# compromised 2024-05-01\n" + fingerprint + "\nSPKI:" + strings.Repeat("CD", 32) + "  # rotated key\ncn: build-agent\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.certs[fingerprint] || !set.keys[strings.Repeat("CD:", 31)+"CD"] || !set.cns["build-agent"] || set.size() != 3 {
+		t.Errorf("Unexpected denied set %+v", set)
+	}
+
+	for _, content := range []string{"cn:\n", "spki:zz\n", "not-a-fingerprint\n"} {
+		if _, err := parseDeniedClients([]byte(content)); err == nil || !strings.Contains(err.Error(), "line 1") {
+			t.Errorf("Expected %q to be rejected with its line number, got %v", content, err)
+		}
+	}
+}
+
+func TestDeniedClients(t *testing.T) {
+	pki := newTestPKI(t)
+	deniedFile := filepath.Join(pki.Dir, "denied.txt")
+	if err := ioutil.WriteFile(deniedFile, []byte("# nobody yet\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.DeniedClientsFile = deniedFile
+		s.WatchDeniedClients = 0
+		s.VerifyAudit = true
+		s.TOFU = true
+	})
+	client := newTestClient(t, pki, baseURL)
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the known client to be accepted, got %d (%v)", status, err)
+	}
+
+	// Neither the known clients entry, audit mode nor TOFU lets a denied client in, whichever way it is denied.
+	for _, entry := range []string{mtls.CertFingerprint(cert), mtls.SPKIEntryPrefix + mtls.KeyFingerprint(cert), deniedCNPrefix + pki.ClientCN} {
+		if err := ioutil.WriteFile(deniedFile, []byte(entry+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.ReloadDeniedClients(); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := newTestClient(t, pki, baseURL).SendRequest(); err == nil {
+			t.Errorf("Expected the client to be denied by %q", entry)
+		}
+	}
+
+	if err := ioutil.WriteFile(deniedFile, []byte("garbage\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadDeniedClients(); err == nil {
+		t.Error("Expected a malformed denied clients file to fail to reload")
+	}
+	if _, _, err := newTestClient(t, pki, baseURL).SendRequest(); err == nil {
+		t.Error("Expected the previous list to stay active after a failed reload")
+	}
+}
+
+func TestWatchDeniedClientsReloadsOnChange(t *testing.T) {
+	pki := newTestPKI(t)
+	deniedFile := filepath.Join(pki.Dir, "denied.txt")
+	if err := ioutil.WriteFile(deniedFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.DeniedClientsFile = deniedFile
+		s.WatchDeniedClients = 20 * time.Millisecond
+	})
+	client := newTestClient(t, pki, baseURL)
+	waitForStatus(t, client, http.StatusOK)
+
+	if err := ioutil.WriteFile(deniedFile, []byte(deniedCNPrefix+pki.ClientCN+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, _, err := newTestClient(t, pki, baseURL).SendRequest(); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the watched denied clients file to block the client")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
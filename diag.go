@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// --- Rejection Diagnostics ---
+//
+// A rejected client only sees a generic TLS bad-certificate alert. The server keeps a small
+// ring buffer of recent rejections, each with a short-lived token that is logged, so an operator
+// (or the client, given the token) can find out which check failed. The listener needs no client
+// certificate, so a rejection is only served for its token: listing them, e.g. by caller IP, would show
+// the identities of clients behind the same NAT or proxy and why they failed.
+
+const (
+	rejectionLogSize = 128
+	rejectionTTL     = 10 * time.Minute
+)
+
+// rejection is one entry of the rejection log.
+type rejection struct {
+	Token       string    `json:"token"`
+	Time        time.Time `json:"time"`
+	RemoteAddr  string    `json:"remote_addr"`
+	CN          string    `json:"cn"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Check       string    `json:"check,omitempty"`
+	Reason      string    `json:"reason"`
+}
+
+// rejectionLog is a fixed-size ring buffer of recent rejections.
+type rejectionLog struct {
+	mu      sync.Mutex
+	entries []rejection
+	next    int
+	ttl     time.Duration
+	now     func() time.Time
+}
+
+func newRejectionLog(size int, ttl time.Duration) *rejectionLog {
+	return &rejectionLog{entries: make([]rejection, 0, size), ttl: ttl, now: time.Now}
+}
+
+// Record stores a denied decision and returns its diagnostic token. Allowed decisions are ignored.
+func (l *rejectionLog) Record(d authDecision) string {
+	if d.Allowed {
+		return ""
+	}
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		logErrorf("Failed to generate diagnostic token: %v", err)
+		return ""
+	}
+	entry := rejection{
+		Token:       hex.EncodeToString(buf),
+		Time:        l.now(),
+		RemoteAddr:  d.RemoteAddr,
+		CN:          d.CN,
+		Fingerprint: d.Fingerprint,
+		Check:       d.Check,
+		Reason:      d.Reason,
+	}
+
+	l.mu.Lock()
+	if len(l.entries) < cap(l.entries) {
+		l.entries = append(l.entries, entry)
+	} else {
+		l.entries[l.next] = entry
+	}
+	l.next = (l.next + 1) % cap(l.entries)
+	l.mu.Unlock()
+
+	logAuth(levelInfo, "Recorded rejection diagnostics", append(decisionAttrs(d), slog.String("token", entry.Token))...)
+	return entry.Token
+}
+
+// Lookup returns the unexpired rejection with the given token.
+func (l *rejectionLog) Lookup(token string) (rejection, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range l.entries {
+		if e.Token == token && l.fresh(e) {
+			return e, true
+		}
+	}
+	return rejection{}, false
+}
+
+func (l *rejectionLog) fresh(e rejection) bool {
+	return l.now().Sub(e.Time) <= l.ttl
+}
+
+// diagRejectionsHandler serves the rejection of ?token= without requiring a client certificate.
+func (s *Server) diagRejectionsHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "pass the diagnostic token from the server log as ?token="})
+		return
+	}
+	entry, ok := s.rejections.Lookup(token)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown or expired token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
+
+// healthzPath is served on the diagnostics listener, so health checks don't need a client certificate.
+const healthzPath = "/healthz"
+
+// healthzHandler answers 200 while the server is serving, and 503 while it is starting, degraded or stopping.
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	select {
+	case <-s.stopped:
+		http.Error(w, "stopping", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	select {
+	case <-s.ready:
+	default:
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+	if degraded, reason := s.Degraded(); degraded {
+		http.Error(w, "degraded: "+reason, http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// startDiagServer starts the plain-HTTP diagnostics listener if DiagAddr is set.
+func (s *Server) startDiagServer() error {
+	if s.DiagAddr == "" {
+		return nil
+	}
+	if err := validateAddr(s.DiagAddr); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/diag/rejections", s.diagRejectionsHandler)
+	mux.HandleFunc(healthzPath, s.healthzHandler)
+	listener, err := net.Listen("tcp", s.DiagAddr)
+	if err != nil {
+		return err
+	}
+	s.diagServer = &http.Server{Handler: mux, ErrorLog: newLevelLogger(levelError)}
+	logInfof("Serving rejection diagnostics on http://%s/diag/rejections and health on http://%s%s", listener.Addr(), listener.Addr(), healthzPath)
+	go func() {
+		if err := s.diagServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErrorf("Diagnostics server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopDiagServer shuts down the diagnostics listener, if running.
+func (s *Server) stopDiagServer(ctx context.Context) error {
+	if s.diagServer == nil {
+		return nil
+	}
+	return s.diagServer.Shutdown(ctx)
+}
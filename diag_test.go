@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRejectionLogLookup(t *testing.T) {
+	l := newRejectionLog(4, time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	if token := l.Record(authDecision{Allowed: true, CN: "ok"}); token != "" {
+		t.Errorf("Expected allowed decisions to be ignored, got token %q", token)
+	}
+	token := l.Record(authDecision{RemoteAddr: "10.0.0.1:5000", CN: "bad", Check: "known-client", Reason: "client CN 'bad' not authorized"})
+	if token == "" {
+		t.Fatal("Expected a token for a rejection")
+	}
+
+	entry, ok := l.Lookup(token)
+	if !ok || entry.CN != "bad" || entry.Check != "known-client" {
+		t.Fatalf("Unexpected lookup result: %+v, %v", entry, ok)
+	}
+	if _, ok := l.Lookup("nope"); ok {
+		t.Error("Expected unknown token lookup to fail")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := l.Lookup(token); ok {
+		t.Error("Expected expired token lookup to fail")
+	}
+}
+
+// latestRejection returns the most recently recorded rejection of l.
+func latestRejection(t *testing.T, l *rejectionLog) rejection {
+	t.Helper()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) == 0 {
+		t.Fatal("Expected a recorded rejection")
+	}
+	return l.entries[(l.next-1+len(l.entries))%len(l.entries)]
+}
+
+func TestRejectionLogRing(t *testing.T) {
+	l := newRejectionLog(3, time.Minute)
+	var tokens []string
+	for i := 0; i < 5; i++ {
+		tokens = append(tokens, l.Record(authDecision{RemoteAddr: fmt.Sprintf("10.0.0.%d:%d", i%2, 1000+i), CN: fmt.Sprintf("c%d", i)}))
+	}
+
+	// Only c2, c3 and c4 remain.
+	if _, ok := l.Lookup(tokens[1]); ok {
+		t.Error("Expected the overwritten rejection to be gone")
+	}
+	if entry, ok := l.Lookup(tokens[2]); !ok || entry.CN != "c2" {
+		t.Errorf("Expected c2 to remain, got %+v", entry)
+	}
+	if latest := latestRejection(t, l); latest.CN != "c4" {
+		t.Errorf("Expected c4 as the latest rejection, got %+v", latest)
+	}
+}
+
+func TestDiagRejectionsEndpoint(t *testing.T) {
+	pki := newTestPKI(t)
+	diagAddr := freeAddr(t)
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.DiagAddr = diagAddr
+	})
+
+	unknownCert := filepath.Join(pki.Dir, "unknown.crt")
+	unknownKey := filepath.Join(pki.Dir, "unknown.key")
+	pki.newClientCert(t, "intruder", unknownCert, unknownKey)
+	intruder, err := NewClient(baseURL+"/hello", pki.ServerCertFile, unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := intruder.SendRequest(); err == nil {
+		t.Fatal("Expected unknown client to be rejected")
+	}
+
+	// Without the token from the server log, nothing is listed.
+	resp, err := http.Get("http://" + diagAddr + "/diag/rejections")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a token, got %d", resp.StatusCode)
+	}
+
+	token := latestRejection(t, server.rejections).Token
+	resp, err = http.Get("http://" + diagAddr + "/diag/rejections?token=" + token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var entry rejection
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || entry.Token != token || entry.CN != "intruder" || entry.Check != "known-client" {
+		t.Errorf("Unexpected token lookup: %d %+v", resp.StatusCode, entry)
+	}
+
+	resp, err = http.Get("http://" + diagAddr + "/diag/rejections?token=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown token, got %d", resp.StatusCode)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	pki := newTestPKI(t)
+	diagAddr := freeAddr(t)
+	server, _ := startTestServer(t, pki, func(s *Server) {
+		s.DiagAddr = diagAddr
+		s.Strict = true
+		s.DegradeOnReloadFailure = true
+		s.ReloadRetryInterval = time.Hour
+	})
+
+	// No client certificate is needed.
+	healthz := func() int {
+		t.Helper()
+		resp, err := http.Get("http://" + diagAddr + healthzPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := healthz(); status != http.StatusOK {
+		t.Fatalf("Expected 200 from a ready server, got %d", status)
+	}
+
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte("not-a-valid-line\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server.ReloadKnownClients()
+	if status := healthz(); status != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 from a degraded server, got %d", status)
+	}
+}
+
+func TestHealthzBeforeStart(t *testing.T) {
+	server := NewServer("localhost:0", "", "", "")
+	rec := httptest.NewRecorder()
+	server.healthzHandler(rec, httptest.NewRequest(http.MethodGet, healthzPath, nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 before the server is ready, got %d", rec.Code)
+	}
+}
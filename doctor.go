@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Setup Doctor ---
+//
+// doctor checks a server and client setup without starting either: the configured files exist, the
+// certificates and keys parse and belong together, the certificates are within their validity period,
+// the known clients file is valid and lists the client certificate, and the server certificate covers
+// the host of --url. Finally a temporary server and client run the mTLS handshake over a loopback
+// connection on a free port (see conn.go). Every check runs unless one it depends on failed, in
+// which case it is skipped, and the outcome is printed as a checklist.
+
+// DoctorCmd defines the kong command that validates the setup.
+type DoctorCmd struct {
+	ServerCertFile string `kong:"name='server-cert',help='Server certificate file.',default='certs/server.crt',type='path'"`
+	ServerKeyFile  string `kong:"name='server-key',help='Server private key file.',default='certs/server.key',type='path'"`
+	CertFile       string `kong:"name='cert',help='Client certificate file, or a .p12/.pfx bundle.',default='certs/client.crt',type='path'"`
+	KeyFile        string `kong:"name='key',help='Client private key file (ignored with a .p12/.pfx bundle).',default='certs/client.key',type='path'"`
+	KnownClients   string `kong:"name='known-clients',help='Known clients file of the server.',default='certs/knownClients.txt',type='path'"`
+	ClientIDSource string `kong:"name='client-id-source',help='What identifies clients in the known clients file, as for the server.',enum='cn,dns-san,uri-san,email-san',default='cn'"`
+	ServerURL      string `kong:"name='url',help='URL the client connects to; the server certificate must be valid for its host.',default='https://localhost:8443/hello'"`
+	ExpiryWarnDays int    `kong:"name='expiry-warn-days',help='Warn about certificates expiring within this many days. 0 disables.',default='30'"`
+}
+
+// Run runs the checks and prints the checklist.
+func (d *DoctorCmd) Run() error {
+	checks := runDoctor(d, time.Now())
+	failed := 0
+	for _, c := range checks {
+		outputf("[%s] %s\n", c.Status, c.Name)
+		if c.Detail != "" {
+			outputf("       %s\n", c.Detail)
+		}
+		if c.Status == doctorFail {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	outputf("All checks passed.\n")
+	return nil
+}
+
+// Statuses of a doctor check.
+const (
+	doctorPass = "PASS"
+	doctorWarn = "WARN"
+	doctorFail = "FAIL"
+	doctorSkip = "SKIP"
+)
+
+// doctorCheck is one line of the checklist.
+type doctorCheck struct {
+	Name   string
+	Status string
+	Detail string // Why it failed, was skipped or deserves a warning
+}
+
+// doctorChecklist collects the outcome of the checks.
+type doctorChecklist []doctorCheck
+
+// check records a check that passed if err is nil, and reports whether it did.
+func (l *doctorChecklist) check(name string, err error) bool {
+	if err != nil {
+		*l = append(*l, doctorCheck{Name: name, Status: doctorFail, Detail: err.Error()})
+		return false
+	}
+	*l = append(*l, doctorCheck{Name: name, Status: doctorPass})
+	return true
+}
+
+// skip records a check that couldn't run because one it depends on failed.
+func (l *doctorChecklist) skip(name string) {
+	*l = append(*l, doctorCheck{Name: name, Status: doctorSkip, Detail: "skipped after an earlier failure"})
+}
+
+// runDoctor runs every check of the setup at time now.
+func runDoctor(d *DoctorCmd, now time.Time) []doctorCheck {
+	var l doctorChecklist
+	p12 := mtls.IsPKCS12File(d.CertFile)
+
+	files := []struct{ what, path string }{
+		{"Server certificate", d.ServerCertFile},
+		{"Server key", d.ServerKeyFile},
+		{"Client certificate", d.CertFile},
+		{"Client key", d.KeyFile},
+		{"Known clients file", d.KnownClients},
+	}
+	filesOK := true
+	for _, f := range files {
+		if p12 && f.what == "Client key" {
+			continue // In the bundle
+		}
+		filesOK = l.check(fmt.Sprintf("%s %s exists", f.what, f.path), fileExists(f.path)) && filesOK
+	}
+
+	serverCert := l.checkKeyPair("Server", func() (tls.Certificate, error) {
+		return mtls.LoadKeyPair(d.ServerCertFile, d.ServerKeyFile, keyPassphrase(d.ServerKeyFile))
+	})
+	clientCert := l.checkKeyPair("Client", func() (tls.Certificate, error) {
+		if p12 {
+			return mtls.LoadPKCS12(d.CertFile, keyPassphrase(d.CertFile))
+		}
+		return mtls.LoadKeyPair(d.CertFile, d.KeyFile, keyPassphrase(d.KeyFile))
+	})
+	l.checkValidity("Server", serverCert, d.ExpiryWarnDays, now)
+	l.checkValidity("Client", clientCert, d.ExpiryWarnDays, now)
+
+	host := ""
+	u, err := url.Parse(d.ServerURL)
+	if err == nil && u.Hostname() == "" {
+		err = fmt.Errorf("URL %q has no host", d.ServerURL)
+	}
+	if err == nil {
+		host = u.Hostname()
+	}
+	hostName := fmt.Sprintf("Server certificate is valid for %s", host)
+	switch {
+	case err != nil:
+		l.check("Client URL is valid", err)
+	case serverCert == nil:
+		l.skip(hostName)
+	default:
+		l.check(hostName, serverCert.VerifyHostname(host))
+	}
+
+	var store *mtls.FileStore
+	if fileExists(d.KnownClients) == nil {
+		if store, err = mtls.NewFileStore(d.KnownClients, mtls.FileStoreOptions{Strict: true}); err == nil {
+			l.check(fmt.Sprintf("Known clients file is valid (%d entries)", len(store.List())), nil)
+		} else {
+			l.check("Known clients file is valid", err)
+			store = nil
+		}
+	} else {
+		l.skip("Known clients file is valid")
+	}
+	listedName := "Client certificate is listed in the known clients file"
+	idSource := mtls.IdentitySource(d.ClientIDSource)
+	if store != nil && clientCert != nil {
+		id, err := idSource.ClientID(clientCert)
+		if err == nil {
+			listedName = fmt.Sprintf("Client %s '%s' is listed with its fingerprint", idSource.Label(), id)
+			err = mtls.FingerprintVerifier{Store: store, Identity: idSource}.Verify(clientCert)
+		}
+		l.check(listedName, err)
+	} else {
+		l.skip(listedName)
+	}
+
+	if filesOK && serverCert != nil && clientCert != nil && store != nil && host != "" {
+		l.check("Loopback mTLS handshake succeeds", doctorHandshake(d))
+	} else {
+		l.skip("Loopback mTLS handshake succeeds")
+	}
+	return l
+}
+
+// checkKeyPair loads a certificate with its key and returns the certificate, or nil if it fails.
+func (l *doctorChecklist) checkKeyPair(what string, load func() (tls.Certificate, error)) *x509.Certificate {
+	pair, err := load()
+	if err == nil && pair.Leaf == nil {
+		pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0])
+	}
+	if !l.check(what+" certificate and key parse and match", err) {
+		return nil
+	}
+	return pair.Leaf
+}
+
+// checkValidity checks that cert is within its validity period, and warns if it expires within warnDays.
+func (l *doctorChecklist) checkValidity(what string, cert *x509.Certificate, warnDays int, now time.Time) {
+	name := what + " certificate is within its validity period"
+	if cert == nil {
+		l.skip(name)
+		return
+	}
+	switch {
+	case now.After(cert.NotAfter):
+		l.check(name, fmt.Errorf("CN '%s' expired at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339)))
+	case now.Before(cert.NotBefore):
+		l.check(name, fmt.Errorf("CN '%s' is not valid before %s", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339)))
+	default:
+		left := cert.NotAfter.Sub(now)
+		l.check(fmt.Sprintf("%s (%d days left)", name, int(left.Hours()/24)), nil)
+		if warnDays > 0 && left < time.Duration(warnDays)*24*time.Hour {
+			(*l)[len(*l)-1].Status = doctorWarn
+			(*l)[len(*l)-1].Detail = fmt.Sprintf("expires at %s, renew it soon", cert.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// fileExists checks that path is an existing regular file.
+func fileExists(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return errors.New("is a directory")
+	}
+	return nil
+}
+
+// doctorHandshakeTimeout bounds the loopback handshake.
+const doctorHandshakeTimeout = 5 * time.Second
+
+// doctorHandshake runs the mTLS handshake between a temporary server and client over a loopback
+// connection and reports the error of the side that failed it.
+func doctorHandshake(d *DoctorCmd) error {
+	server := NewServer("", d.ServerCertFile, d.ServerKeyFile, d.KnownClients)
+	server.ClientIDSource = mtls.IdentitySource(d.ClientIDSource)
+	client, err := NewClient(d.ServerURL, d.ServerCertFile, d.CertFile, d.KeyFile)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	deadline := time.Now().Add(doctorHandshakeTimeout)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		conn.SetDeadline(deadline)
+		tlsConn, err := server.ServerConn(conn)
+		if err == nil {
+			tlsConn.Close()
+		}
+		serverErr <- err
+	}()
+	conn, err := net.DialTimeout("tcp", listener.Addr().String(), doctorHandshakeTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline)
+	tlsConn, clientErr := client.ClientConn(conn)
+	if clientErr == nil {
+		tlsConn.Close()
+	}
+	// Each side sees the other's rejection as a remote alert, so report the side that rejected
+	if err := <-serverErr; err != nil && (clientErr == nil || !strings.Contains(err.Error(), "remote error")) {
+		return err
+	}
+	return clientErr
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// doctorStatuses maps the checks of a doctor run to their status.
+func doctorStatuses(checks []doctorCheck) map[string]string {
+	statuses := make(map[string]string)
+	for _, c := range checks {
+		statuses[c.Name] = c.Status
+	}
+	return statuses
+}
+
+func newTestDoctor(pki *testPKI) *DoctorCmd {
+	return &DoctorCmd{
+		ServerCertFile: pki.ServerCertFile,
+		ServerKeyFile:  pki.ServerKeyFile,
+		CertFile:       pki.ClientCertFile,
+		KeyFile:        pki.ClientKeyFile,
+		KnownClients:   pki.KnownClientsFile,
+		ServerURL:      "https://localhost:8443/hello",
+		ExpiryWarnDays: 30,
+	}
+}
+
+func TestDoctorPassesOnValidSetup(t *testing.T) {
+	pki := newTestPKI(t)
+	checks := runDoctor(newTestDoctor(pki), time.Now())
+	for _, c := range checks {
+		if c.Status != doctorPass {
+			t.Errorf("Expected %q to pass, got %s: %s", c.Name, c.Status, c.Detail)
+		}
+	}
+	if statuses := doctorStatuses(checks); statuses["Loopback mTLS handshake succeeds"] != doctorPass {
+		t.Errorf("Expected the loopback handshake to run, got %v", statuses)
+	}
+}
+
+func TestDoctorReportsProblems(t *testing.T) {
+	pki := newTestPKI(t)
+	strangerCert, strangerKey := filepath.Join(pki.Dir, "stranger.crt"), filepath.Join(pki.Dir, "stranger.key")
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: "stranger", ValidFor: 10 * 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCertFiles(strangerCert, strangerKey, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+
+	d := newTestDoctor(pki)
+	d.CertFile, d.KeyFile = strangerCert, strangerKey
+	d.ServerURL = "https://example.com"
+	statuses := doctorStatuses(runDoctor(d, time.Now()))
+	for name, want := range map[string]string{
+		"Client certificate is within its validity period (9 days left)": doctorWarn,
+		"Server certificate is valid for example.com":                    doctorFail,
+		"Client CN 'stranger' is listed with its fingerprint":            doctorFail,
+		"Loopback mTLS handshake succeeds":                               doctorFail,
+	} {
+		if statuses[name] != want {
+			t.Errorf("Expected %q to be %s, got %v", name, want, statuses)
+		}
+	}
+
+	if err := os.Remove(pki.ServerKeyFile); err != nil {
+		t.Fatal(err)
+	}
+	statuses = doctorStatuses(runDoctor(newTestDoctor(pki), time.Now()))
+	for name, want := range map[string]string{
+		"Server key " + pki.ServerKeyFile + " exists":                     doctorFail,
+		"Server certificate and key parse and match":                      doctorFail,
+		"Server certificate is within its validity period":                doctorSkip,
+		"Client CN '" + pki.ClientCN + "' is listed with its fingerprint": doctorPass,
+		"Loopback mTLS handshake succeeds":                                doctorSkip,
+	} {
+		if statuses[name] != want {
+			t.Errorf("Expected %q to be %s, got %v", name, want, statuses)
+		}
+	}
+}
+
+func TestDoctorCommandFails(t *testing.T) {
+	pki := newTestPKI(t)
+	d := newTestDoctor(pki)
+	d.KnownClients = filepath.Join(pki.Dir, "missing.txt")
+	out, _ := captureOutput(t, func() {
+		if err := d.Run(); err == nil || !strings.Contains(err.Error(), "checks failed") {
+			t.Errorf("Expected the command to fail, got %v", err)
+		}
+	})
+	if !strings.Contains(out, "[FAIL] Known clients file") || !strings.Contains(out, "[SKIP] Loopback mTLS handshake succeeds") {
+		t.Errorf("Expected the checklist on stdout, got:\n%s", out)
+	}
+}
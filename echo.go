@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// --- Raw TCP Echo Mode ---
+//
+// With Mode set to serverModeTCP the server skips HTTP entirely: every accepted connection goes through
+// ServerConn, so the client certificate is verified against the known clients exactly as for HTTPS, and
+// each line the client sends is written back. The matching `client echo` command dials the same
+// host:port as --url and uses ClientConn.
+
+const (
+	serverModeHTTPS = "https"
+	serverModeTCP   = "tcp"
+)
+
+// echoHandshakeTimeout bounds the TLS handshake of echo connections, so a client that connects and
+// sends nothing doesn't hold a goroutine forever. Established connections have no idle timeout.
+const echoHandshakeTimeout = 10 * time.Second
+
+// echoServer tracks the listeners and open connections of the TCP echo mode, and of the tunnel mode.
+type echoServer struct {
+	listeners []net.Listener
+	mu        sync.Mutex
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// startEchoServer accepts mTLS connections on the listeners and echoes their lines, or forwards them in
+// serverModeTunnel, in goroutines.
+func (s *Server) startEchoServer(listeners []net.Listener) {
+	s.echo = &echoServer{listeners: listeners, conns: make(map[net.Conn]struct{})}
+	serve := s.serveEchoConn
+	if s.Mode == serverModeTunnel {
+		serve = s.serveTunnelConn
+	}
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					if !errors.Is(err, net.ErrClosed) {
+						logErrorf("Echo server accept error on %s: %v", listener.Addr(), err)
+					} else {
+						logInfof("Server stopped gracefully on %s.", listener.Addr())
+					}
+					return
+				}
+				if !s.echo.track(conn) {
+					conn.Close() // Stop raced with Accept
+					continue
+				}
+				go func() {
+					defer s.echo.untrack(conn)
+					serve(conn)
+				}()
+			}
+		}(listener)
+	}
+}
+
+// track registers an open connection. It returns false once the server is stopping.
+func (e *echoServer) track(conn net.Conn) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conns == nil {
+		return false
+	}
+	e.conns[conn] = struct{}{}
+	e.wg.Add(1)
+	return true
+}
+
+func (e *echoServer) untrack(conn net.Conn) {
+	e.mu.Lock()
+	delete(e.conns, conn)
+	e.mu.Unlock()
+	e.wg.Done()
+}
+
+// open returns the number of open connections.
+func (e *echoServer) open() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.conns)
+}
+
+// serveEchoConn runs the mTLS handshake on conn and echoes every line until the client closes it.
+func (s *Server) serveEchoConn(conn net.Conn) {
+	s.metrics.activeConns.Inc()
+	defer s.metrics.activeConns.Dec()
+
+	conn.SetDeadline(time.Now().Add(echoHandshakeTimeout))
+	tlsConn, err := s.ServerConn(conn)
+	if err != nil {
+		logWarnf("Echo connection rejected: %v", err) // The auth decision is logged by the verifier
+		return
+	}
+	defer tlsConn.Close()
+	conn.SetDeadline(time.Time{})
+
+	cn := tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	logInfof("Echo connection from %s (%s) opened", cn, conn.RemoteAddr())
+	reader := bufio.NewReader(tlsConn)
+	lines := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if _, werr := io.WriteString(tlsConn, line); werr != nil {
+				logWarnf("Echo connection from %s: write failed: %v", cn, werr)
+				return
+			}
+			lines++
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				logWarnf("Echo connection from %s: read failed: %v", cn, err)
+			}
+			break
+		}
+	}
+	logInfof("Echo connection from %s (%s) closed after %d lines", cn, conn.RemoteAddr(), lines)
+}
+
+// stop closes the listener and all open connections, then waits for their handlers to return or ctx to expire.
+// Echo connections stay open until the client closes them, so there is nothing to drain gracefully.
+func (e *echoServer) stop(ctx context.Context) error {
+	closeListeners(e.listeners)
+	e.mu.Lock()
+	for conn := range e.conns {
+		conn.Close()
+	}
+	e.conns = nil
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// echoAddr returns the host:port of ServerURL, defaulting the port to 443 like HTTPS would.
+func (c *Client) echoAddr() (string, error) {
+	u, err := url.Parse(c.ServerURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("invalid server URL %q", c.ServerURL)
+	}
+	if u.Port() == "" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return u.Host, nil
+}
+
+// Echo connects to a server in TCP mode, sends each line read from in and writes the echoed line to out.
+// It returns once in is exhausted and every line has been echoed.
+func (c *Client) Echo(in io.Reader, out io.Writer) error {
+	addr, err := c.echoAddr()
+	if err != nil {
+		return err
+	}
+	logInfof("Connecting to %s over raw TLS...", addr)
+	ctx, cancel := context.WithTimeout(context.Background(), echoHandshakeTimeout)
+	conn, err := c.dialContext(ctx, "tcp", addr)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(echoHandshakeTimeout))
+	tlsConn, err := c.ClientConn(conn)
+	if err != nil {
+		return err
+	}
+	defer tlsConn.Close()
+	conn.SetDeadline(time.Time{})
+	state := tlsConn.ConnectionState()
+	logInfof("Connected to %s (%s, %s, ALPN %q)", state.PeerCertificates[0].Subject.CommonName,
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite), state.NegotiatedProtocol)
+
+	scanner := bufio.NewScanner(in)
+	replies := bufio.NewReader(tlsConn)
+	for scanner.Scan() {
+		if _, err := fmt.Fprintf(tlsConn, "%s\n", scanner.Text()); err != nil {
+			return fmt.Errorf("failed to send line: %w", err)
+		}
+		reply, err := replies.ReadString('\n')
+		if err != nil {
+			// With TLS 1.3 a rejected client only learns about it here, from the server's alert
+			return fmt.Errorf("failed to read echo: %w", err)
+		}
+		fmt.Fprint(out, reply)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	return nil
+}
+
+// ClientEchoCmd sends stdin line by line to a server started with --mode tcp.
+type ClientEchoCmd struct{}
+
+// Run echoes stdin until EOF over one raw mTLS connection to the host and port of --url.
+func (e *ClientEchoCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	if err := client.Echo(os.Stdin, os.Stdout); err != nil {
+		return fmt.Errorf("echo failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEchoMode(t *testing.T) {
+	pki := newTestPKI(t)
+	_, url := startTestServer(t, pki, func(s *Server) { s.Mode = serverModeTCP })
+
+	client, err := NewClient(url, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := client.Echo(strings.NewReader("hello\nover raw TLS\n"), &out); err != nil {
+		t.Fatalf("Echo failed: %v", err)
+	}
+	if out.String() != "hello\nover raw TLS\n" {
+		t.Errorf("Expected the lines to be echoed back, got %q", out.String())
+	}
+
+	// An HTTPS request doesn't get an HTTP response from an echo server.
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Error("Expected an HTTP request to a TCP mode server to fail")
+	}
+}
+
+func TestEchoModeRejectsUnknownClient(t *testing.T) {
+	pki := newTestPKI(t)
+	_, url := startTestServer(t, pki, func(s *Server) { s.Mode = serverModeTCP })
+
+	unknownCert := filepath.Join(pki.Dir, "unknown.crt")
+	unknownKey := filepath.Join(pki.Dir, "unknown.key")
+	pki.newClientCert(t, "intruder", unknownCert, unknownKey)
+	client, err := NewClient(url, pki.ServerCertFile, unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := client.Echo(strings.NewReader("hello\n"), &out); err == nil {
+		t.Fatal("Expected an unknown client to be rejected")
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no echo for a rejected client, got %q", out.String())
+	}
+}
+
+func TestEchoStopClosesConnections(t *testing.T) {
+	pki := newTestPKI(t)
+	server, url := startTestServer(t, pki, func(s *Server) { s.Mode = serverModeTCP })
+	client, err := NewClient(url, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A reader that never ends keeps the connection open until the server closes it.
+	in, writer := io.Pipe()
+	defer writer.Close()
+	done := make(chan error, 1)
+	go func() { done <- client.Echo(in, &bytes.Buffer{}) }()
+	writer.Write([]byte("first\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for server.echo.open() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the echo connection")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := server.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	go writer.Write([]byte("second\n")) // Echo may already have failed on the first line and stopped reading
+	if err := <-done; err == nil {
+		t.Error("Expected Echo to fail once the server closed the connection")
+	}
+}
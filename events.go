@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/x509"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Authentication Decision Events ---
+
+// authDecision describes the outcome of verifying one client certificate.
+type authDecision struct {
+	Time        time.Time `json:"time"`
+	RemoteAddr  string    `json:"remote_addr"`
+	CN          string    `json:"cn"`
+	ClientID    string    `json:"client_id,omitempty"` // Set when clients are identified by a SAN, see --client-id-source
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"` // Set when a tenant's configuration verified the client, see tenants.go
+	Allowed     bool      `json:"allowed"`
+	Check       string    `json:"check,omitempty"` // Name of the failing check, if known
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// newAuthDecision builds a decision from the raw certificates presented and the verification result,
+// with the client ID taken from the SAN idSource selects, if any.
+func newAuthDecision(rawCerts [][]byte, remoteAddr string, verifyErr error, idSource mtls.IdentitySource) authDecision {
+	d := authDecision{Time: time.Now().UTC(), RemoteAddr: remoteAddr, Allowed: verifyErr == nil}
+	if verifyErr != nil {
+		d.Reason = verifyErr.Error()
+		d.Check = failedCheckName(verifyErr)
+	}
+	if len(rawCerts) > 0 {
+		if cert, err := x509.ParseCertificate(rawCerts[0]); err == nil {
+			d.CN = cert.Subject.CommonName
+			if idSource != "" && idSource != mtls.IdentityCN {
+				d.ClientID, _ = idSource.ClientID(cert)
+			}
+			d.Fingerprint = mtls.CertFingerprint(cert)
+		}
+	}
+	return d
+}
+
+// eventSubscriberBuffer is how many events a subscriber may fall behind before it is dropped.
+const eventSubscriberBuffer = 64
+
+// eventHub fans out events, such as auth decisions, to subscribers.
+// Publishing never blocks the handshake: a subscriber whose buffer is full is disconnected as a slow consumer.
+type eventHub[T any] struct {
+	mu          sync.Mutex
+	subscribers map[chan T]struct{}
+}
+
+func newEventHub[T any]() *eventHub[T] {
+	return &eventHub[T]{subscribers: make(map[chan T]struct{})}
+}
+
+// Subscribe registers a new subscriber. The returned channel is closed when the subscriber
+// is cancelled or dropped for being too slow.
+func (h *eventHub[T]) Subscribe() (<-chan T, func()) {
+	ch := make(chan T, eventSubscriberBuffer)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() { h.remove(ch) }
+}
+
+// Publish delivers the event to all subscribers without blocking.
+func (h *eventHub[T]) Publish(event T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logWarnf("Dropping slow event subscriber")
+			delete(h.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventHub[T]) remove(ch chan T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[ch]; ok {
+		delete(h.subscribers, ch)
+		close(ch)
+	}
+}
+
+// eventsUpgrader upgrades admin event requests to websockets.
+var eventsUpgrader = websocket.Upgrader{}
+
+// adminEventsHandler streams auth decisions as JSON messages over a websocket.
+func (s *Server) adminEventsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := eventsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logErrorf("Failed to upgrade admin events connection: %v", err)
+		return // Upgrade already replied with an error
+	}
+	defer conn.Close()
+
+	events, cancel := s.decisions.Subscribe()
+	defer cancel()
+	logAuth(levelInfo, "Admin subscribed to auth events", requestAttrs(r)...)
+
+	closed := discardWSMessages(conn)
+	for {
+		select {
+		case d, ok := <-events:
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow consumer"))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteJSON(d); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// discardWSMessages reads (and discards) the messages of an event stream's client so close frames are
+// processed. The returned channel is closed once the connection is.
+func discardWSMessages(conn *websocket.Conn) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return closed
+}
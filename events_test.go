@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"tls-playground/pkg/mtls"
+)
+
+// dialAdminEvents opens the /admin/events websocket using the given client identity.
+func dialAdminEvents(t *testing.T, pki *testPKI, baseURL, certFile, keyFile string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	tlsConfig, err := mtls.ClientConfig{CertFile: certFile, KeyFile: keyFile, ServerCertFile: pki.ServerCertFile}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer := websocket.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: 5 * time.Second}
+	return dialer.Dial(strings.Replace(baseURL, "https://", "wss://", 1)+"/admin/events", nil)
+}
+
+func TestAdminEventsStreamsDecisions(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.AdminCNs = []string{pki.ClientCN}
+	})
+
+	conn, _, err := dialAdminEvents(t, pki, baseURL, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatalf("Failed to open events websocket: %v", err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond) // Let the handler subscribe before generating events
+
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+
+	unknownCert := filepath.Join(pki.Dir, "unknown.crt")
+	unknownKey := filepath.Join(pki.Dir, "unknown.key")
+	pki.newClientCert(t, "intruder", unknownCert, unknownKey)
+	intruder, err := NewClient(baseURL+"/hello", pki.ServerCertFile, unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := intruder.SendRequest(); err == nil {
+		t.Fatal("Expected unknown client to be rejected")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var allowed, denied authDecision
+	if err := conn.ReadJSON(&allowed); err != nil {
+		t.Fatalf("Failed to read allow event: %v", err)
+	}
+	if err := conn.ReadJSON(&denied); err != nil {
+		t.Fatalf("Failed to read deny event: %v", err)
+	}
+
+	if !allowed.Allowed || allowed.CN != pki.ClientCN || allowed.RemoteAddr == "" || allowed.Time.IsZero() {
+		t.Errorf("Unexpected allow event: %+v", allowed)
+	}
+	if denied.Allowed || denied.CN != "intruder" || !strings.Contains(denied.Reason, "not authorized") {
+		t.Errorf("Unexpected deny event: %+v", denied)
+	}
+}
+
+func TestAdminEventsRequiresAdminCN(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+
+	_, resp, err := dialAdminEvents(t, pki, baseURL, pki.ClientCertFile, pki.ClientKeyFile)
+	if err == nil {
+		t.Fatal("Expected non-admin websocket dial to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 for non-admin, got %v", resp)
+	}
+}
+
+func TestEventHubDropsSlowConsumer(t *testing.T) {
+	hub := newEventHub[authDecision]()
+	events, cancel := hub.Subscribe()
+	defer cancel()
+
+	for i := 0; i < eventSubscriberBuffer+1; i++ {
+		hub.Publish(authDecision{CN: "c"})
+	}
+
+	count := 0
+	for range events { // Closed once the subscriber is dropped
+		count++
+	}
+	if count != eventSubscriberBuffer {
+		t.Errorf("Expected %d buffered events before drop, got %d", eventSubscriberBuffer, count)
+	}
+}
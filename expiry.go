@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// --- Certificate Expiry ---
+//
+// Both the server and the client look at their configured certificates at startup and warn when one
+// expires within ExpiryWarnDays, so a forgotten renewal shows up in the logs before handshakes start
+// failing. With StrictExpiry a certificate that is already expired (or not yet valid) is an error
+// instead of a warning. Client certificates presented during handshakes are always rejected outside
+// their validity period, see validityCheck in checks.go.
+
+// defaultExpiryWarnDays is how far ahead expiry warnings look by default.
+const defaultExpiryWarnDays = 30
+
+// checkCertExpiry warns about a certificate that expires within warnDays (0 disables the warning)
+// and about one outside its validity period, which is an error instead when strict is set.
+// what names the certificate in messages, e.g. "server certificate certs/server.crt".
+func checkCertExpiry(what string, cert *x509.Certificate, warnDays int, strict bool, now time.Time) error {
+	var problem string
+	switch {
+	case now.After(cert.NotAfter):
+		problem = fmt.Sprintf("%s (CN '%s') expired at %s", what, cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+	case now.Before(cert.NotBefore):
+		problem = fmt.Sprintf("%s (CN '%s') is not valid before %s", what, cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+	default:
+		if left := cert.NotAfter.Sub(now); warnDays > 0 && left < time.Duration(warnDays)*24*time.Hour {
+			logWarnf("%s (CN '%s') expires in %d days, at %s", what, cert.Subject.CommonName,
+				int(left.Hours()/24), cert.NotAfter.Format(time.RFC3339))
+		}
+		return nil
+	}
+	if strict {
+		return errors.New(problem)
+	}
+	logWarnf("%s", problem)
+	return nil
+}
+
+// checkCertFileExpiry loads the PEM certificate in certFile and runs checkCertExpiry on it.
+func checkCertFileExpiry(what, certFile string, warnDays int, strict bool) error {
+	cert, err := loadCertificate(certFile)
+	if err != nil {
+		return err
+	}
+	return checkCertExpiry(what+" "+certFile, cert, warnDays, strict, time.Now())
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckCertExpiry(t *testing.T) {
+	now := time.Now()
+	cert := func(notBefore, notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{Subject: pkix.Name{CommonName: "test"}, NotBefore: notBefore, NotAfter: notAfter}
+	}
+	tests := []struct {
+		name    string
+		cert    *x509.Certificate
+		strict  bool
+		wantErr string
+	}{
+		{"valid", cert(now.Add(-time.Hour), now.Add(365*24*time.Hour)), true, ""},
+		{"expiring soon", cert(now.Add(-time.Hour), now.Add(24*time.Hour)), true, ""},
+		{"expired", cert(now.Add(-48*time.Hour), now.Add(-time.Hour)), false, ""},
+		{"expired strict", cert(now.Add(-48*time.Hour), now.Add(-time.Hour)), true, "expired at"},
+		{"not yet valid strict", cert(now.Add(time.Hour), now.Add(48*time.Hour)), true, "not valid before"},
+	}
+	for _, tt := range tests {
+		err := checkCertExpiry("test certificate", tt.cert, defaultExpiryWarnDays, tt.strict, now)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: expected an error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestVerifyClientCertificateRejectsExpired(t *testing.T) {
+	// Backdated by a minute for clock skew, so this expired 30 seconds ago.
+	certPEM, _, err := generateSelfSignedCert(certOptions{CommonName: "expired", ValidFor: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	err = verifyClientCertificate([][]byte{block.Bytes}, nil, nil, verifyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected an expired client certificate to be rejected, got %v", err)
+	}
+}
+
+func TestServerStrictExpiry(t *testing.T) {
+	pki := newTestPKI(t)
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: "localhost", ValidFor: 30 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCertFiles(pki.ServerCertFile, pki.ServerKeyFile, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.StrictExpiry = true
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "expired") {
+		server.Stop()
+		t.Errorf("Expected --strict-expiry to refuse an expired server certificate, got %v", err)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Handshake Failure Explanations ---
+//
+// Go reports failed handshakes in terms of the protocol ("remote error: tls: bad certificate", "x509:
+// certificate is valid for localhost, not example.com"). The common causes are mapped to an explanation
+// with a suggested fix: the client logs one when a request fails, and the server logs one next to each
+// rejected client and failed handshake. The client can't tell why the server rejected its certificate
+// (the alert is the same whatever check failed), so it explains the likely causes from what it presented;
+// the server knows exactly.
+
+// explanation describes why a handshake failed and what usually fixes it.
+type explanation struct {
+	Problem string
+	Fix     string
+}
+
+// attrs returns the explanation as log attributes.
+func (e explanation) attrs() []slog.Attr {
+	return []slog.Attr{slog.String("explanation", e.Problem), slog.String("fix", e.Fix)}
+}
+
+// explainHandshakeError logs an explanation of a request that failed during the TLS handshake.
+func (c *Client) explainHandshakeError(err error) {
+	if e, ok := c.diagnoseHandshakeError(err, time.Now()); ok {
+		logWarnf("%s. Fix: %s", e.Problem, e.Fix)
+	}
+}
+
+// diagnoseHandshakeError explains a client-side handshake error, if it is one of the common ones.
+func (c *Client) diagnoseHandshakeError(err error, now time.Time) (explanation, bool) {
+	if err == nil {
+		return explanation{}, false
+	}
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return explanation{
+			Problem: fmt.Sprintf("The server certificate is not valid for the name %s the client connected to (%s)", hostnameErr.Host, certNames(hostnameErr.Certificate)),
+			Fix:     "Connect with a name from the certificate (--url, or --sni to only change the name verified), or reissue the server certificate with this name as a SAN (gen-cert --san)",
+		}, true
+	}
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthorityErr) {
+		return explanation{
+			Problem: "The server certificate is not the one the client trusts, nor signed by it",
+			Fix:     "Pass the server certificate or its CA with --server-cert, or trust the server by its key with --server-fingerprint",
+		}, true
+	}
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired {
+		return explanation{
+			Problem: "The server certificate has expired or is not valid yet",
+			Fix:     "Renew the server certificate (gen-cert --kind server), or check the clocks of client and server",
+		}, true
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "server public key fingerprint mismatch"):
+		return explanation{
+			Problem: "The server key does not match --server-fingerprint",
+			Fix:     "If the server got a new key, update the fingerprint (go run . fingerprint on its certificate prints it)",
+		}, true
+	case strings.Contains(msg, "remote error: tls: protocol version not supported"),
+		strings.Contains(msg, "server selected unsupported protocol version"):
+		return explanation{
+			Problem: "Client and server have no TLS version in common",
+			Fix:     "Make the --min-tls and --max-tls ranges of client and server overlap",
+		}, true
+	case strings.Contains(msg, "remote error: tls: bad certificate"),
+		strings.Contains(msg, "remote error: tls: certificate required"),
+		strings.Contains(msg, "remote error: tls: unknown certificate authority"),
+		strings.Contains(msg, "remote error: tls: certificate expired"):
+		return c.diagnoseRejectedCertificate(now), true
+	case strings.Contains(msg, "remote error: tls: handshake failure"):
+		return explanation{
+			Problem: "The server refused the handshake, often because no cipher suite or curve is acceptable to both sides",
+			Fix:     "Compare the --ciphers of client and server, or run the server with --tls-debug to see the ClientHello",
+		}, true
+	case strings.Contains(msg, "first record does not look like a TLS handshake"):
+		return explanation{
+			Problem: "The server does not speak TLS on this port",
+			Fix:     "Check the port of --url, e.g. use the HTTPS port rather than --http-addr",
+		}, true
+	}
+	return explanation{}, false
+}
+
+// diagnoseRejectedCertificate explains why the server may have rejected the client certificate.
+func (c *Client) diagnoseRejectedCertificate(now time.Time) explanation {
+	leaf := c.clientLeaf()
+	switch {
+	case leaf == nil:
+		return explanation{
+			Problem: "The server requires a client certificate and the client presented none",
+			Fix:     "Pass the client certificate and key with --cert and --key (or --p12)",
+		}
+	case now.After(leaf.NotAfter):
+		return explanation{
+			Problem: fmt.Sprintf("The client certificate for CN '%s' expired at %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339)),
+			Fix:     "Issue a new client certificate (gen-cert --kind client --add-known-client)",
+		}
+	case now.Before(leaf.NotBefore):
+		return explanation{
+			Problem: fmt.Sprintf("The client certificate for CN '%s' is not valid before %s", leaf.Subject.CommonName, leaf.NotBefore.Format(time.RFC3339)),
+			Fix:     "Check the clocks of client and server",
+		}
+	}
+	return explanation{
+		Problem: fmt.Sprintf("The server rejected the client certificate for CN '%s': the CN is likely missing from its known clients file, listed with another fingerprint, or signed by a CA it does not trust", leaf.Subject.CommonName),
+		Fix: fmt.Sprintf("Add \"%s %s\" to the known clients file of the server; the server log (and /diag/rejections with --diag-addr) names the check that failed",
+			leaf.Subject.CommonName, mtls.CertFingerprint(leaf)),
+	}
+}
+
+// clientLeaf returns the client certificate presented to the server, or nil if there is none.
+func (c *Client) clientLeaf() *x509.Certificate {
+	if c.spiffe != nil {
+		return c.spiffe.svid().Cert.Leaf
+	}
+	cert := c.certificate()
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil
+	}
+	if cert.Leaf != nil {
+		return cert.Leaf
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil
+	}
+	return leaf
+}
+
+// certNames lists the names a certificate is valid for.
+func certNames(cert *x509.Certificate) string {
+	if cert == nil {
+		return "no names"
+	}
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 {
+		return fmt.Sprintf("it has no SANs, only CN '%s', which Go ignores", cert.Subject.CommonName)
+	}
+	return "it is valid for " + strings.Join(names, ", ")
+}
+
+// explainRejection explains why the server's client certificate checks rejected d.
+func explainRejection(d authDecision, verifyErr error) explanation {
+	what, id := "CN", d.CN
+	if d.ClientID != "" {
+		what, id = "Client ID", d.ClientID
+	}
+	switch {
+	case errors.Is(verifyErr, mtls.ErrNoClientID):
+		return explanation{
+			Problem: "The client certificate lacks the SAN that --client-id-source identifies clients by",
+			Fix:     "Issue the client a certificate with that SAN (ca issue --san), or identify clients by another field",
+		}
+	case errors.Is(verifyErr, mtls.ErrCNNotAuthorized):
+		return explanation{
+			Problem: fmt.Sprintf("%s '%s' is not listed in the known clients file", what, id),
+			Fix:     fmt.Sprintf("If the client should be let in, add \"%s %s\" to the known clients file", id, d.Fingerprint),
+		}
+	case errors.Is(verifyErr, mtls.ErrEntryNotYetValid):
+		return explanation{
+			Problem: fmt.Sprintf("The known clients entry for %s '%s' is not valid yet", what, id),
+			Fix:     "Wait for the valid from time of the entry, or move it earlier",
+		}
+	case errors.Is(verifyErr, mtls.ErrFingerprintMismatch):
+		return explanation{
+			Problem: fmt.Sprintf("%s '%s' is known, but with another certificate", what, id),
+			Fix:     fmt.Sprintf("If the client renewed its certificate, replace its fingerprint with %s, or list its key with an spki: entry so renewals keep working", d.Fingerprint),
+		}
+	}
+	switch d.Check {
+	case "known-client":
+		return explanation{
+			Problem: fmt.Sprintf("The known clients entry for %s '%s' has expired", what, id),
+			Fix:     "Extend or remove the expiry (valid-until) of the entry",
+		}
+	case "denied":
+		return explanation{
+			Problem: fmt.Sprintf("The client certificate for CN '%s' is on the denied clients list", d.CN),
+			Fix:     "If the client should be let in again, remove its line from --denied-clients (reloaded automatically)",
+		}
+	case "validity":
+		return explanation{
+			Problem: fmt.Sprintf("The client certificate for CN '%s' has expired or is not valid yet", d.CN),
+			Fix:     "Issue the client a new certificate (gen-cert --kind client), or check the clocks of client and server",
+		}
+	case "signature-algorithm":
+		return explanation{
+			Problem: "The client certificate is signed with an algorithm --allowed-sig-algs does not allow",
+			Fix:     "Reissue the certificate with an allowed algorithm, or allow its algorithm",
+		}
+	case "max-lifetime":
+		return explanation{
+			Problem: "The client certificate is valid for longer than --max-client-cert-lifetime",
+			Fix:     "Reissue the certificate with a shorter validity (gen-cert --valid-for), or raise the limit",
+		}
+	case "eku":
+		return explanation{
+			Problem: "The client certificate was not issued for the purposes --require-client-eku requires",
+			Fix:     "Issue the client a certificate with the client auth EKU (ca issue --kind client), or relax --require-client-eku",
+		}
+	case "name-patterns":
+		return explanation{
+			Problem: fmt.Sprintf("The client certificate for CN '%s' carries a name outside --client-name-pattern", d.CN),
+			Fix:     "Issue the certificate for a permitted name, or add a pattern matching it",
+		}
+	case "issuer":
+		return explanation{
+			Problem: fmt.Sprintf("The client certificate for CN '%s' is from an issuer outside --client-issuer-pattern", d.CN),
+			Fix:     "Issue the certificate from a permitted CA, or add a pattern matching its issuer",
+		}
+	}
+	return explanation{
+		Problem: fmt.Sprintf("The client certificate for CN '%s' failed the %s check", d.CN, d.Check),
+		Fix:     "See the reason logged with the rejection",
+	}
+}
+
+// explainHandshakeFailure explains a server-side handshake error, if it is one of the common ones.
+func explainHandshakeFailure(reason string) (explanation, bool) {
+	switch {
+	case strings.Contains(reason, "client didn't provide a certificate"):
+		return explanation{
+			Problem: "The client presented no certificate",
+			Fix:     "Give the client its certificate and key (client --cert and --key, or import a PKCS#12 bundle from export-p12 into a browser)",
+		}, true
+	case strings.Contains(reason, "unsupported versions"), strings.Contains(reason, "protocol version not supported"):
+		return explanation{
+			Problem: "Client and server have no TLS version in common",
+			Fix:     "Make the --min-tls and --max-tls ranges of client and server overlap",
+		}, true
+	case strings.Contains(reason, "no cipher suite supported by both"):
+		return explanation{
+			Problem: "Client and server have no cipher suite in common",
+			Fix:     "Compare the --ciphers of client and server (list-ciphers shows the names)",
+		}, true
+	case strings.Contains(reason, "remote error: tls:"):
+		return explanation{
+			Problem: "The client rejected the server certificate",
+			Fix:     "The client must trust the server certificate (client --server-cert) and connect with a name among its SANs",
+		}, true
+	case strings.Contains(reason, "x509: certificate signed by unknown authority"):
+		return explanation{
+			Problem: "The client certificate is not signed by a --client-ca",
+			Fix:     "Issue the client certificate from one of the client CAs (ca issue), or add its CA with --client-ca. A certificate from an intermediate CA must come with the intermediates (client --intermediates)",
+		}, true
+	case strings.Contains(reason, "x509: certificate has expired or is not yet valid"):
+		return explanation{
+			Problem: "The client certificate has expired or is not valid yet",
+			Fix:     "Issue the client a new certificate, or check the clocks of client and server",
+		}, true
+	case strings.Contains(reason, "first record does not look like a TLS handshake"):
+		return explanation{
+			Problem: "The client spoke plain HTTP to the HTTPS port",
+			Fix:     "Use an https:// URL, or serve a redirect with --http-addr",
+		}, true
+	}
+	return explanation{}, false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandshakeFailuresAreExplained(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.TLSVersions = TLSVersions{Min: tls.VersionTLS13}
+	})
+	strangerCert, strangerKey := filepath.Join(pki.Dir, "stranger.crt"), filepath.Join(pki.Dir, "stranger.key")
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: "stranger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCertFiles(strangerCert, strangerKey, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	newClient := func(serverCert, cert, key string) *Client {
+		client, err := NewClient(baseURL+"/hello", serverCert, cert, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	tests := []struct {
+		name   string
+		client func() *Client
+		want   []string // In the client's and the server's logs
+	}{
+		{"unknown CN", func() *Client { return newClient(pki.ServerCertFile, strangerCert, strangerKey) },
+			[]string{"The server rejected the client certificate for CN 'stranger'", "CN 'stranger' is not listed in the known clients file"}},
+		{"wrong SAN", func() *Client {
+			client := newClient(pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+			client.SetServerName("wrong.example")
+			return client
+		}, []string{"not valid for the name wrong.example", "The client rejected the server certificate"}},
+		{"untrusted server", func() *Client { return newClient(strangerCert, pki.ClientCertFile, pki.ClientKeyFile) },
+			[]string{"not the one the client trusts", "The client rejected the server certificate"}},
+		{"version mismatch", func() *Client {
+			client := newClient(pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+			client.SetTLSVersions(TLSVersions{Max: tls.VersionTLS12})
+			return client
+		}, []string{"no TLS version in common", "explanation=\"Client and server have no TLS version in common\""}},
+		{"no client certificate", func() *Client {
+			return newClientWithTLSConfig(baseURL+"/hello", &tls.Config{InsecureSkipVerify: true})
+		}, []string{"the client presented none", "The client presented no certificate"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := tt.client()
+			_, logs := captureOutput(t, func() {
+				if _, _, err := client.SendRequest(); err == nil {
+					t.Fatal("Expected the request to fail")
+				}
+				time.Sleep(50 * time.Millisecond) // Let the server log its side
+			})
+			for _, want := range tt.want {
+				if !strings.Contains(logs, want) {
+					t.Errorf("Expected %q in the logs, got:\n%s", want, logs)
+				}
+			}
+		})
+	}
+}
+
+func TestDiagnoseRejectedCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	client, err := NewClient("https://localhost", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := client.diagnoseRejectedCertificate(time.Now().Add(10 * 365 * 24 * time.Hour))
+	if !strings.Contains(e.Problem, "expired at") || !strings.Contains(e.Fix, "gen-cert") {
+		t.Errorf("Expected an expired client certificate to be pointed out, got %+v", e)
+	}
+	e = client.diagnoseRejectedCertificate(time.Now())
+	if !strings.Contains(e.Fix, pki.ClientCN) {
+		t.Errorf("Expected the known clients entry to add, got %+v", e)
+	}
+}
+
+func TestExplainRejectionFingerprintMismatch(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	otherCert, otherKey := filepath.Join(pki.Dir, "other.crt"), filepath.Join(pki.Dir, "other.key")
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: pki.ClientCN})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeCertFiles(otherCert, otherKey, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, otherCert, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, logs := captureOutput(t, func() {
+		if _, _, err := client.SendRequest(); err == nil {
+			t.Fatal("Expected a certificate with another fingerprint to be rejected")
+		}
+	})
+	if !strings.Contains(logs, "is known, but with another certificate") {
+		t.Errorf("Expected the server to explain the fingerprint mismatch, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import "tls-playground/pkg/mtls"
+
+// --- Fingerprint Command ---
+
+// FingerprintCmd prints the CN and fingerprints of certificates in the format the known clients file uses.
+type FingerprintCmd struct {
+	Certs []string `kong:"arg,name='cert',help='PEM certificate file(s).',type='existingfile'"`
+	Entry bool     `kong:"name='entry',help='Only print the known clients line for each certificate, e.g. to append it to knownClients.txt.'"`
+}
+
+// Run prints the fingerprints of each certificate.
+func (f *FingerprintCmd) Run() error {
+	for i, certFile := range f.Certs {
+		cert, err := loadCertificate(certFile)
+		if err != nil {
+			return err
+		}
+		cn := cert.Subject.CommonName
+		fingerprint := mtls.CertFingerprint(cert)
+		if f.Entry {
+			outputf("%s %s\n", cn, fingerprint)
+			continue
+		}
+		if i > 0 {
+			outputf("\n")
+		}
+		outputf("File:        %s\n", certFile)
+		outputf("CN:          %s\n", cn)
+		outputf("Fingerprint: %s\n", fingerprint)
+		outputf("Public key:  %s%s\n", mtls.SPKIEntryPrefix, mtls.KeyFingerprint(cert))
+		outputf("Entry:       %s %s\n", cn, fingerprint)
+	}
+	return nil
+}
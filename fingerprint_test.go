@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestFingerprintCmd(t *testing.T) {
+	pki := newTestPKI(t)
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := pki.ClientCN + " " + mtls.CertFingerprint(cert)
+
+	stdout, _ := captureOutput(t, func() {
+		if err := (&FingerprintCmd{Certs: []string{pki.ClientCertFile}}).Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, want := range []string{"CN:          " + pki.ClientCN, "Fingerprint: " + mtls.CertFingerprint(cert), "Public key:  spki:" + mtls.KeyFingerprint(cert), "Entry:       " + entry} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("Expected %q in output:\n%s", want, stdout)
+		}
+	}
+
+	// --entry prints lines the server loads as-is.
+	stdout, _ = captureOutput(t, func() {
+		if err := (&FingerprintCmd{Certs: []string{pki.ClientCertFile, pki.ServerCertFile}, Entry: true}).Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || lines[0] != entry {
+		t.Fatalf("Expected one entry per certificate starting with %q, got:\n%s", entry, stdout)
+	}
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "spki_client", "spki:"+mtls.KeyFingerprint(cert)); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := clients["spki_client"]; len(entries) != 1 || entries[0].Fingerprint != mtls.KeyEntry(mtls.KeyFingerprint(cert)) {
+		t.Errorf("Expected the printed public key to load as an spki: entry, got %+v", entries)
+	}
+
+	if err := (&FingerprintCmd{Certs: []string{pki.ClientKeyFile}}).Run(); err == nil {
+		t.Error("Expected an error for a file without a certificate")
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Certificate Generation Command ---
+
+// GenCertCmd generates the self-signed server and client certificates, replacing setup.sh.
+type GenCertCmd struct {
+	OutDir   string        `kong:"name='out-dir',help='Directory to write the certificates and keys to.',default='certs',type='path'"`
+	Kind     string        `kong:"name='kind',help='Which certificates to generate.',enum='both,server,client',default='both'"`
+	ServerCN string        `kong:"name='server-cn',help='Common name of the server certificate.',default='localhost'"`
+	SANs     []string      `kong:"name='san',help='Subject alternative name (DNS name or IP) of the server certificate (repeatable).',default='localhost,127.0.0.1,::1',sep=','"`
+	ClientCN string        `kong:"name='client-cn',help='Common name of the client certificate.',default='my_secure_client'"`
+	KeyType  string        `kong:"name='key-type',help='Key type of the generated certificates: rsa, ecdsa (P-256, also ecdsa-p256), ecdsa-p384 or ed25519.',enum='rsa,ecdsa,ecdsa-p256,ecdsa-p384,ed25519',default='rsa'"`
+	RSABits  int           `kong:"name='rsa-bits',help='RSA key size.',default='2048'"`
+	ValidFor time.Duration `kong:"name='valid-for',help='Validity period of the certificates.',default='8760h'"`
+
+	AddKnownClient bool   `kong:"name='add-known-client',help='Append the client CN and fingerprint to the known clients file.'"`
+	KnownClients   string `kong:"name='known-clients',help='Known clients file for --add-known-client (default: <out-dir>/knownClients.txt).',type='path'"`
+	Force          bool   `kong:"name='force',help='Overwrite existing certificate and key files.'"`
+}
+
+// Run generates the requested certificates and prints their fingerprints.
+func (g *GenCertCmd) Run() error {
+	if err := os.MkdirAll(g.OutDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory %s: %w", g.OutDir, err)
+	}
+
+	// Check everything up front so an existing client cert doesn't leave a freshly overwritten server cert behind.
+	if !g.Force {
+		for _, name := range g.names() {
+			if err := refuseOverwrite(filepath.Join(g.OutDir, name+".crt"), filepath.Join(g.OutDir, name+".key")); err != nil {
+				return err
+			}
+		}
+	}
+
+	if g.Kind == "both" || g.Kind == "server" {
+		opts := certOptions{CommonName: g.ServerCN}
+		if err := opts.setSANs(g.SANs); err != nil {
+			return err
+		}
+		if _, err := g.generate("server", opts); err != nil {
+			return err
+		}
+	}
+
+	if g.Kind == "both" || g.Kind == "client" {
+		fingerprint, err := g.generate("client", certOptions{CommonName: g.ClientCN})
+		if err != nil {
+			return err
+		}
+		if g.AddKnownClient {
+			knownClients := g.KnownClients
+			if knownClients == "" {
+				knownClients = filepath.Join(g.OutDir, "knownClients.txt")
+			}
+			if err := mtls.AppendKnownClient(knownClients, g.ClientCN, fingerprint); err != nil {
+				return err
+			}
+			outputf("Added '%s %s' to %s\n", g.ClientCN, fingerprint, knownClients)
+		}
+	}
+	return nil
+}
+
+// names returns the base file names of the certificates to generate.
+func (g *GenCertCmd) names() []string {
+	switch g.Kind {
+	case "server", "client":
+		return []string{g.Kind}
+	default:
+		return []string{"server", "client"}
+	}
+}
+
+// generate (over)writes <out-dir>/<name>.crt and <name>.key and returns the certificate fingerprint.
+func (g *GenCertCmd) generate(name string, opts certOptions) (string, error) {
+	certFile := filepath.Join(g.OutDir, name+".crt")
+	keyFile := filepath.Join(g.OutDir, name+".key")
+	opts.KeyType = g.KeyType
+	opts.RSABits = g.RSABits
+	opts.ValidFor = g.ValidFor
+	certPEM, keyPEM, err := generateSelfSignedCert(opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate %s certificate: %w", name, err)
+	}
+	if err := replaceCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+		return "", err
+	}
+
+	cert, err := loadCertificate(certFile)
+	if err != nil {
+		return "", err
+	}
+	fingerprint := mtls.CertFingerprint(cert)
+	outputf("Generated %s certificate %s (key %s)\n  CN: %s\n  SHA-256 fingerprint: %s\n", name, certFile, keyFile, opts.CommonName, fingerprint)
+	return fingerprint, nil
+}
+
+// setSANs sorts subject alternative names into the IP addresses, URIs (such as SPIFFE IDs, told apart
+// by their "://"), email addresses (by their "@") and DNS names of the certificate.
+func (o *certOptions) setSANs(sans []string) error {
+	for _, san := range sans {
+		switch {
+		case san == "":
+		case net.ParseIP(san) != nil:
+			o.IPAddresses = append(o.IPAddresses, net.ParseIP(san))
+		case strings.Contains(san, "://"):
+			uri, err := url.Parse(san)
+			if err != nil {
+				return fmt.Errorf("invalid URI SAN %q: %w", san, err)
+			}
+			o.URIs = append(o.URIs, uri)
+		case strings.Contains(san, "@"):
+			o.EmailAddresses = append(o.EmailAddresses, san)
+		default:
+			o.DNSNames = append(o.DNSNames, san)
+		}
+	}
+	return nil
+}
+
+// replaceCertFiles writes a PEM certificate and key like writeCertFiles, removing existing files first.
+func replaceCertFiles(certFile, keyFile string, certPEM, keyPEM []byte) error {
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) { // Keys may be read-only (setup.sh makes them 0400)
+			return fmt.Errorf("failed to remove %s: %w", f, err)
+		}
+	}
+	return writeCertFiles(certFile, keyFile, certPEM, keyPEM)
+}
+
+// refuseOverwrite fails if any of the files exists, so nothing is written unless everything can be.
+func refuseOverwrite(files ...string) error {
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", f)
+		}
+	}
+	return nil
+}
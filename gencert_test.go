@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// genCert runs gen-cert into a fresh directory and returns a testPKI describing its output.
+func genCert(t *testing.T, keyType string) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	cmd := &GenCertCmd{
+		OutDir:         dir,
+		Kind:           "both",
+		ServerCN:       "localhost",
+		SANs:           []string{"localhost", "127.0.0.1", "::1"},
+		ClientCN:       "gen_client",
+		KeyType:        keyType,
+		RSABits:        defaultRSABits,
+		ValidFor:       24 * time.Hour,
+		AddKnownClient: true,
+	}
+	captureOutput(t, func() {
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("gen-cert failed: %v", err)
+		}
+	})
+	return &testPKI{
+		Dir:              dir,
+		ServerCertFile:   filepath.Join(dir, "server.crt"),
+		ServerKeyFile:    filepath.Join(dir, "server.key"),
+		ClientCertFile:   filepath.Join(dir, "client.crt"),
+		ClientKeyFile:    filepath.Join(dir, "client.key"),
+		KnownClientsFile: filepath.Join(dir, "knownClients.txt"),
+		ClientCN:         cmd.ClientCN,
+	}
+}
+
+func TestGenCertKeyTypes(t *testing.T) {
+	curves := map[string]string{keyTypeECDSA: "P-256", keyTypeECDSAP384: "P-384"}
+	for _, keyType := range []string{keyTypeRSA, keyTypeECDSA, keyTypeECDSAP384, keyTypeEd25519} {
+		t.Run(keyType, func(t *testing.T) {
+			pki := genCert(t, keyType)
+
+			serverCert, err := loadCertificate(pki.ServerCertFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch key := serverCert.PublicKey.(type) {
+			case *rsa.PublicKey:
+				if keyType != keyTypeRSA {
+					t.Errorf("Expected a %s key, got RSA", keyType)
+				}
+			case *ecdsa.PublicKey:
+				if curve := key.Curve.Params().Name; curve != curves[keyType] {
+					t.Errorf("Expected a %s key, got ECDSA %s", keyType, curve)
+				}
+			case ed25519.PublicKey:
+				if keyType != keyTypeEd25519 {
+					t.Errorf("Expected a %s key, got Ed25519", keyType)
+				}
+			}
+			if err := serverCert.VerifyHostname("::1"); err != nil {
+				t.Errorf("Expected ::1 in the server certificate SANs: %v", err)
+			}
+
+			clientCert, err := loadCertificate(pki.ClientCertFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			knownClients, err := ioutil.ReadFile(pki.KnownClientsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := pki.ClientCN + " " + mtls.CertFingerprint(clientCert); !strings.Contains(string(knownClients), want) {
+				t.Errorf("Expected %q in known clients file, got:\n%s", want, knownClients)
+			}
+
+			// The generated files are a working setup as-is.
+			_, baseURL := startTestServer(t, pki, nil)
+			client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := client.SendRequest(); err != nil {
+				t.Fatalf("Expected the generated client to be accepted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSetSANs(t *testing.T) {
+	var opts certOptions
+	if err := opts.setSANs([]string{"localhost", "127.0.0.1", "::1", "ops@example.org", "spiffe://example.org/billing", ""}); err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.DNSNames) != 1 || len(opts.IPAddresses) != 2 || len(opts.EmailAddresses) != 1 || len(opts.URIs) != 1 ||
+		opts.URIs[0].String() != "spiffe://example.org/billing" {
+		t.Errorf("Expected one DNS name, two IPs, one email and one URI, got %+v", opts)
+	}
+	if err := opts.setSANs([]string{"spiffe://bad host/%zz"}); err == nil {
+		t.Error("Expected an invalid URI to be rejected")
+	}
+}
+
+func TestGenCertRefusesToOverwrite(t *testing.T) {
+	pki := genCert(t, keyTypeECDSA)
+	before, err := ioutil.ReadFile(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := &GenCertCmd{OutDir: pki.Dir, Kind: "server", ServerCN: "localhost", KeyType: keyTypeECDSA}
+	if err := cmd.Run(); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Expected an already exists error, got %v", err)
+	}
+	after, _ := ioutil.ReadFile(pki.ServerCertFile)
+	if string(after) != string(before) {
+		t.Fatal("Expected the server certificate to be left untouched")
+	}
+
+	cmd.Force = true
+	captureOutput(t, func() {
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("Expected --force to overwrite, got %v", err)
+		}
+	})
+	after, _ = ioutil.ReadFile(pki.ServerCertFile)
+	if string(after) == string(before) {
+		t.Fatal("Expected a new server certificate with --force")
+	}
+}
+
+func TestMixedKeyTypesHandshake(t *testing.T) {
+	serverPKI := genCert(t, keyTypeEd25519)
+	clientPKI := genCert(t, keyTypeECDSAP384)
+	pki := *serverPKI
+	pki.ClientCertFile, pki.ClientKeyFile = clientPKI.ClientCertFile, clientPKI.ClientKeyFile
+	pki.KnownClientsFile = clientPKI.KnownClientsFile
+	_, baseURL := startTestServer(t, &pki, nil)
+
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.SetTLSVersions(TLSVersions{Min: version, Max: version})
+		if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+			t.Errorf("Expected an Ed25519 server and a P-384 client to handshake over %s, got %d (%v)", tls.VersionName(version), status, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- gRPC Mode ---
+//
+// With Mode set to serverModeGRPC the server serves gRPC instead of HTTPS. credentials.NewTLS wraps the
+// same TLS configuration, so client certificates are verified against the known clients exactly as for
+// HTTPS. The one service, tlsplayground.Playground, has a Hello RPC taking and returning a
+// google.protobuf.StringValue, so no generated code is needed (see grpcPlaygroundDesc for its definition).
+// Every RPC also goes through grpcAuthInterceptor, the counterpart of the HTTPS middleware.
+
+const serverModeGRPC = "grpc"
+
+// grpcHelloMethod is the full name of the Hello RPC.
+const grpcHelloMethod = "/tlsplayground.Playground/Hello"
+
+// defaultGRPCTimeout bounds a Hello call when the client has no RequestTimeout.
+const defaultGRPCTimeout = 10 * time.Second
+
+// grpcPlayground is the implementation of the Playground service.
+type grpcPlayground interface {
+	Hello(ctx context.Context, name *wrapperspb.StringValue) (*wrapperspb.StringValue, error)
+}
+
+// grpcPlaygroundDesc describes the Playground service, as protoc-gen-go-grpc would from:
+//
+//	service Playground { rpc Hello(google.protobuf.StringValue) returns (google.protobuf.StringValue); }
+var grpcPlaygroundDesc = grpc.ServiceDesc{
+	ServiceName: "tlsplayground.Playground",
+	HandlerType: (*grpcPlayground)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Hello",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			name := new(wrapperspb.StringValue)
+			if err := dec(name); err != nil {
+				return nil, err
+			}
+			hello := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return srv.(grpcPlayground).Hello(ctx, req.(*wrapperspb.StringValue))
+			}
+			if interceptor == nil {
+				return hello(ctx, name)
+			}
+			return interceptor(ctx, name, &grpc.UnaryServerInfo{Server: srv, FullMethod: grpcHelloMethod}, hello)
+		},
+	}},
+}
+
+// grpcHelloServer implements the Playground service for the server.
+type grpcHelloServer struct {
+	idSource mtls.IdentitySource // See Server.ClientIDSource
+}
+
+// Hello greets the authenticated client like the HTTPS hello handler does.
+func (h grpcHelloServer) Hello(ctx context.Context, name *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	id, _ := grpcClientIdentity(ctx, h.idSource)
+	greeting := fmt.Sprintf("Hello, authenticated client '%s'!", id.CN)
+	if name.GetValue() != "" {
+		greeting = fmt.Sprintf("Hello %s, authenticated client '%s'!", name.GetValue(), id.CN)
+	}
+	return wrapperspb.String(greeting), nil
+}
+
+// grpcClientIdentity returns the identity of the client behind an RPC, from the verified TLS connection.
+func grpcClientIdentity(ctx context.Context, idSource mtls.IdentitySource) (ClientIdentity, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ClientIdentity{}, false
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.PeerCertificates) == 0 {
+		return ClientIdentity{}, false
+	}
+	return newClientIdentity(info.State.PeerCertificates[0], idSource), true
+}
+
+// grpcServerName returns the server name the client asked for in SNI, if any.
+func grpcServerName(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			return info.State.ServerName
+		}
+	}
+	return ""
+}
+
+// grpcAuthInterceptor applies what the HTTPS middleware does to every RPC: it refuses calls while the
+// server is degraded and from clients whose known clients entry was removed or expired since the handshake.
+func (s *Server) grpcAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if degraded, reason := s.Degraded(); degraded {
+		return nil, status.Errorf(codes.Unavailable, "server degraded: %s", reason)
+	}
+	id, ok := grpcClientIdentity(ctx, s.ClientIDSource)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "client certificate required")
+	}
+	if !s.knownClientEntryValid(grpcServerName(ctx), id.Certificate) {
+		logWarnf("Denied RPC %s from %s: known clients entry removed or expired", info.FullMethod, id.CN)
+		return nil, status.Error(codes.PermissionDenied, "client no longer authorized")
+	}
+	logInfof("Received RPC %s from %s (%s)", info.FullMethod, id.CN, id.Fingerprint)
+	return handler(ctx, req)
+}
+
+// startGRPCServer serves the Playground service on the listeners, in goroutines.
+func (s *Server) startGRPCServer(listeners []net.Listener, tlsConfig *tls.Config) {
+	s.grpcServer = grpc.NewServer(
+		grpc.Creds(credentials.NewTLS(tlsConfig)),
+		grpc.UnaryInterceptor(s.grpcAuthInterceptor),
+	)
+	s.grpcServer.RegisterService(&grpcPlaygroundDesc, grpcHelloServer{idSource: s.ClientIDSource})
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			if err := s.grpcServer.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+				logErrorf("gRPC server error on %s: %v", listener.Addr(), err)
+			} else {
+				logInfof("Server stopped gracefully on %s.", listener.Addr())
+			}
+		}(listener)
+	}
+}
+
+// stopGRPCServer waits for in-flight RPCs to finish until ctx expires, then closes all connections.
+func (s *Server) stopGRPCServer(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		logWarnf("In-flight RPCs did not finish within %s, closing their connections", s.ShutdownTimeout)
+		s.grpcServer.Stop()
+		return fmt.Errorf("shutdown timed out after %s: %w", s.ShutdownTimeout, ctx.Err())
+	}
+}
+
+// GRPCHello calls the Hello RPC of a server started with --mode grpc, at the host and port of ServerURL.
+func (c *Client) GRPCHello(name string) (string, error) {
+	addr, err := c.echoAddr()
+	if err != nil {
+		return "", err
+	}
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(c.tlsConfig.Clone())),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) { return c.dialContext(ctx, "tcp", addr) }))
+	if err != nil {
+		return "", fmt.Errorf("failed to create gRPC connection to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	timeout := c.RequestTimeout
+	if timeout <= 0 {
+		timeout = defaultGRPCTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	logInfof("Calling %s on %s...", grpcHelloMethod, addr)
+	reply := new(wrapperspb.StringValue)
+	if err := conn.Invoke(ctx, grpcHelloMethod, wrapperspb.String(name), reply); err != nil {
+		return "", fmt.Errorf("gRPC call failed: %w", err)
+	}
+	return reply.GetValue(), nil
+}
+
+// ClientGRPCCmd calls the Hello RPC of a server started with --mode grpc.
+type ClientGRPCCmd struct {
+	Name string `kong:"arg,optional,help='Name to send in the Hello request.'"`
+}
+
+// Run calls Hello over mTLS at the host and port of --url and prints the greeting.
+func (g *ClientGRPCCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	greeting, err := client.GRPCHello(g.Name)
+	if err != nil {
+		return err
+	}
+	outputf("Server Response:\n%s\n", greeting)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGRPCMode(t *testing.T) {
+	pki := newTestPKI(t)
+	server, url := startTestServer(t, pki, func(s *Server) { s.Mode = serverModeGRPC })
+	client, err := NewClient(url, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	greeting, err := client.GRPCHello("gopher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello gopher, authenticated client '" + pki.ClientCN + "'!"; greeting != want {
+		t.Errorf("Expected %q, got %q", want, greeting)
+	}
+
+	// Each call opens a new connection, which a removed client can't
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte("someone_else 00:11\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GRPCHello(""); err == nil {
+		t.Error("Expected a removed client to be rejected")
+	}
+}
+
+func TestGRPCModeRejectsUnknownClient(t *testing.T) {
+	pki := newTestPKI(t)
+	_, url := startTestServer(t, pki, func(s *Server) { s.Mode = serverModeGRPC })
+	client, _ := addNewClient(t, pki, url, "stranger")
+	_, err := client.GRPCHello("")
+	if err == nil || !strings.Contains(err.Error(), "gRPC call failed") {
+		t.Errorf("Expected an unknown client to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- Handshake Benchmark ---
+//
+// client bench handshake dials the server and does a TLS handshake, without sending a request, as many
+// times as asked for each TLS version, and reports latency percentiles per version, server key type and
+// handshake kind (full or resumed). Only the handshake is timed, not the TCP connect, so the results compare
+// versions and key types (run it against servers with RSA, ECDSA and Ed25519 certificates) rather than the
+// network. By default each handshake resumes a session of an earlier one where it can; --no-resumption
+// makes every handshake a full one. TLS 1.3 servers send session tickets after the handshake, so with
+// resumption the client waits briefly for them before closing, outside the timed part.
+
+// handshakeBenchTimeout bounds one connection, from dial to close.
+const handshakeBenchTimeout = 10 * time.Second
+
+// ClientBenchHandshakeCmd times raw TLS handshakes.
+type ClientBenchHandshakeCmd struct {
+	Handshakes   int      `kong:"name='handshakes',short='n',help='Handshakes to do for each TLS version.',default='100'"`
+	Concurrency  int      `kong:"name='concurrency',short='c',help='Handshakes in flight at a time. Above 1 the latencies include time spent queueing at the server.',default='1'"`
+	Versions     []string `kong:"name='versions',help='Comma-separated TLS versions to measure, one after the other.',default='1.2,1.3',sep=','"`
+	NoResumption bool     `kong:"name='no-resumption',help='Do a full handshake every time instead of resuming sessions.'"`
+	JSON         bool     `kong:"name='json',help='Print the report as JSON.'"`
+}
+
+// handshakeBenchOptions control a handshake benchmark, see ClientBenchHandshakeCmd.
+type handshakeBenchOptions struct {
+	Handshakes  int
+	Concurrency int
+	Versions    []uint16
+	Resumption  bool
+}
+
+// handshakeSample is one completed handshake.
+type handshakeSample struct {
+	version   uint16
+	serverKey string
+	resumed   bool
+	latency   time.Duration
+}
+
+// handshakeBenchRow summarizes the handshakes of one TLS version, server key and kind.
+type handshakeBenchRow struct {
+	TLSVersion string  `json:"tls_version"`
+	ServerKey  string  `json:"server_key"`
+	Resumed    bool    `json:"resumed"`
+	Handshakes int     `json:"handshakes"`
+	P50Millis  float64 `json:"p50_ms"`
+	P95Millis  float64 `json:"p95_ms"`
+	P99Millis  float64 `json:"p99_ms"`
+	MaxMillis  float64 `json:"max_ms"`
+	version    uint16
+}
+
+// handshakeBenchReport is the outcome of a handshake benchmark.
+type handshakeBenchReport struct {
+	ClientKey string              `json:"client_key,omitempty"`
+	Results   []handshakeBenchRow `json:"results"`
+	Failed    int                 `json:"failed"`
+	Errors    map[string]int      `json:"errors,omitempty"`
+}
+
+// Run does the handshakes and prints the report.
+func (b *ClientBenchHandshakeCmd) Run(c *ClientCmd) error {
+	if b.Handshakes <= 0 {
+		return errors.New("--handshakes must be positive")
+	}
+	opts := handshakeBenchOptions{Handshakes: b.Handshakes, Concurrency: b.Concurrency, Resumption: !b.NoResumption}
+	for _, name := range b.Versions {
+		version, err := parseTLSVersion(name)
+		if err != nil {
+			return fmt.Errorf("invalid --versions: %w", err)
+		}
+		opts.Versions = append(opts.Versions, version)
+	}
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	report, err := client.HandshakeBench(opts)
+	if err != nil {
+		return err
+	}
+	if b.JSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		outputf("%s\n", out)
+	} else {
+		printHandshakeBenchReport(report)
+	}
+	if len(report.Results) == 0 {
+		return fmt.Errorf("all %d handshakes failed", report.Failed)
+	}
+	return nil
+}
+
+// HandshakeBench does opts.Handshakes TLS handshakes with the server for each of opts.Versions and
+// summarizes their latencies. It uses the client's TLS configuration, except for the versions and a
+// session cache of its own.
+func (c *Client) HandshakeBench(opts handshakeBenchOptions) (handshakeBenchReport, error) {
+	addr, err := c.echoAddr()
+	if err != nil {
+		return handshakeBenchReport{}, err
+	}
+	var report handshakeBenchReport
+	if leaf := c.clientLeaf(); leaf != nil {
+		report.ClientKey = keyLabel(publicKeyInfo(leaf))
+	}
+	var samples []handshakeSample
+	for _, version := range opts.Versions {
+		cfg := c.tlsConfig.Clone()
+		cfg.MinVersion, cfg.MaxVersion = version, version
+		if cfg.ServerName == "" {
+			u, _ := url.Parse(c.ServerURL) // Checked by echoAddr
+			cfg.ServerName = u.Hostname()
+		}
+		cfg.ClientSessionCache = nil
+		if opts.Resumption {
+			cfg.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+		}
+		logInfof("Timing %d %s handshakes with %s...", opts.Handshakes, tlsVersionName(version), addr)
+
+		var (
+			issued atomic.Int64
+			mu     sync.Mutex
+			wg     sync.WaitGroup
+		)
+		for i := 0; i < max(opts.Concurrency, 1); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for issued.Add(1) <= int64(opts.Handshakes) {
+					sample, err := c.timeHandshake(addr, cfg)
+					mu.Lock()
+					if err != nil {
+						report.Failed++
+						if report.Errors == nil {
+							report.Errors = make(map[string]int)
+						}
+						report.Errors[benchErrorKind(err)]++
+					} else {
+						samples = append(samples, sample)
+					}
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	report.Results = summarizeHandshakes(samples)
+	return report, nil
+}
+
+// timeHandshake connects to addr and times a handshake with cfg.
+func (c *Client) timeHandshake(addr string, cfg *tls.Config) (handshakeSample, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeBenchTimeout)
+	defer cancel()
+	conn, err := c.dialContext(ctx, "tcp", addr)
+	if err != nil {
+		return handshakeSample{}, err
+	}
+	conn.SetDeadline(time.Now().Add(handshakeBenchTimeout))
+	tlsConn := tls.Client(conn, cfg)
+	defer tlsConn.Close()
+	start := time.Now()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return handshakeSample{}, err
+	}
+	latency := time.Since(start)
+	state := tlsConn.ConnectionState()
+	if cfg.ClientSessionCache != nil && state.Version >= tls.VersionTLS13 {
+		// Tickets follow the handshake within a round trip, which the handshake took at least once.
+		// Reading processes them; the read itself times out unless the server speaks first (e.g. HTTP/2).
+		tlsConn.SetReadDeadline(time.Now().Add(latency + 5*time.Millisecond))
+		tlsConn.Read(make([]byte, 1))
+	}
+	sample := handshakeSample{version: state.Version, resumed: state.DidResume, latency: latency}
+	if len(state.PeerCertificates) > 0 {
+		sample.serverKey = keyLabel(publicKeyInfo(state.PeerCertificates[0]))
+	}
+	return sample, nil
+}
+
+// keyLabel names a key type by its algorithm, and size for RSA, e.g. "RSA 2048" or "ECDSA P-256".
+func keyLabel(algorithm string, bits int) string {
+	if algorithm == "RSA" {
+		return fmt.Sprintf("%s %d", algorithm, bits)
+	}
+	return algorithm
+}
+
+// summarizeHandshakes groups samples by TLS version, server key and kind, in that order, and computes
+// the latency percentiles of each group.
+func summarizeHandshakes(samples []handshakeSample) []handshakeBenchRow {
+	type groupKey struct {
+		version   uint16
+		serverKey string
+		resumed   bool
+	}
+	groups := make(map[groupKey]latencySample)
+	for _, s := range samples {
+		key := groupKey{s.version, s.serverKey, s.resumed}
+		groups[key] = append(groups[key], s.latency)
+	}
+	rows := make([]handshakeBenchRow, 0, len(groups))
+	for key, latencies := range groups {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		rows = append(rows, handshakeBenchRow{
+			TLSVersion: tlsVersionName(key.version),
+			ServerKey:  key.serverKey,
+			Resumed:    key.resumed,
+			Handshakes: len(latencies),
+			P50Millis:  millis(latencies.percentile(50)),
+			P95Millis:  millis(latencies.percentile(95)),
+			P99Millis:  millis(latencies.percentile(99)),
+			MaxMillis:  millis(latencies[len(latencies)-1]),
+			version:    key.version,
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.version != b.version {
+			return a.version < b.version
+		}
+		if a.ServerKey != b.ServerKey {
+			return a.ServerKey < b.ServerKey
+		}
+		return !a.Resumed && b.Resumed
+	})
+	return rows
+}
+
+// printHandshakeBenchReport prints a table with a row per TLS version, server key and kind.
+func printHandshakeBenchReport(r handshakeBenchReport) {
+	if r.ClientKey != "" {
+		outputf("Client key: %s\n", r.ClientKey)
+	}
+	outputf("%-10s %-12s %-8s %6s %9s %9s %9s %9s\n", "Version", "Server key", "Kind", "Count", "p50", "p95", "p99", "max")
+	for _, row := range r.Results {
+		kind := "full"
+		if row.Resumed {
+			kind = "resumed"
+		}
+		outputf("%-10s %-12s %-8s %6d %7.2fms %7.2fms %7.2fms %7.2fms\n",
+			row.TLSVersion, row.ServerKey, kind, row.Handshakes, row.P50Millis, row.P95Millis, row.P99Millis, row.MaxMillis)
+	}
+	kinds := make([]string, 0, len(r.Errors))
+	for kind := range r.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return r.Errors[kinds[i]] > r.Errors[kinds[j]] })
+	for _, kind := range kinds {
+		outputf("Failed: %d %s\n", r.Errors[kind], kind)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestHandshakeBench(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, resumption := range []bool{true, false} {
+		opts := handshakeBenchOptions{Handshakes: 4, Concurrency: 1, Versions: []uint16{tls.VersionTLS12, tls.VersionTLS13}, Resumption: resumption}
+		report, err := client.HandshakeBench(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Failed != 0 || report.ClientKey == "" {
+			t.Fatalf("Unexpected report %+v", report)
+		}
+		counts := make(map[string]int)
+		for _, row := range report.Results {
+			if row.ServerKey == "" || row.P50Millis <= 0 || row.P99Millis < row.P50Millis || row.MaxMillis < row.P99Millis {
+				t.Errorf("Unexpected row %+v", row)
+			}
+			kind := "full"
+			if row.Resumed {
+				kind = "resumed"
+			}
+			counts[row.TLSVersion+" "+kind] += row.Handshakes
+		}
+		for _, version := range []string{"TLS 1.2", "TLS 1.3"} {
+			if resumption {
+				// The first handshake has no session to resume.
+				if counts[version+" full"] < 1 || counts[version+" resumed"] < 1 || counts[version+" full"]+counts[version+" resumed"] != 4 {
+					t.Errorf("Expected full and resumed %s handshakes, got %v", version, counts)
+				}
+			} else if counts[version+" full"] != 4 || counts[version+" resumed"] != 0 {
+				t.Errorf("Expected 4 full %s handshakes without resumption, got %v", version, counts)
+			}
+		}
+	}
+}
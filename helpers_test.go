@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// testPKI holds a set of freshly generated certificates in a temporary directory,
+// so tests don't depend on ./setup.sh having been run.
+type testPKI struct {
+	Dir              string
+	ServerCertFile   string
+	ServerKeyFile    string
+	ClientCertFile   string
+	ClientKeyFile    string
+	KnownClientsFile string
+	ClientCN         string
+}
+
+// newTestPKI generates a server certificate for localhost and a client certificate
+// that is listed in the known clients file.
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	dir := t.TempDir()
+	pki := &testPKI{
+		Dir:              dir,
+		ServerCertFile:   filepath.Join(dir, "server.crt"),
+		ServerKeyFile:    filepath.Join(dir, "server.key"),
+		ClientCertFile:   filepath.Join(dir, "client.crt"),
+		ClientKeyFile:    filepath.Join(dir, "client.key"),
+		KnownClientsFile: filepath.Join(dir, "knownClients.txt"),
+		ClientCN:         "test_client",
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{
+		CommonName:  "localhost",
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("::1")},
+	})
+	if err != nil {
+		t.Fatalf("Failed to generate server certificate: %v", err)
+	}
+	if err := writeCertFiles(pki.ServerCertFile, pki.ServerKeyFile, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+
+	fingerprint := pki.newClientCert(t, pki.ClientCN, pki.ClientCertFile, pki.ClientKeyFile)
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(fmt.Sprintf("%s %s\n", pki.ClientCN, fingerprint)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return pki
+}
+
+// newClientCert generates a client certificate with the given CN and returns its fingerprint.
+// The certificate is not added to the known clients file.
+func (p *testPKI) newClientCert(t *testing.T, cn, certFile, keyFile string) string {
+	t.Helper()
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: cn})
+	if err != nil {
+		t.Fatalf("Failed to generate client certificate: %v", err)
+	}
+	if err := writeCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := loadCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mtls.CertFingerprint(cert)
+}
+
+// freeAddr returns a localhost address with a port that was free at the time of the call.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	return freeAddrOn(t, "localhost")
+}
+
+// freeAddrOn returns a host:port address on the given host with a port that was free at the time of the call.
+func freeAddrOn(t *testing.T, host string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startTestServer starts a server for the PKI on a port chosen by the OS and returns it with its base URL.
+// The configure callback, if any, runs before Start. The server is stopped when the test ends.
+func startTestServer(t *testing.T, pki *testPKI, configure func(*Server)) (*Server, string) {
+	t.Helper()
+	server := NewServer("127.0.0.1:0", pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	if configure != nil {
+		configure(server)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return server, "https://" + server.ListenAddr()
+}
+
+// newTestClient returns a client of the PKI for the /hello endpoint of the server at baseURL.
+func newTestClient(t *testing.T, pki *testPKI, baseURL string) *Client {
+	t.Helper()
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	return client
+}
+
+// reissueCert writes a new self-signed certificate for cn to newCertFile, reusing the key of certFile/keyFile.
+// The new certificate has no SANs.
+func reissueCert(t *testing.T, certFile, keyFile, cn, newCertFile string) {
+	t.Helper()
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := pair.PrivateKey.(crypto.Signer)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(newCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
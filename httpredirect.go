@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// --- Plain-HTTP Listener ---
+//
+// Trying the server with `curl http://...` otherwise gets Go's terse "Client sent an HTTP request to an
+// HTTPS server". With HTTPAddr the server also listens for plain HTTP and redirects every request to the
+// same URL on the HTTPS listener (308, so the method and body are kept). Following the redirect alone
+// still fails the handshake, so the response body explains how to present a client certificate. With
+// HTTPNoRedirect the listener only answers with that explanation, for setups where the HTTPS port isn't
+// reachable under the host the client used.
+
+// httpRedirectHandler answers plain-HTTP requests with a redirect to httpsPort and an explanation.
+func (s *Server) httpRedirectHandler(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := hostOf(r.Host)
+		if host == "" {
+			host = "localhost"
+		}
+		if httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		logDebugf("Plain-HTTP request %s %s from %s, pointing it to %s", r.Method, r.URL.Path, r.RemoteAddr, target)
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if s.HTTPNoRedirect {
+			w.WriteHeader(http.StatusBadRequest)
+		} else {
+			w.Header().Set("Location", target)
+			w.WriteHeader(http.StatusPermanentRedirect)
+		}
+		fmt.Fprintf(w, "This server only accepts HTTPS with a client certificate (mutual TLS).\n")
+		fmt.Fprintf(w, "Retry at %s and present a certificate listed in its known clients file, e.g.:\n\n", target)
+		fmt.Fprintf(w, "  curl --cert certs/client.crt --key certs/client.key --cacert certs/server.crt %s\n", target)
+		fmt.Fprintf(w, "  go run . client --url %s\n", target)
+	})
+}
+
+// startHTTPServer starts the plain-HTTP listener if HTTPAddr is set, pointing clients to the HTTPS
+// listener bound at httpsAddr.
+func (s *Server) startHTTPServer(httpsAddr net.Addr) error {
+	if s.HTTPAddr == "" {
+		return nil
+	}
+	if err := validateAddr(s.HTTPAddr); err != nil {
+		return err
+	}
+	if s.Mode != serverModeHTTPS {
+		return fmt.Errorf("a plain-HTTP listener needs --mode %s, there is nothing to redirect to in --mode %s", serverModeHTTPS, s.Mode)
+	}
+	if httpsAddr.Network() != "tcp" {
+		return fmt.Errorf("plain-HTTP clients can only be redirected to a TCP address, not %s", httpsAddr)
+	}
+	_, httpsPort, err := net.SplitHostPort(httpsAddr.String())
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", s.HTTPAddr)
+	if err != nil {
+		return err
+	}
+	s.plainServer = &http.Server{Handler: s.httpRedirectHandler(httpsPort), ErrorLog: newLevelLogger(levelError)}
+	if s.HTTPNoRedirect {
+		logInfof("Explaining mTLS to plain-HTTP clients on http://%s", listener.Addr())
+	} else {
+		logInfof("Redirecting plain-HTTP clients from http://%s to port %s", listener.Addr(), httpsPort)
+	}
+	go func() {
+		if err := s.plainServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErrorf("Plain-HTTP server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopHTTPServer shuts down the plain-HTTP listener, if running.
+func (s *Server) stopHTTPServer(ctx context.Context) error {
+	if s.plainServer == nil {
+		return nil
+	}
+	return s.plainServer.Shutdown(ctx)
+}
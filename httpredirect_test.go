@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// getPlainHTTP sends a GET over plain HTTP without following redirects.
+func getPlainHTTP(t *testing.T, url string) (*http.Response, string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+func TestHTTPRedirect(t *testing.T) {
+	pki := newTestPKI(t)
+	httpAddr := freeAddr(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.HTTPAddr = httpAddr
+	})
+
+	resp, body := getPlainHTTP(t, "http://"+httpAddr+"/hello?name=x")
+	if resp.StatusCode != http.StatusPermanentRedirect {
+		t.Fatalf("Expected a permanent redirect, got %d", resp.StatusCode)
+	}
+	if want := baseURL + "/hello?name=x"; resp.Header.Get("Location") != want {
+		t.Errorf("Expected a redirect to %s, got %s", want, resp.Header.Get("Location"))
+	}
+	if !strings.Contains(body, "client certificate") || !strings.Contains(body, "curl --cert") {
+		t.Errorf("Expected the body to explain how to present a client certificate, got %q", body)
+	}
+}
+
+func TestHTTPNoRedirect(t *testing.T) {
+	pki := newTestPKI(t)
+	httpAddr := freeAddr(t)
+	startTestServer(t, pki, func(s *Server) {
+		s.HTTPAddr = httpAddr
+		s.HTTPNoRedirect = true
+	})
+
+	resp, body := getPlainHTTP(t, "http://"+httpAddr+"/")
+	if resp.StatusCode != http.StatusBadRequest || resp.Header.Get("Location") != "" {
+		t.Errorf("Expected a 400 without a redirect, got %d to %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	if !strings.Contains(body, "client certificate") {
+		t.Errorf("Expected the body to explain the client certificate requirement, got %q", body)
+	}
+}
+
+func TestHTTPRedirectNeedsHTTPSMode(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.Mode = serverModeTCP
+	server.HTTPAddr = freeAddr(t)
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Error("Expected a plain-HTTP listener in TCP mode to fail Start")
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- HTTP Message Signatures ---
+//
+// mTLS authenticates the connection; a message signature (RFC 9421) authenticates one request, and
+// would survive a TLS-terminating proxy that mTLS does not. /signed demonstrates the two together: the
+// client signs the method, the target URI and, with a body, its Content-Digest (RFC 9530) with the
+// private key of its TLS certificate, and the server verifies the signature with the public key of the
+// certificate presented in the handshake, which the known clients file pinned. The keyid parameter must
+// name that key by its SPKI fingerprint, as spki: known clients entries do, so a request signed with any
+// other key is refused even over an authenticated connection. Signatures older or newer than
+// httpSigMaxSkew are refused too; within that window a captured request could be replayed, as nothing
+// records the signatures already seen. `client sign` sends the configured request signed.
+
+const (
+	httpSigPath     = "/signed"
+	httpSigLabel    = "sig1"
+	httpSigMaxSkew  = 5 * time.Minute
+	httpSigMaxBody  = 1 << 20
+	headerSigInput  = "Signature-Input"
+	headerSignature = "Signature"
+	headerDigest    = "Content-Digest"
+)
+
+// httpSigResponse is returned by the /signed endpoint.
+type httpSigResponse struct {
+	Verified      bool       `json:"verified"`
+	Error         string     `json:"error,omitempty"`
+	TLSClientCN   string     `json:"tls_client_cn"`
+	KeyID         string     `json:"keyid,omitempty"`
+	Alg           string     `json:"alg,omitempty"`
+	Components    []string   `json:"components,omitempty"`
+	Created       *time.Time `json:"created,omitempty"`
+	SignatureBase string     `json:"signature_base,omitempty"` // What was signed, to show how the base is built
+}
+
+// httpSigParams are the parsed signature parameters of one Signature-Input member.
+type httpSigParams struct {
+	components []string
+	created    int64
+	expires    int64
+	keyID      string
+	alg        string
+	raw        string // The serialized member value, the last line of the signature base
+}
+
+// httpSigAlgorithm returns the RFC 9421 algorithm name for a public key.
+func httpSigAlgorithm(pub crypto.PublicKey) (string, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return "rsa-v1_5-sha256", nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return "ecdsa-p256-sha256", nil
+		case elliptic.P384():
+			return "ecdsa-p384-sha384", nil
+		}
+		return "", fmt.Errorf("unsupported ECDSA curve %s", key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return "ed25519", nil
+	}
+	return "", fmt.Errorf("unsupported public key type %T", pub)
+}
+
+// httpSigSign signs base as alg specifies. ECDSA signatures are the fixed-size r||s concatenation
+// RFC 9421 uses, not ASN.1 like proof-of-possession signatures.
+func httpSigSign(signer crypto.Signer, alg string, base []byte) ([]byte, error) {
+	switch alg {
+	case "ed25519":
+		return signer.Sign(rand.Reader, base, crypto.Hash(0))
+	case "rsa-v1_5-sha256":
+		digest := sha256.Sum256(base)
+		return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case "ecdsa-p256-sha256", "ecdsa-p384-sha384":
+		digest, hash, size := httpSigECDSADigest(alg, base)
+		der, err := signer.Sign(rand.Reader, digest, hash)
+		if err != nil {
+			return nil, err
+		}
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(der, &sig); err != nil {
+			return nil, fmt.Errorf("failed to parse ECDSA signature: %w", err)
+		}
+		raw := make([]byte, 2*size)
+		sig.R.FillBytes(raw[:size])
+		sig.S.FillBytes(raw[size:])
+		return raw, nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+// httpSigECDSADigest hashes base for an ECDSA algorithm and returns the size of r and s.
+func httpSigECDSADigest(alg string, base []byte) ([]byte, crypto.Hash, int) {
+	if alg == "ecdsa-p384-sha384" {
+		digest := sha512.Sum384(base)
+		return digest[:], crypto.SHA384, 48
+	}
+	digest := sha256.Sum256(base)
+	return digest[:], crypto.SHA256, 32
+}
+
+// httpSigVerify checks a signature produced by httpSigSign with alg, which must suit the key.
+func httpSigVerify(pub crypto.PublicKey, alg string, base, sig []byte) error {
+	want, err := httpSigAlgorithm(pub)
+	if err != nil {
+		return err
+	}
+	if alg != want {
+		return fmt.Errorf("algorithm %q does not match the %s key of the client certificate", alg, want)
+	}
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, base, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(base)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("invalid RSA signature")
+		}
+	case *ecdsa.PublicKey:
+		digest, _, size := httpSigECDSADigest(alg, base)
+		if len(sig) != 2*size {
+			return fmt.Errorf("ECDSA signature is %d bytes, want %d", len(sig), 2*size)
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+	}
+	return nil
+}
+
+// httpSigComponentValue returns the value of a covered component of req, a derived component such as
+// @method or a header field. It works on both sides: a client request has an absolute URL, a server
+// request a Host and a request URI.
+func httpSigComponentValue(req *http.Request, component string) (string, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	switch component {
+	case "@method":
+		return req.Method, nil
+	case "@target-uri":
+		return "https://" + strings.ToLower(host) + req.URL.RequestURI(), nil
+	case "@authority":
+		return strings.ToLower(host), nil
+	case "@path":
+		return req.URL.EscapedPath(), nil
+	case "@query":
+		return "?" + req.URL.RawQuery, nil
+	}
+	if strings.HasPrefix(component, "@") {
+		return "", fmt.Errorf("unsupported derived component %q", component)
+	}
+	var values []string
+	for _, value := range req.Header.Values(component) {
+		values = append(values, strings.TrimSpace(value))
+	}
+	if len(values) == 0 {
+		return "", fmt.Errorf("covered header %q is missing", component)
+	}
+	return strings.Join(values, ", "), nil
+}
+
+// httpSigBase builds the signature base (RFC 9421 section 2.5) of req for params.
+func httpSigBase(req *http.Request, params httpSigParams) (string, error) {
+	var b strings.Builder
+	for _, component := range params.components {
+		value, err := httpSigComponentValue(req, component)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%q: %s\n", component, value)
+	}
+	fmt.Fprintf(&b, "%q: %s", "@signature-params", params.raw)
+	return b.String(), nil
+}
+
+// serialize renders params as a Signature-Input member value and stores it in raw.
+func (p *httpSigParams) serialize() {
+	quoted := make([]string, len(p.components))
+	for i, component := range p.components {
+		quoted[i] = strconv.Quote(component)
+	}
+	p.raw = fmt.Sprintf("(%s);created=%d;keyid=%s;alg=%s", strings.Join(quoted, " "), p.created, strconv.Quote(p.keyID), strconv.Quote(p.alg))
+}
+
+// splitSFList splits a structured field dictionary or parameter list at sep, outside quoted strings
+// and inner lists.
+func splitSFList(value string, sep byte) []string {
+	var parts []string
+	depth, quoted, start := 0, false, 0
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && c == '(':
+			depth++
+		case !quoted && c == ')':
+			depth--
+		case !quoted && depth == 0 && c == sep:
+			parts = append(parts, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(value[start:]))
+}
+
+// sfDictionaryMember returns the value of label in a structured field dictionary.
+func sfDictionaryMember(header, label string) (string, bool) {
+	for _, member := range splitSFList(header, ',') {
+		if name, value, ok := strings.Cut(member, "="); ok && strings.TrimSpace(name) == label {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
+// sfByteSequence decodes a structured field byte sequence, :base64:.
+func sfByteSequence(value string) ([]byte, error) {
+	if len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+		return nil, fmt.Errorf("%q is not a byte sequence", value)
+	}
+	return base64.StdEncoding.DecodeString(value[1 : len(value)-1])
+}
+
+// parseHTTPSigParams parses a Signature-Input member value such as
+// ("@method" "@target-uri");created=1700000000;keyid="AB:CD:...";alg="ed25519".
+func parseHTTPSigParams(value string) (httpSigParams, error) {
+	p := httpSigParams{raw: value}
+	parts := splitSFList(value, ';')
+	list := parts[0]
+	if !strings.HasPrefix(list, "(") || !strings.HasSuffix(list, ")") {
+		return p, fmt.Errorf("covered components must be an inner list, got %q", list)
+	}
+	for _, item := range strings.Fields(list[1 : len(list)-1]) {
+		component, err := strconv.Unquote(item)
+		if err != nil || !strings.HasPrefix(item, `"`) {
+			return p, fmt.Errorf("covered component %s must be a plain quoted string", item)
+		}
+		if component != strings.ToLower(component) {
+			return p, fmt.Errorf("covered component %q must be lowercase", component)
+		}
+		p.components = append(p.components, component)
+	}
+	for _, param := range parts[1:] {
+		name, raw, _ := strings.Cut(param, "=")
+		var err error
+		switch name {
+		case "created":
+			p.created, err = strconv.ParseInt(raw, 10, 64)
+		case "expires":
+			p.expires, err = strconv.ParseInt(raw, 10, 64)
+		case "keyid":
+			p.keyID, err = strconv.Unquote(raw)
+		case "alg":
+			p.alg, err = strconv.Unquote(raw)
+		}
+		if err != nil {
+			return p, fmt.Errorf("invalid %s parameter %q", name, raw)
+		}
+	}
+	return p, nil
+}
+
+// covers reports whether the signature covers component.
+func (p httpSigParams) covers(component string) bool {
+	for _, c := range p.components {
+		if c == component {
+			return true
+		}
+	}
+	return false
+}
+
+// contentDigest returns the Content-Digest field value of body.
+func contentDigest(body []byte) string {
+	digest := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(digest[:]) + ":"
+}
+
+// verifyHTTPSignature checks the signature of r, whose body has been read, against leaf. It returns the
+// parsed parameters and the signature base along with the first problem found.
+func verifyHTTPSignature(r *http.Request, body []byte, leaf *x509.Certificate, now time.Time) (httpSigParams, string, error) {
+	input, ok := sfDictionaryMember(r.Header.Get(headerSigInput), httpSigLabel)
+	if !ok {
+		return httpSigParams{}, "", fmt.Errorf("no %s signature in the %s header", httpSigLabel, headerSigInput)
+	}
+	params, err := parseHTTPSigParams(input)
+	if err != nil {
+		return params, "", err
+	}
+	member, ok := sfDictionaryMember(r.Header.Get(headerSignature), httpSigLabel)
+	if !ok {
+		return params, "", fmt.Errorf("no %s signature in the %s header", httpSigLabel, headerSignature)
+	}
+	sig, err := sfByteSequence(member)
+	if err != nil {
+		return params, "", fmt.Errorf("invalid %s header: %w", headerSignature, err)
+	}
+
+	switch {
+	case !params.covers("@method"):
+		return params, "", errors.New("the signature must cover @method")
+	case !params.covers("@target-uri") && !(params.covers("@authority") && params.covers("@path")):
+		return params, "", errors.New("the signature must cover @target-uri, or @authority and @path")
+	case len(body) > 0 && !params.covers("content-digest"):
+		return params, "", errors.New("the signature must cover content-digest when there is a body")
+	case params.created == 0:
+		return params, "", errors.New("the signature has no created parameter")
+	case now.Sub(time.Unix(params.created, 0)).Abs() > httpSigMaxSkew:
+		return params, "", fmt.Errorf("the signature was created at %s, more than %s from now", time.Unix(params.created, 0).UTC().Format(time.RFC3339), httpSigMaxSkew)
+	case params.expires != 0 && now.Unix() > params.expires:
+		return params, "", errors.New("the signature has expired")
+	case params.keyID != mtls.KeyFingerprint(leaf):
+		return params, "", fmt.Errorf("keyid %q is not the key of the TLS client certificate", params.keyID)
+	}
+	if params.covers("content-digest") && r.Header.Get(headerDigest) != contentDigest(body) {
+		return params, "", fmt.Errorf("the %s header does not match the body", headerDigest)
+	}
+	base, err := httpSigBase(r, params)
+	if err != nil {
+		return params, "", err
+	}
+	if params.alg == "" {
+		if params.alg, err = httpSigAlgorithm(leaf.PublicKey); err != nil {
+			return params, base, err
+		}
+	}
+	return params, base, httpSigVerify(leaf.PublicKey, params.alg, []byte(base), sig)
+}
+
+// httpSigHandler verifies the message signature of the request against the TLS client certificate.
+func (s *Server) httpSigHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ClientIdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, httpSigMaxBody))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, httpSigResponse{TLSClientCN: id.CN, Error: "failed to read the body: " + err.Error()})
+		return
+	}
+	params, base, err := verifyHTTPSignature(r, body, id.Certificate, time.Now())
+	resp := httpSigResponse{
+		TLSClientCN:   id.CN,
+		KeyID:         params.keyID,
+		Alg:           params.alg,
+		Components:    params.components,
+		SignatureBase: base,
+	}
+	if params.created != 0 {
+		created := time.Unix(params.created, 0).UTC()
+		resp.Created = &created
+	}
+	if err != nil {
+		logAuth(levelError, "Message signature rejected", append(requestAttrs(r), slog.String("reason", err.Error()))...)
+		resp.Error = err.Error()
+		writeJSON(w, http.StatusForbidden, resp)
+		return
+	}
+	logAuth(levelInfo, "Message signature verified", append(requestAttrs(r), slog.String("alg", params.alg))...)
+	resp.Verified = true
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// signRequest adds a Content-Digest for the body, if any, and a signature of req made with the client
+// certificate's key, keyed by its SPKI fingerprint.
+func (c *Client) signRequest(req *http.Request) error {
+	cert, leaf := c.certificate(), c.clientLeaf()
+	if cert == nil || leaf == nil {
+		return errors.New("signing requests needs a client certificate")
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("client private key does not support signing")
+	}
+	alg, err := httpSigAlgorithm(signer.Public())
+	if err != nil {
+		return err
+	}
+	params := httpSigParams{components: []string{"@method", "@target-uri"}, created: time.Now().Unix(), keyID: mtls.KeyFingerprint(leaf), alg: alg}
+	if len(c.Body) > 0 {
+		req.Header.Set(headerDigest, contentDigest(c.Body))
+		params.components = append(params.components, "content-digest")
+	}
+	params.serialize()
+	base, err := httpSigBase(req, params)
+	if err != nil {
+		return err
+	}
+	sig, err := httpSigSign(signer, alg, []byte(base))
+	if err != nil {
+		return fmt.Errorf("failed to sign the request: %w", err)
+	}
+	req.Header.Set(headerSigInput, httpSigLabel+"="+params.raw)
+	req.Header.Set(headerSignature, httpSigLabel+"=:"+base64.StdEncoding.EncodeToString(sig)+":")
+	logDebugf("Signed request with %s, signature base:\n%s", alg, base)
+
+	if c.tamperSignedBody { // Change the body the signature covers, to see the server refuse it
+		tampered := append(bytes.Clone(c.Body), " (tampered)"...)
+		req.Body, req.ContentLength = ioutil.NopCloser(bytes.NewReader(tampered)), int64(len(tampered))
+		req.GetBody = nil
+	}
+	return nil
+}
+
+// ClientSignCmd sends the request signed with the client certificate's key.
+type ClientSignCmd struct {
+	Path   string `kong:"name='path',help='Path on the --url server to send the signed request to.',default='/signed'"`
+	Tamper bool   `kong:"name='tamper',help='Change the body after signing it, so the server refuses the signature.'"`
+}
+
+// Run sends one signed request and prints the verification result of the server.
+func (s *ClientSignCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	if client.ServerURL, err = client.resolve(s.Path); err != nil {
+		return err
+	}
+	if s.Tamper && len(client.Body) == 0 {
+		return errors.New("--tamper changes the body, send one with --data")
+	}
+	client.signRequests, client.tamperSignedBody, client.JSON = true, s.Tamper, true
+	_, status, err := client.SendRequest()
+	if err != nil {
+		return fmt.Errorf("signed request failed: %w", err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("server refused the signed request with status %d", status)
+	}
+	return nil
+}
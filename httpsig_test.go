@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPSigSignVerify(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256Key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	base := []byte("\"@method\": POST\n\"@signature-params\": (\"@method\");created=1")
+
+	for _, signer := range []crypto.Signer{rsaKey, p256Key, p384Key, edKey} {
+		alg, err := httpSigAlgorithm(signer.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := httpSigSign(signer, alg, base)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if err := httpSigVerify(signer.Public(), alg, base, sig); err != nil {
+			t.Errorf("%s: expected the signature to verify, got %v", alg, err)
+		}
+		if err := httpSigVerify(signer.Public(), alg, append(base, '!'), sig); err == nil {
+			t.Errorf("%s: expected a signature over another base to fail", alg)
+		}
+	}
+	sig, _ := httpSigSign(p256Key, "ecdsa-p256-sha256", base)
+	if err := httpSigVerify(p256Key.Public(), "ed25519", base, sig); err == nil {
+		t.Error("Expected an algorithm not matching the key to be refused")
+	}
+}
+
+func TestParseHTTPSigParams(t *testing.T) {
+	value := `("@method" "@target-uri" "content-digest");created=1700000000;keyid="AB:CD";alg="ed25519"`
+	p, err := parseHTTPSigParams(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(p.components, " ") != "@method @target-uri content-digest" || p.created != 1700000000 || p.keyID != "AB:CD" || p.alg != "ed25519" {
+		t.Errorf("Unexpected params: %+v", p)
+	}
+	p.serialize()
+	if p.raw != value {
+		t.Errorf("Expected serialize to round trip, got %s", p.raw)
+	}
+	if member, ok := sfDictionaryMember(`other=("a";b), sig1=`+value, "sig1"); !ok || member != value {
+		t.Errorf("Expected the sig1 member, got %q", member)
+	}
+	for _, bad := range []string{`"@method"`, `(@method)`, `("@Method")`, `("@method");created=soon`} {
+		if _, err := parseHTTPSigParams(bad); err == nil {
+			t.Errorf("Expected %s to be refused", bad)
+		}
+	}
+}
+
+func TestSignedRequest(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client := newTestClient(t, pki, baseURL)
+	client.ServerURL = baseURL + httpSigPath
+	client.Body = []byte("hello")
+	client.signRequests = true
+
+	body, status, err := client.SendRequest()
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the signed request to verify, got %d %s (%v)", status, body, err)
+	}
+	var resp httpSigResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Verified || resp.TLSClientCN != pki.ClientCN || resp.Alg != "rsa-v1_5-sha256" ||
+		!strings.Contains(resp.SignatureBase, `"@target-uri": `+baseURL+httpSigPath) {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	client.tamperSignedBody = true
+	if body, status, _ := client.SendRequest(); status != http.StatusForbidden || !strings.Contains(body, "Content-Digest") {
+		t.Errorf("Expected a tampered body to be refused, got %d %s", status, body)
+	}
+
+	client.signRequests, client.tamperSignedBody = false, false
+	client.Header = http.Header{
+		headerSigInput:  {`sig1=("@method" "@target-uri" "content-digest");created=` + strconv.FormatInt(time.Now().Unix(), 10) + `;keyid="AB:CD"`},
+		headerSignature: {"sig1=:AAAA:"},
+		headerDigest:    {contentDigest(client.Body)},
+	}
+	if body, status, _ := client.SendRequest(); status != http.StatusForbidden || !strings.Contains(body, "keyid") {
+		t.Errorf("Expected a keyid other than the TLS key to be refused, got %d %s", status, body)
+	}
+
+	client.Header = nil
+	if _, status, _ := client.SendRequest(); status != http.StatusForbidden {
+		t.Errorf("Expected an unsigned request to be refused, got %d", status)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Client Identity in the Request Context ---
+//
+// withClientIdentity parses the verified client certificate once per request and stores the result
+// in the request context, so handlers can make authorization decisions with ClientIdentityFromContext
+// instead of digging through r.TLS.
+
+// ClientIdentity is what the server knows about the client behind a request.
+type ClientIdentity struct {
+	CN string
+	// ID identifies the client in the known clients file: the CN, or the SAN selected by the server's
+	// ClientIDSource. It is empty if the certificate has no such SAN.
+	ID             string
+	Fingerprint    string // SHA-256 of the certificate, as in the known clients file
+	Organizations  []string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []string
+	URIs           []string
+	// Certificate is the client's leaf certificate, for anything not covered above.
+	Certificate *x509.Certificate
+}
+
+// newClientIdentity extracts the identity from a client certificate, identified by the field idSource selects.
+func newClientIdentity(cert *x509.Certificate, idSource mtls.IdentitySource) ClientIdentity {
+	clientID, _ := idSource.ClientID(cert)
+	id := ClientIdentity{
+		CN:             cert.Subject.CommonName,
+		ID:             clientID,
+		Fingerprint:    mtls.CertFingerprint(cert),
+		Organizations:  cert.Subject.Organization,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		Certificate:    cert,
+	}
+	for _, ip := range cert.IPAddresses {
+		id.IPAddresses = append(id.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		id.URIs = append(id.URIs, uri.String())
+	}
+	return id
+}
+
+// clientIdentityKey is the context key of the ClientIdentity.
+type clientIdentityKey struct{}
+
+// ClientIdentityFromContext returns the identity of the client that sent the request whose context
+// this is. It is false for requests without a client certificate.
+func ClientIdentityFromContext(ctx context.Context) (ClientIdentity, bool) {
+	id, ok := ctx.Value(clientIdentityKey{}).(ClientIdentity)
+	return id, ok
+}
+
+// withClientIdentity adds the ClientIdentity of the request's client certificate to the request context.
+func (s *Server) withClientIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			id := newClientIdentity(r.TLS.PeerCertificates[0], s.ClientIDSource)
+			r = r.WithContext(context.WithValue(r.Context(), clientIdentityKey{}, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- Identity Endpoint ---
+//
+// GET /identity answers with what the server saw of the client: its certificate, as parsed into the
+// ClientIdentity, and the TLS connection it came over. The CN and fingerprint are also set as
+// X-Client-CN and X-Client-Fingerprint response headers, the ones a backend gets (see proxy.go), so
+// `curl -i` shows them at a glance. Like every endpoint, it needs a client certificate the server accepts.
+
+const identityPath = "/identity"
+
+// identityResponse is the JSON document served at identityPath.
+type identityResponse struct {
+	CN             string    `json:"cn"`
+	ClientID       string    `json:"client_id,omitempty"` // If it isn't the CN, see --client-id-source
+	Organizations  []string  `json:"organizations,omitempty"`
+	DNSNames       []string  `json:"dns_names,omitempty"`
+	EmailAddresses []string  `json:"email_addresses,omitempty"`
+	IPAddresses    []string  `json:"ip_addresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	Fingerprint    string    `json:"fingerprint"`     // As in the known clients file
+	KeyFingerprint string    `json:"key_fingerprint"` // For spki: known clients entries
+	Issuer         string    `json:"issuer"`
+	Serial         string    `json:"serial"`
+	NotBefore      time.Time `json:"not_before"`
+	NotAfter       time.Time `json:"not_after"`
+	ChainLength    int       `json:"chain_length"` // Certificates the client sent
+	TLSVersion     string    `json:"tls_version"`
+	CipherSuite    string    `json:"cipher_suite"`
+	ALPN           string    `json:"alpn,omitempty"` // Empty if none was negotiated
+	ServerName     string    `json:"server_name,omitempty"`
+	Resumed        bool      `json:"resumed"`
+	HTTPProtocol   string    `json:"http_protocol"`
+	RemoteAddr     string    `json:"remote_addr"`
+}
+
+// clientIDIfNotCN returns the ID of the client if it is identified by something other than its CN.
+func clientIDIfNotCN(id ClientIdentity) string {
+	if id.ID == id.CN {
+		return ""
+	}
+	return id.ID
+}
+
+// identityHandler serves the identity of the client and its connection.
+func (s *Server) identityHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ClientIdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	logAuth(levelInfo, "Client requested its identity", requestAttrs(r)...)
+	cert := id.Certificate
+	w.Header().Set(headerClientCN, id.CN)
+	w.Header().Set(headerClientFingerprint, id.Fingerprint)
+	writeJSON(w, http.StatusOK, identityResponse{
+		CN:             id.CN,
+		ClientID:       clientIDIfNotCN(id),
+		Organizations:  id.Organizations,
+		DNSNames:       id.DNSNames,
+		EmailAddresses: id.EmailAddresses,
+		IPAddresses:    id.IPAddresses,
+		URIs:           id.URIs,
+		Fingerprint:    id.Fingerprint,
+		KeyFingerprint: mtls.KeyFingerprint(cert),
+		Issuer:         cert.Issuer.String(),
+		Serial:         cert.SerialNumber.String(),
+		NotBefore:      cert.NotBefore,
+		NotAfter:       cert.NotAfter,
+		ChainLength:    len(r.TLS.PeerCertificates),
+		TLSVersion:     tlsVersionName(r.TLS.Version),
+		CipherSuite:    tls.CipherSuiteName(r.TLS.CipherSuite),
+		ALPN:           r.TLS.NegotiatedProtocol,
+		ServerName:     r.TLS.ServerName,
+		Resumed:        r.TLS.DidResume,
+		HTTPProtocol:   r.Proto,
+		RemoteAddr:     r.RemoteAddr,
+	})
+}
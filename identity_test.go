@@ -0,0 +1,204 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestClientIdentityInContext(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/workload")
+	cert := &x509.Certificate{
+		Raw:            []byte("not a real certificate"),
+		Subject:        pkix.Name{CommonName: "workload", Organization: []string{"Example"}},
+		DNSNames:       []string{"workload.example.org"},
+		EmailAddresses: []string{"ops@example.org"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffe},
+	}
+
+	var got ClientIdentity
+	var found bool
+	handler := (&Server{}).withClientIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, found = ClientIdentityFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	want := ClientIdentity{
+		CN:             "workload",
+		ID:             "workload",
+		Fingerprint:    mtls.CertFingerprint(cert),
+		Organizations:  []string{"Example"},
+		DNSNames:       []string{"workload.example.org"},
+		EmailAddresses: []string{"ops@example.org"},
+		IPAddresses:    []string{"10.0.0.1"},
+		URIs:           []string{"spiffe://example.org/workload"},
+		Certificate:    cert,
+	}
+	if !found || !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected identity %+v, got %+v (found %t)", want, got, found)
+	}
+
+	// Without a client certificate there is no identity.
+	found = true
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if found {
+		t.Error("Expected no identity for a request without a client certificate")
+	}
+}
+
+func TestIdentityEndpoint(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+identityPath, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetALPN([]string{alpnHTTP11})
+	body, status, err := client.SendRequest()
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the identity, got %d (%v)", status, err)
+	}
+	var got identityResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatalf("Expected a JSON identity, got %q: %v", body, err)
+	}
+
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CN != pki.ClientCN || got.Fingerprint != mtls.CertFingerprint(cert) || got.KeyFingerprint != mtls.KeyFingerprint(cert) {
+		t.Errorf("Expected the identity of %s, got %+v", pki.ClientCN, got)
+	}
+	if got.Issuer != cert.Issuer.String() || got.Serial != cert.SerialNumber.String() || got.ChainLength != 1 {
+		t.Errorf("Expected the issuer, serial and chain of the client certificate, got %+v", got)
+	}
+	if got.TLSVersion != "TLS 1.3" || got.CipherSuite == "" || got.ALPN != alpnHTTP11 || got.ServerName != "" {
+		t.Errorf("Expected the negotiated connection parameters, got %+v", got)
+	}
+}
+
+func TestServerIdentifiesClientsBySAN(t *testing.T) {
+	pki := newTestPKI(t)
+	newSPIFFEClient := func(name, id string) (certFile, keyFile string) {
+		opts := certOptions{} // No CN, as is common for SPIFFE certificates
+		if err := opts.setSANs([]string{id}); err != nil {
+			t.Fatal(err)
+		}
+		certPEM, keyPEM, err := generateSelfSignedCert(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certFile, keyFile = filepath.Join(pki.Dir, name+".crt"), filepath.Join(pki.Dir, name+".key")
+		if err := writeCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+			t.Fatal(err)
+		}
+		return certFile, keyFile
+	}
+	const billingID = "spiffe://example.org/ns/prod/sa/billing"
+	billingCert, billingKey := newSPIFFEClient("billing", billingID)
+	otherCert, otherKey := newSPIFFEClient("other", "spiffe://example.org/ns/prod/sa/other")
+	cert, err := loadCertificate(billingCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, billingID, mtls.CertFingerprint(cert)); err != nil {
+		t.Fatal(err)
+	}
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.ClientIDSource = mtls.IdentityURISAN })
+
+	client, err := NewClient(baseURL+identityPath, pki.ServerCertFile, billingCert, billingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, status, err := client.SendRequest()
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the listed SPIFFE ID to be accepted, got %d (%v)", status, err)
+	}
+	var got identityResponse
+	if err := json.Unmarshal([]byte(body), &got); err != nil || got.ClientID != billingID || got.CN != "" {
+		t.Errorf("Expected the SPIFFE ID as client_id and no CN, got %+v (%v)", got, err)
+	}
+
+	for _, tt := range []struct{ name, cert, key, want string }{
+		{"unlisted SPIFFE ID", otherCert, otherKey, "Client ID 'spiffe://example.org/ns/prod/sa/other' is not listed"},
+		{"no URI SAN", pki.ClientCertFile, pki.ClientKeyFile, "lacks the SAN that --client-id-source identifies clients by"},
+	} {
+		client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, tt.cert, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, logs := captureOutput(t, func() {
+			if _, _, err := client.SendRequest(); err == nil {
+				t.Errorf("Expected the client with %s to be rejected", tt.name)
+			}
+		})
+		if !strings.Contains(logs, tt.want) {
+			t.Errorf("Expected %q in the logs for the client with %s, got:\n%s", tt.want, tt.name, logs)
+		}
+	}
+}
+
+func TestAdminCNMatchesClientID(t *testing.T) {
+	pki := newTestPKI(t)
+	const opsID = "spiffe://example.org/ns/prod/sa/ops"
+	newClient := func(name, cn, id string) (certFile, keyFile string) {
+		opts := certOptions{CommonName: cn}
+		if err := opts.setSANs([]string{id}); err != nil {
+			t.Fatal(err)
+		}
+		certPEM, keyPEM, err := generateSelfSignedCert(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certFile, keyFile = filepath.Join(pki.Dir, name+".crt"), filepath.Join(pki.Dir, name+".key")
+		if err := writeCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+			t.Fatal(err)
+		}
+		cert, err := loadCertificate(certFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mtls.AppendKnownClient(pki.KnownClientsFile, id, mtls.CertFingerprint(cert)); err != nil {
+			t.Fatal(err)
+		}
+		return certFile, keyFile
+	}
+	// A known client whose CN claims to be an admin, and the client the admin ID names
+	impostorCert, impostorKey := newClient("impostor", "ops", "spiffe://example.org/ns/prod/sa/billing")
+	opsCert, opsKey := newClient("ops", "", opsID)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.ClientIDSource = mtls.IdentityURISAN
+		s.AdminCNs = []string{"ops", opsID}
+	})
+
+	for _, tt := range []struct {
+		cert, key string
+		want      int
+	}{
+		{impostorCert, impostorKey, http.StatusForbidden},
+		{opsCert, opsKey, http.StatusOK},
+	} {
+		client, err := NewClient(baseURL+"/admin/config", pki.ServerCertFile, tt.cert, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, status, err := client.SendRequest(); err != nil || status != tt.want {
+			t.Errorf("Expected %d for %s, got %d (%v)", tt.want, tt.cert, status, err)
+		}
+	}
+}
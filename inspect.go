@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Inspect Command ---
+//
+// inspect prints what openssl x509 -text would, in less detail and without needing openssl:
+// every certificate in the given PEM files, so a chain file shows each of its certificates.
+
+// InspectCmd prints the details of certificates.
+type InspectCmd struct {
+	Certs []string `kong:"arg,name='cert',help='PEM certificate file(s). Every certificate in a file is printed.',type='existingfile'"`
+	JSON  bool     `kong:"name='json',help='Print a JSON array instead of text.'"`
+}
+
+// certDetails is the inspect output for one certificate.
+type certDetails struct {
+	File               string          `json:"file"`
+	Subject            string          `json:"subject"`
+	Issuer             string          `json:"issuer"`
+	Serial             string          `json:"serial"`
+	NotBefore          time.Time       `json:"not_before"`
+	NotAfter           time.Time       `json:"not_after"`
+	Status             string          `json:"status"` // valid, expired or not yet valid
+	DNSNames           []string        `json:"dns_names,omitempty"`
+	IPAddresses        []string        `json:"ip_addresses,omitempty"`
+	EmailAddresses     []string        `json:"email_addresses,omitempty"`
+	URIs               []string        `json:"uris,omitempty"`
+	KeyAlgorithm       string          `json:"key_algorithm"`
+	KeySize            int             `json:"key_size"` // Bits; the curve size for ECDSA
+	SignatureAlgorithm string          `json:"signature_algorithm"`
+	Fingerprint        string          `json:"fingerprint_sha256"`
+	PublicKey          string          `json:"public_key_sha256"` // As spki: entries in the known clients file
+	IsCA               bool            `json:"is_ca"`
+	KeyUsage           []string        `json:"key_usage,omitempty"`
+	ExtKeyUsage        []string        `json:"ext_key_usage,omitempty"`
+	Extensions         []certExtension `json:"extensions"`
+}
+
+// certExtension is one X.509 extension, named if it's a common one.
+type certExtension struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+// Run prints each certificate of each file.
+func (c *InspectCmd) Run() error {
+	var all []certDetails
+	for _, certFile := range c.Certs {
+		certs, err := loadCertificates(certFile)
+		if err != nil {
+			return err
+		}
+		for _, cert := range certs {
+			all = append(all, inspectCertificate(certFile, cert, time.Now()))
+		}
+	}
+	if c.JSON {
+		out, err := json.MarshalIndent(all, "", "  ")
+		if err != nil {
+			return err
+		}
+		outputf("%s\n", out)
+		return nil
+	}
+	for i, d := range all {
+		if i > 0 {
+			outputf("\n")
+		}
+		printCertDetails(d)
+	}
+	return nil
+}
+
+// inspectCertificate collects the details of cert, with its validity status as of now.
+func inspectCertificate(file string, cert *x509.Certificate, now time.Time) certDetails {
+	d := certDetails{
+		File:               file,
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		Serial:             mtls.ColonHex(cert.SerialNumber.Bytes()),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		Status:             "valid",
+		DNSNames:           cert.DNSNames,
+		EmailAddresses:     cert.EmailAddresses,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		Fingerprint:        mtls.CertFingerprint(cert),
+		PublicKey:          mtls.KeyFingerprint(cert),
+		IsCA:               cert.IsCA,
+		KeyUsage:           keyUsageNames(cert.KeyUsage),
+		Extensions:         []certExtension{},
+	}
+	switch {
+	case now.After(cert.NotAfter):
+		d.Status = "expired"
+	case now.Before(cert.NotBefore):
+		d.Status = "not yet valid"
+	}
+	for _, ip := range cert.IPAddresses {
+		d.IPAddresses = append(d.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		d.URIs = append(d.URIs, uri.String())
+	}
+	d.KeyAlgorithm, d.KeySize = publicKeyInfo(cert)
+	for _, usage := range cert.ExtKeyUsage {
+		d.ExtKeyUsage = append(d.ExtKeyUsage, extKeyUsageName(usage))
+	}
+	for _, ext := range cert.Extensions {
+		oid := ext.Id.String()
+		d.Extensions = append(d.Extensions, certExtension{OID: oid, Name: extensionNames[oid], Critical: ext.Critical})
+	}
+	return d
+}
+
+// publicKeyInfo returns the key algorithm of cert, with the curve for ECDSA, and its size in bits (the
+// curve size for ECDSA).
+func publicKeyInfo(cert *x509.Certificate) (algorithm string, bits int) {
+	algorithm = cert.PublicKeyAlgorithm.String()
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		bits = key.N.BitLen()
+	case *ecdsa.PublicKey:
+		bits = key.Curve.Params().BitSize
+		algorithm += " " + key.Curve.Params().Name
+	case ed25519.PublicKey:
+		bits = 256
+	}
+	return algorithm, bits
+}
+
+// printCertDetails prints d in the aligned "Label: value" format of the fingerprint command.
+func printCertDetails(d certDetails) {
+	line := func(label, value string) {
+		if value != "" {
+			outputf("%-20s%s\n", label+":", value)
+		}
+	}
+	line("File", d.File)
+	line("Subject", d.Subject)
+	line("Issuer", d.Issuer)
+	line("Serial", d.Serial)
+	line("Not before", d.NotBefore.Format(time.RFC3339))
+	line("Not after", d.NotAfter.Format(time.RFC3339))
+	line("Status", d.Status)
+	line("DNS names", strings.Join(d.DNSNames, ", "))
+	line("IP addresses", strings.Join(d.IPAddresses, ", "))
+	line("Email addresses", strings.Join(d.EmailAddresses, ", "))
+	line("URIs", strings.Join(d.URIs, ", "))
+	line("Key", fmt.Sprintf("%s (%d bits)", d.KeyAlgorithm, d.KeySize))
+	line("Signature", d.SignatureAlgorithm)
+	line("Fingerprint", d.Fingerprint)
+	line("Public key", mtls.SPKIEntryPrefix+d.PublicKey)
+	line("CA", fmt.Sprint(d.IsCA))
+	line("Key usage", strings.Join(d.KeyUsage, ", "))
+	line("Extended key usage", strings.Join(d.ExtKeyUsage, ", "))
+	for _, ext := range d.Extensions {
+		name := ext.OID
+		if ext.Name != "" {
+			name = ext.Name + " (" + ext.OID + ")"
+		}
+		if ext.Critical {
+			name += ", critical"
+		}
+		line("Extension", name)
+	}
+}
+
+// keyUsageNames lists the key usage bits set in usage.
+func keyUsageNames(usage x509.KeyUsage) []string {
+	names := []string{
+		"Digital Signature", "Content Commitment", "Key Encipherment", "Data Encipherment",
+		"Key Agreement", "Certificate Sign", "CRL Sign", "Encipher Only", "Decipher Only",
+	}
+	var set []string
+	for i, name := range names {
+		if usage&(1<<i) != 0 {
+			set = append(set, name)
+		}
+	}
+	return set
+}
+
+// extKeyUsageName names an extended key usage.
+func extKeyUsageName(usage x509.ExtKeyUsage) string {
+	switch usage {
+	case x509.ExtKeyUsageAny:
+		return "Any"
+	case x509.ExtKeyUsageServerAuth:
+		return "Server Auth"
+	case x509.ExtKeyUsageClientAuth:
+		return "Client Auth"
+	case x509.ExtKeyUsageCodeSigning:
+		return "Code Signing"
+	case x509.ExtKeyUsageEmailProtection:
+		return "Email Protection"
+	case x509.ExtKeyUsageTimeStamping:
+		return "Time Stamping"
+	case x509.ExtKeyUsageOCSPSigning:
+		return "OCSP Signing"
+	}
+	return fmt.Sprintf("ExtKeyUsage(%d)", usage)
+}
+
+// extensionNames names the extensions commonly found in TLS certificates, by OID.
+var extensionNames = map[string]string{
+	"2.5.29.14":               "Subject Key Identifier",
+	"2.5.29.15":               "Key Usage",
+	"2.5.29.17":               "Subject Alternative Name",
+	"2.5.29.19":               "Basic Constraints",
+	"2.5.29.30":               "Name Constraints",
+	"2.5.29.31":               "CRL Distribution Points",
+	"2.5.29.32":               "Certificate Policies",
+	"2.5.29.35":               "Authority Key Identifier",
+	"2.5.29.37":               "Extended Key Usage",
+	"1.3.6.1.5.5.7.1.1":       "Authority Information Access",
+	"1.3.6.1.5.5.7.1.24":      "TLS Feature",
+	"1.3.6.1.4.1.11129.2.4.2": "Signed Certificate Timestamps",
+}
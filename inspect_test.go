@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestInspectCmd(t *testing.T) {
+	pki := newTestPKI(t)
+	server, err := ioutil.ReadFile(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ioutil.ReadFile(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := filepath.Join(t.TempDir(), "chain.pem")
+	if err := ioutil.WriteFile(chain, append(server, client...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _ := captureOutput(t, func() {
+		if err := (&InspectCmd{Certs: []string{chain}}).Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, want := range []string{"Subject:            CN=localhost", "Subject:            CN=" + pki.ClientCN,
+		"DNS names:          localhost", "Status:             valid", "Fingerprint:        " + mtls.CertFingerprint(clientCert),
+		"Extension:          Basic Constraints (2.5.29.19), critical"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("Expected %q in output:\n%s", want, stdout)
+		}
+	}
+
+	stdout, _ = captureOutput(t, func() {
+		if err := (&InspectCmd{Certs: []string{pki.ClientCertFile}, JSON: true}).Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	var details []certDetails
+	if err := json.Unmarshal([]byte(stdout), &details); err != nil {
+		t.Fatalf("Expected JSON output: %v\n%s", err, stdout)
+	}
+	if len(details) != 1 || details[0].Subject != "CN="+pki.ClientCN || details[0].KeyAlgorithm != "RSA" ||
+		details[0].KeySize != 2048 || details[0].PublicKey != mtls.KeyFingerprint(clientCert) {
+		t.Errorf("Unexpected details %+v", details)
+	}
+
+	if err := (&InspectCmd{Certs: []string{pki.ClientKeyFile}}).Run(); err == nil {
+		t.Error("Expected an error for a file without a certificate")
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// --- ClientHello Fingerprinting (JA3) ---
+//
+// JA3 fingerprints a client's TLS stack as the MD5 of
+// "SSLVersion,Ciphers,Extensions,EllipticCurves,EllipticCurvePointFormats".
+// tls.ClientHelloInfo only exposes part of the ClientHello, so this is an approximation:
+//   - SSLVersion: the legacy ClientHello version isn't exposed; it is derived from the highest
+//     supported version, capped at TLS 1.2 (771) as real TLS 1.3 clients send.
+//   - Extensions: not exposed at all, so the field is always empty.
+//   - Ciphers, curves and point formats are exact, in the order the client sent them.
+// GREASE values (RFC 8701) are skipped, as in JA3.
+// Fingerprints are therefore stable and comparable with each other, but not with JA3 hashes from other tools.
+
+// ja3 returns the JA3 string and its MD5 hash for a ClientHello.
+func ja3(hello *tls.ClientHelloInfo) (string, string) {
+	version := uint16(0)
+	for _, v := range hello.SupportedVersions {
+		if !isGREASE(v) && v > version {
+			version = v
+		}
+	}
+	if version > tls.VersionTLS12 {
+		version = tls.VersionTLS12
+	}
+
+	curves := make([]uint16, len(hello.SupportedCurves))
+	for i, c := range hello.SupportedCurves {
+		curves[i] = uint16(c)
+	}
+	points := make([]uint16, len(hello.SupportedPoints))
+	for i, p := range hello.SupportedPoints {
+		points[i] = uint16(p)
+	}
+
+	s := strings.Join([]string{
+		strconv.Itoa(int(version)),
+		joinJA3Values(hello.CipherSuites),
+		"", // Extensions: not exposed by crypto/tls
+		joinJA3Values(curves),
+		joinJA3Values(points),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return s, hex.EncodeToString(sum[:])
+}
+
+// joinJA3Values joins values with "-" in decimal, skipping GREASE values.
+func joinJA3Values(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !isGREASE(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// isGREASE reports whether v is a reserved GREASE value (0x0a0a, 0x1a1a, ..., 0xfafa).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// logClientHello wraps cfg.GetConfigForClient to log the JA3 fingerprint of each ClientHello.
+func logClientHello(cfg *tls.Config) {
+	next := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		remoteAddr := "unknown"
+		if hello.Conn != nil {
+			remoteAddr = hello.Conn.RemoteAddr().String()
+		}
+		s, hash := ja3(hello)
+		logInfof("JA3 %s from %s (SNI '%s'): %s", hash, remoteAddr, hello.ServerName, s)
+		if next == nil {
+			return nil, nil
+		}
+		return next(hello)
+	}
+}
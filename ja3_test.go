@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+var errHelloCaptured = errors.New("hello captured")
+
+// captureClientHello runs a client handshake with cfg over a net.Pipe and returns the ClientHello the server saw.
+func captureClientHello(t *testing.T, cfg *tls.Config) *tls.ClientHelloInfo {
+	t.Helper()
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	serverSide.SetDeadline(deadline)
+	clientSide.SetDeadline(deadline)
+
+	hellos := make(chan *tls.ClientHelloInfo, 1)
+	go func() {
+		defer serverSide.Close()
+		tls.Server(serverSide, &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			hellos <- hello
+			return nil, errHelloCaptured // Abort: only the ClientHello is needed
+		}}).Handshake()
+	}()
+	tls.Client(clientSide, cfg).Handshake()
+	select {
+	case hello := <-hellos:
+		return hello
+	case <-time.After(5 * time.Second):
+		t.Fatal("No ClientHello received")
+		return nil
+	}
+}
+
+func TestJA3StableForFixedClientConfig(t *testing.T) {
+	cfg := &tls.Config{
+		ServerName:       "localhost",
+		MinVersion:       tls.VersionTLS12,
+		MaxVersion:       tls.VersionTLS12,
+		CipherSuites:     []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		CurvePreferences: []tls.CurveID{tls.CurveP256},
+	}
+
+	first, firstHash := ja3(captureClientHello(t, cfg))
+	second, secondHash := ja3(captureClientHello(t, cfg))
+
+	const want = "771,49199,,23,0"
+	if first != want {
+		t.Errorf("Expected JA3 string %q, got %q", want, first)
+	}
+	if first != second || firstHash != secondHash || len(firstHash) != 32 {
+		t.Errorf("Expected a stable fingerprint, got %s (%s) and %s (%s)", first, firstHash, second, secondHash)
+	}
+}
+
+func TestJA3SkipsGREASE(t *testing.T) {
+	hello := &tls.ClientHelloInfo{
+		SupportedVersions: []uint16{0x0a0a, tls.VersionTLS13, tls.VersionTLS12},
+		CipherSuites:      []uint16{0x1a1a, tls.TLS_AES_128_GCM_SHA256},
+		SupportedCurves:   []tls.CurveID{0x2a2a, tls.X25519},
+		SupportedPoints:   []uint8{0},
+	}
+	if s, _ := ja3(hello); s != "771,4865,,29,0" {
+		t.Errorf("Unexpected JA3 string %q", s)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Kubernetes Secrets ---
+//
+// In a pod the server can take its key pair and known clients from Kubernetes secrets, without an init
+// script copying them into place.
+//
+// Mounted secrets need nothing special: point --cert, --key and --known-clients at the files of the
+// volume. The kubelet updates a mounted secret by writing the new files to a fresh directory and
+// swapping a symlink, which changes the stamp the file watchers compare (they follow symlinks), so
+// --watch-server-cert and --watch-known-clients pick the update up like any other change.
+//
+// Without a volume, KubeTLSSecret and KubeKnownClientsSecret name secrets read through the API with the
+// pod's service account (the in-cluster configuration under kubeServiceAccountDir, which needs get on
+// the secrets). Their values are written to a private temporary directory that stands in for CertFile,
+// KeyFile and KnownClientsFile, so reloads, strict parsing and everything else work as with files. The
+// secrets are polled every KubePoll, and a new resourceVersion rewrites the files and reloads them.
+
+// kubeServiceAccountDir holds the token, CA certificate and namespace of the pod's service account.
+var kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+const (
+	// Keys of a kubernetes.io/tls secret.
+	kubeTLSCertKey = "tls.crt"
+	kubeTLSKeyKey  = "tls.key"
+	// defaultKubeKnownClientsKey is the key of the known clients in their secret.
+	defaultKubeKnownClientsKey = "knownClients.txt"
+	// kubeRequestTimeout bounds a request to the API server.
+	kubeRequestTimeout = 10 * time.Second
+)
+
+// kubeClient reads secrets from the Kubernetes API server.
+type kubeClient struct {
+	baseURL    string
+	token      string
+	namespace  string
+	httpClient *http.Client
+}
+
+// kubeSecret is the part of a Secret object used here. Data values are base64 in JSON, which
+// encoding/json decodes into []byte.
+type kubeSecret struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Data map[string][]byte `json:"data"`
+}
+
+// newInClusterKubeClient configures a client from the service account mounted into the pod and the
+// KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT environment variables. An empty namespace means
+// the pod's own.
+func newInClusterKubeClient(namespace string) (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes pod: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	token, err := ioutil.ReadFile(filepath.Join(kubeServiceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	roots, err := mtls.LoadCertPool(filepath.Join(kubeServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the API server CA: %w", err)
+	}
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(filepath.Join(kubeServiceAccountDir, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the pod namespace: %w", err)
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	return &kubeClient{
+		baseURL:    "https://" + net.JoinHostPort(host, port),
+		token:      strings.TrimSpace(string(token)),
+		namespace:  namespace,
+		httpClient: &http.Client{Transport: transport, Timeout: kubeRequestTimeout},
+	}, nil
+}
+
+// getSecret fetches a secret of the client's namespace.
+func (k *kubeClient) getSecret(name string) (*kubeSecret, error) {
+	u := fmt.Sprintf("%s/api/v1/namespaces/%s/secrets/%s", k.baseURL, url.PathEscape(k.namespace), url.PathEscape(name))
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The token is re-read on every request: the kubelet rotates projected tokens in place.
+	if token, err := ioutil.ReadFile(filepath.Join(kubeServiceAccountDir, "token")); err == nil {
+		k.token = strings.TrimSpace(string(token))
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+	resp, err := k.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s/%s: %w", k.namespace, name, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", k.namespace, name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(body, &status)
+		return nil, fmt.Errorf("failed to get secret %s/%s (%s): %s", k.namespace, name, resp.Status, status.Message)
+	}
+	var secret kubeSecret
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return nil, fmt.Errorf("failed to parse secret %s/%s: %w", k.namespace, name, err)
+	}
+	return &secret, nil
+}
+
+// kubeSecrets keeps the files written from the secrets and the version they were written from.
+type kubeSecrets struct {
+	client   *kubeClient
+	dir      string
+	versions map[string]string // Secret name to resourceVersion
+}
+
+// loadKubeSecrets reads KubeTLSSecret and KubeKnownClientsSecret, if set, into a temporary directory and
+// points CertFile, KeyFile and KnownClientsFile at the files.
+func (s *Server) loadKubeSecrets() error {
+	if s.KubeTLSSecret == "" && s.KubeKnownClientsSecret == "" {
+		return nil
+	}
+	client, err := newInClusterKubeClient(s.KubeNamespace)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "tls-playground-secrets-")
+	if err != nil {
+		return err
+	}
+	k := &kubeSecrets{client: client, dir: dir, versions: make(map[string]string)}
+	if s.KubeTLSSecret != "" {
+		if _, err := k.sync(s.KubeTLSSecret, kubeTLSCertKey, kubeTLSKeyKey); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		s.CertFile, s.KeyFile = filepath.Join(dir, kubeTLSCertKey), filepath.Join(dir, kubeTLSKeyKey)
+	}
+	if s.KubeKnownClientsSecret != "" {
+		if _, err := k.sync(s.KubeKnownClientsSecret, s.kubeKnownClientsKey()); err != nil {
+			os.RemoveAll(dir)
+			return err
+		}
+		s.KnownClientsFile = filepath.Join(dir, s.kubeKnownClientsKey())
+	}
+	logInfof("Loaded Kubernetes secrets from namespace %s", client.namespace)
+	s.kubeSecrets = k
+	return nil
+}
+
+// kubeKnownClientsKey returns the key of the known clients in KubeKnownClientsSecret.
+func (s *Server) kubeKnownClientsKey() string {
+	if s.KubeKnownClientsKey != "" {
+		return s.KubeKnownClientsKey
+	}
+	return defaultKubeKnownClientsKey
+}
+
+// sync writes keys of the secret to files of the same name unless its resourceVersion is the one
+// already written, and reports whether it wrote them. A key missing from the secret is an error.
+func (k *kubeSecrets) sync(name string, keys ...string) (bool, error) {
+	secret, err := k.client.getSecret(name)
+	if err != nil {
+		return false, err
+	}
+	if version := secret.Metadata.ResourceVersion; version != "" && version == k.versions[name] {
+		return false, nil
+	}
+	for _, key := range keys {
+		if _, ok := secret.Data[key]; !ok {
+			return false, fmt.Errorf("secret %s/%s has no key %s", k.client.namespace, name, key)
+		}
+	}
+	for _, key := range keys {
+		if err := writeFileAtomically(filepath.Join(k.dir, key), secret.Data[key], 0600); err != nil {
+			return false, err
+		}
+	}
+	k.versions[name] = secret.Metadata.ResourceVersion
+	return true, nil
+}
+
+// watchKubeSecrets polls the secrets every KubePoll and reloads what changed, until the server stops.
+func (s *Server) watchKubeSecrets() {
+	ticker := time.NewTicker(s.KubePoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		if s.KubeTLSSecret != "" {
+			if changed, err := s.kubeSecrets.sync(s.KubeTLSSecret, kubeTLSCertKey, kubeTLSKeyKey); err != nil {
+				logErrorf("%v", err) // Keep serving the current key pair
+			} else if changed {
+				logInfof("Kubernetes secret %s changed, reloading the server certificate", s.KubeTLSSecret)
+				if err := s.ReloadServerCertificate(); err != nil {
+					logErrorf("%v", err)
+				}
+			}
+		}
+		if s.KubeKnownClientsSecret != "" {
+			if changed, err := s.kubeSecrets.sync(s.KubeKnownClientsSecret, s.kubeKnownClientsKey()); err != nil {
+				logErrorf("%v", err)
+			} else if changed {
+				logInfof("Kubernetes secret %s changed, reloading known clients", s.KubeKnownClientsSecret)
+				if err := s.ReloadKnownClients(); err != nil {
+					logErrorf("%v", err)
+				}
+			}
+		}
+	}
+}
+
+// writeFileAtomically replaces path with data, so readers see either the old or the new contents.
+func writeFileAtomically(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
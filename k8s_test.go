@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// fakeKubeAPI serves the secrets of one namespace to the in-cluster configuration it installs.
+type fakeKubeAPI struct {
+	mu      sync.Mutex
+	secrets map[string]map[string][]byte
+	version int
+}
+
+// newFakeKubeAPI starts an API server for namespace "playground" accepting the token "pod-token",
+// and points the service account directory and environment at it.
+func newFakeKubeAPI(t *testing.T) *fakeKubeAPI {
+	t.Helper()
+	api := &fakeKubeAPI{secrets: make(map[string]map[string][]byte)}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fail := func(status int, msg string) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"kind": "Status", "message": msg})
+		}
+		if r.Header.Get("Authorization") != "Bearer pod-token" {
+			fail(http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/api/v1/namespaces/playground/secrets/")
+		api.mu.Lock()
+		data, ok := api.secrets[name]
+		version := api.version
+		api.mu.Unlock()
+		if !ok || name == r.URL.Path {
+			fail(http.StatusNotFound, `secrets "`+name+`" not found`)
+			return
+		}
+		secret := kubeSecret{Data: data}
+		secret.Metadata.ResourceVersion = strconv.Itoa(version)
+		json.NewEncoder(w).Encode(secret)
+	}))
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	for name, data := range map[string][]byte{"token": []byte("pod-token\n"), "ca.crt": caPEM, "namespace": []byte("playground")} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := kubeServiceAccountDir
+	kubeServiceAccountDir = dir
+	t.Cleanup(func() { kubeServiceAccountDir = saved })
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	t.Setenv("KUBERNETES_SERVICE_HOST", host)
+	t.Setenv("KUBERNETES_SERVICE_PORT", port)
+	return api
+}
+
+// Put creates or replaces a secret with the contents of files, bumping the resourceVersion.
+func (a *fakeKubeAPI) Put(t *testing.T, name string, files map[string]string) {
+	t.Helper()
+	data := make(map[string][]byte)
+	for key, file := range files {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[key] = contents
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.secrets[name] = data
+	a.version++
+}
+
+func TestKubeSecretsLoadAndReload(t *testing.T) {
+	pki := newTestPKI(t)
+	api := newFakeKubeAPI(t)
+	api.Put(t, "server-tls", map[string]string{kubeTLSCertKey: pki.ServerCertFile, kubeTLSKeyKey: pki.ServerKeyFile})
+	api.Put(t, "clients", map[string]string{defaultKubeKnownClientsKey: pki.KnownClientsFile})
+	server, baseURL := startTestServer(t, &testPKI{Dir: pki.Dir}, func(s *Server) {
+		s.KubeTLSSecret, s.KubeKnownClientsSecret, s.KubePoll = "server-tls", "clients", 20*time.Millisecond
+	})
+	if filepath.Dir(server.CertFile) != server.kubeSecrets.dir || filepath.Dir(server.KnownClientsFile) != server.kubeSecrets.dir {
+		t.Errorf("Expected the files to be read from the secrets, got %s and %s", server.CertFile, server.KnownClientsFile)
+	}
+	if err := requestTrusting(t, pki, baseURL+"/hello", pki.ServerCertFile); err != nil {
+		t.Fatalf("Expected the key pair and known clients from the secrets to be used, got %v", err)
+	}
+
+	// Adding a client to the secret lets it in at the next poll
+	client, fingerprint := addNewClient(t, pki, baseURL, "kube_client")
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "kube_client", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	api.Put(t, "clients", map[string]string{defaultKubeKnownClientsKey: pki.KnownClientsFile})
+	waitForStatus(t, client, http.StatusOK)
+
+	// So does rotating the key pair
+	newCert, newKey := newServerCert(t, t.TempDir(), "rotated")
+	api.Put(t, "server-tls", map[string]string{kubeTLSCertKey: newCert, kubeTLSKeyKey: newKey})
+	deadline := time.Now().Add(5 * time.Second)
+	for requestTrusting(t, pki, baseURL+"/hello", newCert) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the server to present the certificate from the updated secret")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestKubeSecretsErrors(t *testing.T) {
+	pki := newTestPKI(t)
+	api := newFakeKubeAPI(t)
+	api.Put(t, "cert-only", map[string]string{kubeTLSCertKey: pki.ServerCertFile})
+	for _, tc := range []struct {
+		name      string
+		configure func(*Server)
+		want      string
+	}{
+		{"missing secret", func(s *Server) { s.KubeTLSSecret = "absent" }, `secrets "absent" not found`},
+		{"missing key", func(s *Server) { s.KubeTLSSecret = "cert-only" }, "secret playground/cert-only has no key tls.key"},
+		{"bad token", func(s *Server) {
+			s.KubeTLSSecret = "cert-only"
+			ioutil.WriteFile(filepath.Join(kubeServiceAccountDir, "token"), []byte("stale"), 0600)
+		}, "401 Unauthorized"},
+		{"outside a pod", func(s *Server) {
+			s.KubeKnownClientsSecret = "clients"
+			t.Setenv("KUBERNETES_SERVICE_HOST", "")
+		}, "not running in a Kubernetes pod"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+			tc.configure(server)
+			if err := server.Start(); err == nil || !strings.Contains(err.Error(), tc.want) {
+				server.Stop()
+				t.Errorf("Expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/term"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Encrypted Private Keys ---
+//
+// Server, client and CA private keys may be encrypted (see mtls.LoadKeyPair) and are decrypted in
+// memory only. The passphrase of a key file comes from --key-pass or --key-pass-file, or else from a
+// prompt on the terminal. It is asked for once and kept in memory, so reloading the server certificate
+// with the same key doesn't prompt again. Without a terminal, an encrypted key needs one of the flags.
+
+// keyPassphrases holds the passphrase source of each key file, by cleaned path.
+var keyPassphrases = struct {
+	mu      sync.Mutex
+	sources map[string]mtls.PassphraseFunc
+}{sources: make(map[string]mtls.PassphraseFunc)}
+
+// setKeyPassphrase sets where the passphrase of keyFile comes from. The first passphrase it returns is reused.
+func setKeyPassphrase(keyFile string, source mtls.PassphraseFunc) {
+	keyPassphrases.mu.Lock()
+	defer keyPassphrases.mu.Unlock()
+	keyPassphrases.sources[filepath.Clean(keyFile)] = cachePassphrase(source)
+}
+
+// keyPassphrase returns the passphrase source of keyFile: the one set with setKeyPassphrase, or a prompt.
+func keyPassphrase(keyFile string) mtls.PassphraseFunc {
+	keyPassphrases.mu.Lock()
+	defer keyPassphrases.mu.Unlock()
+	key := filepath.Clean(keyFile)
+	source, ok := keyPassphrases.sources[key]
+	if !ok {
+		source = cachePassphrase(promptPassphrase(keyFile))
+		keyPassphrases.sources[key] = source
+	}
+	return source
+}
+
+// setKeyPassphraseFlags sets the passphrase source of keyFile from --key-pass or --key-pass-file, if given.
+func setKeyPassphraseFlags(keyFile, pass, passFile string) {
+	switch {
+	case pass != "":
+		setKeyPassphrase(keyFile, func() ([]byte, error) { return []byte(pass), nil })
+	case passFile != "":
+		setKeyPassphrase(keyFile, passphraseFromFile(passFile))
+	}
+}
+
+// loadKeyPair loads a certificate and its private key, decrypting the key if needed. A PKCS#12 certFile
+// (see pkcs12.go) holds both and keyFile is ignored.
+func loadKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	if mtls.IsPKCS12File(certFile) {
+		return mtls.LoadPKCS12(certFile, keyPassphrase(certFile))
+	}
+	return mtls.LoadKeyPair(certFile, keyFile, keyPassphrase(keyFile))
+}
+
+// cachePassphrase returns source, remembering the first passphrase it returns.
+func cachePassphrase(source mtls.PassphraseFunc) mtls.PassphraseFunc {
+	var mu sync.Mutex
+	var pass []byte
+	return func() ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if pass != nil {
+			return pass, nil
+		}
+		p, err := source()
+		if err != nil {
+			return nil, err
+		}
+		pass = p
+		return pass, nil
+	}
+}
+
+// passphraseFromFile reads the passphrase from the first line of a file.
+func passphraseFromFile(path string) mtls.PassphraseFunc {
+	return func() ([]byte, error) {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key passphrase: %w", err)
+		}
+		line, _, _ := bytes.Cut(content, []byte("\n"))
+		return bytes.TrimSuffix(line, []byte("\r")), nil
+	}
+}
+
+// promptPassphrase asks for the passphrase of keyFile on the terminal, without echoing it.
+func promptPassphrase(keyFile string) mtls.PassphraseFunc {
+	return func() ([]byte, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return nil, fmt.Errorf("%w: use --key-pass or --key-pass-file", mtls.ErrKeyPassphraseRequired)
+		}
+		fmt.Fprintf(os.Stderr, "Passphrase for %s: ", keyFile)
+		pass, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key passphrase: %w", err)
+		}
+		return pass, nil
+	}
+}
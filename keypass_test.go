@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/youmark/pkcs8"
+)
+
+// encryptKeyFile encrypts the PKCS#8 private key in keyFile with the passphrase, in place.
+func encryptKeyFile(t *testing.T, keyFile, passphrase string) {
+	t.Helper()
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := pkcs8.MarshalPrivateKey(key, []byte(passphrase), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptedKeys(t *testing.T) {
+	pki := newTestPKI(t)
+	encryptKeyFile(t, pki.ServerKeyFile, "server secret")
+	encryptKeyFile(t, pki.ClientKeyFile, "client secret")
+	passFile := filepath.Join(pki.Dir, "client.pass")
+	if err := ioutil.WriteFile(passFile, []byte("client secret\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	setKeyPassphraseFlags(pki.ServerKeyFile, "server secret", "")
+	setKeyPassphraseFlags(pki.ClientKeyFile, "", passFile)
+
+	server, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatalf("Expected the encrypted client key to load, got %v", err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the request to succeed with encrypted keys, got %d (%v)", status, err)
+	}
+
+	// The passphrase is remembered, so the key can be reloaded after the file is gone.
+	if err := ioutil.WriteFile(passFile, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadServerCertificate(); err != nil {
+		t.Errorf("Expected the server key to reload with the remembered passphrase, got %v", err)
+	}
+	if _, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile); err != nil {
+		t.Errorf("Expected the client key to load with the remembered passphrase, got %v", err)
+	}
+}
+
+func TestEncryptedKeyWrongPassphrase(t *testing.T) {
+	pki := newTestPKI(t)
+	encryptKeyFile(t, pki.ClientKeyFile, "client secret")
+	setKeyPassphraseFlags(pki.ClientKeyFile, "not it", "")
+	if _, err := NewClient("https://localhost/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile); err == nil {
+		t.Error("Expected a wrong passphrase to fail")
+	}
+}
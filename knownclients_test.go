@@ -0,0 +1,599 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestKnownClientsMaxAge(t *testing.T) {
+	pki := newTestPKI(t)
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(pki.KnownClientsFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	_, logs := captureOutput(t, func() {
+		_, err = mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{MaxAge: 24 * time.Hour, Warnf: logWarnf})
+	})
+	if err != nil {
+		t.Fatalf("Expected a stale file to only warn, got %v", err)
+	}
+	if !strings.Contains(logs, "older than the maximum age") {
+		t.Errorf("Expected a staleness warning, got logs: %s", logs)
+	}
+
+	if _, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{MaxAge: 24 * time.Hour, Strict: true}); err == nil {
+		t.Error("Expected a stale file to be refused in strict mode")
+	}
+	if _, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{MaxAge: 72 * time.Hour, Strict: true}); err != nil {
+		t.Errorf("Expected a file within the maximum age to load, got %v", err)
+	}
+}
+
+func TestKnownClientsMaxAgeOnReload(t *testing.T) {
+	pki := newTestPKI(t)
+	store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{MaxAge: time.Hour, Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(pki.KnownClientsFile, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err == nil {
+		t.Fatal("Expected the reload of a stale file to fail in strict mode")
+	}
+	if _, ok := store.Lookup(pki.ClientCN); !ok {
+		t.Error("Expected the previous entries to be kept after a failed reload")
+	}
+}
+
+func TestKnownClientsMaxEntries(t *testing.T) {
+	pki := newTestPKI(t)
+	for _, cn := range []string{"second", "third"} {
+		if err := mtls.AppendKnownClient(pki.KnownClientsFile, cn, "AA:BB"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{MaxEntries: 3}); err != nil {
+		t.Errorf("Expected a file at the cap to load, got %v", err)
+	}
+	_, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{MaxEntries: 2})
+	if err == nil || !strings.Contains(err.Error(), "more than the maximum of 2 entries") {
+		t.Errorf("Expected a clear error when exceeding the cap, got %v", err)
+	}
+
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.MaxKnownClients = 2
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Error("Expected startup to fail when the known clients cap is exceeded")
+	}
+}
+
+func TestKnownClientsSPKIEntry(t *testing.T) {
+	pki := newTestPKI(t)
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewedFile := filepath.Join(pki.Dir, "renewed.crt")
+	reissueCert(t, pki.ClientCertFile, pki.ClientKeyFile, pki.ClientCN, renewedFile)
+	renewed, err := loadCertificate(renewedFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherFile := filepath.Join(pki.Dir, "other.crt")
+	pki.newClientCert(t, pki.ClientCN, otherFile, filepath.Join(pki.Dir, "other.key"))
+	other, err := loadCertificate(otherFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name                     string
+		entry                    string
+		original, renewed, other bool
+	}{
+		{"certificate fingerprint", mtls.CertFingerprint(cert), true, false, false},
+		{"spki hex", "spki:" + strings.ToLower(mtls.KeyFingerprint(cert)), true, true, false},
+		{"spki base64", "SPKI:" + mtls.SPKIPin(cert), true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(pki.ClientCN+" "+tt.entry+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{Strict: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, c := range []struct {
+				name string
+				cert *x509.Certificate
+				want bool
+			}{{"original", cert, tt.original}, {"renewed with the same key", renewed, tt.renewed}, {"new key", other, tt.other}} {
+				err := verifyClientCertificate([][]byte{c.cert.Raw}, nil, store, verifyOptions{})
+				if (err == nil) != c.want {
+					t.Errorf("%s: expected accepted=%v, got %v", c.name, c.want, err)
+				}
+			}
+		})
+	}
+
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(pki.ClientCN+" spki:not-a-hash\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{Strict: true}); err == nil {
+		t.Error("Expected an invalid spki entry to fail a strict load")
+	}
+}
+
+func TestFileKnownClientsStoreAddRemoveList(t *testing.T) {
+	pki := newTestPKI(t)
+	store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Add("another_client", "spki:"+mtls.SPKIPin(cert)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("broken", "spki:nope"); err == nil {
+		t.Error("Expected Add to reject an invalid spki entry")
+	}
+	want := []mtls.KnownClient{
+		{CN: "another_client", Fingerprint: mtls.KeyEntry(mtls.KeyFingerprint(cert))},
+		{CN: pki.ClientCN, Fingerprint: mtls.CertFingerprint(cert)},
+	}
+	if got := store.List(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+
+	// Removing by the normalized form also matches the base64 entry written to the file.
+	if err := store.Remove("another_client", want[0].Fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Lookup("another_client"); ok {
+		t.Error("Expected the removed client to be gone")
+	}
+}
+
+// memKnownClients is a minimal in-memory mtls.KnownClientsStore, standing in for a non-file backend.
+type memKnownClients struct {
+	mu      sync.Mutex
+	clients map[string][]mtls.KnownClient
+}
+
+func (m *memKnownClients) Lookup(cn string) ([]mtls.KnownClient, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entries, ok := m.clients[cn]
+	return entries, ok
+}
+
+func (m *memKnownClients) List() []mtls.KnownClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var entries []mtls.KnownClient
+	for _, cnEntries := range m.clients {
+		entries = append(entries, cnEntries...)
+	}
+	return entries
+}
+
+func (m *memKnownClients) Add(cn, fingerprint string) error {
+	normalized, err := mtls.NormalizeFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[cn] = append(m.clients[cn], mtls.KnownClient{CN: cn, Fingerprint: normalized})
+	return nil
+}
+
+func (m *memKnownClients) Remove(cn, fingerprint string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.clients, cn)
+	return nil
+}
+
+func (m *memKnownClients) Reload() error { return nil }
+
+func TestAppendKnownClientWithoutTrailingNewline(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "knownClients.txt")
+	if err := ioutil.WriteFile(file, []byte("first AA:BB"), 0644); err != nil { // Hand-edited, no final newline
+		t.Fatal(err)
+	}
+	for _, cn := range []string{"second", "third"} {
+		if err := mtls.AppendKnownClient(file, cn, "CC:DD"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := ioutil.ReadFile(file); string(got) != "first AA:BB\nsecond CC:DD\nthird CC:DD\n" {
+		t.Errorf("Expected each entry on its own line, got %q", got)
+	}
+	newFile := filepath.Join(t.TempDir(), "new.txt")
+	if err := mtls.AppendKnownClient(newFile, "first", "AA:BB"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(newFile); string(got) != "first AA:BB\n" {
+		t.Errorf("Expected a new file to start with the entry, got %q", got)
+	}
+}
+
+func TestServerWithCustomKnownClientsBackend(t *testing.T) {
+	pki := newTestPKI(t)
+	backend := &memKnownClients{clients: map[string][]mtls.KnownClient{}}
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.KnownClients = backend
+		s.KnownClientsFile = filepath.Join(pki.Dir, "does-not-exist.txt") // Not read with a custom backend
+	})
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Fatal("Expected rejection while the backend is empty")
+	}
+
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Add(pki.ClientCN, mtls.CertFingerprint(cert)); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != 200 {
+		t.Fatalf("Expected the client added to the backend to be accepted, got %d (%v)", status, err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKnownClientsJSONAndYAML(t *testing.T) {
+	dir := t.TempDir()
+	yamlDoc := `clients:
+  - cn: build_agent
+    fingerprint: aa:bb
+    allowed_paths: [/hello, /pop/]
+    expires: 2030-01-02T03:04:05Z
+    notes: CI runners
+  - cn: build_agent
+    fingerprint: spki:` + pinTestCertPin + `
+`
+	jsonDoc := `{"clients": [
+  {"cn": "build_agent", "fingerprint": "aa:bb", "allowed_paths": ["/hello", "/pop/"], "expires": "2030-01-02T03:04:05Z", "notes": "CI runners"},
+  {"cn": "build_agent", "fingerprint": "spki:` + pinTestCertPin + `"}
+]}`
+	for _, tt := range []struct{ file, content string }{
+		{"clients.yaml", yamlDoc},
+		{"clients.yml", yamlDoc},
+		{"clients.json", jsonDoc},
+		{"knownClients.txt", jsonDoc}, // Detected by content
+	} {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			clients, err := mtls.LoadKnownClients(path, mtls.FileStoreOptions{Strict: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries := clients["build_agent"]
+			if len(entries) != 2 {
+				t.Fatalf("Expected 2 entries, got %v", clients)
+			}
+			want := mtls.KnownClient{
+				CN:           "build_agent",
+				Fingerprint:  "AA:BB",
+				AllowedPaths: []string{"/hello", "/pop/"},
+				Expires:      time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+				Notes:        "CI runners",
+			}
+			if !reflect.DeepEqual(entries[0], want) {
+				t.Errorf("Expected %+v, got %+v", want, entries[0])
+			}
+			if !strings.HasPrefix(entries[1].Fingerprint, "SPKI:") {
+				t.Errorf("Expected a normalized spki entry, got %q", entries[1].Fingerprint)
+			}
+		})
+	}
+
+	// Unknown fields are only an error in strict mode.
+	path := filepath.Join(dir, "typo.yaml")
+	if err := ioutil.WriteFile(path, []byte("clients:\n  - cn: a\n    fingerprint: aa\n    expiry: 2030-01-01T00:00:00Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mtls.LoadKnownClients(path, mtls.FileStoreOptions{Strict: true}); err == nil {
+		t.Error("Expected an unknown field to fail a strict load")
+	}
+	if _, err := mtls.LoadKnownClients(path, mtls.FileStoreOptions{}); err != nil {
+		t.Errorf("Expected a lenient load to ignore the unknown field, got %v", err)
+	}
+
+	store, err := mtls.NewFileStore(path, mtls.FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("b", "bb"); err == nil {
+		t.Error("Expected editing a YAML file to be refused")
+	}
+}
+
+func TestKnownClientEntryRestrictions(t *testing.T) {
+	pki := newTestPKI(t)
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pki.KnownClientsFile = filepath.Join(pki.Dir, "knownClients.yaml")
+	doc := "clients:\n  - cn: " + pki.ClientCN + "\n    fingerprint: " + mtls.CertFingerprint(cert) + "\n    allowed_paths: [/pop/]\n"
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path string) int {
+		t.Helper()
+		resp, err := client.httpClient.Get(baseURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get("/hello"); status != http.StatusForbidden {
+		t.Errorf("Expected 403 outside the allowed paths, got %d", status)
+	}
+	if status := get(popNoncePath); status != http.StatusOK {
+		t.Errorf("Expected 200 under an allowed path, got %d", status)
+	}
+
+	// An entry that expires is enforced on the already open connection too.
+	expired := doc + "    expires: " + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339) + "\n"
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(expired), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	if status := get(popNoncePath); status != http.StatusForbidden {
+		t.Errorf("Expected 403 after the entry expired, got %d", status)
+	}
+	client.httpClient.CloseIdleConnections()
+	err = verifyClientCertificate([][]byte{cert.Raw}, nil, server.knownClients, verifyOptions{})
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected new handshakes to fail with an expired entry, got %v", err)
+	}
+}
+
+func TestKnownClientsCommaSeparatedFingerprints(t *testing.T) {
+	pki := newTestPKI(t)
+	oldCert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newCertFile := filepath.Join(pki.Dir, "client_new.crt")
+	newKeyFile := filepath.Join(pki.Dir, "client_new.key")
+	newFingerprint := pki.newClientCert(t, pki.ClientCN, newCertFile, newKeyFile)
+
+	// One line listing both the old and the new certificate, plus an unrelated fingerprint.
+	content := pki.ClientCN + " " + mtls.CertFingerprint(oldCert) + ", " + newFingerprint + ",AA:BB\n"
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries := clients[pki.ClientCN]; len(entries) != 3 || entries[1].Fingerprint != newFingerprint {
+		t.Fatalf("Expected three entries for %s, got %+v", pki.ClientCN, entries)
+	}
+	store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, certFile := range []string{pki.ClientCertFile, newCertFile} {
+		cert, err := loadCertificate(certFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := verifyClientCertificate([][]byte{cert.Raw}, nil, store, verifyOptions{}); err != nil {
+			t.Errorf("Expected %s to be accepted during the overlap, got %v", certFile, err)
+		}
+	}
+
+	// Removing one fingerprint keeps the rest of the line.
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, pki.ClientCN, mtls.CertFingerprint(oldCert)); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := ioutil.ReadFile(pki.KnownClientsFile)
+	if want := pki.ClientCN + " " + newFingerprint + ",AA:BB\n"; string(got) != want {
+		t.Errorf("Expected %q after removing the old fingerprint, got %q", want, got)
+	}
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, pki.ClientCN, newFingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, pki.ClientCN, "aa:bb"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(pki.KnownClientsFile); len(got) != 0 {
+		t.Errorf("Expected the line to be removed with its last fingerprint, got %q", got)
+	}
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, pki.ClientCN, "aa:bb"); !errors.Is(err, mtls.ErrKnownClientNotFound) {
+		t.Errorf("Expected removing a missing entry to fail with ErrKnownClientNotFound, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(pki.ClientCN+" AA:BB,\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{Strict: true}); err == nil {
+		t.Error("Expected an empty fingerprint in the list to fail a strict load")
+	}
+}
+
+func TestKnownClientsTextAllowedPaths(t *testing.T) {
+	pki := newTestPKI(t)
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf("%s %s /hello,/pop/\nother AA:BB, CC:DD\n", pki.ClientCN, mtls.CertFingerprint(cert))
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := mtls.LoadKnownClients(pki.KnownClientsFile, mtls.FileStoreOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paths := clients[pki.ClientCN][0].AllowedPaths; len(paths) != 2 || paths[0] != "/hello" || paths[1] != "/pop/" {
+		t.Errorf("Unexpected allowed paths: %v", paths)
+	}
+	if others := clients["other"]; len(others) != 2 || len(others[1].AllowedPaths) != 0 {
+		t.Errorf("Expected a fingerprint list with spaces and no paths to keep parsing, got %+v", others)
+	}
+
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]int{"/hello": http.StatusOK, popNoncePath: http.StatusOK, "/hello/more": http.StatusForbidden, "/other": http.StatusForbidden} {
+		resp, err := client.httpClient.Get(baseURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s: expected %d, got %d", path, want, resp.StatusCode)
+		}
+	}
+
+	// Removing one fingerprint of a line keeps its paths.
+	content = fmt.Sprintf("%s %s,AA:BB /hello\n", pki.ClientCN, mtls.CertFingerprint(cert))
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, pki.ClientCN, "AA:BB"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(pki.KnownClientsFile); string(data) != fmt.Sprintf("%s %s /hello\n", pki.ClientCN, mtls.CertFingerprint(cert)) {
+		t.Errorf("Unexpected file after removal: %q", data)
+	}
+}
+
+func TestKnownClientsTextRejectsRelativePaths(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "knownClients.txt")
+	if err := ioutil.WriteFile(file, []byte("client AA:BB /hello,api/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mtls.LoadKnownClients(file, mtls.FileStoreOptions{Strict: true}); err == nil || !strings.Contains(err.Error(), "must start with /") {
+		t.Errorf("Expected a relative path to be rejected, got %v", err)
+	}
+}
+
+func TestKnownClientsTextStrayFields(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "knownClients.txt")
+	// A space inside the fingerprint must not turn its remainder into a path or vice versa.
+	for _, line := range []string{"client SPKI: /abc", "client AA:BB stray /hello", "client AA:BB /hello stray"} {
+		if err := ioutil.WriteFile(file, []byte(line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if clients, err := mtls.LoadKnownClients(file, mtls.FileStoreOptions{Strict: true}); err == nil {
+			t.Errorf("Expected %q to be rejected, got %+v", line, clients)
+		}
+	}
+}
+
+func TestKnownClientsTextRateLimit(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "knownClients.txt")
+	content := "limited AA:BB rate=2.5\nboth CC:DD,EE:FF /hello rate=10\nplain 11:22\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := mtls.LoadKnownClients(file, mtls.FileStoreOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := clients["limited"][0]; got.RateLimit != 2.5 || len(got.AllowedPaths) != 0 {
+		t.Errorf("Unexpected entry for a rate without paths: %+v", got)
+	}
+	if got := clients["both"][1]; got.RateLimit != 10 || len(got.AllowedPaths) != 1 || got.Fingerprint != "EE:FF" {
+		t.Errorf("Unexpected entry for paths and a rate: %+v", got)
+	}
+	if got := clients["plain"][0]; got.RateLimit != 0 {
+		t.Errorf("Expected no rate limit without rate=, got %v", got.RateLimit)
+	}
+
+	// Removing one fingerprint of a line keeps its paths and rate.
+	if err := mtls.RemoveKnownClient(file, "both", "CC:DD"); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(file); !strings.Contains(string(data), "both EE:FF /hello rate=10\n") {
+		t.Errorf("Unexpected file after removal: %q", data)
+	}
+
+	for _, line := range []string{"client AA:BB rate=0", "client AA:BB rate=fast", "client AA:BB /hello rate=-1"} {
+		if err := ioutil.WriteFile(file, []byte(line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mtls.LoadKnownClients(file, mtls.FileStoreOptions{Strict: true}); err == nil || !strings.Contains(err.Error(), "rate limit") {
+			t.Errorf("Expected %q to be rejected, got %v", line, err)
+		}
+	}
+}
+
+func TestKnownClientsTextValidityWindow(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "knownClients.txt")
+	content := "temp AA:BB /hello valid-until=2030-01-02T03:04:05Z rate=2 valid-from=2030-01-01\n"
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clients, err := mtls.LoadKnownClients(file, mtls.FileStoreOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := clients["temp"][0]
+	if !got.ValidFrom.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) || !got.Expires.Equal(time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)) ||
+		got.RateLimit != 2 || len(got.AllowedPaths) != 1 {
+		t.Errorf("Unexpected entry: %+v", got)
+	}
+	if got.Active(time.Date(2029, 12, 31, 0, 0, 0, 0, time.UTC)) || !got.Active(time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected validity window: %+v", got)
+	}
+
+	for _, line := range []string{"temp AA:BB valid-from=tomorrow", "temp AA:BB valid-from=2030-01-02 valid-until=2030-01-01", "temp AA:BB rate=2 ttl=1h"} {
+		if err := ioutil.WriteFile(file, []byte(line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mtls.LoadKnownClients(file, mtls.FileStoreOptions{Strict: true}); err == nil {
+			t.Errorf("Expected %q to be rejected", line)
+		}
+	}
+}
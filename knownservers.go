@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Known Servers File ---
+//
+// The client's counterpart of the known clients file, like SSH's known_hosts: '<host> <fingerprint>'
+// lines map server host names (as in the URL, without the port) to the SHA-256 fingerprints of their
+// certificates, or to spki: entries for their public keys. A server is trusted if the certificate it
+// presents matches an entry for the host it was reached at; chains and names in the certificate are
+// not checked, so self-signed server certificates work without --server-cert. IP addresses are not sent
+// in SNI, so a connection to one is looked up under the host of the server URL.
+//
+// A host without entries is rejected, unless confirm (--ask-new-servers) accepts it, in which case its
+// certificate fingerprint is appended to the file. A host whose certificate changed is always rejected.
+
+// knownServers holds the entries of a known servers file.
+type knownServers struct {
+	path string
+	// urlHost is the host name of the server URL, for connections without a server name (IP addresses).
+	urlHost string
+	// confirm, if set, is asked whether to trust a server whose host has no entries.
+	confirm func(host, fingerprint string) bool
+
+	mu    sync.Mutex // Held across confirm, so one connection asks at a time
+	hosts map[string][]string
+}
+
+// loadKnownServers reads a known servers file. A missing file has no entries.
+func loadKnownServers(path string) (*knownServers, error) {
+	k := &knownServers{path: path, hosts: make(map[string][]string)}
+	content, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known servers file %s: %w", path, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		host, fingerprints, ok := strings.Cut(line, " ")
+		if !ok {
+			logWarnf("Skipping line %d of known servers file %s: format should be '<host> <fingerprint>'", lineNumber, path)
+			continue
+		}
+		for _, fingerprint := range strings.Split(strings.TrimSpace(fingerprints), ",") {
+			normalized, err := mtls.NormalizeFingerprint(strings.TrimSpace(fingerprint))
+			if err != nil {
+				logWarnf("Skipping fingerprint on line %d of known servers file %s: %v", lineNumber, path, err)
+				continue
+			}
+			host = strings.ToLower(host)
+			k.hosts[host] = append(k.hosts[host], normalized)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading known servers file %s: %w", path, err)
+	}
+	return k, nil
+}
+
+// verifyConnection is the tls.Config.VerifyConnection callback checking the server certificate
+// against the entries for the host it was reached at.
+func (k *knownServers) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	leaf := cs.PeerCertificates[0]
+	host := cs.ServerName
+	if host == "" {
+		host = k.urlHost
+	}
+	host = strings.ToLower(host)
+	fingerprint := mtls.CertFingerprint(leaf)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	entries, ok := k.hosts[host]
+	if ok {
+		key := mtls.KeyEntry(mtls.KeyFingerprint(leaf))
+		for _, entry := range entries {
+			if entry == fingerprint || entry == key {
+				return nil
+			}
+		}
+		return fmt.Errorf("certificate of server %s (fingerprint %s) does not match its entry in %s: "+
+			"the server changed its certificate, or someone is intercepting the connection", host, fingerprint, k.path)
+	}
+	if k.confirm == nil || !k.confirm(host, fingerprint) {
+		return fmt.Errorf("server %s is not in the known servers file %s (certificate fingerprint %s)", host, k.path, fingerprint)
+	}
+	if err := appendKnownServer(k.path, host, fingerprint); err != nil {
+		return err
+	}
+	k.hosts[host] = append(k.hosts[host], fingerprint)
+	logInfof("Added server %s with fingerprint %s to %s", host, fingerprint, k.path)
+	return nil
+}
+
+// appendKnownServer adds a '<host> <fingerprint>' entry to the known servers file, creating it if needed.
+func appendKnownServer(filePath, host, fingerprint string) error {
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open known servers file %s: %w", filePath, err)
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintf(file, "%s %s\n", host, fingerprint); err != nil {
+		return fmt.Errorf("failed to append to known servers file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// promptTrustServer returns a confirm callback that asks on out and reads a yes/no answer from in.
+// Anything but "yes" or "y" declines.
+func promptTrustServer(in io.Reader, out io.Writer) func(host, fingerprint string) bool {
+	return func(host, fingerprint string) bool {
+		fmt.Fprintf(out, "The authenticity of server '%s' can't be established.\nCertificate SHA-256 fingerprint: %s\n", host, fingerprint)
+		fmt.Fprintf(out, "Are you sure you want to trust it and add it to the known servers (yes/no)? ")
+		var answer string
+		fmt.Fscanln(in, &answer) // Reads unbuffered, leaving the rest of in for the caller
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		return answer == "yes" || answer == "y"
+	}
+}
+
+// createKnownServersClientTLSConfig creates a tls.Config for the client that trusts servers listed in
+// the known servers file instead of a trusted certificate.
+func createKnownServersClientTLSConfig(servers *knownServers, clientCertFile, clientKeyFile string) (*tls.Config, error) {
+	cert, err := loadKeyPair(clientCertFile, clientKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client key pair (%s, %s): %w", clientCertFile, clientKeyFile, err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		// Chain and hostname verification are replaced by the known servers lookup, as with pinning.
+		InsecureSkipVerify: true,
+		VerifyConnection:   servers.verifyConnection,
+	}, nil
+}
+
+// NewKnownServersClient creates a client that trusts servers by their entries in knownServersFile
+// (see knownservers.go). confirm, if not nil, is asked whether to trust and record a server the file
+// doesn't list yet.
+func NewKnownServersClient(serverURL, knownServersFile, clientCertFile, clientKeyFile string, confirm func(host, fingerprint string) bool) (*Client, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL %q: %w", serverURL, err)
+	}
+	servers, err := loadKnownServers(knownServersFile)
+	if err != nil {
+		return nil, err
+	}
+	servers.urlHost = u.Hostname()
+	servers.confirm = confirm
+	tlsConfig, err := createKnownServersClientTLSConfig(servers, clientCertFile, clientKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client TLS config: %w", err)
+	}
+
+	client := newClientWithTLSConfig(serverURL, tlsConfig)
+	client.CertFile = clientCertFile
+	client.KeyFile = clientKeyFile
+	return client, nil
+}
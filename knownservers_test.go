@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestKnownServersClient(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	serverCert, err := loadCertificate(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownServersFile := filepath.Join(pki.Dir, "knownServers.txt")
+	host := hostOf(strings.TrimPrefix(baseURL, "https://")) // An IP address, looked up under the URL host
+
+	// Unknown host without confirmation: rejected and not recorded
+	client, err := NewKnownServersClient(baseURL+"/hello", knownServersFile, pki.ClientCertFile, pki.ClientKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.SendRequest(); err == nil || !strings.Contains(err.Error(), "not in the known servers file") {
+		t.Fatalf("Expected an unknown server to be rejected, got %v", err)
+	}
+
+	// Confirmed on first use: recorded, then trusted without asking again
+	asked := 0
+	confirm := func(host, fingerprint string) bool {
+		asked++
+		if host != host || fingerprint != mtls.CertFingerprint(serverCert) {
+			t.Errorf("Asked about %s %s", host, fingerprint)
+		}
+		return true
+	}
+	client, err = NewKnownServersClient(baseURL+"/hello", knownServersFile, pki.ClientCertFile, pki.ClientKeyFile, confirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		client.httpClient.CloseIdleConnections()
+		if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+			t.Fatalf("Expected the confirmed server to be trusted, got %d (%v)", status, err)
+		}
+	}
+	if asked != 1 {
+		t.Errorf("Expected to be asked once, was asked %d times", asked)
+	}
+	content, err := ioutil.ReadFile(knownServersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := host + " " + mtls.CertFingerprint(serverCert) + "\n"; string(content) != want {
+		t.Errorf("Expected the known servers file to be %q, got %q", want, content)
+	}
+
+	// A changed certificate is rejected even when new servers would be confirmed
+	other := filepath.Join(pki.Dir, "other.crt")
+	pki.newClientCert(t, "localhost", other, filepath.Join(pki.Dir, "other.key"))
+	otherCert, err := loadCertificate(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(knownServersFile, []byte("# moved\n"+strings.ToUpper(host)+" "+mtls.CertFingerprint(otherCert)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, err = NewKnownServersClient(baseURL+"/hello", knownServersFile, pki.ClientCertFile, pki.ClientKeyFile, confirm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.SendRequest(); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("Expected a changed server certificate to be rejected, got %v", err)
+	}
+}
+
+func TestKnownServersSPKIEntry(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	serverCert, err := loadCertificate(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownServersFile := filepath.Join(pki.Dir, "knownServers.txt")
+	if err := ioutil.WriteFile(knownServersFile, []byte(hostOf(strings.TrimPrefix(baseURL, "https://"))+" spki:"+mtls.SPKIPin(serverCert)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewKnownServersClient(baseURL+"/hello", knownServersFile, pki.ClientCertFile, pki.ClientKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Errorf("Expected the server to be trusted by its public key, got %d (%v)", status, err)
+	}
+}
+
+func TestPromptTrustServer(t *testing.T) {
+	for answer, want := range map[string]bool{"yes\n": true, "Y\n": true, "no\n": false, "\n": false, "": false} {
+		var out bytes.Buffer
+		if got := promptTrustServer(strings.NewReader(answer), &out)("localhost", "AB:CD"); got != want {
+			t.Errorf("Answer %q: got %v, want %v", answer, got, want)
+		}
+		if !strings.Contains(out.String(), "AB:CD") {
+			t.Errorf("Expected the prompt to show the fingerprint, got %q", out.String())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// --- Multiple Listeners ---
+//
+// Besides Addr the server can listen on ExtraAddrs, e.g. on both 0.0.0.0:8443 and [::]:8443 for dual
+// stack, or on an internal and an external interface. Every listener serves the same handler with the
+// same TLS configuration, so clients are verified alike wherever they connect; requests are logged with
+// the local_addr they arrived on. Start fails if any address can't be bound. Redirects from HTTPAddr
+// point to the first listener. An address with port 0 (e.g. --addr 127.0.0.1:0) binds a port the OS
+// chooses, which is logged and returned by ListenAddrs, so tests and scripts can run servers side by
+// side without agreeing on ports.
+
+// listenAddrs returns Addr followed by ExtraAddrs.
+func (s *Server) listenAddrs() []string {
+	return append([]string{s.Addr}, s.ExtraAddrs...)
+}
+
+// validateListenAddrs checks every listen address and that none is repeated.
+func (s *Server) validateListenAddrs() error {
+	seen := make(map[string]bool)
+	for _, addr := range s.listenAddrs() {
+		if err := validateListenAddr(addr); err != nil {
+			return err
+		}
+		if seen[addr] && !hasPortZero(addr) { // Each port 0 gets a port of its own
+			return fmt.Errorf("address %s is listed more than once", addr)
+		}
+		seen[addr] = true
+	}
+	return nil
+}
+
+// listenAll binds a listener on every listen address, or serves the sockets passed by systemd instead
+// (see systemd.go). On error no listener is left open.
+func (s *Server) listenAll() ([]net.Listener, error) {
+	if listeners, err := systemdListeners(); err != nil || listeners != nil {
+		if listeners != nil {
+			logInfof("Serving on %s passed by systemd socket activation instead of binding %s",
+				joinListenerAddrs(listeners), strings.Join(s.listenAddrs(), ", "))
+		}
+		return listeners, err
+	}
+	var listeners []net.Listener
+	for _, addr := range s.listenAddrs() {
+		listener, err := s.listenOn(addr)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		if hasPortZero(addr) {
+			logInfof("Bound %s to %s, a port chosen by the OS", addr, listener.Addr())
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// hasPortZero reports whether addr is a TCP address asking the OS to choose the port.
+func hasPortZero(addr string) bool {
+	if _, ok := unixSocketPath(addr); ok {
+		return false
+	}
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port == "0"
+}
+
+// ListenAddrs returns the addresses the server's listeners are bound to, in the order of Addr and
+// ExtraAddrs, with the actual port of addresses given with port 0. It is nil until Ready is closed.
+func (s *Server) ListenAddrs() []string {
+	select {
+	case <-s.ready:
+		return s.boundAddrs
+	default:
+		return nil
+	}
+}
+
+// ListenAddr returns the first of ListenAddrs, the address Addr is bound to, or "" until Ready is closed.
+func (s *Server) ListenAddr() string {
+	if addrs := s.ListenAddrs(); len(addrs) > 0 {
+		return addrs[0]
+	}
+	return ""
+}
+
+// closeListeners closes every listener.
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// joinListenerAddrs lists the addresses listeners are bound to.
+func joinListenerAddrs(listeners []net.Listener) string {
+	addrs := make([]string, len(listeners))
+	for i, listener := range listeners {
+		addrs[i] = listener.Addr().String()
+	}
+	return strings.Join(addrs, ", ")
+}
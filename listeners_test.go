@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestServerOnSeveralAddrs(t *testing.T) {
+	pki := newTestPKI(t)
+	second := freeAddr(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.ExtraAddrs = []string{second}
+	})
+
+	for _, url := range []string{baseURL, "https://" + second} {
+		client, err := NewClient(url+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, logs := captureOutput(t, func() {
+			if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+				t.Fatalf("Expected the request to %s to succeed, got %d (%v)", url, status, err)
+			}
+		})
+		if local := strings.TrimPrefix(url, "https://"); !strings.Contains(logs, "local_addr="+local) {
+			t.Errorf("Expected the request to be logged with local_addr=%s, got:\n%s", local, logs)
+		}
+	}
+}
+
+func TestServerOnSeveralAddrsFailsTogether(t *testing.T) {
+	pki := newTestPKI(t)
+	taken, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	first := freeAddr(t)
+
+	server := NewServer(first, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.ExtraAddrs = []string{taken.Addr().String()}
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Fatal("Expected Start to fail when an address is taken")
+	} else if !strings.Contains(err.Error(), taken.Addr().String()) {
+		t.Errorf("Expected the error to name the taken address, got %v", err)
+	}
+	if l, err := net.Listen("tcp", first); err != nil {
+		t.Errorf("Expected the first address to be released, got %v", err)
+	} else {
+		l.Close()
+	}
+
+	server = NewServer(first, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.ExtraAddrs = []string{first}
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "more than once") {
+		t.Errorf("Expected a repeated address to be rejected, got %v", err)
+	}
+}
+
+func TestServerOnPortZero(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer("127.0.0.1:0", pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.ExtraAddrs = []string{"127.0.0.1:0"}
+	if server.ListenAddr() != "" {
+		t.Errorf("Expected no address before Start, got %q", server.ListenAddr())
+	}
+	var err error
+	_, logs := captureOutput(t, func() { err = server.Start() })
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	addrs := server.ListenAddrs()
+	if len(addrs) != 2 || addrs[0] == addrs[1] || addrs[0] != server.ListenAddr() {
+		t.Fatalf("Expected two distinct bound addresses, got %v", addrs)
+	}
+	for _, addr := range addrs {
+		if strings.HasSuffix(addr, ":0") {
+			t.Errorf("Expected %s to have the port the OS chose", addr)
+		}
+		if !strings.Contains(logs, "Bound 127.0.0.1:0 to "+addr) {
+			t.Errorf("Expected the bound address %s to be logged, got:\n%s", addr, logs)
+		}
+		client, err := NewClient("https://"+addr+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+			t.Errorf("Expected the request to %s to succeed, got %d (%v)", addr, status, err)
+		}
+	}
+}
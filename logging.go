@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Leveled, Structured Logging ---
+//
+// Logs go through log/slog, as key=value text or as JSON lines (--log-format). Operational messages use
+// the printf-style helpers; auth log lines use logAuth with structured fields (cn, fingerprint,
+// remote_addr, path) so they can be filtered and aggregated without parsing the message.
+
+// logLevel orders log messages by severity. Messages below the current level are dropped.
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+	levelSilent // Suppresses all logs and interactive output
+)
+
+// slogLevel returns the slog level of the log level. levelSilent is above every level that is logged.
+func (l logLevel) slogLevel() slog.Level {
+	switch l {
+	case levelDebug:
+		return slog.LevelDebug
+	case levelInfo:
+		return slog.LevelInfo
+	case levelWarn:
+		return slog.LevelWarn
+	case levelError:
+		return slog.LevelError
+	default:
+		return slog.LevelError + 1000
+	}
+}
+
+// parseLogLevel parses a --log-level value.
+func parseLogLevel(name string) (logLevel, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return levelDebug, nil
+	case "info", "":
+		return levelInfo, nil
+	case "warn":
+		return levelWarn, nil
+	case "error":
+		return levelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", name)
+}
+
+// currentLogLevel is read concurrently by server goroutines, so it is accessed atomically.
+var currentLogLevel = int32(levelInfo)
+
+// setLogLevel changes the minimum level of messages that are emitted.
+func setLogLevel(level logLevel) {
+	atomic.StoreInt32(&currentLogLevel, int32(level))
+}
+
+// getLogLevel returns the minimum level of messages that are emitted.
+func getLogLevel() logLevel {
+	return logLevel(atomic.LoadInt32(&currentLogLevel))
+}
+
+// currentLevel makes the slog handlers follow setLogLevel.
+type currentLevel struct{}
+
+func (currentLevel) Level() slog.Level { return getLogLevel().slogLevel() }
+
+// Log formats (--log-format).
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// stdLogOutput writes to the standard logger's current output, so log.SetOutput redirects slog records too.
+type stdLogOutput struct{}
+
+func (stdLogOutput) Write(p []byte) (int, error) { return log.Writer().Write(p) }
+
+// newLogHandler returns the slog handler for a log format.
+func newLogHandler(format string) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: currentLevel{}}
+	switch format {
+	case logFormatText, "":
+		return slog.NewTextHandler(stdLogOutput{}, opts), nil
+	case logFormatJSON:
+		return slog.NewJSONHandler(stdLogOutput{}, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (want %s or %s)", format, logFormatText, logFormatJSON)
+}
+
+// logger is swapped by configureLogging while server goroutines may be logging.
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	handler, _ := newLogHandler(logFormatText)
+	logger.Store(slog.New(handler))
+}
+
+// configureLogging applies the global --log-level, --log-format, --quiet and --silent flags.
+// --silent and --quiet take precedence over --log-level.
+func configureLogging(level, format string, quiet, silent bool) error {
+	handler, err := newLogHandler(format)
+	if err != nil {
+		return err
+	}
+	minLevel, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	switch {
+	case silent:
+		minLevel = levelSilent
+	case quiet:
+		minLevel = levelError
+	}
+	setLogLevel(minLevel)
+	logger.Store(slog.New(handler))
+	return nil
+}
+
+// logf writes a log message if its level is enabled.
+func logf(level logLevel, format string, args ...interface{}) {
+	if level < getLogLevel() {
+		return
+	}
+	logger.Load().Log(context.Background(), level.slogLevel(), fmt.Sprintf(format, args...))
+}
+
+func logDebugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func logInfof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func logWarnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
+func logErrorf(format string, args ...interface{}) { logf(levelError, format, args...) }
+
+// logAttrs writes a log line with structured fields if its level is enabled.
+func logAttrs(level logLevel, msg string, attrs ...slog.Attr) {
+	if level < getLogLevel() {
+		return
+	}
+	logger.Load().LogAttrs(context.Background(), level.slogLevel(), msg, attrs...)
+}
+
+// logAuth writes an auth log line with structured fields, e.g. from requestAttrs or decisionAttrs.
+func logAuth(level logLevel, msg string, attrs ...slog.Attr) {
+	logAttrs(level, msg, attrs...)
+}
+
+// requestAttrs returns the auth log fields of an HTTP request: the client's CN and certificate fingerprint,
+// its remote address and the request path.
+func requestAttrs(r *http.Request) []slog.Attr {
+	attrs := []slog.Attr{slog.String("cn", peerCN(r))}
+	if id, ok := ClientIdentityFromContext(r.Context()); ok && clientIDIfNotCN(id) != "" {
+		attrs = append(attrs, slog.String("client_id", id.ID))
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		attrs = append(attrs, slog.String("fingerprint", mtls.CertFingerprint(r.TLS.PeerCertificates[0])))
+	}
+	attrs = append(attrs, slog.String("remote_addr", r.RemoteAddr))
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok { // The listener, see listeners.go
+		attrs = append(attrs, slog.String("local_addr", local.String()))
+	}
+	return append(attrs, slog.String("path", r.URL.Path))
+}
+
+// decisionAttrs returns the auth log fields of a handshake decision. There is no request path yet.
+func decisionAttrs(d authDecision) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("cn", d.CN),
+		slog.String("fingerprint", d.Fingerprint),
+		slog.String("remote_addr", d.RemoteAddr),
+	}
+	if d.ClientID != "" {
+		attrs = append(attrs, slog.String("client_id", d.ClientID))
+	}
+	if d.Check != "" {
+		attrs = append(attrs, slog.String("check", d.Check))
+	}
+	if d.Reason != "" {
+		attrs = append(attrs, slog.String("reason", d.Reason))
+	}
+	return attrs
+}
+
+// outputf prints interactive output (e.g. the server response) to stdout unless running silent.
+func outputf(format string, args ...interface{}) {
+	if getLogLevel() >= levelSilent {
+		return
+	}
+	fmt.Fprintf(os.Stdout, format, args...)
+}
+
+// levelWriter adapts the leveled logger to an io.Writer, e.g. for http.Server.ErrorLog.
+type levelWriter logLevel
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	logf(logLevel(w), "%s", strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// newLevelLogger returns a *log.Logger whose output goes through the leveled logger at the given level.
+func newLevelLogger(level logLevel) *log.Logger {
+	return log.New(levelWriter(level), "", 0)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureOutput redirects the standard logger and os.Stdout while fn runs and returns what was written.
+func captureOutput(t *testing.T, fn func()) (stdout, logs string) {
+	t.Helper()
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origStdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = origStdout }()
+
+	fn()
+
+	w.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), logBuf.String()
+}
+
+func TestSilentModeProducesNoOutput(t *testing.T) {
+	pki := newTestPKI(t)
+	configureLogging("info", logFormatText, false, true)
+	defer configureLogging("info", logFormatText, false, false)
+
+	stdout, logs := captureOutput(t, func() {
+		_, baseURL := startTestServer(t, pki, nil)
+		client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := client.SendRequest(); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	})
+
+	if stdout != "" {
+		t.Errorf("Expected no stdout under --silent, got %q", stdout)
+	}
+	if logs != "" {
+		t.Errorf("Expected no logs under --silent, got %q", logs)
+	}
+}
+
+func TestQuietModeOnlyLogsErrors(t *testing.T) {
+	configureLogging("info", logFormatText, true, false)
+	defer configureLogging("info", logFormatText, false, false)
+
+	stdout, logs := captureOutput(t, func() {
+		logInfof("info message")
+		logWarnf("warn message")
+		logErrorf("error message")
+		outputf("response\n")
+	})
+
+	if strings.Contains(logs, "info message") || strings.Contains(logs, "warn message") {
+		t.Errorf("Expected only errors under --quiet, got %q", logs)
+	}
+	if !strings.Contains(logs, "error message") {
+		t.Errorf("Expected error to be logged under --quiet, got %q", logs)
+	}
+	if stdout != "response\n" {
+		t.Errorf("Expected interactive output under --quiet, got %q", stdout)
+	}
+}
+
+func TestJSONLogsIncludeAuthFields(t *testing.T) {
+	pki := newTestPKI(t)
+	if err := configureLogging("info", logFormatJSON, false, false); err != nil {
+		t.Fatal(err)
+	}
+	defer configureLogging("info", logFormatText, false, false)
+
+	_, logs := captureOutput(t, func() {
+		_, baseURL := startTestServer(t, pki, nil)
+		client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := client.SendRequest(); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	})
+
+	lines := map[string]map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(logs), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Expected every log line to be JSON, got %q: %v", line, err)
+		}
+		lines[record["msg"].(string)] = record
+	}
+	for msg, fields := range map[string][]string{
+		"Client authenticated": {"cn", "fingerprint", "remote_addr", "via"},
+		"Received request":     {"cn", "fingerprint", "remote_addr", "path"},
+	} {
+		record, ok := lines[msg]
+		if !ok {
+			t.Errorf("Expected a %q log line, got:\n%s", msg, logs)
+			continue
+		}
+		if record["level"] != "INFO" || record["cn"] != pki.ClientCN {
+			t.Errorf("Unexpected %q record: %v", msg, record)
+		}
+		for _, field := range fields {
+			if v, _ := record[field].(string); v == "" {
+				t.Errorf("Expected field %q in the %q record, got %v", field, msg, record)
+			}
+		}
+	}
+}
+
+func TestConfigureLoggingLevels(t *testing.T) {
+	defer configureLogging("info", logFormatText, false, false)
+	for _, tt := range []struct {
+		level     string
+		quiet     bool
+		wantDebug bool
+		wantInfo  bool
+	}{
+		{level: "debug", wantDebug: true, wantInfo: true},
+		{level: "info", wantInfo: true},
+		{level: "warn"},
+		{level: "debug", quiet: true}, // --quiet wins
+	} {
+		if err := configureLogging(tt.level, logFormatText, tt.quiet, false); err != nil {
+			t.Fatal(err)
+		}
+		_, logs := captureOutput(t, func() {
+			logDebugf("debug message")
+			logInfof("info message")
+			logErrorf("error message")
+		})
+		if got := strings.Contains(logs, "debug message"); got != tt.wantDebug {
+			t.Errorf("level %s, quiet %v: debug logged = %v", tt.level, tt.quiet, got)
+		}
+		if got := strings.Contains(logs, "info message"); got != tt.wantInfo {
+			t.Errorf("level %s, quiet %v: info logged = %v", tt.level, tt.quiet, got)
+		}
+		if !strings.Contains(logs, `level=ERROR msg="error message"`) {
+			t.Errorf("level %s, quiet %v: expected the error as key=value text, got %q", tt.level, tt.quiet, logs)
+		}
+	}
+	if err := configureLogging("verbose", logFormatText, false, false); err == nil {
+		t.Error("Expected an unknown level to be rejected")
+	}
+	if err := configureLogging("info", "xml", false, false); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
@@ -2,69 +2,527 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	// Ensure you have run 'go mod tidy' or 'go get github.com/alecthomas/kong'
 	"github.com/alecthomas/kong"
+
+	"tls-playground/pkg/mtls"
 )
 
 // --- CLI Structure ---
 
 // ServerCmd defines the kong command for the server.
 type ServerCmd struct {
-	CertFile     string `kong:"name='cert',help='Server certificate file.',default='certs/server.crt',type='path'"`
-	KeyFile      string `kong:"name='key',help='Server private key file.',default='certs/server.key',type='path'"`
-	KnownClients string `kong:"name='known-clients',help='File listing authorized client CNs and fingerprints.',default='certs/knownClients.txt',type='path'"`
-	Addr         string `kong:"name='addr',help='Address to listen on.',default=':8443'"`
+	CertFile              string        `kong:"name='cert',help='Server certificate file.',default='certs/server.crt',type='path'"`
+	KeyFile               string        `kong:"name='key',help='Server private key file.',default='certs/server.key',type='path'"`
+	P12                   string        `kong:"name='p12',help='PKCS#12 bundle (.p12 or .pfx) with the server certificate, chain and key, used instead of --cert and --key. Its password is taken like a key passphrase.',type='existingfile'"`
+	KeyPass               string        `kong:"name='key-pass',help='Passphrase of an encrypted --key. Visible to other local users; prefer --key-pass-file or the prompt.',xor='keypass'"`
+	KeyPassFile           string        `kong:"name='key-pass-file',help='Read the passphrase of an encrypted --key from the first line of this file.',xor='keypass',type='path'"`
+	SNICerts              []string      `kong:"name='sni-cert',help='Extra certificate for clients asking for other host names in SNI, as [HOST[,HOST...]=]CERT:KEY or [HOST[,HOST...]=]BUNDLE.p12 (repeatable). Hosts default to the DNS names in CERT; *.example.com matches one label. Other names get --cert.',sep='none'"`
+	Tenants               []string      `kong:"name='tenant',help='Host an isolated tenant for clients asking for its host names in SNI, with its own certificate and known clients, as HOST[,HOST...]=CERT:KEY:KNOWN_CLIENTS or HOST[,HOST...]=BUNDLE.p12:KNOWN_CLIENTS (repeatable).',sep='none'"`
+	KnownClients          string        `kong:"name='known-clients',help='File listing authorized client CNs and fingerprints.',default='certs/knownClients.txt',type='path'"`
+	K8sTLSSecret          string        `kong:"name='k8s-tls-secret',help='Read the key pair from the tls.crt and tls.key keys of this Kubernetes secret through the API (in-cluster service account) instead of --cert and --key, and reload it when the secret changes.'"`
+	K8sKnownClientsSecret string        `kong:"name='k8s-known-clients-secret',help='Read the known clients from this Kubernetes secret through the API instead of --known-clients, and reload them when the secret changes.'"`
+	K8sKnownClientsKey    string        `kong:"name='k8s-known-clients-key',help='Key of the known clients in --k8s-known-clients-secret.',default='knownClients.txt'"`
+	K8sNamespace          string        `kong:"name='k8s-namespace',help='Namespace of the Kubernetes secrets. Defaults to the namespace of the pod.'"`
+	K8sPoll               time.Duration `kong:"name='k8s-poll',help='Check the Kubernetes secrets for changes at this interval. 0 disables.',default='10s'"`
+	SPIFFESocket          string        `kong:"name='spiffe-socket',help='Present the X.509 SVID from this SPIFFE Workload API (unix:///path or tcp://host:port, e.g. a SPIRE agent) instead of --cert and --key, rotating it as the API does. With --verify-mode ca or both its trust bundle replaces --client-ca.'"`
+	Addrs                 []string      `kong:"name='addr',help='Address to listen on: host:port, or unix:///path/to/socket for a Unix domain socket. Repeat it (or separate with commas) to listen on several, e.g. 0.0.0.0:8443 and [::]:8443. Port 0 binds a free port chosen by the OS, which is logged. Ignored when systemd passes sockets (socket activation).',default=':8443'"`
+	SocketMode            string        `kong:"name='socket-mode',help='Permissions of the socket file with a unix:// --addr, in octal.',default='0600'"`
+	SocketGroup           string        `kong:"name='socket-group',help='Group (name or ID) owning the socket file with a unix:// --addr, e.g. to share it with a sidecar running as another user.'"`
+	Mode                  string        `kong:"name='mode',help='Serve HTTPS, echo lines over raw mTLS connections (see client echo), serve gRPC (see client grpc), or forward raw mTLS connections to --tunnel-target or --tunnel-allowlist destinations (see client tunnel and client socks).',enum='https,tcp,grpc,tunnel',default='https'"`
+	TunnelTarget          string        `kong:"name='tunnel-target',help='With --mode tunnel, the host:port to forward authenticated connections to, e.g. localhost:5432.',xor='tunnel'"`
+	TunnelAllowlist       string        `kong:"name='tunnel-allowlist',help='With --mode tunnel, let clients pick their destination (see client socks) among the host:port patterns this file lists for their CN.',xor='tunnel',type='path'"`
+
+	VerifyMode             string        `kong:"name='verify-mode',help='How to authenticate client certificates: listed in the known clients file, issued by --client-ca, or both.',enum='fingerprint,ca,both',default='fingerprint'"`
+	ClientCA               string        `kong:"name='client-ca',help='PEM bundle of CAs trusted to issue client certificates (--verify-mode ca or both).',type='path'"`
+	CRL                    string        `kong:"name='crl',help='CRL signed by a --client-ca CA (PEM or DER, see ca revoke); listed client certificates are rejected. Requires --verify-mode ca or both.',type='path'"`
+	WatchCRL               time.Duration `kong:"name='watch-crl',help='Poll --crl at this interval and reload it when it changes. 0 disables; SIGHUP always reloads.',default='5s'"`
+	DeniedClients          string        `kong:"name='denied-clients',help='File of client certificate fingerprints, spki:<fingerprint> public keys and cn:<name> CNs to reject before any other check, in every verify mode.',type='path'"`
+	WatchDeniedClients     time.Duration `kong:"name='watch-denied-clients',help='Poll --denied-clients at this interval and reload it when it changes. 0 disables; SIGHUP always reloads.',default='5s'"`
+	AllowedSigAlgs         []string      `kong:"name='allowed-sig-algs',help='Comma-separated signature algorithms accepted on client certificates (e.g. SHA256-RSA,ECDSA-SHA256,Ed25519). Empty accepts all.',sep=','"`
+	MaxClientCertLifetime  time.Duration `kong:"name='max-client-cert-lifetime',help='Reject client certificates whose total validity period exceeds this (e.g. 2160h for 90 days). 0 disables.',default='0'"`
+	RequireClientEKU       []string      `kong:"name='require-client-eku',help='Comma-separated extended key usages client certificates must list, e.g. clientAuth. Certificates without the extension are rejected. Empty accepts any purpose.',sep=','"`
+	ClientNamePatterns     []string      `kong:"name='client-name-pattern',help='Glob the CN and every DNS SAN of client certificates must match one of, e.g. *.internal.example.com (repeatable). Empty accepts any name.'"`
+	ClientIssuerPatterns   []string      `kong:"name='client-issuer-pattern',help='Glob the issuer of client certificates must match by CN or full DN, e.g. Example Issuing CA* (repeatable). Empty accepts any issuer.'"`
+	VerifyAudit            bool          `kong:"name='verify-audit',help='Run every client certificate check and report all failures, not just the first.'"`
+	TOFU                   bool          `kong:"name='tofu',help='Trust on first use: add a client whose CN is not in the known clients file with the certificate it first connects with.'"`
+	TOFUApproval           bool          `kong:"name='tofu-approval',help='Like --tofu, but hold new clients until they are approved on the admin API (POST /pending-clients/<cn>).'"`
+	AdminCNs               []string      `kong:"name='admin-cn',help='Client allowed to use the /admin/ endpoints, by CN or by the SAN --client-id-source selects (repeatable).'"`
+	PprofCNs               []string      `kong:"name='pprof-cn',help='Serve the Go profiling endpoints under /debug/pprof/ to this client, by CN or by the SAN --client-id-source selects (repeatable). Disabled unless set.'"`
+	Strict                 bool          `kong:"name='strict',help='Treat configuration problems, such as malformed known clients lines, as errors instead of warnings.'"`
+	MaxKnownClientsAge     time.Duration `kong:"name='max-kc-age',help='Warn (or refuse with --strict) when the known clients file was last modified longer ago than this, e.g. 24h. 0 disables.',default='0'"`
+	MaxKnownClients        int           `kong:"name='max-known-clients',help='Refuse to load a known clients file with more entries than this. 0 means no limit.',default='0'"`
+	CaseInsensitiveCN      bool          `kong:"name='case-insensitive-cn',help='Match client CNs against the known clients file ignoring case.'"`
+	ClientIDSource         string        `kong:"name='client-id-source',help='What identifies clients in the known clients file: the subject CN, or the first DNS, URI (e.g. a SPIFFE ID) or email SAN.',enum='cn,dns-san,uri-san,email-san',default='cn'"`
+	DegradeOnReloadFailure bool          `kong:"name='degrade-on-reload-failure',help='After a failed known clients reload, answer 503 with Retry-After and keep retrying until a reload succeeds.'"`
+	WatchServerCert        time.Duration `kong:"name='watch-server-cert',help='Poll --cert and --key at this interval and serve the new key pair to new handshakes when they change. 0 disables; SIGHUP always reloads.',default='5s'"`
+	NoSessionTickets       bool          `kong:"name='no-session-tickets',help='Disable TLS session resumption (TLS 1.2 tickets and TLS 1.3 PSKs), forcing a full handshake per connection.'"`
+	OCSPStaple             string        `kong:"name='ocsp-staple',help='Staple this DER OCSP response for --cert into handshakes. Re-read every --ocsp-refresh.',type='path'"`
+	OCSPFetch              bool          `kong:"name='ocsp-fetch',help='Staple an OCSP response fetched from the responder named in --cert.'"`
+	OCSPResponder          string        `kong:"name='ocsp-responder',help='Staple an OCSP response fetched from this responder URL instead of the one in --cert.'"`
+	OCSPIssuer             string        `kong:"name='ocsp-issuer',help='Issuer of --cert, to request and verify OCSP responses. Defaults to the second certificate in --cert.',type='path'"`
+	OCSPRefresh            time.Duration `kong:"name='ocsp-refresh',help='How often to re-read or re-fetch the stapled OCSP response.',default='1h'"`
+	ExpiryWarnDays         int           `kong:"name='expiry-warn-days',help='Warn at startup when --cert expires within this many days. 0 disables.',default='30'"`
+	StrictExpiry           bool          `kong:"name='strict-expiry',help='Refuse to start when --cert is expired or not yet valid, instead of warning.'"`
+	ShutdownTimeout        time.Duration `kong:"name='shutdown-timeout',help='On SIGINT/SIGTERM, wait this long for in-flight requests before closing their connections.',default='5s'"`
+	WatchKnownClients      time.Duration `kong:"name='watch-known-clients',help='Poll the known clients file at this interval and reload it when it changes, e.g. 2s. 0 disables; SIGHUP always reloads.',default='0'"`
+	DiagAddr               string        `kong:"name='diag-addr',help='Plain-HTTP address serving /diag/rejections so rejected clients can see which check failed (e.g. localhost:8081). Disabled if empty.'"`
+	AdminAddr              string        `kong:"name='admin-addr',help='Plain-HTTP address serving the known clients admin API (list, add, delete), e.g. localhost:8082. Disabled if empty.'"`
+	AdminAllowRemote       bool          `kong:"name='admin-allow-remote',help='Allow --admin-addr to be a non-loopback address. Needs --admin-token-file.'"`
+	AdminTokenFile         string        `kong:"name='admin-token-file',help='Require the token on the first line of this file as Authorization: Bearer on every admin API request.',type='path'"`
+	MetricsAddr            string        `kong:"name='metrics-addr',help='Plain-HTTP address serving Prometheus metrics at /metrics (e.g. localhost:9090). Disabled if empty.'"`
+	HTTPAddr               string        `kong:"name='http-addr',help='Plain-HTTP address redirecting every request to the HTTPS listener, with a body explaining that a client certificate is needed (e.g. :8080). Disabled if empty.'"`
+	HTTPNoRedirect         bool          `kong:"name='http-no-redirect',help='Answer --http-addr requests with the explanation only (400) instead of redirecting.'"`
+	MinTLS                 string        `kong:"name='min-tls',help='Minimum TLS version: 1.0, 1.1, 1.2 or 1.3.',default='1.2'"`
+	MaxTLS                 string        `kong:"name='max-tls',help='Maximum TLS version: 1.0, 1.1, 1.2 or 1.3. Defaults to the highest supported.'"`
+	Ciphers                []string      `kong:"name='ciphers',help='Comma-separated cipher suites for TLS 1.2 and earlier (see list-ciphers). TLS 1.3 suites are not configurable.',sep=','"`
+	BackendURL             string        `kong:"name='backend-url',help='Proxy authenticated requests to this HTTP backend (e.g. http://localhost:8080) with X-Client-CN and X-Client-Fingerprint headers, instead of answering hello.'"`
+	ALPN                   []string      `kong:"name='alpn',help='Comma-separated ALPN protocols to offer, in order of preference (e.g. h2,http/1.1, or a custom one). Defaults to h2,http/1.1 with --mode https; HTTP/2 is disabled unless h2 is listed.',sep=','"`
+	LogJA3                 bool          `kong:"name='log-ja3',help='Log an approximated JA3 fingerprint of each ClientHello (see ja3.go for what is approximated).'"`
+	TLSDebug               bool          `kong:"name='tls-debug',help='Log the offered and negotiated TLS version, cipher suite, ALPN protocol and SNI, and the client certificate chain, for every handshake.'"`
+	TLSKeyLog              string        `kong:"name='tls-keylog',help='Append TLS session secrets to this file in NSS key log format, to decrypt captured traffic in Wireshark.',type='path'"`
+	TokenTTL               time.Duration `kong:"name='token-ttl',help='Lifetime of certificate-bound tokens issued at /token.',default='5m'"`
+	RateLimit              float64       `kong:"name='rate-limit',help='Limit each client CN to this many requests per second (429 beyond it). A rate=<n> field on a known clients line overrides it for that client. 0 disables.',default='0'"`
+	RateBurst              int           `kong:"name='rate-burst',help='Requests a client may send at once before --rate-limit applies. 0 allows one second worth.',default='0'"`
+	MaxConns               int           `kong:"name='max-conns',help='Maximum connections open at once, across all listeners. 0 means no limit.',default='0'"`
+	MaxConnsPerIP          int           `kong:"name='max-conns-per-ip',help='Maximum connections open at once from one client IP; more are closed before the handshake. 0 means no limit.',default='0'"`
+	MaxConnsPolicy         string        `kong:"name='max-conns-policy',help='What to do with connections over --max-conns: close them before the handshake, or stop accepting and leave them in the accept queue until a connection closes.',enum='refuse,queue',default='refuse'"`
+	DecisionLog            string        `kong:"name='decision-log',help='Append every auth decision, including failed handshakes, to this file as JSON lines. SIGHUP reopens it.'"`
+	DecisionLogMaxSize     int           `kong:"name='decision-log-max-size',help='Rotate the decision log before it grows past this many megabytes. 0 disables rotation.',default='0'"`
+	DecisionLogMaxBackups  int           `kong:"name='decision-log-max-backups',help='Rotated decision logs to keep (decisions.jsonl.1 is the newest).',default='5'"`
+	SinkWorkers            int           `kong:"name='sink-workers',help='Worker goroutines writing auth decisions to sinks such as the decision log.',default='4'"`
+	SinkQueue              int           `kong:"name='sink-queue',help='Auth decisions that may wait for a sink worker.',default='1024'"`
+	SinkPolicy             string        `kong:"name='sink-policy',help='What to do when the sink queue is full: drop the decision, or block the handshake until there is room.',enum='drop,block',default='drop'"`
+	DumpConfig             bool          `kong:"name='dump-config',help='Print the effective configuration as JSON (private key paths redacted) and exit.'"`
 }
 
 // Run starts the server using the Server struct from server.go.
 func (s *ServerCmd) Run() error {
-	server := NewServer(s.Addr, s.CertFile, s.KeyFile, s.KnownClients)
-	err := server.Start() // Start runs the server in a goroutine
+	sigAlgs, err := parseSignatureAlgorithms(s.AllowedSigAlgs)
+	if err != nil {
+		return fmt.Errorf("invalid --allowed-sig-algs: %w", err)
+	}
+	requiredEKUs, err := parseExtKeyUsages(s.RequireClientEKU)
+	if err != nil {
+		return fmt.Errorf("invalid --require-client-eku: %w", err)
+	}
+
+	tlsVersions, err := parseTLSVersions(s.MinTLS, s.MaxTLS, s.Ciphers)
+	if err != nil {
+		return err
+	}
+	alpn, err := parseALPN(s.ALPN)
+	if err != nil {
+		return fmt.Errorf("invalid --alpn: %w", err)
+	}
+
+	if err := applyP12Flag(s.P12, &s.CertFile, &s.KeyFile); err != nil {
+		return err
+	}
+	setKeyPassphraseFlags(s.KeyFile, s.KeyPass, s.KeyPassFile)
+	var sniCerts []SNICertificate
+	for _, value := range s.SNICerts {
+		c, err := parseSNICertificate(value)
+		if err != nil {
+			return fmt.Errorf("invalid --sni-cert: %w", err)
+		}
+		sniCerts = append(sniCerts, c)
+	}
+	var tenants []Tenant
+	for _, value := range s.Tenants {
+		t, err := parseTenant(value)
+		if err != nil {
+			return fmt.Errorf("invalid --tenant: %w", err)
+		}
+		tenants = append(tenants, t)
+	}
+	socketMode, err := parseSocketMode(s.SocketMode)
+	if err != nil {
+		return fmt.Errorf("invalid --socket-mode: %w", err)
+	}
+	if len(s.Addrs) == 0 {
+		return fmt.Errorf("--addr is required")
+	}
+	server := NewServer(s.Addrs[0], s.CertFile, s.KeyFile, s.KnownClients)
+	server.ExtraAddrs = s.Addrs[1:]
+	server.SocketMode = socketMode
+	server.SocketGroup = s.SocketGroup
+	server.SNICertificates = sniCerts
+	server.Tenants = tenants
+	server.SPIFFESocket = s.SPIFFESocket
+	server.KubeTLSSecret = s.K8sTLSSecret
+	server.KubeKnownClientsSecret = s.K8sKnownClientsSecret
+	server.KubeKnownClientsKey = s.K8sKnownClientsKey
+	server.KubeNamespace = s.K8sNamespace
+	server.KubePoll = s.K8sPoll
+	server.Mode = s.Mode
+	server.TunnelTarget = s.TunnelTarget
+	server.TunnelAllowlistFile = s.TunnelAllowlist
+	server.TLSVersions = tlsVersions
+	server.ALPN = alpn
+	server.BackendURL = s.BackendURL
+	server.VerifyMode = s.VerifyMode
+	server.ClientCAFile = s.ClientCA
+	server.CRLFile = s.CRL
+	server.WatchCRL = s.WatchCRL
+	server.DeniedClientsFile = s.DeniedClients
+	server.WatchDeniedClients = s.WatchDeniedClients
+	server.AllowedSignatureAlgorithms = sigAlgs
+	server.MaxClientCertLifetime = s.MaxClientCertLifetime
+	server.RequiredClientEKUs = requiredEKUs
+	server.ClientNamePatterns = s.ClientNamePatterns
+	server.ClientIssuerPatterns = s.ClientIssuerPatterns
+	server.VerifyAudit = s.VerifyAudit
+	server.TOFU = s.TOFU
+	server.TOFUApproval = s.TOFUApproval
+	server.AdminCNs = s.AdminCNs
+	server.PprofCNs = s.PprofCNs
+	server.Strict = s.Strict
+	server.MaxKnownClientsAge = s.MaxKnownClientsAge
+	server.MaxKnownClients = s.MaxKnownClients
+	server.CaseInsensitiveCN = s.CaseInsensitiveCN
+	server.ClientIDSource = mtls.IdentitySource(s.ClientIDSource)
+	server.DegradeOnReloadFailure = s.DegradeOnReloadFailure
+	server.WatchKnownClients = s.WatchKnownClients
+	server.WatchServerCert = s.WatchServerCert
+	server.SessionTicketsDisabled = s.NoSessionTickets
+	server.OCSPStapleFile = s.OCSPStaple
+	server.OCSPFetch = s.OCSPFetch
+	server.OCSPResponderURL = s.OCSPResponder
+	server.OCSPIssuerFile = s.OCSPIssuer
+	server.OCSPRefresh = s.OCSPRefresh
+	server.ExpiryWarnDays = s.ExpiryWarnDays
+	server.StrictExpiry = s.StrictExpiry
+	server.ShutdownTimeout = s.ShutdownTimeout
+	server.DiagAddr = s.DiagAddr
+	server.AdminAddr = s.AdminAddr
+	server.AdminAllowRemote = s.AdminAllowRemote
+	server.AdminTokenFile = s.AdminTokenFile
+	server.MetricsAddr = s.MetricsAddr
+	server.HTTPAddr = s.HTTPAddr
+	server.HTTPNoRedirect = s.HTTPNoRedirect
+	server.LogJA3 = s.LogJA3
+	server.TLSDebug = s.TLSDebug
+	server.TLSKeyLogFile = s.TLSKeyLog
+	server.TokenTTL = s.TokenTTL
+	server.RateLimit = s.RateLimit
+	server.RateBurst = s.RateBurst
+	server.MaxConns = s.MaxConns
+	server.MaxConnsPerIP = s.MaxConnsPerIP
+	server.ConnLimitPolicy = s.MaxConnsPolicy
+	server.DecisionLogFile = s.DecisionLog
+	server.DecisionLogMaxSize = int64(s.DecisionLogMaxSize) << 20
+	server.DecisionLogMaxBackups = s.DecisionLogMaxBackups
+	server.SinkWorkers = s.SinkWorkers
+	server.SinkQueue = s.SinkQueue
+	server.SinkPolicy = s.SinkPolicy
+	if s.DumpConfig {
+		return server.dumpConfig()
+	}
+	err = server.Start() // Start runs the server in a goroutine
 	if err != nil {
 		// Use log.Fatalf only in main or test setup, return error here
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 
-	// Keep the main goroutine alive. Server runs in its own goroutine.
-	// In a real app, you might wait on a signal channel here for graceful shutdown.
-	log.Println("Server started. Running indefinitely...")
-	select {}
+	// Keep the main goroutine alive until SIGINT/SIGTERM. Server runs in its own goroutine.
+	logInfof("Server started. Press Ctrl+C to stop, send SIGHUP to reload %s and %s.", s.KnownClients, s.CertFile)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	if err := server.handleSignals(signals); err != nil {
+		return fmt.Errorf("server did not shut down cleanly: %w", err)
+	}
+	return nil
 }
 
 // ClientCmd defines the kong command for the client.
+// Its flags are shared by all client subcommands; plain `client` sends a request.
 type ClientCmd struct {
-	CertFile       string `kong:"name='cert',help='Client certificate file.',default='certs/client.crt',type='path'"`
-	KeyFile        string `kong:"name='key',help='Client private key file.',default='certs/client.key',type='path'"`
-	ServerCertFile string `kong:"name='server-cert',help='Server certificate file for client verification.',default='certs/server.crt',type='path'"`
-	ServerURL      string `kong:"name='url',help='Server URL to connect to.',default='https://localhost:8443/hello'"`
+	CertFile          string `kong:"name='cert',help='Client certificate file.',default='certs/client.crt',type='path'"`
+	KeyFile           string `kong:"name='key',help='Client private key file.',default='certs/client.key',type='path'"`
+	Intermediates     string `kong:"name='intermediates',help='PEM file of intermediate CA certificates to present after --cert, for a client certificate issued by an intermediate CA. Not needed when --cert already holds the chain.',type='existingfile'"`
+	P12               string `kong:"name='p12',help='PKCS#12 bundle (.p12 or .pfx) with the client certificate, chain and key, used instead of --cert and --key. Its password is taken like a key passphrase.',type='existingfile'"`
+	KeyPass           string `kong:"name='key-pass',help='Passphrase of an encrypted --key. Visible to other local users; prefer --key-pass-file or the prompt.',xor='keypass'"`
+	KeyPassFile       string `kong:"name='key-pass-file',help='Read the passphrase of an encrypted --key from the first line of this file.',xor='keypass',type='path'"`
+	ServerCertFile    string `kong:"name='server-cert',help='Server certificate file for client verification.',default='certs/server.crt',type='path'"`
+	ServerFingerprint string `kong:"name='server-fingerprint',help='Trust the server by the SHA-256 fingerprint of its public key (hex, or base64 as printed by --print-pins) instead of --server-cert.',xor='trust'"`
+	KnownServers      string `kong:"name='known-servers',help='Trust servers by the fingerprints listed for their host names in this file (like SSH known_hosts) instead of --server-cert.',xor='trust',type='path'"`
+	AskNewServers     bool   `kong:"name='ask-new-servers',help='Ask whether to trust a server missing from --known-servers, and add it to the file if confirmed.'"`
+	ServerURL         string `kong:"name='url',help='Server URL to connect to.',default='https://localhost:8443/hello'"`
+	UnixSocket        string `kong:"name='unix-socket',help='Connect to the server through this Unix domain socket (see server --addr unix://...). The --url host is still sent in SNI and verified.',type='path'"`
+	SPIFFESocket      string `kong:"name='spiffe-socket',help='Present the X.509 SVID from this SPIFFE Workload API (unix:///path or tcp://host:port, e.g. a SPIRE agent) instead of --cert and --key, and trust servers by its trust bundle instead of --server-cert. Rotates as the API does.',xor='trust'"`
+	SPIFFEServerID    string `kong:"name='spiffe-server-id',help='With --spiffe-socket, only trust a server with this SPIFFE ID, e.g. spiffe://example.org/server.'"`
+	SNI               string `kong:"name='sni',help='Server name to send in SNI and verify the server certificate against, instead of the --url host. Connects to the --url address.'"`
+
+	Method   string   `kong:"name='method',short='X',help='HTTP method. Defaults to GET, or POST with --data or --data-file.'"`
+	Data     string   `kong:"name='data',short='d',help='Request body.',xor='data'"`
+	DataFile string   `kong:"name='data-file',help='Read the request body from this file, or from stdin with -.',xor='data'"`
+	Headers  []string `kong:"name='header',short='H',help='Request header as \"Name: value\" (repeatable).',sep='none'"`
+
+	Retries         int           `kong:"name='retries',help='Retry 429/503 responses (honoring Retry-After) and failures to reach the server (with backoff) up to this many times.',default='0'"`
+	MaxRetryAfter   time.Duration `kong:"name='max-retry-after',help='Maximum time to wait for a single Retry-After.',default='30s'"`
+	RetryBackoff    time.Duration `kong:"name='retry-backoff',help='Wait before the first retry of a failure to reach the server; doubles with each retry.',default='200ms'"`
+	MaxRetryBackoff time.Duration `kong:"name='max-retry-backoff',help='Maximum wait between retries of failures to reach the server. 0 means no cap.',default='5s'"`
+	Timeout         time.Duration `kong:"name='timeout',help='Give up on a single attempt after this long, e.g. 5s. 0 means no timeout.',default='0'"`
+	Deadline        time.Duration `kong:"name='deadline',help='Give up on the request after this long, retries included, e.g. 30s. 0 means no deadline.',default='0'"`
+	TLSReport       string        `kong:"name='tls-report',help='Write a JSON report of the negotiated TLS parameters, server chain and timings to this file after a 2xx response.',type='path'"`
+	PrintPins       bool          `kong:"name='print-pins',help='Print the base64 SHA-256 SPKI pins (pin-sha256) of the server certificate chain after a 2xx response.'"`
+	SessionCache    int           `kong:"name='session-cache',help='Remember up to this many TLS sessions so new connections to the same server can resume them. 0 disables.',default='0'"`
+	RequireOCSP     bool          `kong:"name='require-ocsp-staple',help='Fail unless the server staples a current OCSP response saying its certificate is good.'"`
+	ExpiryWarnDays  int           `kong:"name='expiry-warn-days',help='Warn when --cert or --server-cert expires within this many days. 0 disables.',default='30'"`
+	StrictExpiry    bool          `kong:"name='strict-expiry',help='Refuse to run when --cert or --server-cert is expired or not yet valid, instead of warning.'"`
+
+	MaxIdleConns        int           `kong:"name='max-idle-conns',help='Maximum idle connections kept open across all hosts. 0 means no limit.',default='0'"`
+	MaxIdleConnsPerHost int           `kong:"name='max-idle-conns-per-host',help='Maximum idle connections kept open to each host. 0 uses the net/http default of 2.',default='0'"`
+	MaxConnsPerHost     int           `kong:"name='max-conns-per-host',help='Maximum connections to each host, idle or not; further requests wait. 0 means no limit.',default='0'"`
+	IdleConnTimeout     time.Duration `kong:"name='idle-conn-timeout',help='Close connections that have been idle this long, e.g. 30s. 0 keeps them open.',default='0'"`
+	DisableKeepAlives   bool          `kong:"name='disable-keep-alives',help='Open a new connection (and do a TLS handshake) for every request instead of reusing open ones.'"`
+	ForceNewHandshake   bool          `kong:"name='force-new-handshake',help='Do a full TLS handshake for every request: disables keep-alives and session resumption.'"`
+
+	MinTLS        string   `kong:"name='min-tls',help='Minimum TLS version: 1.0, 1.1, 1.2 or 1.3.',default='1.2'"`
+	MaxTLS        string   `kong:"name='max-tls',help='Maximum TLS version: 1.0, 1.1, 1.2 or 1.3. Defaults to the highest supported.'"`
+	Ciphers       []string `kong:"name='ciphers',help='Comma-separated cipher suites to offer for TLS 1.2 and earlier (see list-ciphers).',sep=','"`
+	Renegotiation string   `kong:"name='renegotiation',help='Accept TLS 1.2 renegotiations started by the server: never, once or freely. Go does not support TLS 1.3 post-handshake client auth.',enum='never,once,freely',default='never'"`
+	ALPN          []string `kong:"name='alpn',help='Comma-separated ALPN protocols to offer, in order of preference. Defaults to h2,http/1.1; HTTP/2 is disabled unless h2 is listed.',sep=','"`
+
+	NoFollowRedirects  bool     `kong:"name='no-follow-redirects',help='Return redirect responses instead of following them.'"`
+	MaxRedirects       int      `kong:"name='max-redirects',help='Maximum number of redirects to follow.',default='10'"`
+	StripCertCrossHost bool     `kong:"name='strip-cert-cross-host',help='Only present the client certificate to the --url host and --cert-host entries.'"`
+	CertHosts          []string `kong:"name='cert-host',help='Additional host (or host:port) the client certificate may be presented to (repeatable).'"`
+
+	Get      ClientGetCmd      `kong:"cmd,default='withargs',help='Send a request to the server (default).'"`
+	Pop      ClientPopCmd      `kong:"cmd,help='Prove possession of the client private key by signing a server-issued nonce.'"`
+	Sign     ClientSignCmd     `kong:"cmd,help='Send the request signed with the client key (HTTP message signatures) to /signed, which verifies it against the TLS client certificate.'"`
+	Token    ClientTokenCmd    `kong:"cmd,help='Exchange the mTLS identity for a certificate-bound JWT at /token and show its claims.'"`
+	Repl     ClientReplCmd     `kong:"cmd,help='Interactively send requests (METHOD PATH BODY) over a single keep-alive connection.'"`
+	Echo     ClientEchoCmd     `kong:"cmd,help='Send stdin line by line over a raw mTLS connection to a server started with --mode tcp.'"`
+	WS       ClientWSCmd       `kong:"cmd,name='ws',help='Send stdin line by line over a WebSocket to the /ws endpoint of the server, printing the identity it pushes.'"`
+	GRPC     ClientGRPCCmd     `kong:"cmd,name='grpc',help='Call the Hello RPC of a server started with --mode grpc, at the host and port of --url.'"`
+	Bench    ClientBenchCmd    `kong:"cmd,help='Load test the server with concurrent requests (bench requests, the default) or time raw TLS handshakes (bench handshake).'"`
+	Transfer ClientTransferCmd `kong:"cmd,help='Stream a large payload to /upload and from /download and report the transfer rates, e.g. to compare cipher suites.'"`
+	SOCKS    ClientSOCKSCmd    `kong:"cmd,name='socks',help='Serve SOCKS5 on a local port, reaching each destination through a server started with --mode tunnel --tunnel-allowlist.'"`
+	Tunnel   ClientTunnelCmd   `kong:"cmd,help='Accept plaintext connections on a local port and forward each over mTLS to a server started with --mode tunnel (like stunnel).'"`
+	Clients  ClientClientsCmd  `kong:"cmd,help='Admin commands about the clients of the server, such as clients watch.'"`
+	Daemon   ClientDaemonCmd   `kong:"cmd,help='Keep sending requests at an interval, presenting a renewed --cert and --key from the next request on (zero-downtime rotation).'"`
 }
 
-// Run executes the client request using the Client struct from client.go.
-func (c *ClientCmd) Run() error {
-	client, err := NewClient(c.ServerURL, c.ServerCertFile, c.CertFile, c.KeyFile)
+// newClient creates a Client from the shared client flags.
+func (c *ClientCmd) newClient() (*Client, error) {
+	tlsVersions, err := parseTLSVersions(c.MinTLS, c.MaxTLS, c.Ciphers)
+	if err != nil {
+		return nil, err
+	}
+	alpn, err := parseALPN(c.ALPN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --alpn: %w", err)
+	}
+	renegotiation, err := parseRenegotiation(c.Renegotiation)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --renegotiation: %w", err)
+	}
+	if err := applyP12Flag(c.P12, &c.CertFile, &c.KeyFile); err != nil {
+		return nil, err
+	}
+	setKeyPassphraseFlags(c.KeyFile, c.KeyPass, c.KeyPassFile)
+	if c.SPIFFESocket == "" { // SVIDs are short-lived and rotated by the Workload API
+		if err := c.checkExpiry(); err != nil {
+			return nil, err
+		}
+	} else if c.P12 != "" {
+		return nil, fmt.Errorf("--spiffe-socket replaces the client certificate, it can't be used with --p12")
+	}
+	if c.SPIFFEServerID != "" && c.SPIFFESocket == "" {
+		return nil, fmt.Errorf("--spiffe-server-id requires --spiffe-socket")
+	}
+	if c.ForceNewHandshake && c.SessionCache > 0 {
+		return nil, fmt.Errorf("--force-new-handshake disables session resumption, it can't be used with --session-cache")
+	}
+	var client *Client
+	switch {
+	case c.SPIFFESocket != "":
+		client, err = NewSPIFFEClient(c.ServerURL, c.SPIFFESocket, c.SPIFFEServerID)
+	case c.ServerFingerprint != "":
+		client, err = NewPinnedClient(c.ServerURL, c.ServerFingerprint, c.CertFile, c.KeyFile)
+	case c.KnownServers != "":
+		var confirm func(host, fingerprint string) bool
+		if c.AskNewServers {
+			confirm = promptTrustServer(os.Stdin, os.Stderr)
+		}
+		client, err = NewKnownServersClient(c.ServerURL, c.KnownServers, c.CertFile, c.KeyFile, confirm)
+	default:
+		client, err = NewClient(c.ServerURL, c.ServerCertFile, c.CertFile, c.KeyFile)
+	}
 	if err != nil {
 		// Use log.Fatalf only in main or test setup, return error here
-		return fmt.Errorf("failed to create client: %w", err)
+		return nil, fmt.Errorf("failed to create client: %w", err)
+	}
+	if c.Intermediates != "" {
+		if c.SPIFFESocket != "" {
+			return nil, fmt.Errorf("--spiffe-socket presents the chain of the SVID, it can't be used with --intermediates")
+		}
+		if err := client.AddIntermediates(c.Intermediates); err != nil {
+			return nil, fmt.Errorf("invalid --intermediates: %w", err)
+		}
+	}
+	if err := c.applyRequest(client); err != nil {
+		return nil, err
+	}
+	client.MaxRetries = c.Retries
+	client.MaxRetryAfter = c.MaxRetryAfter
+	client.RetryBackoff = c.RetryBackoff
+	client.MaxRetryBackoff = c.MaxRetryBackoff
+	client.RequestTimeout = c.Timeout
+	client.Deadline = c.Deadline
+	client.TLSReportFile = c.TLSReport
+	client.PrintPins = c.PrintPins
+	client.NoFollowRedirects = c.NoFollowRedirects
+	client.MaxRedirects = c.MaxRedirects
+	client.StripCertCrossHost = c.StripCertCrossHost
+	client.CertHosts = c.CertHosts
+	client.SetTLSVersions(tlsVersions)
+	client.SetALPN(alpn)
+	client.SetRenegotiation(renegotiation)
+	if c.SNI != "" {
+		client.SetServerName(c.SNI)
+	}
+	if c.UnixSocket != "" {
+		client.SetUnixSocket(c.UnixSocket)
 	}
+	if c.SessionCache > 0 {
+		client.EnableSessionCache(c.SessionCache)
+	}
+	client.SetTransportOptions(TransportOptions{
+		MaxIdleConns:        c.MaxIdleConns,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		IdleConnTimeout:     c.IdleConnTimeout,
+		DisableKeepAlives:   c.DisableKeepAlives,
+		ForceNewHandshake:   c.ForceNewHandshake,
+	})
+	if c.RequireOCSP {
+		client.RequireOCSPStaple()
+	}
+	return client, nil
+}
 
-	_, _, err = client.SendRequest()
+// checkExpiry warns about (or with --strict-expiry rejects) expiring client and server certificates.
+// The server certificate is only checked when it is trusted by file rather than by fingerprint.
+func (c *ClientCmd) checkExpiry() error {
+	if mtls.IsPKCS12File(c.CertFile) {
+		pair, err := loadKeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client PKCS#12 bundle %s: %w", c.CertFile, err)
+		}
+		if err := checkCertExpiry("client certificate "+c.CertFile, pair.Leaf, c.ExpiryWarnDays, c.StrictExpiry, time.Now()); err != nil {
+			return err
+		}
+	} else if err := checkCertFileExpiry("client certificate", c.CertFile, c.ExpiryWarnDays, c.StrictExpiry); err != nil {
+		return err
+	}
+	if c.ServerFingerprint == "" && c.KnownServers == "" {
+		return checkCertFileExpiry("server certificate", c.ServerCertFile, c.ExpiryWarnDays, c.StrictExpiry)
+	}
+	return nil
+}
+
+// applyRequest sets the method, headers and body of the client's request from the flags.
+func (c *ClientCmd) applyRequest(client *Client) error {
+	header, err := parseHeaders(c.Headers)
 	if err != nil {
-		return fmt.Errorf("client request failed: %w", err)
+		return fmt.Errorf("invalid --header: %w", err)
+	}
+	client.Method = c.Method
+	client.Header = header
+	switch {
+	case c.DataFile == "-":
+		if client.Body, err = ioutil.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("failed to read request body from stdin: %w", err)
+		}
+	case c.DataFile != "":
+		if client.Body, err = ioutil.ReadFile(c.DataFile); err != nil {
+			return fmt.Errorf("failed to read request body: %w", err)
+		}
+	case c.Data != "":
+		client.Body = []byte(c.Data)
+	}
+	return nil
+}
+
+// ClientGetCmd sends a single request to the server.
+type ClientGetCmd struct {
+	Repeat    int  `kong:"name='repeat',help='Send the request this many times, each over a new connection (e.g. to see sessions resumed with --session-cache).',default='1'"`
+	KeepAlive bool `kong:"name='keep-alive',help='Send repeated requests over an open connection when there is one, instead of a new connection each time.'"`
+	JSON      bool `kong:"name='json',help='Ask for a JSON response (unless --header sets Accept) and pretty-print it.'"`
+}
+
+// Run executes the client request using the Client struct from client.go.
+func (g *ClientGetCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	client.JSON = g.JSON
+
+	for i := 0; i < max(g.Repeat, 1); i++ {
+		if i > 0 && !g.KeepAlive {
+			client.httpClient.CloseIdleConnections() // Force a new handshake
+		}
+		if _, _, err = client.SendRequest(); err != nil {
+			return fmt.Errorf("client request failed: %w", err)
+		}
 	}
 	// Response is printed within SendRequest for interactive use
 	return nil
 }
 
+// ClientPopCmd runs the proof-of-possession exchange.
+type ClientPopCmd struct{}
+
+// Run fetches a nonce, signs it with the client key and has the server verify the signature.
+func (p *ClientPopCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	if err := client.ProvePossession(); err != nil {
+		return fmt.Errorf("proof of possession failed: %w", err)
+	}
+	return nil
+}
+
 // --- Main CLI Definition & Execution ---
 
 var cli struct {
+	Config configFlag `kong:"name='config',help='YAML file setting flags of any command, nested under the command name (see configfile.go). TLS_PLAYGROUND_* environment variables override it.'"`
+
+	Quiet  bool `kong:"name='quiet',short='q',help='Only log errors.'"`
+	Silent bool `kong:"name='silent',help='Suppress all logs and interactive output; rely on the exit code.'"`
+
+	LogLevel  string `kong:"name='log-level',help='Minimum level of messages to log. --quiet and --silent take precedence.',enum='debug,info,warn,error',default='info'"`
+	LogFormat string `kong:"name='log-format',help='Log as key=value text or as JSON lines.',enum='text,json',default='text'"`
+
 	Server ServerCmd `kong:"cmd,help='Run the mTLS server with known client verification.'"`
 	Client ClientCmd `kong:"cmd,help='Run the mTLS client.'"`
+
+	GenCert     GenCertCmd     `kong:"cmd,name='gen-cert',help='Generate self-signed server and client certificates.'"`
+	CA          CACmd          `kong:"cmd,name='ca',help='Create a playground CA and issue certificates signed by it.'"`
+	RotateTest  RotateTestCmd  `kong:"cmd,name='rotate-test',help='Rotate the client certificate end-to-end against a temporary server.'"`
+	ListCiphers ListCiphersCmd `kong:"cmd,name='list-ciphers',help='List the cipher suites accepted by --ciphers.'"`
+	Fingerprint FingerprintCmd `kong:"cmd,help='Print the CN and SHA-256 fingerprint of certificates, as the known clients file expects them.'"`
+	Inspect     InspectCmd     `kong:"cmd,help='Print the subject, issuer, SANs, validity, key, fingerprints and extensions of certificates.'"`
+	Doctor      DoctorCmd      `kong:"cmd,help='Check the certificates, keys and known clients file of the setup and run a loopback handshake.'"`
+	ExportP12   ExportP12Cmd   `kong:"cmd,name='export-p12',help='Bundle a certificate, its chain and its key into a password-protected PKCS#12 file for browsers and Java.'"`
 }
 
 func main() {
+	settings := &settingsResolver{}
 	ctx := kong.Parse(&cli,
 		kong.Name("tls-playground"),
 		kong.Description("A playground CLI for mTLS with known client/server verification."),
@@ -72,10 +530,17 @@ func main() {
 		kong.ConfigureHelp(kong.HelpOptions{
 			Compact: true,
 		}),
+		kong.Resolvers(settings),
+		kong.Bind(settings),
 	)
+	ctx.FatalIfErrorf(configureLogging(cli.LogLevel, cli.LogFormat, cli.Quiet, cli.Silent))
+
 	// kong.Parse returns the parsed command context (ctx)
 	// ctx.Run() executes the Run() method of the selected command (ServerCmd or ClientCmd)
 	err := ctx.Run()
+	if err != nil && cli.Silent {
+		os.Exit(1) // --silent leaves only the exit code
+	}
 	// ctx.FatalIfErrorf handles the error returned from the command's Run method
 	// It prints the error and exits with a non-zero status if err is not nil.
 	ctx.FatalIfErrorf(err)
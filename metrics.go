@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Prometheus Metrics ---
+//
+// The metrics are always collected; MetricsAddr only decides whether they are served. They live in a
+// registry per server rather than the global one, so several servers (e.g. in tests) don't collide.
+// /metrics is served on its own plain-HTTP listener because the main listener requires a client
+// certificate, which scrapers usually don't have.
+
+const metricsPath = "/metrics"
+
+// serverMetrics holds the server's Prometheus collectors.
+type serverMetrics struct {
+	registry           *prometheus.Registry
+	handshakes         *prometheus.CounterVec
+	handshakeDuration  prometheus.Histogram
+	resumedHandshakes  prometheus.Counter
+	requests           *prometheus.CounterVec
+	activeConns        prometheus.Gauge
+	connectionBytes    *prometheus.CounterVec
+	connectionDuration prometheus.Histogram
+	connectionIdle     prometheus.Histogram
+	refusedConns       *prometheus.CounterVec
+}
+
+func newServerMetrics() *serverMetrics {
+	m := &serverMetrics{
+		registry: prometheus.NewRegistry(),
+		handshakes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tls_playground_handshakes_total",
+			Help: "Client certificate verifications by result, and by the failing check for failures.",
+		}, []string{"result", "reason"}),
+		handshakeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "tls_playground_handshake_duration_seconds",
+			Help:    "Time from ClientHello to the end of client certificate verification, for completed handshakes.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms to ~4s
+		}),
+		resumedHandshakes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "tls_playground_resumed_handshakes_total",
+			Help: "Completed handshakes that resumed an earlier TLS session.",
+		}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tls_playground_requests_total",
+			Help: "HTTP requests by client certificate CN.",
+		}, []string{"cn"}),
+		activeConns: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "tls_playground_active_connections",
+			Help: "Open HTTPS connections.",
+		}),
+		connectionBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tls_playground_connection_bytes_total",
+			Help: "Bytes received and sent on HTTPS connections, including TLS overhead.",
+		}, []string{"direction"}),
+		connectionDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "tls_playground_connection_duration_seconds",
+			Help:    "Lifetime of closed HTTPS connections, from accept to close.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10), // 10ms to ~43min
+		}),
+		connectionIdle: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "tls_playground_connection_idle_seconds",
+			Help:    "Time keep-alive HTTPS connections spent idle before their next request or close.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10), // 1ms to ~4min
+		}),
+		refusedConns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tls_playground_refused_connections_total",
+			Help: "Connections closed before the handshake for exceeding a connection limit, by limit.",
+		}, []string{"reason"}),
+	}
+	m.registry.MustRegister(m.handshakes, m.handshakeDuration, m.resumedHandshakes, m.requests, m.activeConns,
+		m.connectionBytes, m.connectionDuration, m.connectionIdle, m.refusedConns,
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// observeDecision counts a client certificate verification.
+func (m *serverMetrics) observeDecision(d authDecision) {
+	if d.Allowed {
+		m.handshakes.WithLabelValues("success", "none").Inc()
+		return
+	}
+	reason := d.Check
+	if reason == "" {
+		reason = "other" // e.g. no or unparsable certificate
+	}
+	m.handshakes.WithLabelValues("failure", reason).Inc()
+}
+
+// instrumentHandshakes times each handshake from its ClientHello. VerifyConnection runs once the client
+// certificate has been verified, so failed handshakes are not timed.
+func (m *serverMetrics) instrumentHandshakes(cfg *tls.Config) {
+	mtls.WrapVerifyConnection(cfg, func(*tls.ClientHelloInfo) func(tls.ConnectionState) error {
+		start := time.Now()
+		return func(cs tls.ConnectionState) error {
+			m.handshakeDuration.Observe(time.Since(start).Seconds())
+			if cs.DidResume {
+				m.resumedHandshakes.Inc()
+			}
+			return nil
+		}
+	})
+}
+
+// trackConnState keeps the active connections gauge up to date, for use as http.Server.ConnState.
+func (m *serverMetrics) trackConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.activeConns.Inc()
+	case http.StateClosed, http.StateHijacked:
+		m.activeConns.Dec()
+	}
+}
+
+// countRequests counts requests per client CN.
+func (s *Server) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.metrics.requests.WithLabelValues(peerCN(r)).Inc()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// startMetricsServer starts the plain-HTTP metrics listener if MetricsAddr is set.
+func (s *Server) startMetricsServer() error {
+	if s.MetricsAddr == "" {
+		return nil
+	}
+	if err := validateAddr(s.MetricsAddr); err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{ErrorLog: newLevelLogger(levelError)}))
+	listener, err := net.Listen("tcp", s.MetricsAddr)
+	if err != nil {
+		return err
+	}
+	s.metricsServer = &http.Server{Handler: mux, ErrorLog: newLevelLogger(levelError)}
+	logInfof("Serving Prometheus metrics on http://%s%s", listener.Addr(), metricsPath)
+	go func() {
+		if err := s.metricsServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErrorf("Metrics server error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopMetricsServer shuts down the metrics listener, if running.
+func (s *Server) stopMetricsServer(ctx context.Context) error {
+	if s.metricsServer == nil {
+		return nil
+	}
+	return s.metricsServer.Shutdown(ctx)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the text exposition served at addr.
+func scrapeMetrics(t *testing.T, addr string) string {
+	t.Helper()
+	resp, err := http.Get("http://" + addr + metricsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from %s, got %d: %s", metricsPath, resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	pki := newTestPKI(t)
+	metricsAddr := freeAddr(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.MetricsAddr = metricsAddr })
+
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := client.SendRequest(); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	}
+	if metrics := scrapeMetrics(t, metricsAddr); !strings.Contains(metrics, "tls_playground_active_connections 1") {
+		t.Errorf("Expected the keep-alive connection to be counted as active:\n%s", metrics)
+	}
+
+	unknownCert := filepath.Join(pki.Dir, "unknown.crt")
+	unknownKey := filepath.Join(pki.Dir, "unknown.key")
+	pki.newClientCert(t, "intruder", unknownCert, unknownKey)
+	intruder, err := NewClient(baseURL+"/hello", pki.ServerCertFile, unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := intruder.SendRequest(); err == nil {
+		t.Fatal("Expected unknown client to be rejected")
+	}
+
+	metrics := scrapeMetrics(t, metricsAddr)
+	for _, want := range []string{
+		`tls_playground_handshakes_total{reason="none",result="success"} 1`, // Both requests share a keep-alive connection
+		`tls_playground_handshakes_total{reason="known-client",result="failure"} 1`,
+		`tls_playground_requests_total{cn="test_client"} 2`,
+		"tls_playground_handshake_duration_seconds_count 1",
+		"go_goroutines ",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("Expected %q in metrics:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, `cn="intruder"`) {
+		t.Error("Expected no request count for a client rejected at the handshake")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/tls"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Content Negotiation ---
+//
+// The hello handler answers in the media type the client prefers in its Accept header: text/plain (the
+// default, also for a missing Accept header or */*) or application/json, which describes the client
+// identity, the server and the connection as a helloResponse. Preferences are weighed by their q values
+// and the most specific range matching a type wins, as in RFC 9110; a request accepting neither gets 406.
+// `client --json` asks for JSON and pretty-prints it.
+
+const (
+	contentTypeText = "text/plain"
+	contentTypeJSON = "application/json"
+)
+
+// negotiateContentType returns the offer the request's Accept header prefers, the first offer on ties
+// or without an Accept header, or "" if it accepts none of them.
+func negotiateContentType(r *http.Request, offers ...string) string {
+	accept := r.Header.Values("Accept")
+	if len(accept) == 0 {
+		return offers[0]
+	}
+	type acceptRange struct {
+		mediaType string
+		q         float64
+	}
+	var ranges []acceptRange
+	for _, value := range accept {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		for _, ar := range ranges {
+			s := mediaRangeSpecificity(ar.mediaType, offer)
+			if s > specificity {
+				q, specificity = ar.q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// mediaRangeSpecificity reports how specifically mediaRange (e.g. text/*) matches mediaType: 2 for an
+// exact match, 1 for type/*, 0 for */* and -1 if it doesn't match.
+func mediaRangeSpecificity(mediaRange, mediaType string) int {
+	switch {
+	case mediaRange == mediaType:
+		return 2
+	case mediaRange == "*/*":
+		return 0
+	case strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*")):
+		return 1
+	}
+	return -1
+}
+
+// helloResponse is the JSON form of the hello handler's response.
+type helloResponse struct {
+	Message string      `json:"message"`
+	Client  helloClient `json:"client"`
+	Server  helloServer `json:"server"`
+	Time    time.Time   `json:"time"`
+}
+
+// helloClient is the authenticated client in a helloResponse.
+type helloClient struct {
+	CN          string    `json:"cn"`
+	ClientID    string    `json:"client_id,omitempty"` // Set when clients are identified by a SAN
+	Fingerprint string    `json:"fingerprint"`
+	NotAfter    time.Time `json:"not_after"`
+	RemoteAddr  string    `json:"remote_addr"`
+}
+
+// helloServer describes the server and the connection in a helloResponse.
+type helloServer struct {
+	Hostname    string `json:"hostname,omitempty"`
+	ServerName  string `json:"server_name,omitempty"` // Requested in SNI
+	Protocol    string `json:"protocol"`
+	TLSVersion  string `json:"tls_version"`
+	CipherSuite string `json:"cipher_suite"`
+	Resumed     bool   `json:"resumed"`
+}
+
+// newHelloResponse describes the client of r, which must be authenticated.
+func newHelloResponse(r *http.Request, id ClientIdentity) helloResponse {
+	hostname, _ := os.Hostname()
+	return helloResponse{
+		Message: "Hello, authenticated client '" + id.CN + "'!",
+		Client: helloClient{
+			CN:          id.CN,
+			ClientID:    clientIDIfNotCN(id),
+			Fingerprint: id.Fingerprint,
+			NotAfter:    id.Certificate.NotAfter,
+			RemoteAddr:  r.RemoteAddr,
+		},
+		Server: helloServer{
+			Hostname:    hostname,
+			ServerName:  r.TLS.ServerName,
+			Protocol:    negotiatedProtocol(r),
+			TLSVersion:  tlsVersionName(r.TLS.Version),
+			CipherSuite: tls.CipherSuiteName(r.TLS.CipherSuite),
+			Resumed:     r.TLS.DidResume,
+		},
+		Time: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", contentTypeText},
+		{"*/*", contentTypeText},
+		{"application/json", contentTypeJSON},
+		{"text/html, application/json;q=0.9", contentTypeJSON},
+		{"application/*", contentTypeJSON},
+		{"text/plain;q=0.5, application/json", contentTypeJSON},
+		{"application/json;q=0.5, */*", contentTypeText},
+		{"*/*;q=0.1, application/json;q=0", contentTypeText},
+		{"application/json;q=0, text/plain;q=0", ""},
+		{"image/png", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.accept != "" {
+			r.Header.Set("Accept", tt.accept)
+		}
+		if got := negotiateContentType(r, contentTypeText, contentTypeJSON); got != tt.want {
+			t.Errorf("Accept %q: got %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestHelloJSON(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client := newTestClient(t, pki, baseURL)
+	client.Header = http.Header{"Accept": {"application/json"}}
+
+	body, status, err := client.SendRequest()
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Expected a JSON hello, got %d (%v)", status, err)
+	}
+	var hello helloResponse
+	if err := json.Unmarshal([]byte(body), &hello); err != nil {
+		t.Fatalf("Expected a JSON body, got %q: %v", body, err)
+	}
+	if hello.Client.CN != pki.ClientCN || hello.Client.Fingerprint == "" || hello.Server.TLSVersion == "" || hello.Time.IsZero() {
+		t.Errorf("Unexpected hello response: %+v", hello)
+	}
+
+	client.Header = http.Header{"Accept": {"image/png"}}
+	if _, status, _ := client.SendRequest(); status != http.StatusNotAcceptable {
+		t.Errorf("Expected 406 for an unacceptable type, got %d", status)
+	}
+}
+
+func TestClientJSONPrettyPrints(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client := newTestClient(t, pki, baseURL)
+	client.JSON = true
+
+	var err error
+	stdout, _ := captureOutput(t, func() { _, _, err = client.SendRequest() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stdout, "{\n  \"message\": \"Hello, authenticated client 'test_client'!\"") {
+		t.Errorf("Expected only the indented JSON response, got %q", stdout)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// --- OCSP Stapling ---
+//
+// The server can staple an OCSP response for its certificate into the handshake, so clients learn
+// that it hasn't been revoked without asking the CA themselves. The response comes from
+// OCSPStapleFile (DER, e.g. from `openssl ocsp -respout`) or is fetched from the responder named in
+// the certificate (OCSPFetch) or from OCSPResponderURL. Either way it is re-read every OCSPRefresh
+// and after the server certificate is reloaded. Responses that don't verify against the issuer, or
+// are past their next update, are not stapled.
+//
+// The client checks the staple with --require-ocsp-staple: the handshake fails without a staple, or
+// with one that doesn't verify or doesn't say "good".
+
+const (
+	defaultOCSPRefresh = time.Hour
+	ocspFetchTimeout   = 10 * time.Second
+)
+
+// ocspStapling reports whether the server staples OCSP responses.
+func (s *Server) ocspStapling() bool {
+	return s.OCSPStapleFile != "" || s.OCSPFetch || s.OCSPResponderURL != ""
+}
+
+// refreshOCSPStaple loads a fresh OCSP response for the current server certificate and staples it.
+func (s *Server) refreshOCSPStaple() error {
+	current := s.serverCert.current.Load()
+	leaf := current.Leaf
+	issuer, err := s.ocspIssuer(current)
+	if err != nil {
+		return err
+	}
+
+	var der []byte
+	if s.OCSPStapleFile != "" {
+		if der, err = ioutil.ReadFile(s.OCSPStapleFile); err != nil {
+			return fmt.Errorf("failed to read OCSP response %s: %w", s.OCSPStapleFile, err)
+		}
+	} else {
+		url := s.OCSPResponderURL
+		if url == "" {
+			if len(leaf.OCSPServer) == 0 {
+				return fmt.Errorf("server certificate %s names no OCSP responder (use --ocsp-responder)", s.CertFile)
+			}
+			url = leaf.OCSPServer[0]
+		}
+		if der, err = fetchOCSPResponse(url, leaf, issuer); err != nil {
+			return err
+		}
+	}
+
+	resp, err := verifyOCSPResponse(der, leaf, issuer, time.Now())
+	if err != nil {
+		return err
+	}
+	if resp.Status != ocsp.Good {
+		logWarnf("OCSP responder reports the server certificate as %s; stapling it anyway", ocspStatusName(resp.Status))
+	}
+	if !s.serverCert.staple(leaf, der) {
+		return errors.New("server certificate changed while its OCSP response was loaded")
+	}
+	logInfof("Stapled OCSP response for %s (status %s, next update %s)",
+		s.CertFile, ocspStatusName(resp.Status), resp.NextUpdate.Format(time.RFC3339))
+	return nil
+}
+
+// ocspIssuer returns the certificate that issued the server certificate: OCSPIssuerFile, the next
+// certificate of the chain in CertFile, or the certificate itself if it is self-signed.
+func (s *Server) ocspIssuer(cert *tls.Certificate) (*x509.Certificate, error) {
+	switch {
+	case s.OCSPIssuerFile != "":
+		return loadCertificate(s.OCSPIssuerFile)
+	case len(cert.Certificate) > 1:
+		return x509.ParseCertificate(cert.Certificate[1])
+	case bytes.Equal(cert.Leaf.RawIssuer, cert.Leaf.RawSubject):
+		return cert.Leaf, nil
+	}
+	return nil, fmt.Errorf("no issuer for the OCSP response of %s: add it to the file or use --ocsp-issuer", s.CertFile)
+}
+
+// watchOCSPStaple refreshes the staple every OCSPRefresh until the server stops. A failed refresh
+// keeps the current staple, which clients reject once it is past its next update.
+func (s *Server) watchOCSPStaple() {
+	ticker := time.NewTicker(s.OCSPRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+		if err := s.refreshOCSPStaple(); err != nil {
+			logErrorf("Failed to refresh OCSP staple: %v", err)
+		}
+	}
+}
+
+// staple attaches an OCSP response to the current key pair, unless it was replaced by a pair other
+// than leaf in the meantime.
+func (c *serverCertificate) staple(leaf *x509.Certificate, der []byte) bool {
+	current := c.current.Load()
+	if !bytes.Equal(current.Certificate[0], leaf.Raw) {
+		return false
+	}
+	stapled := *current
+	stapled.OCSPStaple = der
+	return c.current.CompareAndSwap(current, &stapled)
+}
+
+// fetchOCSPResponse asks the responder at url about leaf.
+func fetchOCSPResponse(url string, leaf, issuer *x509.Certificate) ([]byte, error) {
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+	client := &http.Client{Timeout: ocspFetchTimeout}
+	resp, err := client.Post(url, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query OCSP responder %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s answered %s", url, resp.Status)
+	}
+	der, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCSP response from %s: %w", url, err)
+	}
+	return der, nil
+}
+
+// verifyOCSPResponse parses an OCSP response for leaf, checking its signature against issuer and
+// that it is current.
+func verifyOCSPResponse(der []byte, leaf, issuer *x509.Certificate, now time.Time) (*ocsp.Response, error) {
+	resp, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	if now.Before(resp.ThisUpdate.Add(-time.Minute)) { // Tolerate small clock skew
+		return nil, fmt.Errorf("OCSP response is not valid before %s", resp.ThisUpdate.Format(time.RFC3339))
+	}
+	if !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate) {
+		return nil, fmt.Errorf("OCSP response is stale: its next update was due at %s", resp.NextUpdate.Format(time.RFC3339))
+	}
+	return resp, nil
+}
+
+// ocspStatusName names an OCSP certificate status.
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	}
+	return "unknown"
+}
+
+// RequireOCSPStaple makes handshakes fail unless the server staples a current OCSP response saying its
+// certificate is good. Call it before the first request. The staple is checked after any existing
+// VerifyConnection callback, such as the known servers lookup.
+func (c *Client) RequireOCSPStaple() {
+	c.tlsConfig.VerifyConnection = chainVerifyConnection(c.tlsConfig.VerifyConnection, verifyStapledOCSP)
+	c.anonymousTLSConfig.VerifyConnection = chainVerifyConnection(c.anonymousTLSConfig.VerifyConnection, verifyStapledOCSP)
+}
+
+// chainVerifyConnection returns a VerifyConnection callback running first (if not nil) and then next.
+func chainVerifyConnection(first, next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	if first == nil {
+		return next
+	}
+	return func(cs tls.ConnectionState) error {
+		if err := first(cs); err != nil {
+			return err
+		}
+		return next(cs)
+	}
+}
+
+// verifyStapledOCSP is the tls.Config.VerifyConnection callback of RequireOCSPStaple. The response is
+// checked against the issuer from the verified chain, or the one the server sent, or the server
+// certificate itself when it is self-signed. Without a verified chain (pinned or known servers) the
+// server picks the issuer, so it must have signed the server certificate: otherwise whoever holds the
+// server key could staple a good status signed by an issuer of their own.
+func verifyStapledOCSP(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	if len(cs.OCSPResponse) == 0 {
+		return errors.New("server stapled no OCSP response")
+	}
+	leaf := cs.PeerCertificates[0]
+	issuer := leaf
+	switch {
+	case len(cs.VerifiedChains) > 0 && len(cs.VerifiedChains[0]) > 1:
+		issuer = cs.VerifiedChains[0][1]
+	case len(cs.PeerCertificates) > 1:
+		issuer = cs.PeerCertificates[1]
+		if err := leaf.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("stapled OCSP response can't be checked: the server's second certificate did not issue its certificate: %w", err)
+		}
+	default:
+		if err := leaf.CheckSignature(leaf.SignatureAlgorithm, leaf.RawTBSCertificate, leaf.Signature); err != nil {
+			return fmt.Errorf("stapled OCSP response can't be checked: the server sent no issuer and its certificate is not self-signed: %w", err)
+		}
+	}
+	resp, err := verifyOCSPResponse(cs.OCSPResponse, leaf, issuer, time.Now())
+	if err != nil {
+		return fmt.Errorf("stapled %w", err)
+	}
+	if resp.Status != ocsp.Good {
+		return fmt.Errorf("stapled OCSP response reports the server certificate as %s", ocspStatusName(resp.Status))
+	}
+	logDebugf("Stapled OCSP response is good (next update %s)", resp.NextUpdate.Format(time.RFC3339))
+	return nil
+}
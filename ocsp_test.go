@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// issueServerCert writes a localhost server certificate signed by ca to dir and returns its files and certificate.
+func issueServerCert(t *testing.T, ca *testCA, dir string) (certFile, keyFile string, cert *x509.Certificate) {
+	t.Helper()
+	certPEM, keyPEM, err := generateCert(certOptions{
+		CommonName:  "localhost",
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca.Cert, ca.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "ocsp-server.crt"), filepath.Join(dir, "ocsp-server.key")
+	if err := writeCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if cert, err = loadCertificate(certFile); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+func TestOCSPStapling(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	responder := newFakeRevocationResponder(t, ca)
+	certFile, keyFile, cert := issueServerCert(t, ca, dir)
+
+	server, url := startTestServer(t, pki, func(s *Server) {
+		s.CertFile, s.KeyFile = certFile, keyFile
+		s.OCSPResponderURL = responder.URL + "/ocsp"
+		s.OCSPIssuerFile = ca.CertFile
+	})
+	send := func() error {
+		client, err := NewClient(url+"/hello", ca.CertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.RequireOCSPStaple()
+		_, _, err = client.SendRequest()
+		return err
+	}
+	if err := send(); err != nil {
+		t.Fatalf("Expected a good stapled OCSP response to be accepted: %v", err)
+	}
+
+	responder.Revoke(cert.SerialNumber)
+	if err := server.refreshOCSPStaple(); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(); err == nil || !strings.Contains(err.Error(), "revoked") {
+		t.Errorf("Expected the client to reject a stapled revoked status, got %v", err)
+	}
+}
+
+func TestRequireOCSPStapleWithoutStaple(t *testing.T) {
+	pki := newTestPKI(t)
+	_, url := startTestServer(t, pki, nil)
+	client, err := NewClient(url+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.RequireOCSPStaple()
+	if _, _, err := client.SendRequest(); err == nil || !strings.Contains(err.Error(), "no OCSP response") {
+		t.Errorf("Expected the client to require a staple, got %v", err)
+	}
+}
+
+func TestStapledOCSPRequiresIssuerOfLeaf(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	forged := newTestCA(t, t.TempDir())
+	_, _, cert := issueServerCert(t, ca, dir)
+	staple := func(issuer *testCA) []byte {
+		now := time.Now()
+		resp, err := ocsp.CreateResponse(issuer.Cert, issuer.Cert, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: cert.SerialNumber,
+			ThisUpdate:   now.Add(-time.Minute),
+			NextUpdate:   now.Add(time.Hour),
+		}, issuer.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// Without verified chains (e.g. a pinned server) the issuer is whatever the server sent.
+	if err := verifyStapledOCSP(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert, ca.Cert}, OCSPResponse: staple(ca)}); err != nil {
+		t.Errorf("Expected a staple from the real issuer to be accepted, got %v", err)
+	}
+	if err := verifyStapledOCSP(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert, forged.Cert}, OCSPResponse: staple(forged)}); err == nil {
+		t.Error("Expected a staple signed by an issuer that did not sign the certificate to be refused")
+	}
+	if err := verifyStapledOCSP(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, OCSPResponse: staple(ca)}); err == nil {
+		t.Error("Expected a staple without an issuer for a certificate that is not self-signed to be refused")
+	}
+}
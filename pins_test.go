@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+// pinTestCert is a fixed P-256 certificate whose pin was computed with:
+//
+//	openssl x509 -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+const pinTestCert = `-----BEGIN CERTIFICATE-----
+MIIBgDCCASegAwIBAgIUUlikkh0nuaVr70V6uThziz5rniYwCgYIKoZIzj0EAwIw
+FjEUMBIGA1UEAwwLcGluLmV4YW1wbGUwHhcNMjYxMDE2MDk0NjE4WhcNMzYxMDEz
+MDk0NjE4WjAWMRQwEgYDVQQDDAtwaW4uZXhhbXBsZTBZMBMGByqGSM49AgEGCCqG
+SM49AwEHA0IABGt5NDaCRQ51QYRq5RuuyMFcDvBGCQsclIzhtMiPs8waxoepdFhM
+fwn/+OmbRCH/EdNz7By+FXeFn9X0a1630qKjUzBRMB0GA1UdDgQWBBQGX701s3gg
+YgKcm/aJpSi3qSHuoTAfBgNVHSMEGDAWgBQGX701s3ggYgKcm/aJpSi3qSHuoTAP
+BgNVHRMBAf8EBTADAQH/MAoGCCqGSM49BAMCA0cAMEQCIDPhr09FttXJm5s235wa
+MW6EPhlWh7FWxj0CWK8vYoTDAiAQmHNHLkNNinnVy+3ZUFwsLhHKQzYpCNVz23VM
+XfUMng==
+-----END CERTIFICATE-----
+`
+
+const pinTestCertPin = "gQ0vC1SMwxSzchWUJoVy8Oesx22XRYgoavqMtle93ZI="
+
+func TestSPKIPinForKnownCert(t *testing.T) {
+	block, _ := pem.Decode([]byte(pinTestCert))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin := mtls.SPKIPin(cert); pin != pinTestCertPin {
+		t.Errorf("Expected pin %s, got %s", pinTestCertPin, pin)
+	}
+}
+
+func TestClientPrintPins(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.PrintPins = true
+	serverCert, err := loadCertificate(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout, _ := captureOutput(t, func() {
+		if _, _, err := client.SendRequest(); err != nil {
+			t.Error(err)
+		}
+	})
+	if want := `pin-sha256="` + mtls.SPKIPin(serverCert) + `"`; !strings.Contains(stdout, want) {
+		t.Errorf("Expected %s in output, got:\n%s", want, stdout)
+	}
+}
+
+func TestParseKeyFingerprint(t *testing.T) {
+	block, _ := pem.Decode([]byte(pinTestCert))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	colonHex := strings.ToUpper(hex.EncodeToString(hash[:]))
+	var parts []string
+	for i := 0; i < len(colonHex); i += 2 {
+		parts = append(parts, colonHex[i:i+2])
+	}
+
+	for _, in := range []string{pinTestCertPin, hex.EncodeToString(hash[:]), strings.Join(parts, ":")} {
+		got, err := mtls.ParseKeyFingerprint(in)
+		if err != nil || !bytes.Equal(got, hash[:]) {
+			t.Errorf("mtls.ParseKeyFingerprint(%q) = %x, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "AA:BB", "bm90IGEgcGlu"} {
+		if _, err := mtls.ParseKeyFingerprint(in); err == nil {
+			t.Errorf("Expected mtls.ParseKeyFingerprint(%q) to fail", in)
+		}
+	}
+}
+
+func TestPinnedClientSurvivesServerReissue(t *testing.T) {
+	pki := newTestPKI(t)
+	serverCert, err := loadCertificate(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := mtls.SPKIPin(serverCert)
+
+	// Same key, new CN and SANs: a client trusting server.crt would now fail, the pinned one doesn't.
+	reissuedCertFile := filepath.Join(pki.Dir, "reissued.crt")
+	reissueCert(t, pki.ServerCertFile, pki.ServerKeyFile, "renamed.example", reissuedCertFile)
+	reissued := *pki
+	reissued.ServerCertFile = reissuedCertFile
+	_, baseURL := startTestServer(t, &reissued, nil)
+
+	pinned, err := NewPinnedClient(baseURL+"/hello", pin, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := pinned.SendRequest(); err != nil || status != 200 {
+		t.Fatalf("Expected the pinned client to accept the re-issued certificate, got %d (%v)", status, err)
+	}
+	trusting, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := trusting.SendRequest(); err == nil {
+		t.Error("Expected the client trusting the old server.crt to reject the re-issued certificate")
+	}
+
+	// A different key fails the pin.
+	otherPKI := newTestPKI(t)
+	_, otherURL := startTestServer(t, otherPKI, nil)
+	pinned, err = NewPinnedClient(otherURL+"/hello", pin, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := pinned.SendRequest(); err == nil || !strings.Contains(err.Error(), "fingerprint mismatch") {
+		t.Fatalf("Expected a fingerprint mismatch for a server with another key, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+	"software.sslmate.com/src/go-pkcs12"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- PKCS#12 Bundles ---
+//
+// A PKCS#12 file (.p12 or .pfx) holds a certificate, its chain and its private key, protected by a
+// password: the format browsers, Java keystores and Windows import client identities in. Server and
+// client take one with --p12 instead of --cert and --key; the password comes from --key-pass,
+// --key-pass-file or a prompt, like the passphrase of an encrypted key (see keypass.go). Bundles are
+// recognized by their extension wherever a certificate file is loaded with its key. export-p12 makes
+// a bundle out of PEM files.
+
+// applyP12Flag points certFile and keyFile at a --p12 bundle, if given.
+func applyP12Flag(p12 string, certFile, keyFile *string) error {
+	if p12 == "" {
+		return nil
+	}
+	if !mtls.IsPKCS12File(p12) {
+		return fmt.Errorf("--p12 %s: PKCS#12 bundles must end in .p12 or .pfx", p12)
+	}
+	*certFile, *keyFile = p12, p12
+	return nil
+}
+
+// ExportP12Cmd bundles a certificate, its chain and its key into a PKCS#12 file.
+type ExportP12Cmd struct {
+	CertFile     string   `kong:"name='cert',help='Certificate to export. Certificates after the first in the file are exported as its chain.',default='certs/client.crt',type='existingfile'"`
+	KeyFile      string   `kong:"name='key',help='Private key of the certificate. An encrypted key asks for its passphrase.',default='certs/client.key',type='existingfile'"`
+	Chain        []string `kong:"name='chain',help='PEM file of intermediate or CA certificates to include (repeatable).',type='existingfile'"`
+	Out          string   `kong:"name='out',short='o',help='Bundle to write. Defaults to the certificate file name with a .p12 extension.',type='path'"`
+	Password     string   `kong:"name='password',help='Password of the bundle. Visible to other local users; prefer --password-file or the prompt.',xor='password'"`
+	PasswordFile string   `kong:"name='password-file',help='Read the password of the bundle from the first line of this file.',xor='password',type='existingfile'"`
+	Legacy       bool     `kong:"name='legacy',help='Encrypt with 3DES and SHA-1 for older importers (Java 8, older macOS and Windows) instead of AES-256 and PBKDF2.'"`
+	Force        bool     `kong:"name='force',help='Overwrite an existing bundle.'"`
+}
+
+// Run writes the bundle.
+func (e *ExportP12Cmd) Run() error {
+	out := e.Out
+	if out == "" {
+		out = strings.TrimSuffix(e.CertFile, ".crt") + ".p12"
+	}
+	if !e.Force {
+		if err := refuseOverwrite(out); err != nil {
+			return err
+		}
+	}
+
+	pair, err := loadKeyPair(e.CertFile, e.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load key pair (%s, %s): %w", e.CertFile, e.KeyFile, err)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse certificate %s: %w", e.CertFile, err)
+	}
+	var chain []*x509.Certificate
+	for _, der := range pair.Certificate[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return fmt.Errorf("failed to parse chain of %s: %w", e.CertFile, err)
+		}
+		chain = append(chain, cert)
+	}
+	for _, file := range e.Chain {
+		certs, err := loadCertificates(file)
+		if err != nil {
+			return err
+		}
+		chain = append(chain, certs...)
+	}
+
+	password, err := e.password(out)
+	if err != nil {
+		return err
+	}
+	encoder := pkcs12.Modern
+	if e.Legacy {
+		encoder = pkcs12.Legacy
+	}
+	data, err := encoder.Encode(pair.PrivateKey, leaf, chain, password)
+	if err != nil {
+		return fmt.Errorf("failed to encode PKCS#12 bundle: %w", err)
+	}
+	if err := os.Remove(out); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", out, err)
+	}
+	if err := ioutil.WriteFile(out, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", out, err)
+	}
+	outputf("Exported %s (CN %s, %d chain certificates) to %s\n", e.CertFile, leaf.Subject.CommonName, len(chain), out)
+	return nil
+}
+
+// password returns the bundle password from --password, --password-file or the terminal, where it is
+// asked for twice.
+func (e *ExportP12Cmd) password(out string) (string, error) {
+	switch {
+	case e.Password != "":
+		return e.Password, nil
+	case e.PasswordFile != "":
+		pass, err := passphraseFromFile(e.PasswordFile)()
+		if err != nil {
+			return "", err
+		}
+		if len(pass) == 0 {
+			return "", fmt.Errorf("password file %s is empty", e.PasswordFile)
+		}
+		return string(pass), nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("a password is required: use --password or --password-file")
+	}
+	fmt.Fprintf(os.Stderr, "Password for %s: ", out)
+	pass, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	fmt.Fprint(os.Stderr, "Repeat the password: ")
+	again, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	if len(pass) == 0 {
+		return "", errors.New("the password must not be empty")
+	}
+	if !bytes.Equal(pass, again) {
+		return "", errors.New("the passwords do not match")
+	}
+	return string(pass), nil
+}
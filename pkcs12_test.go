@@ -0,0 +1,70 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// exportP12 runs export-p12 for a certificate and key and returns the bundle file.
+func exportP12(t *testing.T, certFile, keyFile, out, password string, configure func(*ExportP12Cmd)) string {
+	t.Helper()
+	cmd := &ExportP12Cmd{CertFile: certFile, KeyFile: keyFile, Out: out, Password: password}
+	if configure != nil {
+		configure(cmd)
+	}
+	captureOutput(t, func() {
+		if err := cmd.Run(); err != nil {
+			t.Fatalf("export-p12 failed: %v", err)
+		}
+	})
+	return out
+}
+
+func TestPKCS12Identities(t *testing.T) {
+	pki := newTestPKI(t)
+	serverP12 := exportP12(t, pki.ServerCertFile, pki.ServerKeyFile, filepath.Join(pki.Dir, "server.pfx"), "server pw", nil)
+	clientP12 := exportP12(t, pki.ClientCertFile, pki.ClientKeyFile, filepath.Join(pki.Dir, "client.p12"), "client pw", func(e *ExportP12Cmd) {
+		e.Legacy = true
+	})
+	setKeyPassphraseFlags(serverP12, "server pw", "")
+	setKeyPassphraseFlags(clientP12, "client pw", "")
+
+	p12PKI := *pki
+	p12PKI.ServerCertFile, p12PKI.ServerKeyFile = serverP12, serverP12
+	_, baseURL := startTestServer(t, &p12PKI, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, clientP12, clientP12)
+	if err != nil {
+		t.Fatalf("Expected the client bundle to load, got %v", err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the request to succeed with PKCS#12 identities, got %d (%v)", status, err)
+	}
+}
+
+func TestExportP12Chain(t *testing.T) {
+	pki := newTestPKI(t)
+	out := exportP12(t, pki.ClientCertFile, pki.ClientKeyFile, filepath.Join(pki.Dir, "client.p12"), "pw", func(e *ExportP12Cmd) {
+		e.Chain = []string{pki.ServerCertFile} // Stands in for a CA certificate
+	})
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, leaf, chain, err := pkcs12.DecodeChain(data, "pw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.Subject.CommonName != pki.ClientCN || len(chain) != 1 || chain[0].Subject.CommonName != "localhost" {
+		t.Errorf("Expected the client certificate with the server certificate as its chain, got %s and %d chain certificates", leaf.Subject.CommonName, len(chain))
+	}
+
+	cmd := &ExportP12Cmd{CertFile: pki.ClientCertFile, KeyFile: pki.ClientKeyFile, Out: out, Password: "pw"}
+	if err := cmd.Run(); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an already exists error, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package mtls
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// --- tls.Config Builders ---
+
+// Verification is the outcome of verifying one client certificate, see ServerConfig.OnVerify.
+type Verification struct {
+	RawCerts   [][]byte // The certificates the client presented, leaf first
+	RemoteAddr string   // Empty if the connection's address is not known
+	Resumed    bool     // The certificate is from the session a resumed handshake continued
+	// Chain is the chain the TLS stack verified against ClientCAs, leaf first and root last, through the
+	// intermediates the client presented. nil without ClientCAs.
+	Chain []*x509.Certificate
+	Err   error // nil if the client was accepted
+}
+
+// ServerConfig builds the tls.Config of a server that requires client certificates and accepts those
+// its Verifier accepts.
+type ServerConfig struct {
+	// Verifier decides on every client certificate, e.g. a FingerprintVerifier.
+	Verifier Verifier
+	// ClientCAs, if set, makes the TLS stack first verify the client certificate chain against these CAs,
+	// using the intermediates the client presents after its certificate. A chain that doesn't verify fails
+	// the handshake before Verifier and OnVerify run.
+	ClientCAs *x509.CertPool
+	// OnVerify, if not nil, is called with the outcome of every verification.
+	OnVerify func(Verification)
+}
+
+// TLSConfig returns the server tls.Config. It has no server certificate: set Certificates or
+// GetCertificate on the result.
+//
+// Unless ClientCAs is set, verification happens *only* in VerifyPeerCertificate. A resumed session
+// skips VerifyPeerCertificate, so the certificate it was established with is verified again in
+// VerifyConnection: the client may have been removed from the known clients since.
+func (c ServerConfig) TLSConfig() (*tls.Config, error) {
+	if c.Verifier == nil {
+		return nil, errors.New("server config has no verifier")
+	}
+	verify := func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate, remoteAddr string, resumed bool) error {
+		err := c.verify(rawCerts)
+		if c.OnVerify != nil {
+			v := Verification{RawCerts: rawCerts, RemoteAddr: remoteAddr, Resumed: resumed, Err: err}
+			if len(verifiedChains) > 0 {
+				v.Chain = verifiedChains[0]
+			}
+			c.OnVerify(v)
+		}
+		return err
+	}
+	verifyResumed := func(cs tls.ConnectionState, remoteAddr string) error {
+		if !cs.DidResume {
+			return nil
+		}
+		rawCerts := make([][]byte, len(cs.PeerCertificates))
+		for i, cert := range cs.PeerCertificates {
+			rawCerts[i] = cert.Raw
+		}
+		return verify(rawCerts, cs.VerifiedChains, remoteAddr, true)
+	}
+
+	cfg := &tls.Config{
+		ClientAuth: tls.RequireAnyClientCert, // Require a cert, but don't verify against CAs
+		MinVersion: tls.VersionTLS12,
+		// NOTE: Go does not expose the signature schemes accepted in the client's CertificateVerify
+		// (there is no settable SupportedSignatureAlgorithms). The stdlib already refuses SHA-1 and
+		// PKCS#1 v1.5 schemes there under TLS 1.3; restricting the certificate's own signature
+		// algorithm is up to the Verifier.
+		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return verify(rawCerts, verifiedChains, "", false)
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return verifyResumed(cs, "")
+		},
+	}
+	if c.ClientCAs != nil {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.ClientCAs = c.ClientCAs
+	}
+
+	// Per-connection config so verification knows which remote address it is deciding on.
+	WrapConfigForClient(cfg, func(hello *tls.ClientHelloInfo, connCfg *tls.Config) {
+		remoteAddr := ""
+		if hello.Conn != nil {
+			remoteAddr = hello.Conn.RemoteAddr().String()
+		}
+		connCfg.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return verify(rawCerts, verifiedChains, remoteAddr, false)
+		}
+		connCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyResumed(cs, remoteAddr)
+		}
+	})
+	return cfg, nil
+}
+
+// WrapConfigForClient chains configure onto cfg.GetConfigForClient. Each handshake gets its own clone of
+// the config the previous GetConfigForClient returned (of cfg if there is none, or it returned nil), with
+// GetConfigForClient cleared, and configure adjusts that clone. A wrapper sees the config of the ones
+// installed before it, so one that picks a whole config, e.g. by SNI, must be installed first for the
+// others to apply to its handshakes.
+func WrapConfigForClient(cfg *tls.Config, configure func(hello *tls.ClientHelloInfo, connCfg *tls.Config)) {
+	next := cfg.GetConfigForClient
+	cfg.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		connCfg := cfg
+		if next != nil {
+			nextCfg, err := next(hello)
+			if err != nil {
+				return nil, err
+			}
+			if nextCfg != nil {
+				connCfg = nextCfg
+			}
+		}
+		connCfg = connCfg.Clone()
+		connCfg.GetConfigForClient = nil
+		configure(hello, connCfg)
+		return connCfg, nil
+	}
+}
+
+// WrapVerifyConnection adds a check after the VerifyConnection of every handshake's config (see
+// WrapConfigForClient). check is called with the ClientHello and returns the check of that handshake,
+// or nil to add none; it only runs if the VerifyConnection before it passed.
+func WrapVerifyConnection(cfg *tls.Config, check func(hello *tls.ClientHelloInfo) func(tls.ConnectionState) error) {
+	WrapConfigForClient(cfg, func(hello *tls.ClientHelloInfo, connCfg *tls.Config) {
+		after := check(hello)
+		if after == nil {
+			return
+		}
+		verify := connCfg.VerifyConnection
+		connCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(cs); err != nil {
+					return err
+				}
+			}
+			return after(cs)
+		}
+	})
+}
+
+// verify parses the leaf certificate and runs the Verifier on it.
+func (c ServerConfig) verify(rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no client certificate presented") // Should be caught by RequireAnyClientCert
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	return c.Verifier.Verify(cert)
+}
+
+// ClientConfig builds the tls.Config of a client that presents a certificate and trusts the server
+// either by its certificate or by the fingerprint of its public key.
+type ClientConfig struct {
+	// CertFile and KeyFile hold the client's certificate and private key (PEM).
+	CertFile string
+	KeyFile  string
+	// PKCS12File, if set, holds the client's certificate, chain and key instead of CertFile and KeyFile.
+	PKCS12File string
+	// KeyPassphrase returns the passphrase of KeyFile if it is encrypted (see LoadKeyPair), or the
+	// password of PKCS12File if it has one.
+	KeyPassphrase PassphraseFunc
+	// ServerCertFile is the PEM file of the trusted server certificate (or its CA). The server name is
+	// checked against it as usual.
+	ServerCertFile string
+	// ServerFingerprint, if set, trusts the server by the SHA-256 fingerprint of its public key (hex or
+	// base64 pin-sha256, see ParseKeyFingerprint) instead of ServerCertFile, so the server can re-issue
+	// its certificate with a different CN, SANs or validity period as long as it keeps the key.
+	ServerFingerprint string
+}
+
+// TLSConfig returns the client tls.Config.
+func (c ClientConfig) TLSConfig() (*tls.Config, error) {
+	var pin []byte
+	if c.ServerFingerprint != "" {
+		var err error
+		if pin, err = ParseKeyFingerprint(c.ServerFingerprint); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := c.loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert}, // Client's identity
+		MinVersion:   tls.VersionTLS12,
+	}
+	if pin != nil {
+		// Chain and hostname verification are replaced by the pin check; without a CA there is
+		// nothing to chain to, and the point of the pin is not to depend on the names.
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyServerPin(rawCerts, pin)
+		}
+		return cfg, nil
+	}
+	if cfg.RootCAs, err = LoadCertPool(c.ServerCertFile); err != nil { // Explicitly trust only certs in this pool
+		return nil, fmt.Errorf("failed to load server certificate %s for client trust: %w", c.ServerCertFile, err)
+	}
+	return cfg, nil
+}
+
+// loadCertificate loads the client's certificate and key from PKCS12File, or else CertFile and KeyFile.
+func (c ClientConfig) loadCertificate() (tls.Certificate, error) {
+	if c.PKCS12File != "" {
+		cert, err := LoadPKCS12(c.PKCS12File, c.KeyPassphrase)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to load client PKCS#12 bundle %s: %w", c.PKCS12File, err)
+		}
+		return cert, nil
+	}
+	cert, err := LoadKeyPair(c.CertFile, c.KeyFile, c.KeyPassphrase)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load client key pair (%s, %s): %w", c.CertFile, c.KeyFile, err)
+	}
+	return cert, nil
+}
+
+// verifyServerPin checks that the server's leaf certificate has the pinned public key.
+func verifyServerPin(rawCerts [][]byte, pin []byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	leaf, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return fmt.Errorf("failed to parse server certificate: %w", err)
+	}
+	if got := sha256.Sum256(leaf.RawSubjectPublicKeyInfo); !bytes.Equal(got[:], pin) {
+		return fmt.Errorf("server public key fingerprint mismatch for CN '%s': got %s", leaf.Subject.CommonName, SPKIPin(leaf))
+	}
+	return nil
+}
+
+// LoadCertPool loads the certificates of a PEM file into a cert pool.
+func LoadCertPool(certFile string) (*x509.CertPool, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate PEM %s: %w", certFile, err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(certPEM) {
+		return nil, fmt.Errorf("failed to append certificate from %s to pool", certFile)
+	}
+	return certPool, nil
+}
@@ -0,0 +1,170 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a certificate and its key PEM to dir and returns the file names.
+func writeTestCert(t *testing.T, dir, name string, cert *x509.Certificate, keyPEM []byte) (certFile, keyFile string) {
+	t.Helper()
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// handshake runs one TLS handshake between the two configs over an in-memory pipe and returns the client's error.
+func handshake(serverCfg, clientCfg *tls.Config) error {
+	serverConn, clientConn := net.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn := tls.Server(serverConn, serverCfg)
+		if conn.Handshake() == nil {
+			conn.Write([]byte{1}) // Tell the client it was accepted
+		}
+		serverConn.Close() // Not conn.Close: its close_notify would block on the synchronous pipe
+	}()
+	conn := tls.Client(clientConn, clientCfg)
+	err := conn.Handshake()
+	if err == nil {
+		// Under TLS 1.3 the server verifies the client certificate after the client's handshake completes.
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = conn.Read(make([]byte, 1))
+	}
+	clientConn.Close()
+	wg.Wait()
+	return err
+}
+
+func TestServerAndClientConfig(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	serverCert, serverKey := newTestCert(t, "localhost", now.Add(-time.Hour), now.Add(time.Hour))
+	knownCert, knownKey := newTestCert(t, "known", now.Add(-time.Hour), now.Add(time.Hour))
+	strangerCert, strangerKey := newTestCert(t, "stranger", now.Add(-time.Hour), now.Add(time.Hour))
+	serverCertFile, serverKeyFile := writeTestCert(t, dir, "server", serverCert, serverKey)
+	knownCertFile, knownKeyFile := writeTestCert(t, dir, "known", knownCert, knownKey)
+	strangerCertFile, strangerKeyFile := writeTestCert(t, dir, "stranger", strangerCert, strangerKey)
+
+	var mu sync.Mutex
+	var verifications []Verification
+	serverCfg, err := ServerConfig{
+		Verifier: VerifyAll(ValidityVerifier, FingerprintVerifier{Store: newTestStore(t, "known "+CertFingerprint(knownCert)+"\n")}),
+		OnVerify: func(v Verification) {
+			mu.Lock()
+			defer mu.Unlock()
+			verifications = append(verifications, v)
+		},
+	}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(serverCertFile, serverKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCfg.Certificates = []tls.Certificate{pair}
+
+	tests := []struct {
+		name   string
+		client ClientConfig
+		ok     bool
+	}{
+		{"known client trusting the server certificate", ClientConfig{CertFile: knownCertFile, KeyFile: knownKeyFile, ServerCertFile: serverCertFile}, true},
+		{"known client pinning the server key", ClientConfig{CertFile: knownCertFile, KeyFile: knownKeyFile, ServerFingerprint: SPKIPin(serverCert)}, true},
+		{"unknown client", ClientConfig{CertFile: strangerCertFile, KeyFile: strangerKeyFile, ServerCertFile: serverCertFile}, false},
+		{"wrong server pin", ClientConfig{CertFile: knownCertFile, KeyFile: knownKeyFile, ServerFingerprint: SPKIPin(knownCert)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientCfg, err := tt.client.TLSConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientCfg.ServerName = "localhost"
+			err = handshake(serverCfg, clientCfg)
+			if tt.ok && err != nil {
+				t.Errorf("Expected the handshake to succeed, got %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("Expected the handshake to fail")
+			}
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var accepted, rejected int
+	for _, v := range verifications {
+		if v.Err == nil {
+			accepted++
+		} else if errors.Is(v.Err, ErrCNNotAuthorized) {
+			rejected++
+		}
+	}
+	if accepted != 2 || rejected != 1 {
+		t.Errorf("Expected OnVerify to see 2 accepted and 1 unauthorized client, got %d and %d of %d", accepted, rejected, len(verifications))
+	}
+}
+
+func TestServerConfigRequiresVerifier(t *testing.T) {
+	if _, err := (ServerConfig{}).TLSConfig(); err == nil {
+		t.Error("Expected a ServerConfig without a Verifier to fail")
+	}
+}
+
+func TestWrapVerifyConnection(t *testing.T) {
+	var calls []string
+	cfg := &tls.Config{VerifyConnection: func(tls.ConnectionState) error {
+		calls = append(calls, "base")
+		return nil
+	}}
+	for _, name := range []string{"first", "second"} {
+		name := name
+		WrapVerifyConnection(cfg, func(*tls.ClientHelloInfo) func(tls.ConnectionState) error {
+			return func(tls.ConnectionState) error {
+				calls = append(calls, name)
+				return nil
+			}
+		})
+	}
+	WrapVerifyConnection(cfg, func(*tls.ClientHelloInfo) func(tls.ConnectionState) error { return nil })
+
+	connCfg, err := cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if connCfg == cfg || connCfg.GetConfigForClient != nil {
+		t.Fatal("Expected a per-connection clone without GetConfigForClient")
+	}
+	if err := connCfg.VerifyConnection(tls.ConnectionState{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(calls); got != "[base first second]" {
+		t.Errorf("Expected the checks in the order they were added, got %s", got)
+	}
+
+	calls = nil
+	cfg.VerifyConnection = func(tls.ConnectionState) error { return errors.New("revoked") }
+	connCfg, _ = cfg.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err := connCfg.VerifyConnection(tls.ConnectionState{}); err == nil || len(calls) != 0 {
+		t.Errorf("Expected a failed VerifyConnection to skip the added checks, got %v and %v", err, calls)
+	}
+}
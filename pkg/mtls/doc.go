@@ -0,0 +1,23 @@
+// Package mtls is the reusable core of tls-playground: mutual TLS where clients are authorized by the
+// fingerprints of their certificates (or public keys) in a known clients file, instead of by a CA.
+//
+// A server builds its tls.Config from a ServerConfig with a FingerprintVerifier over a
+// KnownClientsStore, usually a FileStore:
+//
+//	store, err := mtls.NewFileStore("knownClients.txt", mtls.FileStoreOptions{})
+//	...
+//	cfg, err := mtls.ServerConfig{
+//		Verifier: mtls.VerifyAll(mtls.ValidityVerifier, mtls.FingerprintVerifier{Store: store}),
+//	}.TLSConfig()
+//	...
+//	cfg.Certificates = []tls.Certificate{serverCert}
+//
+// A client builds its tls.Config from a ClientConfig, trusting the server by its certificate or by
+// the fingerprint of its public key.
+//
+// The known clients file has one '<common_name> <fingerprint>[,<fingerprint>...] [<path>,...]' entry per
+// line, with fingerprints as printed by CertFingerprint, or as spki:<hash> to pin only the public key.
+// JSON and YAML files (see LoadKnownClients) can also set an expiry per entry.
+// FingerprintVerifier.Identity can identify clients by a DNS, URI or email SAN (IdentitySource) instead
+// of the CN, e.g. by their SPIFFE ID.
+package mtls
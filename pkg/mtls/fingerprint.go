@@ -0,0 +1,84 @@
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// --- Fingerprints ---
+
+// SPKIEntryPrefix marks a known clients entry that pins the client's public key rather than its certificate:
+// '<common_name> spki:<fingerprint>', with the SHA-256 of the SubjectPublicKeyInfo as hex or base64 (pin-sha256).
+// Such an entry keeps matching when the client renews its certificate with the same key.
+const SPKIEntryPrefix = "spki:"
+
+// CertFingerprint returns the SHA-256 fingerprint of a certificate in the
+// colon-separated uppercase hex format used by openssl and known clients files.
+func CertFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return ColonHex(hash[:])
+}
+
+// KeyFingerprint returns the SHA-256 fingerprint of the certificate's SubjectPublicKeyInfo in the same
+// format as CertFingerprint. It stays the same when a certificate is re-issued for the same key.
+func KeyFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return ColonHex(hash[:])
+}
+
+// ColonHex formats bytes as colon-separated uppercase hex, e.g. "AB:CD:EF".
+func ColonHex(b []byte) string {
+	var buf strings.Builder
+	for i, c := range b {
+		fmt.Fprintf(&buf, "%02X", c)
+		if i < len(b)-1 {
+			buf.WriteByte(':')
+		}
+	}
+	return buf.String()
+}
+
+// SPKIPin returns the base64 SHA-256 hash of the certificate's SubjectPublicKeyInfo,
+// the pin format used by HPKP (pin-sha256) and most pinning configurations.
+func SPKIPin(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// ParseKeyFingerprint parses a SHA-256 public key fingerprint given either as hex (optionally
+// colon-separated, like KeyFingerprint) or as a base64 pin-sha256 value (like SPKIPin).
+func ParseKeyFingerprint(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if hexDigits := strings.ReplaceAll(s, ":", ""); len(hexDigits) == 2*sha256.Size {
+		if fingerprint, err := hex.DecodeString(hexDigits); err == nil {
+			return fingerprint, nil
+		}
+	}
+	fingerprint, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(fingerprint) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 fingerprint %q: want 64 hex digits or a base64 pin-sha256", s)
+	}
+	return fingerprint, nil
+}
+
+// NormalizeFingerprint returns the form in which a known clients fingerprint is stored and compared:
+// uppercase colon-separated hex, prefixed with "SPKI:" for public key entries (see KeyEntry).
+func NormalizeFingerprint(raw string) (string, error) {
+	if len(raw) < len(SPKIEntryPrefix) || !strings.EqualFold(raw[:len(SPKIEntryPrefix)], SPKIEntryPrefix) {
+		return strings.ToUpper(raw), nil
+	}
+	hash, err := ParseKeyFingerprint(raw[len(SPKIEntryPrefix):]) // Not uppercased first: base64 is case-sensitive
+	if err != nil {
+		return "", err
+	}
+	return KeyEntry(ColonHex(hash)), nil
+}
+
+// KeyEntry returns the stored form of a public key entry for the given key fingerprint (see KeyFingerprint).
+func KeyEntry(keyFingerprint string) string {
+	return strings.ToUpper(SPKIEntryPrefix) + keyFingerprint
+}
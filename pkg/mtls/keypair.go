@@ -0,0 +1,145 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// ErrKeyPassphraseRequired is returned for an encrypted private key when there is no way to get its passphrase.
+var ErrKeyPassphraseRequired = errors.New("private key is encrypted and no passphrase was given")
+
+// PassphraseFunc returns the passphrase of an encrypted private key. It is only called for encrypted keys.
+type PassphraseFunc func() ([]byte, error)
+
+// LoadKeyPair is tls.LoadX509KeyPair for private keys that may be encrypted: PKCS#8 "ENCRYPTED PRIVATE KEY"
+// blocks (openssl pkcs8 -topk8 -v2 aes-256-cbc) and legacy OpenSSL PEM encryption ("Proc-Type: 4,ENCRYPTED").
+// An encrypted key is decrypted in memory with the passphrase from passphrase, which may be nil for keys
+// that are known to be unencrypted.
+func LoadKeyPair(certFile, keyFile string, passphrase PassphraseFunc) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if keyPEM, err = DecryptKeyPEM(keyPEM, passphrase); err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// DecryptKeyPEM returns keyPEM with its private key decrypted and PKCS#8 encoded, or keyPEM itself if
+// the key isn't encrypted.
+func DecryptKeyPEM(keyPEM []byte, passphrase PassphraseFunc) ([]byte, error) {
+	block := findKeyBlock(keyPEM)
+	if block == nil || !isEncryptedKeyBlock(block) {
+		return keyPEM, nil // tls.X509KeyPair reports what's wrong with it
+	}
+	if passphrase == nil {
+		return nil, ErrKeyPassphraseRequired
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if len(pass) == 0 {
+		return nil, ErrKeyPassphraseRequired
+	}
+
+	var key interface{}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		if key, _, err = pkcs8.ParsePrivateKey(block.Bytes, pass); err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key (wrong passphrase?): %w", err)
+		}
+	} else {
+		// Legacy PEM encryption is deprecated as insecure, but older tools still write it.
+		der, err := x509.DecryptPEMBlock(block, pass)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key (wrong passphrase?): %w", err)
+		}
+		if key, err = parseLegacyKey(block.Type, der); err != nil {
+			return nil, fmt.Errorf("failed to parse decrypted private key (wrong passphrase?): %w", err)
+		}
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode decrypted private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// findKeyBlock returns the first PEM block that holds a private key.
+func findKeyBlock(data []byte) *pem.Block {
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			return nil
+		}
+		if block.Type == "PRIVATE KEY" || block.Type == "ENCRYPTED PRIVATE KEY" ||
+			block.Type == "RSA PRIVATE KEY" || block.Type == "EC PRIVATE KEY" {
+			return block
+		}
+	}
+}
+
+// isEncryptedKeyBlock reports whether a private key block is encrypted.
+func isEncryptedKeyBlock(block *pem.Block) bool {
+	return block.Type == "ENCRYPTED PRIVATE KEY" || x509.IsEncryptedPEMBlock(block)
+}
+
+// parseLegacyKey parses the decrypted DER of a legacy encrypted "RSA PRIVATE KEY" or "EC PRIVATE KEY" block.
+func parseLegacyKey(blockType string, der []byte) (interface{}, error) {
+	switch blockType {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(der)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(der)
+	default:
+		return x509.ParsePKCS8PrivateKey(der)
+	}
+}
+
+// LoadPKCS12 loads a certificate, its chain and its private key from a PKCS#12 (.p12/.pfx) bundle. A bundle
+// without a password loads without calling passphrase; otherwise passphrase returns the password.
+func LoadPKCS12(file string, passphrase PassphraseFunc) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, "")
+	if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+		if passphrase == nil {
+			return tls.Certificate{}, ErrKeyPassphraseRequired
+		}
+		var pass []byte
+		if pass, err = passphrase(); err != nil {
+			return tls.Certificate{}, err
+		}
+		key, leaf, chain, err = pkcs12.DecodeChain(data, string(pass))
+	}
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decode PKCS#12 bundle: %w", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{leaf.Raw}, PrivateKey: key, Leaf: leaf}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
+
+// IsPKCS12File reports whether a file name has a PKCS#12 extension (.p12 or .pfx).
+func IsPKCS12File(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".p12" || ext == ".pfx"
+}
@@ -0,0 +1,119 @@
+package mtls
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/youmark/pkcs8"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestLoadKeyPairEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	cert, keyPEM := newTestCert(t, "client", now.Add(-time.Hour), now.Add(time.Hour))
+	certFile, plainKeyFile := writeTestCert(t, dir, "client", cert, keyPEM)
+	block, _ := pem.Decode(keyPEM)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs8DER, err := pkcs8.MarshalPrivateKey(key, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Deprecated as insecure, but older tools still write it.
+	legacyBlock, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", block.Bytes, []byte("secret"), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFiles := map[string][]byte{
+		"pkcs8.key":  pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: pkcs8DER}),
+		"legacy.key": pem.EncodeToMemory(legacyBlock),
+	}
+
+	passphrase := func(pass string) PassphraseFunc {
+		return func() ([]byte, error) { return []byte(pass), nil }
+	}
+	for name, content := range keyFiles {
+		t.Run(name, func(t *testing.T) {
+			keyFile := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(keyFile, content, 0600); err != nil {
+				t.Fatal(err)
+			}
+			pair, err := LoadKeyPair(certFile, keyFile, passphrase("secret"))
+			if err != nil {
+				t.Fatalf("Expected the key to decrypt, got %v", err)
+			}
+			if !key.Equal(pair.PrivateKey) {
+				t.Error("Expected the decrypted key to be the original")
+			}
+			if _, err := LoadKeyPair(certFile, keyFile, passphrase("wrong")); err == nil {
+				t.Error("Expected a wrong passphrase to fail")
+			}
+			if _, err := LoadKeyPair(certFile, keyFile, nil); !errors.Is(err, ErrKeyPassphraseRequired) {
+				t.Errorf("Expected ErrKeyPassphraseRequired without a passphrase, got %v", err)
+			}
+		})
+	}
+
+	t.Run("plain key", func(t *testing.T) {
+		asked := false
+		_, err := LoadKeyPair(certFile, plainKeyFile, func() ([]byte, error) {
+			asked = true
+			return nil, errors.New("no terminal")
+		})
+		if err != nil || asked {
+			t.Errorf("Expected an unencrypted key to load without asking for a passphrase, got %v (asked: %v)", err, asked)
+		}
+	})
+}
+
+func TestLoadPKCS12(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	cert, keyPEM := newTestCert(t, "client", now.Add(-time.Hour), now.Add(time.Hour))
+	block, _ := pem.Decode(keyPEM)
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name string, encoder *pkcs12.Encoder, password string) string {
+		data, err := encoder.Encode(key, cert, nil, password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	protected := write("protected.p12", pkcs12.Modern, "secret")
+	pair, err := LoadPKCS12(protected, func() ([]byte, error) { return []byte("secret"), nil })
+	if err != nil {
+		t.Fatalf("Expected the bundle to load, got %v", err)
+	}
+	if !key.Equal(pair.PrivateKey) || pair.Leaf.Subject.CommonName != "client" {
+		t.Error("Expected the bundle's key and certificate")
+	}
+	if _, err := LoadPKCS12(protected, func() ([]byte, error) { return []byte("wrong"), nil }); err == nil {
+		t.Error("Expected a wrong password to fail")
+	}
+	if _, err := LoadPKCS12(protected, nil); !errors.Is(err, ErrKeyPassphraseRequired) {
+		t.Errorf("Expected ErrKeyPassphraseRequired without a password, got %v", err)
+	}
+
+	passwordless := write("open.pfx", pkcs12.Passwordless, "")
+	if _, err := LoadPKCS12(passwordless, nil); err != nil {
+		t.Errorf("Expected a bundle without a password to load without asking, got %v", err)
+	}
+}
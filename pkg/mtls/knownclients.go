@@ -0,0 +1,659 @@
+package mtls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// --- Known Clients Store ---
+
+// KnownClientsStore is the source of authorized clients used by client certificate verification.
+// FileStore is the file backend; other backends, such as a database or an HTTP service, can be plugged
+// into FingerprintVerifier without touching the verification logic.
+//
+// Lookup runs on every handshake, so backends should answer it from memory and refresh
+// their view in Reload. Implementations must be safe for concurrent use.
+type KnownClientsStore interface {
+	// Lookup returns the entries for the CN, with fingerprints in the form stored by
+	// NormalizeFingerprint, and whether the CN is known at all.
+	Lookup(cn string) ([]KnownClient, bool)
+	// List returns every entry, sorted by CN.
+	List() []KnownClient
+	// Add authorizes a fingerprint (or spki: entry) for the CN.
+	Add(cn, fingerprint string) error
+	// Remove revokes a fingerprint for the CN, or returns ErrKnownClientNotFound if it isn't authorized.
+	Remove(cn, fingerprint string) error
+	// Reload refreshes the entries from the backend. On error the previous entries stay in use.
+	Reload() error
+}
+
+// KnownClient is one known clients entry. The text format has the CN, fingerprint, allowed paths, rate limit and
+// validity window; JSON and YAML files can also set notes (see knownClientsDocument).
+type KnownClient struct {
+	CN          string `json:"cn" yaml:"cn"`
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	// AllowedPaths, if not empty, limits the request paths the client may use. A path ending in "/" allows everything below it.
+	AllowedPaths []string `json:"allowed_paths,omitempty" yaml:"allowed_paths,omitempty"`
+	// RateLimit, if > 0, is the client's request budget in requests per second, in place of the server's default.
+	RateLimit float64 `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	// ValidFrom, if set, is when the entry starts authorizing the client, e.g. for a temporary access grant.
+	ValidFrom time.Time `json:"valid_from,omitempty" yaml:"valid_from,omitempty"`
+	// Expires, if set, is when the entry stops authorizing the client, regardless of the certificate's own validity.
+	// Text entries set it with valid-until=.
+	Expires time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+	// Notes is free text for operators, e.g. who owns the client.
+	Notes string `json:"notes,omitempty" yaml:"notes,omitempty"`
+}
+
+// Expired reports whether the entry has an expiry that has passed.
+func (c KnownClient) Expired(now time.Time) bool {
+	return !c.Expires.IsZero() && now.After(c.Expires)
+}
+
+// NotYetValid reports whether the entry has a start that is still ahead.
+func (c KnownClient) NotYetValid(now time.Time) bool {
+	return !c.ValidFrom.IsZero() && now.Before(c.ValidFrom)
+}
+
+// Active reports whether now falls within the entry's validity window.
+func (c KnownClient) Active(now time.Time) bool {
+	return !c.NotYetValid(now) && !c.Expired(now)
+}
+
+// AllowsPath reports whether the entry permits a request for the path.
+func (c KnownClient) AllowsPath(path string) bool {
+	if len(c.AllowedPaths) == 0 {
+		return true
+	}
+	for _, allowed := range c.AllowedPaths {
+		if path == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(path, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FileStore holds the authorized clients loaded from a known clients file, in the text, JSON or YAML
+// format (see LoadKnownClients). It is safe for concurrent use, so it can be reloaded while the
+// server is handling handshakes.
+type FileStore struct {
+	path string
+	opts FileStoreOptions
+
+	mu      sync.RWMutex
+	clients map[string][]KnownClient // CN -> accepted entries
+}
+
+// FileStoreOptions control how the known clients file is parsed and looked up.
+type FileStoreOptions struct {
+	// Warnf receives warnings about skipped entries and stale files. nil logs them with slog.Default.
+	Warnf func(format string, args ...interface{})
+	// Strict makes invalid lines and files without any entries fail the load instead of logging a warning.
+	Strict bool
+	// CaseInsensitiveCN lowercases CNs when loading and before lookup, so "My_Client" matches "my_client".
+	CaseInsensitiveCN bool
+	// MaxAge warns (or fails the load in strict mode) if the file was last modified longer ago than this.
+	// It catches config pushes that silently stopped updating the file. 0 disables the check.
+	MaxAge time.Duration
+	// MaxEntries fails the load if the file has more '<common_name> <fingerprint>' entries than this,
+	// catching a runaway generated file. 0 means no limit.
+	MaxEntries int
+}
+
+// warnf reports a warning through Warnf.
+func (o FileStoreOptions) warnf(format string, args ...interface{}) {
+	if o.Warnf != nil {
+		o.Warnf(format, args...)
+		return
+	}
+	slog.Default().Warn(fmt.Sprintf(format, args...))
+}
+
+// normalizeCN returns the CN as stored in the known clients map.
+func (o FileStoreOptions) normalizeCN(cn string) string {
+	if o.CaseInsensitiveCN {
+		return strings.ToLower(cn)
+	}
+	return cn
+}
+
+// NewFileStore creates a store and performs the initial load of the file.
+func NewFileStore(path string, opts FileStoreOptions) (*FileStore, error) {
+	store := &FileStore{path: path, opts: opts}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload re-reads the known clients file and atomically replaces the current entries.
+// On error the previously loaded entries are kept.
+func (s *FileStore) Reload() error {
+	clients, err := LoadKnownClients(s.path, s.opts)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.clients = clients
+	s.mu.Unlock()
+	return nil
+}
+
+// Lookup returns the entries for the given CN.
+// The CN is normalized the same way as when the file was loaded.
+func (s *FileStore) Lookup(cn string) ([]KnownClient, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries, ok := s.clients[s.opts.normalizeCN(cn)]
+	return entries, ok
+}
+
+// List returns the loaded entries, sorted by CN and then in file order.
+func (s *FileStore) List() []KnownClient {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cns := make([]string, 0, len(s.clients))
+	for cn := range s.clients {
+		cns = append(cns, cn)
+	}
+	sort.Strings(cns)
+	var entries []KnownClient
+	for _, cn := range cns {
+		entries = append(entries, s.clients[cn]...)
+	}
+	return entries
+}
+
+// Add appends an entry to the file and reloads it. Only text files can be edited.
+func (s *FileStore) Add(cn, fingerprint string) error {
+	if err := s.checkEditable(); err != nil {
+		return err
+	}
+	if _, err := NormalizeFingerprint(fingerprint); err != nil {
+		return err
+	}
+	if err := AppendKnownClient(s.path, cn, fingerprint); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// Remove deletes matching entries from the file and reloads it. Only text files can be edited.
+// The CN is normalized the same way as Lookup does, so with CaseInsensitiveCN any spelling matches.
+func (s *FileStore) Remove(cn, fingerprint string) error {
+	if err := s.checkEditable(); err != nil {
+		return err
+	}
+	if err := removeKnownClient(s.path, cn, fingerprint, s.opts); err != nil {
+		return err
+	}
+	return s.Reload()
+}
+
+// ErrKnownClientNotFound is returned when removing an entry that the known clients file doesn't have.
+var ErrKnownClientNotFound = errors.New("no matching known clients entry")
+
+// ErrInvalidEntry is returned when adding an entry whose CN or fingerprint can't be written as a text line.
+var ErrInvalidEntry = errors.New("invalid known clients entry")
+
+// ErrKnownClientsReadOnly is returned when editing a known clients file whose format can't be edited in place.
+var ErrKnownClientsReadOnly = errors.New("only text files can be edited")
+
+// checkEditable refuses to edit JSON and YAML files, which would lose their formatting and comments.
+func (s *FileStore) checkEditable() error {
+	content, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read known clients file %s: %w", s.path, err)
+	}
+	if format := knownClientsFormat(s.path, content); format != knownClientsFormatText {
+		return fmt.Errorf("known clients file %s is %s: %w", s.path, format, ErrKnownClientsReadOnly)
+	}
+	return nil
+}
+
+// Known clients file formats, see knownClientsFormat.
+const (
+	knownClientsFormatText = "text" // '<common_name> <fingerprint>' lines
+	knownClientsFormatJSON = "json"
+	knownClientsFormatYAML = "yaml"
+)
+
+// knownClientsDocument is the JSON and YAML form of the known clients file:
+//
+//	clients:
+//	  - cn: my_secure_client
+//	    fingerprint: AB:CD:...            # or spki:<hash>
+//	    allowed_paths: [/hello, /pop/]    # optional
+//	    valid_from: 2025-06-01T00:00:00Z  # optional
+//	    expires: 2025-06-30T00:00:00Z     # optional
+//	    notes: build agents               # optional
+type knownClientsDocument struct {
+	Clients []KnownClient `json:"clients" yaml:"clients"`
+}
+
+// knownClientsFormat detects the format of a known clients file: by extension (.json, .yaml, .yml),
+// else JSON if the content starts with '{', else text.
+func knownClientsFormat(filePath string, content []byte) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".json":
+		return knownClientsFormatJSON
+	case ".yaml", ".yml":
+		return knownClientsFormatYAML
+	}
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		return knownClientsFormatJSON
+	}
+	return knownClientsFormatText
+}
+
+// LoadKnownClients reads the known clients file and parses it in the detected format.
+// A CN may appear in several entries to accept more than one certificate, e.g. during rotation.
+// Entries pin either the whole certificate or, with the spki: prefix, only its public key.
+// Invalid entries are skipped with a warning, or fail the load in strict mode.
+func LoadKnownClients(filePath string, opts FileStoreOptions) (map[string][]KnownClient, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open known clients file %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	if opts.MaxAge > 0 {
+		if err := checkKnownClientsAge(file, opts); err != nil {
+			return nil, err
+		}
+	}
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known clients file %s: %w", filePath, err)
+	}
+
+	loader := &knownClientsLoader{path: filePath, opts: opts, clients: make(map[string][]KnownClient)}
+	switch knownClientsFormat(filePath, content) {
+	case knownClientsFormatJSON:
+		err = loader.parseJSON(content)
+	case knownClientsFormatYAML:
+		err = loader.parseYAML(content)
+	default:
+		err = loader.parseText(content)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(loader.clients) == 0 {
+		if opts.Strict {
+			return nil, fmt.Errorf("no valid client entries found in %s", filePath)
+		}
+		opts.warnf("No valid client entries found in %s", filePath)
+	}
+
+	return loader.clients, nil
+}
+
+// knownClientsLoader validates and collects entries, whatever format they come from.
+type knownClientsLoader struct {
+	path    string
+	opts    FileStoreOptions
+	clients map[string][]KnownClient
+	entries int
+}
+
+// invalid reports an invalid entry: an error in strict mode, otherwise a warning and the entry is skipped.
+func (l *knownClientsLoader) invalid(where, reason string) error {
+	if l.opts.Strict {
+		return fmt.Errorf("invalid %s in %s: %s", where, l.path, reason)
+	}
+	l.opts.warnf("Skipping invalid %s in %s: %s", where, l.path, reason)
+	return nil
+}
+
+// add normalizes and validates an entry, then adds it.
+func (l *knownClientsLoader) add(where string, entry KnownClient) error {
+	entry.CN = l.opts.normalizeCN(strings.TrimSpace(entry.CN))
+	fingerprint, err := NormalizeFingerprint(strings.TrimSpace(entry.Fingerprint))
+	if err != nil {
+		return l.invalid(where, err.Error())
+	}
+	entry.Fingerprint = fingerprint
+	if entry.CN == "" || entry.Fingerprint == "" {
+		return l.invalid(where, "empty common name or fingerprint")
+	}
+	if entry.RateLimit < 0 {
+		return l.invalid(where, "negative rate limit")
+	}
+	if !entry.ValidFrom.IsZero() && !entry.Expires.IsZero() && !entry.ValidFrom.Before(entry.Expires) {
+		return l.invalid(where, "valid from must be before the expiry")
+	}
+	l.entries++
+	if l.opts.MaxEntries > 0 && l.entries > l.opts.MaxEntries {
+		return fmt.Errorf("known clients file %s has more than the maximum of %d entries (exceeded at %s)", l.path, l.opts.MaxEntries, where)
+	}
+	l.clients[entry.CN] = append(l.clients[entry.CN], entry)
+	return nil
+}
+
+// Prefixes of the optional key=value fields of a text entry, see parseTextOptions.
+const (
+	rateOptionPrefix       = "rate="
+	validFromOptionPrefix  = "valid-from="
+	validUntilOptionPrefix = "valid-until="
+)
+
+// textOptionPrefixes are the prefixes of every key=value field of a text entry.
+var textOptionPrefixes = []string{rateOptionPrefix, validFromOptionPrefix, validUntilOptionPrefix}
+
+// splitTextEntry splits a '<common_name> <fingerprint>[,<fingerprint>...] [<path>[,<path>...]] [<key>=<value>...]'
+// line into the CN, the fingerprints and the optional fields. The fields are separated by whitespace: the first
+// is the CN and the second the fingerprint list, which continues into the next field only after a trailing comma.
+// Everything after it is left to parseTextOptions, so a stray field is an error there rather than part of a
+// fingerprint or path.
+func splitTextEntry(line string) (cn, fingerprints, options string, ok bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return "", "", "", false
+	}
+	cn, fingerprints, rest := fields[0], fields[1], fields[2:]
+	for strings.HasSuffix(fingerprints, ",") && len(rest) > 0 { // 'AA:BB, CC:DD'
+		fingerprints, rest = fingerprints+rest[0], rest[1:]
+	}
+	return cn, fingerprints, strings.Join(rest, " "), true
+}
+
+// parseTextOptions parses the optional fields of a text entry into an entry without CN and fingerprint:
+// comma-separated allowed paths, then in any order 'rate=<n>' with the client's rate limit in requests per
+// second and 'valid-from=<time>' and 'valid-until=<time>' bounding when the entry authorizes the client.
+func parseTextOptions(options string) (KnownClient, error) {
+	var entry KnownClient
+	end := len(options)
+	for _, prefix := range textOptionPrefixes {
+		if i := strings.Index(" "+options, " "+prefix); i >= 0 && i < end {
+			end = i
+		}
+	}
+	pathList := strings.TrimSpace(options[:end])
+	for _, field := range strings.Fields(options[end:]) {
+		switch {
+		case strings.HasPrefix(field, rateOptionPrefix):
+			value := field[len(rateOptionPrefix):]
+			rateLimit, err := strconv.ParseFloat(value, 64)
+			if err != nil || !(rateLimit > 0) || math.IsInf(rateLimit, 0) {
+				return KnownClient{}, fmt.Errorf("rate limit %q must be a positive number of requests per second", value)
+			}
+			entry.RateLimit = rateLimit
+		case strings.HasPrefix(field, validFromOptionPrefix):
+			validFrom, err := parseEntryTime(field[len(validFromOptionPrefix):])
+			if err != nil {
+				return KnownClient{}, err
+			}
+			entry.ValidFrom = validFrom
+		case strings.HasPrefix(field, validUntilOptionPrefix):
+			validUntil, err := parseEntryTime(field[len(validUntilOptionPrefix):])
+			if err != nil {
+				return KnownClient{}, err
+			}
+			entry.Expires = validUntil
+		default:
+			return KnownClient{}, fmt.Errorf("unknown option %q (want rate=, valid-from= or valid-until=)", field)
+		}
+	}
+	paths, err := parseAllowedPaths(pathList)
+	if err != nil {
+		return KnownClient{}, err
+	}
+	entry.AllowedPaths = paths
+	return entry, nil
+}
+
+// parseEntryTime parses the time of a valid-from= or valid-until= field: RFC 3339, or a date alone for
+// midnight UTC.
+func parseEntryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("time %q must be RFC 3339 (2025-06-30T18:00:00Z) or a date (2025-06-30)", value)
+}
+
+// parseAllowedPaths parses the comma-separated allowed paths of a text entry.
+func parseAllowedPaths(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var paths []string
+	for _, path := range strings.Split(list, ",") {
+		path = strings.TrimSpace(path)
+		if !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("allowed path %q must start with /", path)
+		}
+		if strings.ContainsAny(path, " \t") {
+			return nil, fmt.Errorf("allowed path %q must not contain spaces", path)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// parseText parses '<common_name> <fingerprint>[,<fingerprint>...] [<path>[,<path>...]] [rate=<n>]
+// [valid-from=<time>] [valid-until=<time>]' lines, skipping empty lines and # comments. A CN may also appear on
+// several lines; either way every listed fingerprint is accepted. The paths, rate and validity window, if any,
+// apply to the line's fingerprints like allowed_paths, rate_limit, valid_from and expires in JSON and YAML.
+func (l *knownClientsLoader) parseText(content []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") { // Skip empty lines and comments
+			continue
+		}
+
+		where := fmt.Sprintf("line %d", lineNumber)
+		cn, fingerprints, options, ok := splitTextEntry(line)
+		if !ok {
+			if err := l.invalid(where, "format should be '<common_name> <fingerprint> [<path>,...] [rate=<n>] [valid-from=<time>] [valid-until=<time>]'"); err != nil {
+				return err
+			}
+			continue
+		}
+		template, err := parseTextOptions(options)
+		if err != nil {
+			if err := l.invalid(where, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, fingerprint := range strings.Split(fingerprints, ",") { // Several fingerprints overlap during a rotation
+			entry := template
+			entry.CN, entry.Fingerprint = cn, fingerprint
+			if err := l.add(where, entry); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading known clients file %s: %w", l.path, err)
+	}
+	return nil
+}
+
+// parseJSON parses a knownClientsDocument. Unknown fields are an error in strict mode.
+func (l *knownClientsLoader) parseJSON(content []byte) error {
+	var doc knownClientsDocument
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	if l.opts.Strict {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(&doc); err != nil {
+		return fmt.Errorf("failed to parse known clients file %s as JSON: %w", l.path, err)
+	}
+	return l.addAll(doc.Clients)
+}
+
+// parseYAML parses a knownClientsDocument. Unknown fields are an error in strict mode.
+func (l *knownClientsLoader) parseYAML(content []byte) error {
+	var doc knownClientsDocument
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(l.opts.Strict)
+	if err := decoder.Decode(&doc); err != nil && !errors.Is(err, io.EOF) { // EOF: empty file
+		return fmt.Errorf("failed to parse known clients file %s as YAML: %w", l.path, err)
+	}
+	return l.addAll(doc.Clients)
+}
+
+// addAll adds the entries of a JSON or YAML document.
+func (l *knownClientsLoader) addAll(entries []KnownClient) error {
+	for i, entry := range entries {
+		if err := l.add(fmt.Sprintf("entry %d", i+1), entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkKnownClientsAge compares the file's modification time with opts.MaxAge.
+func checkKnownClientsAge(file *os.File, opts FileStoreOptions) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat known clients file %s: %w", file.Name(), err)
+	}
+	age := time.Since(info.ModTime())
+	if age <= opts.MaxAge {
+		return nil
+	}
+	msg := fmt.Sprintf("known clients file %s was last modified %s ago (%s), older than the maximum age %s",
+		file.Name(), age.Round(time.Second), info.ModTime().Format(time.RFC3339), opts.MaxAge)
+	if opts.Strict {
+		return errors.New(msg)
+	}
+	opts.warnf("%s", msg)
+	return nil
+}
+
+// ValidateEntryCN rejects CNs that can't be written as a '<common_name> <fingerprint>' line: empty ones,
+// ones starting with '#', and ones with whitespace or control characters, which would split the line or
+// start another one. CNs come from client certificates, so they can contain anything.
+func ValidateEntryCN(cn string) error {
+	if cn == "" || strings.HasPrefix(cn, "#") || !utf8.ValidString(cn) || strings.IndexFunc(cn, isEntrySeparator) >= 0 {
+		return fmt.Errorf("%w: CN %q must be non-empty, without whitespace or control characters and not start with '#'", ErrInvalidEntry, cn)
+	}
+	return nil
+}
+
+// isEntrySeparator reports whether r can't appear inside a field of a text entry.
+func isEntrySeparator(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+// AppendKnownClient adds a '<common_name> <fingerprint>' entry to the known clients file. The CN is
+// checked with ValidateEntryCN, so the entry can't add lines of its own.
+func AppendKnownClient(filePath, cn, fingerprint string) error {
+	if err := ValidateEntryCN(cn); err != nil {
+		return err
+	}
+	if fingerprint == "" || strings.IndexFunc(fingerprint, isEntrySeparator) >= 0 {
+		return fmt.Errorf("%w: fingerprint %q must be non-empty and without whitespace or control characters", ErrInvalidEntry, fingerprint)
+	}
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open known clients file %s: %w", filePath, err)
+	}
+	defer file.Close()
+	entry := cn + " " + fingerprint + "\n"
+	// A hand-edited file may lack the final newline, which would glue the entry onto its last line
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+			return fmt.Errorf("failed to read known clients file %s: %w", filePath, err)
+		}
+		if last[0] != '\n' {
+			entry = "\n" + entry
+		}
+	}
+	if _, err := io.WriteString(file, entry); err != nil {
+		return fmt.Errorf("failed to append to known clients file %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// RemoveKnownClient deletes every entry matching the CN and fingerprint from the known clients file.
+// Fingerprints are compared in normalized form, so an spki: entry matches in hex or base64.
+// A matching fingerprint in a comma-separated list is removed from the list. Comments and unrelated lines are preserved as-is.
+// CNs are compared exactly. If nothing matches, the file is left alone and ErrKnownClientNotFound is returned.
+func RemoveKnownClient(filePath, cn, fingerprint string) error {
+	return removeKnownClient(filePath, cn, fingerprint, FileStoreOptions{})
+}
+
+// removeKnownClient is RemoveKnownClient comparing CNs as opts.normalizeCN does.
+func removeKnownClient(filePath, cn, fingerprint string, opts FileStoreOptions) error {
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read known clients file %s: %w", filePath, err)
+	}
+
+	normalized, err := NormalizeFingerprint(fingerprint)
+	if err != nil {
+		return err
+	}
+	var kept []string
+	removed := false
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		lineCN, fingerprints, options, ok := splitTextEntry(line)
+		if !ok || opts.normalizeCN(lineCN) != opts.normalizeCN(cn) {
+			kept = append(kept, line)
+			continue
+		}
+		// Drop the fingerprint from a comma-separated list, and the line once none are left
+		var others []string
+		for _, fingerprint := range strings.Split(fingerprints, ",") {
+			fingerprint = strings.TrimSpace(fingerprint)
+			if entry, err := NormalizeFingerprint(fingerprint); err == nil && entry == normalized {
+				continue
+			}
+			others = append(others, fingerprint)
+		}
+		if len(others) < len(strings.Split(fingerprints, ",")) {
+			removed = true
+		}
+		switch {
+		case len(others) == 0:
+		case len(others) == len(strings.Split(fingerprints, ",")):
+			kept = append(kept, line)
+		case options != "":
+			kept = append(kept, lineCN+" "+strings.Join(others, ",")+" "+options)
+		default:
+			kept = append(kept, lineCN+" "+strings.Join(others, ","))
+		}
+	}
+	if !removed {
+		return fmt.Errorf("%s has no entry for CN '%s' with fingerprint %s: %w", filePath, cn, normalized, ErrKnownClientNotFound)
+	}
+
+	output := strings.Join(kept, "\n")
+	if len(kept) > 0 {
+		output += "\n"
+	}
+	if err := ioutil.WriteFile(filePath, []byte(output), 0644); err != nil {
+		return fmt.Errorf("failed to write known clients file %s: %w", filePath, err)
+	}
+	return nil
+}
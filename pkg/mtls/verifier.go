@@ -0,0 +1,172 @@
+package mtls
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// --- Client Certificate Verification ---
+
+// Verifier decides whether a client certificate is accepted. Implementations must be safe for concurrent use.
+type Verifier interface {
+	Verify(cert *x509.Certificate) error
+}
+
+// VerifierFunc adapts a function to a Verifier.
+type VerifierFunc func(cert *x509.Certificate) error
+
+// Verify calls f(cert).
+func (f VerifierFunc) Verify(cert *x509.Certificate) error { return f(cert) }
+
+var (
+	// ErrCNNotAuthorized is wrapped by the error for a certificate whose CN has no known clients entries.
+	ErrCNNotAuthorized = errors.New("not authorized")
+	// ErrFingerprintMismatch is wrapped by the error for a certificate whose CN is known, but with other fingerprints.
+	ErrFingerprintMismatch = errors.New("fingerprint mismatch")
+	// ErrEntryNotYetValid is wrapped by the error for a certificate whose matching entry has a valid from still ahead.
+	ErrEntryNotYetValid = errors.New("not valid yet")
+)
+
+// IdentitySource selects the certificate field that identifies a client, i.e. what the first column of
+// the known clients file lists. Modern certificates often leave the CN empty and carry the identity in a
+// SAN, e.g. a SPIFFE ID (spiffe://trust-domain/workload) as a URI SAN. The zero value reads the CN.
+type IdentitySource string
+
+// Identity sources. A SAN source reads the certificate's first SAN of that type.
+const (
+	IdentityCN       IdentitySource = "cn"
+	IdentityDNSSAN   IdentitySource = "dns-san"
+	IdentityURISAN   IdentitySource = "uri-san"
+	IdentityEmailSAN IdentitySource = "email-san"
+)
+
+// ErrNoClientID is wrapped by the error for a certificate without the field its IdentitySource reads.
+var ErrNoClientID = errors.New("no client identity")
+
+// ParseIdentitySource parses "cn", "dns-san", "uri-san" or "email-san"; the empty string means "cn".
+func ParseIdentitySource(s string) (IdentitySource, error) {
+	switch src := IdentitySource(s); src {
+	case "":
+		return IdentityCN, nil
+	case IdentityCN, IdentityDNSSAN, IdentityURISAN, IdentityEmailSAN:
+		return src, nil
+	}
+	return "", fmt.Errorf("unknown client identity source %q (want cn, dns-san, uri-san or email-san)", s)
+}
+
+// Label names the source in messages, e.g. "CN" or "URI SAN".
+func (src IdentitySource) Label() string {
+	switch src {
+	case IdentityDNSSAN:
+		return "DNS SAN"
+	case IdentityURISAN:
+		return "URI SAN"
+	case IdentityEmailSAN:
+		return "email SAN"
+	}
+	return "CN"
+}
+
+// ClientID returns the identity of a client certificate: its CN, or its first SAN of the source's type.
+// An empty CN is returned as is (and matches no entry); a missing SAN is an error wrapping ErrNoClientID.
+func (src IdentitySource) ClientID(cert *x509.Certificate) (string, error) {
+	var sans []string
+	switch src {
+	case IdentityDNSSAN:
+		sans = cert.DNSNames
+	case IdentityURISAN:
+		for _, uri := range cert.URIs {
+			sans = append(sans, uri.String())
+		}
+	case IdentityEmailSAN:
+		sans = cert.EmailAddresses
+	default:
+		return cert.Subject.CommonName, nil
+	}
+	if len(sans) == 0 {
+		return "", fmt.Errorf("%w: client certificate (CN '%s') has no %s", ErrNoClientID, cert.Subject.CommonName, src.Label())
+	}
+	return sans[0], nil
+}
+
+// FingerprintVerifier accepts a client certificate if its identity (the CN unless Identity says
+// otherwise) is listed in Store with the certificate's fingerprint, or with its public key's (spki:
+// entries), in an entry whose validity window includes now. Certificates are not checked against any CA, so
+// self-signed client certificates work; the validity period is not checked either, see VerifyAll.
+type FingerprintVerifier struct {
+	Store    KnownClientsStore
+	Identity IdentitySource
+}
+
+// Verify implements Verifier. The store applies its own CN normalization (see FileStoreOptions).
+func (v FingerprintVerifier) Verify(cert *x509.Certificate) error {
+	id, err := v.Identity.ClientID(cert)
+	if err != nil {
+		return err
+	}
+	label := v.Identity.Label()
+	entries, ok := v.Store.Lookup(id)
+	if !ok {
+		return fmt.Errorf("client %s '%s' %w", label, id, ErrCNNotAuthorized)
+	}
+	entry, ok := MatchKnownClient(entries, cert)
+	if !ok {
+		return fmt.Errorf("client %w for %s '%s'", ErrFingerprintMismatch, label, id)
+	}
+	now := time.Now()
+	if entry.Expired(now) {
+		return fmt.Errorf("known clients entry for %s '%s' expired at %s", label, id, entry.Expires.Format(time.RFC3339))
+	}
+	if entry.NotYetValid(now) {
+		return fmt.Errorf("known clients entry for %s '%s' %w (valid from %s)", label, id, ErrEntryNotYetValid, entry.ValidFrom.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// MatchKnownClient returns the entry whose fingerprint matches the certificate or its public key.
+// A match within its validity window is preferred, so an expired entry doesn't shadow a renewed one for the
+// same key, nor a future grant a current one.
+func MatchKnownClient(entries []KnownClient, cert *x509.Certificate) (KnownClient, bool) {
+	fingerprint := CertFingerprint(cert)
+	key := KeyEntry(KeyFingerprint(cert))
+	var match KnownClient
+	found := false
+	for _, entry := range entries {
+		if entry.Fingerprint != fingerprint && entry.Fingerprint != key {
+			continue
+		}
+		if entry.Active(time.Now()) {
+			return entry, true
+		}
+		match, found = entry, true
+	}
+	return match, found
+}
+
+// ValidityVerifier rejects certificates outside their validity period. Without client CAs the TLS
+// stack accepts any client certificate, leaving this to the Verifier.
+var ValidityVerifier = VerifierFunc(func(cert *x509.Certificate) error {
+	now := time.Now()
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("client certificate for CN '%s' expired at %s", cert.Subject.CommonName, cert.NotAfter.Format(time.RFC3339))
+	}
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("client certificate for CN '%s' is not valid before %s", cert.Subject.CommonName, cert.NotBefore.Format(time.RFC3339))
+	}
+	return nil
+})
+
+// VerifyAll returns a Verifier that runs the verifiers in order and stops at the first error, e.g.
+// VerifyAll(ValidityVerifier, FingerprintVerifier{Store: store}).
+func VerifyAll(verifiers ...Verifier) Verifier {
+	return VerifierFunc(func(cert *x509.Certificate) error {
+		for _, v := range verifiers {
+			if err := v.Verify(cert); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
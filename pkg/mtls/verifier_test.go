@@ -0,0 +1,172 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCert returns a self-signed certificate for cn, valid from notBefore to notAfter, and its key PEM.
+func newTestCert(t *testing.T, cn string, notBefore, notAfter time.Time) (*x509.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// newTestStore writes the known clients file content and loads it.
+func newTestStore(t *testing.T, content string) *FileStore {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "knownClients.txt")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewFileStore(path, FileStoreOptions{Strict: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestFingerprintVerifier(t *testing.T) {
+	now := time.Now()
+	known, _ := newTestCert(t, "known", now.Add(-time.Hour), now.Add(time.Hour))
+	byKey, _ := newTestCert(t, "by_key", now.Add(-time.Hour), now.Add(time.Hour))
+	other, _ := newTestCert(t, "known", now.Add(-time.Hour), now.Add(time.Hour))
+	stranger, _ := newTestCert(t, "stranger", now.Add(-time.Hour), now.Add(time.Hour))
+	store := newTestStore(t, "known "+CertFingerprint(known)+"\nby_key spki:"+SPKIPin(byKey)+"\n")
+	v := FingerprintVerifier{Store: store}
+
+	if err := v.Verify(known); err != nil {
+		t.Errorf("Expected the listed certificate to be accepted, got %v", err)
+	}
+	if err := v.Verify(byKey); err != nil {
+		t.Errorf("Expected the certificate with a listed key to be accepted, got %v", err)
+	}
+	if err := v.Verify(other); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("Expected ErrFingerprintMismatch for another certificate with a known CN, got %v", err)
+	}
+	if err := v.Verify(stranger); !errors.Is(err, ErrCNNotAuthorized) {
+		t.Errorf("Expected ErrCNNotAuthorized for an unknown CN, got %v", err)
+	}
+}
+
+func TestFingerprintVerifierValidityWindow(t *testing.T) {
+	now := time.Now()
+	cert, _ := newTestCert(t, "temp", now.Add(-time.Hour), now.Add(time.Hour))
+	at := func(t time.Time) string { return t.UTC().Format(time.RFC3339) }
+	fingerprint := CertFingerprint(cert)
+
+	for _, tt := range []struct {
+		options string
+		check   func(error) bool
+	}{
+		{"valid-from=" + at(now.Add(-time.Minute)) + " valid-until=" + at(now.Add(time.Minute)), func(err error) bool { return err == nil }},
+		{"valid-from=" + at(now.Add(time.Minute)), func(err error) bool { return errors.Is(err, ErrEntryNotYetValid) }},
+		{"valid-until=" + at(now.Add(-time.Minute)), func(err error) bool { return err != nil && strings.Contains(err.Error(), "expired") }},
+	} {
+		err := FingerprintVerifier{Store: newTestStore(t, "temp "+fingerprint+" "+tt.options+"\n")}.Verify(cert)
+		if !tt.check(err) {
+			t.Errorf("Unexpected result for %q: %v", tt.options, err)
+		}
+	}
+
+	// A current grant is found even when a future one for the same certificate comes first.
+	store := newTestStore(t, "temp "+fingerprint+" valid-from="+at(now.Add(time.Hour))+"\ntemp "+fingerprint+" valid-until="+at(now.Add(time.Minute))+"\n")
+	if err := (FingerprintVerifier{Store: store}).Verify(cert); err != nil {
+		t.Errorf("Expected the current entry to be preferred, got %v", err)
+	}
+}
+
+func TestFingerprintVerifierByIdentitySource(t *testing.T) {
+	spiffeID, _ := url.Parse("spiffe://example.org/ns/prod/sa/billing")
+	cert := &x509.Certificate{
+		Raw:            []byte("stands in for the DER"),
+		URIs:           []*url.URL{spiffeID},
+		EmailAddresses: []string{"billing@example.org"},
+	}
+	store := newTestStore(t, spiffeID.String()+" "+CertFingerprint(cert)+"\n")
+
+	if err := (FingerprintVerifier{Store: store, Identity: IdentityURISAN}).Verify(cert); err != nil {
+		t.Errorf("Expected the listed SPIFFE ID to be accepted, got %v", err)
+	}
+	err := FingerprintVerifier{Store: store, Identity: IdentityEmailSAN}.Verify(cert)
+	if !errors.Is(err, ErrCNNotAuthorized) || !strings.Contains(err.Error(), "email SAN 'billing@example.org'") {
+		t.Errorf("Expected the unlisted email SAN to be named in ErrCNNotAuthorized, got %v", err)
+	}
+	if err := (FingerprintVerifier{Store: store}).Verify(cert); !errors.Is(err, ErrCNNotAuthorized) {
+		t.Errorf("Expected the empty CN not to match, got %v", err)
+	}
+	if err := (FingerprintVerifier{Store: store, Identity: IdentityDNSSAN}).Verify(cert); !errors.Is(err, ErrNoClientID) {
+		t.Errorf("Expected ErrNoClientID for a certificate without DNS SANs, got %v", err)
+	}
+
+	if src, err := ParseIdentitySource(""); err != nil || src != IdentityCN {
+		t.Errorf("Expected the empty source to mean cn, got %q, %v", src, err)
+	}
+	if _, err := ParseIdentitySource("subject"); err == nil {
+		t.Error("Expected an unknown source to be rejected")
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	now := time.Now()
+	expired, _ := newTestCert(t, "known", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	store := newTestStore(t, "known "+CertFingerprint(expired)+"\n")
+
+	if err := (FingerprintVerifier{Store: store}).Verify(expired); err != nil {
+		t.Errorf("Expected FingerprintVerifier alone to ignore the validity period, got %v", err)
+	}
+	err := VerifyAll(ValidityVerifier, FingerprintVerifier{Store: store}).Verify(expired)
+	if err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("Expected the expired certificate to be rejected, got %v", err)
+	}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	cert, _ := newTestCert(t, "client", time.Now(), time.Now().Add(time.Hour))
+	want := KeyEntry(KeyFingerprint(cert))
+	for _, raw := range []string{"spki:" + SPKIPin(cert), "SPKI:" + strings.ToLower(KeyFingerprint(cert))} {
+		got, err := NormalizeFingerprint(raw)
+		if err != nil || got != want {
+			t.Errorf("NormalizeFingerprint(%q) = %q, %v; want %q", raw, got, err, want)
+		}
+	}
+	if got, _ := NormalizeFingerprint("ab:cd"); got != "AB:CD" {
+		t.Errorf("Expected certificate fingerprints to be uppercased, got %q", got)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// --- Proof of Possession ---
+//
+// The mTLS handshake already proves key possession, but only at connection time.
+// These endpoints let a client prove fresh possession of its private key for a
+// single request: the server issues a nonce, the client signs it, and the server
+// verifies the signature against the public key of the presented certificate.
+
+const (
+	popNoncePath  = "/pop/nonce"
+	popVerifyPath = "/pop/verify"
+	popNonceTTL   = time.Minute
+	popSigContext = "tls-playground-pop:" // Domain separation so signatures can't be reused elsewhere
+)
+
+// popNonceResponse is returned by the nonce endpoint.
+type popNonceResponse struct {
+	Nonce string `json:"nonce"`
+}
+
+// popVerifyRequest is sent by the client to the verify endpoint.
+type popVerifyRequest struct {
+	Nonce     string `json:"nonce"`
+	Signature string `json:"signature"`
+}
+
+// popVerifyResponse is returned by the verify endpoint.
+type popVerifyResponse struct {
+	Verified bool   `json:"verified"`
+	CN       string `json:"cn,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// nonceStore tracks issued nonces. Each nonce is bound to the CN it was issued to and can be used once.
+type nonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]issuedNonce
+}
+
+type issuedNonce struct {
+	cn      string
+	expires time.Time
+}
+
+func newNonceStore() *nonceStore {
+	return &nonceStore{nonces: make(map[string]issuedNonce)}
+}
+
+// Issue creates a fresh random nonce for the CN.
+func (n *nonceStore) Issue(cn string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(buf)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	for k, v := range n.nonces { // Drop expired nonces so the map doesn't grow unbounded
+		if now.After(v.expires) {
+			delete(n.nonces, k)
+		}
+	}
+	n.nonces[nonce] = issuedNonce{cn: cn, expires: now.Add(popNonceTTL)}
+	return nonce, nil
+}
+
+// Consume removes the nonce and reports whether it was valid for the CN.
+func (n *nonceStore) Consume(nonce, cn string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	issued, ok := n.nonces[nonce]
+	delete(n.nonces, nonce)
+	return ok && issued.cn == cn && time.Now().Before(issued.expires)
+}
+
+// popNonceHandler issues a nonce to the authenticated client.
+func (s *Server) popNonceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	cn := r.TLS.PeerCertificates[0].Subject.CommonName
+	nonce, err := s.nonces.Issue(cn)
+	if err != nil {
+		logErrorf("Failed to issue proof-of-possession nonce: %v", err)
+		http.Error(w, "failed to issue nonce", http.StatusInternalServerError)
+		return
+	}
+	logAuth(levelInfo, "Issued proof-of-possession nonce", requestAttrs(r)...)
+	writeJSON(w, http.StatusOK, popNonceResponse{Nonce: nonce})
+}
+
+// popVerifyHandler checks the client's signature over a previously issued nonce.
+func (s *Server) popVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	cn := leaf.Subject.CommonName
+
+	var req popVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, popVerifyResponse{Error: "invalid request body"})
+		return
+	}
+	if !s.nonces.Consume(req.Nonce, cn) {
+		logAuth(levelError, "Proof of possession failed", append(requestAttrs(r), slog.String("reason", "unknown, expired or reused nonce"))...)
+		writeJSON(w, http.StatusForbidden, popVerifyResponse{Error: "unknown, expired or reused nonce"})
+		return
+	}
+	sig, err := base64.StdEncoding.DecodeString(req.Signature)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, popVerifyResponse{Error: "signature is not valid base64"})
+		return
+	}
+	if err := verifyPossessionSignature(leaf.PublicKey, []byte(popSigContext+req.Nonce), sig); err != nil {
+		logAuth(levelError, "Proof of possession failed", append(requestAttrs(r), slog.String("reason", err.Error()))...)
+		writeJSON(w, http.StatusForbidden, popVerifyResponse{Error: err.Error()})
+		return
+	}
+
+	logAuth(levelInfo, "Proof of possession verified", requestAttrs(r)...)
+	writeJSON(w, http.StatusOK, popVerifyResponse{Verified: true, CN: cn})
+}
+
+// signPossession signs the message with the client's private key.
+// RSA keys use PKCS#1 v1.5 and ECDSA keys ASN.1 signatures over SHA-256; Ed25519 signs the message directly.
+func signPossession(signer crypto.Signer, message []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, message, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(message)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// verifyPossessionSignature checks a signature produced by signPossession against the certificate's public key.
+func verifyPossessionSignature(pub crypto.PublicKey, message, sig []byte) error {
+	digest := sha256.Sum256(message)
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return errors.New("invalid RSA signature")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, digest[:], sig) {
+			return errors.New("invalid ECDSA signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, message, sig) {
+			return errors.New("invalid Ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// ProvePossession fetches a nonce from the server, signs it with the client's key and asks the server to verify it.
+func (c *Client) ProvePossession() error {
+	cert := c.certificate()
+	if cert == nil {
+		return errors.New("proving possession needs a client certificate")
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return errors.New("client private key does not support signing")
+	}
+	return c.provePossession(signer)
+}
+
+// provePossession runs the nonce exchange signing with the given key, which tests use to forge signatures.
+func (c *Client) provePossession(signer crypto.Signer) error {
+	nonceURL, err := c.resolve(popNoncePath)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Get(nonceURL)
+	if err != nil {
+		return fmt.Errorf("failed to request nonce: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("nonce request failed with status %d", resp.StatusCode)
+	}
+	var nonceResp popNonceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&nonceResp); err != nil {
+		return fmt.Errorf("failed to decode nonce response: %w", err)
+	}
+	logInfof("Received nonce %s", nonceResp.Nonce)
+
+	sig, err := signPossession(signer, []byte(popSigContext+nonceResp.Nonce))
+	if err != nil {
+		return fmt.Errorf("failed to sign nonce: %w", err)
+	}
+	body, err := json.Marshal(popVerifyRequest{Nonce: nonceResp.Nonce, Signature: base64.StdEncoding.EncodeToString(sig)})
+	if err != nil {
+		return err
+	}
+
+	verifyURL, err := c.resolve(popVerifyPath)
+	if err != nil {
+		return err
+	}
+	verifyResp, err := c.httpClient.Post(verifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to send signature: %w", err)
+	}
+	defer verifyResp.Body.Close()
+	var result popVerifyResponse
+	if err := json.NewDecoder(verifyResp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode verify response (status %d): %w", verifyResp.StatusCode, err)
+	}
+	if !result.Verified {
+		return fmt.Errorf("server rejected proof of possession: %s", result.Error)
+	}
+	outputf("Proof of possession verified by server for CN '%s'\n", result.CN)
+	return nil
+}
+
+// resolve builds a URL for the given path on the configured server.
+func (c *Client) resolve(path string) (string, error) {
+	base, err := url.Parse(c.ServerURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL %s: %w", c.ServerURL, err)
+	}
+	ref, err := url.Parse(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path %s: %w", path, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+)
+
+func TestProofOfPossession(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("valid signature", func(t *testing.T) {
+		if err := client.ProvePossession(); err != nil {
+			t.Fatalf("Expected proof of possession to succeed, got %v", err)
+		}
+	})
+
+	t.Run("forged signature", func(t *testing.T) {
+		forger, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = client.provePossession(forger)
+		if err == nil || !strings.Contains(err.Error(), "invalid RSA signature") {
+			t.Fatalf("Expected forged signature to be rejected, got %v", err)
+		}
+	})
+}
+
+func TestVerifyPossessionSignatureKeyTypes(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte(popSigContext + "nonce")
+
+	for name, signer := range map[string]crypto.Signer{"ecdsa": ecKey, "ed25519": edKey} {
+		t.Run(name, func(t *testing.T) {
+			sig, err := signPossession(signer, message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifyPossessionSignature(signer.Public(), message, sig); err != nil {
+				t.Errorf("Expected valid signature, got %v", err)
+			}
+			if err := verifyPossessionSignature(signer.Public(), []byte("other message"), sig); err == nil {
+				t.Error("Expected signature over a different message to fail")
+			}
+		})
+	}
+}
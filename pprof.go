@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// --- Profiling Endpoints ---
+//
+// With PprofCNs set, the HTTPS server also serves the net/http/pprof handlers under /debug/pprof/ to
+// clients with one of those IDs (CNs, or SANs with ClientIDSource), so a server under load can be profiled in place without a rebuild.
+// go tool pprof can't present a client certificate, so profiles are fetched with curl and read locally.
+// The handlers are registered on the server's own mux, never on http.DefaultServeMux, and only when
+// enabled: they reveal command lines, goroutine stacks and heap contents, and a CPU profile or trace
+// keeps a request busy for its whole duration. Requests still pass the allowed paths of the known
+// clients entry and the rate limiter like any other.
+
+const pprofPath = "/debug/pprof/"
+
+// registerPprof adds the profiling handlers to mux if PprofCNs is set.
+func (s *Server) registerPprof(mux *http.ServeMux) {
+	if len(s.PprofCNs) == 0 {
+		return
+	}
+	for path, handler := range map[string]http.HandlerFunc{
+		pprofPath:             pprof.Index, // Also serves the named profiles: heap, goroutine, block, ...
+		pprofPath + "cmdline": pprof.Cmdline,
+		pprofPath + "profile": pprof.Profile,
+		pprofPath + "symbol":  pprof.Symbol,
+		pprofPath + "trace":   pprof.Trace,
+	} {
+		mux.Handle(path, s.requireClientID(s.PprofCNs, "profiling", handler))
+	}
+}
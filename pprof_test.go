@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// getPprof fetches path with the test client and returns the status and body.
+func getPprof(t *testing.T, pki *testPKI, baseURL, path string) (int, string) {
+	t.Helper()
+	client, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.httpClient.Get(baseURL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestPprofEndpoints(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.PprofCNs = []string{pki.ClientCN} })
+
+	status, body := getPprof(t, pki, baseURL, "/debug/pprof/")
+	if status != http.StatusOK || !strings.Contains(body, "goroutine") {
+		t.Fatalf("Expected the profile index, got %d: %s", status, body)
+	}
+	status, body = getPprof(t, pki, baseURL, "/debug/pprof/goroutine?debug=1")
+	if status != http.StatusOK || !strings.Contains(body, "goroutine profile:") {
+		t.Errorf("Expected a goroutine profile, got %d: %s", status, body)
+	}
+	if status, _ := getPprof(t, pki, baseURL, "/debug/pprof/cmdline"); status != http.StatusOK {
+		t.Errorf("Expected the command line, got %d", status)
+	}
+}
+
+func TestPprofRequiresCN(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.PprofCNs = []string{"ops"} })
+	if status, _ := getPprof(t, pki, baseURL, "/debug/pprof/heap"); status != http.StatusForbidden {
+		t.Errorf("Expected 403 for a client without a pprof CN, got %d", status)
+	}
+
+	_, baseURL = startTestServer(t, pki, nil)
+	if _, body := getPprof(t, pki, baseURL, "/debug/pprof/goroutine?debug=1"); strings.Contains(body, "goroutine profile:") {
+		t.Error("Expected no profiling endpoints unless enabled")
+	}
+}
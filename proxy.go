@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// --- Backend Handler & Reverse Proxy ---
+//
+// Requests that none of the playground's own endpoints (/pop/, /token, /admin/) handle go to the
+// application handler: Server.Handler if set, otherwise a reverse proxy to BackendURL, otherwise
+// the hello handler. Either way the request has passed client certificate verification and the
+// known clients entry checks, so the proxy turns the server into a small mTLS terminating proxy.
+
+// Headers identifying the client to the backend. Values sent by the client itself are dropped.
+const (
+	headerClientCN          = "X-Client-CN"
+	headerClientFingerprint = "X-Client-Fingerprint"
+)
+
+// newBackendProxy returns a reverse proxy to the http(s) backend URL that tells the backend who the client is.
+func newBackendProxy(backendURL string) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(backendURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid backend URL %q: want http://host[:port][/path] or https://...", backendURL)
+	}
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Del(headerClientCN)
+			pr.Out.Header.Del(headerClientFingerprint)
+			if id, ok := ClientIdentityFromContext(pr.In.Context()); ok {
+				pr.Out.Header.Set(headerClientCN, id.CN)
+				pr.Out.Header.Set(headerClientFingerprint, id.Fingerprint)
+			}
+		},
+		ErrorLog: newLevelLogger(levelError),
+	}, nil
+}
+
+// redactURL hides the password of a URL, e.g. for the configuration summary.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
+
+// appHandler returns the handler for requests the playground's own endpoints don't serve.
+func (s *Server) appHandler() (http.Handler, error) {
+	switch {
+	case s.Handler != nil:
+		return s.Handler, nil
+	case s.BackendURL != "":
+		proxy, err := newBackendProxy(s.BackendURL)
+		if err != nil {
+			return nil, err
+		}
+		logInfof("Proxying authenticated requests to %s", s.BackendURL)
+		return proxy, nil
+	default:
+		return http.HandlerFunc(helloHandler), nil
+	}
+}
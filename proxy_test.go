@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestBackendProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "backend saw %s %s cn=%s fingerprint=%s", r.Method, r.URL.Path,
+			r.Header.Get(headerClientCN), r.Header.Get(headerClientFingerprint))
+	}))
+	defer backend.Close()
+
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.BackendURL = backend.URL + "/app" })
+	client, err := NewClient(baseURL+"/orders", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Identity headers sent by the client are replaced, not forwarded.
+	client.Header = http.Header{headerClientCN: {"admin"}}
+	client.Method = http.MethodDelete
+
+	body, status, err := client.SendRequest()
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Request failed: %d (%v)", status, err)
+	}
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("backend saw DELETE /app/orders cn=%s fingerprint=%s", pki.ClientCN, mtls.CertFingerprint(cert))
+	if body != want {
+		t.Errorf("Expected %q, got %q", want, body)
+	}
+}
+
+func TestBackendProxyUnreachable(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.BackendURL = "http://" + freeAddr(t) })
+	client, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusBadGateway {
+		t.Errorf("Expected 502 from an unreachable backend, got %d (%v)", status, err)
+	}
+}
+
+func TestServerHandler(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := ClientIdentityFromContext(r.Context())
+			fmt.Fprintf(w, "custom handler for %s", id.CN)
+		})
+	})
+	client, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _, err := client.SendRequest(); err != nil || body != "custom handler for "+pki.ClientCN {
+		t.Errorf("Expected the custom handler to answer, got %q (%v)", body, err)
+	}
+}
+
+func TestInvalidBackendURL(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.BackendURL = "localhost:8080"
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "invalid backend URL") {
+		server.Stop()
+		t.Errorf("Expected an invalid backend URL to fail Start, got %v", err)
+	}
+}
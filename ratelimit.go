@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Per-Client Rate Limiting ---
+//
+// With RateLimit set, every client CN gets a token bucket refilled at RateLimit requests per second and
+// holding up to RateBurst requests. A request that finds its client's bucket empty is answered with 429
+// Too Many Requests and a Retry-After header. A known clients entry can give its client another rate
+// (rate=<n> in the text format, rate_limit in JSON and YAML), which also limits that client when RateLimit
+// is 0. Overrides take effect on the next request after a reload. Only HTTPS requests are limited.
+
+// maxRateLimiters bounds the buckets kept in memory. Beyond it, buckets that have refilled completely are
+// dropped: a new bucket starts full, so forgetting them changes nothing.
+const maxRateLimiters = 10000
+
+// clientRateLimiter holds a token bucket per client CN.
+type clientRateLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newClientRateLimiter() *clientRateLimiter {
+	return &clientRateLimiter{limiters: make(map[string]*rate.Limiter)}
+}
+
+// rateBurst returns the bucket size for a rate: burst if set, otherwise one second's worth of requests.
+func rateBurst(perSecond float64, burst int) int {
+	if burst > 0 {
+		return burst
+	}
+	return int(math.Max(1, math.Ceil(perSecond)))
+}
+
+// allow takes a token from the CN's bucket. If it is empty, it returns false and how long until the next token.
+func (l *clientRateLimiter) allow(cn string, perSecond float64, burst int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	limit, size := rate.Limit(perSecond), rateBurst(perSecond, burst)
+	limiter, ok := l.limiters[cn]
+	if !ok {
+		if len(l.limiters) >= maxRateLimiters {
+			l.pruneFull()
+		}
+		limiter = rate.NewLimiter(limit, size)
+		l.limiters[cn] = limiter
+	}
+	if limiter.Limit() != limit { // The entry's override changed on reload
+		limiter.SetLimit(limit)
+	}
+	if limiter.Burst() != size {
+		limiter.SetBurst(size)
+	}
+	reservation := limiter.Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// pruneFull drops the buckets that have refilled completely.
+func (l *clientRateLimiter) pruneFull() {
+	for cn, limiter := range l.limiters {
+		if limiter.Tokens() >= float64(limiter.Burst()) {
+			delete(l.limiters, cn)
+		}
+	}
+}
+
+// clientRateLimit returns the rate limit for the request's client: its known clients entry's if set,
+// otherwise RateLimit. 0 means unlimited.
+func (s *Server) clientRateLimit(r *http.Request) float64 {
+	if s.knownClients != nil && r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		cert := r.TLS.PeerCertificates[0]
+		entries, _ := s.knownClientsFor(r.TLS.ServerName).Lookup(s.clientID(cert))
+		if entry, ok := mtls.MatchKnownClient(entries, cert); ok && entry.RateLimit > 0 {
+			return entry.RateLimit
+		}
+	}
+	return s.RateLimit
+}
+
+// limitRate answers 429 to clients that exceed their rate limit.
+func (s *Server) limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		perSecond := s.clientRateLimit(r)
+		if perSecond <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok, retryAfter := s.rateLimiter.allow(peerCN(r), perSecond, s.RateBurst); !ok {
+			logAuth(levelWarn, "Rate limited request", requestAttrs(r)...)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.RateLimit = 0.5
+		s.RateBurst = 2
+	})
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+			t.Fatalf("Expected request %d within the burst to succeed, got %d (%v)", i+1, status, err)
+		}
+	}
+	resp, err := client.httpClient.Get(baseURL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 once the burst is used up, got %d", resp.StatusCode)
+	}
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "2" {
+		t.Errorf("Expected Retry-After 2 at 0.5 requests per second, got %q", retryAfter)
+	}
+
+	// Other clients have their own budget.
+	other, fingerprint := addNewClient(t, pki, baseURL, "other_client")
+	appendKnownClientLine(t, pki, fmt.Sprintf("other_client %s", fingerprint))
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := other.SendRequest(); err != nil || status != http.StatusOK {
+		t.Errorf("Expected another client to be unaffected, got %d (%v)", status, err)
+	}
+}
+
+func TestRateLimitOverride(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, nil) // No default limit
+	limited, fingerprint := addNewClient(t, pki, baseURL, "limited_client")
+	appendKnownClientLine(t, pki, fmt.Sprintf("limited_client %s /hello rate=0.1", fingerprint))
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, status, err := limited.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the first request to succeed, got %d (%v)", status, err)
+	}
+	if _, status, _ := limited.SendRequest(); status != http.StatusTooManyRequests {
+		t.Errorf("Expected the entry's rate to limit its client, got %d", status)
+	}
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+			t.Fatalf("Expected clients without an override to be unlimited, got %d (%v)", status, err)
+		}
+	}
+}
+
+func TestClientRateLimiterRefills(t *testing.T) {
+	limiter := newClientRateLimiter()
+	if ok, _ := limiter.allow("cn", 50, 1); !ok {
+		t.Fatal("Expected the first request to be allowed")
+	}
+	ok, retryAfter := limiter.allow("cn", 50, 1)
+	if ok || retryAfter <= 0 || retryAfter > 20*time.Millisecond {
+		t.Fatalf("Expected the second request to wait up to 20ms, got %v, %s", ok, retryAfter)
+	}
+	time.Sleep(25 * time.Millisecond)
+	if ok, _ := limiter.allow("cn", 50, 1); !ok {
+		t.Error("Expected the bucket to have refilled")
+	}
+}
+
+// appendKnownClientLine appends a raw line to the PKI's known clients file.
+func appendKnownClientLine(t *testing.T, pki *testPKI, line string) {
+	t.Helper()
+	content, err := ioutil.ReadFile(pki.KnownClientsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pki.KnownClientsFile, append(content, line+"\n"...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
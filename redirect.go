@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// --- Redirect Policy & Client Certificate Presentation ---
+
+// defaultMaxRedirects matches the limit net/http applies when no CheckRedirect is set.
+const defaultMaxRedirects = 10
+
+// checkRedirect implements the client's redirect policy for http.Client.CheckRedirect.
+func (c *Client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if c.NoFollowRedirects {
+		logInfof("Not following redirect to %s", req.URL)
+		return http.ErrUseLastResponse
+	}
+	max := c.MaxRedirects
+	if max <= 0 {
+		max = defaultMaxRedirects
+	}
+	if len(via) >= max {
+		return fmt.Errorf("stopped after %d redirects", max)
+	}
+
+	from := via[len(via)-1].URL
+	if !strings.EqualFold(from.Host, req.URL.Host) {
+		if c.StripCertCrossHost && !c.presentsCertTo(req.URL) {
+			logWarnf("Following cross-host redirect from %s to %s without the client certificate", from.Host, req.URL.Host)
+		} else {
+			logWarnf("Following cross-host redirect from %s to %s; the client certificate may be presented", from.Host, req.URL.Host)
+		}
+	} else {
+		logInfof("Following redirect to %s", req.URL)
+	}
+	return nil
+}
+
+// presentsCertTo reports whether the client certificate may be sent to the URL's host:
+// the configured server's host and any CertHosts entry. Entries without a port match any port.
+func (c *Client) presentsCertTo(u *url.URL) bool {
+	allowed := append([]string{}, c.CertHosts...)
+	if server, err := url.Parse(c.ServerURL); err == nil {
+		allowed = append(allowed, server.Host)
+	}
+	for _, entry := range allowed {
+		if hostMatches(entry, u) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostMatches compares an allowlist entry ("host" or "host:port") with the URL's host.
+func hostMatches(entry string, u *url.URL) bool {
+	if host, port, err := net.SplitHostPort(entry); err == nil {
+		return strings.EqualFold(host, u.Hostname()) && port == urlPort(u)
+	}
+	return strings.EqualFold(strings.Trim(entry, "[]"), u.Hostname())
+}
+
+// urlPort returns the URL's port, defaulting to the scheme's well-known port.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	if u.Scheme == "http" {
+		return "80"
+	}
+	return "443"
+}
+
+// certRoutingTransport sends requests through a transport that presents the client certificate
+// only when the client's policy allows it for the request's host.
+type certRoutingTransport struct {
+	client      *Client
+	withCert    http.RoundTripper
+	withoutCert http.RoundTripper
+}
+
+func (t *certRoutingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.client.StripCertCrossHost && !t.client.presentsCertTo(req.URL) {
+		return t.withoutCert.RoundTrip(req)
+	}
+	return t.withCert.RoundTrip(req)
+}
+
+// CloseIdleConnections closes idle connections of both underlying transports.
+func (t *certRoutingTransport) CloseIdleConnections() {
+	for _, rt := range []http.RoundTripper{t.withCert, t.withoutCert} {
+		if ci, ok := rt.(interface{ CloseIdleConnections() }); ok {
+			ci.CloseIdleConnections()
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newCertRecordingServer starts a TLS server that requests (but doesn't require) client certificates
+// and records whether the last request presented one.
+func newCertRecordingServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *int32) {
+	t.Helper()
+	var sawCert int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			atomic.StoreInt32(&sawCert, 1)
+		} else {
+			atomic.StoreInt32(&sawCert, 0)
+		}
+		handler(w, r)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts, &sawCert
+}
+
+// newRedirectTestClient builds a client trusting the httptest certificate and presenting the test PKI's client cert.
+func newRedirectTestClient(t *testing.T, ts *httptest.Server, serverURL string) *Client {
+	t.Helper()
+	pki := newTestPKI(t)
+	cert, err := tls.LoadX509KeyPair(pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig := ts.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	return newClientWithTLSConfig(serverURL, tlsConfig)
+}
+
+func TestClientRedirectPolicy(t *testing.T) {
+	target, targetSawCert := newCertRecordingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("target"))
+	})
+	origin, _ := newCertRecordingServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/cross":
+			http.Redirect(w, r, target.URL+"/final", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			w.Write([]byte("origin"))
+		}
+	})
+
+	t.Run("follows same-host redirect", func(t *testing.T) {
+		client := newRedirectTestClient(t, origin, origin.URL+"/same")
+		body, status, err := client.SendRequest()
+		if err != nil || status != http.StatusOK || body != "origin" {
+			t.Fatalf("Expected redirect to be followed, got %d %q %v", status, body, err)
+		}
+	})
+
+	t.Run("does not follow when disabled", func(t *testing.T) {
+		client := newRedirectTestClient(t, origin, origin.URL+"/same")
+		client.NoFollowRedirects = true
+		_, status, err := client.SendRequest()
+		if err != nil || status != http.StatusFound {
+			t.Fatalf("Expected 302 to be returned, got %d %v", status, err)
+		}
+	})
+
+	t.Run("stops after max redirects", func(t *testing.T) {
+		client := newRedirectTestClient(t, origin, origin.URL+"/loop")
+		client.MaxRedirects = 3
+		_, _, err := client.SendRequest()
+		if err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+			t.Fatalf("Expected redirect limit error, got %v", err)
+		}
+	})
+
+	t.Run("cross-host presents cert by default", func(t *testing.T) {
+		client := newRedirectTestClient(t, origin, origin.URL+"/cross")
+		body, _, err := client.SendRequest()
+		if err != nil || body != "target" {
+			t.Fatalf("Expected cross-host redirect to be followed, got %q %v", body, err)
+		}
+		if atomic.LoadInt32(targetSawCert) != 1 {
+			t.Error("Expected client certificate to be presented without --strip-cert-cross-host")
+		}
+	})
+
+	t.Run("cross-host strips cert", func(t *testing.T) {
+		client := newRedirectTestClient(t, origin, origin.URL+"/cross")
+		client.StripCertCrossHost = true
+		body, _, err := client.SendRequest()
+		if err != nil || body != "target" {
+			t.Fatalf("Expected cross-host redirect to be followed, got %q %v", body, err)
+		}
+		if atomic.LoadInt32(targetSawCert) != 0 {
+			t.Error("Expected client certificate to be withheld from the other host")
+		}
+	})
+
+	t.Run("cross-host allowlisted", func(t *testing.T) {
+		client := newRedirectTestClient(t, origin, origin.URL+"/cross")
+		client.StripCertCrossHost = true
+		targetURL, _ := url.Parse(target.URL)
+		client.CertHosts = []string{targetURL.Host}
+		if _, _, err := client.SendRequest(); err != nil {
+			t.Fatal(err)
+		}
+		if atomic.LoadInt32(targetSawCert) != 1 {
+			t.Error("Expected client certificate to be presented to the allowlisted host")
+		}
+	})
+}
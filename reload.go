@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// --- Known Clients Hot Reload ---
+//
+// The known clients store is swapped atomically on reload (see mtls.FileStore.Reload), so the
+// file can be reloaded while handshakes are in flight. Two triggers are available: SIGHUP (see
+// handleSignals), and polling the file's modification time and size every WatchKnownClients. Polling is used instead
+// of inotify-style notifications because editors and config management often replace the file
+// by renaming over it, which would silently detach a watch on the old inode.
+
+// fileStamp identifies a version of a file well enough to notice that it changed.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// statFileStamp returns the current stamp of a file.
+func statFileStamp(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// watchKnownClients reloads the known clients file whenever its stamp changes, until the server stops.
+func (s *Server) watchKnownClients() {
+	last, err := statFileStamp(s.KnownClientsFile)
+	missing := err != nil
+	ticker := time.NewTicker(s.WatchKnownClients)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := statFileStamp(s.KnownClientsFile)
+		if err != nil {
+			if !missing {
+				logWarnf("Cannot watch known clients file, keeping the current entries: %v", err)
+				missing = true
+			}
+			continue
+		}
+		missing = false
+		if current.modTime.Equal(last.modTime) && current.size == last.size {
+			continue
+		}
+		last = current
+		logInfof("Known clients file %s changed, reloading", s.KnownClientsFile)
+		if err := s.ReloadKnownClients(); err != nil {
+			logErrorf("%v", err)
+		}
+	}
+}
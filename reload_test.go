@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// addNewClient generates a client certificate that is not yet known to the server and returns a client using it.
+func addNewClient(t *testing.T, pki *testPKI, baseURL, cn string) (*Client, string) {
+	t.Helper()
+	certFile := filepath.Join(pki.Dir, cn+".crt")
+	keyFile := filepath.Join(pki.Dir, cn+".key")
+	fingerprint := pki.newClientCert(t, cn, certFile, keyFile)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, fingerprint
+}
+
+// waitForStatus polls /hello with the client until it gets the wanted status.
+func waitForStatus(t *testing.T, client *Client, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, status, _ := client.SendRequest()
+		if status == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected status %d, last got %d", want, status)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestWatchKnownClientsReloadsOnChange(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.WatchKnownClients = 20 * time.Millisecond })
+	client, fingerprint := addNewClient(t, pki, baseURL, "hot_client")
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Fatal("Expected the unknown client to be rejected before the file changes")
+	}
+
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "hot_client", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	waitForStatus(t, client, http.StatusOK)
+
+	// Revoking works the same way; new handshakes fail once the file is reloaded.
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, "hot_client", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client.httpClient.CloseIdleConnections()
+		if _, _, err := client.SendRequest(); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the removed client to be rejected after the file changed")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, nil)
+	client, fingerprint := addNewClient(t, pki, baseURL, "hup_client")
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "hup_client", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	// Without watching, the change is not picked up on its own.
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Fatal("Expected the client to be rejected before SIGHUP")
+	}
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- server.handleSignals(signals) }()
+	signals <- syscall.SIGHUP
+	waitForStatus(t, client, http.StatusOK)
+
+	close(signals)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected nil after the channel was closed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleSignals did not return after the channel was closed")
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected SIGHUP not to stop the server, got %d (%v)", status, err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// --- Renegotiation & Post-Handshake Client Auth ---
+//
+// Servers that only want a client certificate for some paths (Apache's per-directory SSLVerifyClient,
+// IIS) ask for it after the handshake: with a TLS 1.2 renegotiation, or with a TLS 1.3 post-handshake
+// CertificateRequest. Go's server does neither, so the playground server always asks in the initial
+// handshake; the client flags are for experimenting against other servers.
+//   - Renegotiation: crypto/tls clients refuse it unless --renegotiation is once or freely. Accepted
+//     renegotiations are logged, with whether the server asked for the client certificate again.
+//   - Post-handshake auth: crypto/tls doesn't implement it. The client doesn't offer the
+//     post_handshake_auth extension, so a compliant TLS 1.3 server never sends the request; one that
+//     does anyway gets an unexpected_message alert.
+// Failures caused by either are logged with an explanation.
+
+// renegotiationByName maps --renegotiation values to tls.RenegotiationSupport.
+var renegotiationByName = map[string]tls.RenegotiationSupport{
+	"never":  tls.RenegotiateNever,
+	"once":   tls.RenegotiateOnceAsClient,
+	"freely": tls.RenegotiateFreelyAsClient,
+}
+
+// parseRenegotiation parses a --renegotiation value. An empty string is never, the crypto/tls default.
+func parseRenegotiation(name string) (tls.RenegotiationSupport, error) {
+	if name == "" {
+		return tls.RenegotiateNever, nil
+	}
+	if r, ok := renegotiationByName[strings.ToLower(strings.TrimSpace(name))]; ok {
+		return r, nil
+	}
+	return 0, fmt.Errorf("unknown renegotiation support %q (want never, once or freely)", name)
+}
+
+// SetRenegotiation sets whether the client accepts TLS 1.2 renegotiations the server starts, and logs
+// the ones it accepts. Call it before the first request.
+func (c *Client) SetRenegotiation(r tls.RenegotiationSupport) {
+	for _, cfg := range []*tls.Config{c.tlsConfig, c.anonymousTLSConfig} {
+		cfg.Renegotiation = r
+		if r != tls.RenegotiateNever {
+			logCertificateRequests(cfg)
+		}
+	}
+}
+
+// logCertificateRequests wraps cfg.GetClientCertificate to log when the server asks for the client
+// certificate. Without one it chooses from cfg.Certificates as crypto/tls does.
+func logCertificateRequests(cfg *tls.Config) {
+	certs := cfg.Certificates
+	get := cfg.GetClientCertificate
+	cfg.GetClientCertificate = func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		var cert *tls.Certificate
+		if get != nil {
+			var err error
+			if cert, err = get(info); err != nil {
+				return nil, err
+			}
+		} else {
+			cert = selectClientCertificate(certs, info)
+		}
+		// Renegotiations run outside of the dial, with a background context.
+		if info.Context() == context.Background() {
+			if len(cert.Certificate) == 0 {
+				logWarnf("Server asked for a client certificate while renegotiating, but none is acceptable to it")
+			} else {
+				logInfof("Server asked for the client certificate while renegotiating, presenting it again")
+			}
+		} else {
+			logDebugf("Server asked for the client certificate in the handshake (%s)", tls.VersionName(info.Version))
+		}
+		return cert, nil
+	}
+}
+
+// selectClientCertificate returns the first certificate the server accepts, or an empty one to send none.
+func selectClientCertificate(certs []tls.Certificate, info *tls.CertificateRequestInfo) *tls.Certificate {
+	for i := range certs {
+		if info.SupportsCertificate(&certs[i]) == nil {
+			return &certs[i]
+		}
+	}
+	return new(tls.Certificate)
+}
+
+// explainPostHandshakeError logs why a request failed if the server tried to renegotiate or to
+// authenticate the client after the handshake.
+func explainPostHandshakeError(err error) {
+	if err == nil {
+		return
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "no renegotiation"):
+		logWarnf("The server tried to renegotiate the TLS 1.2 connection, which the client refused; allow it with --renegotiation once, or freely for repeated renegotiations")
+	case strings.Contains(msg, "unexpected handshake message") && strings.Contains(msg, "certificateRequestMsgTLS13"):
+		logWarnf("The server asked for the client certificate after the TLS 1.3 handshake (post-handshake auth), which Go does not support; try --max-tls 1.2 if the server can renegotiate instead")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseRenegotiation(t *testing.T) {
+	tests := []struct {
+		name string
+		want tls.RenegotiationSupport
+		ok   bool
+	}{
+		{"", tls.RenegotiateNever, true},
+		{"never", tls.RenegotiateNever, true},
+		{"Once", tls.RenegotiateOnceAsClient, true},
+		{"freely", tls.RenegotiateFreelyAsClient, true},
+		{"always", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseRenegotiation(tt.name)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("parseRenegotiation(%q) = %v, %v; want %v", tt.name, got, err, tt.want)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("parseRenegotiation(%q) succeeded, want an error", tt.name)
+		}
+	}
+}
+
+func TestRenegotiationClientStillPresentsCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	for _, maxTLS := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.SetTLSVersions(TLSVersions{Max: maxTLS})
+		client.SetRenegotiation(tls.RenegotiateFreelyAsClient)
+		if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+			t.Errorf("Expected the client certificate to be presented over %s, got %d (%v)", tls.VersionName(maxTLS), status, err)
+		}
+	}
+}
+
+func TestExplainPostHandshakeError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("local error: tls: no renegotiation"), "--renegotiation once"},
+		{errors.New("tls: received unexpected handshake message of type *tls.certificateRequestMsgTLS13"), "post-handshake auth"},
+		{errors.New("connection refused"), ""},
+	}
+	for _, tt := range tests {
+		_, logs := captureOutput(t, func() { explainPostHandshakeError(tt.err) })
+		if tt.want == "" && logs != "" {
+			t.Errorf("Expected no explanation for %q, got %q", tt.err, logs)
+		}
+		if !strings.Contains(logs, tt.want) {
+			t.Errorf("Expected the explanation of %q to mention %q, got %q", tt.err, tt.want, logs)
+		}
+	}
+}
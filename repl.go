@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Interactive Client REPL ---
+//
+// Each line is a request: a path, optionally preceded by a method and followed by a body, e.g.
+// "/hello" or "POST /token". Requests go over one keep-alive connection, carrying the --header headers,
+// and each response is shown with its status, timing, headers and body, and with whether the
+// connection was reused or, for a new one, whether its TLS session was resumed.
+
+// ClientReplCmd reads requests from stdin and sends them over one keep-alive connection.
+type ClientReplCmd struct{}
+
+// Run starts the REPL until EOF, "quit"/"exit" or Ctrl+C.
+func (r *ClientReplCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+	return runREPL(client, os.Stdin, os.Stdout, interrupt)
+}
+
+// replHelp explains the REPL syntax.
+const replHelp = `Type a request as [METHOD] PATH [BODY], e.g. /hello, HEAD /hello or POST /token.
+'help' shows this, 'quit' exits.
+`
+
+// replMethods are the methods a REPL line may start with.
+var replMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
+
+// replLine is a request typed into the REPL.
+type replLine struct {
+	Method string
+	Path   string // Relative to ServerURL, may include a query
+	Body   string
+}
+
+// parseREPLLine parses [METHOD] PATH [BODY]. The method defaults to GET, or POST with a body.
+func parseREPLLine(line string) (replLine, error) {
+	var r replLine
+	first, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if slices.Contains(replMethods, strings.ToUpper(first)) {
+		r.Method = strings.ToUpper(first)
+		first, rest, _ = strings.Cut(strings.TrimSpace(rest), " ")
+	}
+	if first == "" {
+		return r, errors.New("missing path")
+	}
+	r.Path, r.Body = first, strings.TrimSpace(rest)
+	if !strings.HasPrefix(r.Path, "/") {
+		r.Path = "/" + r.Path
+	}
+	if r.Method == "" {
+		r.Method = http.MethodGet
+		if r.Body != "" {
+			r.Method = http.MethodPost
+		}
+	}
+	return r, nil
+}
+
+// replResponse is what the REPL shows for one request.
+type replResponse struct {
+	Status    string
+	Header    http.Header
+	Body      string
+	Duration  time.Duration // From sending the request to reading the whole body
+	Handshake time.Duration // TLS handshake of a new connection, 0 on a reused one
+	Reused    bool          // The request went over an already open connection
+	DidResume bool          // A new connection resumed an earlier TLS session
+}
+
+// replHTTPClient returns an HTTP client for the REPL. Its transports are clones of the client's, so the
+// transport options, dialer and ALPN settings carry over, and they remember TLS sessions so a dropped
+// connection is re-established with a resumed handshake. The client's own TLS configuration and
+// connections are left untouched.
+func (c *Client) replHTTPClient() *http.Client {
+	routing := c.httpClient.Transport.(*certRoutingTransport)
+	withCert := routing.withCert.(*http.Transport).Clone()
+	withoutCert := routing.withoutCert.(*http.Transport).Clone()
+	for _, t := range []*http.Transport{withCert, withoutCert} {
+		if t.TLSClientConfig.ClientSessionCache == nil {
+			t.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(c.sessionCacheSize)
+		}
+	}
+	return &http.Client{
+		Transport:     &certRoutingTransport{client: c, withCert: withCert, withoutCert: withoutCert},
+		CheckRedirect: c.checkRedirect,
+	}
+}
+
+// replRequest sends a request over httpClient and reports how the connection was obtained.
+func (c *Client) replRequest(httpClient *http.Client, line replLine) (replResponse, error) {
+	target, err := c.resolve(line.Path)
+	if err != nil {
+		return replResponse{}, err
+	}
+	var body io.Reader
+	if line.Body != "" {
+		body = strings.NewReader(line.Body)
+	}
+	req, err := http.NewRequest(line.Method, target, body)
+	if err != nil {
+		return replResponse{}, fmt.Errorf("failed to build request: %w", err)
+	}
+	for name, values := range c.Header {
+		req.Header[name] = values
+	}
+	if host := c.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	var result replResponse
+	var handshakeStart time.Time
+	trace := &httptrace.ClientTrace{
+		GotConn:           func(info httptrace.GotConnInfo) { result.Reused = info.Reused },
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { result.Handshake = time.Since(handshakeStart) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		explainPostHandshakeError(err)
+		c.explainHandshakeError(err)
+		return replResponse{}, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body) // Read fully so the connection goes back to the pool
+	if err != nil {
+		return replResponse{}, fmt.Errorf("failed to read response body: %w", err)
+	}
+	result.Duration = time.Since(start)
+	result.Status = resp.Status
+	result.Header = resp.Header
+	result.Body = string(respBody)
+	if resp.TLS != nil {
+		result.DidResume = resp.TLS.DidResume
+	}
+	return result, nil
+}
+
+// printREPLResponse writes a response like an HTTP message, headed by how it was obtained.
+func printREPLResponse(out io.Writer, resp replResponse) {
+	handshake := ""
+	if resp.Handshake > 0 {
+		handshake = fmt.Sprintf(", TLS handshake %s", resp.Handshake.Round(time.Microsecond))
+	}
+	fmt.Fprintf(out, "%s in %s (connection reused: %t, TLS session resumed: %t%s)\n",
+		resp.Status, resp.Duration.Round(time.Microsecond), resp.Reused, resp.DidResume, handshake)
+	names := make([]string, 0, len(resp.Header))
+	for name := range resp.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range resp.Header[name] {
+			fmt.Fprintf(out, "%s: %s\n", name, value)
+		}
+	}
+	fmt.Fprintln(out)
+	fmt.Fprint(out, resp.Body)
+	if resp.Body != "" && !strings.HasSuffix(resp.Body, "\n") {
+		fmt.Fprintln(out)
+	}
+}
+
+// runREPL reads one path per line from in and prints each response to out.
+// It returns on EOF, "quit"/"exit" or a value on interrupt, closing the connections it opened.
+func runREPL(client *Client, in io.Reader, out io.Writer, interrupt <-chan os.Signal) error {
+	httpClient := client.replHTTPClient()
+	defer httpClient.CloseIdleConnections()
+
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-done:
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	fmt.Fprintf(out, "Sending requests to %s.\n%s> ", client.ServerURL, replHelp)
+	for {
+		select {
+		case <-interrupt:
+			fmt.Fprintln(out, "\nInterrupted, closing connection.")
+			return nil
+		case err := <-readErr:
+			fmt.Fprintln(out)
+			return err
+		case line := <-lines:
+			line = strings.TrimSpace(line)
+			switch line {
+			case "":
+			case "quit", "exit":
+				return nil
+			case "help":
+				fmt.Fprint(out, replHelp)
+			default:
+				req, err := parseREPLLine(line)
+				if err == nil {
+					var resp replResponse
+					if resp, err = client.replRequest(httpClient, req); err == nil {
+						printREPLResponse(out, resp)
+					}
+				}
+				if err != nil {
+					fmt.Fprintf(out, "Error: %v\n", err)
+				}
+			}
+			fmt.Fprint(out, "> ")
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestREPLReusesConnection(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runREPL(client, strings.NewReader("/hello\n\nagain\nquit\n/never\n"), &out, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, "connection reused: false") || !strings.Contains(output, "connection reused: true") {
+		t.Errorf("Expected a new and then a reused connection, got:\n%s", output)
+	}
+	if n := strings.Count(output, "Hello, authenticated client"); n != 2 {
+		t.Errorf("Expected 2 responses (nothing after quit), got %d:\n%s", n, output)
+	}
+	if client.tlsConfig.ClientSessionCache != nil || client.anonymousTLSConfig.ClientSessionCache != nil {
+		t.Error("Expected the REPL to leave the client TLS configuration untouched")
+	}
+}
+
+func TestParseREPLLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want replLine
+	}{
+		{"/hello", replLine{Method: "GET", Path: "/hello"}},
+		{"hello?x=1", replLine{Method: "GET", Path: "/hello?x=1"}},
+		{"head /hello", replLine{Method: "HEAD", Path: "/hello"}},
+		{"POST /token", replLine{Method: "POST", Path: "/token"}},
+		{"/echo {\"a\": 1}", replLine{Method: "POST", Path: "/echo", Body: `{"a": 1}`}},
+		{"PUT /item  some text", replLine{Method: "PUT", Path: "/item", Body: "some text"}},
+	}
+	for _, tt := range tests {
+		if got, err := parseREPLLine(tt.line); err != nil || got != tt.want {
+			t.Errorf("parseREPLLine(%q) = %+v, %v; want %+v", tt.line, got, err, tt.want)
+		}
+	}
+	if _, err := parseREPLLine("DELETE"); err == nil {
+		t.Error("Expected a method without a path to be rejected")
+	}
+}
+
+func TestREPLShowsMethodsHeadersAndTiming(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := runREPL(client, strings.NewReader("POST /token\nGET /token\nhelp\n"), &out, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	output := out.String()
+	if !strings.Contains(output, "200 OK in ") || !strings.Contains(output, "405 Method Not Allowed in ") {
+		t.Errorf("Expected POST to be accepted and GET to be refused, got:\n%s", output)
+	}
+	if !strings.Contains(output, ", TLS handshake ") {
+		t.Errorf("Expected the handshake time of the new connection, got:\n%s", output)
+	}
+	if !strings.Contains(output, "\nContent-Type: ") {
+		t.Errorf("Expected the response headers, got:\n%s", output)
+	}
+	if strings.Count(output, "Type a request as") != 2 {
+		t.Errorf("Expected help at startup and on request, got:\n%s", output)
+	}
+}
+
+func TestREPLExitsOnInterrupt(t *testing.T) {
+	pki := newTestPKI(t)
+	client, err := NewClient("https://localhost", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, w := io.Pipe() // Never written to, like an idle terminal
+	defer w.Close()
+	interrupt := make(chan os.Signal, 1)
+	interrupt <- os.Interrupt
+
+	done := make(chan error, 1)
+	var out bytes.Buffer
+	go func() { done <- runREPL(client, in, &out, interrupt) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean exit on interrupt, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("REPL did not exit on interrupt")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- TLS Handshake Report ---
+
+// tlsReport is the JSON document written by --tls-report after a successful request.
+type tlsReport struct {
+	URL         string              `json:"url"`
+	Timestamp   time.Time           `json:"timestamp"`
+	StatusCode  int                 `json:"status_code"`
+	Connection  tlsReportConnection `json:"connection"`
+	ServerChain []tlsReportCert     `json:"server_chain"`
+	Timing      tlsReportTiming     `json:"timing"`
+}
+
+// tlsReportConnection describes the negotiated TLS parameters.
+type tlsReportConnection struct {
+	Version            string `json:"version"`
+	CipherSuite        string `json:"cipher_suite"`
+	ServerName         string `json:"server_name"`
+	NegotiatedProtocol string `json:"negotiated_protocol"` // ALPN, empty if none was negotiated
+	DidResume          bool   `json:"did_resume"`
+	ConnectionReused   bool   `json:"connection_reused"`
+}
+
+// tlsReportCert summarizes one certificate of the server's chain.
+type tlsReportCert struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	DNSNames    []string  `json:"dns_names,omitempty"`
+	Fingerprint string    `json:"fingerprint_sha256"`
+}
+
+// tlsReportTiming breaks the request down into phases, in milliseconds.
+// Phases that did not happen (e.g. DNS for an IP literal, or everything but the request on a reused connection) are 0.
+type tlsReportTiming struct {
+	DNSMillis          float64 `json:"dns_ms"`
+	ConnectMillis      float64 `json:"connect_ms"`
+	TLSHandshakeMillis float64 `json:"tls_handshake_ms"`
+	FirstByteMillis    float64 `json:"time_to_first_byte_ms"`
+	TotalMillis        float64 `json:"total_ms"`
+}
+
+// requestTimings records the httptrace events of a single request.
+type requestTimings struct {
+	Start        time.Time
+	DNSStart     time.Time
+	DNSDone      time.Time
+	ConnectStart time.Time
+	ConnectDone  time.Time
+	TLSStart     time.Time
+	TLSDone      time.Time
+	FirstByte    time.Time
+	Done         time.Time
+	Reused       bool
+}
+
+// trace returns a ClientTrace that fills in the timings.
+func (rt *requestTimings) trace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { rt.DNSStart = time.Now() },
+		DNSDone:              func(httptrace.DNSDoneInfo) { rt.DNSDone = time.Now() },
+		ConnectStart:         func(string, string) { rt.ConnectStart = time.Now() },
+		ConnectDone:          func(string, string, error) { rt.ConnectDone = time.Now() },
+		TLSHandshakeStart:    func() { rt.TLSStart = time.Now() },
+		TLSHandshakeDone:     rt.tlsHandshakeDone,
+		GotConn:              rt.gotConn,
+		GotFirstResponseByte: func() { rt.FirstByte = time.Now() },
+	}
+}
+
+// gotConn records whether the request got an open connection, and logs it, as such a request does
+// no TLS handshake.
+func (rt *requestTimings) gotConn(info httptrace.GotConnInfo) {
+	rt.Reused = info.Reused
+	if info.Reused {
+		logInfof("Reusing open connection to %s (idle for %s), no TLS handshake", info.Conn.RemoteAddr(), info.IdleTime.Round(time.Millisecond))
+	}
+}
+
+// tlsHandshakeDone records the end of a handshake and logs how it went, including whether an earlier
+// session was resumed.
+func (rt *requestTimings) tlsHandshakeDone(cs tls.ConnectionState, err error) {
+	rt.TLSDone = time.Now()
+	if err == nil {
+		logInfof("TLS handshake done: %s, %s, session resumed: %t", tlsVersionName(cs.Version), tls.CipherSuiteName(cs.CipherSuite), cs.DidResume)
+	}
+}
+
+// millisBetween returns the duration between two events in milliseconds, or 0 if either didn't happen.
+func millisBetween(start, end time.Time) float64 {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
+
+// buildTLSReport assembles the report from the response and the recorded timings.
+func buildTLSReport(resp *http.Response, timings *requestTimings) tlsReport {
+	report := tlsReport{
+		URL:        resp.Request.URL.String(),
+		Timestamp:  timings.Start,
+		StatusCode: resp.StatusCode,
+		Timing: tlsReportTiming{
+			DNSMillis:          millisBetween(timings.DNSStart, timings.DNSDone),
+			ConnectMillis:      millisBetween(timings.ConnectStart, timings.ConnectDone),
+			TLSHandshakeMillis: millisBetween(timings.TLSStart, timings.TLSDone),
+			FirstByteMillis:    millisBetween(timings.Start, timings.FirstByte),
+			TotalMillis:        millisBetween(timings.Start, timings.Done),
+		},
+		ServerChain: []tlsReportCert{},
+	}
+
+	if state := resp.TLS; state != nil {
+		report.Connection = tlsReportConnection{
+			Version:            tlsVersionName(state.Version),
+			CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+			ServerName:         state.ServerName,
+			NegotiatedProtocol: state.NegotiatedProtocol,
+			DidResume:          state.DidResume,
+			ConnectionReused:   timings.Reused,
+		}
+		for _, cert := range state.PeerCertificates {
+			report.ServerChain = append(report.ServerChain, tlsReportCert{
+				Subject:     cert.Subject.String(),
+				Issuer:      cert.Issuer.String(),
+				Serial:      cert.SerialNumber.String(),
+				NotBefore:   cert.NotBefore,
+				NotAfter:    cert.NotAfter,
+				DNSNames:    cert.DNSNames,
+				Fingerprint: mtls.CertFingerprint(cert),
+			})
+		}
+	}
+	return report
+}
+
+// writeTLSReport writes the report as indented JSON.
+func writeTLSReport(path string, report tlsReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode TLS report: %w", err)
+	}
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write TLS report %s: %w", path, err)
+	}
+	logInfof("Wrote TLS report to %s", path)
+	return nil
+}
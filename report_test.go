@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestTLSReport(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.TLSReportFile = filepath.Join(t.TempDir(), "report.json")
+
+	if _, _, err := client.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(client.TLSReportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report tlsReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("Report is not valid JSON: %v", err)
+	}
+
+	if report.StatusCode != 200 {
+		t.Errorf("Expected status 200 in report, got %d", report.StatusCode)
+	}
+	if report.Connection.Version != "TLS 1.3" || report.Connection.CipherSuite == "" {
+		t.Errorf("Unexpected connection parameters: %+v", report.Connection)
+	}
+	serverCert, err := loadCertificate(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.ServerChain) != 1 || report.ServerChain[0].Fingerprint != mtls.CertFingerprint(serverCert) {
+		t.Errorf("Expected server chain with the server certificate, got %+v", report.ServerChain)
+	}
+	if report.Timing.TLSHandshakeMillis <= 0 || report.Timing.TotalMillis < report.Timing.TLSHandshakeMillis {
+		t.Errorf("Unexpected timing breakdown: %+v", report.Timing)
+	}
+
+	// The raw document uses the documented snake_case keys.
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"url", "timestamp", "status_code", "connection", "server_chain", "timing"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("Report missing key %q", key)
+		}
+	}
+}
+
+func TestTLSReportAndPinsOnlyAfterSuccess(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+tokenPath, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile) // GET is not allowed
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.TLSReportFile = filepath.Join(t.TempDir(), "report.json")
+	client.PrintPins = true
+
+	stdout, _ := captureOutput(t, func() {
+		if _, status, err := client.SendRequest(); err != nil || status != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405, got %d (%v)", status, err)
+		}
+	})
+	if strings.Contains(stdout, "pin-sha256") {
+		t.Errorf("Expected no pins after an error status, got:\n%s", stdout)
+	}
+	if _, err := os.Stat(client.TLSReportFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no TLS report after an error status, got %v", err)
+	}
+}
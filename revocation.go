@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Certificate Revocation Lists ---
+//
+// In the ca and both verify modes, CRLFile lists client certificates the CA has revoked. The CRL must
+// be signed by a CA in ClientCAFile; it is reloaded on SIGHUP and, with WatchCRL, when polling notices
+// that the file changed. Like the server certificate, a CRL that fails to load leaves the current one
+// in place. ca revoke adds certificates to the CRL of the playground CA (see ca.go).
+
+// defaultWatchCRL is how often the CRL file is polled for changes by default.
+const defaultWatchCRL = 5 * time.Second
+
+// crlStore holds the current CRL and the CAs it may be signed by.
+type crlStore struct {
+	file    string
+	cas     []*x509.Certificate
+	current atomic.Pointer[x509.RevocationList]
+}
+
+// loadCRLStore loads the CRL in crlFile, which must be signed by one of the CAs in caFile.
+func loadCRLStore(crlFile, caFile string) (*crlStore, error) {
+	cas, err := loadCertificates(caFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &crlStore{file: crlFile, cas: cas}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload replaces the current CRL with the one on disk, keeping the current CRL on error.
+func (s *crlStore) reload() error {
+	crl, err := loadCRL(s.file)
+	if err != nil {
+		return err
+	}
+	if err := checkCRLIssuer(crl, s.cas); err != nil {
+		return fmt.Errorf("CRL %s: %w", s.file, err)
+	}
+	if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+		logWarnf("CRL %s is stale: its next update was due at %s", s.file, crl.NextUpdate.Format(time.RFC3339))
+	}
+	s.current.Store(crl)
+	return nil
+}
+
+// revokedAt reports whether the CRL lists cert, and when it was revoked.
+func (s *crlStore) revokedAt(cert *x509.Certificate) (time.Time, bool) {
+	crl := s.current.Load()
+	if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+		return time.Time{}, false // Issued by another CA, which this CRL says nothing about
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return entry.RevocationTime, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// revocationCheck rejects client certificates listed on the CRL.
+func revocationCheck(crls *crlStore) CertCheck {
+	return CertCheck{Name: "revocation", Check: func(cert *x509.Certificate) error {
+		if revokedAt, revoked := crls.revokedAt(cert); revoked {
+			return fmt.Errorf("client certificate for CN '%s' (serial %s) was revoked at %s",
+				cert.Subject.CommonName, mtls.ColonHex(cert.SerialNumber.Bytes()), revokedAt.Format(time.RFC3339))
+		}
+		return nil
+	}}
+}
+
+// loadCRL reads a PEM ("X509 CRL") or DER encoded CRL.
+func loadCRL(crlFile string) (*x509.RevocationList, error) {
+	data, err := ioutil.ReadFile(crlFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CRL %s: %w", crlFile, err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("no PEM CRL found in %s", crlFile)
+		}
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CRL %s: %w", crlFile, err)
+	}
+	return crl, nil
+}
+
+// checkCRLIssuer verifies that the CRL is signed by one of the CAs.
+func checkCRLIssuer(crl *x509.RevocationList, cas []*x509.Certificate) error {
+	for _, ca := range cas {
+		if bytes.Equal(crl.RawIssuer, ca.RawSubject) && crl.CheckSignatureFrom(ca) == nil {
+			return nil
+		}
+	}
+	return errors.New("not signed by a trusted client CA")
+}
+
+// ReloadCRL re-reads CRLFile without restarting the server. On error the current CRL stays active.
+func (s *Server) ReloadCRL() error {
+	if s.crls == nil {
+		return errors.New("no CRL loaded")
+	}
+	if err := s.crls.reload(); err != nil {
+		return fmt.Errorf("failed to reload CRL: %w", err)
+	}
+	logInfof("Reloaded CRL %s (%d revoked certificates)", s.CRLFile, len(s.crls.current.Load().RevokedCertificateEntries))
+	return nil
+}
+
+// watchCRL reloads the CRL whenever the stamp of its file changes, until the server stops.
+func (s *Server) watchCRL() {
+	last, _ := statFileStamp(s.CRLFile)
+	ticker := time.NewTicker(s.WatchCRL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		stamp, err := statFileStamp(s.CRLFile)
+		if err != nil || (stamp.modTime.Equal(last.modTime) && stamp.size == last.size) {
+			continue
+		}
+		last = stamp
+		logInfof("CRL %s changed, reloading", s.CRLFile)
+		if err := s.ReloadCRL(); err != nil {
+			logErrorf("%v", err)
+		}
+	}
+}
+
+// revokeCertificates writes a new CRL signed by ca to crlFile listing the revoked certificates of the
+// existing CRL (if there is one) plus serials, and returns how many serials were newly added.
+func revokeCertificates(crlFile string, ca *x509.Certificate, caKey crypto.Signer, serials []*big.Int, validFor time.Duration) (int, error) {
+	var entries []x509.RevocationListEntry
+	number := big.NewInt(1)
+	if existing, err := loadCRL(crlFile); err == nil {
+		if err := checkCRLIssuer(existing, []*x509.Certificate{ca}); err != nil {
+			return 0, fmt.Errorf("existing CRL %s: %w", crlFile, err)
+		}
+		entries = existing.RevokedCertificateEntries
+		if existing.Number != nil {
+			number.Add(existing.Number, big.NewInt(1))
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+
+	now := time.Now()
+	added := 0
+	for _, serial := range serials {
+		listed := false
+		for _, entry := range entries {
+			if entry.SerialNumber.Cmp(serial) == 0 {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: now})
+			added++
+		}
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                now.Add(-time.Minute), // Tolerate small clock skew
+		NextUpdate:                now.Add(validFor),
+		RevokedCertificateEntries: entries,
+	}, ca, caKey)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create CRL: %w", err)
+	}
+	if err := ioutil.WriteFile(crlFile, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write CRL %s: %w", crlFile, err)
+	}
+	return added, nil
+}
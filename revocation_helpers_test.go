@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// --- Revocation Test Helpers ---
+//
+// testCA and fakeRevocationResponder let revocation checks be exercised deterministically:
+// the responder serves a freshly signed CRL at /crl and answers OCSP requests at /ocsp
+// with whatever status the test has configured for each serial number.
+
+// testCA is a throwaway certificate authority whose certificates can be revoked.
+type testCA struct {
+	Cert     *x509.Certificate
+	Key      crypto.Signer
+	CertFile string
+
+	// CRLURL and OCSPURL are embedded in certificates issued after they are set (see newFakeRevocationResponder).
+	CRLURL  string
+	OCSPURL string
+
+	mu         sync.Mutex
+	nextSerial int64
+}
+
+// newTestCA creates a CA certificate and writes it to dir/ca.crt.
+func newTestCA(t *testing.T, dir string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tls-playground test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "ca.crt")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{Cert: cert, Key: key, CertFile: certFile, nextSerial: 100}
+}
+
+// issue signs a client certificate for cn, writes it and its key, and returns the certificate.
+func (ca *testCA) issue(t *testing.T, cn, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.mu.Lock()
+	serial := big.NewInt(ca.nextSerial)
+	ca.nextSerial++
+	ca.mu.Unlock()
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(12 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if ca.CRLURL != "" {
+		template.CRLDistributionPoints = []string{ca.CRLURL}
+	}
+	if ca.OCSPURL != "" {
+		template.OCSPServer = []string{ca.OCSPURL}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate for %s: %v", cn, err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := writeCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// revocationStatus is the configured status of one serial number.
+type revocationStatus struct {
+	Status    int // ocsp.Good, ocsp.Revoked or ocsp.Unknown
+	RevokedAt time.Time
+}
+
+// fakeRevocationResponder is an HTTP CRL and OCSP responder for a testCA.
+// Serials without a configured status are reported as good.
+type fakeRevocationResponder struct {
+	ca  *testCA
+	URL string
+
+	mu           sync.Mutex
+	statuses     map[string]revocationStatus // serial (decimal) -> status
+	crlNumber    int64
+	crlRequests  int
+	ocspRequests int
+}
+
+// newFakeRevocationResponder starts a responder for ca and points ca's CRLURL and OCSPURL at it.
+// The responder is shut down when the test ends.
+func newFakeRevocationResponder(t *testing.T, ca *testCA) *fakeRevocationResponder {
+	t.Helper()
+	r := &fakeRevocationResponder{ca: ca, statuses: make(map[string]revocationStatus)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/crl", r.serveCRL)
+	mux.HandleFunc("/ocsp", r.serveOCSP)
+	mux.HandleFunc("/ocsp/", r.serveOCSP) // GET requests carry the request in the path
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	r.URL = server.URL
+	ca.CRLURL = server.URL + "/crl"
+	ca.OCSPURL = server.URL + "/ocsp"
+	return r
+}
+
+// SetStatus configures the status reported for a serial number.
+func (r *fakeRevocationResponder) SetStatus(serial *big.Int, status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := revocationStatus{Status: status}
+	if status == ocsp.Revoked {
+		entry.RevokedAt = time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	}
+	r.statuses[serial.String()] = entry
+}
+
+// Revoke marks the serial number as revoked.
+func (r *fakeRevocationResponder) Revoke(serial *big.Int) {
+	r.SetStatus(serial, ocsp.Revoked)
+}
+
+// CRLRequests returns how many CRLs the responder has served.
+func (r *fakeRevocationResponder) CRLRequests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.crlRequests
+}
+
+// OCSPRequests returns how many well-formed OCSP requests the responder has answered.
+func (r *fakeRevocationResponder) OCSPRequests() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ocspRequests
+}
+
+func (r *fakeRevocationResponder) status(serial *big.Int) revocationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.statuses[serial.String()]; ok {
+		return entry
+	}
+	return revocationStatus{Status: ocsp.Good}
+}
+
+// serveCRL signs a CRL listing every revoked serial.
+func (r *fakeRevocationResponder) serveCRL(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	r.crlRequests++
+	r.crlNumber++
+	var revoked []x509.RevocationListEntry
+	for serial, entry := range r.statuses {
+		if entry.Status != ocsp.Revoked {
+			continue
+		}
+		n, _ := new(big.Int).SetString(serial, 10)
+		revoked = append(revoked, x509.RevocationListEntry{SerialNumber: n, RevocationTime: entry.RevokedAt})
+	}
+	number := big.NewInt(r.crlNumber)
+	r.mu.Unlock()
+
+	now := time.Now()
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    number,
+		ThisUpdate:                now.Add(-time.Minute),
+		NextUpdate:                now.Add(time.Hour),
+		RevokedCertificateEntries: revoked,
+	}, r.ca.Cert, r.ca.Key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(crl)
+}
+
+// serveOCSP answers an OCSP request sent by POST or by GET (base64 in the path, RFC 6960 appendix A.1).
+func (r *fakeRevocationResponder) serveOCSP(w http.ResponseWriter, req *http.Request) {
+	var raw []byte
+	var err error
+	switch req.Method {
+	case http.MethodPost:
+		raw, err = ioutil.ReadAll(req.Body)
+	case http.MethodGet:
+		var encoded string
+		if encoded, err = url.PathUnescape(strings.TrimPrefix(req.URL.Path, "/ocsp/")); err == nil {
+			raw, err = base64.StdEncoding.DecodeString(encoded)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "bad OCSP request", http.StatusBadRequest)
+		return
+	}
+	ocspReq, err := ocsp.ParseRequest(raw)
+	if err != nil {
+		w.Write(ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	r.mu.Lock()
+	r.ocspRequests++
+	r.mu.Unlock()
+	entry := r.status(ocspReq.SerialNumber)
+	now := time.Now().UTC().Truncate(time.Minute)
+	resp, err := ocsp.CreateResponse(r.ca.Cert, r.ca.Cert, ocsp.Response{
+		Status:       entry.Status,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(time.Hour),
+		RevokedAt:    entry.RevokedAt,
+	}, r.ca.Key)
+	if err != nil {
+		w.Write(ocsp.InternalErrorErrorResponse)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+// queryOCSP asks the responder at cert's OCSP server about cert, via POST.
+func queryOCSP(t *testing.T, cert, issuer *x509.Certificate) *ocsp.Response {
+	t.Helper()
+	if len(cert.OCSPServer) == 0 {
+		t.Fatal("Certificate has no OCSP server")
+	}
+	reqDER, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpResp, err := http.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(reqDER))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpResp.Body.Close()
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		t.Fatalf("Failed to parse OCSP response: %v", err)
+	}
+	return resp
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestFakeRevocationResponderOCSP(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	responder := newFakeRevocationResponder(t, ca)
+	good := ca.issue(t, "good_client", filepath.Join(dir, "good.crt"), filepath.Join(dir, "good.key"))
+	revoked := ca.issue(t, "revoked_client", filepath.Join(dir, "revoked.crt"), filepath.Join(dir, "revoked.key"))
+	unknown := ca.issue(t, "unknown_client", filepath.Join(dir, "unknown.crt"), filepath.Join(dir, "unknown.key"))
+
+	if resp := queryOCSP(t, revoked, ca.Cert); resp.Status != ocsp.Good {
+		t.Fatalf("Expected good status before revocation, got %d", resp.Status)
+	}
+	responder.Revoke(revoked.SerialNumber)
+	responder.SetStatus(unknown.SerialNumber, ocsp.Unknown)
+
+	if resp := queryOCSP(t, good, ca.Cert); resp.Status != ocsp.Good {
+		t.Errorf("Expected good status, got %d", resp.Status)
+	}
+	if resp := queryOCSP(t, revoked, ca.Cert); resp.Status != ocsp.Revoked || resp.RevokedAt.IsZero() {
+		t.Errorf("Expected revoked status with a revocation time, got %d at %v", resp.Status, resp.RevokedAt)
+	}
+	if resp := queryOCSP(t, unknown, ca.Cert); resp.Status != ocsp.Unknown {
+		t.Errorf("Expected unknown status, got %d", resp.Status)
+	}
+	if n := responder.OCSPRequests(); n != 4 {
+		t.Errorf("Expected 4 OCSP requests, got %d", n)
+	}
+}
+
+func TestFakeRevocationResponderCRL(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	responder := newFakeRevocationResponder(t, ca)
+	good := ca.issue(t, "good_client", filepath.Join(dir, "good.crt"), filepath.Join(dir, "good.key"))
+	revoked := ca.issue(t, "revoked_client", filepath.Join(dir, "revoked.crt"), filepath.Join(dir, "revoked.key"))
+	responder.Revoke(revoked.SerialNumber)
+
+	if len(revoked.CRLDistributionPoints) != 1 {
+		t.Fatalf("Expected the CRL distribution point to be embedded, got %v", revoked.CRLDistributionPoints)
+	}
+	resp, err := http.Get(revoked.CRLDistributionPoints[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	der, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crl.CheckSignatureFrom(ca.Cert); err != nil {
+		t.Fatalf("Expected the CRL to be signed by the CA: %v", err)
+	}
+
+	listed := map[string]bool{}
+	for _, entry := range crl.RevokedCertificateEntries {
+		listed[entry.SerialNumber.String()] = true
+	}
+	if !listed[revoked.SerialNumber.String()] || listed[good.SerialNumber.String()] {
+		t.Errorf("Expected only the revoked serial on the CRL, got %v", listed)
+	}
+}
+
+func TestServerRejectsRevokedClientCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	caCert, caKey, crlFile := filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"), filepath.Join(dir, "ca.crl")
+	clientCert, clientKey := filepath.Join(dir, "ca-client.crt"), filepath.Join(dir, "ca-client.key")
+	captureOutput(t, func() {
+		if err := (&CAInitCmd{OutDir: dir, CN: "test CA", KeyType: keyTypeECDSA}).Run(); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&CAIssueCmd{Kind: "client", OutDir: dir, CACert: caCert, CAKey: caKey, KeyType: keyTypeECDSA}).Run(); err != nil {
+			t.Fatal(err)
+		}
+		if err := (&CARevokeCmd{CRL: crlFile, CACert: caCert, CAKey: caKey, ValidFor: time.Hour}).Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	_, url := startTestServer(t, pki, func(s *Server) {
+		s.VerifyMode = verifyModeCA
+		s.ClientCAFile = caCert
+		s.CRLFile = crlFile
+		s.WatchCRL = 20 * time.Millisecond
+	})
+	send := func() error {
+		client, err := NewClient(url+"/hello", pki.ServerCertFile, clientCert, clientKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = client.SendRequest()
+		return err
+	}
+	if err := send(); err != nil {
+		t.Fatalf("Expected the client to be accepted before revocation: %v", err)
+	}
+
+	captureOutput(t, func() {
+		if err := (&CARevokeCmd{Certs: []string{clientCert}, CRL: crlFile, CACert: caCert, CAKey: caKey, ValidFor: time.Hour}).Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	deadline := time.Now().Add(5 * time.Second)
+	for send() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the server to reject the revoked certificate")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestCRLMustBeSignedByClientCA(t *testing.T) {
+	dir, other := t.TempDir(), t.TempDir()
+	captureOutput(t, func() {
+		for _, d := range []string{dir, other} {
+			if err := (&CAInitCmd{OutDir: d, CN: "test CA", KeyType: keyTypeECDSA}).Run(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		revoke := &CARevokeCmd{CRL: filepath.Join(other, "ca.crl"), CACert: filepath.Join(other, "ca.crt"), CAKey: filepath.Join(other, "ca.key"), ValidFor: time.Hour}
+		if err := revoke.Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if _, err := loadCRLStore(filepath.Join(other, "ca.crl"), filepath.Join(dir, "ca.crt")); err == nil {
+		t.Error("Expected a CRL signed by another CA with the same name to be rejected")
+	}
+	if _, err := loadCRLStore(filepath.Join(other, "ca.crl"), filepath.Join(other, "ca.crt")); err != nil {
+		t.Errorf("Expected the CRL to load with its own CA: %v", err)
+	}
+}
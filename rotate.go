@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Client Certificate Rotation Test ---
+
+// RotateTestCmd defines the kong command that exercises a full client certificate rotation.
+// It runs its own server instance against a scratch copy of the known clients file,
+// so the configured files are never modified.
+type RotateTestCmd struct {
+	CertFile       string `kong:"name='cert',help='Current client certificate file.',default='certs/client.crt',type='path'"`
+	KeyFile        string `kong:"name='key',help='Current client private key file.',default='certs/client.key',type='path'"`
+	ServerCertFile string `kong:"name='server-cert',help='Server certificate file.',default='certs/server.crt',type='path'"`
+	ServerKeyFile  string `kong:"name='server-key',help='Server private key file.',default='certs/server.key',type='path'"`
+	KnownClients   string `kong:"name='known-clients',help='Known clients file to start the rotation from.',default='certs/knownClients.txt',type='path'"`
+	Addr           string `kong:"name='addr',help='Address for the temporary test server; port 0 picks a free one.',default='localhost:8445'"`
+}
+
+// Run performs the rotation steps and reports the outcome of each one.
+func (r *RotateTestCmd) Run() error {
+	results, err := runRotationTest(r)
+	for _, res := range results {
+		status := "PASS"
+		if res.Err != nil {
+			status = "FAIL"
+		}
+		outputf("[%s] %s\n", status, res.Step)
+		if res.Err != nil {
+			outputf("       %v\n", res.Err)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("rotation test failed: %w", err)
+	}
+	outputf("Rotation test successful.\n")
+	return nil
+}
+
+// rotationStep records the outcome of a single step of the rotation test.
+type rotationStep struct {
+	Step string
+	Err  error
+}
+
+// runRotationTest generates a replacement client certificate and walks it through the
+// add -> verify -> remove old -> verify rejection workflow. It stops at the first failing step.
+func runRotationTest(r *RotateTestCmd) ([]rotationStep, error) {
+	var steps []rotationStep
+	step := func(name string, err error) error {
+		steps = append(steps, rotationStep{Step: name, Err: err})
+		return err
+	}
+
+	oldCert, err := loadCertificate(r.CertFile)
+	if err := step("Load current client certificate", err); err != nil {
+		return steps, err
+	}
+	cn := oldCert.Subject.CommonName
+	oldFingerprint := mtls.CertFingerprint(oldCert)
+
+	workDir, err := ioutil.TempDir("", "rotate-test-")
+	if err != nil {
+		return steps, fmt.Errorf("failed to create working directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	knownClientsFile := filepath.Join(workDir, "knownClients.txt")
+	content, err := ioutil.ReadFile(r.KnownClients)
+	if err == nil {
+		err = ioutil.WriteFile(knownClientsFile, content, 0644)
+	}
+	if err := step("Copy known clients file", err); err != nil {
+		return steps, err
+	}
+
+	server := NewServer(r.Addr, r.ServerCertFile, r.ServerKeyFile, knownClientsFile)
+	err = server.Start() // Returns once the listener is bound
+	if err == nil {
+		defer server.Stop()
+	}
+	if err := step(fmt.Sprintf("Start test server on %s", r.Addr), err); err != nil {
+		return steps, err
+	}
+
+	url := fmt.Sprintf("https://%s/hello", dialAddr(server.ListenAddr())) // Addr may have port 0
+	if err := step(fmt.Sprintf("Current certificate accepted (CN='%s')", cn), expectAccepted(url, r.ServerCertFile, r.CertFile, r.KeyFile)); err != nil {
+		return steps, err
+	}
+
+	newCertFile := filepath.Join(workDir, "client.crt")
+	newKeyFile := filepath.Join(workDir, "client.key")
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: cn})
+	if err == nil {
+		err = writeCertFiles(newCertFile, newKeyFile, certPEM, keyPEM)
+	}
+	if err := step("Generate new client certificate with the same CN", err); err != nil {
+		return steps, err
+	}
+	newCert, err := loadCertificate(newCertFile)
+	if err != nil {
+		return steps, err
+	}
+	newFingerprint := mtls.CertFingerprint(newCert)
+
+	err = mtls.AppendKnownClient(knownClientsFile, cn, newFingerprint)
+	if err == nil {
+		err = server.ReloadKnownClients()
+	}
+	if err := step("Add new fingerprint and reload known clients", err); err != nil {
+		return steps, err
+	}
+
+	if err := step("New certificate accepted", expectAccepted(url, r.ServerCertFile, newCertFile, newKeyFile)); err != nil {
+		return steps, err
+	}
+	if err := step("Old certificate still accepted during overlap", expectAccepted(url, r.ServerCertFile, r.CertFile, r.KeyFile)); err != nil {
+		return steps, err
+	}
+
+	err = mtls.RemoveKnownClient(knownClientsFile, cn, oldFingerprint)
+	if err == nil {
+		err = server.ReloadKnownClients()
+	}
+	if err := step("Remove old fingerprint and reload known clients", err); err != nil {
+		return steps, err
+	}
+
+	if err := step("Old certificate rejected", expectRejected(url, r.ServerCertFile, r.CertFile, r.KeyFile)); err != nil {
+		return steps, err
+	}
+	if err := step("New certificate still accepted", expectAccepted(url, r.ServerCertFile, newCertFile, newKeyFile)); err != nil {
+		return steps, err
+	}
+
+	return steps, nil
+}
+
+// expectAccepted connects with the given client identity and expects a successful response.
+func expectAccepted(url, serverCertFile, certFile, keyFile string) error {
+	client, err := NewClient(url, serverCertFile, certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	_, status, err := client.SendRequest()
+	if err != nil {
+		return err
+	}
+	if status < 200 || status > 299 {
+		return fmt.Errorf("expected a successful response, got status %d", status)
+	}
+	return nil
+}
+
+// expectRejected connects with the given client identity and expects the server to refuse it, either
+// with a TLS alert during the handshake or with 401/403. Any other failure, such as the server being
+// unreachable, fails the step rather than passing as a rejection.
+func expectRejected(url, serverCertFile, certFile, keyFile string) error {
+	client, err := NewClient(url, serverCertFile, certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	_, status, err := client.SendRequest()
+	switch {
+	case err != nil && isTLSRejection(err):
+		logInfof("Request rejected as expected: %v", err)
+		return nil
+	case err != nil:
+		return fmt.Errorf("expected a TLS rejection, got: %w", err)
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		logInfof("Request rejected as expected with status %d", status)
+		return nil
+	}
+	return fmt.Errorf("request succeeded with status %d but the certificate should have been rejected", status)
+}
+
+// isTLSRejection reports whether err is a TLS alert. The client failing to verify the server is not a
+// rejection of the client certificate, so a broken server trust setup fails the step instead.
+func isTLSRejection(err error) bool {
+	var alertErr tls.AlertError
+	var opErr *net.OpError
+	if errors.As(err, &alertErr) {
+		return true
+	}
+	// Alerts received from the server are wrapped in a "remote error" OpError around an unexported type.
+	return errors.As(err, &opErr) && opErr.Op == "remote error"
+}
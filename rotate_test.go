@@ -0,0 +1,84 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestRotationTest(t *testing.T) {
+	pki := newTestPKI(t)
+	cmd := &RotateTestCmd{
+		CertFile:       pki.ClientCertFile,
+		KeyFile:        pki.ClientKeyFile,
+		ServerCertFile: pki.ServerCertFile,
+		ServerKeyFile:  pki.ServerKeyFile,
+		KnownClients:   pki.KnownClientsFile,
+		Addr:           freeAddr(t),
+	}
+
+	steps, err := runRotationTest(cmd)
+	for _, s := range steps {
+		t.Logf("%s: %v", s.Step, s.Err)
+	}
+	if err != nil {
+		t.Fatalf("Rotation test failed: %v", err)
+	}
+	if len(steps) != 11 {
+		t.Errorf("Expected 11 steps, got %d", len(steps))
+	}
+}
+
+func TestExpectRejected(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	url := baseURL + "/hello"
+
+	unknownCert := filepath.Join(pki.Dir, "unknown.crt")
+	unknownKey := filepath.Join(pki.Dir, "unknown.key")
+	pki.newClientCert(t, "intruder", unknownCert, unknownKey)
+	if err := expectRejected(url, pki.ServerCertFile, unknownCert, unknownKey); err != nil {
+		t.Errorf("Expected the unknown client to count as rejected, got %v", err)
+	}
+	if err := expectRejected(url, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile); err == nil {
+		t.Error("Expected an accepted client to fail the check")
+	}
+	if err := expectRejected("https://"+freeAddr(t)+"/hello", pki.ServerCertFile, unknownCert, unknownKey); err == nil {
+		t.Error("Expected an unreachable server not to count as a rejection")
+	}
+	// Trusting the wrong server certificate fails on the client side, which is not a rejection.
+	if err := expectRejected(url, pki.ClientCertFile, unknownCert, unknownKey); err == nil {
+		t.Error("Expected a server the client can't verify not to count as a rejection")
+	}
+}
+
+func TestKnownClientsMultipleFingerprints(t *testing.T) {
+	pki := newTestPKI(t)
+	store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, pki.ClientCN, "aa:bb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	entries, ok := store.Lookup(pki.ClientCN)
+	if !ok || len(entries) != 2 || entries[1].Fingerprint != "AA:BB" {
+		t.Fatalf("Expected two fingerprints after append, got %v", entries)
+	}
+
+	if err := mtls.RemoveKnownClient(pki.KnownClientsFile, pki.ClientCN, entries[0].Fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = store.Lookup(pki.ClientCN)
+	if len(entries) != 1 || entries[0].Fingerprint != "AA:BB" {
+		t.Fatalf("Expected only the new fingerprint after removal, got %v", entries)
+	}
+}
@@ -1,17 +1,22 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"slices"
+	"sync"
 	"time"
+
+	"google.golang.org/grpc"
+
+	"tls-playground/pkg/mtls"
 )
 
 // --- Server Implementation ---
@@ -23,120 +28,905 @@ type Server struct {
 	// CaFile           string // No longer needed
 	KnownClientsFile string
 
-	httpServer *http.Server
+	// Mode is serverModeHTTPS (the default), serverModeTCP, which echoes lines over raw mTLS
+	// connections instead of serving HTTP (see echo.go), serverModeGRPC (see grpc.go), or
+	// serverModeTunnel, which forwards raw mTLS connections to TunnelTarget (see tunnel.go).
+	Mode string
+	// TunnelTarget is the host:port serverModeTunnel forwards connections to. TunnelAllowlistFile instead
+	// lets clients pick destinations, among those it allows them (see socks.go).
+	TunnelTarget        string
+	TunnelAllowlistFile string
+
+	// KnownClients, if set, replaces the known clients file (KnownClientsFile and its options are then
+	// ignored) with another backend, see mtls.KnownClientsStore.
+	KnownClients mtls.KnownClientsStore
+
+	// VerifyMode selects how client certificates are authenticated: against the known clients file
+	// (verifyModeFingerprint, the default), by chaining to ClientCAFile (verifyModeCA), or both.
+	VerifyMode string
+	// ClientCAFile is the PEM bundle of CAs trusted to issue client certificates in the ca and both modes.
+	ClientCAFile string
+	// CRLFile, in the ca and both modes, is a CRL signed by a client CA; certificates it lists are rejected.
+	CRLFile string
+	// WatchCRL, if set, polls CRLFile at this interval and reloads it when it changes.
+	WatchCRL time.Duration
+	// DeniedClientsFile lists certificate fingerprints, public keys and CNs rejected before any other check,
+	// in every verify mode (see denylist.go).
+	DeniedClientsFile string
+	// WatchDeniedClients, if set, polls DeniedClientsFile at this interval and reloads it when it changes.
+	WatchDeniedClients time.Duration
+
+	// AllowedSignatureAlgorithms restricts which algorithms client certificates may be signed with.
+	// Empty accepts any algorithm Go can parse.
+	AllowedSignatureAlgorithms []x509.SignatureAlgorithm
+	// MaxClientCertLifetime rejects client certificates whose total validity period (NotAfter - NotBefore)
+	// is longer than this, even if they are currently valid. 0 disables the check.
+	MaxClientCertLifetime time.Duration
+	// RequiredClientEKUs rejects client certificates that don't list each of these extended key usages
+	// (or the any usage). Empty accepts certificates issued for any purpose.
+	RequiredClientEKUs []x509.ExtKeyUsage
+	// ClientNamePatterns, if set, requires the CN and every DNS SAN of client certificates to match one
+	// of these globs, and ClientIssuerPatterns the CN or DN of their issuer.
+	ClientNamePatterns   []string
+	ClientIssuerPatterns []string
+	// VerifyAudit runs every client certificate check and logs all failures instead of stopping at the first.
+	VerifyAudit bool
+	// TOFU adds clients whose CN has no known clients entry to the store on their first connection
+	// (trust on first use, see tofu.go). TOFUApproval, which implies TOFU, holds them until they are
+	// approved on the admin API instead.
+	TOFU         bool
+	TOFUApproval bool
+	// AdminCNs lists the clients allowed to use the /admin/ endpoints, by client ID: the CN, or the SAN
+	// ClientIDSource selects, since only that is vouched for by the known clients file.
+	AdminCNs []string
+	// PprofCNs enables the /debug/pprof/ endpoints for the listed client IDs, like AdminCNs (see pprof.go).
+	PprofCNs []string
+	// Strict turns configuration problems that are otherwise only logged (such as malformed
+	// known clients lines) into errors.
+	Strict bool
+	// MaxKnownClientsAge warns, or with Strict fails the load/reload, if the known clients file
+	// hasn't been modified for longer than this. 0 disables the check.
+	MaxKnownClientsAge time.Duration
+	// MaxKnownClients fails loading (and reloading) a known clients file with more entries than this. 0 means no limit.
+	MaxKnownClients int
+	// CaseInsensitiveCN matches client CNs against the known clients file ignoring case.
+	CaseInsensitiveCN bool
+	// ClientIDSource selects what identifies clients in the known clients file: the CN (the default) or a
+	// DNS, URI (e.g. a SPIFFE ID) or email SAN, see mtls.IdentitySource.
+	ClientIDSource mtls.IdentitySource
+	// DegradeOnReloadFailure answers 503 with Retry-After after a failed known clients reload
+	// until a reload succeeds; the reload is retried every ReloadRetryInterval (see degraded.go).
+	DegradeOnReloadFailure bool
+	ReloadRetryInterval    time.Duration
+	// WatchKnownClients, if set, polls the known clients file at this interval and reloads it
+	// when it changes (see reload.go). 0 disables watching; SIGHUP still triggers a reload.
+	WatchKnownClients time.Duration
+	// WatchServerCert, if set, polls CertFile and KeyFile at this interval and reloads the key pair
+	// when either changes (see servercert.go). 0 disables watching; SIGHUP still triggers a reload.
+	WatchServerCert time.Duration
+	// SessionTicketsDisabled turns off TLS session resumption, so every connection does a full handshake.
+	SessionTicketsDisabled bool
+	// OCSPStapleFile, OCSPFetch and OCSPResponderURL staple an OCSP response for the server certificate
+	// into handshakes, read from a DER file or fetched from the certificate's (or the given) responder;
+	// see ocsp.go. OCSPIssuerFile is the issuer the response is requested for and verified against.
+	OCSPStapleFile   string
+	OCSPFetch        bool
+	OCSPResponderURL string
+	OCSPIssuerFile   string
+	// OCSPRefresh is how often the stapled response is re-read or re-fetched.
+	OCSPRefresh time.Duration
+	// SNICertificates are presented instead of the CertFile/KeyFile pair to clients asking for one of
+	// their hosts in SNI; see sni.go.
+	SNICertificates []SNICertificate
+	// Tenants are hosted on the same address with their own server certificate and known clients, picked
+	// by SNI; see tenants.go.
+	Tenants []Tenant
+	// SPIFFESocket, if set, is the address of a SPIFFE Workload API. The server presents the X.509 SVID
+	// it streams instead of the CertFile/KeyFile pair, and with VerifyMode ca or both its trust bundle
+	// replaces ClientCAFile unless that is set; see spiffe.go.
+	SPIFFESocket string
+	// KubeTLSSecret and KubeKnownClientsSecret, if set, name Kubernetes secrets read through the API with
+	// the pod's service account instead of CertFile/KeyFile (the tls.crt and tls.key keys) and
+	// KnownClientsFile (the KubeKnownClientsKey key, knownClients.txt by default); see k8s.go.
+	// KubeNamespace defaults to the pod's namespace. The secrets are checked for changes every KubePoll.
+	KubeTLSSecret          string
+	KubeKnownClientsSecret string
+	KubeKnownClientsKey    string
+	KubeNamespace          string
+	KubePoll               time.Duration
+	// ExpiryWarnDays logs a warning at startup when the server certificate expires within this many
+	// days. 0 disables the warning.
+	ExpiryWarnDays int
+	// StrictExpiry refuses to start with a server certificate outside its validity period instead of
+	// only logging it. Expired client certificates are rejected either way.
+	StrictExpiry bool
+	// ShutdownTimeout is how long Stop waits for in-flight requests before closing their connections.
+	ShutdownTimeout time.Duration
+	// SocketMode and SocketGroup set the permissions and group of the socket file when Addr is a
+	// unix:// address (see unixsocket.go). SocketMode 0 means 0600.
+	SocketMode  os.FileMode
+	SocketGroup string
+	// DiagAddr, if set, serves rejection diagnostics over plain HTTP (no client certificate needed).
+	DiagAddr string
+	// AdminAddr, if set, serves the known clients admin API over plain HTTP (see admin.go).
+	// It must be a loopback address unless AdminAllowRemote is set, which needs AdminTokenFile.
+	AdminAddr        string
+	AdminAllowRemote bool
+	// AdminTokenFile, if set, holds a token every admin API request must send as Authorization: Bearer.
+	AdminTokenFile string
+	// MetricsAddr, if set, serves Prometheus metrics at /metrics over plain HTTP (see metrics.go).
+	MetricsAddr string
+	// ExtraAddrs are further addresses to listen on besides Addr, served alike (see listeners.go).
+	ExtraAddrs []string
+	// MaxConns limits the connections open at once across all listeners, and MaxConnsPerIP those from
+	// one client IP; 0 means no limit. ConnLimitPolicy is "refuse" or "queue" and decides what happens
+	// to connections over MaxConns (see connlimit.go).
+	MaxConns        int
+	MaxConnsPerIP   int
+	ConnLimitPolicy string
+	// HTTPAddr, if set, redirects plain-HTTP requests to the HTTPS listener, explaining that a client
+	// certificate is needed; with HTTPNoRedirect it only explains (see httpredirect.go).
+	HTTPAddr       string
+	HTTPNoRedirect bool
+
+	// LogJA3 logs an (approximated, see ja3.go) JA3 fingerprint of every ClientHello.
+	LogJA3 bool
+	// ALPN lists the protocols offered during the handshake, in order of preference. Empty offers
+	// h2 and http/1.1 in serverModeHTTPS and nothing in serverModeTCP (see alpn.go).
+	ALPN []string
+	// Handler, if set, serves the requests the playground's own endpoints don't, in place of the hello
+	// handler. Otherwise BackendURL, if set, proxies them to an HTTP backend (see proxy.go).
+	Handler    http.Handler
+	BackendURL string
+	// TLSVersions restricts the TLS versions and cipher suites clients may negotiate (see tlsversions.go).
+	TLSVersions TLSVersions
+	// TLSDebug logs the ClientHello and the negotiated parameters of every handshake (see tlsdebug.go).
+	TLSDebug bool
+	// TLSKeyLogFile, if set, receives the TLS session secrets in NSS key log format, e.g. for Wireshark.
+	TLSKeyLogFile string
+	// TokenTTL is how long certificate-bound tokens issued at /token are valid.
+	TokenTTL time.Duration
+	// RateLimit, if > 0, limits each client CN to this many HTTPS requests per second, with bursts of up to
+	// RateBurst (0: one second's worth). Known clients entries can override it per client (see ratelimit.go).
+	RateLimit float64
+	RateBurst int
+
+	// DecisionLogFile, if set, receives every auth decision as a JSON line, including handshakes that failed
+	// before verification (see audit.go). DecisionLogMaxSize, if > 0, rotates it before it grows past this many
+	// bytes, keeping DecisionLogMaxBackups old files. SIGHUP reopens it, for rotation by an external tool.
+	DecisionLogFile       string
+	DecisionLogMaxSize    int64
+	DecisionLogMaxBackups int
+	// DecisionSinks receive every auth decision asynchronously, alongside the decision log (see sinks.go).
+	DecisionSinks []DecisionSink
+	// SinkWorkers and SinkQueue size the worker pool feeding the decision sinks.
+	SinkWorkers int
+	SinkQueue   int
+	// SinkPolicy is "drop" or "block" and decides what happens when the sink queue is full.
+	SinkPolicy string
+
+	httpServer    *http.Server
+	echo          *echoServer  // Set instead of httpServer in serverModeTCP
+	grpcServer    *grpc.Server // Set instead of httpServer in serverModeGRPC
+	knownClients  mtls.KnownClientsStore
+	nonces        *nonceStore
+	rateLimiter   *clientRateLimiter
+	decisions     *eventHub[authDecision]
+	clientChanges *eventHub[knownClientsChange] // Known clients changes, see clientswatch.go
+	rejections    *rejectionLog
+	diagServer    *http.Server
+	adminServer   *http.Server
+	adminToken    string     // Loaded from AdminTokenFile
+	adminMu       sync.Mutex // Serializes admin API changes to the known clients store
+	metrics       *serverMetrics
+	metricsServer *http.Server
+	conns         *connTracker
+	plainServer   *http.Server // Set when HTTPAddr is
+	serverCert    *serverCertificate
+	sniCerts      *sniCertificates // Set when SNICertificates is
+	tenants       *tenantSet       // Set when Tenants is
+	crls          *crlStore        // Set when CRLFile is
+	denied        *deniedClients   // Set when DeniedClientsFile is
+	tunnelAllow   *tunnelAllowlist // Set when TunnelAllowlistFile is
+	tofu          *tofuTrust       // Set when TOFU or TOFUApproval is
+	spiffe        *spiffeSource    // Set when SPIFFESocket is
+	kubeSecrets   *kubeSecrets     // Set when KubeTLSSecret or KubeKnownClientsSecret is
+	keyLog        *os.File
+	sinks         *sinkPool
+	decisionLog   *decisionLogSink    // Set when DecisionLogFile is, also fed through sinks
+	rejectedConns *recordedRejections // See recordHandshakeFailure
+	tokenKey      []byte
+	degraded      degradedState
+	ready         chan struct{} // Closed once the listener is bound
+	boundAddrs    []string      // Set before ready is closed, see ListenAddrs
+	stopped       chan struct{} // Closed by Stop
+	stopOnce      sync.Once
+
+	tlsMu     sync.Mutex
+	tlsConfig *tls.Config // Built once by serverTLSConfig, shared by HTTPS and ServerConn
 }
 
 // NewServer creates a new server instance.
 func NewServer(addr, certFile, keyFile, knownClientsFile string) *Server {
+	metrics := newServerMetrics()
 	return &Server{
 		Addr:     addr,
 		CertFile: certFile,
 		KeyFile:  keyFile,
 		// CaFile:           caFile, // Removed
-		KnownClientsFile: knownClientsFile,
+		KnownClientsFile:      knownClientsFile,
+		Mode:                  serverModeHTTPS,
+		VerifyMode:            verifyModeFingerprint,
+		nonces:                newNonceStore(),
+		rateLimiter:           newClientRateLimiter(),
+		rejectedConns:         newRecordedRejections(),
+		decisions:             newEventHub[authDecision](),
+		clientChanges:         newEventHub[knownClientsChange](),
+		rejections:            newRejectionLog(rejectionLogSize, rejectionTTL),
+		metrics:               metrics,
+		conns:                 newConnTracker(metrics),
+		SinkWorkers:           defaultSinkWorkers,
+		SinkQueue:             defaultSinkQueue,
+		SinkPolicy:            sinkPolicyDrop,
+		ConnLimitPolicy:       connLimitRefuse,
+		DecisionLogMaxBackups: defaultDecisionLogMaxBackups,
+		TokenTTL:              defaultTokenTTL,
+		ReloadRetryInterval:   defaultReloadRetryInterval,
+		WatchServerCert:       defaultWatchServerCert,
+		WatchCRL:              defaultWatchCRL,
+		WatchDeniedClients:    defaultWatchDeniedClients,
+		KubePoll:              defaultKubePoll,
+		OCSPRefresh:           defaultOCSPRefresh,
+		ExpiryWarnDays:        defaultExpiryWarnDays,
+		ShutdownTimeout:       defaultShutdownTimeout,
+		ready:                 make(chan struct{}),
+		stopped:               make(chan struct{}),
 	}
 }
 
-// Start initializes and starts the HTTPS server in a goroutine.
+const (
+	defaultShutdownTimeout = 5 * time.Second
+	defaultWatchServerCert = 5 * time.Second
+	defaultKubePoll        = 10 * time.Second
+)
+
+// Start binds the listeners and serves HTTPS in a goroutine.
 func (s *Server) Start() error {
-	log.Println("Configuring server TLS for self-signed client verification...")
-	tlsConfig, err := createServerTLSConfig(s.KnownClientsFile) // Pass only knownClientsFile
+	if err := s.validateListenAddrs(); err != nil {
+		return err
+	}
+	if s.Mode != serverModeHTTPS && s.Mode != serverModeTCP && s.Mode != serverModeGRPC && s.Mode != serverModeTunnel {
+		return fmt.Errorf("unknown server mode %q (want %s, %s, %s or %s)", s.Mode, serverModeHTTPS, serverModeTCP, serverModeGRPC, serverModeTunnel)
+	}
+	if s.Mode == serverModeTunnel && (s.TunnelTarget == "") == (s.TunnelAllowlistFile == "") {
+		return fmt.Errorf("--mode %s needs either a --tunnel-target to forward connections to or a --tunnel-allowlist", serverModeTunnel)
+	}
+	if s.Mode != serverModeTunnel && (s.TunnelTarget != "" || s.TunnelAllowlistFile != "") {
+		return fmt.Errorf("--tunnel-target and --tunnel-allowlist require --mode %s", serverModeTunnel)
+	}
+	if s.TunnelAllowlistFile != "" {
+		allowlist, err := loadTunnelAllowlist(s.TunnelAllowlistFile)
+		if err != nil {
+			return err
+		}
+		s.tunnelAllow = allowlist
+		logInfof("Loaded %d tunnel allowlist rules from %s", len(s.tunnelAllow.rules), s.TunnelAllowlistFile)
+	}
+	if err := s.loadKubeSecrets(); err != nil {
+		return err
+	}
+	tlsConfig, err := s.serverTLSConfig()
+	if err != nil {
+		return err
+	}
+	if s.tokenKey, err = newTokenKey(); err != nil {
+		return err
+	}
+	if s.OCSPStapleFile != "" && (s.OCSPFetch || s.OCSPResponderURL != "") {
+		return errors.New("staple an OCSP response from a file or from a responder, not both")
+	}
+	if s.ocspStapling() {
+		if err := s.refreshOCSPStaple(); err != nil {
+			if s.OCSPStapleFile != "" {
+				return fmt.Errorf("failed to staple OCSP response: %w", err)
+			}
+			logWarnf("Failed to fetch an OCSP response to staple, retrying in %s: %v", s.OCSPRefresh, err) // Responders go down
+		}
+	}
+	var app http.Handler
+	if s.Mode == serverModeHTTPS {
+		if app, err = s.appHandler(); err != nil {
+			return err
+		}
+	}
+
+	limiter, err := s.newConnLimiter()
 	if err != nil {
-		return fmt.Errorf("failed to create server TLS config: %w", err)
+		return err
+	}
+
+	// Bind synchronously so address errors are returned here and the server is reachable once Start returns
+	listeners, err := s.listenAll()
+	if err != nil {
+		return err
+	}
+	for _, listener := range listeners {
+		s.boundAddrs = append(s.boundAddrs, listener.Addr().String())
+	}
+	if limiter != nil {
+		listeners = limiter.listeners(listeners)
 	}
 
 	// Create HTTP server
-	s.httpServer = &http.Server{
-		Addr:      s.Addr,
-		TLSConfig: tlsConfig,
-		Handler:   http.HandlerFunc(helloHandler), // Use the handler defined below
+	if s.Mode == serverModeHTTPS {
+		s.httpServer = &http.Server{
+			Addr:      s.Addr,
+			TLSConfig: tlsConfig,
+			Handler:   s.rejectWhenDegraded(s.routes(app)),
+			ErrorLog:  newHandshakeErrorLogger(s), // Also records failed TLS handshakes
+			ConnState: s.connState,
+		}
+		if !s.TLSVersions.allowsHTTP2() {
+			// ServeTLS would otherwise fail to configure HTTP/2 and never accept a connection
+			logWarnf("HTTP/2 disabled: the configured cipher suites lack the AES_128_GCM_SHA256 suite HTTP/2 requires")
+		}
+		if !slices.Contains(s.nextProtos(), alpnH2) {
+			s.httpServer.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		}
+		s.warnCustomALPN()
 	}
 
-	log.Printf("Starting HTTPS server on %s...", s.Addr)
-	log.Printf("Server expects client CN and Fingerprint to match entries in %s", s.KnownClientsFile)
+	if err := s.startDiagServer(); err != nil {
+		closeListeners(listeners)
+		return fmt.Errorf("failed to start diagnostics server on %s: %w", s.DiagAddr, err)
+	}
+	if err := s.startAdminServer(); err != nil {
+		closeListeners(listeners)
+		s.stopDiagServer(context.Background())
+		return fmt.Errorf("failed to start admin server on %s: %w", s.AdminAddr, err)
+	}
+	if err := s.startMetricsServer(); err != nil {
+		closeListeners(listeners)
+		s.stopDiagServer(context.Background())
+		s.stopAdminServer(context.Background())
+		return fmt.Errorf("failed to start metrics server on %s: %w", s.MetricsAddr, err)
+	}
+	if err := s.startHTTPServer(listeners[0].Addr()); err != nil {
+		closeListeners(listeners)
+		s.stopDiagServer(context.Background())
+		s.stopAdminServer(context.Background())
+		s.stopMetricsServer(context.Background())
+		return fmt.Errorf("failed to start plain-HTTP server on %s: %w", s.HTTPAddr, err)
+	}
+	if s.WatchKnownClients > 0 && s.knownClients != nil && s.KnownClients == nil { // Only the file backend can be watched
+		go s.watchKnownClients()
+	}
+	if s.WatchServerCert > 0 && s.spiffe == nil && s.KubeTLSSecret == "" { // Both reload on their own
+		go s.watchServerCertificate()
+	}
+	if s.kubeSecrets != nil && s.KubePoll > 0 {
+		go s.watchKubeSecrets()
+	}
+	if s.WatchCRL > 0 && s.crls != nil {
+		go s.watchCRL()
+	}
+	if s.WatchDeniedClients > 0 && s.denied != nil {
+		go s.watchDeniedClients()
+	}
+	if s.ocspStapling() && s.OCSPRefresh > 0 {
+		go s.watchOCSPStaple()
+	}
 
-	// Start server in a goroutine so it doesn't block
-	go func() {
-		err := s.httpServer.ListenAndServeTLS(s.CertFile, s.KeyFile)
-		if err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Printf("Server ListenAndServeTLS error: %v", err) // Use log.Printf, not Fatalf in goroutine
-		} else {
-			log.Println("Server stopped gracefully.")
+	if s.Mode == serverModeTCP || s.Mode == serverModeTunnel {
+		for _, listener := range listeners {
+			if s.Mode == serverModeTunnel && s.tunnelAllow != nil {
+				logInfof("Starting mTLS tunnel on %s to destinations in %s...", listener.Addr(), s.TunnelAllowlistFile)
+			} else if s.Mode == serverModeTunnel {
+				logInfof("Starting mTLS tunnel on %s to %s...", listener.Addr(), s.TunnelTarget)
+			} else {
+				logInfof("Starting TCP echo server on %s...", listener.Addr())
+			}
+		}
+		logInfof("Server expects client CN and Fingerprint to match entries in %s", s.KnownClientsFile)
+		s.startEchoServer(listeners)
+		close(s.ready)
+		return nil
+	}
+	if s.Mode == serverModeGRPC {
+		for _, listener := range listeners {
+			logInfof("Starting gRPC server on %s...", listener.Addr())
 		}
-	}()
-	// TODO: Add a readiness check mechanism (e.g., channel) if needed before client connects in tests
+		logInfof("Server expects client CN and Fingerprint to match entries in %s", s.KnownClientsFile)
+		s.startGRPCServer(listeners, tlsConfig)
+		close(s.ready)
+		return nil
+	}
+
+	for _, listener := range listeners {
+		logInfof("Starting HTTPS server on %s...", listener.Addr())
+	}
+	logInfof("Server expects client CN and Fingerprint to match entries in %s", s.KnownClientsFile)
+	close(s.ready)
+
+	// Serve in goroutines so it doesn't block
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			err := s.httpServer.ServeTLS(s.conns.listener(listener), "", "") // Certificates are already in TLSConfig
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logErrorf("Server ServeTLS error on %s: %v", listener.Addr(), err) // Log, not Fatalf in goroutine
+			} else {
+				logInfof("Server stopped gracefully on %s.", listener.Addr())
+			}
+		}(listener)
+	}
 	return nil
 }
 
-// Stop gracefully shuts down the server.
+// Ready is closed once the server's listener is bound and accepting connections.
+// Start binds before returning, so it is already closed when Start succeeds; it lets other
+// goroutines wait for a server that is being started elsewhere without polling the port.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
+}
+
+// Stop gracefully shuts down the server: it stops accepting connections and waits up to
+// ShutdownTimeout for in-flight requests, then closes whatever is left.
 func (s *Server) Stop() error {
-	if s.httpServer == nil {
+	if s.httpServer == nil && s.echo == nil && s.grpcServer == nil {
 		return errors.New("server not started")
 	}
-	log.Println("Stopping server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second) // Add timeout
+	logInfof("Stopping server...")
+	s.stopOnce.Do(func() { close(s.stopped) })
+	ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
 	defer cancel()
-	return s.httpServer.Shutdown(ctx)
+	if err := s.stopDiagServer(ctx); err != nil {
+		logErrorf("Failed to stop diagnostics server: %v", err)
+	}
+	if err := s.stopAdminServer(ctx); err != nil {
+		logErrorf("Failed to stop admin server: %v", err)
+	}
+	if err := s.stopMetricsServer(ctx); err != nil {
+		logErrorf("Failed to stop metrics server: %v", err)
+	}
+	if err := s.stopHTTPServer(ctx); err != nil {
+		logErrorf("Failed to stop plain-HTTP server: %v", err)
+	}
+	var err error
+	if s.echo != nil {
+		err = s.echo.stop(ctx)
+	} else if s.grpcServer != nil {
+		err = s.stopGRPCServer(ctx)
+	} else if err = s.httpServer.Shutdown(ctx); errors.Is(err, context.DeadlineExceeded) { // Waits for in-flight requests to finish
+		logWarnf("In-flight requests did not finish within %s, closing their connections", s.ShutdownTimeout)
+		s.httpServer.Close()
+		err = fmt.Errorf("shutdown timed out after %s: %w", s.ShutdownTimeout, err)
+	}
+	if s.sinks != nil {
+		s.sinks.Close() // Flush queued decisions once no more handshakes can happen
+	}
+	if s.keyLog != nil {
+		s.keyLog.Close()
+	}
+	if s.spiffe != nil {
+		s.spiffe.Close()
+	}
+	if s.kubeSecrets != nil {
+		os.RemoveAll(s.kubeSecrets.dir)
+	}
+	return err
 }
 
-// --- Server Handlers & Helpers (belong conceptually with the server) ---
-
-// helloHandler responds to requests.
-func helloHandler(w http.ResponseWriter, r *http.Request) {
-	cn := "unknown"
-	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
-		cn = r.TLS.PeerCertificates[0].Subject.CommonName
+// startSinks starts the worker pool feeding the decision log and DecisionSinks, if any are configured.
+func (s *Server) startSinks() error {
+	sinks := s.DecisionSinks
+	var decisionLog *decisionLogSink
+	if s.DecisionLogFile != "" {
+		var err error
+		if decisionLog, err = newDecisionLogSink(s.DecisionLogFile, s.DecisionLogMaxSize, s.DecisionLogMaxBackups); err != nil {
+			return err
+		}
+		sinks = append(sinks, decisionLog)
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+	pool, err := newSinkPool(sinks, s.SinkWorkers, s.SinkQueue, s.SinkPolicy)
+	if err != nil {
+		if decisionLog != nil {
+			decisionLog.Close()
+		}
+		return fmt.Errorf("failed to start decision sinks: %w", err)
 	}
-	log.Printf("Received request from %s for %s", cn, r.URL.Path)
-	fmt.Fprintf(w, "Hello, authenticated client '%s'!\n", cn)
+	s.sinks = pool
+	s.decisionLog = decisionLog
+	return nil
 }
 
-// loadKnownClients reads the known clients file and parses it.
-func loadKnownClients(filePath string) (map[string]string, error) {
-	file, err := os.Open(filePath)
+// serverTLSConfig returns the server TLS configuration, loading the known clients and
+// server key pair the first time it is called.
+func (s *Server) serverTLSConfig() (*tls.Config, error) {
+	s.tlsMu.Lock()
+	defer s.tlsMu.Unlock()
+	if s.tlsConfig != nil {
+		return s.tlsConfig, nil
+	}
+
+	if _, err := mtls.ParseIdentitySource(string(s.ClientIDSource)); err != nil {
+		return nil, err
+	}
+	opts := s.verifyOptions()
+	if err := validateNamePatterns(opts.NamePatterns); err != nil {
+		return nil, fmt.Errorf("invalid client name pattern: %w", err)
+	}
+	if err := validateNamePatterns(opts.IssuerPatterns); err != nil {
+		return nil, fmt.Errorf("invalid client issuer pattern: %w", err)
+	}
+	if s.DeniedClientsFile != "" {
+		denied, err := loadDeniedClients(s.DeniedClientsFile)
+		if err != nil {
+			return nil, err
+		}
+		logInfof("Loaded %d denied clients from %s", denied.current.Load().size(), s.DeniedClientsFile)
+		s.denied, opts.Denied = denied, denied
+	}
+	switch s.VerifyMode {
+	case verifyModeFingerprint:
+		if s.CRLFile != "" {
+			return nil, fmt.Errorf("a CRL requires verify mode %s or %s", verifyModeCA, verifyModeBoth)
+		}
+	case verifyModeCA, verifyModeBoth:
+		if s.ClientCAFile == "" && s.SPIFFESocket == "" {
+			return nil, fmt.Errorf("verify mode %s requires a client CA bundle", s.VerifyMode)
+		}
+		if s.ClientCAFile == "" {
+			if s.CRLFile != "" {
+				return nil, errors.New("a CRL requires a client CA bundle to verify it")
+			}
+			break // The SPIFFE trust bundle, see below
+		}
+		clientCAs, err := mtls.LoadCertPool(s.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client CAs: %w", err)
+		}
+		opts.ClientCAs = clientCAs
+		if s.CRLFile != "" {
+			if s.crls, err = loadCRLStore(s.CRLFile, s.ClientCAFile); err != nil {
+				return nil, fmt.Errorf("error loading CRL: %w", err)
+			}
+			opts.CRL = s.crls
+		}
+	default:
+		return nil, fmt.Errorf("unknown verify mode %q (want %s, %s or %s)", s.VerifyMode, verifyModeFingerprint, verifyModeCA, verifyModeBoth)
+	}
+
+	logInfof("Configuring server TLS for %s client verification...", s.VerifyMode)
+	var knownClients mtls.KnownClientsStore
+	if s.VerifyMode != verifyModeCA {
+		knownClients = s.KnownClients
+		if knownClients == nil {
+			store, err := mtls.NewFileStore(s.KnownClientsFile, s.fileStoreOptions())
+			if err != nil {
+				return nil, fmt.Errorf("error loading known clients from %s: %w", s.KnownClientsFile, err)
+			}
+			knownClients = store
+		}
+		logInfof("Loaded %d known clients for verification.", len(knownClients.List()))
+		knownClients = newNotifyingStore(knownClients, s.clientChanges.Publish)
+		s.knownClients = knownClients
+	}
+	if s.TOFU || s.TOFUApproval {
+		if knownClients == nil {
+			return nil, fmt.Errorf("trust on first use requires verify mode %s or %s", verifyModeFingerprint, verifyModeBoth)
+		}
+		if s.TOFUApproval && s.AdminAddr == "" {
+			logWarnf("Clients awaiting approval can only be approved on the admin API, which --admin-addr enables")
+		}
+		s.tofu = newTOFUTrust(knownClients, s.ClientIDSource, s.TOFUApproval, &s.adminMu)
+		opts.TOFU = s.tofu
+		if s.TOFUApproval {
+			logWarnf("Trust on first use is enabled: unknown client CNs are held for approval")
+		} else {
+			logWarnf("Trust on first use is enabled: unknown client CNs are trusted on their first connection")
+		}
+	}
+
+	spiffeClientCAs := false
+	if s.SPIFFESocket != "" {
+		bundle, err := s.useSPIFFE()
+		if err != nil {
+			return nil, err
+		}
+		if s.VerifyMode != verifyModeFingerprint && opts.ClientCAs == nil {
+			opts.ClientCAs = bundle
+			spiffeClientCAs = true
+		}
+	}
+
+	if err := s.startSinks(); err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := createServerTLSConfig(knownClients, opts, s.recordDecision)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open known clients file %s: %w", filePath, err)
+		return nil, fmt.Errorf("failed to create server TLS config: %w", err)
+	}
+	if spiffeClientCAs {
+		s.spiffe.rotateClientCAs(tlsConfig)
+	}
+	// Load the server identity up front so a bad key pair fails Start; GetCertificate serves reloads.
+	if s.spiffe == nil {
+		if s.serverCert, err = loadServerCertificate(s.CertFile, s.KeyFile); err != nil {
+			return nil, err
+		}
+		if err := checkCertExpiry("server certificate "+s.CertFile, s.serverCert.leaf(), s.ExpiryWarnDays, s.StrictExpiry, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	tlsConfig.GetCertificate = s.serverCert.getCertificate
+	if len(s.SNICertificates) > 0 {
+		if s.sniCerts, err = loadSNICertificates(s.SNICertificates, s.ExpiryWarnDays, s.StrictExpiry); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetCertificate = s.getCertificate
+	}
+	s.TLSVersions.apply(tlsConfig)
+	tlsConfig.NextProtos = s.nextProtos()
+	tlsConfig.SessionTicketsDisabled = s.SessionTicketsDisabled
+	if s.TLSKeyLogFile != "" {
+		if s.keyLog, err = openKeyLog(s.TLSKeyLogFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.KeyLogWriter = s.keyLog
 	}
-	defer file.Close()
+	if len(s.Tenants) > 0 { // Before the wrappers below, so they apply to the tenants' handshakes too
+		if err := s.loadTenants(opts, tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	s.metrics.instrumentHandshakes(tlsConfig)
+	s.conns.instrumentHandshakes(tlsConfig)
+	if s.LogJA3 {
+		logClientHello(tlsConfig)
+	}
+	if s.TLSDebug {
+		debugHandshakes(tlsConfig)
+	}
+	s.tlsConfig = tlsConfig
+	return tlsConfig, nil
+}
+
+// fileStoreOptions are the options of the known clients files the server loads.
+func (s *Server) fileStoreOptions() mtls.FileStoreOptions {
+	return mtls.FileStoreOptions{
+		Warnf:             logWarnf,
+		Strict:            s.Strict,
+		CaseInsensitiveCN: s.CaseInsensitiveCN,
+		MaxAge:            s.MaxKnownClientsAge,
+		MaxEntries:        s.MaxKnownClients,
+	}
+}
+
+// recordDecision is called for every client certificate verification and failed handshake.
+func (s *Server) recordDecision(d authDecision) {
+	if !d.Allowed && d.RemoteAddr != "" && d.Check != handshakeCheck {
+		s.rejectedConns.add(d.RemoteAddr) // Its handshake is about to fail, see recordHandshakeFailure
+	}
+	s.rejections.Record(d)
+	s.metrics.observeDecision(d)
+	s.decisions.Publish(d)
+	if s.sinks != nil {
+		s.sinks.Submit(d)
+	}
+}
+
+// verifyOptions collects the client certificate checks configured on the server.
+func (s *Server) verifyOptions() verifyOptions {
+	return verifyOptions{
+		AllowedSignatureAlgorithms: s.AllowedSignatureAlgorithms,
+		MaxCertLifetime:            s.MaxClientCertLifetime,
+		RequiredEKUs:               s.RequiredClientEKUs,
+		NamePatterns:               s.ClientNamePatterns,
+		IssuerPatterns:             s.ClientIssuerPatterns,
+		Audit:                      s.VerifyAudit,
+		ClientIDSource:             s.ClientIDSource,
+	}
+}
 
-	clients := make(map[string]string)
-	scanner := bufio.NewScanner(file)
-	lineNumber := 0
-	for scanner.Scan() {
-		lineNumber++
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") { // Skip empty lines and comments
-			continue
+// ReloadKnownClients re-reads the known clients file without restarting the server.
+// New handshakes are verified against the reloaded entries; on error the old entries stay active,
+// and with DegradeOnReloadFailure the server is degraded until a reload succeeds.
+func (s *Server) ReloadKnownClients() error {
+	if s.VerifyMode == verifyModeCA {
+		logInfof("Verify mode is %s, no known clients to reload", verifyModeCA)
+		return nil
+	}
+	if s.knownClients == nil {
+		return errors.New("server not started")
+	}
+	if err := s.knownClients.Reload(); err != nil {
+		err = fmt.Errorf("failed to reload known clients: %w", err)
+		if s.DegradeOnReloadFailure {
+			s.enterDegraded(err)
 		}
+		return err
+	}
+	logInfof("Reloaded %d known clients from %s", len(s.knownClients.List()), s.knownClientsSource())
+	if err := s.reloadTenantKnownClients(); err != nil {
+		err = fmt.Errorf("failed to reload known clients: %w", err)
+		if s.DegradeOnReloadFailure {
+			s.enterDegraded(err)
+		}
+		return err
+	}
+	s.leaveDegraded()
+	return nil
+}
+
+// knownClientsSource describes where the known clients come from, for logs.
+func (s *Server) knownClientsSource() string {
+	if s.KnownClients != nil {
+		return fmt.Sprintf("%T", s.KnownClients)
+	}
+	return s.KnownClientsFile
+}
+
+// --- Server Handlers & Helpers (belong conceptually with the server) ---
+
+// routes registers the server's handlers, with app serving everything else (see appHandler).
+func (s *Server) routes(app http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", s.requireBoundToken(app))
+	mux.HandleFunc(popNoncePath, s.popNonceHandler)
+	mux.HandleFunc(popVerifyPath, s.popVerifyHandler)
+	mux.HandleFunc(httpSigPath, s.httpSigHandler)
+	mux.HandleFunc(tokenPath, s.tokenHandler)
+	mux.HandleFunc(wsPath, s.wsHandler)
+	mux.HandleFunc(identityPath, s.identityHandler)
+	mux.HandleFunc(uploadPath, s.uploadHandler)
+	mux.HandleFunc(downloadPath, s.downloadHandler)
+	mux.Handle("/admin/events", s.requireAdmin(http.HandlerFunc(s.adminEventsHandler)))
+	mux.Handle("/admin/config", s.requireAdmin(http.HandlerFunc(s.adminConfigHandler)))
+	mux.Handle(adminWatchPath, s.requireAdmin(http.HandlerFunc(s.adminWatchHandler)))
+	s.registerPprof(mux)
+	return s.countRequests(s.withClientIdentity(s.enforceKnownClientEntry(s.limitRate(mux))))
+}
+
+// enforceKnownClientEntry applies the restrictions of the client's known clients entry to every request:
+// its allowed paths, and its expiry, which may pass while a keep-alive connection is still open.
+func (s *Server) enforceKnownClientEntry(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.knownClients == nil || r.TLS == nil || len(r.TLS.PeerCertificates) == 0 { // ca mode
+			next.ServeHTTP(w, r)
+			return
+		}
+		cert := r.TLS.PeerCertificates[0]
+		entries, _ := s.knownClientsFor(r.TLS.ServerName).Lookup(s.clientID(cert))
+		entry, _ := mtls.MatchKnownClient(entries, cert)
+		switch {
+		case !s.knownClientEntryValid(r.TLS.ServerName, cert):
+			logAuth(levelError, "Denied request: known clients entry removed or expired", requestAttrs(r)...)
+			http.Error(w, "client no longer authorized", http.StatusForbidden)
+		case !entry.AllowsPath(r.URL.Path):
+			logAuth(levelError, "Denied request: path not in the allowed paths of its known clients entry", requestAttrs(r)...)
+			http.Error(w, "path not allowed for this client", http.StatusForbidden)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// requireAdmin only lets clients whose ID is listed in AdminCNs through to the handler.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return s.requireClientID(s.AdminCNs, "admin", next)
+}
 
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) != 2 {
-			log.Printf("Skipping invalid line %d in %s: format should be '<common_name> <fingerprint>'", lineNumber, filePath)
-			continue
+// requireClientID only lets clients whose ID (see clientID) is listed in ids through to the handler,
+// denying the others access to what (e.g. "admin"). With a SAN as the ID, the CN is not checked against
+// the known clients file, and an spki: entry accepts any certificate for the key, so the CN is whatever
+// the client put in it and can't grant access. The endpoints are server-wide, so clients authenticated by
+// a tenant are denied whatever their ID: any tenant's known clients file could list a listed ID.
+func (s *Server) requireClientID(ids []string, what string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id string
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 && (s.tenants == nil || s.tenants.lookup(r.TLS.ServerName) == nil) {
+			id = s.clientID(r.TLS.PeerCertificates[0])
 		}
-		cn := strings.TrimSpace(parts[0])
-		fingerprint := strings.ToUpper(strings.TrimSpace(parts[1])) // Normalize fingerprint
-		if cn == "" || fingerprint == "" {
-			log.Printf("Skipping invalid line %d in %s: empty common name or fingerprint", lineNumber, filePath)
-			continue
+		for _, allowed := range ids {
+			if id != "" && id == allowed {
+				next.ServeHTTP(w, r)
+				return
+			}
 		}
-		clients[cn] = fingerprint
+		logAuth(levelError, "Denied "+what+" request", requestAttrs(r)...)
+		http.Error(w, what+" access required", http.StatusForbidden)
+	})
+}
+
+// clientID returns what identifies cert in the known clients file (see ClientIDSource), or "" if it has
+// no such field.
+func (s *Server) clientID(cert *x509.Certificate) string {
+	id, _ := s.ClientIDSource.ClientID(cert)
+	return id
+}
+
+// peerCN returns the CN of the verified client certificate, or "unknown".
+func peerCN(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
 	}
+	return "unknown"
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading known clients file %s: %w", filePath, err)
+// helloHandler responds to requests, as text or as JSON (see negotiate.go).
+func helloHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ClientIdentityFromContext(r.Context())
+	logAuth(levelInfo, "Received request", requestAttrs(r)...)
+	w.Header().Set("Vary", "Accept")
+	switch negotiateContentType(r, contentTypeText, contentTypeJSON) {
+	case contentTypeJSON:
+		if !ok {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "client certificate required"})
+			return
+		}
+		writeJSON(w, http.StatusOK, newHelloResponse(r, id))
+		return
+	case "":
+		http.Error(w, "acceptable types are text/plain and application/json", http.StatusNotAcceptable)
+		return
 	}
+	fmt.Fprintf(w, "Hello, authenticated client '%s'!\n", id.CN)
+	fmt.Fprintf(w, "Protocol: %s\n", negotiatedProtocol(r))
+	if r.TLS != nil && r.TLS.ServerName != "" {
+		fmt.Fprintf(w, "Server name: %s\n", r.TLS.ServerName)
+	}
+}
 
-	if len(clients) == 0 {
-		log.Printf("Warning: No valid client entries found in %s", filePath)
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logErrorf("Failed to write JSON response: %v", err)
 	}
+}
 
-	return clients, nil
+// verifyOptions holds the optional checks applied to a client certificate
+// in addition to the known clients lookup.
+type verifyOptions struct {
+	AllowedSignatureAlgorithms []x509.SignatureAlgorithm
+	MaxCertLifetime            time.Duration
+	// RequiredEKUs, NamePatterns and IssuerPatterns restrict what client certificates were issued for,
+	// which names they carry and who issued them (see certpolicy.go).
+	RequiredEKUs   []x509.ExtKeyUsage
+	NamePatterns   []string
+	IssuerPatterns []string
+	// Audit runs every check and reports all failures instead of stopping at the first one.
+	Audit bool
+	// ClientCAs, if set, makes the TLS stack verify the client certificate chain against these CAs
+	// before checkClientCertificate runs.
+	ClientCAs *x509.CertPool
+	// CRL, if set, rejects client certificates it lists as revoked.
+	CRL *crlStore
+	// Denied, if set, rejects the client certificates it lists before any other check.
+	Denied *deniedClients
+	// TOFU, if set, trusts (or holds for approval) clients whose CN is not in the known clients store.
+	TOFU *tofuTrust
+	// ClientIDSource selects what the known clients lookup uses instead of the CN.
+	ClientIDSource mtls.IdentitySource
 }
 
+// Client certificate verification modes (--verify-mode).
+const (
+	verifyModeFingerprint = "fingerprint" // Listed in the known clients file (self-signed certificates work)
+	verifyModeCA          = "ca"          // Chains to a client CA; the known clients file is not used
+	verifyModeBoth        = "both"        // Chains to a client CA and is listed in the known clients file
+)
+
 // verifyClientCertificate checks if the client certificate matches a known client.
+// The certificate is run through the check pipeline built from opts (see checks.go);
+// with a nil knownClients store (ca mode) only the policy checks run.
 // NOTE: verifiedChains will be nil in the self-signed setup as ClientCAs is not set.
-func verifyClientCertificate(rawCerts [][]byte, _ [][]*x509.Certificate, knownClients map[string]string) error {
+func verifyClientCertificate(rawCerts [][]byte, _ [][]*x509.Certificate, knownClients mtls.KnownClientsStore, opts verifyOptions) error {
 	if len(rawCerts) == 0 {
 		return errors.New("no client certificate provided")
 	}
@@ -146,30 +936,27 @@ func verifyClientCertificate(rawCerts [][]byte, _ [][]*x509.Certificate, knownCl
 		return fmt.Errorf("failed to parse client certificate: %w", err)
 	}
 
-	hash := sha256.Sum256(cert.Raw)
-	var buf strings.Builder
-	for i, b := range hash {
-		fmt.Fprintf(&buf, "%02X", b)
-		if i < len(hash)-1 {
-			buf.WriteByte(':')
-		}
-	}
-	fingerprint := buf.String()
-	cn := cert.Subject.CommonName
-
-	log.Printf("Verifying client: CN='%s', Fingerprint='%s'", cn, fingerprint)
+	return checkClientCertificate(cert, knownClients, opts)
+}
 
-	knownFingerprint, ok := knownClients[cn]
-	if !ok {
-		log.Printf("Authentication failed: Client CN '%s' not found in known clients file.", cn)
-		return fmt.Errorf("client CN '%s' not authorized", cn)
+// checkClientCertificate runs a parsed client certificate through the check pipeline built from opts, handing
+// clients rejected only for an unknown CN to TOFU if it is enabled. It is the Verifier of the server's mtls.ServerConfig.
+func checkClientCertificate(cert *x509.Certificate, knownClients mtls.KnownClientsStore, opts verifyOptions) error {
+	logDebugf("Verifying client: CN='%s', Fingerprint='%s'", cert.Subject.CommonName, mtls.CertFingerprint(cert))
+	err := runCertChecks(cert, buildCertChecks(knownClients, opts), opts.Audit)
+	if err != nil && opts.TOFU != nil && onlyUnknownCN(err) {
+		return opts.TOFU.trust(cert)
 	}
+	return err
+}
 
-	if knownFingerprint != fingerprint {
-		log.Printf("Authentication failed: Fingerprint mismatch for CN '%s'. Expected '%s', Got '%s'", cn, knownFingerprint, fingerprint)
-		return fmt.Errorf("client fingerprint mismatch for CN '%s'", cn)
+// verifiedVia describes how a client that passed checkClientCertificate was authenticated, for logs.
+func verifiedVia(knownClients mtls.KnownClientsStore, opts verifyOptions) string {
+	switch {
+	case opts.ClientCAs != nil && knownClients == nil:
+		return "CA"
+	case opts.ClientCAs != nil:
+		return "CA and fingerprint"
 	}
-
-	log.Printf("Client authenticated successfully via fingerprint: CN='%s'", cn)
-	return nil
+	return "fingerprint"
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// rawClientCert returns the DER bytes of the test PKI's client certificate, as passed to VerifyPeerCertificate.
+func rawClientCert(t *testing.T, pki *testPKI) [][]byte {
+	t.Helper()
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return [][]byte{cert.Raw}
+}
+
+func TestVerifyClientCertificateSignatureAlgorithms(t *testing.T) {
+	pki := newTestPKI(t)
+	store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := rawClientCert(t, pki)
+
+	tests := []struct {
+		name    string
+		allowed []x509.SignatureAlgorithm
+		wantErr bool
+	}{
+		{"no restriction", nil, false},
+		{"allowed", []x509.SignatureAlgorithm{x509.SHA256WithRSA}, false},
+		{"disallowed", []x509.SignatureAlgorithm{x509.ECDSAWithSHA256, x509.PureEd25519}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyClientCertificate(raw, nil, store, verifyOptions{AllowedSignatureAlgorithms: tt.allowed})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "signature algorithm") {
+					t.Fatalf("Expected signature algorithm error, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected success, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseSignatureAlgorithms(t *testing.T) {
+	algs, err := parseSignatureAlgorithms([]string{"sha256-rsa", " ECDSA-SHA384 ", "Ed25519"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []x509.SignatureAlgorithm{x509.SHA256WithRSA, x509.ECDSAWithSHA384, x509.PureEd25519}
+	if len(algs) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, algs)
+	}
+	for i := range want {
+		if algs[i] != want[i] {
+			t.Errorf("Expected %v at %d, got %v", want[i], i, algs[i])
+		}
+	}
+
+	if _, err := parseSignatureAlgorithms([]string{"ROT13-RSA"}); err == nil {
+		t.Error("Expected error for unknown algorithm")
+	}
+}
+
+func TestVerifyClientCertificateMaxLifetime(t *testing.T) {
+	pki := newTestPKI(t)
+	maxLifetime := 90 * 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		validFor time.Duration
+		wantErr  bool
+	}{
+		{"compliant", 30 * 24 * time.Hour, false},
+		{"at limit", maxLifetime, false},
+		{"excessive", 365 * 24 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: pki.ClientCN, ValidFor: tt.validFor})
+			if err != nil {
+				t.Fatal(err)
+			}
+			certFile := filepath.Join(t.TempDir(), "client.crt")
+			if err := writeCertFiles(certFile, certFile+".key", certPEM, keyPEM); err != nil {
+				t.Fatal(err)
+			}
+			cert, err := loadCertificate(certFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mtls.AppendKnownClient(pki.KnownClientsFile, pki.ClientCN, mtls.CertFingerprint(cert)); err != nil {
+				t.Fatal(err)
+			}
+			store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = verifyClientCertificate([][]byte{cert.Raw}, nil, store, verifyOptions{MaxCertLifetime: maxLifetime})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "lifetime") {
+					t.Fatalf("Expected lifetime error, got %v", err)
+				}
+			} else if err != nil {
+				t.Fatalf("Expected success, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyClientCertificateCaseInsensitiveCN(t *testing.T) {
+	pki := newTestPKI(t)
+	certFile := filepath.Join(pki.Dir, "mixed.crt")
+	keyFile := filepath.Join(pki.Dir, "mixed.key")
+	fingerprint := pki.newClientCert(t, "My_Client", certFile, keyFile)
+	cert, err := loadCertificate(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := [][]byte{cert.Raw}
+
+	tests := []struct {
+		name            string
+		listedCN        string
+		caseInsensitive bool
+		wantErr         bool
+	}{
+		{"exact case, sensitive", "My_Client", false, false},
+		{"other case, sensitive", "my_client", false, true},
+		{"other case, insensitive", "my_client", true, false},
+		{"upper case, insensitive", "MY_CLIENT", true, false},
+		{"different CN, insensitive", "my_other_client", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(pki.KnownClientsFile, []byte(tt.listedCN+" "+fingerprint+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			store, err := mtls.NewFileStore(pki.KnownClientsFile, mtls.FileStoreOptions{CaseInsensitiveCN: tt.caseInsensitive})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = verifyClientCertificate(raw, nil, store, verifyOptions{})
+			if tt.wantErr && err == nil {
+				t.Fatal("Expected the CN not to match")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("Expected the CN to match, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStartBindsBeforeReturning(t *testing.T) {
+	pki := newTestPKI(t)
+	addr := freeAddr(t)
+	server := NewServer(addr, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	select {
+	case <-server.Ready():
+		t.Fatal("Expected Ready to be open before Start")
+	default:
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+	select {
+	case <-server.Ready():
+	default:
+		t.Fatal("Expected Ready to be closed once Start returns")
+	}
+
+	// The address is taken now, which a second server reports from Start instead of a background log line.
+	second := NewServer(addr, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	if err := second.Start(); err == nil {
+		second.Stop()
+		t.Fatal("Expected Start to fail on an address in use")
+	}
+}
+
+func TestVerifyModes(t *testing.T) {
+	pki := newTestPKI(t)
+	ca := newTestCA(t, pki.Dir)
+	issued := func(cn string, listed bool) (string, string) {
+		certFile := filepath.Join(pki.Dir, cn+".crt")
+		keyFile := filepath.Join(pki.Dir, cn+".key")
+		cert := ca.issue(t, cn, certFile, keyFile)
+		if listed {
+			if err := mtls.AppendKnownClient(pki.KnownClientsFile, cn, mtls.CertFingerprint(cert)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return certFile, keyFile
+	}
+	listedCert, listedKey := issued("ca_listed", true)
+	unlistedCert, unlistedKey := issued("ca_unlisted", false)
+
+	tests := []struct {
+		mode         string
+		selfSigned   bool // pki.ClientCertFile, self-signed and listed
+		caListed     bool
+		caUnlisted   bool
+		withoutCAs   bool // Start without --client-ca
+		wantStartErr bool
+	}{
+		{mode: verifyModeFingerprint, selfSigned: true, caListed: true, caUnlisted: false},
+		{mode: verifyModeCA, selfSigned: false, caListed: true, caUnlisted: true},
+		{mode: verifyModeBoth, selfSigned: false, caListed: true, caUnlisted: false},
+		{mode: verifyModeCA, withoutCAs: true, wantStartErr: true},
+		{mode: "pinning", wantStartErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			addr := freeAddr(t)
+			server := NewServer(addr, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+			server.VerifyMode = tt.mode
+			if !tt.withoutCAs {
+				server.ClientCAFile = ca.CertFile
+			}
+			err := server.Start()
+			if tt.wantStartErr {
+				if err == nil {
+					server.Stop()
+					t.Fatal("Expected Start to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Stop()
+
+			for _, c := range []struct {
+				name              string
+				certFile, keyFile string
+				want              bool
+			}{
+				{"self-signed listed", pki.ClientCertFile, pki.ClientKeyFile, tt.selfSigned},
+				{"CA-issued listed", listedCert, listedKey, tt.caListed},
+				{"CA-issued unlisted", unlistedCert, unlistedKey, tt.caUnlisted},
+			} {
+				client, err := NewClient("https://"+addr+"/hello", pki.ServerCertFile, c.certFile, c.keyFile)
+				if err != nil {
+					t.Fatal(err)
+				}
+				_, status, err := client.SendRequest()
+				if accepted := err == nil && status == 200; accepted != c.want {
+					t.Errorf("%s: expected accepted=%v, got status %d (%v)", c.name, c.want, status, err)
+				}
+			}
+		})
+	}
+}
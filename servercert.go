@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Server Certificate Rotation ---
+//
+// The server presents its certificate through tls.Config.GetCertificate instead of a fixed
+// Certificates list, so a reload only swaps a pointer: new handshakes get the new key pair while
+// established connections keep the one they negotiated. Like the known clients file, the pair is
+// reloaded on SIGHUP and, with WatchServerCert, when polling notices that either file changed.
+// A pair that fails to load (e.g. the certificate was replaced but the key not yet) leaves the
+// current one in place until the next change.
+
+// serverCertificate holds the server key pair loaded from CertFile and KeyFile.
+type serverCertificate struct {
+	certFile string
+	keyFile  string
+	current  atomic.Pointer[tls.Certificate]
+}
+
+// loadServerCertificate loads the key pair, failing if it can't be used.
+func loadServerCertificate(certFile, keyFile string) (*serverCertificate, error) {
+	c := &serverCertificate{certFile: certFile, keyFile: keyFile}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// reload replaces the current key pair with the one on disk, keeping the current pair on error.
+func (c *serverCertificate) reload() error {
+	cert, err := loadKeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server key pair (%s, %s): %w", c.certFile, c.keyFile, err)
+	}
+	if cert.Leaf == nil { // Go 1.23+ fills it in
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse server certificate %s: %w", c.certFile, err)
+		}
+	}
+	c.current.Store(&cert)
+	return nil
+}
+
+// leaf returns the current server certificate.
+func (c *serverCertificate) leaf() *x509.Certificate {
+	return c.current.Load().Leaf
+}
+
+// getCertificate is the tls.Config.GetCertificate callback.
+func (c *serverCertificate) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.current.Load(), nil
+}
+
+// ReloadServerCertificate re-reads CertFile and KeyFile (unless the SVID from SPIFFESocket replaces
+// them), the SNICertificates and the tenants' certificates, without restarting the server. New
+// handshakes present the reloaded certificates; on error the current ones stay active.
+func (s *Server) ReloadServerCertificate() error {
+	if s.serverCert == nil {
+		return errors.New("server not started")
+	}
+	if s.spiffe == nil { // The Workload API rotates the SVID itself
+		if err := s.serverCert.reload(); err != nil {
+			return fmt.Errorf("failed to reload server certificate: %w", err)
+		}
+		leaf := s.serverCert.leaf()
+		logInfof("Reloaded server certificate %s (CN %s, fingerprint %s, expires %s)",
+			s.CertFile, leaf.Subject.CommonName, mtls.CertFingerprint(leaf), leaf.NotAfter.Format(time.RFC3339))
+		checkCertExpiry("server certificate "+s.CertFile, leaf, s.ExpiryWarnDays, false, time.Now()) // Only warns without strict
+	}
+	if s.sniCerts != nil {
+		if err := s.sniCerts.reload(); err != nil {
+			return fmt.Errorf("failed to reload SNI certificate: %w", err)
+		}
+	}
+	if err := s.reloadTenantCertificates(); err != nil {
+		return fmt.Errorf("failed to reload server certificate: %w", err)
+	}
+	if s.ocspStapling() {
+		if err := s.refreshOCSPStaple(); err != nil { // The old staple was for the old certificate
+			logErrorf("Failed to staple OCSP response to the reloaded certificate: %v", err)
+		}
+	}
+	return nil
+}
+
+// watchServerCertificate reloads the server key pair whenever the stamp of the certificate or key file
+// changes, until the server stops.
+func (s *Server) watchServerCertificate() {
+	stamps := func() (fileStamp, fileStamp, error) {
+		cert, err := statFileStamp(s.CertFile)
+		if err != nil {
+			return fileStamp{}, fileStamp{}, err
+		}
+		key, err := statFileStamp(s.KeyFile)
+		return cert, key, err
+	}
+	lastCert, lastKey, _ := stamps()
+	ticker := time.NewTicker(s.WatchServerCert)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case <-ticker.C:
+		}
+
+		cert, key, err := stamps()
+		if err != nil { // e.g. mid-replacement; a failed load is reported once both files are back
+			continue
+		}
+		if cert.modTime.Equal(lastCert.modTime) && cert.size == lastCert.size &&
+			key.modTime.Equal(lastKey.modTime) && key.size == lastKey.size {
+			continue
+		}
+		lastCert, lastKey = cert, key
+		logInfof("Server certificate or key changed, reloading")
+		if err := s.ReloadServerCertificate(); err != nil {
+			logErrorf("%v", err) // Replacing the other file of the pair changes its stamp and retries
+		}
+	}
+}
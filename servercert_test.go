@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newServerCert generates a localhost server certificate into dir and returns its files.
+func newServerCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{
+		CommonName:  "localhost",
+		DNSNames:    []string{"localhost"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := writeCertFiles(certFile, keyFile, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// requestTrusting sends a request to url from a fresh client that trusts serverCertFile.
+func requestTrusting(t *testing.T, pki *testPKI, url, serverCertFile string) error {
+	t.Helper()
+	client, err := NewClient(url, serverCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = client.SendRequest()
+	return err
+}
+
+func TestServerCertificateWatch(t *testing.T) {
+	pki := newTestPKI(t)
+	_, url := startTestServer(t, pki, func(s *Server) { s.WatchServerCert = 20 * time.Millisecond })
+	newCert, newKey := newServerCert(t, t.TempDir(), "rotated")
+	if err := requestTrusting(t, pki, url, newCert); err == nil {
+		t.Fatal("Expected a client trusting only the new certificate to fail before the rotation")
+	}
+
+	// Rename over the old files, as deployment tools usually do.
+	if err := os.Rename(newKey, pki.ServerKeyFile); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(newCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pki.ServerCertFile+".tmp", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(pki.ServerCertFile+".tmp", pki.ServerCertFile); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for requestTrusting(t, pki, url, newCert) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the server to present the new certificate")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestServerCertificateReloadKeepsCurrentPairOnError(t *testing.T) {
+	pki := newTestPKI(t)
+	server, url := startTestServer(t, pki, func(s *Server) { s.WatchServerCert = 0 })
+	oldCert, err := ioutil.ReadFile(pki.ServerCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the certificate is replaced, so it doesn't match the key.
+	newCert, _ := newServerCert(t, t.TempDir(), "half")
+	data, err := ioutil.ReadFile(newCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pki.ServerCertFile, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadServerCertificate(); err == nil {
+		t.Fatal("Expected reloading a mismatched key pair to fail")
+	}
+
+	trusted := filepath.Join(t.TempDir(), "old.crt")
+	if err := ioutil.WriteFile(trusted, oldCert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := requestTrusting(t, pki, url, trusted); err != nil {
+		t.Errorf("Expected the server to keep presenting the old certificate: %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// resumed sends a request over a new connection and reports whether it resumed a TLS session.
+func resumed(t *testing.T, client *Client, url string) (bool, error) {
+	t.Helper()
+	client.httpClient.CloseIdleConnections()
+	resp, err := client.httpClient.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body) // TLS 1.3 tickets arrive after the handshake
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	return resp.TLS.DidResume, nil
+}
+
+func TestSessionResumption(t *testing.T) {
+	pki := newTestPKI(t)
+	server, url := startTestServer(t, pki, nil)
+	client, err := NewClient(url+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.EnableSessionCache(0)
+	for i, want := range []bool{false, true} {
+		if got, err := resumed(t, client, url+"/hello"); err != nil || got != want {
+			t.Fatalf("Connection %d: expected resumed %t, got %t (%v)", i+1, want, got, err)
+		}
+	}
+
+	// Resumed sessions are verified again, so removing the client locks it out.
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte("someone_else 00:11\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resumed(t, client, url+"/hello"); err == nil {
+		t.Error("Expected a resumed session of a removed client to be rejected")
+	}
+}
+
+func TestSessionTicketsDisabled(t *testing.T) {
+	pki := newTestPKI(t)
+	_, url := startTestServer(t, pki, func(s *Server) { s.SessionTicketsDisabled = true })
+	client, err := NewClient(url+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.EnableSessionCache(0)
+	for i := 0; i < 2; i++ {
+		if got, err := resumed(t, client, url+"/hello"); err != nil || got {
+			t.Fatalf("Connection %d: expected a full handshake, got resumed %t (%v)", i+1, got, err)
+		}
+	}
+}
@@ -10,6 +10,16 @@ CLIENT_SUBJ="/C=US/ST=California/L=SanFrancisco/O=MyOrg/OU=Client/CN=my_secure_c
 CERT_DIR="certs"
 KNOWN_CLIENTS_FILE="$CERT_DIR/knownClients.txt"
 SERVER_EXT_FILE="$CERT_DIR/server_ext.cnf"
+# Key type of both certificates: rsa (default), ecdsa (P-256), ecdsa-p384 or ed25519, e.g. KEY_TYPE=ed25519 ./setup.sh
+KEY_TYPE="${KEY_TYPE:-rsa}"
+
+case "$KEY_TYPE" in
+    rsa) NEWKEY="-newkey rsa:2048"; KEY_USAGE="digitalSignature, keyEncipherment" ;;
+    ecdsa|ecdsa-p256) NEWKEY="-newkey ec -pkeyopt ec_paramgen_curve:P-256"; KEY_USAGE="digitalSignature" ;;
+    ecdsa-p384) NEWKEY="-newkey ec -pkeyopt ec_paramgen_curve:P-384"; KEY_USAGE="digitalSignature" ;;
+    ed25519) NEWKEY="-newkey ed25519"; KEY_USAGE="digitalSignature" ;;
+    *) echo "Unknown KEY_TYPE '$KEY_TYPE' (want rsa, ecdsa, ecdsa-p384 or ed25519)" >&2; exit 1 ;;
+esac
 
 # Clean previous certs
 echo "Cleaning up previous certificates..."
@@ -18,7 +28,7 @@ mkdir "$CERT_DIR"
 
 # --- No CA Generation --- 
 
-echo "Generating Self-Signed Server Certificate..."
+echo "Generating Self-Signed Server Certificate ($KEY_TYPE)..."
 # Create OpenSSL config file including SAN extension
 cat > "$SERVER_EXT_FILE" <<-EOF
 [ req ]
@@ -39,7 +49,7 @@ CN = localhost
 subjectKeyIdentifier = hash
 authorityKeyIdentifier = keyid:always,issuer
 basicConstraints = critical,CA:false
-keyUsage = critical, digitalSignature, keyEncipherment
+keyUsage = critical, $KEY_USAGE
 subjectAltName = @alt_names
 
 [ v3_req ] # Section for req_extensions
@@ -48,16 +58,17 @@ subjectAltName = @alt_names
 [ alt_names ]
 DNS.1 = localhost
 IP.1 = 127.0.0.1
+IP.2 = ::1
 EOF
 
 # Generate self-signed server certificate directly using the key and subject from the config file
-openssl req -x509 -newkey rsa:2048 -nodes -keyout "$CERT_DIR/server.key" \
+openssl req -x509 $NEWKEY -nodes -keyout "$CERT_DIR/server.key" \
     -out "$CERT_DIR/server.crt" -days 365 -config "$SERVER_EXT_FILE" -extensions v3_ca # Specify extensions section
 
-echo "Generating Self-Signed Client Certificate..."
+echo "Generating Self-Signed Client Certificate ($KEY_TYPE)..."
 # Generate client private key and self-signed certificate directly
 # Client cert doesn't usually need SANs, so direct command is fine
-openssl req -x509 -newkey rsa:2048 -nodes -keyout "$CERT_DIR/client.key" \
+openssl req -x509 $NEWKEY -nodes -keyout "$CERT_DIR/client.key" \
     -out "$CERT_DIR/client.crt" -subj "$CLIENT_SUBJ" -days 365
 
 echo "Generating knownClients.txt..."
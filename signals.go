@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// --- Signal Handling ---
+
+// handleSignals runs the server until it is told to stop. SIGHUP reloads the known clients file,
+// the server certificate, the CRL and the denied clients, and reopens the decision log;
+// any other signal (SIGINT, SIGTERM) stops the server gracefully and returns the result of Stop.
+// A second stop signal while requests are draining closes their connections immediately.
+// It also returns, with nil, if the channel is closed.
+func (s *Server) handleSignals(signals <-chan os.Signal) error {
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			logInfof("Received %s, reloading known clients and server certificate", sig)
+			if err := s.ReloadKnownClients(); err != nil {
+				logErrorf("%v", err)
+			}
+			if err := s.ReloadServerCertificate(); err != nil {
+				logErrorf("%v", err)
+			}
+			if s.crls != nil {
+				if err := s.ReloadCRL(); err != nil {
+					logErrorf("%v", err)
+				}
+			}
+			if s.denied != nil {
+				if err := s.ReloadDeniedClients(); err != nil {
+					logErrorf("%v", err)
+				}
+			}
+			if s.decisionLog != nil {
+				if err := s.decisionLog.Reopen(); err != nil {
+					logErrorf("%v", err)
+				}
+			}
+			continue
+		}
+
+		logInfof("Received %s, shutting down (waiting up to %s for in-flight requests)", sig, s.ShutdownTimeout)
+		done := make(chan error, 1)
+		go func() { done <- s.Stop() }()
+		for {
+			select {
+			case err := <-done:
+				return err
+			case sig := <-signals:
+				if sig != syscall.SIGHUP && s.httpServer != nil { // Echo connections are closed right away anyway
+					logWarnf("Received %s again, closing connections now", sig)
+					s.httpServer.Close()
+				}
+			}
+		}
+	}
+	return nil
+}
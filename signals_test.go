@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// startSlowRequest opens a connection with the PKI's client certificate and sends a POST to /pop/verify
+// without the last byte of its body, leaving the handler in flight until the body is finished with "}".
+// (A request whose headers are still incomplete would not count: net/http drops it on shutdown.)
+func startSlowRequest(t *testing.T, pki *testPKI, baseURL string) *tls.Conn {
+	t.Helper()
+	cfg, err := mtls.ClientConfig{CertFile: pki.ClientCertFile, KeyFile: pki.ClientKeyFile, ServerCertFile: pki.ServerCertFile}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", strings.TrimPrefix(baseURL, "https://"), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	request := "POST " + popVerifyPath + " HTTP/1.1\r\nHost: localhost\r\nContent-Type: application/json\r\nContent-Length: 2\r\n\r\n{"
+	if _, err := conn.Write([]byte(request)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // Let the handler start reading the body
+	return conn
+}
+
+func TestSIGTERMDrainsInFlightRequests(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, nil)
+	conn := startSlowRequest(t, pki, baseURL)
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- server.handleSignals(signals) }()
+	signals <- syscall.SIGTERM
+	time.Sleep(100 * time.Millisecond)
+
+	// The request started before the signal still completes.
+	if _, err := conn.Write([]byte("}")); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Expected the in-flight request to complete, got %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden { // The body has no nonce
+		t.Fatalf("Expected the handler's 403 for the in-flight request, got %d", resp.StatusCode)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server did not shut down after the in-flight request completed")
+	}
+}
+
+func TestShutdownTimeoutClosesConnections(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, func(s *Server) { s.ShutdownTimeout = 200 * time.Millisecond })
+	startSlowRequest(t, pki, baseURL)
+
+	signals := make(chan os.Signal, 1)
+	signals <- os.Interrupt
+	start := time.Now()
+	err := server.handleSignals(signals)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected a shutdown timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected shutdown to give up after the timeout, took %s", elapsed)
+	}
+}
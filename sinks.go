@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// --- Asynchronous Decision Sinks ---
+//
+// Sinks that do I/O per auth decision (such as the decision log file) are fed by a bounded
+// worker pool so a slow sink never stalls TLS handshakes. The in-memory rejection log and the
+// admin events hub are updated synchronously in recordDecision and are not routed through the pool.
+//
+// When the queue is full, the "drop" policy discards the decision (and counts it), while the
+// "block" policy makes the handshake wait for room in the queue, so no decision is lost.
+// Stopping the server drains the queue before returning.
+
+const (
+	sinkPolicyDrop  = "drop"
+	sinkPolicyBlock = "block"
+
+	defaultSinkWorkers = 4
+	defaultSinkQueue   = 1024
+
+	defaultDecisionLogMaxBackups = 5
+)
+
+// DecisionSink receives every auth decision made by the server.
+// HandleDecision may be called concurrently from several workers.
+// Sinks that also implement io.Closer are closed once the pool has drained.
+type DecisionSink interface {
+	HandleDecision(d authDecision)
+}
+
+// DecisionSinkFunc adapts a function to a DecisionSink.
+type DecisionSinkFunc func(d authDecision)
+
+func (f DecisionSinkFunc) HandleDecision(d authDecision) { f(d) }
+
+// sinkPool delivers decisions to the sinks from a fixed number of worker goroutines.
+type sinkPool struct {
+	sinks []DecisionSink
+	queue chan authDecision
+	block bool
+
+	mu      sync.RWMutex // Held for writing only while closing the queue
+	closed  bool
+	wg      sync.WaitGroup
+	dropped uint64
+}
+
+// newSinkPool starts workers goroutines draining a queue of queueSize decisions to sinks.
+func newSinkPool(sinks []DecisionSink, workers, queueSize int, policy string) (*sinkPool, error) {
+	if workers < 1 {
+		return nil, fmt.Errorf("sink workers must be at least 1, got %d", workers)
+	}
+	if queueSize < 0 {
+		return nil, fmt.Errorf("sink queue size must not be negative, got %d", queueSize)
+	}
+	if policy != sinkPolicyDrop && policy != sinkPolicyBlock {
+		return nil, fmt.Errorf("unknown sink queue policy %q (want %s or %s)", policy, sinkPolicyDrop, sinkPolicyBlock)
+	}
+
+	p := &sinkPool{
+		sinks: sinks,
+		queue: make(chan authDecision, queueSize),
+		block: policy == sinkPolicyBlock,
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p, nil
+}
+
+func (p *sinkPool) work() {
+	defer p.wg.Done()
+	for d := range p.queue {
+		for _, sink := range p.sinks {
+			sink.HandleDecision(d)
+		}
+	}
+}
+
+// Submit queues a decision for the sinks, dropping or blocking per the pool's policy when the queue is full.
+// Decisions submitted after Close are dropped.
+func (p *sinkPool) Submit(d authDecision) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		atomic.AddUint64(&p.dropped, 1)
+		return
+	}
+	if p.block {
+		p.queue <- d
+		return
+	}
+	select {
+	case p.queue <- d:
+	default:
+		if atomic.AddUint64(&p.dropped, 1) == 1 {
+			logWarnf("Decision sink queue is full; dropping decisions (see --sink-queue, --sink-policy)")
+		}
+	}
+}
+
+// Dropped returns how many decisions were discarded because the queue was full or the pool closed.
+func (p *sinkPool) Dropped() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// Close stops accepting decisions, waits for the queued ones to be delivered and closes the sinks.
+func (p *sinkPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.queue)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	for _, sink := range p.sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				logErrorf("Failed to close decision sink: %v", err)
+			}
+		}
+	}
+	if dropped := p.Dropped(); dropped > 0 {
+		logWarnf("Dropped %d auth decisions in total", dropped)
+	}
+}
+
+// decisionLogSink appends each decision to a file as one JSON object per line. With a maxSize, a decision
+// that would grow the file past it first rotates the file: path becomes path.1, path.1 becomes path.2, and
+// so on up to maxBackups, and the oldest is deleted. Reopen supports rotation by an external tool instead.
+type decisionLogSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func newDecisionLogSink(path string, maxSize int64, maxBackups int) (*decisionLogSink, error) {
+	l := &decisionLogSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens (or creates) the log file for appending.
+func (l *decisionLogSink) open() error {
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open decision log %s: %w", l.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open decision log %s: %w", l.path, err)
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+func (l *decisionLogSink) HandleDecision(d authDecision) {
+	line, err := json.Marshal(d)
+	if err != nil {
+		logErrorf("Failed to write decision log: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			logErrorf("Failed to rotate decision log: %v", err)
+		}
+	}
+	if l.file == nil { // A failed rotation could not reopen the file
+		if err := l.open(); err != nil {
+			logErrorf("%v", err)
+			return
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		logErrorf("Failed to write decision log: %v", err)
+	}
+}
+
+// rotate shifts the backups, moves the log to path.1 and starts a new one.
+func (l *decisionLogSink) rotate() error {
+	l.file.Close()
+	l.file = nil
+	if l.maxBackups < 1 {
+		if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return l.open()
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxBackups))
+	for i := l.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+	logInfof("Rotated decision log %s", l.path)
+	return l.open()
+}
+
+// Reopen closes the log file and opens the file at its path again, e.g. after logrotate moved it away.
+func (l *decisionLogSink) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	return l.open()
+}
+
+func (l *decisionLogSink) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingSink counts decisions, optionally waiting on gate before each one.
+type countingSink struct {
+	mu     sync.Mutex
+	count  int
+	gate   chan struct{}
+	closed bool
+}
+
+func (s *countingSink) HandleDecision(authDecision) {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	s.count++
+	s.mu.Unlock()
+}
+
+func (s *countingSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return nil
+}
+
+func TestSinkPoolBlockPolicyLosesNothing(t *testing.T) {
+	sink := &countingSink{}
+	slow := DecisionSinkFunc(func(authDecision) { time.Sleep(time.Millisecond) })
+	pool, err := newSinkPool([]DecisionSink{sink, slow}, 2, 1, sinkPolicyBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const events = 100
+	for i := 0; i < events; i++ {
+		pool.Submit(authDecision{CN: "c"})
+	}
+	pool.Close()
+
+	if sink.count != events || pool.Dropped() != 0 {
+		t.Errorf("Expected %d delivered and none dropped, got %d delivered and %d dropped", events, sink.count, pool.Dropped())
+	}
+	if !sink.closed {
+		t.Error("Expected Close to close the sinks after draining")
+	}
+	pool.Close() // Closing twice is harmless
+	pool.Submit(authDecision{})
+	if pool.Dropped() != 1 {
+		t.Errorf("Expected a decision submitted after Close to be dropped, got %d dropped", pool.Dropped())
+	}
+}
+
+func TestSinkPoolDropPolicy(t *testing.T) {
+	sink := &countingSink{gate: make(chan struct{})}
+	pool, err := newSinkPool([]DecisionSink{sink}, 1, 1, sinkPolicyDrop)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One decision is held by the blocked worker, one fits in the queue, the rest are dropped.
+	pool.Submit(authDecision{})
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		pool.Submit(authDecision{})
+	}
+	close(sink.gate)
+	pool.Close()
+
+	if sink.count != 2 || pool.Dropped() != 4 {
+		t.Errorf("Expected 2 delivered and 4 dropped, got %d delivered and %d dropped", sink.count, pool.Dropped())
+	}
+}
+
+func TestNewSinkPoolValidates(t *testing.T) {
+	if _, err := newSinkPool(nil, 0, 1, sinkPolicyDrop); err == nil {
+		t.Error("Expected an error for zero workers")
+	}
+	if _, err := newSinkPool(nil, 1, 1, "maybe"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestServerWritesDecisionLog(t *testing.T) {
+	pki := newTestPKI(t)
+	logFile := filepath.Join(pki.Dir, "decisions.jsonl")
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.DecisionLogFile = logFile
+		s.SinkPolicy = sinkPolicyBlock
+	})
+
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.SendRequest(); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Stop(); err != nil { // Flushes the sinks
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("Expected a decision in the log")
+	}
+	var d authDecision
+	if err := json.Unmarshal(scanner.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if !d.Allowed || d.CN != pki.ClientCN {
+		t.Errorf("Unexpected logged decision: %+v", d)
+	}
+}
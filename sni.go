@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- SNI Virtual Hosting ---
+//
+// With SNICertificates the server presents a certificate chosen by the server name the client asks for
+// in SNI, like a web server hosting several names on one address. Names are matched exactly (case
+// insensitively) first, then against wildcards such as *.example.com, which cover one label. Clients
+// asking for another name, or for none (e.g. connecting to an IP address), get the default certificate
+// from CertFile and KeyFile. Client verification is the same whichever certificate is presented.
+// SIGHUP reloads every certificate; WatchServerCert only polls the default pair's files and OCSP
+// stapling only covers the default certificate.
+
+// SNICertificate is a certificate the server presents to clients asking for one of Hosts.
+type SNICertificate struct {
+	// Hosts lists the server names, exact or wildcards like *.example.com. Empty means the DNS names of
+	// the certificate (or its CN if it has none).
+	Hosts []string
+	// CertFile and KeyFile hold the key pair. KeyFile is ignored for a PKCS#12 CertFile.
+	CertFile string
+	KeyFile  string
+}
+
+// parseSNICertificate parses a --sni-cert value: [HOST[,HOST...]=]CERT:KEY, or [HOST[,HOST...]=]BUNDLE
+// for a PKCS#12 bundle.
+func parseSNICertificate(value string) (SNICertificate, error) {
+	var c SNICertificate
+	files := value
+	if hosts, rest, ok := strings.Cut(value, "="); ok {
+		files = rest
+		for _, host := range strings.Split(hosts, ",") {
+			if host = strings.TrimSpace(host); host != "" {
+				c.Hosts = append(c.Hosts, strings.ToLower(host))
+			}
+		}
+		if len(c.Hosts) == 0 {
+			return c, fmt.Errorf("no host names before '=' in %q", value)
+		}
+	}
+	if mtls.IsPKCS12File(files) {
+		c.CertFile, c.KeyFile = files, files
+		return c, nil
+	}
+	certFile, keyFile, ok := strings.Cut(files, ":")
+	if !ok || certFile == "" || keyFile == "" {
+		return c, fmt.Errorf("want [HOST[,HOST...]=]CERT:KEY or a .p12/.pfx bundle, got %q", value)
+	}
+	c.CertFile, c.KeyFile = certFile, keyFile
+	return c, nil
+}
+
+// sniCertificate is a loaded SNICertificate.
+type sniCertificate struct {
+	hosts []string
+	cert  *serverCertificate
+}
+
+// hostIndex maps server names, exact or wildcards like *.example.com, to values.
+type hostIndex[T any] struct {
+	exact     map[string]T
+	wildcards map[string]T // Parent domain of *.<domain> -> value
+}
+
+func newHostIndex[T any]() hostIndex[T] {
+	return hostIndex[T]{exact: make(map[string]T), wildcards: make(map[string]T)}
+}
+
+// add maps host to value, failing if host is already mapped.
+func (h hostIndex[T]) add(host string, value T) error {
+	host = strings.ToLower(host)
+	target, key := h.exact, host
+	if domain, ok := strings.CutPrefix(host, "*."); ok {
+		target, key = h.wildcards, domain
+	}
+	if _, taken := target[key]; taken {
+		return fmt.Errorf("host %s is listed more than once", host)
+	}
+	target[key] = value
+	return nil
+}
+
+// lookup returns the value for a server name: its exact match, else the wildcard covering it.
+func (h hostIndex[T]) lookup(serverName string) (T, bool) {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if value, ok := h.exact[name]; ok {
+		return value, true
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		value, ok := h.wildcards[parent]
+		return value, ok
+	}
+	var zero T
+	return zero, false
+}
+
+// sniCertificates selects the server certificate by SNI.
+type sniCertificates struct {
+	certs []sniCertificate
+	hosts hostIndex[*serverCertificate]
+}
+
+// loadSNICertificates loads the key pairs, checking their expiry like the default certificate's.
+func loadSNICertificates(specs []SNICertificate, expiryWarnDays int, strictExpiry bool) (*sniCertificates, error) {
+	c := &sniCertificates{hosts: newHostIndex[*serverCertificate]()}
+	for _, spec := range specs {
+		cert, err := loadServerCertificate(spec.CertFile, spec.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		leaf := cert.leaf()
+		if err := checkCertExpiry("server certificate "+spec.CertFile, leaf, expiryWarnDays, strictExpiry, time.Now()); err != nil {
+			return nil, err
+		}
+		hosts := spec.Hosts
+		if len(hosts) == 0 {
+			hosts = leaf.DNSNames
+			if len(hosts) == 0 && leaf.Subject.CommonName != "" {
+				hosts = []string{leaf.Subject.CommonName}
+			}
+		}
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("server certificate %s has no DNS names or CN, list its hosts with HOST=", spec.CertFile)
+		}
+		for _, host := range hosts {
+			if err := c.hosts.add(host, cert); err != nil {
+				return nil, fmt.Errorf("SNI certificate %s: %w", spec.CertFile, err)
+			}
+		}
+		c.certs = append(c.certs, sniCertificate{hosts: hosts, cert: cert})
+		logInfof("Serving %s for SNI %s (fingerprint %s)", spec.CertFile, strings.Join(hosts, ", "), mtls.CertFingerprint(leaf))
+	}
+	return c, nil
+}
+
+// lookup returns the certificate for a server name, or nil if none matches.
+func (c *sniCertificates) lookup(serverName string) *serverCertificate {
+	cert, _ := c.hosts.lookup(serverName)
+	return cert
+}
+
+// reload reloads every SNI certificate, returning the first error. Certificates that fail keep their current pair.
+func (c *sniCertificates) reload() error {
+	var firstErr error
+	for _, sc := range c.certs {
+		if err := sc.cert.reload(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		logInfof("Reloaded server certificate %s for SNI %s", sc.cert.certFile, strings.Join(sc.hosts, ", "))
+	}
+	return firstErr
+}
+
+// getCertificate is the tls.Config.GetCertificate callback with SNI certificates: the one matching the
+// client's server name, else the default.
+func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := s.sniCerts.lookup(hello.ServerName); cert != nil {
+		return cert.getCertificate(hello)
+	}
+	if hello.ServerName != "" {
+		logDebugf("No SNI certificate for '%s', presenting the default certificate", hello.ServerName)
+	}
+	return s.serverCert.getCertificate(hello)
+}
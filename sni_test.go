@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSNICert writes a self-signed certificate for dnsNames to <dir>/<name>.crt and .key.
+func writeSNICert(t *testing.T, dir, name string, dnsNames ...string) SNICertificate {
+	t.Helper()
+	certPEM, keyPEM, err := generateSelfSignedCert(certOptions{CommonName: name, DNSNames: dnsNames})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := SNICertificate{CertFile: filepath.Join(dir, name+".crt"), KeyFile: filepath.Join(dir, name+".key")}
+	if err := writeCertFiles(c.CertFile, c.KeyFile, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestParseSNICertificate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    SNICertificate
+		wantErr bool
+	}{
+		{value: "a.crt:a.key", want: SNICertificate{CertFile: "a.crt", KeyFile: "a.key"}},
+		{value: "API.example.com, *.example.org=a.crt:a.key", want: SNICertificate{Hosts: []string{"api.example.com", "*.example.org"}, CertFile: "a.crt", KeyFile: "a.key"}},
+		{value: "api.example.com=bundle.p12", want: SNICertificate{Hosts: []string{"api.example.com"}, CertFile: "bundle.p12", KeyFile: "bundle.p12"}},
+		{value: "a.crt", wantErr: true},
+		{value: ",=a.crt:a.key", wantErr: true},
+		{value: "api.example.com=a.crt:", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSNICertificate(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSNICertificate(%q): expected an error, got %+v", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSNICertificate(%q): %v", tt.value, err)
+			continue
+		}
+		if strings.Join(got.Hosts, ",") != strings.Join(tt.want.Hosts, ",") || got.CertFile != tt.want.CertFile || got.KeyFile != tt.want.KeyFile {
+			t.Errorf("parseSNICertificate(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSNICertificateSelection(t *testing.T) {
+	pki := newTestPKI(t)
+	api := writeSNICert(t, pki.Dir, "api", "api.example.test")
+	apps := writeSNICert(t, pki.Dir, "apps", "unused.example.test")
+	apps.Hosts = []string{"*.apps.example.test"}
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.SNICertificates = []SNICertificate{api, apps}
+	})
+	addr := strings.TrimPrefix(baseURL, "https://")
+	clientPair, err := tls.LoadX509KeyPair(pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		serverName string
+		wantCN     string
+	}{
+		{"api.example.test", "api"},
+		{"API.example.test.", "api"},
+		{"web.apps.example.test", "apps"},
+		{"a.b.apps.example.test", "localhost"}, // Wildcards cover one label
+		{"unused.example.test", "localhost"},   // Explicit hosts replace the names in the certificate
+		{"other.example.test", "localhost"},
+		{"", "localhost"},
+	}
+	for _, tt := range tests {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			ServerName:         tt.serverName,
+			InsecureSkipVerify: true, // Only the presented certificate matters here
+			Certificates:       []tls.Certificate{clientPair},
+		})
+		if err != nil {
+			t.Errorf("SNI %q: handshake failed: %v", tt.serverName, err)
+			continue
+		}
+		if cn := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; cn != tt.wantCN {
+			t.Errorf("SNI %q: expected the %s certificate, got %s", tt.serverName, tt.wantCN, cn)
+		}
+		conn.Close()
+	}
+}
+
+func TestClientSNIOverride(t *testing.T) {
+	pki := newTestPKI(t)
+	api := writeSNICert(t, pki.Dir, "api", "api.example.test")
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.SNICertificates = []SNICertificate{api}
+	})
+
+	// The URL names 127.0.0.1, which the api certificate doesn't cover; --sni makes it the name to verify.
+	client, err := NewClient(baseURL+"/hello", api.CertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetServerName("api.example.test")
+	body, status, err := client.SendRequest()
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the request to the api virtual host to succeed, got %d (%v)", status, err)
+	}
+	if !strings.Contains(body, "Server name: api.example.test") {
+		t.Errorf("Expected the response to name the requested server, got %q", body)
+	}
+
+	// Without the override the server presents the default certificate, which the api certificate doesn't verify.
+	client, err = NewClient(baseURL+"/hello", api.CertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Error("Expected the default certificate to fail verification against the api certificate")
+	}
+
+	if err := server.ReloadServerCertificate(); err != nil {
+		t.Errorf("Expected the SNI certificates to reload, got %v", err)
+	}
+}
+
+func TestSNICertificateDuplicateHost(t *testing.T) {
+	pki := newTestPKI(t)
+	a := writeSNICert(t, pki.Dir, "a", "api.example.test")
+	b := writeSNICert(t, pki.Dir, "b", "API.example.test")
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.SNICertificates = []SNICertificate{a, b}
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Fatal("Expected a host listed for two certificates to fail Start")
+	} else if !strings.Contains(err.Error(), "api.example.test") {
+		t.Errorf("Expected the error to name the host, got %v", err)
+	}
+}
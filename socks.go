@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// --- SOCKS5 over mTLS ---
+//
+// With a TunnelAllowlistFile instead of a TunnelTarget, the tunnel server lets each client pick its
+// destination: right after the handshake the client sends one "CONNECT host:port" line, and the server
+// answers "OK", "DENIED <reason>" or "FAILED <reason>" before the stream starts. A destination must match
+// one of the patterns the allowlist gives the client's CN (or the identity --client-id-source selects):
+//
+//	# <client pattern> <destination pattern>[,<destination pattern>...]
+//	build-*   db.internal:5432,cache.internal:*
+//	alice     *.example.com:443
+//
+// Patterns are globs as in path.Match, compared ignoring case; a destination equal to a pattern always
+// matches, so [::1]:22 works as is. Names are matched as the client sent them, before resolving.
+// `client socks` puts a SOCKS5 frontend (RFC 1928, CONNECT without authentication) in front of this,
+// so curl --socks5-hostname, ssh -o ProxyCommand='nc -X 5 ...' or a browser can use the tunnel.
+
+// Lines of the destination exchange of a dynamic tunnel.
+const (
+	tunnelConnectPrefix = "CONNECT "
+	tunnelReplyOK       = "OK"
+	tunnelReplyDenied   = "DENIED"
+	tunnelReplyFailed   = "FAILED"
+)
+
+// tunnelRequestMaxLen bounds the CONNECT line, host names being at most 253 bytes.
+const tunnelRequestMaxLen = 512
+
+// tunnelAllowRule gives the clients matching a pattern the destinations matching any of its patterns.
+type tunnelAllowRule struct {
+	client       string
+	destinations []string
+}
+
+// tunnelAllowlist holds the rules of TunnelAllowlistFile, in file order.
+type tunnelAllowlist struct {
+	rules []tunnelAllowRule
+}
+
+// loadTunnelAllowlist reads and parses a tunnel allowlist file.
+func loadTunnelAllowlist(file string) (*tunnelAllowlist, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tunnel allowlist %s: %w", file, err)
+	}
+	allowlist, err := parseTunnelAllowlist(content)
+	if err != nil {
+		return nil, fmt.Errorf("tunnel allowlist %s: %w", file, err)
+	}
+	return allowlist, nil
+}
+
+// parseTunnelAllowlist parses '<client pattern> <destination pattern>[,...]' lines, skipping empty lines and
+// # comments.
+func parseTunnelAllowlist(content []byte) (*tunnelAllowlist, error) {
+	allowlist := &tunnelAllowlist{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: format should be '<client pattern> <destination pattern>[,...]'", line)
+		}
+		rule := tunnelAllowRule{client: fields[0]}
+		for _, destination := range strings.Split(fields[1], ",") {
+			if _, port, err := net.SplitHostPort(destination); err != nil || port == "" {
+				return nil, fmt.Errorf("line %d: destination %q must be host:port", line, destination)
+			}
+			rule.destinations = append(rule.destinations, destination)
+		}
+		if err := validateNamePatterns(append([]string{rule.client}, rule.destinations...)); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		allowlist.rules = append(allowlist.rules, rule)
+	}
+	return allowlist, scanner.Err()
+}
+
+// allows reports whether the client may reach destination, a host:port.
+func (a *tunnelAllowlist) allows(client, destination string) bool {
+	for _, rule := range a.rules {
+		if !matchesAny([]string{rule.client}, client) {
+			continue
+		}
+		for _, pattern := range rule.destinations {
+			if strings.EqualFold(pattern, destination) || matchesAny([]string{pattern}, destination) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readTunnelRequest reads the CONNECT line of a dynamic tunnel and returns its destination.
+func readTunnelRequest(conn net.Conn) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(echoHandshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	reader := bufio.NewReaderSize(conn, tunnelRequestMaxLen)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read the destination: %w", err)
+	}
+	if reader.Buffered() > 0 { // The client must wait for the reply before sending the stream
+		return "", errors.New("data sent before the destination was accepted")
+	}
+	request := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(request, tunnelConnectPrefix) {
+		return "", fmt.Errorf("expected %q followed by host:port, got %q", tunnelConnectPrefix, request)
+	}
+	destination := strings.TrimPrefix(request, tunnelConnectPrefix)
+	if _, _, err := net.SplitHostPort(destination); err != nil {
+		return "", fmt.Errorf("invalid destination %q: %w", destination, err)
+	}
+	return destination, nil
+}
+
+// writeTunnelReply sends a reply line of the destination exchange.
+func writeTunnelReply(conn net.Conn, reply, reason string) error {
+	if reason != "" {
+		reply += " " + reason
+	}
+	_, err := io.WriteString(conn, reply+"\n")
+	return err
+}
+
+// SOCKS5 protocol values (RFC 1928) the frontend uses.
+const (
+	socksVersion              = 0x05
+	socksMethodNoAuth         = 0x00
+	socksMethodNoAcceptable   = 0xff
+	socksCommandConnect       = 0x01
+	socksAddrIPv4             = 0x01
+	socksAddrDomain           = 0x03
+	socksAddrIPv6             = 0x04
+	socksReplySucceeded       = 0x00
+	socksReplyGeneralFailure  = 0x01
+	socksReplyNotAllowed      = 0x02
+	socksReplyHostUnreach     = 0x04
+	socksReplyCmdUnsupported  = 0x07
+	socksReplyAddrUnsupported = 0x08
+)
+
+// readSOCKSRequest runs the SOCKS5 method negotiation and reads the CONNECT request, answering requests
+// it can't serve itself. It returns the requested destination as host:port.
+func readSOCKSRequest(conn net.Conn) (string, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS greeting: %w", err)
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS methods: %w", err)
+	}
+	if !bytes.Contains(methods, []byte{socksMethodNoAuth}) {
+		conn.Write([]byte{socksVersion, socksMethodNoAcceptable})
+		return "", errors.New("SOCKS client offers no method without authentication")
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksMethodNoAuth}); err != nil {
+		return "", err
+	}
+
+	var request [4]byte // Version, command, reserved, address type
+	if _, err := io.ReadFull(conn, request[:]); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS request: %w", err)
+	}
+	if request[1] != socksCommandConnect {
+		writeSOCKSReply(conn, socksReplyCmdUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d, only CONNECT is", request[1])
+	}
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS address: %w", err)
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS address: %w", err)
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", fmt.Errorf("failed to read SOCKS address: %w", err)
+		}
+		host = string(name)
+	default:
+		writeSOCKSReply(conn, socksReplyAddrUnsupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS port: %w", err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// writeSOCKSReply answers a SOCKS5 request. The bound address is left unspecified: the connection to the
+// destination is made by the server, not by this proxy.
+func writeSOCKSReply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socksVersion, reply, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// SOCKS accepts SOCKS5 connections on listener and carries each over a new mTLS connection to the
+// tunnel server at the host and port of ServerURL, which connects to the requested destination if its
+// allowlist lets this client reach it. It runs until ctx is done.
+func (c *Client) SOCKS(ctx context.Context, listener net.Listener) error {
+	addr, err := c.echoAddr()
+	if err != nil {
+		return err
+	}
+	logInfof("Serving SOCKS5 on %s through %s", listener.Addr(), addr)
+	return serveLocal(ctx, listener, func(local net.Conn) {
+		local.SetDeadline(time.Now().Add(echoHandshakeTimeout))
+		destination, err := readSOCKSRequest(local)
+		if err != nil {
+			logWarnf("SOCKS connection from %s: %v", local.RemoteAddr(), err)
+			return
+		}
+		tlsConn, err := c.dialTunnel(ctx, addr)
+		if err != nil {
+			logErrorf("SOCKS connection to %s: %v", destination, err)
+			writeSOCKSReply(local, socksReplyGeneralFailure)
+			return
+		}
+		defer tlsConn.Close()
+
+		tlsConn.SetDeadline(time.Now().Add(tunnelDialTimeout + echoHandshakeTimeout)) // The server dials first
+		reply, err := exchangeTunnelDestination(tlsConn, destination)
+		if err != nil {
+			logErrorf("SOCKS connection to %s: %v", destination, err)
+			writeSOCKSReply(local, reply)
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		local.SetDeadline(time.Time{})
+		if err := writeSOCKSReply(local, socksReplySucceeded); err != nil {
+			return
+		}
+		logInfof("SOCKS connection from %s to %s opened", local.RemoteAddr(), destination)
+		sent, received := pipeConns(local, tlsConn)
+		logInfof("SOCKS connection from %s to %s closed after %d bytes sent and %d received", local.RemoteAddr(), destination, sent, received)
+	})
+}
+
+// exchangeTunnelDestination asks the tunnel server for destination. On error it also returns the SOCKS
+// reply that fits.
+func exchangeTunnelDestination(conn net.Conn, destination string) (byte, error) {
+	if _, err := io.WriteString(conn, tunnelConnectPrefix+destination+"\n"); err != nil {
+		return socksReplyGeneralFailure, fmt.Errorf("failed to send the destination: %w", err)
+	}
+	line, err := bufio.NewReaderSize(conn, tunnelRequestMaxLen).ReadString('\n') // Nothing follows until the stream
+	if err != nil {
+		// With TLS 1.3 a rejected client only learns about it here, from the server's alert
+		return socksReplyGeneralFailure, fmt.Errorf("tunnel server closed the connection: %w", err)
+	}
+	reply, reason, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch reply {
+	case tunnelReplyOK:
+		return socksReplySucceeded, nil
+	case tunnelReplyDenied:
+		return socksReplyNotAllowed, fmt.Errorf("tunnel server denied the destination: %s", reason)
+	case tunnelReplyFailed:
+		return socksReplyHostUnreach, fmt.Errorf("tunnel server failed to connect: %s", reason)
+	}
+	return socksReplyGeneralFailure, fmt.Errorf("unexpected reply from the tunnel server: %q", line)
+}
+
+// ClientSOCKSCmd runs a local SOCKS5 proxy that reaches destinations through a tunnel server.
+type ClientSOCKSCmd struct {
+	Listen string `kong:"name='listen',help='Local address to serve SOCKS5 on; port 0 picks a free one.',default='localhost:1080'"`
+}
+
+// Run serves SOCKS5 until SIGINT or SIGTERM.
+func (p *ClientSOCKSCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", p.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", p.Listen, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return client.SOCKS(ctx, listener)
+}
+
+// tunnelDestination returns where a tunnel connection from the client identified by id goes: TunnelTarget,
+// or with a tunnel allowlist the destination the client asks for, if allowed. Denials are answered here.
+func (s *Server) tunnelDestination(conn net.Conn, id string) (string, error) {
+	if s.tunnelAllow == nil {
+		return s.TunnelTarget, nil
+	}
+	destination, err := readTunnelRequest(conn)
+	if err != nil {
+		writeTunnelReply(conn, tunnelReplyFailed, "bad request")
+		return "", err
+	}
+	if !s.tunnelAllow.allows(id, destination) {
+		writeTunnelReply(conn, tunnelReplyDenied, "not in the tunnel allowlist")
+		return "", fmt.Errorf("destination %s is not in the tunnel allowlist for %s", destination, id)
+	}
+	return destination, nil
+}
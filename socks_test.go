@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTunnelAllowlist(t *testing.T) {
+	allowlist, err := parseTunnelAllowlist([]byte(`
+# client    destinations
+build-*     db.internal:5432,cache.internal:*
+alice       *.example.com:443 # web only
+*           [::1]:22
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		client, destination string
+		allowed             bool
+	}{
+		{"build-42", "db.internal:5432", true},
+		{"build-42", "DB.internal:5432", true},
+		{"build-42", "cache.internal:6379", true},
+		{"build-42", "db.internal:5433", false},
+		{"alice", "www.example.com:443", true},
+		{"alice", "db.internal:5432", false},
+		{"bob", "[::1]:22", true},
+		{"bob", "www.example.com:443", false},
+	} {
+		if got := allowlist.allows(tc.client, tc.destination); got != tc.allowed {
+			t.Errorf("allows(%q, %q) = %v, want %v", tc.client, tc.destination, got, tc.allowed)
+		}
+	}
+
+	for _, bad := range []string{"alice", "alice db.internal", "alice a:1 b:2", "alice db.internal:"} {
+		if _, err := parseTunnelAllowlist([]byte(bad)); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+// startTestSOCKS runs client.SOCKS on a free local port until the test ends.
+func startTestSOCKS(t *testing.T, client *Client) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.SOCKS(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+// socksConnect asks the SOCKS5 proxy at addr for a domain-name destination and returns the connection
+// and the reply code.
+func socksConnect(t *testing.T, addr, destination string) (net.Conn, byte) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := conn.Write([]byte{socksVersion, 1, socksMethodNoAuth}); err != nil {
+		t.Fatal(err)
+	}
+	var method [2]byte
+	if _, err := io.ReadFull(conn, method[:]); err != nil || method[1] != socksMethodNoAuth {
+		t.Fatalf("Expected the no-authentication method, got %v (%v)", method, err)
+	}
+	host, portText, _ := net.SplitHostPort(destination)
+	port, _ := strconv.Atoi(portText)
+	request := append([]byte{socksVersion, socksCommandConnect, 0x00, socksAddrDomain, byte(len(host))}, host...)
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	var reply [10]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		t.Fatalf("Failed to read the SOCKS reply: %v", err)
+	}
+	return conn, reply[1]
+}
+
+func TestSOCKSThroughTunnelAllowlist(t *testing.T) {
+	pki := newTestPKI(t)
+	target, conns := startUpperTarget(t)
+	_, targetPort, _ := net.SplitHostPort(target)
+	allowlistFile := filepath.Join(pki.Dir, "tunnel-allowlist.txt")
+	if err := ioutil.WriteFile(allowlistFile, []byte(pki.ClientCN+" localhost:"+targetPort+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, url := startTestServer(t, pki, func(s *Server) {
+		s.Mode = serverModeTunnel
+		s.TunnelAllowlistFile = allowlistFile
+	})
+	addr := startTestSOCKS(t, newTestClient(t, pki, url))
+
+	conn, reply := socksConnect(t, addr, "localhost:"+targetPort)
+	if reply != socksReplySucceeded {
+		t.Fatalf("Expected the allowed destination to be reached, got SOCKS reply %d", reply)
+	}
+	io.WriteString(conn, "hello through socks\n")
+	conn.(*net.TCPConn).CloseWrite()
+	if data, _ := ioutil.ReadAll(conn); string(data) != "HELLO THROUGH SOCKS\n" {
+		t.Errorf("Expected the target's answer, got %q", data)
+	}
+
+	if _, reply := socksConnect(t, addr, "127.0.0.1:"+targetPort); reply != socksReplyNotAllowed {
+		t.Errorf("Expected a destination outside the allowlist to be refused, got SOCKS reply %d", reply)
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("Expected only the allowed destination to be dialed, got %d connections", n)
+	}
+}
+
+func TestTunnelAllowlistExcludesTunnelTarget(t *testing.T) {
+	pki := newTestPKI(t)
+	allowlistFile := filepath.Join(pki.Dir, "tunnel-allowlist.txt")
+	if err := ioutil.WriteFile(allowlistFile, []byte("* localhost:5432\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.Mode = serverModeTunnel
+	server.TunnelTarget = "localhost:5432"
+	server.TunnelAllowlistFile = allowlistFile
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "--tunnel-allowlist") {
+		server.Stop()
+		t.Errorf("Expected the server to refuse both a target and an allowlist, got %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- SPIFFE Workload API ---
+//
+// With --spiffe-socket the client, and optionally the server, take their identity from a SPIFFE Workload
+// API (e.g. the socket of a SPIRE agent) instead of files: an X.509 SVID, a certificate whose URI SAN is
+// the SPIFFE ID of the workload (spiffe://example.org/web), with its key, and the trust bundle of the
+// trust domain to verify the other side against. The FetchX509SVID stream pushes a new SVID and bundle
+// whenever the agent rotates them, and new handshakes use them from then on, so short-lived SVIDs never
+// need a restart. Established connections keep the SVID they negotiated with.
+//
+// The other side is verified by its chain against the bundle and, optionally, by its SPIFFE ID, but not
+// by host name: SVIDs usually have no DNS SAN. On the server, list clients in the known clients file by
+// SPIFFE ID with --client-id-source uri-san, or let the bundle alone decide with --verify-mode ca.
+//
+// The API is a gRPC service with a handful of small messages, so they are encoded by hand with protowire
+// instead of generated code (see workload.proto in the SPIFFE specification).
+
+// spiffeFetchX509SVIDMethod is the full name of the RPC streaming X.509 SVIDs and bundles.
+const spiffeFetchX509SVIDMethod = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+// spiffeMetadataKey is the gRPC metadata the Workload API requires on every call, to tell
+// workloads apart from other callers reaching the socket by accident (e.g. through a proxy).
+const spiffeMetadataKey = "workload.spiffe.io"
+
+const (
+	// spiffeFetchTimeout bounds the wait for the first SVID.
+	spiffeFetchTimeout = 10 * time.Second
+	// spiffeRetryBackoff is the wait before reconnecting to the Workload API; it doubles with each
+	// failed attempt up to spiffeMaxRetryBackoff.
+	spiffeRetryBackoff    = time.Second
+	spiffeMaxRetryBackoff = 30 * time.Second
+)
+
+// x509SVID is the identity a workload gets from the Workload API.
+type x509SVID struct {
+	ID     string          // SPIFFE ID
+	Cert   tls.Certificate // With its chain and key, and Leaf set
+	Bundle *x509.CertPool  // Trust bundle of the trust domain
+}
+
+// spiffeSource keeps the current X.509 SVID of a workload, as streamed by the Workload API.
+type spiffeSource struct {
+	addr     string
+	conn     *grpc.ClientConn
+	onUpdate func(*x509SVID) // Called with every SVID received, including the first
+
+	current atomic.Pointer[x509SVID]
+	ready   chan struct{} // Closed when the first SVID arrives
+	once    sync.Once
+	mu      sync.Mutex
+	lastErr error // Why the stream last failed
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newSPIFFESource connects to the Workload API at addr (unix:///path, a plain socket path, or
+// tcp://host:port) and waits for the first SVID. It keeps streaming until Close, calling onUpdate, if not
+// nil, with each SVID received.
+func newSPIFFESource(addr string, onUpdate func(*x509SVID)) (*spiffeSource, error) {
+	target := addr
+	if !strings.Contains(target, "://") {
+		target = unixAddrPrefix + target
+	}
+	if hostPort, ok := strings.CutPrefix(target, "tcp://"); ok {
+		target = "dns:///" + hostPort // gRPC's name for host:port targets
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the SPIFFE Workload API at %s: %w", addr, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &spiffeSource{
+		addr:     addr,
+		conn:     conn,
+		onUpdate: onUpdate,
+		ready:    make(chan struct{}),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go s.watch(ctx)
+
+	select {
+	case <-s.ready:
+		return s, nil
+	case <-time.After(spiffeFetchTimeout):
+	}
+	s.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastErr == nil {
+		return nil, fmt.Errorf("no X.509 SVID from the SPIFFE Workload API at %s within %s", addr, spiffeFetchTimeout)
+	}
+	return nil, fmt.Errorf("no X.509 SVID from the SPIFFE Workload API at %s within %s: %w", addr, spiffeFetchTimeout, s.lastErr)
+}
+
+// Close stops streaming SVIDs. The current one stays available.
+func (s *spiffeSource) Close() {
+	s.cancel()
+	<-s.done
+	s.conn.Close()
+}
+
+// svid returns the current SVID.
+func (s *spiffeSource) svid() *x509SVID {
+	return s.current.Load()
+}
+
+// watch streams SVIDs, reconnecting with backoff whenever the stream ends, until ctx is canceled.
+func (s *spiffeSource) watch(ctx context.Context) {
+	defer close(s.done)
+	backoff := spiffeRetryBackoff
+	for {
+		received, err := s.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if received {
+			backoff = spiffeRetryBackoff
+		}
+		s.mu.Lock()
+		s.lastErr = err
+		s.mu.Unlock()
+		logWarnf("SPIFFE Workload API stream from %s ended, reconnecting in %s: %v", s.addr, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, spiffeMaxRetryBackoff)
+	}
+}
+
+// stream runs one FetchX509SVID call until it fails, and reports whether it received an SVID.
+func (s *spiffeSource) stream(ctx context.Context) (bool, error) {
+	ctx = metadata.AppendToOutgoingContext(ctx, spiffeMetadataKey, "true")
+	stream, err := s.conn.NewStream(ctx, &grpc.StreamDesc{StreamName: "FetchX509SVID", ServerStreams: true},
+		spiffeFetchX509SVIDMethod, grpc.ForceCodec(rawProtoCodec{}))
+	if err != nil {
+		return false, err
+	}
+	if err := stream.SendMsg(new(rawProto)); err != nil { // An empty X509SVIDRequest
+		return false, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return false, err
+	}
+	received := false
+	for {
+		var resp rawProto
+		if err := stream.RecvMsg(&resp); err != nil {
+			return received, err
+		}
+		svid, err := parseX509SVIDResponse(resp)
+		if err != nil {
+			logErrorf("Ignoring an X.509 SVID update from the SPIFFE Workload API: %v", err)
+			continue
+		}
+		received = true
+		s.update(svid)
+	}
+}
+
+// update makes svid the current SVID.
+func (s *spiffeSource) update(svid *x509SVID) {
+	verb := "Rotated to"
+	if s.current.Swap(svid) == nil {
+		verb = "Received"
+	}
+	leaf := svid.Cert.Leaf
+	logInfof("%s X.509 SVID %s from the SPIFFE Workload API (fingerprint %s, expires %s)",
+		verb, svid.ID, mtls.CertFingerprint(leaf), leaf.NotAfter.Format(time.RFC3339))
+	if s.onUpdate != nil {
+		s.onUpdate(svid)
+	}
+	s.once.Do(func() { close(s.ready) })
+}
+
+// getClientCertificate is the tls.Config.GetClientCertificate callback presenting the current SVID.
+func (s *spiffeSource) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return &s.svid().Cert, nil
+}
+
+// verifyPeer returns a tls.Config.VerifyPeerCertificate callback accepting an SVID that chains to the
+// current bundle and, if id is not empty, has that SPIFFE ID.
+func (s *spiffeSource) verifyPeer(id string) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no peer certificate presented")
+		}
+		intermediates := x509.NewCertPool()
+		var leaf *x509.Certificate
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("failed to parse peer certificate: %w", err)
+			}
+			if i == 0 {
+				leaf = cert
+			} else {
+				intermediates.AddCert(cert)
+			}
+		}
+		if _, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         s.svid().Bundle,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}); err != nil {
+			return fmt.Errorf("peer SVID does not chain to the SPIFFE trust bundle: %w", err)
+		}
+		peerID, err := spiffeID(leaf)
+		if err != nil {
+			return err
+		}
+		if id != "" && peerID != id {
+			return fmt.Errorf("peer SPIFFE ID %s is not the expected %s", peerID, id)
+		}
+		return nil
+	}
+}
+
+// spiffeID returns the SPIFFE ID of an SVID, its only URI SAN.
+func spiffeID(cert *x509.Certificate) (string, error) {
+	if len(cert.URIs) != 1 || cert.URIs[0].Scheme != "spiffe" {
+		return "", fmt.Errorf("certificate of CN '%s' is not an SVID: want exactly one spiffe:// URI SAN", cert.Subject.CommonName)
+	}
+	return cert.URIs[0].String(), nil
+}
+
+// rotateClientCAs makes each handshake verify client certificates against the current bundle instead of
+// the one cfg.ClientCAs was set to.
+func (s *spiffeSource) rotateClientCAs(cfg *tls.Config) {
+	mtls.WrapConfigForClient(cfg, func(_ *tls.ClientHelloInfo, connCfg *tls.Config) {
+		connCfg.ClientCAs = s.svid().Bundle
+	})
+}
+
+// NewSPIFFEClient creates a client presenting the X.509 SVID from the SPIFFE Workload API at socket,
+// rotating it as the API does. It trusts a server whose SVID chains to the trust bundle and, if serverID
+// is not empty, has that SPIFFE ID.
+func NewSPIFFEClient(serverURL, socket, serverID string) (*Client, error) {
+	source, err := newSPIFFESource(socket, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := newClientWithTLSConfig(serverURL, &tls.Config{
+		MinVersion:            tls.VersionTLS12,
+		InsecureSkipVerify:    true, // Verified against the bundle by SPIFFE ID instead of by host name
+		VerifyPeerCertificate: source.verifyPeer(serverID),
+		GetClientCertificate:  source.getClientCertificate,
+	})
+	client.spiffe = source
+	return client, nil
+}
+
+// useSPIFFE makes the server present the X.509 SVID from the SPIFFE Workload API at SPIFFESocket instead
+// of CertFile and KeyFile, and returns the trust bundle for verify modes that use a CA.
+func (s *Server) useSPIFFE() (*x509.CertPool, error) {
+	if s.ocspStapling() {
+		return nil, errors.New("OCSP stapling requires a server certificate file, not an SVID")
+	}
+	s.serverCert = &serverCertificate{}
+	source, err := newSPIFFESource(s.SPIFFESocket, func(svid *x509SVID) {
+		s.serverCert.current.Store(&svid.Cert)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.spiffe = source
+	return source.svid().Bundle, nil
+}
+
+// rawProto is an encoded protobuf message.
+type rawProto []byte
+
+// rawProtoCodec passes rawProto messages through as they are. It is named "proto" so the server decodes
+// them as usual.
+type rawProtoCodec struct{}
+
+// Marshal returns the encoded message.
+func (rawProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(*rawProto)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *msg, nil
+}
+
+// Unmarshal keeps a copy of the encoded message.
+func (rawProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(*rawProto)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*msg = append((*msg)[:0], data...)
+	return nil
+}
+
+// Name returns the name of the codec.
+func (rawProtoCodec) Name() string { return "proto" }
+
+// parseX509SVIDResponse decodes the first (default) SVID of an X509SVIDResponse:
+//
+//	message X509SVIDResponse { repeated X509SVID svids = 1; ... }
+//	message X509SVID {
+//	    string spiffe_id = 1;
+//	    bytes x509_svid = 2;     // ASN.1 DER certificates, leaf first
+//	    bytes x509_svid_key = 3; // ASN.1 DER PKCS#8 private key
+//	    bytes bundle = 4;        // ASN.1 DER CA certificates
+//	}
+func parseX509SVIDResponse(resp []byte) (*x509SVID, error) {
+	var first []byte
+	err := protoFields(resp, func(num protowire.Number, value []byte) {
+		if num == 1 && first == nil {
+			first = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if first == nil {
+		return nil, errors.New("response has no SVID")
+	}
+	var id string
+	var certsDER, keyDER, bundleDER []byte
+	err = protoFields(first, func(num protowire.Number, value []byte) {
+		switch num {
+		case 1:
+			id = string(value)
+		case 2:
+			certsDER = value
+		case 3:
+			keyDER = value
+		case 4:
+			bundleDER = value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := x509.ParseCertificates(certsDER)
+	if err != nil || len(certs) == 0 {
+		return nil, fmt.Errorf("invalid certificates in SVID %s: %v", id, err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	var chainPEM []byte
+	for _, cert := range certs {
+		chainPEM = append(chainPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	pair, err := tls.X509KeyPair(chainPEM, keyPEM) // Checks that the key belongs to the leaf
+	if err != nil {
+		return nil, fmt.Errorf("invalid SVID %s: %w", id, err)
+	}
+	pair.Leaf = certs[0]
+	if leafID, err := spiffeID(certs[0]); err != nil {
+		return nil, err
+	} else if leafID != id {
+		return nil, fmt.Errorf("SVID for %s has SPIFFE ID %s", id, leafID)
+	}
+
+	roots, err := x509.ParseCertificates(bundleDER)
+	if err != nil || len(roots) == 0 {
+		return nil, fmt.Errorf("invalid trust bundle in SVID %s: %v", id, err)
+	}
+	bundle := x509.NewCertPool()
+	for _, root := range roots {
+		bundle.AddCert(root)
+	}
+	return &x509SVID{ID: id, Cert: pair, Bundle: bundle}, nil
+}
+
+// protoFields calls fn with the number and value of every length-delimited field of a protobuf message,
+// skipping the other fields.
+func protoFields(msg []byte, fn func(num protowire.Number, value []byte)) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return fmt.Errorf("malformed protobuf message: %w", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+		if typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				return fmt.Errorf("malformed protobuf message: %w", protowire.ParseError(n))
+			}
+			fn(num, value)
+			msg = msg[n:]
+			continue
+		}
+		if n = protowire.ConsumeFieldValue(num, typ, msg); n < 0 {
+			return fmt.Errorf("malformed protobuf message: %w", protowire.ParseError(n))
+		}
+		msg = msg[n:]
+	}
+	return nil
+}
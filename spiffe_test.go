@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"tls-playground/pkg/mtls"
+)
+
+// fakeWorkloadAPI serves FetchX509SVID on a Unix socket, streaming the SVIDs pushed to it.
+type fakeWorkloadAPI struct {
+	Addr    string
+	updates chan rawProto
+}
+
+// newFakeWorkloadAPI starts a Workload API that sends resp to every new stream.
+func newFakeWorkloadAPI(t *testing.T, resp rawProto) *fakeWorkloadAPI {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := &fakeWorkloadAPI{Addr: unixAddrPrefix + path, updates: make(chan rawProto)}
+	server := grpc.NewServer(grpc.ForceServerCodec(rawProtoCodec{}), grpc.UnknownServiceHandler(func(_ interface{}, stream grpc.ServerStream) error {
+		if method, _ := grpc.MethodFromServerStream(stream); method != spiffeFetchX509SVIDMethod {
+			return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+		}
+		if md, _ := metadata.FromIncomingContext(stream.Context()); strings.Join(md.Get(spiffeMetadataKey), "") != "true" {
+			return status.Error(codes.InvalidArgument, "security header missing from request")
+		}
+		if err := stream.RecvMsg(new(rawProto)); err != nil {
+			return err
+		}
+		for {
+			if err := stream.SendMsg(&resp); err != nil {
+				return err
+			}
+			select {
+			case resp = <-api.updates:
+			case <-stream.Context().Done():
+				return nil
+			}
+		}
+	}))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return api
+}
+
+// Push streams resp to the connected workload.
+func (a *fakeWorkloadAPI) Push(t *testing.T, resp rawProto) {
+	t.Helper()
+	select {
+	case a.updates <- resp:
+	case <-time.After(5 * time.Second):
+		t.Fatal("No workload is streaming from the fake Workload API")
+	}
+}
+
+// issueSVID returns an X509SVIDResponse with an SVID for id signed by ca, and ca as the bundle.
+func issueSVID(t *testing.T, ca *testCA, id string) rawProto {
+	t.Helper()
+	return issueSVIDClaiming(t, ca, id, id)
+}
+
+// issueSVIDClaiming is issueSVID with the response claiming the SPIFFE ID claimedID.
+func issueSVIDClaiming(t *testing.T, ca *testCA, id, claimedID string) rawProto {
+	t.Helper()
+	u, err := url.Parse(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, keyPEM, err := generateCert(certOptions{URIs: []*url.URL{u}, KeyType: keyTypeECDSA}, ca.Cert, ca.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(pair.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var svid []byte
+	svid = protowire.AppendTag(svid, 1, protowire.BytesType)
+	svid = protowire.AppendString(svid, claimedID)
+	svid = protowire.AppendTag(svid, 2, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, pair.Certificate[0])
+	svid = protowire.AppendTag(svid, 3, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, keyDER)
+	svid = protowire.AppendTag(svid, 4, protowire.BytesType)
+	svid = protowire.AppendBytes(svid, ca.Cert.Raw)
+	resp := protowire.AppendTag(nil, 1, protowire.BytesType)
+	return protowire.AppendBytes(resp, svid)
+}
+
+// waitForSVID waits until source has received the SVID in resp.
+func waitForSVID(t *testing.T, source *spiffeSource, resp rawProto) {
+	t.Helper()
+	want, err := parseX509SVIDResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if source.svid().Cert.Leaf.Equal(want.Cert.Leaf) {
+			return
+		}
+	}
+	t.Fatalf("Expected the SVID for %s to be rotated in", want.ID)
+}
+
+func TestSPIFFEClientAndServer(t *testing.T) {
+	pki := newTestPKI(t)
+	ca := newTestCA(t, t.TempDir())
+	serverAPI := newFakeWorkloadAPI(t, issueSVID(t, ca, "spiffe://example.org/server"))
+	clientAPI := newFakeWorkloadAPI(t, issueSVID(t, ca, "spiffe://example.org/client"))
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.SPIFFESocket = serverAPI.Addr
+		s.VerifyMode = verifyModeCA
+	})
+
+	client, err := NewSPIFFEClient(baseURL+"/hello", clientAPI.Addr, "spiffe://example.org/server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.spiffe.Close)
+	client.SetTransportOptions(TransportOptions{DisableKeepAlives: true}) // A handshake per request
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the SVIDs to authenticate both sides, got %d (%v)", status, err)
+	}
+
+	other, err := NewSPIFFEClient(baseURL+"/hello", clientAPI.Addr, "spiffe://example.org/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(other.spiffe.Close)
+	if _, _, err := other.SendRequest(); err == nil || !strings.Contains(err.Error(), "is not the expected spiffe://example.org/other") {
+		t.Errorf("Expected a server with another SPIFFE ID to be rejected, got %v", err)
+	}
+
+	// The trust domain moves to a new CA: the client rotates first, and can't reach the server until it does too
+	newCA := newTestCA(t, t.TempDir())
+	clientSVID := issueSVID(t, newCA, "spiffe://example.org/client")
+	clientAPI.Push(t, clientSVID)
+	waitForSVID(t, client.spiffe, clientSVID)
+	if _, _, err := client.SendRequest(); err == nil || !strings.Contains(err.Error(), "does not chain to the SPIFFE trust bundle") {
+		t.Errorf("Expected the old server SVID to be rejected after the bundle rotated, got %v", err)
+	}
+	serverSVID := issueSVID(t, newCA, "spiffe://example.org/server")
+	serverAPI.Push(t, serverSVID)
+	waitForSVID(t, server.spiffe, serverSVID)
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the rotated SVIDs to authenticate both sides, got %d (%v)", status, err)
+	}
+}
+
+func TestSPIFFEServerListsClientsBySPIFFEID(t *testing.T) {
+	pki := newTestPKI(t)
+	ca := newTestCA(t, t.TempDir())
+	clientSVID := issueSVID(t, ca, "spiffe://example.org/client")
+	svid, err := parseX509SVIDResponse(clientSVID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte("spiffe://example.org/client "+mtls.CertFingerprint(svid.Cert.Leaf)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	serverAPI := newFakeWorkloadAPI(t, issueSVID(t, ca, "spiffe://example.org/server"))
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.SPIFFESocket = serverAPI.Addr
+		s.ClientIDSource = mtls.IdentityURISAN
+	})
+
+	for id, wantOK := range map[string]bool{"spiffe://example.org/client": true, "spiffe://example.org/intruder": false} {
+		resp := clientSVID
+		if id != "spiffe://example.org/client" {
+			resp = issueSVID(t, ca, id)
+		}
+		client, err := NewSPIFFEClient(baseURL+"/hello", newFakeWorkloadAPI(t, resp).Addr, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(client.spiffe.Close)
+		_, status, err := client.SendRequest()
+		if ok := err == nil && status == http.StatusOK; ok != wantOK {
+			t.Errorf("Expected %s to be allowed: %t, got %d (%v)", id, wantOK, status, err)
+		}
+	}
+}
+
+func TestParseX509SVIDResponse(t *testing.T) {
+	ca := newTestCA(t, t.TempDir())
+	resp := issueSVID(t, ca, "spiffe://example.org/web")
+	svid, err := parseX509SVIDResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svid.ID != "spiffe://example.org/web" || svid.Cert.Leaf == nil || svid.Bundle == nil {
+		t.Errorf("Unexpected SVID %+v", svid)
+	}
+
+	forged := issueSVIDClaiming(t, ca, "spiffe://example.org/web", "spiffe://example.org/admin")
+	if _, err := parseX509SVIDResponse(forged); err == nil || !strings.Contains(err.Error(), "has SPIFFE ID spiffe://example.org/web") {
+		t.Errorf("Expected an SVID with a mismatched ID to be rejected, got %v", err)
+	}
+	if _, err := parseX509SVIDResponse(nil); err == nil {
+		t.Error("Expected a response without SVIDs to be rejected")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// --- systemd Socket Activation ---
+//
+// Run as a socket-activated unit, the server is started by systemd with the listening socket already
+// open (LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES, see sd_listen_fds(3)) and serves on it instead of
+// binding its addresses. The sockets' addresses and permissions come from the .socket unit, so
+// SocketMode and SocketGroup don't apply. Every passed socket is served, like several addresses (see
+// listeners.go). Without those variables the server binds its addresses as usual.
+
+// listenFDsStart is the first file descriptor systemd passes sockets in.
+const listenFDsStart = 3
+
+// systemdListeners returns the sockets passed by systemd socket activation, or nil if the process wasn't
+// socket activated. The variables are cleared so that a second server in the process binds normally.
+func systemdListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	names, err := listenFDNames(os.Getenv, os.Getpid())
+	if err != nil || names == nil {
+		return nil, err
+	}
+	var listeners []net.Listener
+	for i, name := range names {
+		listener, err := fileListener(os.NewFile(uintptr(listenFDsStart+i), name))
+		if err != nil {
+			closeListeners(listeners)
+			for j := i + 1; j < len(names); j++ {
+				os.NewFile(uintptr(listenFDsStart+j), names[j]).Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenFDNames returns the names of the sockets the LISTEN_* variables in getenv pass to process pid,
+// or nil if they aren't meant for it. Unnamed sockets are named after their descriptor.
+func listenFDNames(getenv func(string) string, pid int) ([]string, error) {
+	if listenPID, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || listenPID != pid {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("socket activated without sockets (LISTEN_FDS=%q)", getenv("LISTEN_FDS"))
+	}
+	given := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	names := make([]string, n)
+	for i := range names {
+		if i < len(given) && given[i] != "" {
+			names[i] = given[i]
+		} else {
+			names[i] = "fd " + strconv.Itoa(listenFDsStart+i)
+		}
+	}
+	return names, nil
+}
+
+// fileListener returns a listener on the passed socket f, which it closes.
+func fileListener(f *os.File) (net.Listener, error) {
+	defer f.Close() // FileListener works on a duplicate
+	listener, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("socket %s passed by systemd is not a listening socket: %w", f.Name(), err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestListenFDNames(t *testing.T) {
+	env := map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "https"}
+	getenv := func(k string) string { return env[k] }
+	if names, err := listenFDNames(getenv, 7); names != nil || err != nil {
+		t.Errorf("Expected variables for another process to be ignored, got %v, %v", names, err)
+	}
+	names, err := listenFDNames(getenv, 42)
+	if err != nil || len(names) != 2 || names[0] != "https" || names[1] != "fd 4" {
+		t.Errorf("Expected the named socket and fd 4, got %v, %v", names, err)
+	}
+	env["LISTEN_FDS"] = "0"
+	if _, err := listenFDNames(getenv, 42); err == nil {
+		t.Error("Expected activation without sockets to fail")
+	}
+}
+
+func TestFileListener(t *testing.T) {
+	passed, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer passed.Close()
+	f, err := passed.(*net.TCPListener).File() // Stands in for the descriptor systemd passes
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := fileListener(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if listener.Addr().String() != passed.Addr().String() {
+		t.Errorf("Expected a listener on the passed socket %s, got %s", passed.Addr(), listener.Addr())
+	}
+}
+
+func TestServerBindsWhenNotActivated(t *testing.T) {
+	pki := newTestPKI(t)
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1)) // Meant for a child that was never started
+	t.Setenv("LISTEN_FDS", "1")
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the server to bind its own address, got %d (%v)", status, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("Expected the activation variables to be cleared")
+	}
+}
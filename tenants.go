@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Tenants ---
+//
+// Tenants are isolated trust domains hosted by one server. Each has its own host names, server
+// certificate and known clients file: GetConfigForClient hands a client asking for one of its hosts in
+// SNI the tenant's tls.Config, which presents the tenant's certificate and only authenticates the
+// tenant's known clients. A client known to one tenant is rejected by the others and by the default
+// configuration, resumed sessions included, and the per-request checks (allowed paths, expiry, rate
+// limits) look the client up in its tenant's file too. Everything else, such as the verify mode, the
+// client CAs, the denied clients and the certificate policy, is shared. Tenants take precedence over
+// SNICertificates for their hosts. SIGHUP reloads their certificates and known clients; the admin API,
+// file watching and trust on first use only cover the default known clients, and only the default known
+// clients can be granted the server-wide /admin/ and /debug/pprof/ endpoints (see requireClientID).
+
+// Tenant is a trust domain served to clients asking for one of Hosts.
+type Tenant struct {
+	// Hosts lists the server names, exact or wildcards like *.example.com. The first names the tenant.
+	Hosts []string
+	// CertFile and KeyFile hold the tenant's key pair. KeyFile is ignored for a PKCS#12 CertFile.
+	CertFile string
+	KeyFile  string
+	// KnownClientsFile lists the clients the tenant authenticates.
+	KnownClientsFile string
+}
+
+// parseTenant parses a --tenant value: HOST[,HOST...]=CERT:KEY:KNOWN_CLIENTS, or
+// HOST[,HOST...]=BUNDLE:KNOWN_CLIENTS for a PKCS#12 bundle.
+func parseTenant(value string) (Tenant, error) {
+	var t Tenant
+	hosts, files, ok := strings.Cut(value, "=")
+	if !ok {
+		return t, fmt.Errorf("want HOST[,HOST...]=CERT:KEY:KNOWN_CLIENTS, got %q", value)
+	}
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			t.Hosts = append(t.Hosts, strings.ToLower(host))
+		}
+	}
+	if len(t.Hosts) == 0 {
+		return t, fmt.Errorf("no host names before '=' in %q", value)
+	}
+	parts := strings.Split(files, ":")
+	switch {
+	case len(parts) == 2 && mtls.IsPKCS12File(parts[0]):
+		t.CertFile, t.KeyFile, t.KnownClientsFile = parts[0], parts[0], parts[1]
+	case len(parts) == 3:
+		t.CertFile, t.KeyFile, t.KnownClientsFile = parts[0], parts[1], parts[2]
+	default:
+		return t, fmt.Errorf("want HOST[,HOST...]=CERT:KEY:KNOWN_CLIENTS or HOST[,HOST...]=BUNDLE.p12:KNOWN_CLIENTS, got %q", value)
+	}
+	for _, file := range parts {
+		if file == "" {
+			return t, fmt.Errorf("empty file name in %q", value)
+		}
+	}
+	return t, nil
+}
+
+// tenant is a loaded Tenant.
+type tenant struct {
+	name         string
+	spec         Tenant
+	cert         *serverCertificate
+	knownClients mtls.KnownClientsStore
+	config       *tls.Config
+}
+
+// tenantSet selects the tenant by SNI.
+type tenantSet struct {
+	tenants []*tenant
+	hosts   hostIndex[*tenant]
+}
+
+// lookup returns the tenant for a server name, or nil if none matches.
+func (ts *tenantSet) lookup(serverName string) *tenant {
+	t, _ := ts.hosts.lookup(serverName)
+	return t
+}
+
+// loadTenants loads the Tenants and makes base hand their handshakes the tenant's configuration, which
+// verifies clients with opts against the tenant's known clients and otherwise mirrors base.
+func (s *Server) loadTenants(opts verifyOptions, base *tls.Config) error {
+	if s.VerifyMode == verifyModeCA {
+		return fmt.Errorf("tenants authenticate their own known clients, which needs verify mode %s or %s", verifyModeFingerprint, verifyModeBoth)
+	}
+	if s.SPIFFESocket != "" {
+		return fmt.Errorf("tenants present their own certificates, they can't be used with --spiffe-socket")
+	}
+	opts.TOFU = nil // Only the default known clients learn new clients
+
+	set := &tenantSet{hosts: newHostIndex[*tenant]()}
+	for _, spec := range s.Tenants {
+		t := &tenant{name: spec.Hosts[0], spec: spec}
+		var err error
+		if t.cert, err = loadServerCertificate(spec.CertFile, spec.KeyFile); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.name, err)
+		}
+		if err := checkCertExpiry("server certificate "+spec.CertFile, t.cert.leaf(), s.ExpiryWarnDays, s.StrictExpiry, time.Now()); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.name, err)
+		}
+		store, err := mtls.NewFileStore(spec.KnownClientsFile, s.fileStoreOptions())
+		if err != nil {
+			return fmt.Errorf("tenant %s: error loading known clients from %s: %w", t.name, spec.KnownClientsFile, err)
+		}
+		t.knownClients = newNotifyingStore(store, s.clientChanges.Publish)
+
+		name := t.name
+		if t.config, err = createServerTLSConfig(t.knownClients, opts, func(d authDecision) {
+			d.Tenant = name
+			s.recordDecision(d)
+		}); err != nil {
+			return fmt.Errorf("tenant %s: failed to create TLS config: %w", t.name, err)
+		}
+		t.config.GetCertificate = t.cert.getCertificate
+		s.TLSVersions.apply(t.config)
+		t.config.NextProtos = base.NextProtos
+		t.config.SessionTicketsDisabled = base.SessionTicketsDisabled
+		t.config.KeyLogWriter = base.KeyLogWriter
+
+		for _, host := range spec.Hosts {
+			if err := set.hosts.add(host, t); err != nil {
+				return fmt.Errorf("tenant %s: %w", t.name, err)
+			}
+		}
+		set.tenants = append(set.tenants, t)
+		logInfof("Hosting tenant %s for SNI %s: certificate %s (fingerprint %s), %d known clients from %s", t.name,
+			strings.Join(spec.Hosts, ", "), spec.CertFile, mtls.CertFingerprint(t.cert.leaf()), len(store.List()), spec.KnownClientsFile)
+	}
+	s.tenants = set
+
+	next := base.GetConfigForClient
+	base.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if t := set.lookup(hello.ServerName); t != nil {
+			return t.config.GetConfigForClient(hello) // Per connection, see mtls.ServerConfig
+		}
+		if next == nil {
+			return nil, nil
+		}
+		return next(hello)
+	}
+	return nil
+}
+
+// knownClientsFor returns the known clients of the tenant hosting serverName, or the default ones
+// (nil in ca mode).
+func (s *Server) knownClientsFor(serverName string) mtls.KnownClientsStore {
+	if s.tenants != nil {
+		if t := s.tenants.lookup(serverName); t != nil {
+			return t.knownClients
+		}
+	}
+	return s.knownClients
+}
+
+// reloadTenantKnownClients reloads the known clients of every tenant, returning the first error.
+// Tenants that fail keep their current entries.
+func (s *Server) reloadTenantKnownClients() error {
+	if s.tenants == nil {
+		return nil
+	}
+	var firstErr error
+	for _, t := range s.tenants.tenants {
+		if err := t.knownClients.Reload(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("tenant %s: %w", t.name, err)
+			}
+			continue
+		}
+		logInfof("Reloaded %d known clients of tenant %s from %s", len(t.knownClients.List()), t.name, t.spec.KnownClientsFile)
+	}
+	return firstErr
+}
+
+// reloadTenantCertificates reloads the key pair of every tenant, returning the first error. Tenants
+// that fail keep their current pair.
+func (s *Server) reloadTenantCertificates() error {
+	if s.tenants == nil {
+		return nil
+	}
+	var firstErr error
+	for _, t := range s.tenants.tenants {
+		if err := t.cert.reload(); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("tenant %s: %w", t.name, err)
+			}
+			continue
+		}
+		logInfof("Reloaded server certificate %s of tenant %s", t.spec.CertFile, t.name)
+	}
+	return firstErr
+}
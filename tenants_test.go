@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestParseTenant(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Tenant
+		wantErr bool
+	}{
+		{value: "ACME.example.com, *.acme.example.com=a.crt:a.key:acme.txt", want: Tenant{Hosts: []string{"acme.example.com", "*.acme.example.com"}, CertFile: "a.crt", KeyFile: "a.key", KnownClientsFile: "acme.txt"}},
+		{value: "acme.example.com=bundle.p12:acme.txt", want: Tenant{Hosts: []string{"acme.example.com"}, CertFile: "bundle.p12", KeyFile: "bundle.p12", KnownClientsFile: "acme.txt"}},
+		{value: "a.crt:a.key:acme.txt", wantErr: true},
+		{value: "acme.example.com=a.crt:a.key", wantErr: true},
+		{value: "acme.example.com=a.crt::acme.txt", wantErr: true},
+		{value: ",=a.crt:a.key:acme.txt", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTenant(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTenant(%q): expected an error, got %+v", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTenant(%q): %v", tt.value, err)
+			continue
+		}
+		if strings.Join(got.Hosts, ",") != strings.Join(tt.want.Hosts, ",") || got.CertFile != tt.want.CertFile ||
+			got.KeyFile != tt.want.KeyFile || got.KnownClientsFile != tt.want.KnownClientsFile {
+			t.Errorf("parseTenant(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestTenantsIsolateKnownClients(t *testing.T) {
+	pki := newTestPKI(t)
+	acmeCert := writeSNICert(t, pki.Dir, "acme", "acme.example.test")
+	acmeClientCert, acmeClientKey := filepath.Join(pki.Dir, "acme_client.crt"), filepath.Join(pki.Dir, "acme_client.key")
+	fingerprint := pki.newClientCert(t, "acme_client", acmeClientCert, acmeClientKey)
+	acmeKnownClients := filepath.Join(pki.Dir, "acme_known_clients.txt")
+	if err := mtls.AppendKnownClient(acmeKnownClients, "acme_client", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	server, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.Tenants = []Tenant{{Hosts: []string{"acme.example.test"}, CertFile: acmeCert.CertFile, KeyFile: acmeCert.KeyFile, KnownClientsFile: acmeKnownClients}}
+	})
+
+	newClient := func(serverCert, certFile, keyFile, serverName string) *Client {
+		t.Helper()
+		client, err := NewClient(baseURL+"/hello", serverCert, certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serverName != "" {
+			client.SetServerName(serverName)
+		}
+		return client
+	}
+
+	acme := newClient(acmeCert.CertFile, acmeClientCert, acmeClientKey, "acme.example.test")
+	if _, status, err := acme.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the tenant's client to be authenticated by the tenant, got %d (%v)", status, err)
+	}
+	if _, _, err := newClient(pki.ServerCertFile, acmeClientCert, acmeClientKey, "").SendRequest(); err == nil {
+		t.Error("Expected the tenant's client to be unknown to the default configuration")
+	}
+	if _, _, err := newClient(acmeCert.CertFile, pki.ClientCertFile, pki.ClientKeyFile, "acme.example.test").SendRequest(); err == nil {
+		t.Error("Expected a default known client to be unknown to the tenant")
+	}
+
+	if err := mtls.RemoveKnownClient(acmeKnownClients, "acme_client", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	if _, status, err := acme.SendRequest(); err == nil && status != http.StatusForbidden {
+		t.Errorf("Expected the client removed from the tenant to be refused, got %d", status)
+	}
+	if err := server.ReloadServerCertificate(); err != nil {
+		t.Errorf("Expected the tenant certificate to reload, got %v", err)
+	}
+}
+
+func TestTenantClientsDeniedAdminAndPprof(t *testing.T) {
+	pki := newTestPKI(t)
+	acmeCert := writeSNICert(t, pki.Dir, "acme", "acme.example.test")
+	opsCert, opsKey := filepath.Join(pki.Dir, "acme_ops.crt"), filepath.Join(pki.Dir, "acme_ops.key")
+	fingerprint := pki.newClientCert(t, "ops", opsCert, opsKey)
+	acmeKnownClients := filepath.Join(pki.Dir, "acme_known_clients.txt")
+	if err := mtls.AppendKnownClient(acmeKnownClients, "ops", fingerprint); err != nil {
+		t.Fatal(err)
+	}
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.Tenants = []Tenant{{Hosts: []string{"acme.example.test"}, CertFile: acmeCert.CertFile, KeyFile: acmeCert.KeyFile, KnownClientsFile: acmeKnownClients}}
+		s.AdminCNs = []string{"ops", pki.ClientCN}
+		s.PprofCNs = []string{"ops"}
+	})
+
+	// The tenant lists a client named like an admin, which must not reach the server-wide endpoints.
+	tenantClient, err := NewClient(baseURL, acmeCert.CertFile, opsCert, opsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantClient.SetServerName("acme.example.test")
+	defaultClient := newTestClient(t, pki, baseURL)
+	for _, tt := range []struct {
+		client *Client
+		path   string
+		want   int
+	}{
+		{tenantClient, "/admin/config", http.StatusForbidden},
+		{tenantClient, "/debug/pprof/", http.StatusForbidden},
+		{defaultClient, "/admin/config", http.StatusOK},
+	} {
+		resp, err := tt.client.httpClient.Get(baseURL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %s: expected %d, got %d", tt.path, tt.want, resp.StatusCode)
+		}
+	}
+}
+
+func TestTenantsRequireKnownClients(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.VerifyMode = verifyModeCA
+	server.ClientCAFile = pki.ServerCertFile
+	server.Tenants = []Tenant{{Hosts: []string{"acme.example.test"}, CertFile: pki.ServerCertFile, KeyFile: pki.ServerKeyFile, KnownClientsFile: pki.KnownClientsFile}}
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "tenants") {
+		server.Stop()
+		t.Errorf("Expected tenants to be refused in ca mode, got %v", err)
+	}
+}
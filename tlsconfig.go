@@ -3,75 +3,89 @@ package main
 import (
 	"crypto/tls"
 	"crypto/x509"
-	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
-)
+	"log/slog"
+	"strings"
 
-// loadCertPool loads certificates from a PEM file into a cert pool.
-// This is still useful for loading the server's cert into the client's trust pool.
-func loadCertPool(certFile string) (*x509.CertPool, error) {
-	certPEM, err := ioutil.ReadFile(certFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read certificate PEM %s: %w", certFile, err)
-	}
-	certPool := x509.NewCertPool()
-	if !certPool.AppendCertsFromPEM(certPEM) {
-		return nil, fmt.Errorf("failed to append certificate from %s to pool", certFile)
-	}
-	return certPool, nil
-}
-
-// createServerTLSConfig creates a tls.Config for the server.
-// It requires client certificates but performs verification *only* via VerifyPeerCertificate.
-func createServerTLSConfig(knownClientsFile string) (*tls.Config, error) {
-	knownClients, err := loadKnownClients(knownClientsFile)
-	if err != nil {
-		return nil, fmt.Errorf("error loading known clients from %s: %w", knownClientsFile, err)
-	}
-	log.Printf("Loaded %d known clients for verification.", len(knownClients))
+	"tls-playground/pkg/mtls"
+)
 
-	// No CA pool for client verification needed here, rely on VerifyPeerCertificate
-	cfg := &tls.Config{
-		ClientAuth: tls.RequireAnyClientCert, // Require a cert, but don't verify against CAs
-		// ClientCAs: nil, // No CA pool specified
-		MinVersion: tls.VersionTLS12,
-		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			// NOTE: verifiedChains will be nil because we didn't provide ClientCAs.
-			// We rely *entirely* on our custom verification logic based on the raw cert.
-			if len(rawCerts) == 0 {
-				return errors.New("no client certificate presented") // Should be caught by RequireAnyClientCert
+// createServerTLSConfig creates a tls.Config for the server, see mtls.ServerConfig.
+// Each client certificate goes through checkClientCertificate; with opts.ClientCAs the TLS stack first verifies
+// the chain (logged when the client is accepted), and a chain that doesn't verify fails the handshake before that,
+// so onDecision is not called for it.
+// onDecision, if not nil, is called with the outcome of every client certificate verification.
+func createServerTLSConfig(knownClients mtls.KnownClientsStore, opts verifyOptions, onDecision func(authDecision)) (*tls.Config, error) {
+	return mtls.ServerConfig{
+		Verifier: mtls.VerifierFunc(func(cert *x509.Certificate) error {
+			return checkClientCertificate(cert, knownClients, opts)
+		}),
+		ClientCAs: opts.ClientCAs,
+		OnVerify: func(v mtls.Verification) {
+			d := newAuthDecision(v.RawCerts, v.RemoteAddr, v.Err, opts.ClientIDSource)
+			if v.Err != nil {
+				attrs := append(decisionAttrs(d), slog.Bool("resumed", v.Resumed))
+				logAuth(levelError, "Client rejected", append(attrs, explainRejection(d, v.Err).attrs()...)...)
+			} else {
+				attrs := append(decisionAttrs(d), slog.String("via", verifiedVia(knownClients, opts)), slog.Bool("resumed", v.Resumed))
+				if len(v.Chain) > 0 {
+					attrs = append(attrs, slog.String("chain", chainSummary(v.Chain)))
+				}
+				logAuth(levelInfo, "Client authenticated", attrs...)
+			}
+			if onDecision != nil {
+				onDecision(d)
 			}
-			// Perform verification based on fingerprint and CN in knownClients map
-			return verifyClientCertificate(rawCerts, nil, knownClients) // Pass nil for verifiedChains
 		},
-	}
-
-	return cfg, nil
+	}.TLSConfig()
 }
 
-// createClientTLSConfig creates a tls.Config for the client.
-// It uses the client's cert/key and explicitly trusts the server's certificate.
-func createClientTLSConfig(serverCertFile, clientCertFile, clientKeyFile string) (*tls.Config, error) {
-	// Load client cert/key for client's identity
-	cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load client key pair (%s, %s): %w", clientCertFile, clientKeyFile, err)
+// parseSignatureAlgorithms maps names such as "SHA256-RSA", "ECDSA-SHA256" or "Ed25519"
+// (as printed by x509.SignatureAlgorithm.String) to their x509 values.
+func parseSignatureAlgorithms(names []string) ([]x509.SignatureAlgorithm, error) {
+	var algs []x509.SignatureAlgorithm
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for alg := x509.MD2WithRSA; alg <= x509.PureEd25519; alg++ {
+			if strings.EqualFold(alg.String(), name) {
+				algs = append(algs, alg)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown signature algorithm %q", name)
+		}
 	}
+	return algs, nil
+}
 
-	// Load server's cert into the RootCAs pool for explicit trust
-	rootCAPool, err := loadCertPool(serverCertFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load server certificate %s for client trust: %w", serverCertFile, err)
+// containsSignatureAlgorithm reports whether alg is in the list.
+func containsSignatureAlgorithm(algs []x509.SignatureAlgorithm, alg x509.SignatureAlgorithm) bool {
+	for _, a := range algs {
+		if a == alg {
+			return true
+		}
 	}
+	return false
+}
 
-	cfg := &tls.Config{
-		Certificates: []tls.Certificate{cert}, // Client's identity
-		RootCAs:      rootCAPool,              // Explicitly trust only certs in this pool (server.crt)
-		MinVersion:   tls.VersionTLS12,
-		// ServerName check still happens against the CN/SAN in the trusted server.crt
+// tlsVersionName returns a human-readable name for a TLS protocol version.
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
 	}
-
-	return cfg, nil
 }
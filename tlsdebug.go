@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- TLS Handshake Debugging ---
+//
+// With TLSDebug the server logs what each client offers in its ClientHello (GetConfigForClient) and
+// what was negotiated once the handshake completes (VerifyConnection). Handshakes that fail client
+// certificate verification only get the ClientHello line, plus the "Client rejected" line if verification failed.
+// TLSKeyLogFile is separate: it writes the session secrets in NSS key log format so captured traffic
+// can be decrypted in Wireshark.
+
+// debugCert is the part of a certificate logged for each handshake.
+type debugCert struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	KeyType     string    `json:"key_type"`
+	Fingerprint string    `json:"fingerprint"`
+}
+
+func newDebugCert(cert *x509.Certificate) debugCert {
+	return debugCert{
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.String(),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+		KeyType:     cert.PublicKeyAlgorithm.String(),
+		Fingerprint: mtls.CertFingerprint(cert),
+	}
+}
+
+// debugHandshakes logs each ClientHello, and the negotiated parameters and the client's certificate
+// chain once VerifyConnection runs.
+func debugHandshakes(cfg *tls.Config) {
+	mtls.WrapVerifyConnection(cfg, func(hello *tls.ClientHelloInfo) func(tls.ConnectionState) error {
+		remoteAddr := "unknown"
+		if hello.Conn != nil {
+			remoteAddr = hello.Conn.RemoteAddr().String()
+		}
+		versions := make([]string, len(hello.SupportedVersions))
+		for i, v := range hello.SupportedVersions {
+			versions[i] = tls.VersionName(v)
+		}
+		suites := make([]string, len(hello.CipherSuites))
+		for i, id := range hello.CipherSuites {
+			suites[i] = tls.CipherSuiteName(id)
+		}
+		logAttrs(levelInfo, "TLS ClientHello",
+			slog.String("remote_addr", remoteAddr),
+			slog.String("sni", hello.ServerName),
+			slog.Any("versions", versions),
+			slog.Any("cipher_suites", suites),
+			slog.Any("alpn", hello.SupportedProtos))
+
+		return func(cs tls.ConnectionState) error {
+			chain := make([]debugCert, len(cs.PeerCertificates))
+			for i, cert := range cs.PeerCertificates {
+				chain[i] = newDebugCert(cert)
+			}
+			logAttrs(levelInfo, "TLS handshake negotiated",
+				slog.String("remote_addr", remoteAddr),
+				slog.String("version", tls.VersionName(cs.Version)),
+				slog.String("cipher_suite", tls.CipherSuiteName(cs.CipherSuite)),
+				slog.String("alpn", cs.NegotiatedProtocol),
+				slog.String("sni", cs.ServerName),
+				slog.Bool("resumed", cs.DidResume),
+				slog.Any("client_chain", chain))
+			return nil
+		}
+	})
+}
+
+// openKeyLog opens the TLS key log file for appending. The secrets decrypt any captured session, so
+// the file is only readable by its owner.
+func openKeyLog(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TLS key log %s: %w", path, err)
+	}
+	logWarnf("Writing TLS session secrets to %s; anyone with this file can decrypt captured traffic", path)
+	return file, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTLSDebugLogsHandshakes(t *testing.T) {
+	pki := newTestPKI(t)
+	keyLogFile := filepath.Join(pki.Dir, "keys.log")
+
+	_, logs := captureOutput(t, func() {
+		_, baseURL := startTestServer(t, pki, func(s *Server) {
+			s.TLSDebug = true
+			s.TLSKeyLogFile = keyLogFile
+		})
+		client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := client.SendRequest(); err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	})
+
+	for _, want := range []string{
+		`msg="TLS ClientHello"`,
+		"sni=",
+		`msg="TLS handshake negotiated"`,
+		`version="TLS 1.3"`,
+		`cipher_suite=TLS_`,
+		"CN=" + pki.ClientCN,
+	} {
+		if !strings.Contains(logs, want) {
+			t.Errorf("Expected %q in logs:\n%s", want, logs)
+		}
+	}
+
+	keyLog, err := ioutil.ReadFile(keyLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(keyLog), "CLIENT_HANDSHAKE_TRAFFIC_SECRET ") {
+		t.Errorf("Expected TLS 1.3 secrets in the key log, got:\n%s", keyLog)
+	}
+}
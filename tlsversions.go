@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// --- TLS Versions & Cipher Suites ---
+
+// TLSVersions restricts the TLS versions and cipher suites of a server or client. Zero values keep the
+// defaults: TLS 1.2 as the minimum, the highest version Go supports as the maximum, and Go's cipher suites.
+// Go doesn't allow configuring TLS 1.3 cipher suites, so CipherSuites only affects TLS 1.2 and earlier.
+type TLSVersions struct {
+	Min          uint16
+	Max          uint16
+	CipherSuites []uint16
+}
+
+// apply sets the non-zero restrictions on cfg.
+func (v TLSVersions) apply(cfg *tls.Config) {
+	if v.Min != 0 {
+		cfg.MinVersion = v.Min
+	}
+	if v.Max != 0 {
+		cfg.MaxVersion = v.Max
+	}
+	if len(v.CipherSuites) > 0 {
+		cfg.CipherSuites = v.CipherSuites
+	}
+}
+
+// allowsHTTP2 reports whether HTTP/2 can be served with these restrictions: net/http refuses to configure it
+// when TLS 1.2 cipher suites are set without one of the AES_128_GCM_SHA256 suites HTTP/2 requires.
+func (v TLSVersions) allowsHTTP2() bool {
+	if len(v.CipherSuites) == 0 || v.Min >= tls.VersionTLS13 {
+		return true
+	}
+	for _, id := range v.CipherSuites {
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return true
+		}
+	}
+	return false
+}
+
+// tlsVersionsByName maps --min-tls and --max-tls values to versions.
+var tlsVersionsByName = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses "1.2", "TLS1.2" or "TLS 1.2". An empty string is the zero value (the default).
+func parseTLSVersion(name string) (uint16, error) {
+	if name == "" {
+		return 0, nil
+	}
+	trimmed := strings.TrimSpace(strings.TrimPrefix(strings.ToUpper(name), "TLS"))
+	if v, ok := tlsVersionsByName[trimmed]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q (want 1.0, 1.1, 1.2 or 1.3)", name)
+}
+
+// allCipherSuites returns Go's secure and insecure cipher suites.
+func allCipherSuites() []*tls.CipherSuite {
+	return append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+}
+
+// parseCipherSuite looks up a cipher suite by its IANA name (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)
+// or its ID in hex (e.g. 0xc02f).
+func parseCipherSuite(name string) (*tls.CipherSuite, error) {
+	name = strings.TrimSpace(name)
+	var id uint64
+	isID := false
+	if strings.HasPrefix(strings.ToLower(name), "0x") {
+		var err error
+		if id, err = strconv.ParseUint(name[2:], 16, 16); err != nil {
+			return nil, fmt.Errorf("invalid cipher suite ID %q: %w", name, err)
+		}
+		isID = true
+	}
+	for _, suite := range allCipherSuites() {
+		if (isID && uint64(suite.ID) == id) || strings.EqualFold(suite.Name, name) {
+			return suite, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown cipher suite %q (see list-ciphers)", name)
+}
+
+// parseTLSVersions parses and validates the --min-tls, --max-tls and --ciphers flags.
+func parseTLSVersions(minName, maxName string, cipherNames []string) (TLSVersions, error) {
+	var v TLSVersions
+	var err error
+	if v.Min, err = parseTLSVersion(minName); err != nil {
+		return v, fmt.Errorf("invalid --min-tls: %w", err)
+	}
+	if v.Max, err = parseTLSVersion(maxName); err != nil {
+		return v, fmt.Errorf("invalid --max-tls: %w", err)
+	}
+	if v.Min != 0 && v.Max != 0 && v.Min > v.Max {
+		return v, fmt.Errorf("--min-tls %s is above --max-tls %s", tls.VersionName(v.Min), tls.VersionName(v.Max))
+	}
+	if len(cipherNames) == 0 {
+		return v, nil
+	}
+
+	// The cipher suites apply to the versions below TLS 1.3 that are enabled.
+	low, high := v.Min, v.Max
+	if low == 0 {
+		low = tls.VersionTLS12
+	}
+	if high == 0 || high > tls.VersionTLS12 {
+		high = tls.VersionTLS12
+	}
+	if low > high {
+		return v, fmt.Errorf("--ciphers has no effect with --min-tls %s: Go doesn't allow configuring TLS 1.3 cipher suites", tls.VersionName(low))
+	}
+	for _, name := range cipherNames {
+		suite, err := parseCipherSuite(name)
+		if err != nil {
+			return v, err
+		}
+		usable := false
+		for _, version := range suite.SupportedVersions {
+			if version >= low && version <= high {
+				usable = true
+			}
+		}
+		if !usable {
+			return v, fmt.Errorf("cipher suite %s is not supported by the enabled TLS versions below 1.3 (it supports %s)", suite.Name, versionNames(suite.SupportedVersions))
+		}
+		if suite.Insecure {
+			logWarnf("Cipher suite %s is insecure; only use it to experiment", suite.Name)
+		}
+		v.CipherSuites = append(v.CipherSuites, suite.ID)
+	}
+	return v, nil
+}
+
+// versionNames formats TLS versions as "TLS 1.2, TLS 1.3".
+func versionNames(versions []uint16) string {
+	names := make([]string, len(versions))
+	for i, version := range versions {
+		names[i] = tls.VersionName(version)
+	}
+	return strings.Join(names, ", ")
+}
+
+// ListCiphersCmd prints the cipher suites accepted by --ciphers.
+type ListCiphersCmd struct {
+	Insecure bool `kong:"name='insecure',help='Include cipher suites with known security issues.'"`
+}
+
+// Run prints one cipher suite per line with its ID and supported TLS versions.
+func (l *ListCiphersCmd) Run() error {
+	suites := tls.CipherSuites()
+	if l.Insecure {
+		suites = allCipherSuites()
+	}
+	for _, suite := range suites {
+		note := ""
+		if suite.Insecure {
+			note = " (insecure)"
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			note += " (TLS 1.3, not configurable)"
+		}
+		outputf("0x%04x  %-50s %s%s\n", suite.ID, suite.Name, versionNames(suite.SupportedVersions), note)
+	}
+	return nil
+}
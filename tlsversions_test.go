@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/tls"
+	"strings"
+	"testing"
+)
+
+func TestParseTLSVersions(t *testing.T) {
+	for _, tt := range []struct {
+		min, max string
+		ciphers  []string
+		want     TLSVersions
+		err      string
+	}{
+		{want: TLSVersions{}},
+		{min: "1.2", max: "TLS1.3", want: TLSVersions{Min: tls.VersionTLS12, Max: tls.VersionTLS13}},
+		{min: "1.2", ciphers: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "0xc030"},
+			want: TLSVersions{Min: tls.VersionTLS12, CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}}},
+		{min: "1.4", err: "unknown TLS version"},
+		{min: "1.3", max: "1.2", err: "is above --max-tls"},
+		{min: "1.3", ciphers: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, err: "has no effect"},
+		{ciphers: []string{"TLS_AES_128_GCM_SHA256"}, err: "not supported by the enabled TLS versions"},
+		{min: "1.0", max: "1.1", ciphers: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, err: "not supported by the enabled TLS versions"},
+		{ciphers: []string{"TLS_NOPE"}, err: "unknown cipher suite"},
+	} {
+		got, err := parseTLSVersions(tt.min, tt.max, tt.ciphers)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseTLSVersions(%q, %q, %v): expected error containing %q, got %v", tt.min, tt.max, tt.ciphers, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTLSVersions(%q, %q, %v): %v", tt.min, tt.max, tt.ciphers, err)
+			continue
+		}
+		if got.Min != tt.want.Min || got.Max != tt.want.Max || len(got.CipherSuites) != len(tt.want.CipherSuites) {
+			t.Errorf("parseTLSVersions(%q, %q, %v) = %+v, want %+v", tt.min, tt.max, tt.ciphers, got, tt.want)
+			continue
+		}
+		for i := range got.CipherSuites {
+			if got.CipherSuites[i] != tt.want.CipherSuites[i] {
+				t.Errorf("parseTLSVersions(%q, %q, %v) = %+v, want %+v", tt.min, tt.max, tt.ciphers, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestTLSVersionsNegotiation(t *testing.T) {
+	pki := newTestPKI(t)
+	suite := uint16(tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.TLSVersions = TLSVersions{Max: tls.VersionTLS12, CipherSuites: []uint16{suite}}
+	})
+
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.httpClient.Get(baseURL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.TLS.Version != tls.VersionTLS12 || resp.TLS.CipherSuite != suite {
+		t.Errorf("Expected TLS 1.2 with %s, got %s with %s",
+			tls.CipherSuiteName(suite), tls.VersionName(resp.TLS.Version), tls.CipherSuiteName(resp.TLS.CipherSuite))
+	}
+
+	// A client that requires TLS 1.3 can't talk to a server capped at TLS 1.2.
+	strict, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strict.SetTLSVersions(TLSVersions{Min: tls.VersionTLS13})
+	if _, _, err := strict.SendRequest(); err == nil || !strings.Contains(err.Error(), "protocol version") {
+		t.Errorf("Expected a protocol version error, got %v", err)
+	}
+}
+
+func TestListCiphers(t *testing.T) {
+	stdout, _ := captureOutput(t, func() {
+		if err := (&ListCiphersCmd{}).Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(stdout, "0xc02f  TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256") || strings.Contains(stdout, "(insecure)") {
+		t.Errorf("Unexpected list-ciphers output:\n%s", stdout)
+	}
+	stdout, _ = captureOutput(t, func() {
+		if err := (&ListCiphersCmd{Insecure: true}).Run(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if !strings.Contains(stdout, "TLS_RSA_WITH_RC4_128_SHA") || !strings.Contains(stdout, "(insecure)") {
+		t.Errorf("Expected insecure suites with --insecure:\n%s", stdout)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Trust On First Use ---
+//
+// With TOFU, a client whose CN is not in the known clients store at all is added to it with the
+// fingerprint of the certificate it presents on its first connection, the way SSH records host keys.
+// From then on the entry is checked like any other: a different certificate for the same CN is a
+// fingerprint mismatch. Only an unknown CN is trusted; every other check must still pass.
+//
+// With TOFUApproval the first connection is rejected instead and the client waits in a pending list
+// until it is approved on the admin API (see admin.go):
+//
+//	GET    /pending-clients                         list the clients waiting for approval
+//	POST   /pending-clients/<cn>?fingerprint=...    approve: add the pending fingerprint to the known clients
+//	DELETE /pending-clients/<cn>                    dismiss the request; the next connection makes a new one
+//
+// Approval names the fingerprint listed by GET, so the certificate added is the one the operator
+// checked, not whichever one arrived first under the CN.
+
+const (
+	pendingClientsAdminPath = "/pending-clients"
+	// maxPendingClients bounds the pending list, since anyone who can connect can add to it.
+	maxPendingClients = 100
+)
+
+// PendingClient is a client waiting for its first-use trust to be approved.
+type PendingClient struct {
+	CN          string    `json:"cn"`
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Attempts    int       `json:"attempts"`
+}
+
+// tofuTrust adds first-seen clients to the known clients store, or holds them for approval.
+type tofuTrust struct {
+	store    mtls.KnownClientsStore
+	identity mtls.IdentitySource // What the CN of an entry is taken from
+	approval bool
+	storeMu  *sync.Mutex // Serializes changes to the store with the admin API
+
+	mu      sync.Mutex
+	pending map[string]*PendingClient // CN -> first certificate seen for it
+}
+
+func newTOFUTrust(store mtls.KnownClientsStore, identity mtls.IdentitySource, approval bool, storeMu *sync.Mutex) *tofuTrust {
+	return &tofuTrust{store: store, identity: identity, approval: approval, storeMu: storeMu, pending: make(map[string]*PendingClient)}
+}
+
+// onlyUnknownCN reports whether err is the known clients check failing for a CN without entries,
+// and nothing else: in audit mode another failed check still rejects the client.
+func onlyUnknownCN(err error) bool {
+	var failures certCheckErrors
+	if errors.As(err, &failures) && len(failures) != 1 {
+		return false
+	}
+	return failedCheckName(err) == "known-client" && errors.Is(err, mtls.ErrCNNotAuthorized)
+}
+
+// trust handles the first connection of an unknown CN: it adds the certificate to the store, or
+// records it as pending and rejects it.
+func (t *tofuTrust) trust(cert *x509.Certificate) error {
+	cn, _ := t.identity.ClientID(cert) // Present, or the known clients check would not have found it unknown
+	fingerprint := mtls.CertFingerprint(cert)
+	if t.approval {
+		return t.hold(cn, fingerprint)
+	}
+
+	t.storeMu.Lock()
+	defer t.storeMu.Unlock()
+	if entries, ok := t.store.Lookup(cn); ok {
+		// Trusted by a concurrent handshake (or the admin API) in the meantime
+		if _, match := mtls.MatchKnownClient(entries, cert); match {
+			return nil
+		}
+		return &certCheckError{Check: "known-client", Err: fmt.Errorf("client fingerprint mismatch for CN '%s'", cn)}
+	}
+	if err := mtls.ValidateEntryCN(cn); err != nil {
+		return &certCheckError{Check: "tofu", Err: fmt.Errorf("cannot trust client on first use: %w", err)}
+	}
+	if err := t.store.Add(cn, fingerprint); err != nil {
+		logErrorf("Failed to add client CN '%s' on first use: %v", cn, err)
+		return &certCheckError{Check: "tofu", Err: fmt.Errorf("failed to trust client CN '%s' on first use", cn)}
+	}
+	logAuth(levelWarn, "Trusted new client on first use", slog.String("cn", cn), slog.String("fingerprint", fingerprint))
+	return nil
+}
+
+// hold records a pending request for the CN and rejects the connection. The first certificate seen
+// for a CN is the one that gets approved; others are rejected without replacing it. A CN that can't be
+// written to the known clients file is rejected without being recorded.
+func (t *tofuTrust) hold(cn, fingerprint string) error {
+	if err := mtls.ValidateEntryCN(cn); err != nil {
+		logAuth(levelWarn, "Not recording client with an invalid CN for approval", slog.String("cn", cn), slog.String("fingerprint", fingerprint))
+		return &certCheckError{Check: "tofu", Err: fmt.Errorf("cannot hold client for approval: %w", err)}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	p, ok := t.pending[cn]
+	switch {
+	case !ok && len(t.pending) >= maxPendingClients:
+		logAuth(levelWarn, "Pending clients list is full, not recording new client", slog.String("cn", cn), slog.String("fingerprint", fingerprint))
+		return &certCheckError{Check: "tofu", Err: fmt.Errorf("client CN '%s' not authorized and too many clients await approval", cn)}
+	case !ok:
+		p = &PendingClient{CN: cn, Fingerprint: fingerprint, FirstSeen: now}
+		t.pending[cn] = p
+		logAuth(levelWarn, "New client awaits approval", slog.String("cn", cn), slog.String("fingerprint", fingerprint))
+	case p.Fingerprint != fingerprint:
+		logAuth(levelWarn, "Pending client presented a different certificate", slog.String("cn", cn), slog.String("fingerprint", fingerprint),
+			slog.String("pending_fingerprint", p.Fingerprint))
+		return &certCheckError{Check: "tofu", Err: fmt.Errorf("client CN '%s' awaits approval for another certificate", cn)}
+	}
+	p.LastSeen = now
+	p.Attempts++
+	return &certCheckError{Check: "tofu", Err: fmt.Errorf("client CN '%s' awaits approval (trust on first use)", cn)}
+}
+
+// List returns the pending clients, sorted by CN.
+func (t *tofuTrust) List() []PendingClient {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]PendingClient, 0, len(t.pending))
+	for _, p := range t.pending {
+		list = append(list, *p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CN < list[j].CN })
+	return list
+}
+
+// take removes and returns the pending request of the CN.
+func (t *tofuTrust) take(cn string) (PendingClient, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.pending[cn]
+	if !ok {
+		return PendingClient{}, false
+	}
+	delete(t.pending, cn)
+	return *p, true
+}
+
+// approve adds the pending fingerprint of the CN to the store, if it is the expected one. On failure
+// the request stays pending.
+func (t *tofuTrust) approve(cn, fingerprint string) (PendingClient, error) {
+	if err := mtls.ValidateEntryCN(cn); err != nil {
+		return PendingClient{}, err
+	}
+	t.mu.Lock()
+	pending, ok := t.pending[cn]
+	switch {
+	case !ok:
+		t.mu.Unlock()
+		return PendingClient{}, errNoPendingClient
+	case pending.Fingerprint != fingerprint:
+		t.mu.Unlock()
+		return *pending, errPendingFingerprintMismatch
+	}
+	delete(t.pending, cn)
+	p := *pending
+	t.mu.Unlock()
+
+	t.storeMu.Lock()
+	defer t.storeMu.Unlock()
+	if err := t.store.Add(p.CN, p.Fingerprint); err != nil {
+		t.mu.Lock()
+		t.pending[cn] = &p
+		t.mu.Unlock()
+		return p, err
+	}
+	return p, nil
+}
+
+var (
+	errNoPendingClient            = errors.New("no pending client with this CN")
+	errPendingFingerprintMismatch = errors.New("the pending client presented another fingerprint")
+)
+
+// adminPendingClientsHandler lists the pending clients (GET /pending-clients).
+func (s *Server) adminPendingClientsHandler(w http.ResponseWriter, r *http.Request) {
+	if s.tofu == nil || !s.tofu.approval {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "trust on first use with approval is not enabled"})
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeJSON(w, http.StatusOK, s.tofu.List())
+}
+
+// adminPendingClientHandler approves (POST) or dismisses (DELETE /pending-clients/<cn>) a pending client.
+func (s *Server) adminPendingClientHandler(w http.ResponseWriter, r *http.Request) {
+	if s.tofu == nil || !s.tofu.approval {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": "trust on first use with approval is not enabled"})
+		return
+	}
+	cn := strings.TrimPrefix(r.URL.Path, pendingClientsAdminPath+"/")
+	switch r.Method {
+	case http.MethodPost:
+		raw := r.URL.Query().Get("fingerprint")
+		if raw == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "approval needs the ?fingerprint= listed for the pending client"})
+			return
+		}
+		fingerprint, err := mtls.NormalizeFingerprint(raw)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		p, err := s.tofu.approve(cn, fingerprint)
+		switch {
+		case errors.Is(err, errNoPendingClient):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		case errors.Is(err, errPendingFingerprintMismatch):
+			logAuth(levelWarn, "Admin API approval names another fingerprint than the pending client", slog.String("cn", cn),
+				slog.String("fingerprint", fingerprint), slog.String("pending_fingerprint", p.Fingerprint), slog.String("remote_addr", r.RemoteAddr))
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		case err != nil:
+			s.writeAdminStoreError(w, err)
+			return
+		}
+		logAuth(levelInfo, "Admin API approved a pending client", slog.String("cn", p.CN), slog.String("fingerprint", p.Fingerprint),
+			slog.String("remote_addr", r.RemoteAddr), slog.String("path", r.URL.Path))
+		writeJSON(w, http.StatusCreated, mtls.KnownClient{CN: p.CN, Fingerprint: p.Fingerprint})
+	case http.MethodDelete:
+		p, ok := s.tofu.take(cn)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": errNoPendingClient.Error()})
+			return
+		}
+		logAuth(levelInfo, "Admin API dismissed a pending client", slog.String("cn", p.CN), slog.String("fingerprint", p.Fingerprint),
+			slog.String("remote_addr", r.RemoteAddr), slog.String("path", r.URL.Path))
+		writeJSON(w, http.StatusOK, p)
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
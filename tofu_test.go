@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tls-playground/pkg/mtls"
+)
+
+func TestTOFUTrustsUnknownCNOnce(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) { s.TOFU = true })
+	client, fingerprint := addNewClient(t, pki, baseURL, "tofu_client")
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected a new client to be trusted on first use, got %d (%v)", status, err)
+	}
+	content, err := ioutil.ReadFile(pki.KnownClientsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "tofu_client "+fingerprint) {
+		t.Errorf("Expected the client to be recorded in the known clients file, got:\n%s", content)
+	}
+
+	// Another certificate with the same CN is a mismatch, not a new first use
+	impostorCert, impostorKey := filepath.Join(pki.Dir, "impostor.crt"), filepath.Join(pki.Dir, "impostor.key")
+	pki.newClientCert(t, "tofu_client", impostorCert, impostorKey)
+	impostor, err := NewClient(baseURL+"/hello", pki.ServerCertFile, impostorCert, impostorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := impostor.SendRequest(); err == nil {
+		t.Error("Expected a second certificate for a trusted CN to be rejected")
+	}
+}
+
+func TestTOFUApproval(t *testing.T) {
+	pki := newTestPKI(t)
+	adminAddr := freeAddr(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.TOFUApproval = true
+		s.AdminAddr = adminAddr
+	})
+	pendingURL := "http://" + adminAddr + pendingClientsAdminPath
+	client, fingerprint := addNewClient(t, pki, baseURL, "pending_client")
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Fatal("Expected a new client to be rejected until approved")
+	}
+
+	var pending []PendingClient
+	if status := adminRequest(t, http.MethodGet, pendingURL, "", &pending); status != http.StatusOK {
+		t.Fatalf("Expected 200 listing pending clients, got %d", status)
+	}
+	if len(pending) != 1 || pending[0].CN != "pending_client" || pending[0].Fingerprint != fingerprint {
+		t.Fatalf("Expected pending_client to await approval, got %+v", pending)
+	}
+	if status := adminRequest(t, http.MethodPost, pendingURL+"/unknown?fingerprint="+fingerprint, "", nil); status != http.StatusNotFound {
+		t.Errorf("Expected 404 approving a CN that isn't pending, got %d", status)
+	}
+	if status := adminRequest(t, http.MethodPost, pendingURL+"/pending_client", "", nil); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 approving without a fingerprint, got %d", status)
+	}
+	if status := adminRequest(t, http.MethodPost, pendingURL+"/pending_client?fingerprint=AA:BB", "", nil); status != http.StatusConflict {
+		t.Errorf("Expected 409 approving another fingerprint than the pending one, got %d", status)
+	}
+	resp, err := http.Post(pendingURL+"/pending_client?fingerprint="+fingerprint, "", nil) // A cross-site form could send this
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 approving without Content-Type: application/json, got %d", resp.StatusCode)
+	}
+	if status := adminRequest(t, http.MethodPost, pendingURL+"/pending_client?fingerprint="+strings.ToLower(fingerprint), "", nil); status != http.StatusCreated {
+		t.Fatalf("Expected 201 approving the pending client, got %d", status)
+	}
+	if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the approved client to be accepted, got %d (%v)", status, err)
+	}
+	if status := adminRequest(t, http.MethodGet, pendingURL, "", &pending); status != http.StatusOK || len(pending) != 0 {
+		t.Errorf("Expected no pending clients after approval, got %d %+v", status, pending)
+	}
+}
+
+func TestTOFUApprovalRefusesInjectedLines(t *testing.T) {
+	pki := newTestPKI(t)
+	adminAddr := freeAddr(t)
+	_, baseURL := startTestServer(t, pki, func(s *Server) {
+		s.TOFUApproval = true
+		s.AdminAddr = adminAddr
+	})
+	// A CN that would add its own known clients line if written as is.
+	certFile, keyFile := filepath.Join(pki.Dir, "injected.crt"), filepath.Join(pki.Dir, "injected.key")
+	pki.newClientCert(t, "x\nadmin AA:BB\ny", certFile, keyFile)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.SendRequest(); err == nil {
+		t.Fatal("Expected a client with an invalid CN to be rejected")
+	}
+
+	var pending []PendingClient
+	if status := adminRequest(t, http.MethodGet, "http://"+adminAddr+pendingClientsAdminPath, "", &pending); status != http.StatusOK || len(pending) != 0 {
+		t.Errorf("Expected the invalid CN not to be recorded as pending, got %d %+v", status, pending)
+	}
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "x\nadmin", "AA:BB"); !errors.Is(err, mtls.ErrInvalidEntry) {
+		t.Errorf("Expected appending a CN with a newline to fail with ErrInvalidEntry, got %v", err)
+	}
+	if content, _ := ioutil.ReadFile(pki.KnownClientsFile); strings.Contains(string(content), "admin") {
+		t.Errorf("Expected no injected line in the known clients file, got:\n%s", content)
+	}
+}
+
+func TestTOFURequiresKnownClients(t *testing.T) {
+	pki := newTestPKI(t)
+	server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	server.TOFU = true
+	server.VerifyMode = verifyModeCA
+	server.ClientCAFile = pki.ServerCertFile
+	if err := server.Start(); err == nil || !strings.Contains(err.Error(), "trust on first use") {
+		server.Stop()
+		t.Errorf("Expected TOFU to be refused in ca mode, got %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// --- Certificate-Bound Access Tokens (RFC 8705) ---
+//
+// An mTLS client can exchange its connection for a short-lived bearer token at /token.
+// The token is a JWT carrying the SHA-256 thumbprint of the client certificate in its
+// "cnf" claim, so it is only accepted on a connection that presents the same certificate:
+// a stolen token is useless without the matching private key. `client token` fetches one and shows its
+// claims, and --use presents it on a following request.
+
+const (
+	tokenPath       = "/token"
+	tokenIssuer     = "tls-playground"
+	defaultTokenTTL = 5 * time.Minute
+)
+
+// tokenConfirmation is the RFC 8705 "cnf" claim.
+type tokenConfirmation struct {
+	X5tS256 string `json:"x5t#S256"`
+}
+
+// boundTokenClaims are the claims of a certificate-bound access token.
+type boundTokenClaims struct {
+	jwt.RegisteredClaims
+	Cnf tokenConfirmation `json:"cnf"`
+}
+
+// tokenResponse is returned by the token endpoint (RFC 6749 section 5.1).
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// newTokenKey returns a random HMAC key. Tokens don't survive a server restart.
+func newTokenKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate token signing key: %w", err)
+	}
+	return key, nil
+}
+
+// certThumbprint returns the base64url SHA-256 thumbprint of the certificate, as used in x5t#S256.
+func certThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// issueBoundToken signs a token for the client (its CN, or see ClientIDSource), bound to the certificate.
+func (s *Server) issueBoundToken(cert *x509.Certificate, now time.Time) (string, error) {
+	claims := boundTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   s.clientID(cert),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.TokenTTL)),
+		},
+		Cnf: tokenConfirmation{X5tS256: certThumbprint(cert)},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.tokenKey)
+}
+
+// verifyBoundToken checks the token's signature and expiry, and that it is bound to cert.
+func (s *Server) verifyBoundToken(token string, cert *x509.Certificate) (*boundTokenClaims, error) {
+	var claims boundTokenClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.tokenKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithIssuer(tokenIssuer), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Cnf.X5tS256 != certThumbprint(cert) {
+		return nil, errors.New("token is bound to a different client certificate")
+	}
+	return &claims, nil
+}
+
+// tokenHandler issues a certificate-bound token to the authenticated client.
+func (s *Server) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	token, err := s.issueBoundToken(leaf, time.Now())
+	if err != nil {
+		logErrorf("Failed to issue token: %v", err)
+		http.Error(w, "failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	logAuth(levelInfo, "Issued certificate-bound token", requestAttrs(r)...)
+	writeJSON(w, http.StatusOK, tokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresIn: int(s.TokenTTL / time.Second)})
+}
+
+// requireBoundToken accepts requests authenticated by mTLS alone, or by mTLS plus a bearer token.
+// If an Authorization header is sent, the token must be valid and bound to the presented certificate.
+func (s *Server) requireBoundToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		auth := r.Header.Get("Authorization")
+		if auth == "" {
+			next.ServeHTTP(w, r) // Plain mTLS
+			return
+		}
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if token == auth {
+			rejectToken(w, "unsupported authorization scheme")
+			return
+		}
+		if _, err := s.verifyBoundToken(token, r.TLS.PeerCertificates[0]); err != nil {
+			logAuth(levelError, "Rejected token", append(requestAttrs(r), slog.String("reason", err.Error()))...)
+			rejectToken(w, err.Error())
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rejectToken responds 401 with an RFC 6750 invalid_token challenge.
+func rejectToken(w http.ResponseWriter, reason string) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	http.Error(w, "invalid token: "+reason, http.StatusUnauthorized)
+}
+
+// FetchToken exchanges the client's mTLS connection for a certificate-bound token at /token. The
+// claims are decoded without verifying the signature, which only the server can, and the cnf claim is
+// checked against the client certificate.
+func (c *Client) FetchToken() (tokenResponse, *boundTokenClaims, error) {
+	var token tokenResponse
+	tokenURL, err := c.resolve(tokenPath)
+	if err != nil {
+		return token, nil, err
+	}
+	resp, err := c.httpClient.Post(tokenURL, "", nil)
+	if err != nil {
+		return token, nil, fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return token, nil, fmt.Errorf("token request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return token, nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	claims, err := decodeBoundToken(token.AccessToken)
+	if err != nil {
+		return token, nil, err
+	}
+	if leaf := c.clientLeaf(); leaf != nil && claims.Cnf.X5tS256 != certThumbprint(leaf) {
+		return token, claims, fmt.Errorf("token is bound to certificate %s, not to the client certificate", claims.Cnf.X5tS256)
+	}
+	return token, claims, nil
+}
+
+// decodeBoundToken decodes the claims of a token without verifying its signature. The iat and exp
+// claims the server always sets are required, so the claims can be shown without nil checks.
+func decodeBoundToken(raw string) (*boundTokenClaims, error) {
+	var claims boundTokenClaims
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode token: %w", err)
+	}
+	if claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		return nil, errors.New("token is missing its iat or exp claim")
+	}
+	return &claims, nil
+}
+
+// ClientTokenCmd obtains a certificate-bound token and shows its claims.
+type ClientTokenCmd struct {
+	Use  string `kong:"name='use',help='Then send a GET for this path with the token as Authorization: Bearer.'"`
+	JSON bool   `kong:"name='json',help='Print the token response and its claims as JSON.'"`
+}
+
+// Run fetches a token, prints it with its claims and optionally presents it.
+func (t *ClientTokenCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	token, claims, err := client.FetchToken()
+	if err != nil {
+		return err
+	}
+	if t.JSON {
+		out, err := json.MarshalIndent(struct {
+			tokenResponse
+			Claims *boundTokenClaims `json:"claims"`
+		}{token, claims}, "", "  ")
+		if err != nil {
+			return err
+		}
+		outputf("%s\n", out)
+	} else {
+		outputf("Access token: %s\n", token.AccessToken)
+		outputf("Subject:      %s (issuer %s)\n", claims.Subject, claims.Issuer)
+		outputf("Issued:       %s\n", claims.IssuedAt.UTC().Format(time.RFC3339))
+		outputf("Expires:      %s (in %ds)\n", claims.ExpiresAt.UTC().Format(time.RFC3339), token.ExpiresIn)
+		outputf("Bound to:     x5t#S256 %s (the client certificate)\n", claims.Cnf.X5tS256)
+	}
+	if t.Use == "" {
+		return nil
+	}
+	if client.ServerURL, err = client.resolve(t.Use); err != nil {
+		return err
+	}
+	if client.Header == nil {
+		client.Header = http.Header{}
+	}
+	client.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	if _, status, err := client.SendRequest(); err != nil {
+		return err
+	} else if status != http.StatusOK {
+		return fmt.Errorf("server refused the token with status %d", status)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"tls-playground/pkg/mtls"
+)
+
+// fetchBoundToken obtains a token for the client's certificate from the /token endpoint.
+func fetchBoundToken(t *testing.T, client *Client, baseURL string) tokenResponse {
+	t.Helper()
+	resp, err := client.httpClient.Post(baseURL+tokenPath, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from %s, got %d", tokenPath, resp.StatusCode)
+	}
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// getWithToken sends a GET with the bearer token (if any) and returns the status code.
+func getWithToken(t *testing.T, client *Client, url, token string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestBoundTokenOnlyValidWithMatchingCert(t *testing.T) {
+	pki := newTestPKI(t)
+	otherCert := filepath.Join(pki.Dir, "other.crt")
+	otherKey := filepath.Join(pki.Dir, "other.key")
+	if err := mtls.AppendKnownClient(pki.KnownClientsFile, "other_client", pki.newClientCert(t, "other_client", otherCert, otherKey)); err != nil {
+		t.Fatal(err)
+	}
+	_, baseURL := startTestServer(t, pki, nil)
+
+	owner, err := NewClient(baseURL, pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewClient(baseURL, pki.ServerCertFile, otherCert, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := fetchBoundToken(t, owner, baseURL)
+	if token.TokenType != "Bearer" || token.ExpiresIn != int(defaultTokenTTL/time.Second) {
+		t.Errorf("Unexpected token response: %+v", token)
+	}
+
+	if status := getWithToken(t, owner, baseURL+"/hello", token.AccessToken); status != http.StatusOK {
+		t.Errorf("Expected token to be accepted with the matching cert, got %d", status)
+	}
+	if status := getWithToken(t, other, baseURL+"/hello", token.AccessToken); status != http.StatusUnauthorized {
+		t.Errorf("Expected token to be rejected with another known cert, got %d", status)
+	}
+	if status := getWithToken(t, other, baseURL+"/hello", ""); status != http.StatusOK {
+		t.Errorf("Expected plain mTLS to still be accepted, got %d", status)
+	}
+	if status := getWithToken(t, owner, baseURL+"/hello", token.AccessToken+"x"); status != http.StatusUnauthorized {
+		t.Errorf("Expected a tampered token to be rejected, got %d", status)
+	}
+}
+
+func TestBoundTokenExpires(t *testing.T) {
+	pki := newTestPKI(t)
+	cert, err := loadCertificate(pki.ClientCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer("", pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	if server.tokenKey, err = newTokenKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := server.issueBoundToken(cert, time.Now().Add(-2*defaultTokenTTL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.verifyBoundToken(token, cert); err == nil {
+		t.Error("Expected an expired token to be rejected")
+	}
+
+	token, err = server.issueBoundToken(cert, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := server.verifyBoundToken(token, cert)
+	if err != nil {
+		t.Fatalf("Expected a fresh token to verify: %v", err)
+	}
+	if claims.Subject != pki.ClientCN || claims.Cnf.X5tS256 != certThumbprint(cert) {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestClientFetchToken(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client := newTestClient(t, pki, baseURL)
+
+	token, claims, err := client.FetchToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != pki.ClientCN || claims.Issuer != tokenIssuer || claims.Cnf.X5tS256 != certThumbprint(client.clientLeaf()) {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+	if status := getWithToken(t, client, baseURL+"/", token.AccessToken); status != http.StatusOK {
+		t.Errorf("Expected the fetched token to be accepted, got %d", status)
+	}
+}
+
+func TestDecodeBoundTokenRequiresTimestamps(t *testing.T) {
+	now := time.Now()
+	for name, claims := range map[string]jwt.RegisteredClaims{
+		"complete":    {IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))},
+		"missing iat": {ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute))},
+		"missing exp": {IssuedAt: jwt.NewNumericDate(now)},
+	} {
+		raw, err := jwt.NewWithClaims(jwt.SigningMethodHS256, boundTokenClaims{RegisteredClaims: claims}).SignedString([]byte("key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := decodeBoundToken(raw); (err == nil) != (name == "complete") {
+			t.Errorf("%s: unexpected result %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Bulk Transfers ---
+//
+// /upload reads and discards a request body of any size, and /download?size=N streams N bytes, so the
+// cost of bulk encryption can be measured apart from handshakes: client transfer times both directions
+// from the moment it has a connection, and reports the rate with the negotiated version and cipher suite.
+// Comparing runs with --ciphers (TLS 1.2) or --max-tls shows e.g. AES-GCM with hardware support against
+// ChaCha20-Poly1305. The payload is zeros, which is fine as TLS no longer compresses. The server logs the
+// rate it saw for each transfer.
+
+const (
+	uploadPath   = "/upload"
+	downloadPath = "/download"
+	// maxTransferSize bounds a single /download, so a typo can't stream forever.
+	maxTransferSize = 64 << 30
+	// defaultTransferSize is the /download size when none is given.
+	defaultTransferSize = 1 << 20
+)
+
+// zeroReader reads zeros forever.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix (powers of 1024), e.g. 512K or 1GiB.
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSpace(s)
+	for _, suffix := range []string{"iB", "B"} {
+		num = strings.TrimSuffix(num, suffix)
+	}
+	var shift uint
+	if n := len(num); n > 0 {
+		switch strings.ToUpper(num[n-1:]) {
+		case "K":
+			shift = 10
+		case "M":
+			shift = 20
+		case "G":
+			shift = 30
+		}
+		if shift > 0 {
+			num = num[:n-1]
+		}
+	}
+	size, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || size < 0 || size > maxTransferSize>>shift {
+		return 0, fmt.Errorf("invalid size %q: want a number of bytes up to 64G, optionally with a K, M or G suffix", s)
+	}
+	return size << shift, nil
+}
+
+// transferRate returns bytes per elapsed as MiB/s.
+func transferRate(bytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes) / (1 << 20) / elapsed.Seconds()
+}
+
+// transferAttrs returns the log fields of a transfer.
+func transferAttrs(r *http.Request, bytes int64, elapsed time.Duration) []slog.Attr {
+	attrs := append(requestAttrs(r), slog.Int64("bytes", bytes), slog.Float64("elapsed_ms", millis(elapsed)),
+		slog.String("mib_per_second", fmt.Sprintf("%.1f", transferRate(bytes, elapsed))))
+	if r.TLS != nil {
+		attrs = append(attrs, slog.String("cipher_suite", tls.CipherSuiteName(r.TLS.CipherSuite)))
+	}
+	return attrs
+}
+
+// uploadResponse is the body of a /upload response.
+type uploadResponse struct {
+	Bytes         int64   `json:"bytes"`
+	ElapsedMillis float64 `json:"elapsed_ms"`
+	MiBPerSecond  float64 `json:"mib_per_second"`
+}
+
+// uploadHandler reads the request body to the end and reports how much arrived and how fast.
+func (s *Server) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, r.Body)
+	elapsed := time.Since(start)
+	if err != nil {
+		logAuth(levelError, "Upload failed: "+err.Error(), transferAttrs(r, n, elapsed)...)
+		http.Error(w, "failed to read the upload", http.StatusBadRequest)
+		return
+	}
+	logAuth(levelInfo, "Received upload", transferAttrs(r, n, elapsed)...)
+	writeJSON(w, http.StatusOK, uploadResponse{Bytes: n, ElapsedMillis: millis(elapsed), MiBPerSecond: transferRate(n, elapsed)})
+}
+
+// downloadHandler streams the number of zero bytes given by the size query parameter.
+func (s *Server) downloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	size := int64(defaultTransferSize)
+	if param := r.URL.Query().Get("size"); param != "" {
+		var err error
+		if size, err = parseSize(param); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	start := time.Now()
+	n, err := io.Copy(w, io.LimitReader(zeroReader{}, size))
+	elapsed := time.Since(start)
+	if err != nil {
+		logAuth(levelError, "Download failed: "+err.Error(), transferAttrs(r, n, elapsed)...)
+		return
+	}
+	logAuth(levelInfo, "Sent download", transferAttrs(r, n, elapsed)...)
+}
+
+// ClientTransferCmd measures upload and download rates.
+type ClientTransferCmd struct {
+	Size      string `kong:"name='size',help='Bytes to transfer each way, with an optional K, M or G suffix (powers of 1024).',default='100M'"`
+	Direction string `kong:"name='direction',help='Transfer to the server (upload), from it (download), or both.',enum='upload,download,both',default='both'"`
+	JSON      bool   `kong:"name='json',help='Print the results as JSON.'"`
+}
+
+// transferResult is the outcome of one transfer.
+type transferResult struct {
+	Direction     string  `json:"direction"`
+	Bytes         int64   `json:"bytes"`
+	ElapsedMillis float64 `json:"elapsed_ms"`
+	MiBPerSecond  float64 `json:"mib_per_second"`
+	TLSVersion    string  `json:"tls_version"`
+	CipherSuite   string  `json:"cipher_suite"`
+	Protocol      string  `json:"protocol"`
+}
+
+// Run transfers the payload in the requested directions and prints the rates.
+func (t *ClientTransferCmd) Run(c *ClientCmd) error {
+	size, err := parseSize(t.Size)
+	if err != nil {
+		return fmt.Errorf("invalid --size: %w", err)
+	}
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	var results []transferResult
+	for _, direction := range []string{"upload", "download"} {
+		if t.Direction != direction && t.Direction != "both" {
+			continue
+		}
+		result, err := client.Transfer(direction, size)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	}
+	if t.JSON {
+		out, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		outputf("%s\n", out)
+		return nil
+	}
+	for i, r := range results {
+		if i == 0 {
+			outputf("%-20s%s, %s, %s\n", "Connection:", r.TLSVersion, r.CipherSuite, r.Protocol)
+		}
+		elapsed := time.Duration(r.ElapsedMillis * float64(time.Millisecond))
+		outputf("%-20s%s in %s (%.1f MiB/s, %.0f Mbit/s)\n", transferLabels[r.Direction]+":", formatSize(r.Bytes),
+			elapsed.Round(time.Millisecond), r.MiBPerSecond, float64(r.Bytes)*8/1e6/elapsed.Seconds())
+	}
+	return nil
+}
+
+// transferLabels names the directions in the report.
+var transferLabels = map[string]string{"upload": "Upload", "download": "Download"}
+
+// Transfer sends size bytes to /upload or receives them from /download ("upload" or "download") and
+// measures the rate. Timing starts once the request has a connection, so a handshake is not counted.
+func (c *Client) Transfer(direction string, size int64) (transferResult, error) {
+	var (
+		path, method string
+		body         io.Reader
+	)
+	switch direction {
+	case "upload":
+		path, method, body = uploadPath, http.MethodPost, io.LimitReader(zeroReader{}, size)
+	case "download":
+		path, method = fmt.Sprintf("%s?size=%d", downloadPath, size), http.MethodGet
+	default:
+		return transferResult{}, fmt.Errorf("unknown transfer direction %q (want upload or download)", direction)
+	}
+	target, err := c.resolve(path)
+	if err != nil {
+		return transferResult{}, err
+	}
+	ctx := context.Background()
+	if c.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.RequestTimeout)
+		defer cancel()
+	}
+	var start time.Time
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{GotConn: func(httptrace.GotConnInfo) { start = time.Now() }})
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return transferResult{}, err
+	}
+	if body != nil {
+		req.ContentLength = size // Not chunked
+	}
+
+	logInfof("Starting %s of %s to %s...", direction, formatSize(size), target)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.explainHandshakeError(err)
+		return transferResult{}, fmt.Errorf("%s failed: %w", direction, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return transferResult{}, fmt.Errorf("%s failed with status %d: %s", direction, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	transferred := size
+	if direction == "download" {
+		if transferred, err = io.Copy(ioutil.Discard, resp.Body); err != nil {
+			return transferResult{}, fmt.Errorf("download failed after %d bytes: %w", transferred, err)
+		}
+	} else {
+		var uploaded uploadResponse
+		if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+			return transferResult{}, fmt.Errorf("unexpected upload response: %w", err)
+		}
+		transferred = uploaded.Bytes
+	}
+	elapsed := time.Since(start)
+	if transferred != size {
+		return transferResult{}, fmt.Errorf("%s transferred %d of %d bytes", direction, transferred, size)
+	}
+	result := transferResult{Direction: direction, Bytes: size, ElapsedMillis: millis(elapsed), MiBPerSecond: transferRate(size, elapsed), Protocol: resp.Proto}
+	if resp.TLS != nil {
+		result.TLSVersion, result.CipherSuite = tlsVersionName(resp.TLS.Version), tls.CipherSuiteName(resp.TLS.CipherSuite)
+	}
+	return result, nil
+}
+
+// formatSize formats a byte count with a binary unit, e.g. 1.5 MiB.
+func formatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(bytes)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", bytes)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"1500", 1500, true},
+		{"512K", 512 << 10, true},
+		{"10MB", 10 << 20, true},
+		{"1GiB", 1 << 30, true},
+		{"2g", 2 << 30, true},
+		{"64G", 64 << 30, true},
+		{"65G", 0, false},
+		{"-1", 0, false},
+		{"1T", 0, false},
+		{"", 0, false},
+	} {
+		got, err := parseSize(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d, ok=%v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, direction := range []string{"upload", "download"} {
+		var result transferResult
+		_, logs := captureOutput(t, func() { result, err = client.Transfer(direction, 3<<20+17) })
+		if err != nil {
+			t.Fatalf("%s failed: %v", direction, err)
+		}
+		if result.Bytes != 3<<20+17 || result.MiBPerSecond <= 0 || result.CipherSuite == "" || result.Protocol != "HTTP/2.0" {
+			t.Errorf("Unexpected %s result %+v", direction, result)
+		}
+		want := map[string]string{"upload": "Received upload", "download": "Sent download"}[direction]
+		if !strings.Contains(logs, want) || !strings.Contains(logs, "bytes=3145745") {
+			t.Errorf("Expected the server to log the %s with its size, got:\n%s", direction, logs)
+		}
+	}
+
+	resp, err := client.httpClient.Get(baseURL + downloadPath + "?size=lots")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid size, got %d", resp.StatusCode)
+	}
+}
+
+func TestClientTransferCmdJSON(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	cmd := &ClientTransferCmd{Size: "256K", Direction: "download", JSON: true}
+	var err error
+	stdout, _ := captureOutput(t, func() {
+		err = cmd.Run(&ClientCmd{ServerURL: baseURL + "/hello", ServerCertFile: pki.ServerCertFile,
+			CertFile: pki.ClientCertFile, KeyFile: pki.ClientKeyFile, MinTLS: "1.2"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []transferResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		t.Fatalf("Invalid JSON %q: %v", stdout, err)
+	}
+	if len(results) != 1 || results[0].Direction != "download" || results[0].Bytes != 256<<10 {
+		t.Errorf("Expected one 256 KiB download, got %+v", results)
+	}
+}
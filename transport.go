@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// --- Client Connection Pooling ---
+//
+// By default the client keeps connections open after a request and sends the next request to the
+// same host over one of them, skipping TCP and TLS setup entirely. TransportOptions tune that pool,
+// and turn it off: with DisableKeepAlives every request opens a new connection, which resumes an
+// earlier TLS session if the client has a session cache; with ForceNewHandshake it also can't resume,
+// so every request pays for a full handshake. The client logs which of the three each request got.
+
+// TransportOptions tune the client's connection pool. Zero values keep net/http's defaults.
+type TransportOptions struct {
+	// MaxIdleConns limits idle connections across all hosts (0 means no limit).
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits idle connections to each host (0 means net/http's default of 2).
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits connections to each host, idle or not; requests beyond it wait (0 means no limit).
+	MaxConnsPerHost int
+	// IdleConnTimeout closes connections idle for longer (0 means they stay open).
+	IdleConnTimeout time.Duration
+	// DisableKeepAlives opens a new connection for every request.
+	DisableKeepAlives bool
+	// ForceNewHandshake disables keep-alives and TLS session resumption, so every request does a full handshake.
+	ForceNewHandshake bool
+}
+
+// apply sets the non-zero options on t.
+func (o TransportOptions) apply(t *http.Transport) {
+	if o.MaxIdleConns != 0 {
+		t.MaxIdleConns = o.MaxIdleConns
+	}
+	if o.MaxIdleConnsPerHost != 0 {
+		t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	if o.MaxConnsPerHost != 0 {
+		t.MaxConnsPerHost = o.MaxConnsPerHost
+	}
+	if o.IdleConnTimeout != 0 {
+		t.IdleConnTimeout = o.IdleConnTimeout
+	}
+	if o.DisableKeepAlives || o.ForceNewHandshake {
+		t.DisableKeepAlives = true
+	}
+}
+
+// SetTransportOptions tunes the client's connection pool. Call it before the first request.
+func (c *Client) SetTransportOptions(o TransportOptions) {
+	c.transportOptions = o
+	routing := c.httpClient.Transport.(*certRoutingTransport)
+	for _, rt := range []http.RoundTripper{routing.withCert, routing.withoutCert} {
+		o.apply(rt.(*http.Transport))
+	}
+	if o.ForceNewHandshake {
+		// Takes precedence over a session cache, including one enabled later (e.g. by the REPL).
+		c.tlsConfig.SessionTicketsDisabled = true
+		c.anonymousTLSConfig.SessionTicketsDisabled = true
+	}
+}
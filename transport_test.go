@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// connectionReuse sends two requests with the client and reports how the second one got its connection.
+func connectionReuse(t *testing.T, client *Client, pause time.Duration) replResponse {
+	t.Helper()
+	defer client.httpClient.CloseIdleConnections()
+	if _, err := client.replRequest(client.httpClient, replLine{Method: http.MethodGet, Path: "/hello"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(pause)
+	resp, err := client.replRequest(client.httpClient, replLine{Method: http.MethodGet, Path: "/hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestTransportOptions(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	newClient := func(o TransportOptions) *Client {
+		client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.EnableSessionCache(0)
+		client.SetTransportOptions(o)
+		return client
+	}
+
+	for _, tc := range []struct {
+		name    string
+		options TransportOptions
+		pause   time.Duration
+		reused  bool
+		resumed bool
+	}{
+		{name: "default", reused: true},
+		{name: "disable keep-alives", options: TransportOptions{DisableKeepAlives: true}, resumed: true},
+		{name: "force new handshake", options: TransportOptions{ForceNewHandshake: true}},
+		{name: "idle timeout", options: TransportOptions{IdleConnTimeout: 50 * time.Millisecond}, pause: 200 * time.Millisecond, resumed: true},
+	} {
+		resp := connectionReuse(t, newClient(tc.options), tc.pause)
+		if resp.Reused != tc.reused || resp.DidResume != tc.resumed {
+			t.Errorf("%s: expected reused %t and resumed %t, got %t and %t", tc.name, tc.reused, tc.resumed, resp.Reused, resp.DidResume)
+		}
+	}
+
+	// Workers share the one connection allowed
+	report := newClient(TransportOptions{MaxConnsPerHost: 1}).Bench(benchOptions{Concurrency: 4, Requests: 12, KeepAlive: true})
+	if report.Succeeded != 12 || report.FullHandshakes+report.ResumedHandshakes != 1 {
+		t.Errorf("Expected 12 requests over a single connection, got %d succeeded with %d full and %d resumed handshakes",
+			report.Succeeded, report.FullHandshakes, report.ResumedHandshakes)
+	}
+}
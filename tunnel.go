@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- mTLS Tunnel ---
+//
+// A minimal stunnel: `client tunnel` listens on a local plaintext port and carries every connection it
+// accepts over its own mTLS connection to a server started with --mode tunnel, which forwards it to
+// --tunnel-target. Clients are authenticated exactly as in the other modes, so a database or any other
+// TCP service behind the tunnel is only reachable with a known client certificate. The streams are
+// copied as is in both directions; when one side finishes sending, the other is told so with a
+// half-close and can still answer.
+
+const serverModeTunnel = "tunnel"
+
+// tunnelDialTimeout bounds connecting to the tunnel target, and the client's connection to the server.
+const tunnelDialTimeout = 10 * time.Second
+
+// serveTunnelConn runs the mTLS handshake on conn and forwards the stream to TunnelTarget, or to the
+// destination the client asks for (see socks.go), until both sides are done.
+func (s *Server) serveTunnelConn(conn net.Conn) {
+	s.metrics.activeConns.Inc()
+	defer s.metrics.activeConns.Dec()
+
+	conn.SetDeadline(time.Now().Add(echoHandshakeTimeout))
+	tlsConn, err := s.ServerConn(conn)
+	if err != nil {
+		logWarnf("Tunnel connection rejected: %v", err) // The auth decision is logged by the verifier
+		return
+	}
+	defer tlsConn.Close()
+	conn.SetDeadline(time.Time{})
+
+	cn := s.clientID(tlsConn.ConnectionState().PeerCertificates[0])
+	destination, err := s.tunnelDestination(tlsConn, cn)
+	if err != nil {
+		logWarnf("Tunnel from %s (%s) refused: %v", cn, conn.RemoteAddr(), err)
+		return
+	}
+	target, err := net.DialTimeout("tcp", destination, tunnelDialTimeout)
+	if err != nil {
+		logErrorf("Tunnel from %s (%s): failed to connect to %s: %v", cn, conn.RemoteAddr(), destination, err)
+		if s.tunnelAllow != nil {
+			writeTunnelReply(tlsConn, tunnelReplyFailed, "destination unreachable")
+		}
+		return
+	}
+	defer target.Close()
+	if s.tunnelAllow != nil {
+		if err := writeTunnelReply(tlsConn, tunnelReplyOK, ""); err != nil {
+			return
+		}
+	}
+	logInfof("Tunnel from %s (%s) to %s opened", cn, conn.RemoteAddr(), destination)
+	sent, received := pipeConns(tlsConn, target)
+	logInfof("Tunnel from %s (%s) to %s closed after %d bytes sent and %d received", cn, conn.RemoteAddr(), destination, sent, received)
+}
+
+// pipeConns copies a to b and b to a until both directions are done, half-closing each destination once
+// its source is exhausted. It returns the bytes copied each way.
+func pipeConns(a, b net.Conn) (aToB, bToA int64) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		bToA = copyAndCloseWrite(a, b)
+	}()
+	aToB = copyAndCloseWrite(b, a)
+	wg.Wait()
+	return aToB, bToA
+}
+
+// copyAndCloseWrite copies src to dst, then closes the writing side of dst (sending a TLS close_notify
+// or a TCP FIN), or all of dst if it can't be half-closed.
+func copyAndCloseWrite(dst, src net.Conn) int64 {
+	n, err := io.Copy(dst, src)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		logDebugf("Tunnel copy from %s to %s ended: %v", src.RemoteAddr(), dst.RemoteAddr(), err)
+	}
+	if closer, ok := dst.(interface{ CloseWrite() error }); ok {
+		closer.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	return n
+}
+
+// Tunnel accepts plaintext connections on listener and forwards each over a new mTLS connection to the
+// host and port of ServerURL, until ctx is done. Connections still open then are closed.
+func (c *Client) Tunnel(ctx context.Context, listener net.Listener) error {
+	addr, err := c.echoAddr()
+	if err != nil {
+		return err
+	}
+	logInfof("Tunneling connections to %s through %s", listener.Addr(), addr)
+	return serveLocal(ctx, listener, func(local net.Conn) {
+		tlsConn, err := c.dialTunnel(ctx, addr)
+		if err != nil {
+			logErrorf("Tunnel for %s: %v", local.RemoteAddr(), err)
+			return
+		}
+		defer tlsConn.Close()
+		sent, received := pipeConns(local, tlsConn)
+		// With TLS 1.3 a rejected client only learns about it from the server's alert, ending the stream early
+		logInfof("Tunnel for %s closed after %d bytes sent and %d received", local.RemoteAddr(), sent, received)
+	})
+}
+
+// serveLocal accepts connections on listener and handles each in a goroutine until ctx is done, then
+// closes the connections still open and waits for their handlers.
+func serveLocal(ctx context.Context, listener net.Listener, handle func(net.Conn)) error {
+	var mu sync.Mutex
+	conns := make(map[net.Conn]struct{})
+	var wg sync.WaitGroup
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+	defer wg.Wait()
+
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("accept error on %s: %w", listener.Addr(), err)
+		}
+		mu.Lock()
+		conns[local] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, local)
+				mu.Unlock()
+				local.Close()
+			}()
+			handle(local)
+		}()
+	}
+}
+
+// dialTunnel opens an mTLS connection to the tunnel server at addr.
+func (c *Client) dialTunnel(ctx context.Context, addr string) (*tls.Conn, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, tunnelDialTimeout)
+	conn, err := c.dialContext(dialCtx, "tcp", addr)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(echoHandshakeTimeout))
+	tlsConn, err := c.ClientConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	state := tlsConn.ConnectionState()
+	logInfof("Tunnel opened to %s (%s)", state.PeerCertificates[0].Subject.CommonName, tls.VersionName(state.Version))
+	return tlsConn, nil
+}
+
+// ClientTunnelCmd forwards local plaintext connections over mTLS to a server started with --mode tunnel.
+type ClientTunnelCmd struct {
+	Listen string `kong:"name='listen',help='Local address to accept plaintext connections on; port 0 picks a free one.',default='localhost:9443'"`
+}
+
+// Run tunnels connections until SIGINT or SIGTERM.
+func (t *ClientTunnelCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", t.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", t.Listen, err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return client.Tunnel(ctx, listener)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startUpperTarget starts a plain TCP service that answers with everything it read, uppercased, once
+// the client half-closes, and counts its connections.
+func startUpperTarget(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var conns atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conn.Close()
+				data, _ := ioutil.ReadAll(conn)
+				io.WriteString(conn, strings.ToUpper(string(data)))
+			}()
+		}
+	}()
+	return listener.Addr().String(), &conns
+}
+
+// startTestTunnel runs client.Tunnel on a free local port until the test ends.
+func startTestTunnel(t *testing.T, client *Client) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.Tunnel(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+// throughTunnel sends msg through the tunnel at addr, half-closes and returns the reply.
+func throughTunnel(t *testing.T, addr, msg string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.(*net.TCPConn).CloseWrite()
+	reply, _ := ioutil.ReadAll(conn)
+	return string(reply)
+}
+
+func TestTunnelMode(t *testing.T) {
+	pki := newTestPKI(t)
+	target, conns := startUpperTarget(t)
+	_, url := startTestServer(t, pki, func(s *Server) {
+		s.Mode = serverModeTunnel
+		s.TunnelTarget = target
+	})
+	addr := startTestTunnel(t, newTestClient(t, pki, url))
+
+	for _, msg := range []string{"hello through the tunnel\n", strings.Repeat("x", 256*1024)} {
+		if reply := throughTunnel(t, addr, msg); reply != strings.ToUpper(msg) {
+			t.Errorf("Expected the target's answer to come back after the half-close, got %d bytes", len(reply))
+		}
+	}
+	if n := conns.Load(); n != 2 {
+		t.Errorf("Expected one target connection per tunneled connection, got %d", n)
+	}
+}
+
+func TestTunnelModeRejectsUnknownClient(t *testing.T) {
+	pki := newTestPKI(t)
+	target, conns := startUpperTarget(t)
+	_, url := startTestServer(t, pki, func(s *Server) {
+		s.Mode = serverModeTunnel
+		s.TunnelTarget = target
+	})
+	unknownCert, unknownKey := filepath.Join(pki.Dir, "unknown.crt"), filepath.Join(pki.Dir, "unknown.key")
+	pki.newClientCert(t, "intruder", unknownCert, unknownKey)
+	client, err := NewClient(url, pki.ServerCertFile, unknownCert, unknownKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := startTestTunnel(t, client)
+
+	if reply := throughTunnel(t, addr, "let me in\n"); reply != "" {
+		t.Errorf("Expected no reply for an unknown client, got %q", reply)
+	}
+	if n := conns.Load(); n != 0 {
+		t.Errorf("Expected the target not to be reached, got %d connections", n)
+	}
+}
+
+func TestTunnelTargetRequiresTunnelMode(t *testing.T) {
+	pki := newTestPKI(t)
+	for _, configure := range []func(*Server){
+		func(s *Server) { s.Mode = serverModeTunnel },
+		func(s *Server) { s.TunnelTarget = "localhost:5432" },
+	} {
+		server := NewServer(freeAddr(t), pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+		configure(server)
+		if err := server.Start(); err == nil || !strings.Contains(err.Error(), "tunnel") {
+			server.Stop()
+			t.Errorf("Expected the server to refuse to start, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// --- Unix Domain Sockets ---
+//
+// The server listens on a Unix socket with --addr unix:///path/to/socket, e.g. for a sidecar sharing a
+// volume with the service it fronts. Nothing about mTLS changes: the handshake and client verification
+// are the same as over TCP. Access to the socket file is a second gate, set with SocketMode (0600 by
+// default) and SocketGroup. A socket left behind by a server that didn't stop cleanly is replaced; one a
+// running server still accepts on is not. Clients reach the socket with --unix-socket, keeping --url for
+// the host name to verify and the path to request, like curl's option of the same name.
+
+const (
+	unixAddrPrefix    = "unix://"
+	defaultSocketMode = 0600
+)
+
+// unixSocketPath returns the socket path of a unix:// listen address.
+func unixSocketPath(addr string) (string, bool) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	return path, ok
+}
+
+// validateListenAddr checks that addr is a host:port or unix:///path listen address.
+func validateListenAddr(addr string) error {
+	if path, ok := unixSocketPath(addr); ok {
+		if path == "" {
+			return fmt.Errorf("invalid address %q: want %s/path/to/socket", addr, unixAddrPrefix)
+		}
+		return nil
+	}
+	return validateAddr(addr)
+}
+
+// parseSocketMode parses an octal permission mode such as 0660.
+func parseSocketMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid socket mode %q: want octal permissions such as 0660", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenOn binds a listener on addr, a TCP address or a Unix socket.
+func (s *Server) listenOn(addr string) (net.Listener, error) {
+	path, ok := unixSocketPath(addr)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path) // Removes the socket file again on Close
+	if err != nil {
+		return nil, err
+	}
+	mode := s.SocketMode
+	if mode == 0 {
+		mode = defaultSocketMode
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions of %s: %w", path, err)
+	}
+	if s.SocketGroup != "" {
+		gid, err := lookupGroupID(s.SocketGroup)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to set group of %s: %w", path, err)
+		}
+	}
+	return listener, nil
+}
+
+// removeStaleSocket removes a socket file no server accepts on anymore.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another server", path)
+	}
+	logInfof("Removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+// lookupGroupID returns the ID of a group given by name or number.
+func lookupGroupID(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up socket group: %w", err)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// SetUnixSocket makes the client connect to the Unix socket at path instead of the ServerURL host, which
+// is still sent in SNI and verified. Call it before the first request.
+func (c *Client) SetUnixSocket(path string) {
+	c.unixSocket = path
+	routing := c.httpClient.Transport.(*certRoutingTransport)
+	for _, rt := range []http.RoundTripper{routing.withCert, routing.withoutCert} {
+		rt.(*http.Transport).DialContext = c.dialContext
+	}
+}
+
+// dialContext connects to addr, or to the Unix socket set with SetUnixSocket.
+func (c *Client) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d net.Dialer
+	if c.unixSocket != "" {
+		return d.DialContext(ctx, "unix", c.unixSocket)
+	}
+	return d.DialContext(ctx, network, addr)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startUnixSocketServer starts a server for the PKI on a Unix socket in its directory.
+func startUnixSocketServer(t *testing.T, pki *testPKI, configure func(*Server)) string {
+	t.Helper()
+	path := filepath.Join(pki.Dir, "mtls.sock")
+	server := NewServer(unixAddrPrefix+path, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	if configure != nil {
+		configure(server)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	return path
+}
+
+func TestValidateListenAddr(t *testing.T) {
+	for _, addr := range []string{":8443", "unix:///run/mtls.sock", "unix://mtls.sock"} {
+		if err := validateListenAddr(addr); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", addr, err)
+		}
+	}
+	for _, addr := range []string{"unix://", "localhost"} {
+		if err := validateListenAddr(addr); err == nil {
+			t.Errorf("Expected %q to be rejected", addr)
+		}
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	if mode, err := parseSocketMode("0660"); err != nil || mode != 0660 {
+		t.Errorf("parseSocketMode(0660) = %o, %v", mode, err)
+	}
+	for _, s := range []string{"", "660x", "0999", "01777"} {
+		if _, err := parseSocketMode(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestServerOverUnixSocket(t *testing.T) {
+	pki := newTestPKI(t)
+	path := startUnixSocketServer(t, pki, func(s *Server) { s.SocketMode = 0660 })
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0660 {
+		t.Errorf("Expected a socket with mode 0660, got %s", info.Mode())
+	}
+
+	client, err := NewClient("https://localhost/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetUnixSocket(path)
+	body, status, err := client.SendRequest()
+	if err != nil || status != http.StatusOK {
+		t.Fatalf("Expected the request over the socket to succeed, got %d (%v)", status, err)
+	}
+	if !strings.Contains(body, pki.ClientCN) {
+		t.Errorf("Expected the client to be authenticated over the socket, got %q", body)
+	}
+}
+
+func TestEchoOverUnixSocket(t *testing.T) {
+	pki := newTestPKI(t)
+	path := startUnixSocketServer(t, pki, func(s *Server) { s.Mode = serverModeTCP })
+
+	client, err := NewClient("https://localhost", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetUnixSocket(path)
+	var out bytes.Buffer
+	if err := client.Echo(strings.NewReader("hello\n"), &out); err != nil {
+		t.Fatalf("Expected the echo over the socket to succeed, got %v", err)
+	}
+	if out.String() != "hello\n" {
+		t.Errorf("Expected the line echoed back, got %q", out.String())
+	}
+}
+
+func TestUnixSocketStaleAndInUse(t *testing.T) {
+	pki := newTestPKI(t)
+	path := filepath.Join(pki.Dir, "mtls.sock")
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close() // Leaves the socket file behind, like a crashed server
+
+	startUnixSocketServer(t, pki, nil)
+
+	other := NewServer(unixAddrPrefix+path, pki.ServerCertFile, pki.ServerKeyFile, pki.KnownClientsFile)
+	if err := other.Start(); err == nil {
+		other.Stop()
+		t.Fatal("Expected a socket in use to fail Start")
+	} else if !strings.Contains(err.Error(), "in use") {
+		t.Errorf("Expected an in use error, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- Vault PKI Backend ---
+//
+// ca issue --backend vault has a HashiCorp Vault PKI secrets engine sign the certificate instead of the
+// playground CA: the key and a CSR are made locally, so the key never leaves the machine, and the CSR is
+// sent to the sign endpoint of a role (POST /v1/<mount>/sign/<role>). The role decides what Vault
+// accepts, e.g. the allowed names, key usages and maximum TTL. Vault certificates are meant to be
+// short-lived, so --vault-ttl defaults to an hour; with --add-known-client every renewal is a new
+// fingerprint to pin, which shows the cost of pinning short-lived certificates (compare with the
+// server's --verify-mode ca and the Vault CA as --client-ca).
+
+// caBackendVault is the ca issue --backend that has Vault sign certificates.
+const caBackendVault = "vault"
+
+// vaultRequestTimeout bounds a request to Vault.
+const vaultRequestTimeout = 30 * time.Second
+
+// vaultSignResponse is the part of the sign endpoint's response used here.
+type vaultSignResponse struct {
+	Data struct {
+		Certificate  string   `json:"certificate"`
+		IssuingCA    string   `json:"issuing_ca"`
+		CAChain      []string `json:"ca_chain"`
+		SerialNumber string   `json:"serial_number"`
+	} `json:"data"`
+	Warnings []string `json:"warnings"`
+	Errors   []string `json:"errors"`
+}
+
+// issueFromVault has the Vault PKI role sign a certificate for a new key, and returns the certificate
+// followed by Vault's CA chain, the key, and the issuing CA.
+func (c *CAIssueCmd) issueFromVault(opts certOptions) (certPEM, keyPEM []byte, ca *x509.Certificate, err error) {
+	addr, token := firstNonEmpty(c.VaultAddr, os.Getenv("VAULT_ADDR")), firstNonEmpty(c.VaultToken, os.Getenv("VAULT_TOKEN"))
+	switch {
+	case addr == "":
+		return nil, nil, nil, errors.New("the Vault backend requires --vault-addr or VAULT_ADDR")
+	case token == "":
+		return nil, nil, nil, errors.New("the Vault backend requires --vault-token or VAULT_TOKEN")
+	case c.VaultRole == "":
+		return nil, nil, nil, errors.New("the Vault backend requires --vault-role")
+	}
+	client, err := newVaultHTTPClient(firstNonEmpty(c.VaultCACert, os.Getenv("VAULT_CACERT")))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := generateKey(opts.KeyType, opts.RSABits)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: opts.CommonName},
+		DNSNames:       opts.DNSNames,
+		IPAddresses:    opts.IPAddresses,
+		EmailAddresses: opts.EmailAddresses,
+		URIs:           opts.URIs,
+	}, key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	body, err := json.Marshal(map[string]string{
+		"csr":         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		"common_name": opts.CommonName,
+		"ttl":         c.VaultTTL.String(),
+		"format":      "pem",
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/sign/%s", strings.TrimSuffix(addr, "/"), strings.Trim(c.VaultMount, "/"), c.VaultRole)
+	logInfof("Requesting a %s certificate for CN '%s' from Vault at %s...", c.Kind, opts.CommonName, url)
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to reach Vault: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read Vault response: %w", err)
+	}
+	var signed vaultSignResponse
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, nil, nil, fmt.Errorf("unexpected Vault response (%s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, nil, fmt.Errorf("Vault refused to sign the certificate (%s): %s", resp.Status, strings.Join(signed.Errors, "; "))
+	}
+	for _, warning := range signed.Warnings {
+		logWarnf("Vault: %s", warning)
+	}
+
+	chain := signed.Data.CAChain
+	if len(chain) == 0 {
+		chain = []string{signed.Data.IssuingCA}
+	}
+	block, _ := pem.Decode([]byte(chain[0]))
+	if block == nil {
+		return nil, nil, nil, errors.New("Vault returned no issuing CA")
+	}
+	if ca, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse the Vault issuing CA: %w", err)
+	}
+	certPEM = []byte(strings.TrimSpace(signed.Data.Certificate) + "\n")
+	for _, caPEM := range chain {
+		certPEM = append(certPEM, strings.TrimSpace(caPEM)+"\n"...)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to marshal private key: %w", err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil { // Checks that it certifies the key of the CSR
+		return nil, nil, nil, fmt.Errorf("Vault returned an unusable certificate: %w", err)
+	}
+	logInfof("Vault issued serial %s", signed.Data.SerialNumber)
+	return certPEM, keyPEM, ca, nil
+}
+
+// newVaultHTTPClient returns an HTTP client for Vault, trusting the CAs in caFile if it is set.
+func newVaultHTTPClient(caFile string) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pool, err := mtls.LoadCertPool(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading Vault CA: %w", err)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport, Timeout: vaultRequestTimeout}, nil
+}
+
+// firstNonEmpty returns the first of values that is not empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tls-playground/pkg/mtls"
+)
+
+// newFakeVault serves the sign endpoint of the PKI role "clients" at /v1/pki, signing with ca.
+func newFakeVault(t *testing.T, ca *testCA, token string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fail := func(status int, msg string) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {msg}})
+		}
+		if r.Header.Get("X-Vault-Token") != token {
+			fail(http.StatusForbidden, "permission denied")
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/pki/sign/clients" {
+			fail(http.StatusNotFound, "unknown role")
+			return
+		}
+		var req struct {
+			CSR        string `json:"csr"`
+			CommonName string `json:"common_name"`
+			TTL        string `json:"ttl"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		block, _ := pem.Decode([]byte(req.CSR))
+		if block == nil {
+			fail(http.StatusBadRequest, "no CSR")
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		ttl, ttlErr := time.ParseDuration(req.TTL)
+		if err != nil || ttlErr != nil || csr.CheckSignature() != nil {
+			fail(http.StatusBadRequest, "invalid request")
+			return
+		}
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(time.Now().UnixNano()),
+			Subject:      csr.Subject,
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(ttl),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca.Cert, csr.PublicKey, ca.Key)
+		if err != nil {
+			fail(http.StatusInternalServerError, err.Error())
+			return
+		}
+		caPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw}))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]interface{}{
+				"certificate":   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+				"issuing_ca":    caPEM,
+				"ca_chain":      []string{caPEM},
+				"serial_number": "01:02",
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCAIssueFromVault(t *testing.T) {
+	pki := newTestPKI(t)
+	dir := t.TempDir()
+	ca := newTestCA(t, dir)
+	vault := newFakeVault(t, ca, "s.token")
+	issue := &CAIssueCmd{Backend: caBackendVault, Kind: "client", CN: "vault_client", OutDir: dir, KeyType: keyTypeECDSA,
+		VaultAddr: vault.URL, VaultToken: "s.token", VaultMount: "pki", VaultRole: "clients", VaultTTL: 15 * time.Minute,
+		AddKnownClient: true, KnownClients: pki.KnownClientsFile}
+	var err error
+	stdout, _ := captureOutput(t, func() { err = issue.Run() })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "vault-client.crt"), filepath.Join(dir, "vault-client.key")
+	certs, err := loadCertificates(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || !certs[1].Equal(ca.Cert) {
+		t.Errorf("Expected the certificate followed by the Vault CA chain, got %d certificates", len(certs))
+	}
+	if left := time.Until(certs[0].NotAfter); left > 15*time.Minute || left < 14*time.Minute {
+		t.Errorf("Expected the --vault-ttl of 15m to be requested, the certificate expires in %s", left)
+	}
+	fingerprint := mtls.CertFingerprint(certs[0])
+	if !strings.Contains(stdout, fingerprint) {
+		t.Errorf("Expected the fingerprint to be printed, got:\n%s", stdout)
+	}
+	known, err := ioutil.ReadFile(pki.KnownClientsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(known), "vault_client "+fingerprint) {
+		t.Errorf("Expected the client to be added to the known clients file, got:\n%s", known)
+	}
+
+	// The Vault-issued certificate is pinned like any other
+	_, url := startTestServer(t, pki, nil)
+	client, err := NewClient(url+"/hello", pki.ServerCertFile, certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, func() {
+		if _, status, err := client.SendRequest(); err != nil || status != http.StatusOK {
+			t.Errorf("Expected the Vault-issued certificate to be accepted, got %d (%v)", status, err)
+		}
+	})
+}
+
+func TestCAIssueFromVaultErrors(t *testing.T) {
+	dir := t.TempDir()
+	vault := newFakeVault(t, newTestCA(t, dir), "s.token")
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	for _, tc := range []struct {
+		name string
+		cmd  CAIssueCmd
+		want string
+	}{
+		{"no address", CAIssueCmd{VaultToken: "s.token", VaultRole: "clients"}, "requires --vault-addr"},
+		{"no role", CAIssueCmd{VaultAddr: vault.URL, VaultToken: "s.token"}, "requires --vault-role"},
+		{"bad token", CAIssueCmd{VaultAddr: vault.URL, VaultToken: "s.wrong", VaultRole: "clients"}, "permission denied"},
+		{"unknown role", CAIssueCmd{VaultAddr: vault.URL, VaultToken: "s.token", VaultRole: "servers"}, "unknown role"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := tc.cmd
+			cmd.Backend, cmd.Kind, cmd.OutDir, cmd.KeyType, cmd.VaultMount, cmd.VaultTTL = caBackendVault, "client", dir, keyTypeECDSA, "pki", time.Hour
+			var err error
+			captureOutput(t, func() { err = cmd.Run() })
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("Expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"tls-playground/pkg/mtls"
+)
+
+// --- WebSocket Echo ---
+//
+// /ws upgrades an authenticated request to a WebSocket. The server first pushes the client's identity
+// as the TLS handshake established it, then echoes every text message back, so one connection stays
+// authenticated for as long as it is open. The known clients entry is checked again for every message:
+// removing the client from the known clients file (and reloading) or letting its entry expire closes
+// the connection with a policy violation. `client ws` is the matching client.
+
+const wsPath = "/ws"
+
+// wsWriteTimeout bounds writing one message to a WebSocket peer.
+const wsWriteTimeout = 5 * time.Second
+
+// Types of wsMessage.
+const (
+	wsMessageIdentity = "identity"
+	wsMessageEcho     = "echo"
+)
+
+// wsMessage is a JSON message the server sends over /ws.
+type wsMessage struct {
+	Type     string      `json:"type"`
+	Identity *wsIdentity `json:"identity,omitempty"` // For identity messages
+	Data     string      `json:"data,omitempty"`     // For echo messages
+}
+
+// wsIdentity is the client identity pushed when a WebSocket opens.
+type wsIdentity struct {
+	CN            string    `json:"cn"`
+	Fingerprint   string    `json:"fingerprint"`
+	Organizations []string  `json:"organizations,omitempty"`
+	NotAfter      time.Time `json:"not_after"`
+	RemoteAddr    string    `json:"remote_addr"`
+	TLSVersion    string    `json:"tls_version"`
+	Resumed       bool      `json:"resumed"`
+}
+
+// wsUpgrader upgrades /ws requests. Browsers are not the intended clients, but a client certificate
+// is required either way, so the origin is not checked.
+var wsUpgrader = websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+
+// wsHandler pushes the client's identity and echoes its messages until either side closes the connection.
+func (s *Server) wsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := ClientIdentityFromContext(r.Context())
+	if !ok {
+		http.Error(w, "client certificate required", http.StatusUnauthorized)
+		return
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logErrorf("Failed to upgrade WebSocket connection: %v", err)
+		return // Upgrade already replied with an error
+	}
+	defer conn.Close()
+	logAuth(levelInfo, "WebSocket opened", requestAttrs(r)...)
+
+	identity := &wsIdentity{
+		CN:            id.CN,
+		Fingerprint:   id.Fingerprint,
+		Organizations: id.Organizations,
+		NotAfter:      id.Certificate.NotAfter,
+		RemoteAddr:    r.RemoteAddr,
+		TLSVersion:    tlsVersionName(r.TLS.Version),
+		Resumed:       r.TLS.DidResume,
+	}
+	if err := writeWSMessage(conn, wsMessage{Type: wsMessageIdentity, Identity: identity}); err != nil {
+		return
+	}
+
+	messages := 0
+	for {
+		kind, data, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logWarnf("WebSocket from %s: read failed: %v", id.CN, err)
+			}
+			break
+		}
+		if !s.knownClientEntryValid(r.TLS.ServerName, id.Certificate) {
+			logAuth(levelError, "Closed WebSocket: known clients entry removed or expired", requestAttrs(r)...)
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "client no longer authorized"), time.Now().Add(wsWriteTimeout))
+			break
+		}
+		if kind != websocket.TextMessage {
+			continue
+		}
+		if err := writeWSMessage(conn, wsMessage{Type: wsMessageEcho, Data: string(data)}); err != nil {
+			break
+		}
+		messages++
+	}
+	logInfof("WebSocket from %s (%s) closed after %d messages", id.CN, r.RemoteAddr, messages)
+}
+
+// writeWSMessage sends msg as JSON, giving up after wsWriteTimeout.
+func writeWSMessage(conn *websocket.Conn, msg wsMessage) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(msg)
+}
+
+// knownClientEntryValid reports whether cert still matches a known clients entry within its validity window,
+// in the known clients of the tenant hosting serverName if any. It is always true in ca mode, which has no
+// known clients.
+func (s *Server) knownClientEntryValid(serverName string, cert *x509.Certificate) bool {
+	store := s.knownClientsFor(serverName)
+	if store == nil {
+		return true
+	}
+	entries, _ := store.Lookup(s.clientID(cert))
+	entry, ok := mtls.MatchKnownClient(entries, cert)
+	return ok && entry.Active(time.Now())
+}
+
+// wsURL returns the WebSocket URL of path on the server: ServerURL with a wss scheme.
+func (c *Client) wsURL(path string) (string, error) {
+	target, err := c.resolve(path)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	u.Scheme = "wss"
+	return u.String(), nil
+}
+
+// WebSocket opens a WebSocket to path over mTLS, prints the identity the server pushes, then sends each
+// line read from in and writes the echoed message to out. It returns once in is exhausted and every
+// line has been echoed, or when the server closes the connection.
+func (c *Client) WebSocket(path string, in io.Reader, out io.Writer) error {
+	conn, err := c.dialWebSocket(path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var hello wsMessage
+	if err := conn.ReadJSON(&hello); err != nil {
+		return fmt.Errorf("failed to read identity: %w", err)
+	}
+	if id := hello.Identity; hello.Type == wsMessageIdentity && id != nil {
+		fmt.Fprintf(out, "Connected as '%s' (fingerprint %s, certificate valid until %s, %s, resumed: %t)\n",
+			id.CN, id.Fingerprint, id.NotAfter.Format(time.RFC3339), id.TLSVersion, id.Resumed)
+	}
+
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if err := conn.WriteMessage(websocket.TextMessage, scanner.Bytes()); err != nil {
+			return fmt.Errorf("failed to send message: %w", err)
+		}
+		var reply wsMessage
+		if err := conn.ReadJSON(&reply); err != nil {
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				return fmt.Errorf("server closed the WebSocket: %s", closeErr.Text)
+			}
+			return fmt.Errorf("failed to read echo: %w", err)
+		}
+		fmt.Fprintln(out, reply.Data)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(wsWriteTimeout))
+	return nil
+}
+
+// dialWebSocket opens a WebSocket to path on the ServerURL host over mTLS.
+func (c *Client) dialWebSocket(path string) (*websocket.Conn, error) {
+	target, err := c.wsURL(path)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := c.tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{alpnHTTP11} // The upgrade needs HTTP/1.1
+	dialer := websocket.Dialer{TLSClientConfig: tlsConfig, HandshakeTimeout: echoHandshakeTimeout, NetDialContext: c.dialContext}
+	logInfof("Opening WebSocket to %s...", target)
+	conn, resp, err := dialer.Dial(target, nil)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("failed to open WebSocket to %s: server responded with %s", target, resp.Status)
+		}
+		return nil, fmt.Errorf("failed to open WebSocket to %s: %w", target, err)
+	}
+	return conn, nil
+}
+
+// ClientWSCmd sends stdin line by line over a WebSocket to the /ws endpoint of the server.
+type ClientWSCmd struct {
+	Path string `kong:"name='path',help='Path of the WebSocket endpoint on the --url server.',default='/ws'"`
+}
+
+// Run echoes stdin until EOF over one WebSocket.
+func (w *ClientWSCmd) Run(c *ClientCmd) error {
+	client, err := c.newClient()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(w.Path, "/") {
+		w.Path = "/" + w.Path
+	}
+	if err := client.WebSocket(w.Path, os.Stdin, os.Stdout); err != nil {
+		return fmt.Errorf("websocket failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestWebSocketEcho(t *testing.T) {
+	pki := newTestPKI(t)
+	_, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := client.WebSocket(wsPath, strings.NewReader("one\ntwo\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || lines[1] != "one" || lines[2] != "two" {
+		t.Fatalf("Expected the identity and two echoes, got %q", out.String())
+	}
+	if !strings.Contains(lines[0], "Connected as '"+pki.ClientCN+"'") {
+		t.Errorf("Expected the pushed identity, got %q", lines[0])
+	}
+}
+
+func TestWebSocketClosedWhenClientRemoved(t *testing.T) {
+	pki := newTestPKI(t)
+	server, baseURL := startTestServer(t, pki, nil)
+	client, err := NewClient(baseURL+"/hello", pki.ServerCertFile, pki.ClientCertFile, pki.ClientKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, send := io.Pipe()
+	out, received := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- client.WebSocket(wsPath, in, received) }()
+	replies := bufio.NewScanner(out)
+	replies.Scan() // Identity
+	send.Write([]byte("before\n"))
+	if !replies.Scan() || replies.Text() != "before" {
+		t.Fatalf("Expected an echo, got %q", replies.Text())
+	}
+
+	if err := ioutil.WriteFile(pki.KnownClientsFile, []byte("someone_else 00:11\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadKnownClients(); err != nil {
+		t.Fatal(err)
+	}
+	send.Write([]byte("after\n"))
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "client no longer authorized") {
+		t.Errorf("Expected the server to close the WebSocket of a removed client, got %v", err)
+	}
+}